@@ -0,0 +1,13 @@
+// Command chatlog-agent runs chatlog's connectors alone, forwarding their
+// records to a central chatlog (see hub and agent in config.yaml), so small
+// machines near chat servers can feed one recorder and uploader
+package main
+
+import "github.com/john/chatlog/internal/app"
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+func main() {
+	app.AgentMain(version)
+}
@@ -0,0 +1,10 @@
+package main
+
+import "github.com/john/chatlog/internal/app"
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+func main() {
+	app.Main(version)
+}
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Role is what a token may do on the admin API. Each role includes the
+// ones below it.
+type Role int
+
+const (
+	// RoleViewer reads stats and archive links
+	RoleViewer Role = iota + 1
+	// RoleOperator also triggers actions such as upload retries and annotations
+	RoleOperator
+	// RoleAdmin also changes what is recorded, such as channel config
+	RoleAdmin
+)
+
+// ParseRole parses "viewer", "operator" or "admin"
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(s) {
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return 0, fmt.Errorf("unknown role %q (want viewer, operator or admin)", s)
+}
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// Token is a named bearer token with a role
+type Token struct {
+	Name  string
+	Token string
+	Role  Role
+}
+
+// Auth checks bearer tokens on admin routes
+type Auth struct {
+	tokens []Token
+}
+
+// New creates an authenticator accepting tokens
+func New(tokens []Token) *Auth {
+	return &Auth{tokens: tokens}
+}
+
+// Protect wraps next so that requests need a bearer token of at least role.
+// routeToken, the legacy token of a single endpoint such as
+// annotations.token, is also accepted when set. A route no token could
+// authorize is refused outright rather than left open.
+func (a *Auth) Protect(role Role, routeToken string, next http.Handler) http.Handler {
+	if len(a.tokens) == 0 && routeToken == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "no admin token is configured; set admin.tokens", http.StatusForbidden)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || bearer == "" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+
+		name, granted, found := a.lookup(bearer)
+		if !found && routeToken != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(routeToken)) == 1 {
+			name, granted, found = "endpoint token", role, true
+		}
+		if !found {
+			log.Printf("Warning: Admin request %s %s from %s with an unknown token", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		if granted < role {
+			log.Printf("Warning: Admin request %s %s by %q denied: needs %s, has %s", r.Method, r.URL.Path, name, role, granted)
+			http.Error(w, fmt.Sprintf("%s role required", role), http.StatusForbidden)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			log.Printf("Admin request %s %s by %q", r.Method, r.URL.Path, name)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// lookup finds the token's name and role, comparing every token in
+// constant time
+func (a *Auth) lookup(bearer string) (name string, role Role, found bool) {
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(t.Token)) == 1 && !found {
+			name, role, found = t.Name, t.Role, true
+		}
+	}
+	return name, role, found
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// status returns the status code of a request to h with the bearer token,
+// if any
+func status(h http.Handler, method, token string) int {
+	req := httptest.NewRequest(method, "/admin/test", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestProtectFailsClosedWithoutTokens(t *testing.T) {
+	h := New(nil).Protect(RoleAdmin, "", ok)
+	for _, token := range []string{"", "anything"} {
+		if code := status(h, http.MethodPost, token); code != http.StatusForbidden {
+			t.Errorf("with token %q: status %d, want %d", token, code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestProtectRouteToken(t *testing.T) {
+	h := New(nil).Protect(RoleOperator, "route-secret", ok)
+	if code := status(h, http.MethodPost, "route-secret"); code != http.StatusOK {
+		t.Errorf("route token: status %d, want 200", code)
+	}
+	if code := status(h, http.MethodPost, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d, want 401", code)
+	}
+	if code := status(h, http.MethodPost, ""); code != http.StatusUnauthorized {
+		t.Errorf("no token: status %d, want 401", code)
+	}
+}
+
+func TestProtectRoles(t *testing.T) {
+	auth := New([]Token{
+		{Name: "dash", Token: "viewer-token", Role: RoleViewer},
+		{Name: "oncall", Token: "operator-token", Role: RoleOperator},
+		{Name: "root", Token: "admin-token", Role: RoleAdmin},
+	})
+
+	tests := []struct {
+		role  Role
+		token string
+		want  int
+	}{
+		{RoleViewer, "viewer-token", http.StatusOK},
+		{RoleOperator, "viewer-token", http.StatusForbidden},
+		{RoleOperator, "operator-token", http.StatusOK},
+		{RoleAdmin, "operator-token", http.StatusForbidden},
+		{RoleAdmin, "admin-token", http.StatusOK},
+		{RoleViewer, "admin-token", http.StatusOK},
+		{RoleViewer, "unknown", http.StatusUnauthorized},
+		{RoleViewer, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if code := status(auth.Protect(tt.role, "", ok), http.MethodGet, tt.token); code != tt.want {
+			t.Errorf("%s route with %q: status %d, want %d", tt.role, tt.token, code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+const (
+	// queueSize is how many alerts wait for delivery before new ones are dropped
+	queueSize = 1000
+
+	// sendAttempts is how many times an alert is posted before giving up
+	sendAttempts = 3
+)
+
+// Rule is a watch list of terms for some channels
+type Rule struct {
+	Name     string
+	Platform string   // Empty matches every platform
+	Channels []string // Empty matches every channel
+	Words    []string // Whole words or phrases, matched case-insensitively
+	Patterns []string // Regular expressions
+	Except   []string // Words or phrases that suppress a match, e.g. place names containing a term
+	Tag      bool     // Add the rule's name to the message's alerts field
+}
+
+// Alert is the webhook body sent for a matched message
+type Alert struct {
+	Rule      string `json:"rule"`
+	Match     string `json:"match"` // The text that matched
+	Platform  string `json:"platform"`
+	Channel   string `json:"channel"`
+	Timestamp string `json:"timestamp"`
+	MessageID string `json:"message_id,omitempty"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Message   string `json:"message"`
+}
+
+// compiledRule is a Rule with its terms compiled
+type compiledRule struct {
+	Rule
+	channels map[string]bool
+	words    *regexp.Regexp
+	patterns *regexp.Regexp
+	except   *regexp.Regexp
+}
+
+// Watcher checks chat messages against rules and posts an alert to a
+// webhook for each match. Alerts are sent in the background, so a slow
+// webhook never holds up recording.
+type Watcher struct {
+	rules  []compiledRule
+	url    string
+	secret string
+	client *http.Client
+
+	queue   chan Alert
+	sent    atomic.Int64
+	dropped atomic.Int64
+
+	status *status.Component
+	on     func() bool // Reports whether alerts are switched on; nil is always on
+}
+
+// New compiles rules into a watcher posting to url. If secret is set, the
+// body's HMAC-SHA256 is sent in the X-Chatlog-Signature header, as with
+// upload notifications.
+func New(rules []Rule, url, secret string) (*Watcher, error) {
+	w := &Watcher{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Alert, queueSize),
+	}
+	for _, rule := range rules {
+		compiled, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		w.rules = append(w.rules, compiled)
+	}
+	return w, nil
+}
+
+// compile builds a rule's matchers. Words match only at word boundaries,
+// which Unicode letters and digits count toward.
+func compile(rule Rule) (compiledRule, error) {
+	c := compiledRule{Rule: rule, channels: make(map[string]bool)}
+	for _, channel := range rule.Channels {
+		c.channels[strings.ToLower(channel)] = true
+	}
+
+	if len(rule.Words) == 0 && len(rule.Patterns) == 0 {
+		return c, fmt.Errorf("no words or patterns")
+	}
+	if len(rule.Words) > 0 {
+		c.words = regexp.MustCompile(wordsPattern(rule.Words))
+	}
+	if len(rule.Patterns) > 0 {
+		alternatives := make([]string, len(rule.Patterns))
+		for i, pattern := range rule.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return c, err
+			}
+			alternatives[i] = "(?:" + pattern + ")"
+		}
+		c.patterns = regexp.MustCompile(strings.Join(alternatives, "|"))
+	}
+	if len(rule.Except) > 0 {
+		c.except = regexp.MustCompile(wordsPattern(rule.Except))
+	}
+	return c, nil
+}
+
+// wordsPattern matches any of words as a whole word, ignoring case
+func wordsPattern(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(strings.TrimSpace(word))
+	}
+	return `(?i)(?:^|[^\pL\pN_])(` + strings.Join(quoted, "|") + `)(?:$|[^\pL\pN_])`
+}
+
+// EnableStatus reports sent and dropped alerts to comp. It must be called
+// before Start.
+func (w *Watcher) EnableStatus(comp *status.Component) {
+	w.status = comp
+}
+
+// EnableSwitch checks messages only while on reports true. It must be
+// called before Filter.
+func (w *Watcher) EnableSwitch(on func() bool) {
+	w.on = on
+}
+
+// Check returns the message with matching tagged rules added to its
+// alerts, queueing an alert for every matching rule
+func (w *Watcher) Check(msg message.Message) message.Message {
+	if msg.Type != "" {
+		return msg // Only chat is watched
+	}
+	if w.on != nil && !w.on() {
+		return msg
+	}
+	channel := strings.ToLower(msg.Channel)
+	for _, rule := range w.rules {
+		if rule.Platform != "" && rule.Platform != msg.Platform {
+			continue
+		}
+		if len(rule.channels) > 0 && !rule.channels[channel] {
+			continue
+		}
+		match, ok := rule.find(msg.Message)
+		if !ok {
+			continue
+		}
+		if rule.except != nil && rule.except.MatchString(msg.Message) {
+			continue
+		}
+
+		if rule.Tag {
+			msg.Alerts = append(msg.Alerts, rule.Name)
+		}
+		w.enqueue(Alert{
+			Rule:      rule.Name,
+			Match:     match,
+			Platform:  msg.Platform,
+			Channel:   msg.Channel,
+			Timestamp: msg.Timestamp,
+			MessageID: msg.ID,
+			UserID:    msg.UserID,
+			Username:  msg.Username,
+			Message:   msg.Message,
+		})
+	}
+	return msg
+}
+
+// find returns the first watched word or pattern match in text
+func (c compiledRule) find(text string) (string, bool) {
+	if c.words != nil {
+		// The word is the first group, without its boundary characters
+		if match := c.words.FindStringSubmatch(text); match != nil {
+			return match[1], true
+		}
+	}
+	if c.patterns != nil {
+		if loc := c.patterns.FindStringIndex(text); loc != nil {
+			return text[loc[0]:loc[1]], true
+		}
+	}
+	return "", false
+}
+
+// enqueue queues an alert for delivery, dropping it if the queue is full
+func (w *Watcher) enqueue(a Alert) {
+	select {
+	case w.queue <- a:
+	default:
+		w.dropped.Add(1)
+		w.status.Set("dropped", w.dropped.Load())
+		log.Printf("Warning: Alert queue full, dropped %q alert for %s/%s", a.Rule, a.Platform, a.Channel)
+	}
+}
+
+// Filter forwards messages from in to out, checking each, until the
+// context is cancelled or in is closed, which closes out
+func (w *Watcher) Filter(ctx context.Context, in <-chan message.Message, out chan<- message.Message) error {
+	for {
+		select {
+		case msg, open := <-in:
+			if !open {
+				close(out)
+				return nil
+			}
+			select {
+			case out <- w.Check(msg):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Start delivers queued alerts until the context is cancelled
+func (w *Watcher) Start(ctx context.Context) error {
+	w.status.SetState(status.StateRunning)
+	for {
+		select {
+		case a := <-w.queue:
+			w.deliver(ctx, a)
+		case <-ctx.Done():
+			if n := len(w.queue); n > 0 {
+				log.Printf("Warning: %d alert(s) were not sent before shutdown", n)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// deliver posts an alert, retrying with backoff
+func (w *Watcher) deliver(ctx context.Context, a Alert) {
+	var err error
+	for attempt := 1; attempt <= sendAttempts; attempt++ {
+		if err = w.post(ctx, a); err == nil {
+			w.sent.Add(1)
+			w.status.Set("sent", w.sent.Load())
+			return
+		}
+		if attempt < sendAttempts {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	log.Printf("Error: Failed to send %q alert for %s/%s: %v", a.Rule, a.Platform, a.Channel, err)
+	w.status.Set("last_error", err.Error())
+}
+
+// post sends one alert to the webhook
+func (w *Watcher) post(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set("X-Chatlog-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
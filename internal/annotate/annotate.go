@@ -0,0 +1,249 @@
+package annotate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/recorder"
+)
+
+// Ext is the extension of annotation sidecar files. They sit next to the
+// chat files they annotate but are never merged into them.
+const Ext = ".annotations.jsonl"
+
+// Type is the record type of annotations
+const Type = "annotation"
+
+// Annotation is moderator context attached to a recorded message.
+// Platform, Channel and Timestamp are those of the annotated message, so
+// the sidecar is filed in the same partition.
+type Annotation struct {
+	Type      string   `json:"type"`
+	Platform  string   `json:"platform"`
+	Channel   string   `json:"channel"`
+	Timestamp string   `json:"timestamp"`
+	MessageID string   `json:"message_id"`
+	Labels    []string `json:"labels,omitempty"` // e.g. "reported"
+	CaseID    string   `json:"case_id,omitempty"`
+	Note      string   `json:"note,omitempty"`
+	Author    string   `json:"author,omitempty"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// sidecar is an open annotation file for one channel and day
+type sidecar struct {
+	file      *os.File
+	info      recorder.FileInfo
+	createdAt time.Time
+}
+
+// Store appends annotations to sidecar JSONL files, one per channel and
+// day of the annotated messages, and hands each to the uploader a while
+// after it is started
+type Store struct {
+	dir      string
+	holdFor  time.Duration
+	sidecars map[string]*sidecar // key: platform_channel_YYYYMMDD
+	stopped  bool
+	mu       sync.Mutex
+}
+
+// New creates a store writing sidecars to dir, normally the recorder's
+// output directory. Sidecars are closed and uploaded holdFor after their
+// first annotation.
+func New(dir string, holdFor time.Duration) *Store {
+	return &Store{
+		dir:      dir,
+		holdFor:  holdFor,
+		sidecars: make(map[string]*sidecar),
+	}
+}
+
+// normalize checks an annotation's fields and fills in the rest,
+// returning the annotated message's time
+func (a *Annotation) normalize() (time.Time, error) {
+	if a.Platform == "" || a.Channel == "" || a.MessageID == "" {
+		return time.Time{}, fmt.Errorf("platform, channel and message_id are required")
+	}
+	if strings.ContainsAny(a.Platform+a.Channel, `/\`) {
+		return time.Time{}, fmt.Errorf("invalid platform or channel")
+	}
+	ts, err := time.Parse(time.RFC3339Nano, a.Timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamp must be the message's RFC3339 timestamp")
+	}
+	if len(a.Labels) == 0 && a.CaseID == "" && a.Note == "" {
+		return time.Time{}, fmt.Errorf("at least one of labels, case_id or note is required")
+	}
+	ts = ts.UTC()
+	a.Type = Type
+	a.Channel = strings.ToLower(a.Channel)
+	a.Timestamp = message.FormatTime(ts)
+	if a.CreatedAt == "" {
+		a.CreatedAt = message.FormatTime(time.Now())
+	}
+	return ts, nil
+}
+
+// Add validates an annotation and appends it to its sidecar
+func (s *Store) Add(a Annotation) error {
+	ts, err := a.normalize()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return fmt.Errorf("shutting down")
+	}
+
+	key := fmt.Sprintf("%s_%s_%s", a.Platform, a.Channel, ts.Format("20060102"))
+	sc := s.sidecars[key]
+	if sc == nil {
+		if sc, err = s.create(a.Platform, a.Channel, ts); err != nil {
+			return err
+		}
+		s.sidecars[key] = sc
+	}
+
+	// Each annotation is written straight through, so none wait in memory
+	if _, err := sc.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write annotation: %w", err)
+	}
+	sc.info.MessageCount++
+	sc.info.Bytes += int64(len(data) + 1)
+	return nil
+}
+
+// create opens a new sidecar, starting its metadata at the annotated
+// message's time so it is uploaded under that message's partition
+func (s *Store) create(platform, channel string, messageTime time.Time) (*sidecar, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("create annotation directory: %w", err)
+	}
+
+	now := time.Now().UTC()
+	stamp := now.Format("20060102_150405")
+	var file *os.File
+	var path string
+	var err error
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s_%s_%s%s", platform, channel, stamp, Ext)
+		if i > 1 {
+			name = fmt.Sprintf("%s_%s_%s-%d%s", platform, channel, stamp, i, Ext)
+		}
+		path = filepath.Join(s.dir, name)
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create annotation file: %w", err)
+	}
+
+	log.Printf("Created new annotation file: %s", filepath.Base(path))
+	return &sidecar{
+		file: file,
+		info: recorder.FileInfo{
+			Path:      path,
+			Platform:  platform,
+			Channel:   channel,
+			StartTime: messageTime,
+		},
+		createdAt: now,
+	}, nil
+}
+
+// Start hands sidecars to the uploader once they have been open for the
+// hold time. When done is closed, or the context is cancelled, every
+// sidecar is closed and handed over and no more annotations are accepted.
+func (s *Store) Start(ctx context.Context, done <-chan struct{}, fileChan chan<- recorder.FileInfo) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.closeSidecars(fileChan, false)
+		case <-done:
+			s.closeSidecars(fileChan, true)
+			return nil
+		case <-ctx.Done():
+			s.closeSidecars(fileChan, true)
+			return ctx.Err()
+		}
+	}
+}
+
+// closeSidecars closes sidecars past the hold time, or all of them when
+// stopping, and queues them for upload
+func (s *Store) closeSidecars(fileChan chan<- recorder.FileInfo, stop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = s.stopped || stop
+
+	for key, sc := range s.sidecars {
+		if !stop && time.Since(sc.createdAt) < s.holdFor {
+			continue
+		}
+		if err := sc.file.Close(); err != nil {
+			log.Printf("Error closing annotation file: %v", err)
+		}
+		delete(s.sidecars, key)
+
+		sc.info.EndTime = time.Now().UTC()
+		if stop {
+			fileChan <- sc.info
+			continue
+		}
+		select {
+		case fileChan <- sc.info:
+		default:
+			log.Printf("Warning: upload queue full, file will be uploaded later: %s", sc.info.Filename())
+		}
+	}
+}
+
+// Handler serves POST requests with an Annotation as the JSON body. The
+// caller is responsible for authenticating requests.
+func (s *Store) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var a Annotation
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&a); err != nil {
+			http.Error(w, fmt.Sprintf("invalid annotation: %v", err), http.StatusBadRequest)
+			return
+		}
+		if _, err := a.normalize(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Add(a); err != nil {
+			log.Printf("Error storing annotation: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/forward"
+	"github.com/john/chatlog/internal/health"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+// AgentMain runs chatlog-agent: the configured connectors, forwarding their
+// records to the hub at agent.hub_url instead of recording them. It returns
+// when the agent has shut down.
+func AgentMain(buildVersion string) {
+	version = buildVersion
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		runValidateConfig(os.Args[2:])
+		return
+	}
+
+	log.Printf("Chatlog agent %s starting...", version)
+
+	cfg, configETag := loadConfigSource()
+	loadedCfg := *cfg
+	if cfg.Agent.HubURL == "" {
+		log.Fatalf("agent.hub_url is required to run the agent")
+	}
+	logPlatforms(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	validateTwitchChannels(ctx, cfg)
+	conns := newConnectors(cfg, nil)
+
+	messageChan := make(chan message.Message, cfg.Recorder.BufferSize)
+	forwardChan := make(chan message.Message, cfg.Recorder.BufferSize)
+	ingestDone := make(chan struct{})
+
+	client := forward.NewClient(cfg.Agent.HubURL, cfg.Agent.Token, cfg.Agent.BatchSize,
+		time.Duration(cfg.Agent.FlushMs)*time.Millisecond)
+
+	statusRegistry := status.New(version)
+	conns.enableStatus(statusRegistry, cfg)
+	client.EnableStatus(statusRegistry.Component("forward"))
+	healthServer := health.New(":8080", statusRegistry)
+
+	// Start all components. Shutdown stops connectors, then waits for the
+	// hub to take what they buffered.
+	ingestCtx, stopIngest := context.WithCancel(ctx)
+	defer stopIngest()
+	var ingestWG, forwardWG, serviceWG sync.WaitGroup
+
+	conns.start(ingestCtx, &ingestWG, cfg, messageChan)
+
+	// Poll a remote config for changes (if loaded from a URL)
+	if remoteConfig(configPath()) {
+		interval := 60 * time.Second
+		if seconds, err := strconv.Atoi(os.Getenv("CONFIG_POLL_SECONDS")); err == nil && seconds > 0 {
+			interval = time.Duration(seconds) * time.Second
+		}
+		ingestWG.Add(1)
+		go func() {
+			defer ingestWG.Done()
+			watchConfig(ingestCtx, configPath(), configETag, interval, &loadedCfg, conns.twitch)
+		}()
+	}
+
+	forwardWG.Add(2)
+	go func() {
+		defer forwardWG.Done()
+		forwardUntil(messageChan, forwardChan, ingestDone)
+	}()
+	go func() {
+		defer forwardWG.Done()
+		if err := client.Start(ctx, forwardChan); err != nil && err != context.Canceled {
+			log.Printf("Forwarding error: %v", err)
+		}
+	}()
+
+	serviceWG.Add(1)
+	go func() {
+		defer serviceWG.Done()
+		if err := healthServer.Start(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Health server error: %v", err)
+		}
+	}()
+
+	log.Printf("Forwarding records to %s", cfg.Agent.HubURL)
+
+	<-sigChan
+	log.Println("Shutdown signal received, initiating graceful shutdown...")
+	progress := newShutdownProgress(cfg, statusRegistry.Component("shutdown"))
+	progress.watch("records_queued", func() int64 { return int64(len(messageChan) + len(forwardChan)) })
+
+	stopIngest()
+	if progress.wait("stopping connectors", &ingestWG) {
+		close(ingestDone)
+		if progress.wait("forwarding buffered records", &forwardWG) {
+			progress.finish()
+		}
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := healthServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down health server: %v", err)
+	}
+	cancel()
+	serviceWG.Wait()
+	log.Println("Chatlog agent stopped")
+}
@@ -0,0 +1,1407 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/john/chatlog/internal/admin"
+	"github.com/john/chatlog/internal/alert"
+	"github.com/john/chatlog/internal/annotate"
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/enrich"
+	"github.com/john/chatlog/internal/features"
+	"github.com/john/chatlog/internal/firstseen"
+	"github.com/john/chatlog/internal/forward"
+	"github.com/john/chatlog/internal/health"
+	"github.com/john/chatlog/internal/highlight"
+	"github.com/john/chatlog/internal/ingest"
+	"github.com/john/chatlog/internal/instance"
+	"github.com/john/chatlog/internal/irc"
+	"github.com/john/chatlog/internal/leader"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/notify"
+	"github.com/john/chatlog/internal/optout"
+	"github.com/john/chatlog/internal/overflow"
+	"github.com/john/chatlog/internal/pause"
+	"github.com/john/chatlog/internal/presign"
+	"github.com/john/chatlog/internal/recorder"
+	"github.com/john/chatlog/internal/retry"
+	"github.com/john/chatlog/internal/sink"
+	"github.com/john/chatlog/internal/stats"
+	"github.com/john/chatlog/internal/status"
+	"github.com/john/chatlog/internal/tenant"
+	"github.com/john/chatlog/internal/twitch"
+	"github.com/john/chatlog/internal/uploader"
+	"github.com/john/chatlog/internal/volume"
+)
+
+// version is the build's version, reported in logs and on /health
+var version = "dev"
+
+// Main runs the chatlog command line: the recording pipeline, or the
+// subcommand named by the first argument. It returns when the pipeline has
+// shut down.
+func Main(buildVersion string) {
+	version = buildVersion
+
+	// Dispatch subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "compact":
+			runCompact(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "search":
+			runSearch(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "backfill":
+			runBackfill(os.Args[2:])
+			return
+		case "retry-failed":
+			runRetryFailed(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "presign":
+			runPresign(os.Args[2:])
+			return
+		case "serve-archive":
+			runServeArchive(os.Args[2:])
+			return
+		case "search-server":
+			runSearchServer(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "validate-config":
+			runValidateConfig(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		}
+	}
+
+	log.Printf("Chatlog %s starting...", version)
+
+	cfg, configETag := loadConfigSource()
+	loadedCfg := *cfg // As loaded, before channel validation, to compare reloads against
+
+	logPlatforms(cfg)
+
+	// Setup context and signal handling
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	// Guard against another instance recording the same channels
+	instanceInfo := instance.NewInfo(cmp.Or(cfg.Leader.InstanceID, leader.InstanceID()), version,
+		cfg.Recorder.OutputDir, configuredChannels(cfg))
+	lockFile, err := instance.Lock(cfg.Recorder.OutputDir, instanceInfo)
+	switch {
+	case errors.Is(err, instance.ErrInUse):
+		onInstanceConflict(cfg, "Output directory %v", err)
+	case err != nil:
+		log.Printf("Warning: Failed to lock the output directory: %v", err)
+	}
+	defer lockFile.Unlock()
+	var heartbeat *instance.Heartbeat
+	if cfg.Instance.Heartbeat.Enabled && !cfg.Leader.Enabled {
+		heartbeat = newHeartbeat(ctx, cfg, instanceInfo)
+		conflicts, err := heartbeat.Check(ctx)
+		if err != nil {
+			log.Printf("Warning: Failed to check for duplicate instances: %v", err)
+		}
+		for _, c := range conflicts {
+			onInstanceConflict(cfg, "Instance %s is already recording %s to s3://%s",
+				c.Instance, instance.FormatChannels(c.Channels), cfg.S3.Bucket)
+		}
+	}
+
+	// Create communication channels
+	messageChan := make(chan message.Message, cfg.Recorder.BufferSize)
+	// pipelineChan carries messageChan's messages to the rest of the
+	// pipeline and is closed once connectors have stopped, so each stage
+	// drains and closes its output in turn during shutdown
+	pipelineChan := make(chan message.Message, cfg.Recorder.BufferSize)
+	ingestDone := make(chan struct{})
+	fileChan := make(chan recorder.FileInfo, 100)
+
+	validateTwitchChannels(ctx, cfg)
+
+	// Channels paused through the admin API stay paused across restarts;
+	// those paused by parting aren't joined
+	var pauses *pause.Gate
+	if cfg.Pauses.Enabled {
+		pauses, err = pause.New(cfg.Pauses.StateFile)
+		if err != nil {
+			log.Fatalf("Failed to load paused channels: %v", err)
+		}
+	}
+
+	// Optional components switched off through the admin API stay off
+	// across restarts
+	var switches *features.Set
+	if cfg.Features.Enabled {
+		switches, err = features.New(cfg.Features.StateFile)
+		if err != nil {
+			log.Fatalf("Failed to load feature switches: %v", err)
+		}
+	}
+
+	// Initialize platform connectors
+	conns := newConnectors(cfg, pauses)
+
+	// In hot-standby deployments, only the lease holder records
+	ingestChan := pipelineChan
+	var elector *leader.Elector
+	var electorIn, electorChan chan message.Message
+	if cfg.Leader.Enabled {
+		elector = leader.New(newLeaderLock(ctx, cfg), cmp.Or(cfg.Leader.InstanceID, leader.InstanceID()),
+			time.Duration(cfg.Leader.LeaseSeconds)*time.Second)
+		electorIn = ingestChan
+		electorChan = make(chan message.Message, cfg.Recorder.BufferSize)
+		ingestChan = electorChan
+	}
+
+	// Drop paused channels before anything, even the overflow queue, keeps
+	// their messages
+	var pauseIn, pauseChan chan message.Message
+	if pauses != nil {
+		pauseIn = ingestChan
+		pauseChan = make(chan message.Message, cfg.Recorder.BufferSize)
+		ingestChan = pauseChan
+	}
+
+	// Drop or anonymize opted-out users before anything records them, even
+	// the overflow queue on disk
+	var optOuts *optout.List
+	var optOutIn, optOutChan chan message.Message
+	if cfg.OptOut.Source != "" {
+		optOuts = optout.New(cfg.OptOut.Source, cfg.OptOut.Action, time.Duration(cfg.OptOut.ReloadMinutes)*time.Minute)
+		if err := optOuts.Load(ctx); err != nil {
+			log.Fatalf("Failed to load opt-out list: %v", err)
+		}
+		optOutIn = ingestChan
+		optOutChan = make(chan message.Message, cfg.Recorder.BufferSize)
+		ingestChan = optOutChan
+	}
+
+	// Spill to disk rather than block connectors when the pipeline stalls
+	var overflowQueue *overflow.Queue
+	var overflowIn, overflowChan chan message.Message
+	if cfg.Recorder.Overflow.Enabled {
+		overflowQueue = overflow.New(cfg.Recorder.Overflow.Dir, cfg.Recorder.Overflow.MaxMegabytes)
+		if keys := cfg.Recorder.Encryption.Recipients; len(keys) > 0 {
+			recipients, err := recorder.ParseRecipients(keys)
+			if err != nil {
+				log.Fatalf("Invalid recorder.encryption: %v", err)
+			}
+			overflowQueue.EnableEncryption(recipients)
+		}
+		if err := overflowQueue.Open(); err != nil {
+			log.Fatalf("Failed to open overflow queue: %v", err)
+		}
+		overflowIn = ingestChan
+		overflowChan = make(chan message.Message, cfg.Recorder.BufferSize)
+		ingestChan = overflowChan
+	}
+
+	// Alert on watched keywords before sampling, so every message is checked
+	var watcher *alert.Watcher
+	var watcherIn, watcherChan chan message.Message
+	if cfg.Alerts.Enabled {
+		rules := make([]alert.Rule, len(cfg.Alerts.Rules))
+		for i, r := range cfg.Alerts.Rules {
+			rules[i] = alert.Rule{
+				Name:     r.Name,
+				Platform: r.Platform,
+				Channels: r.Channels,
+				Words:    r.Words,
+				Patterns: r.Patterns,
+				Except:   r.Except,
+				Tag:      r.Tag,
+			}
+		}
+		var err error
+		watcher, err = alert.New(rules, cfg.Alerts.WebhookURL, cfg.Alerts.WebhookSecret)
+		if err != nil {
+			log.Fatalf("Failed to set up alerts: %v", err)
+		}
+		watcher.EnableSwitch(switches.Register("alerts"))
+		log.Printf("Keyword alerts enabled: %d rule(s)", len(rules))
+		watcherIn = ingestChan
+		watcherChan = make(chan message.Message, cfg.Recorder.BufferSize)
+		ingestChan = watcherChan
+	}
+
+	// Detect chat spikes before sampling, so rates count every message
+	var detector *highlight.Detector
+	var detectorIn, detectorChan chan message.Message
+	if h := cfg.Highlights; h.Enabled {
+		detector = highlight.New(time.Duration(h.WindowSeconds)*time.Second, time.Duration(h.BaselineMinutes)*time.Minute,
+			h.ZScore, h.MinRate, time.Duration(h.CooldownSeconds)*time.Second, h.TopTerms)
+		if h.WebhookURL != "" {
+			detector.EnableWebhook(h.WebhookURL, h.WebhookSecret)
+		}
+		detector.EnableSwitch(switches.Register("highlights"))
+		log.Printf("Highlight detection enabled: z-score %.1f over %ds against a %d minute baseline", h.ZScore, h.WindowSeconds, h.BaselineMinutes)
+		detectorIn = ingestChan
+		detectorChan = make(chan message.Message, cfg.Recorder.BufferSize)
+		ingestChan = detectorChan
+	}
+
+	// Sample or aggregate channels that exceed their high-volume threshold
+	var limiter *volume.Limiter
+	var limiterIn, limiterChan chan message.Message
+	if len(cfg.HighVolume.Rules) > 0 {
+		rules := make([]volume.Rule, len(cfg.HighVolume.Rules))
+		for i, r := range cfg.HighVolume.Rules {
+			rules[i] = volume.Rule{
+				Platform:   r.Platform,
+				Channel:    r.Channel,
+				Threshold:  r.Threshold,
+				Mode:       r.Mode,
+				SampleRate: r.SampleRate,
+			}
+		}
+		limiter = volume.New(rules)
+		limiter.EnableSwitch(switches.Register("high_volume"))
+		limiterIn = ingestChan
+		limiterChan = make(chan message.Message, cfg.Recorder.BufferSize)
+		ingestChan = limiterChan
+	}
+
+	// Add fields to chat messages with the configured processors
+	var enrichment *enrich.Pipeline
+	var enrichIn, enrichChan chan message.Message
+	var userCache *twitch.UserCache
+	if cfg.Twitch.UserInfo.Enabled && !cfg.Twitch.Anonymous() {
+		u := cfg.Twitch.UserInfo
+		userCache = twitch.NewUserCache(newHelixClient(cfg, cfg.Twitch.OAuth),
+			time.Duration(u.CacheHours)*time.Hour, u.MaxUsers, u.Followers)
+	}
+	if len(cfg.Enrich.Processors) > 0 || userCache != nil {
+		var err error
+		enrichment, err = newEnrichment(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up enrichment: %v", err)
+		}
+		if userCache != nil {
+			enrichment.Add("twitch_user_info", userCache)
+		}
+		if switches != nil {
+			enrichment.EnableSwitches(func(name string) func() bool {
+				return switches.Register("enrich/" + name)
+			})
+		}
+		log.Printf("Enriching messages with: %s", strings.Join(enrichment.Names(), ", "))
+		enrichIn = ingestChan
+		enrichChan = make(chan message.Message, cfg.Recorder.BufferSize)
+		ingestChan = enrichChan
+	}
+
+	// Optional sinks receive a copy of every message alongside the recorder
+	recorderChan := make(chan message.Message, cfg.Recorder.BufferSize)
+	fanout := sink.NewFanout(recorderChan, cfg.Sinks.BufferSize)
+	if r := cfg.Sinks.Redis; r.Enabled {
+		log.Printf("Redis sink enabled: %s (streams %s:<platform>:<channel>)", r.Addr, r.KeyPrefix)
+		fanout.AddFiltered("redis", sink.NewRedisSink(r.Addr, r.Password, r.DB, r.KeyPrefix, r.MaxLen, !r.ExactTrim), sinkFilter(cfg, switches, "redis"))
+	}
+	if p := cfg.Sinks.Postgres; p.Enabled {
+		log.Printf("Postgres sink enabled: table %s, keeping %d day(s)", p.Table, p.RetentionDays)
+		fanout.AddFiltered("postgres", sink.NewPostgresSink(p.DSN, p.Table, p.RetentionDays, p.BatchSize, p.Timescale), sinkFilter(cfg, switches, "postgres"))
+	}
+	var overlay *sink.OverlaySink
+	if cfg.Sinks.Overlay.Enabled {
+		log.Println("Overlay relay enabled at /overlay/ws on the health port")
+		overlay = sink.NewOverlaySink(cfg.Sinks.Overlay.Token)
+		fanout.AddFiltered("overlay", overlay, sinkFilter(cfg, switches, "overlay"))
+	}
+	for _, c := range cfg.Sinks.Custom {
+		s, err := sink.New(c.Name, c.Options)
+		if err != nil {
+			log.Fatalf("Failed to create sink: %v", err)
+		}
+		log.Printf("Custom sink enabled: %s", c.Name)
+		fanout.AddFiltered(c.Name, s, sinkFilter(cfg, switches, c.Name))
+	}
+	if fanout.Len() == 0 {
+		// No sinks, so messages go to the recorder directly
+		recorderChan = ingestChan
+	}
+
+	rec := newRecorder(cfg)
+
+	uploaderInstance, err := newUploader(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create uploader: %v", err)
+	}
+	uploaderInstance.EnableDeadLetter(cfg.Uploader.DeadLetterDir)
+	enableDeadLetterRetry(uploaderInstance, cfg)
+	if cfg.Uploader.Mode != config.UploadModeNone && !cfg.Uploader.DeleteAfterUpload {
+		if err := uploaderInstance.EnableUploadIndex(cfg.Uploader.UploadIndex); err != nil {
+			log.Printf("Warning: Startup scans will check every kept file against the bucket: %v", err)
+		}
+	}
+	var latency *stats.Latency
+	if cfg.Uploader.Mode != config.UploadModeNone {
+		latency = stats.NewLatency()
+		uploaderInstance.EnableLatency(latency)
+	}
+	if cfg.Recorder.Layout == config.RecorderLayoutNested {
+		uploaderInstance.EnableDirPruning(cfg.Recorder.OutputDir)
+	}
+	if sched := cfg.Uploader.Schedule; len(sched.Windows) > 0 {
+		uploaderInstance.EnableSchedule(newUploadSchedule(sched))
+	}
+
+	// Report per-component state on /health
+	statusRegistry := status.New(version)
+	conns.enableStatus(statusRegistry, cfg)
+	if watcher != nil {
+		watcher.EnableStatus(statusRegistry.Component("alerts"))
+	}
+	if detector != nil {
+		detector.EnableStatus(statusRegistry.Component("highlights"))
+	}
+	if pauses != nil {
+		pauses.EnableStatus(statusRegistry.Component("pauses"))
+	}
+	if switches != nil {
+		switches.EnableStatus(statusRegistry.Component("features"))
+	}
+	if elector != nil {
+		elector.EnableStatus(statusRegistry.Component("leader"))
+	}
+	rec.EnableStatus(statusRegistry.Component("recorder"))
+	uploaderInstance.EnableStatus(statusRegistry.Component("uploader"))
+
+	// Finalize files a crash left open, and reopen those a clean shutdown
+	// left for appending
+	if err := recorder.Recover(cfg.Recorder.OutputDir); err != nil {
+		log.Printf("Warning: Failed to recover files from the previous run: %v", err)
+	}
+	var resumed []string
+	if cfg.Recorder.AppendAfterRestart {
+		rec.EnableAppend()
+		if resumed, err = rec.Resume(); err != nil {
+			log.Printf("Warning: Failed to resume files from the previous run: %v", err)
+		}
+	}
+
+	// Scan for existing files and queue them for upload
+	if cfg.Uploader.Mode != config.UploadModeNone {
+		scan := uploader.ScanOptions{
+			Patterns:  cfg.Uploader.Scan.Patterns,
+			Ignore:    cfg.Uploader.Scan.Ignore,
+			SkipDirs:  []string{cfg.Uploader.DeadLetterDir, cfg.Recorder.Overflow.Dir, cfg.Recorder.Summaries.StateDir},
+			SkipFiles: resumed,
+		}
+		if cfg.Uploader.Mode == config.UploadModeLocal {
+			scan.SkipDirs = append(scan.SkipDirs, cfg.Uploader.LocalDir)
+		}
+		for _, t := range cfg.Tenants {
+			scan.SkipDirs = append(scan.SkipDirs, t.OutputDir)
+		}
+		if err := uploaderInstance.ScanAndUploadExisting(ctx, cfg.Recorder.OutputDir, scan); err != nil {
+			log.Printf("Warning: Failed to scan for existing files: %v", err)
+		}
+	}
+
+	// Track message rates and flag live channels that go silent
+	silentAfter := time.Duration(max(cfg.Stats.SilentMinutes, 0)) * time.Minute
+	statsRegistry := stats.New(silentAfter)
+	recorderStats := statsRegistry
+	if limiter != nil {
+		// Count messages before they are sampled or aggregated, so rates and
+		// silence reflect the chat received rather than what was recorded
+		limiter.EnableObserver(statsRegistry.Observe)
+		recorderStats = nil
+	} else {
+		rec.EnableStats(statsRegistry)
+	}
+
+	// Tenants get their own recorders and uploaders behind the shared
+	// connectors
+	var tenants *tenant.Supervisor
+	if len(cfg.Tenants) > 0 {
+		tenants = newTenants(ctx, cfg, statusRegistry, recorderStats)
+	}
+
+	// Poll Helix for live status (silent detection) and stream snapshots
+	var liveMonitor *twitch.LiveMonitor
+	if conns.twitch != nil && !cfg.Twitch.Anonymous() && cfg.Twitch.ClientID != "" && (silentAfter > 0 || cfg.Twitch.StreamSnapshots) {
+		liveMonitor = twitch.NewLiveMonitor(
+			newHelixClient(cfg, cfg.Twitch.OAuth),
+			conns.twitch,
+			time.Duration(cfg.Stats.LiveCheckMinutes)*time.Minute,
+			func(live map[string]bool) { statsRegistry.SetLive("twitch", live) },
+		)
+		if cfg.Twitch.StreamSnapshots {
+			liveMonitor.EnableSnapshots(messageChan)
+		}
+	}
+
+	// Record who is in Twitch chat, lurkers included
+	var chattersMonitor *twitch.ChattersMonitor
+	if c := cfg.Twitch.Chatters; conns.twitch != nil && c.Enabled && !cfg.Twitch.Anonymous() {
+		log.Printf("Recording Twitch chatters every %d minutes", c.IntervalMinutes)
+		chattersMonitor = twitch.NewChattersMonitor(
+			newHelixClient(cfg, cfg.Twitch.OAuth),
+			conns.twitch,
+			time.Duration(c.IntervalMinutes)*time.Minute,
+			c.MaxUsers,
+			c.Channels,
+			messageChan,
+		)
+	}
+
+	healthServer := health.New(":8080", statusRegistry)
+	healthServer.AddCheck("recorder", rec.Status)
+	healthServer.AddMetrics(statsRegistry.WriteMetrics)
+	healthServer.AddMetrics(rec.WriteMetrics)
+	if latency != nil {
+		healthServer.AddMetrics(latency.WriteMetrics)
+	}
+	if tenants != nil {
+		healthServer.AddMetrics(tenants.WriteMetrics)
+	}
+	adminAuth := newAdminAuth(cfg.Admin)
+	healthServer.Handle("/admin/stats", adminAuth.Protect(admin.RoleViewer, "", statsRegistry))
+	healthServer.Handle("/admin/retry-failed", adminAuth.Protect(admin.RoleOperator, "", uploaderInstance.RetryHandler()))
+	if overlay != nil {
+		healthServer.Handle("/overlay/ws", overlay)
+	}
+	var annotations *annotate.Store
+	if cfg.Annotations.Enabled {
+		annotations = annotate.New(cfg.Recorder.OutputDir, time.Duration(cfg.Annotations.HoldMinutes)*time.Minute)
+		healthServer.Handle("/admin/annotations", adminAuth.Protect(admin.RoleOperator, cfg.Annotations.Token, annotations.Handler()))
+	}
+	if pauses != nil {
+		healthServer.Handle("/admin/pauses", adminAuth.Protect(admin.RoleAdmin, "", pauses.Handler()))
+	}
+	if switches != nil {
+		healthServer.Handle("/admin/features", adminAuth.Protect(admin.RoleAdmin, "", switches.Handler()))
+	}
+	if cfg.S3.Presign.Enabled {
+		signer, err := newSigner(ctx, cfg, time.Duration(cfg.S3.Presign.MaxExpiryHours)*time.Hour)
+		if err != nil {
+			log.Fatalf("Failed to create S3 client for presigned URLs: %v", err)
+		}
+		healthServer.Handle("/admin/archive-urls", adminAuth.Protect(admin.RoleViewer, cfg.S3.Presign.Token, signer.Handler()))
+	}
+
+	// Start all components. Shutdown runs in phases: connectors stop first,
+	// then the pipeline drains into the recorder, then pending uploads finish.
+	ingestCtx, stopIngest := context.WithCancel(ctx)
+	defer stopIngest()
+	var ingestWG, pipelineWG, uploadWG, serviceWG sync.WaitGroup
+
+	// Accept records from chatlog-agent processes (if configured). They
+	// join connector output, and are refused once connectors stop.
+	if cfg.Hub.Enabled {
+		log.Println("Accepting records from agents at /agents/ingest on the health port")
+		receiver := forward.NewReceiver(ingestCtx, messageChan)
+		healthServer.Handle("/agents/ingest", adminAuth.Protect(admin.RoleOperator, cfg.Hub.Token, receiver))
+		healthServer.AddMetrics(receiver.WriteMetrics)
+	}
+
+	// Accept records pushed by external scrapers (if configured)
+	if cfg.Ingest.Enabled {
+		log.Println("Accepting records at /ingest on the health port")
+		handler := ingest.NewHandler(ingestCtx, messageChan)
+		healthServer.Handle("/ingest", adminAuth.Protect(admin.RoleOperator, cfg.Ingest.Token, handler))
+		healthServer.AddMetrics(handler.WriteMetrics)
+	}
+
+	// Start platform connectors
+	conns.start(ingestCtx, &ingestWG, cfg, messageChan)
+
+	// Poll a remote config for changes (if loaded from a URL)
+	if remoteConfig(configPath()) {
+		interval := 60 * time.Second
+		if seconds, err := strconv.Atoi(os.Getenv("CONFIG_POLL_SECONDS")); err == nil && seconds > 0 {
+			interval = time.Duration(seconds) * time.Second
+		}
+		ingestWG.Add(1)
+		go func() {
+			defer ingestWG.Done()
+			watchConfig(ingestCtx, configPath(), configETag, interval, &loadedCfg, conns.twitch)
+		}()
+	}
+
+	// Start Twitch live status polling (if configured)
+	if liveMonitor != nil {
+		ingestWG.Add(1)
+		go func() {
+			defer ingestWG.Done()
+			if err := liveMonitor.Start(ingestCtx); err != nil && err != context.Canceled {
+				log.Printf("Twitch live monitor error: %v", err)
+			}
+		}()
+	}
+
+	// Start Twitch chatters snapshots (if configured)
+	if chattersMonitor != nil {
+		ingestWG.Add(1)
+		go func() {
+			defer ingestWG.Done()
+			if err := chattersMonitor.Start(ingestCtx); err != nil && err != context.Canceled {
+				log.Printf("Twitch chatters monitor error: %v", err)
+			}
+		}()
+	}
+
+	// Forward connector output into the pipeline until connectors stop
+	pipelineWG.Add(1)
+	go func() {
+		defer pipelineWG.Done()
+		forwardUntil(messageChan, pipelineChan, ingestDone)
+	}()
+
+	// Start leader election (if configured). It runs with the connectors so
+	// the lease is released as soon as shutdown begins.
+	if elector != nil {
+		ingestWG.Add(1)
+		go func() {
+			defer ingestWG.Done()
+			if err := elector.Run(ingestCtx); err != nil && err != context.Canceled {
+				log.Printf("Leader election error: %v", err)
+			}
+		}()
+		pipelineWG.Add(1)
+		go func() {
+			defer pipelineWG.Done()
+			elector.Filter(ctx, electorIn, electorChan)
+		}()
+	}
+
+	// Start pause filtering (if configured)
+	if pauses != nil {
+		serviceWG.Add(1)
+		go func() {
+			defer serviceWG.Done()
+			pauses.Start(ctx)
+		}()
+		pipelineWG.Add(1)
+		go func() {
+			defer pipelineWG.Done()
+			pauses.Filter(ctx, pauseIn, pauseChan)
+		}()
+	}
+
+	// Start opt-out filtering (if configured)
+	if optOuts != nil {
+		serviceWG.Add(1)
+		go func() {
+			defer serviceWG.Done()
+			optOuts.Start(ctx)
+		}()
+		pipelineWG.Add(1)
+		go func() {
+			defer pipelineWG.Done()
+			optOuts.Filter(ctx, optOutIn, optOutChan)
+		}()
+	}
+
+	// Start the overflow queue (if configured)
+	if overflowQueue != nil {
+		pipelineWG.Add(1)
+		go func() {
+			defer pipelineWG.Done()
+			if err := overflowQueue.Run(ctx, overflowIn, overflowChan); err != nil && err != context.Canceled {
+				log.Printf("Overflow queue error: %v", err)
+			}
+		}()
+	}
+
+	// Start keyword alerts (if configured)
+	if watcher != nil {
+		serviceWG.Add(1)
+		go func() {
+			defer serviceWG.Done()
+			watcher.Start(ctx)
+		}()
+		pipelineWG.Add(1)
+		go func() {
+			defer pipelineWG.Done()
+			watcher.Filter(ctx, watcherIn, watcherChan)
+		}()
+	}
+
+	// Start highlight detection (if configured)
+	if detector != nil {
+		if cfg.Highlights.WebhookURL != "" {
+			serviceWG.Add(1)
+			go func() {
+				defer serviceWG.Done()
+				detector.Start(ctx)
+			}()
+		}
+		pipelineWG.Add(1)
+		go func() {
+			defer pipelineWG.Done()
+			detector.Filter(ctx, detectorIn, detectorChan)
+		}()
+	}
+
+	// Start high-volume limiting (if configured)
+	if limiter != nil {
+		pipelineWG.Add(1)
+		go func() {
+			defer pipelineWG.Done()
+			limiter.Filter(ctx, limiterIn, limiterChan)
+		}()
+	}
+
+	// Start enrichment (if configured)
+	if enrichment != nil {
+		pipelineWG.Add(1)
+		go func() {
+			defer pipelineWG.Done()
+			enrichment.Filter(ctx, enrichIn, enrichChan)
+		}()
+	}
+
+	// Start sinks
+	if fanout.Len() > 0 {
+		pipelineWG.Add(1)
+		go func() {
+			defer pipelineWG.Done()
+			if err := fanout.Start(ctx, ingestChan); err != nil && err != context.Canceled {
+				log.Printf("Sink fanout error: %v", err)
+			}
+		}()
+	}
+
+	// Start Twitch user lookups (if configured)
+	if userCache != nil {
+		userCache.EnableStatus(statusRegistry.Component("twitch_user_info"))
+		serviceWG.Add(1)
+		go func() {
+			defer serviceWG.Done()
+			if err := userCache.Run(ctx); err != nil && err != context.Canceled {
+				log.Printf("Twitch user lookup error: %v", err)
+			}
+		}()
+	}
+
+	// Start the instance heartbeat (if configured)
+	if heartbeat != nil {
+		heartbeat.EnableStatus(statusRegistry.Component("instance"))
+		serviceWG.Add(1)
+		go func() {
+			defer serviceWG.Done()
+			if err := heartbeat.Run(ctx); err != nil && err != context.Canceled {
+				log.Printf("Instance heartbeat error: %v", err)
+			}
+		}()
+	}
+
+	// Start stats
+	serviceWG.Add(1)
+	go func() {
+		defer serviceWG.Done()
+		statsRegistry.Start(ctx)
+	}()
+
+	// Start tenants' recorders (if configured), taking their channels'
+	// messages from the shared recorder
+	if tenants != nil {
+		tenantIn := recorderChan
+		recorderChan = make(chan message.Message, cfg.Recorder.BufferSize)
+		pipelineWG.Add(2)
+		go func() {
+			defer pipelineWG.Done()
+			tenants.Route(ctx, tenantIn, recorderChan)
+		}()
+		go func() {
+			defer pipelineWG.Done()
+			tenants.RunRecorders(ctx, fileChan)
+		}()
+	}
+
+	// Start recorder
+	pipelineWG.Add(1)
+	go func() {
+		defer pipelineWG.Done()
+		if err := rec.Start(ctx, recorderChan, fileChan); err != nil && err != context.Canceled {
+			log.Printf("Recorder error: %v", err)
+		}
+	}()
+
+	// Start annotation sidecars (if configured); they are closed and handed
+	// to the uploader with the recorder's files
+	if annotations != nil {
+		pipelineWG.Add(1)
+		go func() {
+			defer pipelineWG.Done()
+			if err := annotations.Start(ctx, ingestDone, fileChan); err != nil && err != context.Canceled {
+				log.Printf("Annotation store error: %v", err)
+			}
+		}()
+	}
+
+	// Start tenants' uploaders (if configured), taking their channels' files
+	// from the shared uploader
+	uploadChan := fileChan
+	if tenants != nil {
+		sharedFiles := make(chan recorder.FileInfo, 100)
+		uploadChan = sharedFiles
+		uploadWG.Add(2)
+		go func() {
+			defer uploadWG.Done()
+			tenants.RouteFiles(ctx, fileChan, sharedFiles)
+		}()
+		go func() {
+			defer uploadWG.Done()
+			tenants.RunUploaders(ctx)
+		}()
+	}
+
+	// Start uploader
+	uploadWG.Add(1)
+	go func() {
+		defer uploadWG.Done()
+		if err := uploaderInstance.Start(ctx, uploadChan); err != nil && err != context.Canceled {
+			log.Printf("Uploader error: %v", err)
+		}
+	}()
+
+	// Start health check server
+	serviceWG.Add(1)
+	go func() {
+		defer serviceWG.Done()
+		if err := healthServer.Start(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Health server error: %v", err)
+		}
+	}()
+
+	log.Println("All components started successfully")
+
+	// Wait for shutdown signal
+	<-sigChan
+	log.Println("Shutdown signal received, initiating graceful shutdown...")
+	progress := newShutdownProgress(cfg, statusRegistry.Component("shutdown"))
+	progress.watch("messages_queued", func() int64 { return int64(len(messageChan) + len(pipelineChan)) })
+	progress.watch("open_files", rec.OpenFiles)
+	progress.watch("files_queued", func() int64 { return int64(len(fileChan)) })
+	progress.watch("uploads_pending", func() int64 {
+		pending := uploaderInstance.Pending()
+		if tenants != nil {
+			pending += tenants.Pending()
+		}
+		return pending
+	})
+
+	phases := []struct {
+		name string
+		stop func()
+		wg   *sync.WaitGroup
+	}{
+		{"stopping connectors", stopIngest, &ingestWG},
+		{"draining pipeline into the recorder", func() { close(ingestDone) }, &pipelineWG},
+		{"finishing uploads", func() { close(fileChan) }, &uploadWG},
+	}
+	for _, phase := range phases {
+		phase.stop()
+		if !progress.wait(phase.name, phase.wg) {
+			log.Println("Forcing exit")
+			reportUnuploaded(cfg)
+			os.Exit(0)
+		}
+	}
+	progress.finish()
+	reportUnuploaded(cfg)
+
+	// Stop the remaining services
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := healthServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down health server: %v", err)
+	}
+	cancel()
+	serviceWG.Wait()
+	log.Println("Chatlog stopped")
+}
+
+// newRecorder creates the recorder with the configured rotation, layout,
+// encryption and sidecars
+func newRecorder(cfg *config.Config) *recorder.Recorder {
+	rec := recorder.New(
+		cfg.Recorder.OutputDir,
+		cfg.Recorder.BufferSize,
+		cfg.Recorder.RotateMinutes,
+		cfg.Recorder.RotateMegabytes,
+		cfg.Recorder.MinFreeMegabytes,
+		cfg.Recorder.SpillBufferSize,
+		cfg.Recorder.IdleMinutes,
+		cfg.Recorder.MaxOpenFiles,
+	)
+	rec.EnableShards(cfg.Recorder.Shards)
+	if cfg.Recorder.RotateJitter > 0 {
+		log.Printf("Spreading rotations over the last %d%% of each file's time limit", cfg.Recorder.RotateJitter)
+		rec.EnableRotationJitter(cfg.Recorder.RotateJitter)
+	}
+	if cfg.Recorder.FileHeaders {
+		log.Println("Writing file header records")
+		rec.EnableHeaders(version)
+	}
+	if cfg.Recorder.RotationIndex != "" {
+		log.Printf("Indexing rotated files in %s", cfg.Recorder.RotationIndex)
+		rec.EnableRotationIndex(cfg.Recorder.RotationIndex)
+	}
+	if s := cfg.Recorder.Summaries; s.Enabled {
+		tracker := firstseen.New(s.StateDir)
+		if err := tracker.Open(); err != nil {
+			log.Fatalf("Failed to open first-seen state: %v", err)
+		}
+		log.Printf("Writing chatter summaries (first-seen state in %s)", s.StateDir)
+		rec.EnableSummaries(tracker, s.TopChatters)
+	}
+	if len(cfg.Groups) > 0 {
+		log.Printf("%d channel group(s) configured", len(cfg.Groups))
+		groups := config.NewGroupIndex(cfg.Groups)
+		rec.EnableRotationOverrides(func(platform, channel string) (int, int) {
+			if group := groups.Lookup(platform, channel); group != nil {
+				return group.RotateMinutes, group.RotateMegabytes
+			}
+			return 0, 0
+		})
+	}
+
+	if keys := cfg.Recorder.Encryption.Recipients; len(keys) > 0 {
+		recipients, err := recorder.ParseRecipients(keys)
+		if err != nil {
+			log.Fatalf("Invalid recorder.encryption: %v", err)
+		}
+		log.Printf("Encrypting recorded files to %d age recipient(s)", len(recipients))
+		rec.EnableEncryption(recipients)
+	}
+	if e := cfg.Recorder.EmoteStats; e.Enabled {
+		log.Println("Writing emote statistics sidecars")
+		rec.EnableEmoteStats(e.Hourly)
+	}
+	if cfg.Recorder.SearchIndex.Enabled {
+		log.Println("Writing search index sidecars")
+		rec.EnableSearchIndex()
+	}
+	if s := cfg.Recorder.Seekable; s.Enabled {
+		log.Printf("Writing seekable gzip files with %d-minute frames", s.FrameMinutes)
+		rec.EnableSeekable(time.Duration(s.FrameMinutes) * time.Minute)
+	}
+	if cfg.Recorder.Layout == config.RecorderLayoutNested {
+		log.Println("Writing files into platform/channel/date subdirectories")
+		rec.EnableNestedLayout()
+	}
+	switch cfg.Recorder.Fsync {
+	case config.FsyncOnRotate:
+		rec.EnableSync(recorder.SyncOnRotate, 0)
+	case config.FsyncInterval:
+		log.Printf("Syncing recorded files to disk every %d second(s)", cfg.Recorder.FsyncSeconds)
+		rec.EnableSync(recorder.SyncInterval, time.Duration(cfg.Recorder.FsyncSeconds)*time.Second)
+	case config.FsyncEveryFlush:
+		log.Println("Syncing recorded files to disk after every flush")
+		rec.EnableSync(recorder.SyncEveryFlush, 0)
+	}
+	if cfg.Recorder.MemoryMegabytes > 0 {
+		policy := recorder.MemoryFlush
+		if cfg.Recorder.MemoryPolicy == config.MemoryPolicyDrop {
+			policy = recorder.MemoryDrop
+		}
+		rec.EnableMemoryBudget(cfg.Recorder.MemoryMegabytes, policy)
+	}
+	return rec
+}
+
+// forwardUntil copies messages from in to out until done is closed, then
+// forwards whatever is still buffered in in and closes out. in itself is
+// never closed, since connectors may still be finishing a send.
+func forwardUntil(in <-chan message.Message, out chan<- message.Message, done <-chan struct{}) {
+	defer close(out)
+	for {
+		select {
+		case msg := <-in:
+			out <- msg
+		case <-done:
+			for {
+				select {
+				case msg := <-in:
+					out <- msg
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// reportUnuploaded logs recorded files still in the output directory after
+// shutdown. They are picked up by the next start's scan.
+func reportUnuploaded(cfg *config.Config) {
+	if cfg.Uploader.Mode == config.UploadModeNone || !cfg.Uploader.DeleteAfterUpload {
+		return // Files are expected to stay
+	}
+
+	paths, err := recorder.LocalFiles(cfg.Recorder.OutputDir)
+	if err != nil {
+		return
+	}
+	var left []string
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".jsonl"+recorder.EncryptedExt) {
+			left = append(left, name)
+		}
+	}
+	if len(left) > 0 {
+		log.Printf("Warning: %d file(s) were not uploaded before shutdown and will be retried on next start: %s",
+			len(left), strings.Join(left, ", "))
+	} else {
+		log.Println("All recorded files were uploaded")
+	}
+}
+
+// configPath returns CONFIG_PATH, or config.yaml. It may be an http(s) or
+// s3:// URL.
+func configPath() string {
+	return cmp.Or(os.Getenv("CONFIG_PATH"), "config.yaml")
+}
+
+// loadConfig loads the configuration from CONFIG_PATH or config.yaml
+func loadConfig() *config.Config {
+	cfg, _ := loadConfigSource()
+	return cfg
+}
+
+// loadConfigSource loads the configuration from CONFIG_PATH or config.yaml,
+// returning it with the ETag of the source it was read from
+func loadConfigSource() (*config.Config, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	data, etag, err := fetchConfig(ctx, configPath(), "")
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Load configuration
+	cfg, err := parseConfig(ctx, data)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	log.Printf("Configuration loaded successfully")
+	for _, warning := range cfg.Warnings {
+		log.Printf("Warning: config: %s", warning)
+	}
+
+	return cfg, etag
+}
+
+// newAdminAuth converts admin.tokens, which Load has validated
+func newAdminAuth(a config.AdminConfig) *admin.Auth {
+	tokens := make([]admin.Token, len(a.Tokens))
+	for i, t := range a.Tokens {
+		role, _ := admin.ParseRole(t.Role)
+		tokens[i] = admin.Token{Name: t.Name, Token: t.Token, Role: role}
+	}
+	return admin.New(tokens)
+}
+
+// newUploadSchedule converts uploader.schedule, which Load has validated
+func newUploadSchedule(sched config.UploadScheduleConfig) *uploader.Schedule {
+	loc, _ := sched.Location()
+	windows := make([]uploader.Window, len(sched.Windows))
+	for i, w := range sched.Windows {
+		start, end, _ := w.Clock()
+		days, _ := w.Weekdays()
+		windows[i] = uploader.Window{
+			Start:          start,
+			End:            end,
+			Days:           days,
+			BytesPerSecond: int64(w.MegabytesPerSecond * 1024 * 1024),
+			Pause:          w.Pause,
+		}
+	}
+	log.Printf("Upload schedule: %d window(s) in %s", len(windows), loc)
+	return uploader.NewSchedule(windows, loc)
+}
+
+// newUploader creates the uploader for the configured mode and credentials,
+// with upload notifications if any are configured
+func newUploader(ctx context.Context, cfg *config.Config) (*uploader.Uploader, error) {
+	objectOpts := objectOptions(cfg)
+
+	var up *uploader.Uploader
+	var err error
+	switch {
+	case cfg.Uploader.Mode == config.UploadModeLocal:
+		log.Printf("Local mode: copying completed files to %s", cfg.Uploader.LocalDir)
+		up, err = uploader.NewLocal(cfg.Uploader.LocalDir, cfg.Uploader.DeleteAfterUpload, cfg.S3.KeyTemplate)
+	case cfg.Uploader.Mode == config.UploadModeNone:
+		log.Printf("Upload disabled: completed files are kept in %s", cfg.Recorder.OutputDir)
+		return uploader.NewLocal("", false, cfg.S3.KeyTemplate)
+	case cfg.S3.RoleARN != "":
+		// Use OIDC authentication
+		log.Printf("Using OIDC authentication with role: %s", cfg.S3.RoleARN)
+		up, err = uploader.New(
+			ctx,
+			cfg.S3.Bucket,
+			cfg.S3.Region,
+			cfg.S3.RoleARN,
+			cfg.Uploader.DeleteAfterUpload,
+			cfg.Uploader.MaxRetries,
+			objectOpts,
+			cfg.S3.KeyTemplate,
+			uploader.WithEndpoint(cfg.S3.Endpoint),
+		)
+	default:
+		// Use legacy static credentials (deprecated)
+		log.Println("WARNING: Using static AWS credentials (deprecated). Migrate to OIDC for better security.")
+		up, err = uploader.NewWithStaticCredentials(
+			ctx,
+			cfg.S3.Bucket,
+			cfg.S3.Region,
+			cfg.S3.AccessKeyID,
+			cfg.S3.SecretAccessKey,
+			cfg.Uploader.DeleteAfterUpload,
+			cfg.Uploader.MaxRetries,
+			objectOpts,
+			cfg.S3.KeyTemplate,
+			uploader.WithEndpoint(cfg.S3.Endpoint),
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	up.EnableRetryPolicy(retryPolicy(cfg.Uploader.Retry))
+
+	if len(cfg.Groups) > 0 {
+		groups := config.NewGroupIndex(cfg.Groups)
+		up.EnableKeyPrefixes(func(platform, channel string) string {
+			if group := groups.Lookup(platform, channel); group != nil {
+				return group.KeyPrefix
+			}
+			return ""
+		})
+	}
+	if cfg.Uploader.Mode == config.UploadModeS3 {
+		if err := addGroupDestinations(ctx, cfg, up); err != nil {
+			return nil, err
+		}
+		if h := cfg.Uploader.Hedge; h.Enabled {
+			log.Printf("Hedging S3 uploads slower than p%d of recent uploads (at least %ds)", h.Percentile, h.MinSeconds)
+			up.EnableHedging(uploader.NewHedge(float64(h.Percentile), time.Duration(h.MinSeconds)*time.Second))
+		}
+	}
+
+	notifiers, err := newNotifiers(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	up.EnableNotifications(notifiers...)
+	return up, nil
+}
+
+// addGroupDestinations routes the files of groups with their own s3
+// settings to their buckets
+func addGroupDestinations(ctx context.Context, cfg *config.Config, up *uploader.Uploader) error {
+	routed := false
+	for _, g := range cfg.Groups {
+		if g.S3 == nil {
+			continue
+		}
+		client, err := groupS3Client(ctx, cfg, g.S3)
+		if err != nil {
+			return fmt.Errorf("group %s: %w", g.Name, err)
+		}
+		up.AddDestination(g.Name, client, g.S3.Bucket, groupObjectOptions(cfg, g.S3))
+		log.Printf("Group %s uploads to s3://%s/%s", g.Name, g.S3.Bucket, g.KeyPrefix)
+		routed = true
+	}
+	if routed {
+		groups := config.NewGroupIndex(cfg.Groups)
+		up.EnableDestinations(func(platform, channel string) string {
+			if group := groups.Lookup(platform, channel); group != nil && group.S3 != nil {
+				return group.Name
+			}
+			return ""
+		})
+	}
+	return nil
+}
+
+// groupS3Client creates the client for a group's bucket. Region and
+// endpoint fall back to the s3 settings, as do credentials when the group
+// has none of its own.
+func groupS3Client(ctx context.Context, cfg *config.Config, gs *config.S3Destination) (*s3.Client, error) {
+	region := cmp.Or(gs.Region, cfg.S3.Region)
+	endpoint := cmp.Or(gs.Endpoint, cfg.S3.Endpoint)
+	roleARN, keyID, secret := gs.RoleARN, gs.AccessKeyID, gs.SecretAccessKey
+	if roleARN == "" && keyID == "" {
+		roleARN, keyID, secret = cfg.S3.RoleARN, cfg.S3.AccessKeyID, cfg.S3.SecretAccessKey
+	}
+	return uploader.NewS3Client(ctx, region, roleARN, keyID, secret, uploader.WithEndpoint(endpoint))
+}
+
+// groupObjectOptions returns the object options for a group's bucket,
+// falling back to the s3 settings
+func groupObjectOptions(cfg *config.Config, gs *config.S3Destination) uploader.ObjectOptions {
+	opts := objectOptions(cfg)
+	if gs.ServerSideEncryption != "" {
+		opts.ServerSideEncryption = gs.ServerSideEncryption
+		opts.KMSKeyID = gs.KMSKeyID
+	}
+	return opts
+}
+
+// archiveBucket is a bucket channels' files are uploaded to, with the key
+// prefixes they are found under besides the default layout
+type archiveBucket struct {
+	client   *s3.Client
+	bucket   string
+	prefixes []string
+	opts     uploader.ObjectOptions
+}
+
+// archiveBuckets returns s3.bucket followed by each other bucket groups
+// upload to, so tools reading the whole archive cover every channel
+func archiveBuckets(ctx context.Context, cfg *config.Config) ([]*archiveBucket, error) {
+	client, err := newS3Client(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	buckets := []*archiveBucket{{client: client, bucket: cfg.S3.Bucket, prefixes: cfg.KeyPrefixes(), opts: objectOptions(cfg)}}
+	for _, g := range cfg.Groups {
+		if g.S3 == nil {
+			continue
+		}
+		i := slices.IndexFunc(buckets, func(b *archiveBucket) bool { return b.bucket == g.S3.Bucket })
+		if i >= 0 {
+			if !slices.Contains(buckets[i].prefixes, g.KeyPrefix) {
+				buckets[i].prefixes = append(buckets[i].prefixes, g.KeyPrefix)
+			}
+			continue
+		}
+		client, err := groupS3Client(ctx, cfg, g.S3)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", g.Name, err)
+		}
+		buckets = append(buckets, &archiveBucket{client: client, bucket: g.S3.Bucket, prefixes: []string{g.KeyPrefix}, opts: groupObjectOptions(cfg, g.S3)})
+	}
+	return buckets, nil
+}
+
+// newNotifiers creates the configured upload notifiers. SQS and SNS use the
+// same AWS credentials as S3.
+func newNotifiers(ctx context.Context, cfg *config.Config) ([]notify.Notifier, error) {
+	n := cfg.Uploader.Notify
+	var notifiers []notify.Notifier
+
+	if n.SQSQueueURL != "" || n.SNSTopicARN != "" {
+		awsCfg, err := uploader.LoadAWSConfig(ctx, n.Region, cfg.S3.RoleARN, cfg.S3.AccessKeyID, cfg.S3.SecretAccessKey)
+		if err != nil {
+			return nil, fmt.Errorf("notifications: %w", err)
+		}
+		if n.SQSQueueURL != "" {
+			log.Printf("Sending upload notifications to SQS queue %s", n.SQSQueueURL)
+			notifiers = append(notifiers, notify.NewSQS(awsCfg, n.SQSQueueURL))
+		}
+		if n.SNSTopicARN != "" {
+			log.Printf("Publishing upload notifications to SNS topic %s", n.SNSTopicARN)
+			notifiers = append(notifiers, notify.NewSNS(awsCfg, n.SNSTopicARN))
+		}
+	}
+	if n.WebhookURL != "" {
+		log.Printf("Posting upload notifications to %s", n.WebhookURL)
+		notifiers = append(notifiers, notify.NewWebhook(n.WebhookURL, n.WebhookSecret))
+	}
+	return notifiers, nil
+}
+
+// newEnrichment builds the configured enrichment processors
+func newEnrichment(cfg *config.Config) (*enrich.Pipeline, error) {
+	specs := make([]enrich.Spec, len(cfg.Enrich.Processors))
+	for i, p := range cfg.Enrich.Processors {
+		specs[i] = enrich.Spec{Name: p.Name, Options: p.Options}
+	}
+	return enrich.Build(specs)
+}
+
+// newSigner creates a presigned URL generator covering the bucket's group
+// prefixes
+func newSigner(ctx context.Context, cfg *config.Config, maxExpiry time.Duration) (*presign.Signer, error) {
+	client, err := newS3Client(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	signer := presign.New(client, cfg.S3.Bucket, maxExpiry)
+	signer.EnablePrefixes(cfg.KeyPrefixes())
+	return signer, nil
+}
+
+// configuredChannels lists the statically configured channels as
+// "platform/channel", for detecting instances that record the same ones.
+// Discovered channels and custom connectors' channels aren't known ahead.
+func configuredChannels(cfg *config.Config) []string {
+	var channels []string
+	for _, channel := range cfg.Twitch.Channels {
+		channels = append(channels, "twitch/"+strings.ToLower(channel))
+	}
+	if cfg.Kick.Enabled {
+		for _, channel := range cfg.Kick.Channels {
+			channels = append(channels, "kick/"+strings.ToLower(channel.Slug))
+		}
+	}
+	if cfg.YouTube.Enabled {
+		for _, channel := range cfg.YouTube.Channels {
+			channels = append(channels, "youtube/"+cmp.Or(channel.Name, channel.ID))
+		}
+	}
+	if cfg.IRC.Enabled {
+		for _, n := range cfg.IRC.Networks {
+			for _, channel := range n.Channels {
+				channels = append(channels, "irc/"+irc.ChannelName(n.Name, channel))
+			}
+		}
+	}
+	if cfg.Bluesky.Enabled {
+		for _, tag := range cfg.Bluesky.Hashtags {
+			channels = append(channels, "bluesky/#"+strings.ToLower(tag))
+		}
+		for _, handle := range cfg.Bluesky.Handles {
+			channels = append(channels, "bluesky/@"+strings.ToLower(handle))
+		}
+	}
+	if cfg.Mastodon.Enabled {
+		for _, tag := range cfg.Mastodon.Hashtags {
+			channels = append(channels, "mastodon/#"+strings.ToLower(strings.TrimPrefix(tag, "#")))
+		}
+		for _, acct := range cfg.Mastodon.Accounts {
+			channels = append(channels, "mastodon/@"+strings.ToLower(strings.TrimPrefix(acct, "@")))
+		}
+	}
+	return channels
+}
+
+// onInstanceConflict exits, or logs an error with instance_check.on_conflict
+// set to warn
+func onInstanceConflict(cfg *config.Config, format string, args ...any) {
+	if cfg.Instance.OnConflict == config.OnConflictWarn {
+		log.Printf("Error: "+format+"; recording anyway, the archive will hold duplicates", args...)
+		return
+	}
+	log.Fatalf(format+"; refusing to start (set instance_check.on_conflict: warn to override)", args...)
+}
+
+// newHeartbeat creates the instance heartbeat in the s3 bucket
+func newHeartbeat(ctx context.Context, cfg *config.Config, info instance.Info) *instance.Heartbeat {
+	client, err := newS3Client(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create S3 client for the instance heartbeat: %v", err)
+	}
+	h := cfg.Instance.Heartbeat
+	log.Printf("Publishing instance heartbeat to s3://%s/%s", cfg.S3.Bucket, h.Prefix)
+	return instance.NewHeartbeat(client, cfg.S3.Bucket, h.Prefix, info, time.Duration(h.IntervalSeconds)*time.Second)
+}
+
+// newLeaderLock creates the lease backend for leader election
+func newLeaderLock(ctx context.Context, cfg *config.Config) leader.Lock {
+	if cfg.Leader.Backend == "s3" {
+		client, err := newS3Client(ctx, cfg)
+		if err != nil {
+			log.Fatalf("Failed to create S3 client for leader election: %v", err)
+		}
+		log.Printf("Leader election enabled: lease s3://%s/%s", cfg.S3.Bucket, cfg.Leader.Key)
+		return leader.NewS3Lock(client, cfg.S3.Bucket, cfg.Leader.Key)
+	}
+	r := cfg.Leader.Redis
+	log.Printf("Leader election enabled: lease %s on Redis %s", cfg.Leader.Key, r.Addr)
+	return leader.NewRedisLock(r.Addr, r.Password, r.DB, cfg.Leader.Key)
+}
+
+// sinkFilter returns the filter of the named sink: its channel groups, and
+// its feature switch when switches are enabled
+func sinkFilter(cfg *config.Config, switches *features.Set, name string) func(message.Message) bool {
+	accept := groupSinkFilter(cfg, name)
+	on := switches.Register("sink/" + name)
+	if on == nil {
+		return accept
+	}
+	return func(msg message.Message) bool {
+		return on() && (accept == nil || accept(msg))
+	}
+}
+
+// groupSinkFilter returns a filter passing messages to the named sink
+// unless the channel's group lists sinks without it. Tenants' messages pass
+// only to the sinks their tenant lists.
+func groupSinkFilter(cfg *config.Config, name string) func(message.Message) bool {
+	if len(cfg.Groups) == 0 && len(cfg.Tenants) == 0 {
+		return nil
+	}
+	groups := config.NewGroupIndex(cfg.Groups)
+	return func(msg message.Message) bool {
+		if t := cfg.Tenant(msg.Platform, msg.Channel); t != nil {
+			return slices.Contains(t.Sinks, name)
+		}
+		group := groups.Lookup(msg.Platform, msg.Channel)
+		return group == nil || group.Sinks == nil || slices.Contains(group.Sinks, name)
+	}
+}
+
+// newS3Client creates a client for the configured bucket's endpoint and
+// credentials
+func newS3Client(ctx context.Context, cfg *config.Config) (*s3.Client, error) {
+	return uploader.NewS3Client(ctx, cfg.S3.Region, cfg.S3.RoleARN, cfg.S3.AccessKeyID, cfg.S3.SecretAccessKey,
+		uploader.WithEndpoint(cfg.S3.Endpoint))
+}
+
+// retryPolicy converts a configured retry policy, in which -1 disables
+// jitter and the elapsed time limit
+func retryPolicy(c config.RetryConfig) retry.Policy {
+	return retry.Policy{
+		Base:       time.Duration(c.BaseMs) * time.Millisecond,
+		Multiplier: c.Multiplier,
+		Jitter:     max(c.Jitter, 0),
+		Max:        time.Duration(c.MaxSeconds) * time.Second,
+		MaxElapsed: time.Duration(max(c.MaxElapsedSeconds, 0)) * time.Second,
+	}
+}
+
+// objectOptions builds the S3 object settings from config
+func objectOptions(cfg *config.Config) uploader.ObjectOptions {
+	return uploader.ObjectOptions{
+		ServerSideEncryption: cfg.S3.ServerSideEncryption,
+		KMSKeyID:             cfg.S3.KMSKeyID,
+		StorageClass:         cfg.S3.StorageClass,
+		Tags:                 cfg.S3.Tags,
+	}
+}
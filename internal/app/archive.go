@@ -0,0 +1,80 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/archive"
+	"github.com/john/chatlog/internal/config"
+)
+
+// runServeArchive implements the "serve-archive" subcommand, serving a
+// read-only browser of the bucket until interrupted
+func runServeArchive(args []string) {
+	fs := flag.NewFlagSet("serve-archive", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8081", "Address to listen on; other than loopback requires CHATLOG_ARCHIVE_TOKEN")
+	fs.Parse(args)
+
+	token := os.Getenv("CHATLOG_ARCHIVE_TOKEN")
+	if token == "" && !isLoopback(*addr) {
+		log.Fatalf("CHATLOG_ARCHIVE_TOKEN is required to serve the archive on %s; set it or listen on a loopback address such as 127.0.0.1:8081", *addr)
+	}
+
+	cfg := loadConfig()
+	if cfg.Uploader.Mode != config.UploadModeS3 {
+		log.Fatalf("serve-archive requires uploader.mode s3")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	buckets, err := archiveBuckets(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create S3 client: %v", err)
+	}
+
+	browser := archive.NewBrowser(buckets[0].client, buckets[0].bucket)
+	for _, b := range buckets[1:] {
+		browser.AddBucket(b.client, b.bucket)
+		log.Printf("Also serving s3://%s under /buckets/%s/", b.bucket, b.bucket)
+	}
+	if token != "" {
+		browser.EnableToken(token)
+	}
+
+	server := &http.Server{Addr: *addr, Handler: browser.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving s3://%s on %s", cfg.S3.Bucket, *addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Archive server error: %v", err)
+	}
+}
+
+// isLoopback reports whether addr only listens on a loopback interface, so
+// only local users can reach it
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && loopbackHost(host)
+}
+
+// loopbackHost reports whether host names or is a loopback address
+func loopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
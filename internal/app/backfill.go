@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/twitch"
+)
+
+// runBackfill implements the "backfill" subcommand, recovering chat the
+// recorder missed from the platform's own archive
+func runBackfill(args []string) {
+	if len(args) == 0 || args[0] != "twitch-vod" {
+		log.Printf("Usage: chatlog backfill twitch-vod [flags] VOD_ID...")
+		os.Exit(2)
+	}
+	runBackfillTwitchVOD(args[1:])
+}
+
+// runBackfillTwitchVOD downloads the chat replay of Twitch VODs and uploads
+// it to the partitions the recorder would have written it to
+func runBackfillTwitchVOD(args []string) {
+	fs := flag.NewFlagSet("backfill twitch-vod", flag.ExitOnError)
+	clientID := fs.String("client-id", "", "Client ID for Twitch's GQL API (default: the web player's)")
+	out := fs.String("out", "", "Write converted files to this directory instead of uploading")
+	fs.Usage = func() {
+		log.Printf("Usage: chatlog backfill twitch-vod [flags] VOD_ID...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	client := twitch.NewVODClient(*clientID)
+	conv := newConverter(ctx, "backfill", *out)
+	for _, arg := range fs.Args() {
+		// Accept VOD URLs as well as IDs
+		id := arg[strings.LastIndex(arg, "/")+1:]
+		id, _, _ = strings.Cut(id, "?")
+
+		vod, err := client.GetVOD(ctx, id)
+		if err != nil {
+			conv.Abort()
+			log.Fatalf("Failed to look up VOD: %v", err)
+		}
+		log.Printf("Downloading chat of VOD %s: %s, %s from %s", vod.ID, vod.Channel, vod.Length, vod.CreatedAt.UTC().Format("2006-01-02 15:04"))
+
+		count := 0
+		err = client.Comments(ctx, vod, func(page []message.Message) error {
+			for _, msg := range page {
+				if err := conv.Add(msg); err != nil {
+					return fmt.Errorf("write converted file: %w", err)
+				}
+			}
+			count += len(page)
+			return nil
+		})
+		if err != nil {
+			conv.Abort()
+			log.Fatalf("Failed to download chat: %v", err)
+		}
+		log.Printf("Downloaded %d message(s) from VOD %s", count, vod.ID)
+	}
+	if conv.Messages() == 0 {
+		conv.Abort()
+		log.Fatalf("No chat found in %d VOD(s)", fs.NArg())
+	}
+
+	conv.Finish(ctx)
+}
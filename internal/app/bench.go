@@ -0,0 +1,250 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/recorder"
+)
+
+// benchWords are mixed into synthetic messages, with a few emote names so
+// emote counting has work to do
+var benchWords = strings.Fields("the stream is so good today lol KEKW PogChamp LUL what did he just say " +
+	"no way chat is this real Kappa gg wp monkaS clip that omegalul")
+
+// runBench implements the "bench" subcommand, driving the recorder with
+// synthetic chat to measure write throughput, allocations and how long
+// files take to close. Budgets make it exit non-zero on a regression.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	channels := fs.Int("channels", 100, "Number of synthetic channels")
+	rate := fs.Int("rate", 0, "Messages per second across all channels (0: as fast as the recorder takes them)")
+	duration := fs.Duration("duration", 30*time.Second, "How long to generate messages")
+	messageBytes := fs.Int("message-bytes", 80, "Approximate length of each chat message")
+	bufferSize := fs.Int("buffer-size", 100, "Recorder buffer size (recorder.buffer_size)")
+	shards := fs.Int("shards", 1, "Recorder shards (recorder.shards)")
+	rotateMegabytes := fs.Int("rotate-megabytes", 64, "Size rotation limit; sizes are checked once a minute")
+	dir := fs.String("dir", "", "Directory to write to (default: a temporary directory, removed afterwards)")
+	minRate := fs.Float64("min-rate", 0, "Budget: fail if fewer messages per second were written")
+	maxAllocs := fs.Float64("max-allocs", 0, "Budget: fail if there were more heap allocations per message")
+	maxCloseMs := fs.Float64("max-close-ms", 0, "Budget: fail if the 99th percentile file close took longer")
+	fs.Usage = func() {
+		log.Printf("Usage: chatlog bench [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *channels < 1 || *duration <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	outputDir := *dir
+	if outputDir == "" {
+		var err error
+		if outputDir, err = os.MkdirTemp("", "chatlog-bench-"); err != nil {
+			log.Fatalf("Failed to create benchmark directory: %v", err)
+		}
+		defer os.RemoveAll(outputDir)
+	}
+	indexPath := filepath.Join(outputDir, "rotations.jsonl")
+
+	rec := recorder.New(outputDir, *bufferSize, 24*60, *rotateMegabytes, 0, 0, -1, 0)
+	rec.EnableShards(*shards)
+	rec.EnableRotationIndex(indexPath)
+
+	log.Printf("Benchmarking the recorder: %d channel(s), %s, rate %s, writing to %s",
+		*channels, *duration, benchRate(*rate), outputDir)
+
+	// The recorder logs every file it opens and closes; keep the report readable
+	logOutput := log.Writer()
+	log.SetOutput(io.Discard)
+
+	messageChan := make(chan message.Message, *bufferSize)
+	fileChan := make(chan recorder.FileInfo, 1000)
+
+	// Remove closed files as they are handed over, so a long run at full
+	// speed doesn't fill the disk
+	var files int
+	var filesWG sync.WaitGroup
+	filesWG.Add(1)
+	go func() {
+		defer filesWG.Done()
+		for info := range fileChan {
+			files++
+			os.Remove(info.Path)
+		}
+	}()
+
+	var recErr error
+	recDone := make(chan struct{})
+	go func() {
+		defer close(recDone)
+		recErr = rec.Start(context.Background(), messageChan, fileChan)
+	}()
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	sent := benchGenerate(messageChan, *channels, *rate, *messageBytes, *duration)
+	close(messageChan)
+	<-recDone
+	elapsed := time.Since(start)
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	close(fileChan)
+	filesWG.Wait()
+	log.SetOutput(logOutput)
+
+	if recErr != nil {
+		log.Fatalf("Recorder failed: %v", recErr)
+	}
+
+	rotations, err := readRotations(indexPath)
+	if err != nil {
+		log.Fatalf("Failed to read rotation index: %v", err)
+	}
+	var written, bytes int64
+	closeMs := make([]float64, 0, len(rotations))
+	for _, rotation := range rotations {
+		written += rotation.Records
+		bytes += rotation.WrittenBytes
+		closeMs = append(closeMs, rotation.CloseSeconds*1000)
+	}
+	slices.Sort(closeMs)
+
+	throughput := float64(written) / elapsed.Seconds()
+	allocs := float64(after.Mallocs-before.Mallocs) / float64(max(sent, 1))
+	fmt.Printf("messages sent       %d\n", sent)
+	fmt.Printf("records written     %d (%d file(s))\n", written, files)
+	fmt.Printf("elapsed             %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("throughput          %.0f records/s, %.1f MB/s\n", throughput, float64(bytes)/elapsed.Seconds()/1e6)
+	fmt.Printf("allocations         %.1f per message, %.0f bytes per message\n",
+		allocs, float64(after.TotalAlloc-before.TotalAlloc)/float64(max(sent, 1)))
+	fmt.Printf("GC                  %d cycle(s), %s paused\n",
+		after.NumGC-before.NumGC, time.Duration(after.PauseTotalNs-before.PauseTotalNs).Round(time.Microsecond))
+	fmt.Printf("file close          p50 %.2f ms, p99 %.2f ms, max %.2f ms\n",
+		percentile(closeMs, 0.5), percentile(closeMs, 0.99), percentile(closeMs, 1))
+
+	var failed []string
+	if *minRate > 0 && throughput < *minRate {
+		failed = append(failed, fmt.Sprintf("throughput %.0f/s is under -min-rate %.0f", throughput, *minRate))
+	}
+	if *maxAllocs > 0 && allocs > *maxAllocs {
+		failed = append(failed, fmt.Sprintf("%.1f allocations per message is over -max-allocs %.1f", allocs, *maxAllocs))
+	}
+	if p99 := percentile(closeMs, 0.99); *maxCloseMs > 0 && p99 > *maxCloseMs {
+		failed = append(failed, fmt.Sprintf("p99 file close %.2f ms is over -max-close-ms %.2f", p99, *maxCloseMs))
+	}
+	if len(failed) > 0 {
+		os.RemoveAll(outputDir) // Fatalf skips deferred cleanup
+		log.Fatalf("Performance budget exceeded: %s", strings.Join(failed, "; "))
+	}
+}
+
+// benchGenerate sends synthetic chat round-robin across channels for
+// duration, paced to rate messages a second unless rate is zero, and
+// returns how many were sent
+func benchGenerate(messageChan chan<- message.Message, channels, rate, messageBytes int, duration time.Duration) int64 {
+	names := make([]string, channels)
+	for i := range names {
+		names[i] = fmt.Sprintf("bench%05d", i)
+	}
+	users := make([]string, 5000)
+	for i := range users {
+		users[i] = fmt.Sprintf("user%d", i)
+	}
+	texts := make([]string, 256)
+	for i := range texts {
+		var b strings.Builder
+		for j := i; b.Len() < messageBytes; j += 7 {
+			if b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(benchWords[j%len(benchWords)])
+		}
+		texts[i] = b.String()
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	start := time.Now()
+	deadline := start.Add(duration)
+	var sent int64
+	for time.Now().Before(deadline) {
+		// Catch up to the rate, or send a batch at a time when unlimited
+		due := sent + 1000
+		if rate > 0 {
+			due = int64(float64(rate) * time.Since(start).Seconds())
+		}
+		for ; sent < due; sent++ {
+			i := int(sent)
+			msg := message.New("bench", time.Time{})
+			msg.Channel = names[i%channels]
+			msg.Username = users[i%len(users)]
+			msg.UserID = users[i%len(users)][len("user"):]
+			msg.Message = texts[i%len(texts)]
+			messageChan <- msg
+		}
+		if rate > 0 {
+			<-ticker.C
+		}
+	}
+	return sent
+}
+
+// readRotations reads a rotation index file
+func readRotations(path string) ([]recorder.Rotation, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rotations []recorder.Rotation
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rotation recorder.Rotation
+		if err := json.Unmarshal(scanner.Bytes(), &rotation); err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, rotation)
+	}
+	return rotations, scanner.Err()
+}
+
+// percentile returns the value at fraction p of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(int(p*float64(len(sorted))), len(sorted)-1)]
+}
+
+// benchRate describes the -rate flag
+func benchRate(rate int) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/s", rate)
+}
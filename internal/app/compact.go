@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/compactor"
+	"github.com/john/chatlog/internal/config"
+)
+
+// runCompact implements the "compact" subcommand, merging a day's rotated
+// files per channel into a single compressed object
+func runCompact(args []string) {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	date := fs.String("date", time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02"), "UTC day to compact (YYYY-MM-DD)")
+	keep := fs.Bool("keep-fragments", false, "Keep the original files after compaction")
+	fs.Parse(args)
+
+	day, err := time.Parse("2006-01-02", *date)
+	if err != nil {
+		log.Fatalf("Invalid -date %q: %v", *date, err)
+	}
+
+	cfg := loadConfig()
+	if cfg.Uploader.Mode != config.UploadModeS3 {
+		log.Fatalf("compact requires uploader.mode s3")
+	}
+	requireDefaultKeyLayout(cfg, "compact")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	buckets, err := archiveBuckets(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create S3 client: %v", err)
+	}
+
+	// Every bucket is compacted even if one fails, since they are independent
+	failed := false
+	for _, b := range buckets {
+		c := compactor.New(b.client, b.bucket, b.opts, !*keep)
+		c.EnablePrefixes(b.prefixes)
+		if err := c.CompactDay(ctx, day); err != nil {
+			log.Printf("Error: compacting s3://%s failed: %v", b.bucket, err)
+			failed = true
+		}
+	}
+	if failed {
+		log.Fatalf("Compaction failed")
+	}
+
+	log.Println("Compaction complete")
+}
@@ -0,0 +1,312 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/secrets"
+	"github.com/john/chatlog/internal/twitch"
+	"github.com/john/chatlog/internal/uploader"
+)
+
+// errConfigNotModified is returned by fetchConfig when the source still
+// has the given ETag
+var errConfigNotModified = errors.New("config not modified")
+
+// maxConfigBytes caps the size of a fetched config
+const maxConfigBytes = 1 << 20
+
+// remoteConfig reports whether path is an http(s) or s3 URL rather than a file
+func remoteConfig(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "s3://")
+}
+
+// fetchConfig reads the config at path, a file, http(s) URL or
+// s3://bucket/key URL, and returns it with its ETag. A non-empty etag makes
+// the request conditional, returning errConfigNotModified if unchanged.
+//
+// S3 uses the default AWS credential chain, or AWS_ROLE_ARN and
+// S3_ACCESS_KEY_ID/S3_SECRET_ACCESS_KEY as the uploader does, with the
+// region from CONFIG_S3_REGION or AWS_REGION and an optional
+// CONFIG_S3_ENDPOINT for S3-compatible services.
+func fetchConfig(ctx context.Context, path, etag string) ([]byte, string, error) {
+	switch {
+	case strings.HasPrefix(path, "s3://"):
+		return fetchS3Config(ctx, path, etag)
+	case remoteConfig(path):
+		return fetchHTTPConfig(ctx, path, etag)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("read config file: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:]), nil
+}
+
+// checkConfigURL refuses plain http except to this host, since the config
+// holds credentials and decides what is recorded
+func checkConfigURL(u *url.URL) error {
+	if u.Scheme != "https" && !(u.Scheme == "http" && loopbackHost(u.Hostname())) {
+		return fmt.Errorf("config URL %s must use https unless it is on localhost", u.Redacted())
+	}
+	return nil
+}
+
+// readConfig reads a fetched config, failing rather than truncating one
+// larger than maxConfigBytes
+func readConfig(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxConfigBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetch config: %w", err)
+	}
+	if len(data) > maxConfigBytes {
+		return nil, fmt.Errorf("config too large: over %d bytes", maxConfigBytes)
+	}
+	return data, nil
+}
+
+// fetchHTTPConfig GETs a config over https, or http on localhost
+func fetchHTTPConfig(ctx context.Context, path, etag string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("create request: %w", err)
+	}
+	if err := checkConfigURL(req.URL); err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return checkConfigURL(req.URL)
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, etag, errConfigNotModified
+	default:
+		return nil, "", fmt.Errorf("fetch config: status %d", resp.StatusCode)
+	}
+	data, err := readConfig(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Servers without ETags are compared by content
+	newETag := resp.Header.Get("ETag")
+	if newETag == "" {
+		sum := sha256.Sum256(data)
+		newETag = hex.EncodeToString(sum[:])
+		if newETag == etag {
+			return nil, etag, errConfigNotModified
+		}
+	}
+	return data, newETag, nil
+}
+
+// fetchS3Config reads a config object from S3
+func fetchS3Config(ctx context.Context, path, etag string) ([]byte, string, error) {
+	u, err := url.Parse(path)
+	if err != nil || u.Host == "" || len(u.Path) < 2 {
+		return nil, "", fmt.Errorf("config path must look like s3://bucket/key, got %q", path)
+	}
+
+	region := cmp.Or(os.Getenv("CONFIG_S3_REGION"), os.Getenv("AWS_REGION"), "us-east-1")
+	awsCfg, err := uploader.LoadAWSConfig(ctx, region, os.Getenv("AWS_ROLE_ARN"),
+		os.Getenv("S3_ACCESS_KEY_ID"), os.Getenv("S3_SECRET_ACCESS_KEY"))
+	if err != nil {
+		return nil, "", err
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if endpoint := os.Getenv("CONFIG_S3_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	}
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+	out, err := client.GetObject(ctx, input)
+	if err != nil {
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified {
+			return nil, etag, errConfigNotModified
+		}
+		return nil, "", fmt.Errorf("fetch config: %w", err)
+	}
+	defer out.Body.Close()
+
+	data, err := readConfig(out.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, aws.ToString(out.ETag), nil
+}
+
+// parseConfig parses a config and replaces secret references in its values
+// (secretsmanager://, ssm:// and vault://) with the secrets they name, so
+// tokens need not be written into the file or environment
+func parseConfig(ctx context.Context, data []byte) (*config.Config, error) {
+	cfg, err := config.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := uploader.NewTokenSource(uploader.TokenSourceOptions{
+		Source:   cfg.S3.OIDC.Source,
+		File:     cfg.S3.OIDC.TokenFile,
+		URL:      cfg.S3.OIDC.URL,
+		Headers:  cfg.S3.OIDC.Headers,
+		Audience: cfg.S3.OIDC.Audience,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3.oidc: %w", err)
+	}
+	uploader.SetTokenSource(tokens)
+	resolver := secrets.New(func(ctx context.Context) (aws.Config, error) {
+		return uploader.LoadAWSConfig(ctx, cmp.Or(os.Getenv("AWS_REGION"), "us-east-1"), os.Getenv("AWS_ROLE_ARN"),
+			os.Getenv("S3_ACCESS_KEY_ID"), os.Getenv("S3_SECRET_ACCESS_KEY"))
+	})
+	if err := resolver.ResolveAll(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	if err := checkKeyLayout(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// defaultKeyLayout reports whether uploads use the key layout that compact,
+// replay, verify, export and presign list
+func defaultKeyLayout(cfg *config.Config) bool {
+	return cfg.S3.KeyTemplate == "" || strings.TrimSpace(cfg.S3.KeyTemplate) == uploader.DefaultKeyTemplate
+}
+
+// checkKeyLayout rejects s3.presign with a custom s3.key_template, since it
+// would serve no files, and warns that the archive commands won't find them
+func checkKeyLayout(cfg *config.Config) error {
+	if cfg.Uploader.Mode != config.UploadModeS3 || defaultKeyLayout(cfg) {
+		return nil
+	}
+	if cfg.S3.Presign.Enabled {
+		return fmt.Errorf("s3.presign only finds files under the default key layout; remove s3.key_template or disable s3.presign")
+	}
+	cfg.Warnings = append(cfg.Warnings, "s3.key_template is not the default layout, so compact, replay, verify, export, search and presign can't find the uploaded files")
+	return nil
+}
+
+// requireDefaultKeyLayout exits if a custom s3.key_template puts files where
+// command won't look for them
+func requireDefaultKeyLayout(cfg *config.Config, command string) {
+	if !defaultKeyLayout(cfg) {
+		log.Fatalf("%s only finds files under the default key layout, not s3.key_template %q", command, cfg.S3.KeyTemplate)
+	}
+}
+
+// watchConfig polls a remote config for changes every interval until the
+// context is cancelled. Twitch channel changes are applied by joining and
+// parting; other changes, including rotated secrets, are logged and take
+// effect after a restart. A changed config that fails to load or whose
+// secrets cannot be resolved is logged and ignored.
+func watchConfig(ctx context.Context, path, etag string, interval time.Duration, current *config.Config, twitchConn *twitch.Connector) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		data, newETag, err := fetchConfig(ctx, path, etag)
+		if errors.Is(err, errConfigNotModified) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Warning: Failed to check config for changes: %v", err)
+			}
+			continue
+		}
+		etag = newETag
+
+		next, err := parseConfig(ctx, data)
+		if err != nil {
+			log.Printf("Error: Changed config is invalid, keeping the running config: %v", err)
+			continue
+		}
+		log.Printf("Config changed (ETag %s)", newETag)
+		applyConfig(current, next, twitchConn)
+		current = next
+	}
+}
+
+// applyConfig applies what can change at runtime from prev to next: the
+// static Twitch channel list
+func applyConfig(prev, next *config.Config, twitchConn *twitch.Connector) {
+	if twitchConn != nil {
+		old := make(map[string]bool)
+		for _, channel := range prev.Twitch.Channels {
+			old[strings.ToLower(channel)] = true
+		}
+		for _, channel := range next.Twitch.Channels {
+			channel = strings.ToLower(channel)
+			if !old[channel] {
+				twitchConn.Join(channel)
+			}
+			delete(old, channel)
+		}
+		for channel := range old {
+			twitchConn.Part(channel)
+		}
+	}
+
+	// Compare everything else with the applied channel list and warnings set aside
+	a, b := *prev, *next
+	if twitchConn != nil {
+		a.Twitch.Channels, b.Twitch.Channels = nil, nil
+	}
+	a.Warnings, b.Warnings = nil, nil
+	if !reflect.DeepEqual(a, b) {
+		log.Println("Warning: Config changes other than twitch.channels take effect after a restart")
+	}
+	for _, warning := range next.Warnings {
+		log.Printf("Warning: config: %s", warning)
+	}
+}
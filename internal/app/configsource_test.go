@@ -0,0 +1,73 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/uploader"
+)
+
+func TestFetchHTTPConfigRefusesLargeConfigs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("#"), maxConfigBytes+1))
+	}))
+	defer server.Close()
+
+	_, _, err := fetchHTTPConfig(context.Background(), server.URL, "")
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("err = %v, want config too large", err)
+	}
+}
+
+func TestFetchHTTPConfigRequiresHTTPS(t *testing.T) {
+	for _, path := range []string{"http://config.example.com/chatlog.yaml", "ftp://localhost/chatlog.yaml"} {
+		if _, _, err := fetchHTTPConfig(context.Background(), path, ""); err == nil || !strings.Contains(err.Error(), "https") {
+			t.Errorf("%s: err = %v, want https required", path, err)
+		}
+	}
+
+	// Redirects away from localhost must be https too
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://config.example.com/chatlog.yaml", http.StatusFound)
+	}))
+	defer server.Close()
+	if _, _, err := fetchHTTPConfig(context.Background(), server.URL, ""); err == nil || !strings.Contains(err.Error(), "https") {
+		t.Errorf("redirect: err = %v, want https required", err)
+	}
+}
+
+func TestCheckKeyLayout(t *testing.T) {
+	custom := `{{.Platform}}/{{.Channel}}/{{strftime .Time "%Y-%m-%d"}}/{{.Filename}}`
+
+	cfg := &config.Config{}
+	cfg.Uploader.Mode = config.UploadModeS3
+	cfg.S3.KeyTemplate = custom
+	if err := checkKeyLayout(cfg); err != nil {
+		t.Fatalf("checkKeyLayout: %v", err)
+	}
+	if len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], "s3.key_template") {
+		t.Errorf("warnings = %q, want one about s3.key_template", cfg.Warnings)
+	}
+
+	cfg = &config.Config{}
+	cfg.Uploader.Mode = config.UploadModeS3
+	cfg.S3.KeyTemplate = custom
+	cfg.S3.Presign.Enabled = true
+	if err := checkKeyLayout(cfg); err == nil || !strings.Contains(err.Error(), "s3.presign") {
+		t.Errorf("err = %v, want s3.presign rejected", err)
+	}
+
+	// Spelling out the default layout is fine
+	cfg = &config.Config{}
+	cfg.Uploader.Mode = config.UploadModeS3
+	cfg.S3.KeyTemplate = uploader.DefaultKeyTemplate
+	cfg.S3.Presign.Enabled = true
+	if err := checkKeyLayout(cfg); err != nil || len(cfg.Warnings) != 0 {
+		t.Errorf("default template: err = %v, warnings = %q", err, cfg.Warnings)
+	}
+}
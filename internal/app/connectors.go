@@ -0,0 +1,383 @@
+package app
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/bluesky"
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/egress"
+	"github.com/john/chatlog/internal/irc"
+	"github.com/john/chatlog/internal/kick"
+	"github.com/john/chatlog/internal/mastodon"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/pause"
+	"github.com/john/chatlog/internal/platform"
+	"github.com/john/chatlog/internal/rawlog"
+	"github.com/john/chatlog/internal/status"
+	"github.com/john/chatlog/internal/twitch"
+	"github.com/john/chatlog/internal/youtube"
+)
+
+// connectors are the configured platform connectors, shared by the full
+// pipeline and chatlog-agent
+type connectors struct {
+	twitch     *twitch.Connector
+	discoverer *twitch.Discoverer
+	kick       *kick.Connector
+	bluesky    *bluesky.Connector
+	mastodon   *mastodon.Connector
+	youtube    *youtube.Connector
+	irc        []*irc.Connector
+	custom     []platform.Connector
+}
+
+// logPlatforms logs the channels each enabled platform monitors
+func logPlatforms(cfg *config.Config) {
+	if len(cfg.Twitch.Channels) > 0 {
+		log.Printf("Monitoring %d Twitch channels: %v", len(cfg.Twitch.Channels), cfg.Twitch.Channels)
+	}
+	if cfg.Kick.Enabled && len(cfg.Kick.Channels) > 0 {
+		log.Printf("Monitoring %d Kick channels: %v", len(cfg.Kick.Channels), cfg.Kick.Channels)
+	}
+	if cfg.IRC.Enabled {
+		for _, n := range cfg.IRC.Networks {
+			log.Printf("Monitoring %d IRC channels on %s: %v", len(n.Channels), n.Name, n.Channels)
+		}
+	}
+	if cfg.Bluesky.Enabled {
+		log.Printf("Monitoring Bluesky hashtags %v and handles %v", cfg.Bluesky.Hashtags, cfg.Bluesky.Handles)
+	}
+	if cfg.Mastodon.Enabled {
+		log.Printf("Monitoring Mastodon hashtags %v and accounts %v on %s", cfg.Mastodon.Hashtags, cfg.Mastodon.Accounts, cfg.Mastodon.Server)
+	}
+	if cfg.YouTube.Enabled {
+		log.Printf("Monitoring %d YouTube channels", len(cfg.YouTube.Channels))
+	}
+}
+
+// outboundNetwork returns the network a connector's outbound settings
+// describe, or nil to connect directly with the system's DNS
+func outboundNetwork(o config.OutboundConfig) *egress.Network {
+	proxy := o.Proxy
+	if proxy == config.ProxyDirect {
+		proxy = ""
+	}
+	if proxy == "" && len(o.DNS) == 0 {
+		return nil
+	}
+	network, err := egress.New(proxy, o.DNS)
+	if err != nil {
+		log.Fatalf("Invalid outbound settings: %v", err)
+	}
+	return network
+}
+
+// newRawLog returns the raw frame log r describes for platform, or nil if
+// it has no path
+func newRawLog(platform string, r config.RawLogConfig) *rawlog.Log {
+	if r.Path == "" {
+		return nil
+	}
+	log.Printf("Writing raw %s frames to %s", platform, r.Path)
+	return rawlog.New(r.Path, r.MaxMegabytes, r.MaxFiles)
+}
+
+// newHelixClient creates a Helix client authenticated with token, reaching
+// the API through Twitch's outbound network
+func newHelixClient(cfg *config.Config, token string) *twitch.HelixClient {
+	helix := twitch.NewHelixClient(cfg.Twitch.ClientID, token)
+	if network := outboundNetwork(cfg.Twitch.Outbound); network != nil {
+		helix.EnableEgress(network)
+	}
+	return helix
+}
+
+// validateTwitchChannels replaces the configured Twitch channels with those
+// Helix knows, so typos are caught early
+func validateTwitchChannels(ctx context.Context, cfg *config.Config) {
+	if len(cfg.Twitch.Channels) == 0 || !cfg.Twitch.ValidateChannels {
+		return
+	}
+	if cfg.Twitch.Anonymous() {
+		log.Println("Warning: Skipping Twitch channel validation, which needs twitch.oauth")
+		return
+	}
+	helix := newHelixClient(cfg, cfg.Twitch.OAuth)
+	channels, err := twitch.ResolveChannels(ctx, helix, cfg.Twitch.Channels)
+	if err != nil {
+		log.Printf("Warning: Failed to validate Twitch channels: %v (joining all configured channels)", err)
+		return
+	}
+	if len(channels) == 0 {
+		log.Fatalf("None of the configured Twitch channels exist")
+	}
+	cfg.Twitch.Channels = channels
+}
+
+// newConnectors creates the connectors the configuration enables. Channels
+// pauses has parted aren't joined; pauses may be nil.
+func newConnectors(cfg *config.Config, pauses *pause.Gate) *connectors {
+	c := &connectors{}
+
+	twitchChannels := cfg.Twitch.Channels
+	if pauses != nil {
+		parted := pauses.Parted("twitch")
+		twitchChannels = slices.DeleteFunc(slices.Clone(twitchChannels), func(channel string) bool {
+			return slices.Contains(parted, strings.ToLower(channel))
+		})
+	}
+
+	if len(cfg.Twitch.Channels) > 0 || cfg.Twitch.Discovery.Enabled {
+		c.twitch = twitch.New(cfg.Twitch.Username, cfg.Twitch.OAuth, twitchChannels)
+		if network := outboundNetwork(cfg.Twitch.Outbound); network != nil {
+			log.Printf("Twitch connects through %s", network)
+			c.twitch.EnableEgress(network)
+		}
+		if l := newRawLog("Twitch", cfg.Twitch.RawLog); l != nil {
+			c.twitch.EnableRawLog(l)
+		}
+		if pauses != nil {
+			pauses.EnableJoiner("twitch", c.twitch)
+		}
+
+		if p := cfg.Twitch.Presence; p.Enabled {
+			c.twitch.EnablePresence(twitch.PresenceConfig{
+				JoinMessage: p.JoinMessage,
+				Message:     p.Message,
+				Interval:    time.Duration(p.IntervalMinutes) * time.Minute,
+				Command:     p.Command,
+				Channels:    p.Channels,
+			})
+		}
+		if cfg.Twitch.Transport == config.TwitchTransportEventSub {
+			log.Println("Reading Twitch chat through EventSub")
+			c.twitch.EnableEventSub(newHelixClient(cfg, cfg.Twitch.OAuth))
+		} else {
+			// Spread channels over the configured accounts' connections
+			var accounts []twitch.Account
+			if !cfg.Twitch.Anonymous() || len(cfg.Twitch.Accounts) == 0 {
+				accounts = append(accounts, twitch.Account{Username: cfg.Twitch.Username, OAuth: cfg.Twitch.OAuth})
+			}
+			for _, a := range cfg.Twitch.Accounts {
+				accounts = append(accounts, twitch.Account{Username: a.Username, OAuth: a.OAuth})
+			}
+			c.twitch.EnablePool(accounts, cfg.Twitch.ChannelsPerConnection)
+
+			rl := cfg.Twitch.RateLimits
+			c.twitch.EnableRateLimits(twitch.RateLimits{
+				Joins:         rl.Joins,
+				JoinWindow:    time.Duration(rl.JoinWindowSeconds) * time.Second,
+				Messages:      rl.Messages,
+				MessageWindow: time.Duration(rl.MessageWindowSeconds) * time.Second,
+				JoinTimeout:   time.Duration(rl.JoinTimeoutSeconds) * time.Second,
+			})
+		}
+	}
+
+	if cfg.Twitch.Discovery.Enabled {
+		d := cfg.Twitch.Discovery
+		log.Printf("Twitch discovery enabled: categories=%v teams=%v max=%d", d.Categories, d.Teams, d.MaxChannels)
+		c.discoverer = twitch.NewDiscoverer(
+			newHelixClient(cfg, cfg.Twitch.OAuth),
+			c.twitch,
+			cfg.Twitch.Channels,
+			twitch.DiscoveryConfig{
+				Categories:  d.Categories,
+				Teams:       d.Teams,
+				MaxChannels: d.MaxChannels,
+				Interval:    time.Duration(d.IntervalMinutes) * time.Minute,
+				Allow:       d.Allow,
+				Deny:        d.Deny,
+			},
+		)
+	}
+
+	if cfg.Kick.Enabled && len(cfg.Kick.Channels) > 0 {
+		// Convert config channels to kick.ChannelConfig
+		kickChannels := make([]kick.ChannelConfig, len(cfg.Kick.Channels))
+		for i, ch := range cfg.Kick.Channels {
+			kickChannels[i] = kick.ChannelConfig{
+				Slug:       ch.Slug,
+				ChatroomID: ch.ChatroomID,
+			}
+		}
+		c.kick = kick.New(kickChannels, cfg.Kick.PusherCluster, cfg.Kick.PusherAppKey)
+
+		resolveCache := cfg.Kick.ResolveCache
+		if resolveCache == "-" {
+			resolveCache = ""
+		}
+		c.kick.EnableResolver(kick.NewResolver(time.Duration(cfg.Kick.ResolveIntervalMs)*time.Millisecond, resolveCache))
+		c.kick.EnableResolveRetry(retryPolicy(cfg.Kick.ResolveRetry))
+		if network := outboundNetwork(cfg.Kick.Outbound); network != nil {
+			log.Printf("Kick connects through %s", network)
+			c.kick.EnableEgress(network)
+		}
+		if l := newRawLog("Kick", cfg.Kick.RawLog); l != nil {
+			c.kick.EnableRawLog(l)
+		}
+		if cfg.Kick.RenameCheckMinutes > 0 {
+			c.kick.EnableRenameCheck(time.Duration(cfg.Kick.RenameCheckMinutes) * time.Minute)
+		}
+		if cfg.Kick.MaxClockSkewSeconds > 0 {
+			c.kick.EnableSkewCheck(time.Duration(cfg.Kick.MaxClockSkewSeconds) * time.Second)
+		}
+	}
+
+	if cfg.Bluesky.Enabled {
+		c.bluesky = bluesky.New(cfg.Bluesky.JetstreamURL, cfg.Bluesky.Hashtags, cfg.Bluesky.Handles)
+		if network := outboundNetwork(cfg.Bluesky.Outbound); network != nil {
+			log.Printf("Bluesky connects through %s", network)
+			c.bluesky.EnableEgress(network)
+		}
+	}
+
+	if m := cfg.Mastodon; m.Enabled {
+		var err error
+		c.mastodon, err = mastodon.New(m.Server, m.AccessToken, m.Hashtags, m.Accounts, time.Duration(m.PollSeconds)*time.Second)
+		if err != nil {
+			log.Fatalf("Failed to create Mastodon connector: %v", err)
+		}
+		if network := outboundNetwork(m.Outbound); network != nil {
+			log.Printf("Mastodon connects through %s", network)
+			c.mastodon.EnableEgress(network)
+		}
+	}
+
+	if cfg.YouTube.Enabled {
+		var channels []youtube.Channel
+		for _, ch := range cfg.YouTube.Channels {
+			channels = append(channels, youtube.Channel{ID: ch.ID, Name: ch.Name, VideoID: ch.VideoID})
+		}
+		c.youtube = youtube.New(cfg.YouTube.APIKey, channels, time.Duration(cfg.YouTube.LiveCheckMinutes)*time.Minute)
+		if network := outboundNetwork(cfg.YouTube.Outbound); network != nil {
+			log.Printf("YouTube connects through %s", network)
+			c.youtube.EnableEgress(network)
+		}
+	}
+
+	if cfg.IRC.Enabled {
+		network := outboundNetwork(cfg.IRC.Outbound)
+		if network != nil {
+			log.Printf("IRC connects through %s", network)
+		}
+		rawLog := newRawLog("IRC", cfg.IRC.RawLog)
+		for _, n := range cfg.IRC.Networks {
+			conn := irc.New(irc.NetworkConfig{
+				Name:             n.Name,
+				Server:           n.Server,
+				Plaintext:        n.Plaintext,
+				Nick:             n.Nick,
+				Username:         n.Username,
+				Realname:         n.Realname,
+				Password:         n.Password,
+				SASLUsername:     n.SASLUsername,
+				SASLPassword:     n.SASLPassword,
+				NickServPassword: n.NickServPassword,
+				Channels:         n.Channels,
+			})
+			if network != nil {
+				conn.EnableEgress(network)
+			}
+			if rawLog != nil {
+				conn.EnableRawLog(rawLog)
+			}
+			c.irc = append(c.irc, conn)
+		}
+	}
+
+	// Connectors on the registry: platforms such as vkplay (platforms.go),
+	// those registered by programs embedding chatlog (see pkg/chatlog), and
+	// plugins run by the subprocess connector
+	for _, cc := range cfg.Connectors {
+		conn, err := platform.New(cc.Name, cc.Options)
+		if err != nil {
+			log.Fatalf("Failed to create connector: %v", err)
+		}
+		log.Printf("Custom connector enabled: %s", cc.Name)
+		if network := outboundNetwork(cc.Outbound); network != nil {
+			if e, ok := conn.(platform.EgressUser); ok {
+				log.Printf("Connector %s connects through %s", cc.Name, network)
+				e.EnableEgress(network)
+			} else {
+				log.Printf("Warning: Connector %s doesn't support outbound settings; connecting directly", cc.Name)
+			}
+		}
+		c.custom = append(c.custom, conn)
+	}
+
+	return c
+}
+
+// enableStatus reports each connector's state under its platform's name
+func (c *connectors) enableStatus(reg *status.Registry, cfg *config.Config) {
+	if c.twitch != nil {
+		c.twitch.EnableStatus(reg.Component("twitch"))
+	}
+	if c.kick != nil {
+		c.kick.EnableStatus(reg.Component("kick"))
+	}
+	if c.bluesky != nil {
+		c.bluesky.EnableStatus(reg.Component("bluesky"))
+	}
+	if c.mastodon != nil {
+		c.mastodon.EnableStatus(reg.Component("mastodon"))
+	}
+	if c.youtube != nil {
+		c.youtube.EnableStatus(reg.Component("youtube"))
+	}
+	for i, conn := range c.irc {
+		conn.EnableStatus(reg.Component("irc." + cfg.IRC.Networks[i].Name))
+	}
+	for i, conn := range c.custom {
+		if r, ok := conn.(platform.StatusReporter); ok {
+			r.EnableStatus(reg.Component(cfg.Connectors[i].Name))
+		}
+	}
+}
+
+// start runs the connectors and Twitch discovery on wg, sending records to
+// out, until the context is cancelled
+func (c *connectors) start(ctx context.Context, wg *sync.WaitGroup, cfg *config.Config, out chan<- message.Message) {
+	run := func(name string, start func(context.Context, chan<- message.Message) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := start(ctx, out); err != nil && err != context.Canceled {
+				log.Printf("%s error: %v", name, err)
+			}
+		}()
+	}
+
+	if c.twitch != nil {
+		run("Twitch connector", c.twitch.Start)
+	}
+	if c.discoverer != nil {
+		run("Twitch discovery", func(ctx context.Context, _ chan<- message.Message) error {
+			return c.discoverer.Start(ctx)
+		})
+	}
+	if c.kick != nil {
+		run("Kick connector", c.kick.Start)
+	}
+	if c.bluesky != nil {
+		run("Bluesky connector", c.bluesky.Start)
+	}
+	if c.mastodon != nil {
+		run("Mastodon connector", c.mastodon.Start)
+	}
+	if c.youtube != nil {
+		run("YouTube connector", c.youtube.Start)
+	}
+	for i, conn := range c.irc {
+		run("IRC connector ("+cfg.IRC.Networks[i].Name+")", conn.Start)
+	}
+	for i, conn := range c.custom {
+		run("Connector ("+cfg.Connectors[i].Name+")", conn.Start)
+	}
+}
@@ -0,0 +1,314 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/kick"
+	"github.com/john/chatlog/internal/twitch"
+	"github.com/john/chatlog/internal/uploader"
+)
+
+// doctorCheck is the outcome of one preflight check. A failure carries a
+// hint on what to fix.
+type doctorCheck struct {
+	name   string
+	detail string // What was found, or why the check was skipped
+	err    error
+	hint   string
+	skip   bool
+}
+
+// runDoctor implements the "doctor" subcommand, checking that the
+// configured credentials, services and directories work before a
+// deployment depends on them
+func runDoctor(args []string) {
+	path := configPath()
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	fmt.Printf("Checking %s\n", path)
+	var cfg *config.Config
+	data, _, err := fetchConfig(ctx, path, "")
+	if err == nil {
+		cfg, err = parseConfig(ctx, data)
+	}
+	if err != nil {
+		printDoctorChecks([]doctorCheck{{name: "config", err: err, hint: "run validate-config for details"}})
+		os.Exit(1)
+	}
+	os.Exit(printDoctorChecks(doctorChecks(ctx, cfg)))
+}
+
+// printDoctorChecks prints the checks and returns the exit code
+func printDoctorChecks(checks []doctorCheck) int {
+	failed := 0
+	for _, c := range checks {
+		switch {
+		case c.skip:
+			fmt.Printf("[SKIP] %s: %s\n", c.name, c.detail)
+		case c.err != nil:
+			failed++
+			fmt.Printf("[FAIL] %s: %v\n", c.name, c.err)
+			if c.hint != "" {
+				fmt.Printf("       %s\n", c.hint)
+			}
+		default:
+			fmt.Printf("[ OK ] %s: %s\n", c.name, c.detail)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d check(s) failed\n", failed, len(checks))
+		return 1
+	}
+	fmt.Println("All checks passed")
+	return 0
+}
+
+// doctorChecks runs every check that applies to the configuration
+func doctorChecks(ctx context.Context, cfg *config.Config) []doctorCheck {
+	checks := []doctorCheck{{name: "config", detail: fmt.Sprintf("%d warning(s)", len(cfg.Warnings))}}
+	checks = append(checks, checkTwitchTokens(ctx, cfg)...)
+	checks = append(checks, checkKick(ctx, cfg))
+	checks = append(checks, checkDirs(cfg)...)
+	checks = append(checks, checkOIDC(ctx, cfg))
+	checks = append(checks, checkUpload(ctx, cfg))
+	return checks
+}
+
+// checkTwitchTokens validates each configured Twitch login's OAuth token:
+// that it is live, belongs to the configured user and can read chat
+func checkTwitchTokens(ctx context.Context, cfg *config.Config) []doctorCheck {
+	accounts := []config.TwitchAccount{{Username: cfg.Twitch.Username, OAuth: cfg.Twitch.OAuth}}
+	if cfg.Twitch.Anonymous() {
+		accounts = nil
+	}
+	if cfg.Twitch.Transport == config.TwitchTransportIRC {
+		accounts = append(accounts, cfg.Twitch.Accounts...)
+	}
+	if len(accounts) == 0 {
+		return []doctorCheck{{name: "twitch oauth", skip: true, detail: "reading chat anonymously"}}
+	}
+
+	scope := "chat:read"
+	if cfg.Twitch.Transport == config.TwitchTransportEventSub {
+		scope = "user:read:chat"
+	}
+
+	var checks []doctorCheck
+	for _, account := range accounts {
+		check := doctorCheck{name: "twitch oauth (" + account.Username + ")"}
+		helix := newHelixClient(cfg, account.OAuth)
+		if err := helix.ValidateToken(ctx); err != nil {
+			check.err = err
+			check.hint = "the token is invalid or expired; generate a new one and set twitch.oauth"
+			var apiErr *twitch.APIError
+			if !errors.As(err, &apiErr) {
+				check.hint = "id.twitch.tv could not be reached; check DNS, proxies and outbound HTTPS"
+			}
+			checks = append(checks, check)
+			continue
+		}
+
+		info := helix.TokenInfo()
+		switch {
+		case !strings.EqualFold(info.Login, account.Username):
+			check.err = fmt.Errorf("token belongs to %s", info.Login)
+			check.hint = "set the username to the token's owner, or use a token issued to " + account.Username
+		case !slices.Contains(info.Scopes, scope):
+			check.err = fmt.Errorf("token lacks the %s scope (has %s)", scope, strings.Join(info.Scopes, ", "))
+			check.hint = "generate a new token with the " + scope + " scope"
+		case info.ExpiresIn > 0:
+			check.detail = fmt.Sprintf("valid, expires in %s", info.ExpiresIn.Round(time.Minute))
+		default:
+			check.detail = "valid, does not expire"
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// checkKick looks up the first configured Kick channel, to check the API
+// can be reached from this host
+func checkKick(ctx context.Context, cfg *config.Config) doctorCheck {
+	check := doctorCheck{name: "kick api"}
+	if !cfg.Kick.Enabled || len(cfg.Kick.Channels) == 0 {
+		check.skip = true
+		check.detail = "kick is not enabled"
+		return check
+	}
+
+	slug := cfg.Kick.Channels[0].Slug
+	resolver := kick.NewResolver(0, "")
+	if network := outboundNetwork(cfg.Kick.Outbound); network != nil {
+		resolver.EnableEgress(network)
+	}
+	chatroomID, _, err := resolver.Resolve(ctx, slug)
+	switch {
+	case errors.Is(err, kick.ErrNotFound):
+		check.err = fmt.Errorf("channel %s: %w", slug, err)
+		check.hint = "the API is reachable, but the slug is wrong; use the name from the channel's kick.com URL"
+	case err != nil:
+		check.err = err
+		check.hint = "kick.com blocks some hosting providers' addresses; try another region or a proxy, or set chatroom_id for each channel"
+	default:
+		check.detail = fmt.Sprintf("reachable, %s is chatroom %d", slug, chatroomID)
+	}
+	return check
+}
+
+// checkDirs checks that the directories chatlog writes to can be created
+// and written
+func checkDirs(cfg *config.Config) []doctorCheck {
+	dirs := map[string]string{
+		"recorder.output_dir":      cfg.Recorder.OutputDir,
+		"uploader.dead_letter_dir": cfg.Uploader.DeadLetterDir,
+	}
+	if cfg.Recorder.Overflow.Enabled {
+		dirs["recorder.overflow.dir"] = cfg.Recorder.Overflow.Dir
+	}
+	if cfg.Recorder.Summaries.Enabled {
+		dirs["recorder.summaries.state_dir"] = cfg.Recorder.Summaries.StateDir
+	}
+	if cfg.Uploader.Mode == config.UploadModeLocal {
+		dirs["uploader.local_dir"] = cfg.Uploader.LocalDir
+	}
+
+	var checks []doctorCheck
+	for _, name := range slices.Sorted(maps.Keys(dirs)) {
+		dir := dirs[name]
+		check := doctorCheck{name: name}
+		if err := checkWritable(dir); err != nil {
+			check.err = err
+			check.hint = "create " + dir + " and make it writable by the user chatlog runs as, or mount a volume there"
+		} else {
+			check.detail = dir + " is writable"
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// checkWritable creates dir if needed and writes, syncs and removes a file
+// in it, without leaving behind directories it created
+func checkWritable(dir string) error {
+	var created []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || d == filepath.Dir(d) {
+			break
+		}
+		created = append(created, d)
+	}
+	defer func() {
+		for _, d := range created {
+			os.Remove(d)
+		}
+	}()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, ".chatlog-doctor-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString("chatlog doctor\n"); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// checkOIDC fetches an OIDC token and exchanges it for role credentials,
+// as S3 role authentication does
+func checkOIDC(ctx context.Context, cfg *config.Config) doctorCheck {
+	check := doctorCheck{name: "oidc"}
+	if cfg.S3.RoleARN == "" || cfg.Uploader.Mode != config.UploadModeS3 {
+		check.skip = true
+		check.detail = "s3.role_arn is not set"
+		return check
+	}
+
+	if _, err := uploader.FetchOIDCToken(); err != nil {
+		check.err = fmt.Errorf("fetch token: %w", err)
+		check.hint = "check s3.oidc: without a source, tokens come from AWS_WEB_IDENTITY_TOKEN_FILE if set, or the Fly.io machine API at /.fly/api"
+		return check
+	}
+	awsCfg, err := uploader.LoadAWSConfig(ctx, cfg.S3.Region, cfg.S3.RoleARN, "", "")
+	if err == nil {
+		_, err = awsCfg.Credentials.Retrieve(ctx)
+	}
+	if err != nil {
+		check.err = fmt.Errorf("assume %s: %w", cfg.S3.RoleARN, err)
+		check.hint = "check the role's trust policy allows this app's OIDC issuer and subject, with audience sts.amazonaws.com"
+		return check
+	}
+	check.detail = "assumed " + cfg.S3.RoleARN
+	return check
+}
+
+// checkUpload writes a test object with the configured object options,
+// reads its metadata back as uploads do, and deletes it
+func checkUpload(ctx context.Context, cfg *config.Config) doctorCheck {
+	check := doctorCheck{name: "s3 upload"}
+	if cfg.Uploader.Mode != config.UploadModeS3 {
+		check.skip = true
+		check.detail = "uploader.mode is " + cfg.Uploader.Mode
+		return check
+	}
+
+	client, err := newS3Client(ctx, cfg)
+	if err != nil {
+		check.err = err
+		check.hint = "check the S3 credentials and region"
+		return check
+	}
+
+	hostname, _ := os.Hostname()
+	key := fmt.Sprintf(".chatlog-doctor/%s-%d", hostname, time.Now().UnixNano())
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(cfg.S3.Bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader("chatlog doctor\n"),
+	}
+	objectOptions(cfg).Apply(input)
+	if _, err := client.PutObject(ctx, input); err != nil {
+		check.err = fmt.Errorf("put %s: %w", key, err)
+		check.hint = "grant s3:PutObject (and s3:PutObjectTagging with tags, kms:GenerateDataKey with aws:kms) on " + cfg.S3.Bucket + " and check s3.bucket and s3.region"
+		return check
+	}
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(cfg.S3.Bucket), Key: aws.String(key)})
+	if err != nil {
+		check.err = fmt.Errorf("head %s: %w", key, err)
+		check.hint = "grant s3:GetObject on " + cfg.S3.Bucket + "; uploads read back each object to verify it"
+	}
+
+	_, delErr := client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(cfg.S3.Bucket), Key: aws.String(key)})
+	switch {
+	case check.err != nil:
+	case delErr != nil:
+		check.detail = fmt.Sprintf("writable; could not delete the test object %s (%v), remove it by hand", key, delErr)
+	default:
+		check.detail = "wrote and deleted a test object in " + cfg.S3.Bucket
+	}
+	return check
+}
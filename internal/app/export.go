@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/export"
+	"github.com/john/chatlog/internal/replay"
+)
+
+// runExport implements the "export" subcommand, rendering a channel and time
+// range from the archive as a CSV, plain text or HTML transcript
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	platform := fs.String("platform", "twitch", "Platform of the channel")
+	channel := fs.String("channel", "", "Channel to export (required)")
+	start := fs.String("start", "", "Start time (RFC3339), required for S3")
+	end := fs.String("end", "", "End time (RFC3339)")
+	format := fs.String("format", "text", "Output format: "+strings.Join(export.Formats, ", "))
+	tz := fs.String("timezone", "UTC", "Time zone for displayed timestamps, e.g. America/New_York")
+	out := fs.String("out", "", "Write to this file instead of stdout")
+	dir := fs.String("dir", "", "Read from a local directory instead of S3")
+	identity := fs.String("identity", "", "age identity file for decrypting encrypted (.age) archives")
+	fs.Parse(args)
+
+	if *channel == "" {
+		log.Fatalf("-channel is required")
+	}
+
+	location, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatalf("Invalid -timezone %q: %v", *tz, err)
+	}
+
+	filter := replay.Filter{
+		Platform: *platform,
+		Channel:  strings.ToLower(*channel),
+	}
+	if *start != "" {
+		if filter.Start, err = time.Parse(time.RFC3339, *start); err != nil {
+			log.Fatalf("Invalid -start: %v", err)
+		}
+	}
+	if *end != "" {
+		if filter.End, err = time.Parse(time.RFC3339, *end); err != nil {
+			log.Fatalf("Invalid -end: %v", err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	messages, err := replay.Load(ctx, archiveSource(ctx, *dir, *identity, filter), filter)
+	if err != nil {
+		log.Fatalf("Failed to load messages: %v", err)
+	}
+	log.Printf("Loaded %d message(s) for %s/%s", len(messages), filter.Platform, filter.Channel)
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	transcript := export.Transcript{
+		Platform: filter.Platform,
+		Channel:  filter.Channel,
+		Start:    filter.Start,
+		End:      filter.End,
+		Location: location,
+		Messages: messages,
+	}
+	if err := export.Write(w, *format, transcript); err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	if *out != "" {
+		log.Printf("Wrote %s", *out)
+	}
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/importer"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/optout"
+	"github.com/john/chatlog/internal/recorder"
+)
+
+// runImport implements the "import" subcommand, converting third-party logs
+// to chatlog's JSONL schema and uploading them like recorded files
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "Input format: justlog, chatterino or irc (required)")
+	platform := fs.String("platform", "twitch", "Platform recorded on imported messages")
+	channel := fs.String("channel", "", "Channel name, overriding any channel found in the logs")
+	tz := fs.String("timezone", "Local", "Time zone of Chatterino timestamps")
+	out := fs.String("out", "", "Write converted files to this directory instead of uploading")
+	fs.Usage = func() {
+		log.Printf("Usage: chatlog import -format FORMAT [flags] FILE|DIR...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *format == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	location, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatalf("Invalid -timezone %q: %v", *tz, err)
+	}
+	opts := importer.Options{
+		Format:   *format,
+		Platform: *platform,
+		Channel:  *channel,
+		Location: location,
+	}
+
+	// Expand directories into the files they contain
+	var paths []string
+	for _, arg := range fs.Args() {
+		err := filepath.WalkDir(arg, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", arg, err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Messages are written as they are read; a file that fails to parse
+	// partway keeps what was read before the error
+	conv := newConverter(ctx, "import", *out)
+	for _, path := range paths {
+		var writeErr error
+		err := importer.ReadFile(path, opts, func(msg message.Message) error {
+			writeErr = conv.Add(msg)
+			return writeErr
+		})
+		if writeErr != nil {
+			conv.Abort()
+			log.Fatalf("Failed to write converted file: %v", writeErr)
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to read all of %s: %v", path, err)
+		}
+	}
+	if conv.Messages() == 0 {
+		conv.Abort()
+		log.Fatalf("No messages found in %d file(s)", len(paths))
+	}
+	log.Printf("Converted %d message(s) from %d file(s)", conv.Messages(), len(paths))
+
+	conv.Finish(ctx)
+}
+
+// converter streams converted messages into recorder-style files and
+// uploads them, or only writes them to an -out directory. Opted-out users
+// are dropped or anonymized as the recorder would; high-volume sampling
+// isn't applied, since it measures live message rates.
+type converter struct {
+	command  string // Names the subcommand in messages
+	out      string
+	dir      string
+	cfg      *config.Config // nil when only writing to out without a config
+	optOuts  *optout.List
+	writer   *importer.Writer
+	messages int
+	dropped  int
+}
+
+// newConverter loads the config, needed to upload and for the opt-out
+// list, and creates the staging directory, or out if set. Writing to out
+// works without a config, but then applies no opt-outs.
+func newConverter(ctx context.Context, command, out string) *converter {
+	c := &converter{command: command, out: out}
+	path := configPath()
+	if _, err := os.Stat(path); out == "" || remoteConfig(path) || err == nil {
+		c.cfg = loadConfig()
+	} else {
+		log.Printf("Warning: No config at %s, so opt-outs are not applied", path)
+	}
+	if out == "" && c.cfg.Uploader.Mode == config.UploadModeNone {
+		log.Fatalf("%s needs uploader.mode s3 or local; use -out to only convert", command)
+	}
+	if c.cfg != nil && c.cfg.OptOut.Source != "" {
+		c.optOuts = optout.New(c.cfg.OptOut.Source, c.cfg.OptOut.Action, 0)
+		if err := c.optOuts.Load(ctx); err != nil {
+			log.Fatalf("Failed to load opt-out list: %v", err)
+		}
+	}
+
+	var err error
+	c.dir = out
+	if c.dir == "" {
+		if c.dir, err = os.MkdirTemp("", "chatlog-"+command+"-"); err != nil {
+			log.Fatalf("Failed to create staging directory: %v", err)
+		}
+	} else if err := os.MkdirAll(c.dir, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", c.dir, err)
+	}
+	c.writer = importer.NewWriter(c.dir)
+	return c
+}
+
+// Add writes a converted message, unless its author opted out
+func (c *converter) Add(msg message.Message) error {
+	if c.optOuts != nil {
+		var ok bool
+		if msg, ok = c.optOuts.Apply(msg); !ok {
+			c.dropped++
+			return nil
+		}
+	}
+	c.messages++
+	return c.writer.Write(msg)
+}
+
+// Messages returns how many messages have been written
+func (c *converter) Messages() int {
+	return c.messages
+}
+
+// Abort closes the files and removes the staging directory, before
+// exiting with an error
+func (c *converter) Abort() {
+	c.writer.Close()
+	if c.out == "" {
+		os.RemoveAll(c.dir)
+	}
+}
+
+// Finish closes the files and uploads them, unless they were only to be
+// written to out
+func (c *converter) Finish(ctx context.Context) {
+	files, err := c.writer.Close()
+	if err != nil {
+		c.Abort()
+		log.Fatalf("Failed to write converted file: %v", err)
+	}
+	if c.dropped > 0 {
+		log.Printf("Dropped %d message(s) from opted-out users", c.dropped)
+	}
+
+	if c.out != "" {
+		log.Printf("Wrote %d file(s) to %s", len(files), c.dir)
+		return
+	}
+	defer os.RemoveAll(c.dir)
+
+	up, err := newUploader(ctx, c.cfg)
+	if err != nil {
+		os.RemoveAll(c.dir) // Fatalf skips deferred cleanup
+		log.Fatalf("Failed to create uploader: %v", err)
+	}
+
+	var failed int
+	for _, path := range files {
+		info, err := recorder.ParseFileInfo(path)
+		if err == nil {
+			err = up.Upload(ctx, info)
+		}
+		if err != nil {
+			log.Printf("Error: %v", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		os.RemoveAll(c.dir)
+		log.Fatalf("%s finished with %d of %d file(s) failing to upload", c.command, failed, len(files))
+	}
+	log.Printf("Uploaded %d file(s)", len(files))
+}
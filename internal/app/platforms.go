@@ -0,0 +1,8 @@
+package app
+
+// Platforms built on the connector registry (internal/platform). Each
+// registers itself from an init function and is enabled under connectors
+// in the config, so adding one takes a package and an import here.
+import (
+	_ "github.com/john/chatlog/internal/vkplay"
+)
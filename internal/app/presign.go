@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/config"
+)
+
+// runPresign implements the "presign" subcommand, printing time-limited
+// download URLs for a channel's archived files on a day
+func runPresign(args []string) {
+	fs := flag.NewFlagSet("presign", flag.ExitOnError)
+	platform := fs.String("platform", "twitch", "Platform of the channel")
+	channel := fs.String("channel", "", "Channel name (required)")
+	date := fs.String("date", time.Now().UTC().Format("2006-01-02"), "UTC day of the files (YYYY-MM-DD)")
+	expires := fs.Duration("expires", time.Hour, "How long the URLs stay valid (at most 168h)")
+	jsonOut := fs.Bool("json", false, "Write the files as JSON")
+	fs.Parse(args)
+
+	if *channel == "" {
+		log.Fatalf("-channel is required")
+	}
+	day, err := time.Parse("2006-01-02", *date)
+	if err != nil {
+		log.Fatalf("Invalid -date: %v", err)
+	}
+
+	cfg := loadConfig()
+	if cfg.Uploader.Mode != config.UploadModeS3 {
+		log.Fatalf("presign requires uploader.mode s3")
+	}
+	requireDefaultKeyLayout(cfg, "presign")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	signer, err := newSigner(ctx, cfg, 7*24*time.Hour)
+	if err != nil {
+		log.Fatalf("Failed to create S3 client: %v", err)
+	}
+
+	files, err := signer.Files(ctx, *platform, strings.ToLower(*channel), day, *expires)
+	if err != nil {
+		log.Fatalf("Presign failed: %v", err)
+	}
+	if len(files) == 0 {
+		log.Fatalf("No files found for %s/%s on %s", *platform, *channel, day.Format("2006-01-02"))
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(files); err != nil {
+			log.Fatalf("Failed to write files: %v", err)
+		}
+		return
+	}
+	for _, f := range files {
+		fmt.Printf("%s (%d bytes, expires %s)\n%s\n\n", f.Key, f.Size, f.Expires.Format(time.RFC3339), f.URL)
+	}
+}
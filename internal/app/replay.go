@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"filippo.io/age"
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/replay"
+)
+
+// runReplay implements the "replay" subcommand, re-emitting archived chat
+// with its original timing
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	platform := fs.String("platform", "twitch", "Platform of the channel")
+	channel := fs.String("channel", "", "Channel to replay (required)")
+	start := fs.String("start", "", "Start time (RFC3339), required for S3")
+	end := fs.String("end", "", "End time (RFC3339)")
+	speedFlag := fs.String("speed", "1x", "Playback speed, e.g. 2x or 0.5")
+	dir := fs.String("dir", "", "Read from a local directory instead of S3")
+	format := fs.String("format", "text", "Output format for stdout: json, irc or text")
+	listen := fs.String("listen", "", "Serve a WebSocket at ws://ADDR/ws instead of writing to stdout")
+	identity := fs.String("identity", "", "age identity file for decrypting encrypted (.age) archives")
+	fs.Parse(args)
+
+	if *channel == "" {
+		log.Fatalf("-channel is required")
+	}
+
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(*speedFlag, "x"), 64)
+	if err != nil || speed <= 0 {
+		log.Fatalf("Invalid -speed %q", *speedFlag)
+	}
+
+	filter := replay.Filter{
+		Platform: *platform,
+		Channel:  strings.ToLower(*channel),
+	}
+	if *start != "" {
+		if filter.Start, err = time.Parse(time.RFC3339, *start); err != nil {
+			log.Fatalf("Invalid -start: %v", err)
+		}
+	}
+	if *end != "" {
+		if filter.End, err = time.Parse(time.RFC3339, *end); err != nil {
+			log.Fatalf("Invalid -end: %v", err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	source := archiveSource(ctx, *dir, *identity, filter)
+
+	messages, err := replay.Load(ctx, source, filter)
+	if err != nil {
+		log.Fatalf("Failed to load messages: %v", err)
+	}
+	log.Printf("Loaded %d message(s) for %s/%s", len(messages), filter.Platform, filter.Channel)
+
+	var sink replay.Sink
+	if *listen != "" {
+		ws := replay.NewWebSocketSink(*listen)
+		go func() {
+			if err := ws.Start(); err != nil {
+				log.Fatalf("WebSocket server error: %v", err)
+			}
+		}()
+		defer ws.Shutdown(context.Background())
+
+		// Wait for the first viewer so playback doesn't start into the void
+		log.Println("Waiting for a WebSocket client to connect...")
+		for ws.ClientCount() == 0 {
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-ctx.Done():
+				return
+			}
+		}
+		sink = ws
+	} else {
+		sink, err = replay.NewWriterSink(os.Stdout, *format)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	if err := replay.Play(ctx, messages, speed, sink); err != nil && err != context.Canceled {
+		log.Fatalf("Replay failed: %v", err)
+	}
+}
+
+// archiveSource returns the archive to read: a local directory if dir is
+// set, otherwise the bucket the filter's channel uploads to (its group's,
+// if it has one, or s3.bucket), decrypting .age files with the identities
+// in identityFile if given
+func archiveSource(ctx context.Context, dir, identityFile string, filter replay.Filter) replay.Source {
+	var source replay.Source
+	if dir != "" {
+		source = replay.LocalSource{Dir: dir}
+	} else {
+		cfg := loadConfig()
+		if cfg.Uploader.Mode != config.UploadModeS3 {
+			log.Fatalf("Reading from S3 requires uploader.mode s3; use -dir for local files")
+		}
+		requireDefaultKeyLayout(cfg, "Reading from S3")
+		if group := cfg.Group(filter.Platform, filter.Channel); group != nil && group.S3 != nil {
+			s3Client, err := groupS3Client(ctx, cfg, group.S3)
+			if err != nil {
+				log.Fatalf("Failed to create S3 client for group %s: %v", group.Name, err)
+			}
+			source = replay.S3Source{Client: s3Client, Bucket: group.S3.Bucket, Prefixes: []string{group.KeyPrefix}}
+		} else {
+			s3Client, err := newS3Client(ctx, cfg)
+			if err != nil {
+				log.Fatalf("Failed to create S3 client: %v", err)
+			}
+			source = replay.S3Source{Client: s3Client, Bucket: cfg.S3.Bucket, Prefixes: cfg.KeyPrefixes()}
+		}
+	}
+
+	if identityFile != "" {
+		identities, err := readIdentities(identityFile)
+		if err != nil {
+			log.Fatalf("Failed to read -identity: %v", err)
+		}
+		source = replay.DecryptSource{Source: source, Identities: identities}
+	}
+	return source
+}
+
+// readIdentities reads age identities (private keys) from a file
+func readIdentities(path string) ([]age.Identity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return age.ParseIdentities(file)
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/uploader"
+)
+
+// runRetryFailed implements the "retry-failed" subcommand, uploading files
+// that were moved to the dead-letter directory after failing all retries
+func runRetryFailed(args []string) {
+	cfg := loadConfig()
+	if cfg.Uploader.Mode == config.UploadModeNone {
+		log.Fatalf("retry-failed requires uploader.mode s3 or local")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	up, err := newUploader(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create uploader: %v", err)
+	}
+	up.EnableDeadLetter(cfg.Uploader.DeadLetterDir)
+
+	retried, failed, err := up.RetryFailed(ctx)
+	if err != nil {
+		log.Fatalf("Retry failed: %v", err)
+	}
+	log.Printf("Retried %d file(s) from %s, %d still failing", retried, cfg.Uploader.DeadLetterDir, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// enableDeadLetterRetry has up retry dead-lettered files as they come due,
+// if configured
+func enableDeadLetterRetry(up *uploader.Uploader, cfg *config.Config) {
+	r := cfg.Uploader.DeadLetterRetry
+	if !r.Enabled || cfg.Uploader.Mode == config.UploadModeNone {
+		return
+	}
+	up.EnableDeadLetterRetry(time.Duration(r.BaseMinutes)*time.Minute, time.Duration(r.MaxHours)*time.Hour, time.Duration(r.MaxAgeHours)*time.Hour)
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/replay"
+	"github.com/john/chatlog/internal/search"
+)
+
+// runSearch implements the "search" subcommand, printing archived messages
+// by a user or containing terms. Files whose search index sidecar rules
+// them out are skipped without being read.
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	platform := fs.String("platform", "twitch", "Platform of the channel")
+	channel := fs.String("channel", "", "Channel to search (required)")
+	start := fs.String("start", "", "Start time (RFC3339), required for S3")
+	end := fs.String("end", "", "End time (RFC3339)")
+	user := fs.String("user", "", "Only messages by this user")
+	var terms []string
+	fs.Func("term", "Only messages containing this term (repeatable; all must match)", func(s string) error {
+		terms = append(terms, s)
+		return nil
+	})
+	format := fs.String("format", "json", "Output format: json, irc or text")
+	dir := fs.String("dir", "", "Read from a local directory instead of S3")
+	identity := fs.String("identity", "", "age identity file for decrypting encrypted (.age) archives")
+	fs.Parse(args)
+
+	if *channel == "" {
+		log.Fatalf("-channel is required")
+	}
+	if *user == "" && len(terms) == 0 {
+		log.Fatalf("-user or -term is required")
+	}
+
+	sink, err := replay.NewWriterSink(os.Stdout, *format)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	filter := replay.Filter{
+		Platform: *platform,
+		Channel:  strings.ToLower(*channel),
+	}
+	if *start != "" {
+		if filter.Start, err = time.Parse(time.RFC3339, *start); err != nil {
+			log.Fatalf("Invalid -start: %v", err)
+		}
+	}
+	if *end != "" {
+		if filter.End, err = time.Parse(time.RFC3339, *end); err != nil {
+			log.Fatalf("Invalid -end: %v", err)
+		}
+	}
+	query := search.Query{User: *user, Terms: terms, Start: filter.Start, End: filter.End}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	source := archiveSource(ctx, *dir, *identity, filter)
+	files, err := source.Files(ctx, filter)
+	if err != nil {
+		log.Fatalf("Failed to list files: %v", err)
+	}
+
+	var candidates []string
+	unindexed := 0
+	for _, name := range files {
+		idx, ok := readSearchIndex(ctx, source, name)
+		if !ok {
+			unindexed++
+		} else if !idx.MayMatch(query) {
+			continue
+		}
+		candidates = append(candidates, name)
+	}
+	log.Printf("Reading %d of %d file(s) for %s/%s (%d without a search index)", len(candidates), len(files), filter.Platform, filter.Channel, unindexed)
+
+	messages, err := replay.LoadFiles(ctx, source, candidates, filter)
+	if err != nil {
+		log.Fatalf("Failed to load messages: %v", err)
+	}
+	matched := 0
+	for _, msg := range messages {
+		if !query.Matches(msg) {
+			continue
+		}
+		if err := sink.Emit(msg); err != nil {
+			log.Fatalf("Failed to write: %v", err)
+		}
+		matched++
+	}
+	log.Printf("Found %d matching message(s)", matched)
+}
+
+// readSearchIndex reads the search index sidecar of a chat file. It reports
+// false if there is none or it can't be read, in which case the file must
+// be read to be searched.
+func readSearchIndex(ctx context.Context, source replay.Source, name string) (*search.Index, bool) {
+	rc, err := source.Open(ctx, search.IndexName(name))
+	if err != nil {
+		return nil, false
+	}
+	defer rc.Close()
+
+	var idx search.Index
+	if err := json.NewDecoder(rc).Decode(&idx); err != nil || idx.Type != search.TypeIndex {
+		return nil, false
+	}
+	return &idx, true
+}
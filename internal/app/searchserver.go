@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/recorder"
+	"github.com/john/chatlog/internal/search"
+)
+
+// runSearchServer implements the "search-server" subcommand, indexing the
+// recent chat in the recorder's local files as they grow and answering
+// searches of it over HTTP until interrupted
+func runSearchServer(args []string) {
+	fs := flag.NewFlagSet("search-server", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8082", "Address to listen on; other than loopback requires CHATLOG_SEARCH_TOKEN")
+	hours := fs.Int("hours", 24, "Hours of chat to keep indexed")
+	dir := fs.String("dir", "", "Directory of chat files (default recorder.output_dir)")
+	interval := fs.Duration("interval", 10*time.Second, "How often to read new chat")
+	fs.Parse(args)
+
+	if *hours < 1 {
+		log.Fatalf("-hours must be at least 1")
+	}
+	token := os.Getenv("CHATLOG_SEARCH_TOKEN")
+	if token == "" && !isLoopback(*addr) {
+		log.Fatalf("CHATLOG_SEARCH_TOKEN is required to serve searches on %s; set it or listen on a loopback address such as 127.0.0.1:8082", *addr)
+	}
+	if *interval <= 0 {
+		log.Fatalf("-interval must be positive")
+	}
+	if *dir == "" {
+		cfg := loadConfig()
+		*dir = cfg.Recorder.OutputDir
+		if cfg.Recorder.Seekable.Enabled || len(cfg.Recorder.Encryption.Recipients) > 0 {
+			log.Printf("Warning: Only plain .jsonl files are indexed, and the recorder writes seekable or encrypted files")
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	recent := search.NewRecent(time.Duration(*hours) * time.Hour)
+	tail := func() {
+		paths, err := recorder.LocalFiles(*dir)
+		if err != nil {
+			log.Printf("Warning: Failed to list %s: %v", *dir, err)
+			return
+		}
+		if err := recent.Tail(paths); err != nil {
+			log.Printf("Warning: Failed to index new chat: %v", err)
+		}
+	}
+	tail()
+	log.Printf("Indexed %d messages from the last %d hours in %s", recent.Messages(), *hours, *dir)
+	go func() {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				tail()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	server := search.NewServer(recent)
+	if token != "" {
+		server.EnableToken(token)
+	}
+
+	httpServer := &http.Server{Addr: *addr, Handler: server.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving searches on %s", *addr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Search server error: %v", err)
+	}
+}
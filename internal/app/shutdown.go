@@ -0,0 +1,101 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/status"
+)
+
+// shutdownProgress runs the phases of a graceful shutdown against one
+// deadline, logging what is left to drain as they go and reporting it as
+// the "shutdown" status component, which /health serves until the end
+type shutdownProgress struct {
+	timeout  time.Duration
+	interval time.Duration
+	started  time.Time
+	probes   []shutdownProbe
+	status   *status.Component
+}
+
+// shutdownProbe counts the work left in one part of the pipeline
+type shutdownProbe struct {
+	name  string
+	count func() int64
+}
+
+// newShutdownProgress starts the shutdown clock with the configured timeout
+func newShutdownProgress(cfg *config.Config, comp *status.Component) *shutdownProgress {
+	comp.SetState(status.StateStopping)
+	return &shutdownProgress{
+		timeout:  time.Duration(cfg.Shutdown.TimeoutSeconds) * time.Second,
+		interval: time.Duration(cfg.Shutdown.ProgressSeconds) * time.Second,
+		started:  time.Now(),
+		status:   comp,
+	}
+}
+
+// watch adds a count of remaining work to progress reports
+func (p *shutdownProgress) watch(name string, count func() int64) {
+	p.probes = append(p.probes, shutdownProbe{name: name, count: count})
+}
+
+// wait waits for phase's goroutines in wg, reporting progress every
+// interval. It returns false if the timeout passes first.
+func (p *shutdownProgress) wait(phase string, wg *sync.WaitGroup) bool {
+	p.status.Set("phase", phase)
+	log.Printf("Shutdown: %s... (%s)", phase, p.report())
+	phaseStarted := time.Now()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	deadline := time.NewTimer(time.Until(p.started.Add(p.timeout)))
+	defer deadline.Stop()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			log.Printf("Shutdown: %s took %s", phase, time.Since(phaseStarted).Round(time.Millisecond))
+			return true
+		case <-ticker.C:
+			log.Printf("Shutdown: still %s after %s, %s left: %s", phase,
+				time.Since(phaseStarted).Round(time.Second), p.remaining().Round(time.Second), p.report())
+		case <-deadline.C:
+			log.Printf("Shutdown timeout of %s exceeded while %s: %s", p.timeout, phase, p.report())
+			return false
+		}
+	}
+}
+
+// remaining returns how long is left before the timeout
+func (p *shutdownProgress) remaining() time.Duration {
+	return max(p.timeout-time.Since(p.started), 0)
+}
+
+// report counts the remaining work as key=value pairs, and records the
+// counts on the status component
+func (p *shutdownProgress) report() string {
+	p.status.Set("remaining_seconds", int64(p.remaining().Seconds()))
+	pairs := make([]string, len(p.probes))
+	for i, probe := range p.probes {
+		n := probe.count()
+		p.status.Set(probe.name, n)
+		pairs[i] = fmt.Sprintf("%s=%d", probe.name, n)
+	}
+	return strings.Join(pairs, " ")
+}
+
+// finish records that every phase completed
+func (p *shutdownProgress) finish() {
+	log.Printf("Shutdown: drained in %s", time.Since(p.started).Round(time.Millisecond))
+	p.status.SetState(status.StateStopped)
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/recorder"
+	"github.com/john/chatlog/internal/stats"
+	"github.com/john/chatlog/internal/status"
+	"github.com/john/chatlog/internal/tenant"
+	"github.com/john/chatlog/internal/uploader"
+)
+
+// tenantConfig derives the settings of a tenant's recorder and uploader:
+// the shared ones, with the tenant's directory, key prefix and bucket.
+// Upload notifications are left out, since their targets are shared.
+func tenantConfig(cfg *config.Config, t config.Tenant) *config.Config {
+	tc := *cfg
+	tc.Recorder.OutputDir = t.OutputDir
+	tc.Recorder.Summaries.StateDir = filepath.Join(t.OutputDir, "users")
+	if cfg.Recorder.RotationIndex != "" {
+		tc.Recorder.RotationIndex = filepath.Join(t.OutputDir, filepath.Base(cfg.Recorder.RotationIndex))
+	}
+	tc.Uploader.DeadLetterDir = filepath.Join(t.OutputDir, "failed")
+	tc.Uploader.UploadIndex = filepath.Join(t.OutputDir, "uploaded.index")
+	tc.Uploader.Notify = config.NotifyConfig{}
+	tc.Groups = []config.ChannelGroup{{Name: t.Name, Channels: t.Channels, KeyPrefix: t.KeyPrefix}}
+
+	if d := t.S3; d != nil {
+		tc.Uploader.Mode = config.UploadModeS3
+		tc.S3.Bucket = d.Bucket
+		tc.S3.Region = cmp.Or(d.Region, cfg.S3.Region)
+		tc.S3.Endpoint = cmp.Or(d.Endpoint, cfg.S3.Endpoint)
+		if d.RoleARN != "" || d.AccessKeyID != "" {
+			tc.S3.RoleARN, tc.S3.AccessKeyID, tc.S3.SecretAccessKey = d.RoleARN, d.AccessKeyID, d.SecretAccessKey
+		}
+		if d.ServerSideEncryption != "" {
+			tc.S3.ServerSideEncryption, tc.S3.KMSKeyID = d.ServerSideEncryption, d.KMSKeyID
+		}
+	}
+	return &tc
+}
+
+// newTenants creates a recorder and uploader per tenant, recovering and
+// queueing the files each left behind, under a supervisor that routes
+// records to them. statsRegistry is nil when messages are counted before
+// the recorders.
+func newTenants(ctx context.Context, cfg *config.Config, statusRegistry *status.Registry, statsRegistry *stats.Registry) *tenant.Supervisor {
+	sup := tenant.New(func(platform, channel string) string {
+		if t := cfg.Tenant(platform, channel); t != nil {
+			return t.Name
+		}
+		return ""
+	}, cfg.Recorder.BufferSize)
+
+	for _, t := range cfg.Tenants {
+		tc := tenantConfig(cfg, t)
+
+		rec := newRecorder(tc)
+		rec.EnableStatus(statusRegistry.Component("recorder/" + t.Name))
+		if statsRegistry != nil {
+			rec.EnableStats(statsRegistry)
+		}
+
+		up, err := newUploader(ctx, tc)
+		if err != nil {
+			log.Fatalf("Failed to create uploader for tenant %s: %v", t.Name, err)
+		}
+		up.EnableStatus(statusRegistry.Component("uploader/" + t.Name))
+		up.EnableDeadLetter(tc.Uploader.DeadLetterDir)
+		enableDeadLetterRetry(up, tc)
+		if tc.Uploader.Mode != config.UploadModeNone && !tc.Uploader.DeleteAfterUpload {
+			if err := up.EnableUploadIndex(tc.Uploader.UploadIndex); err != nil {
+				log.Printf("Warning: Tenant %s startup scans will check every kept file against the bucket: %v", t.Name, err)
+			}
+		}
+		if tc.Recorder.Layout == config.RecorderLayoutNested {
+			up.EnableDirPruning(tc.Recorder.OutputDir)
+		}
+		if sched := tc.Uploader.Schedule; len(sched.Windows) > 0 {
+			up.EnableSchedule(newUploadSchedule(sched))
+		}
+
+		if err := recorder.Recover(tc.Recorder.OutputDir); err != nil {
+			log.Printf("Warning: Failed to recover tenant %s files from the previous run: %v", t.Name, err)
+		}
+		var resumed []string
+		if tc.Recorder.AppendAfterRestart {
+			rec.EnableAppend()
+			if resumed, err = rec.Resume(); err != nil {
+				log.Printf("Warning: Failed to resume tenant %s files from the previous run: %v", t.Name, err)
+			}
+		}
+		if tc.Uploader.Mode != config.UploadModeNone {
+			scan := uploader.ScanOptions{
+				Patterns:  tc.Uploader.Scan.Patterns,
+				Ignore:    tc.Uploader.Scan.Ignore,
+				SkipDirs:  []string{tc.Uploader.DeadLetterDir, tc.Recorder.Summaries.StateDir},
+				SkipFiles: resumed,
+			}
+			if err := up.ScanAndUploadExisting(ctx, tc.Recorder.OutputDir, scan); err != nil {
+				log.Printf("Warning: Failed to scan tenant %s for existing files: %v", t.Name, err)
+			}
+		}
+
+		sup.Add(t.Name, t.Labels, tenant.Filter{Types: t.Filters.Types, ExcludeUsers: t.Filters.ExcludeUsers}, rec, up)
+		log.Printf("Tenant %s recording %s to %s", t.Name, strings.Join(t.Channels, ", "), t.OutputDir)
+	}
+	return sup
+}
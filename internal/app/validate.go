@@ -0,0 +1,59 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/john/chatlog/internal/platform"
+	"github.com/john/chatlog/internal/sink"
+)
+
+// runValidateConfig implements the "validate-config" subcommand, checking a
+// config file and reporting errors and warnings without starting anything
+func runValidateConfig(args []string) {
+	path := os.Getenv("CONFIG_PATH")
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		path = "config.yaml"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	data, _, err := fetchConfig(ctx, path, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: error: %v\n", path, err)
+		os.Exit(1)
+	}
+	cfg, err := parseConfig(ctx, data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: error: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	if _, err := newEnrichment(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: error: enrich: %v\n", path, err)
+		os.Exit(1)
+	}
+	for _, c := range cfg.Connectors {
+		if !slices.Contains(platform.Names(), c.Name) {
+			fmt.Fprintf(os.Stderr, "%s: error: connectors: %q is not registered in this build\n", path, c.Name)
+			os.Exit(1)
+		}
+	}
+	for _, c := range cfg.Sinks.Custom {
+		if !slices.Contains(sink.Names(), c.Name) {
+			fmt.Fprintf(os.Stderr, "%s: error: sinks.custom: %q is not registered in this build\n", path, c.Name)
+			os.Exit(1)
+		}
+	}
+
+	for _, warning := range cfg.Warnings {
+		fmt.Printf("%s: warning: %s\n", path, warning)
+	}
+	fmt.Printf("%s: OK (%d warning(s))\n", path, len(cfg.Warnings))
+}
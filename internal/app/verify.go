@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/config"
+	"github.com/john/chatlog/internal/verify"
+)
+
+// runVerify implements the "verify" subcommand, checking the S3 archive for
+// a time range for coverage gaps, unreadable records and files that were
+// never uploaded. It exits with status 1 if any problems are found.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	start := fs.String("start", today.AddDate(0, 0, -1).Format("2006-01-02"), "Start of the range (YYYY-MM-DD or RFC3339)")
+	end := fs.String("end", today.Format("2006-01-02"), "End of the range (YYYY-MM-DD or RFC3339)")
+	platform := fs.String("platform", "", "Only check this platform")
+	channel := fs.String("channel", "", "Only check this channel")
+	maxGap := fs.Duration("max-gap", time.Hour, "Report stretches longer than this with no archived messages")
+	identity := fs.String("identity", "", "age identity file for decrypting encrypted (.age) archives")
+	local := fs.Bool("local", true, "Compare against files in recorder.output_dir and uploader.dead_letter_dir")
+	jsonOut := fs.Bool("json", false, "Write the report as JSON")
+	fs.Parse(args)
+
+	startTime, err := parseVerifyTime(*start)
+	if err != nil {
+		log.Fatalf("Invalid -start: %v", err)
+	}
+	endTime, err := parseVerifyTime(*end)
+	if err != nil {
+		log.Fatalf("Invalid -end: %v", err)
+	}
+	if !endTime.After(startTime) {
+		log.Fatalf("-end must be after -start")
+	}
+
+	cfg := loadConfig()
+	if cfg.Uploader.Mode != config.UploadModeS3 {
+		log.Fatalf("verify requires uploader.mode s3")
+	}
+	requireDefaultKeyLayout(cfg, "verify")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	buckets, err := archiveBuckets(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create S3 client: %v", err)
+	}
+
+	v := verify.New(buckets[0].client, buckets[0].bucket, *maxGap)
+	v.EnablePrefixes(buckets[0].prefixes)
+	for _, b := range buckets[1:] {
+		v.AddBucket(b.client, b.bucket, b.prefixes)
+	}
+	if *identity != "" {
+		identities, err := readIdentities(*identity)
+		if err != nil {
+			log.Fatalf("Failed to read -identity: %v", err)
+		}
+		v.EnableDecryption(identities)
+	}
+	if *local {
+		v.EnableLocal(cfg.Recorder.OutputDir, cfg.Uploader.DeadLetterDir)
+	}
+
+	for _, b := range buckets[1:] {
+		log.Printf("Also verifying s3://%s", b.bucket)
+	}
+	log.Printf("Verifying s3://%s from %s to %s", cfg.S3.Bucket, startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
+	report, err := v.Run(ctx, startTime, endTime, *platform, strings.ToLower(*channel))
+	if err != nil {
+		log.Fatalf("Verify failed: %v", err)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+	} else {
+		writeVerifyReport(os.Stdout, report)
+	}
+
+	if report.Problems() > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseVerifyTime parses a date (midnight UTC) or an RFC3339 time
+func parseVerifyTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// writeVerifyReport writes a human-readable verify report
+func writeVerifyReport(w io.Writer, report *verify.Report) {
+	const layout = "2006-01-02 15:04:05"
+
+	fmt.Fprintf(w, "Checked %d object(s), %d record(s) in %d channel(s), %s to %s UTC\n\n",
+		report.Objects, report.Records, len(report.Channels), report.Start.Format(layout), report.End.Format(layout))
+
+	for _, ch := range report.Channels {
+		state := "OK"
+		if n := len(ch.Gaps) + len(ch.Corrupt) + len(ch.Local); n > 0 {
+			state = fmt.Sprintf("%d problem(s)", n)
+		}
+		fmt.Fprintf(w, "%s/%s: %d object(s), %d record(s), %s\n", ch.Platform, ch.Channel, ch.Objects, ch.Records, state)
+
+		for _, gap := range ch.Gaps {
+			fmt.Fprintf(w, "  missing   %s - %s (%s)", gap.Start.Format(layout), gap.End.Format(layout), gap.End.Sub(gap.Start).Round(time.Second))
+			if len(gap.Pending) > 0 {
+				fmt.Fprintf(w, ", pending upload: %s", strings.Join(gap.Pending, ", "))
+			}
+			fmt.Fprintln(w)
+		}
+		for _, c := range ch.Corrupt {
+			fmt.Fprintf(w, "  corrupt   %s", c.Key)
+			if c.Line > 0 {
+				fmt.Fprintf(w, " line %d (%d line(s))", c.Line, c.Lines)
+			}
+			if !c.After.IsZero() || !c.Until.IsZero() {
+				fmt.Fprintf(w, " between %s and %s", formatVerifyTime(c.After, layout), formatVerifyTime(c.Until, layout))
+			}
+			fmt.Fprintf(w, ": %s\n", c.Error)
+		}
+		for _, f := range ch.Local {
+			fmt.Fprintf(w, "  not uploaded %s (%s - %s)\n", f.Path, f.Start.Format(layout), f.End.Format(layout))
+		}
+		for _, key := range ch.Encrypted {
+			fmt.Fprintf(w, "  unchecked %s (encrypted, no -identity)\n", key)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d problem(s) found\n", report.Problems())
+}
+
+// formatVerifyTime formats t, or "?" if it is unknown
+func formatVerifyTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return "?"
+	}
+	return t.Format(layout)
+}
@@ -0,0 +1,230 @@
+// Package archive serves a read-only web view of the archive bucket, for
+// self-hosted deployments that want to browse recorded chat without AWS
+// tooling
+package archive
+
+import (
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// pageSize is how many entries a folder page lists
+const pageSize = 500
+
+// Browser lists the bucket's folders (the key layout's days, platforms and
+// channels) and streams files from it
+type Browser struct {
+	buckets []*bucket
+	token   string
+}
+
+// bucket is a browsed bucket and the path its pages are served under
+type bucket struct {
+	client *s3.Client
+	name   string
+	root   string // "" for the first bucket, otherwise /buckets/{name}
+}
+
+// NewBrowser creates a browser of bucket
+func NewBrowser(client *s3.Client, name string) *Browser {
+	return &Browser{buckets: []*bucket{{client: client, name: name}}}
+}
+
+// AddBucket also serves another bucket, such as one a channel group
+// uploads to, under /buckets/{name}/. It must be called before Handler.
+func (b *Browser) AddBucket(client *s3.Client, name string) {
+	b.buckets = append(b.buckets, &bucket{client: client, name: name, root: "/buckets/" + name})
+}
+
+// EnableToken requires token as the password of HTTP basic auth, which
+// browsers prompt for. It must be called before Handler.
+func (b *Browser) EnableToken(token string) {
+	b.token = token
+}
+
+// entry is a folder or file in a listing
+type entry struct {
+	Name     string
+	Link     string
+	Size     int64
+	Modified time.Time
+}
+
+// listing is the data of a folder page
+type listing struct {
+	Bucket  string
+	Prefix  string
+	Crumbs  []entry // Parent folders, outermost first
+	Folders []entry
+	Files   []entry
+	Next    string  // Link to the next page, if any
+	Buckets []entry // Other buckets, listed at the first bucket's top level
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Bucket}}/{{.Prefix}}</title>
+<style>body{font-family:sans-serif;margin:2em}td{padding:0 1em 0 0}a{text-decoration:none}</style></head>
+<body>
+<h1>{{range .Crumbs}}<a href="{{.Link}}">{{.Name}}</a> / {{end}}</h1>
+<table>
+{{range .Buckets}}<tr><td><a href="{{.Link}}">{{.Name}}</a> (bucket)</td><td></td><td></td></tr>
+{{end}}{{range .Folders}}<tr><td><a href="{{.Link}}">{{.Name}}/</a></td><td></td><td></td></tr>
+{{end}}{{range .Files}}<tr><td><a href="{{.Link}}">{{.Name}}</a></td><td>{{.Size}} bytes</td><td>{{.Modified.Format "2006-01-02 15:04:05Z"}}</td></tr>
+{{end}}</table>
+{{if .Next}}<p><a href="{{.Next}}">More</a></p>{{end}}
+{{if not (or .Folders .Files)}}<p>Nothing here yet.</p>{{end}}
+</body></html>
+`))
+
+// Handler serves folder listings under /browse/ and files under /files/,
+// prefixed with /buckets/{name} for buckets added with AddBucket
+func (b *Browser) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/browse/", http.StatusFound)
+	})
+	for _, bk := range b.buckets {
+		mux.HandleFunc(bk.root+"/browse/", func(w http.ResponseWriter, r *http.Request) { b.serveListing(w, r, bk) })
+		mux.HandleFunc(bk.root+"/files/", func(w http.ResponseWriter, r *http.Request) { b.serveFile(w, r, bk) })
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+
+	if b.token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, password, _ := r.BasicAuth()
+		if r.URL.Path != "/healthz" && subtle.ConstantTimeCompare([]byte(password), []byte(b.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chatlog archive"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// serveListing lists one folder of bk
+func (b *Browser) serveListing(w http.ResponseWriter, r *http.Request, bk *bucket) {
+	prefix := strings.TrimPrefix(r.URL.Path, bk.root+"/browse/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusFound)
+		return
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bk.name),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(pageSize),
+	}
+	if token := r.URL.Query().Get("page"); token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	out, err := bk.client.ListObjectsV2(r.Context(), input)
+	if err != nil {
+		log.Printf("Error listing s3://%s/%s: %v", bk.name, prefix, err)
+		http.Error(w, "failed to list the bucket", http.StatusBadGateway)
+		return
+	}
+
+	page := listing{Bucket: bk.name, Prefix: prefix}
+	page.Crumbs = append(page.Crumbs, entry{Name: bk.name, Link: bk.root + "/browse/"})
+	parent := ""
+	for _, part := range strings.Split(strings.TrimSuffix(prefix, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		parent += part + "/"
+		page.Crumbs = append(page.Crumbs, entry{Name: part, Link: bk.root + "/browse/" + parent})
+	}
+	for _, p := range out.CommonPrefixes {
+		name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(p.Prefix), prefix), "/")
+		page.Folders = append(page.Folders, entry{Name: name, Link: bk.root + "/browse/" + aws.ToString(p.Prefix)})
+	}
+	for _, obj := range out.Contents {
+		key := aws.ToString(obj.Key)
+		page.Files = append(page.Files, entry{
+			Name:     path.Base(key),
+			Link:     bk.root + "/files/" + key,
+			Size:     aws.ToInt64(obj.Size),
+			Modified: aws.ToTime(obj.LastModified).UTC(),
+		})
+	}
+	if aws.ToBool(out.IsTruncated) {
+		page.Next = bk.root + "/browse/" + prefix + "?page=" + url.QueryEscape(aws.ToString(out.NextContinuationToken))
+	}
+	if bk == b.buckets[0] && prefix == "" && input.ContinuationToken == nil {
+		for _, other := range b.buckets[1:] {
+			page.Buckets = append(page.Buckets, entry{Name: other.name, Link: other.root + "/browse/"})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := listingTemplate.Execute(w, page); err != nil {
+		log.Printf("Error rendering listing: %v", err)
+	}
+}
+
+// serveFile streams an object of bk. Plain JSONL is shown as text so
+// browsers display it; compressed and encrypted files are downloaded.
+func (b *Browser) serveFile(w http.ResponseWriter, r *http.Request, bk *bucket) {
+	key := strings.TrimPrefix(r.URL.Path, bk.root+"/files/")
+	if key == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	out, err := bk.client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bk.name),
+		Key:    aws.String(key),
+	})
+	var noKey *types.NoSuchKey
+	if errors.As(err, &noKey) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching s3://%s/%s: %v", bk.name, key, err)
+		http.Error(w, "failed to fetch the file", http.StatusBadGateway)
+		return
+	}
+	defer out.Body.Close()
+
+	// Objects hold chat, which anyone can write, so never let a browser
+	// sniff them into HTML or script
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	switch {
+	case strings.HasSuffix(key, ".jsonl"):
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	case strings.HasSuffix(key, ".json"):
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+path.Base(key)+`"`)
+	}
+	if out.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*out.ContentLength, 10))
+	}
+	if _, err := io.Copy(w, out.Body); err != nil {
+		log.Printf("Error streaming s3://%s/%s: %v", bk.name, key, err)
+	}
+}
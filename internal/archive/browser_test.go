@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newFakeS3 serves each bucket's objects, by key, from a path-style S3
+// endpoint, and returns a client for it
+func newFakeS3(t *testing.T, buckets map[string]map[string]string) *s3.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		objects, ok := buckets[name]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+			return
+		}
+		if key != "" {
+			data, ok := objects[key]
+			if !ok {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, data)
+			return
+		}
+		fmt.Fprint(w, "<ListBucketResult>")
+		for key, data := range objects {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", key, len(data))
+			}
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	}))
+	t.Cleanup(server.Close)
+
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestBrowserAddBucket(t *testing.T) {
+	client := newFakeS3(t, map[string]map[string]string{
+		"main":     {"a.jsonl": "main\n"},
+		"customer": {"b.jsonl": "customer\n"},
+	})
+	browser := NewBrowser(client, "main")
+	browser.AddBucket(client, "customer")
+	h := browser.Handler()
+
+	code, body := get(t, h, "/browse/")
+	if code != http.StatusOK || !strings.Contains(body, `href="/files/a.jsonl"`) || !strings.Contains(body, `href="/buckets/customer/browse/"`) {
+		t.Errorf("main listing: %d\n%s", code, body)
+	}
+	code, body = get(t, h, "/buckets/customer/browse/")
+	if code != http.StatusOK || !strings.Contains(body, `href="/buckets/customer/files/b.jsonl"`) {
+		t.Errorf("customer listing: %d\n%s", code, body)
+	}
+	if code, body = get(t, h, "/buckets/customer/files/b.jsonl"); code != http.StatusOK || body != "customer\n" {
+		t.Errorf("customer file: %d %q", code, body)
+	}
+	if code, _ = get(t, h, "/files/b.jsonl"); code != http.StatusNotFound {
+		t.Errorf("customer file from the main bucket: status %d, want 404", code)
+	}
+}
+
+func TestServeFileForbidsSniffing(t *testing.T) {
+	client := newFakeS3(t, map[string]map[string]string{"main": {"a.jsonl": `{"message":"<script>alert(1)</script>"}` + "\n"}})
+	rec := httptest.NewRecorder()
+	NewBrowser(client, "main").Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/a.jsonl", nil))
+	if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("Content-Type = %q", got)
+	}
+}
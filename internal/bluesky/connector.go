@@ -0,0 +1,370 @@
+package bluesky
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/john/chatlog/internal/egress"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+const (
+	// DefaultJetstreamURL is a public Jetstream instance serving the firehose as JSON
+	DefaultJetstreamURL = "wss://jetstream2.us-east.bsky.network/subscribe"
+
+	postCollection = "app.bsky.feed.post"
+	tagFacetType   = "app.bsky.richtext.facet#tag"
+
+	publicAPIURL        = "https://public.api.bsky.app/xrpc"
+	maxReconnectBackoff = 60 * time.Second
+
+	// Handles of post authors are looked up in the background and cached;
+	// failed lookups are cached too so a bad DID isn't retried per post
+	handleTTL        = 24 * time.Hour
+	handleFailureTTL = 10 * time.Minute
+	maxHandleLookups = 4
+	maxCachedHandles = 10000
+)
+
+// jetstreamEvent is a Jetstream commit event
+type jetstreamEvent struct {
+	DID    string `json:"did"`
+	TimeUS int64  `json:"time_us"`
+	Kind   string `json:"kind"`
+	Commit *struct {
+		Operation  string          `json:"operation"`
+		Collection string          `json:"collection"`
+		RKey       string          `json:"rkey"`
+		Record     json.RawMessage `json:"record"`
+	} `json:"commit"`
+}
+
+// postRecord is an app.bsky.feed.post record
+type postRecord struct {
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+	Facets    []struct {
+		Features []struct {
+			Type string `json:"$type"`
+			Tag  string `json:"tag"`
+		} `json:"features"`
+	} `json:"facets"`
+}
+
+// Connector subscribes to the Bluesky firehose via Jetstream and records
+// posts matching configured hashtags or authored by configured handles
+type Connector struct {
+	jetstreamURL string
+	hashtags     map[string]bool // lowercase tag without '#'
+	handles      []string
+
+	handleCache map[string]*cachedHandle // By DID, for untracked authors
+	lookups     int                      // Handle lookups in flight
+	tracked     map[string]string        // tracked DID -> handle
+	mu          sync.Mutex
+
+	apiURL     string
+	httpClient *http.Client
+	dialer     *websocket.Dialer
+	cursor     int64 // time_us of the last event, used to resume after reconnect
+
+	status *status.Component
+}
+
+// New creates a new Bluesky connector. An empty jetstreamURL selects
+// DefaultJetstreamURL.
+func New(jetstreamURL string, hashtags, handles []string) *Connector {
+	if jetstreamURL == "" {
+		jetstreamURL = DefaultJetstreamURL
+	}
+
+	tags := make(map[string]bool, len(hashtags))
+	for _, tag := range hashtags {
+		tags[strings.ToLower(strings.TrimPrefix(tag, "#"))] = true
+	}
+
+	return &Connector{
+		jetstreamURL: jetstreamURL,
+		hashtags:     tags,
+		handles:      handles,
+		handleCache:  make(map[string]*cachedHandle),
+		tracked:      make(map[string]string),
+		apiURL:       publicAPIURL,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		dialer:       websocket.DefaultDialer,
+	}
+}
+
+// EnableStatus reports connection state and message times to comp. It must
+// be called before Start.
+func (c *Connector) EnableStatus(comp *status.Component) {
+	c.status = comp
+}
+
+// EnableEgress connects to Jetstream and resolves handles through network.
+// It must be called before Start.
+func (c *Connector) EnableEgress(network *egress.Network) {
+	c.httpClient = network.HTTPClient(10 * time.Second)
+	c.dialer = network.WebSocketDialer()
+}
+
+// Start begins listening to the firehose
+func (c *Connector) Start(ctx context.Context, messageChan chan<- message.Message) error {
+	// Resolve tracked handles to DIDs
+	for _, handle := range c.handles {
+		did, err := c.resolveHandle(ctx, handle)
+		if err != nil {
+			log.Printf("Warning: Failed to resolve Bluesky handle '%s': %v (skipping)", handle, err)
+			continue
+		}
+		c.tracked[did] = handle
+		log.Printf("Resolved Bluesky handle: %s -> %s", handle, did)
+	}
+
+	if len(c.hashtags) == 0 && len(c.tracked) == 0 {
+		return fmt.Errorf("no Bluesky hashtags or handles to follow")
+	}
+
+	backoff := time.Second
+	for {
+		connectedAt := time.Now()
+		err := c.runConnection(ctx, messageChan)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if time.Since(connectedAt) > maxReconnectBackoff {
+			backoff = time.Second
+		}
+
+		log.Printf("Bluesky Jetstream connection lost: %v. Reconnecting in %v", err, backoff)
+		c.status.SetState(status.StateReconnecting)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// runConnection handles a single Jetstream connection
+func (c *Connector) runConnection(ctx context.Context, messageChan chan<- message.Message) error {
+	query := url.Values{"wantedCollections": {postCollection}}
+	// Following only handles lets Jetstream filter server-side
+	if len(c.hashtags) == 0 {
+		for did := range c.tracked {
+			query.Add("wantedDids", did)
+		}
+	}
+	if c.cursor > 0 {
+		query.Set("cursor", fmt.Sprint(c.cursor))
+	}
+
+	conn, _, err := c.dialer.DialContext(ctx, c.jetstreamURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	log.Println("Connected to Bluesky Jetstream")
+	c.status.SetState(status.StateConnected)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Minute))
+
+		var event jetstreamEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+		c.cursor = event.TimeUS
+
+		if event.Kind != "commit" || event.Commit == nil ||
+			event.Commit.Operation != "create" || event.Commit.Collection != postCollection {
+			continue
+		}
+
+		var post postRecord
+		if err := json.Unmarshal(event.Commit.Record, &post); err != nil {
+			continue
+		}
+
+		channel, ok := c.match(event.DID, post)
+		if !ok {
+			continue
+		}
+
+		chatMessage := message.New("bluesky", post.CreatedAt)
+		chatMessage.Channel = channel
+		chatMessage.Username = c.handleFor(ctx, event.DID)
+		chatMessage.UserID = event.DID
+		chatMessage.Message = post.Text
+		c.status.MessageReceived()
+
+		select {
+		case messageChan <- chatMessage:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// match returns the channel a post is recorded under: the author's handle
+// for tracked accounts, otherwise the first matching hashtag
+func (c *Connector) match(did string, post postRecord) (string, bool) {
+	if handle, ok := c.tracked[did]; ok {
+		return handle, true
+	}
+	if len(c.hashtags) == 0 {
+		return "", false
+	}
+
+	for _, facet := range post.Facets {
+		for _, feature := range facet.Features {
+			if feature.Type == tagFacetType && c.hashtags[strings.ToLower(feature.Tag)] {
+				return strings.ToLower(feature.Tag), true
+			}
+		}
+	}
+
+	// Fall back to scanning the text for clients that don't emit tag facets
+	for _, word := range strings.Fields(post.Text) {
+		if tag, ok := strings.CutPrefix(word, "#"); ok {
+			tag = strings.ToLower(strings.TrimRight(tag, ".,!?:;"))
+			if c.hashtags[tag] {
+				return tag, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// cachedHandle is the looked up handle of a DID
+type cachedHandle struct {
+	handle  string // Empty until a lookup succeeds
+	expires time.Time
+	pending bool // A lookup is in flight
+}
+
+// handleFor returns the cached handle for a DID without blocking the read
+// loop. A missing or expired entry is looked up in the background, and the
+// DID (or the stale handle) is returned meanwhile.
+func (c *Connector) handleFor(ctx context.Context, did string) string {
+	if handle, ok := c.tracked[did]; ok {
+		return handle
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	entry, ok := c.handleCache[did]
+	if !ok {
+		c.pruneHandles(now)
+		entry = &cachedHandle{}
+		c.handleCache[did] = entry
+	}
+	if !entry.pending && !now.Before(entry.expires) && c.lookups < maxHandleLookups {
+		entry.pending = true
+		c.lookups++
+		go c.lookupHandle(ctx, did, entry)
+	}
+
+	if entry.handle == "" {
+		return did
+	}
+	return entry.handle
+}
+
+// lookupHandle fetches a DID's handle into its cache entry
+func (c *Connector) lookupHandle(ctx context.Context, did string, entry *cachedHandle) {
+	var profile struct {
+		Handle string `json:"handle"`
+	}
+	err := c.getJSON(ctx, "/app.bsky.actor.getProfile?"+url.Values{"actor": {did}}.Encode(), &profile)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.pending = false
+	c.lookups--
+	if err != nil || profile.Handle == "" {
+		entry.expires = time.Now().Add(handleFailureTTL)
+		return
+	}
+	entry.handle = profile.Handle
+	entry.expires = time.Now().Add(handleTTL)
+}
+
+// pruneHandles makes room in a full handle cache, dropping expired entries
+// first; the caller must hold c.mu
+func (c *Connector) pruneHandles(now time.Time) {
+	if len(c.handleCache) < maxCachedHandles {
+		return
+	}
+	for did, entry := range c.handleCache {
+		if !entry.pending && !now.Before(entry.expires) {
+			delete(c.handleCache, did)
+		}
+	}
+	for did, entry := range c.handleCache {
+		if len(c.handleCache) < maxCachedHandles {
+			return
+		}
+		if !entry.pending {
+			delete(c.handleCache, did)
+		}
+	}
+}
+
+// resolveHandle resolves a handle to its DID
+func (c *Connector) resolveHandle(ctx context.Context, handle string) (string, error) {
+	var result struct {
+		DID string `json:"did"`
+	}
+	handle = strings.TrimPrefix(handle, "@")
+	if err := c.getJSON(ctx, "/com.atproto.identity.resolveHandle?"+url.Values{"handle": {handle}}.Encode(), &result); err != nil {
+		return "", err
+	}
+	return result.DID, nil
+}
+
+// getJSON performs a GET against the public Bluesky API
+func (c *Connector) getJSON(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("JSON decode failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package bluesky
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForLookups waits until no handle lookup is in flight
+func waitForLookups(t *testing.T, c *Connector) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		n := c.lookups
+		c.mu.Unlock()
+		if n == 0 {
+			return
+		}
+	}
+	t.Fatal("handle lookups did not finish")
+}
+
+func TestHandleForLooksUpInBackground(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		if r.URL.Query().Get("actor") == "did:plc:gone" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"handle":"alice.bsky.social"}`)
+	}))
+	defer server.Close()
+
+	c := New("", []string{"tag"}, nil)
+	c.apiURL = server.URL
+	ctx := context.Background()
+
+	// The read loop gets the DID back while the lookup is stalled
+	if got := c.handleFor(ctx, "did:plc:alice"); got != "did:plc:alice" {
+		t.Errorf("handleFor during lookup = %q, want the DID", got)
+	}
+	if got := c.handleFor(ctx, "did:plc:gone"); got != "did:plc:gone" {
+		t.Errorf("handleFor during lookup = %q, want the DID", got)
+	}
+	close(release)
+	waitForLookups(t, c)
+
+	if got := c.handleFor(ctx, "did:plc:alice"); got != "alice.bsky.social" {
+		t.Errorf("handleFor after lookup = %q", got)
+	}
+	// A failed lookup is cached rather than retried for every post
+	for i := 0; i < 3; i++ {
+		if got := c.handleFor(ctx, "did:plc:gone"); got != "did:plc:gone" {
+			t.Errorf("handleFor after failed lookup = %q, want the DID", got)
+		}
+	}
+	waitForLookups(t, c)
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d lookups, want 2", n)
+	}
+}
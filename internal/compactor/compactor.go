@@ -0,0 +1,383 @@
+package compactor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/john/chatlog/internal/annotate"
+	"github.com/john/chatlog/internal/uploader"
+)
+
+// maxDeleteBatch is the maximum number of keys accepted by DeleteObjects
+const maxDeleteBatch = 1000
+
+// Ext is the extension of compacted objects. It differs from the .jsonl.gz
+// of seekable recordings, which compaction leaves alone.
+const Ext = ".compacted.jsonl.gz"
+
+// ManifestExt is the extension of the manifest beside a compacted object
+const ManifestExt = ".compacted.json"
+
+// Manifest names the fragments merged into a compacted object, so reruns
+// and readers skip any kept beside it (-keep-fragments)
+type Manifest struct {
+	Fragments []string `json:"fragments"` // File names, without the directory
+}
+
+// ManifestKey returns the key of a compacted object's manifest
+func ManifestKey(compactedKey string) string {
+	return strings.TrimSuffix(compactedKey, Ext) + ManifestExt
+}
+
+// ObjectGetter is the part of the S3 client ReadManifest uses
+type ObjectGetter interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// ReadManifest returns the set of fragment names in the manifest at key,
+// which is empty if there is no manifest
+func ReadManifest(ctx context.Context, client ObjectGetter, bucket, key string) (map[string]bool, error) {
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if code := apiErrorCode(err); code == "NoSuchKey" || code == "NotFound" {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	merged := make(map[string]bool, len(manifest.Fragments))
+	for _, name := range manifest.Fragments {
+		merged[name] = true
+	}
+	return merged, nil
+}
+
+// objectAPI is the part of the S3 client the compactor uses
+type objectAPI interface {
+	s3.ListObjectsV2APIClient
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+}
+
+// Compactor merges a day's rotated files per channel into a single
+// sorted, gzip-compressed object
+type Compactor struct {
+	s3Client        objectAPI
+	bucket          string
+	objectOpts      uploader.ObjectOptions
+	deleteFragments bool
+	prefixes        []string // Key prefixes the day's layout is also found under
+}
+
+// New creates a new compactor
+func New(s3Client *s3.Client, bucket string, objectOpts uploader.ObjectOptions, deleteFragments bool) *Compactor {
+	return &Compactor{
+		s3Client:        s3Client,
+		bucket:          bucket,
+		objectOpts:      objectOpts,
+		deleteFragments: deleteFragments,
+	}
+}
+
+// EnablePrefixes also compacts objects under these key prefixes, such as
+// channel group prefixes
+func (c *Compactor) EnablePrefixes(prefixes []string) {
+	for _, prefix := range prefixes {
+		if prefix != "" {
+			c.prefixes = append(c.prefixes, prefix)
+		}
+	}
+}
+
+// CompactDay compacts all channels for the given UTC day.
+// Keys are expected in the layout [prefix]YYYY/MM/DD/platform/channel/filename.
+func (c *Compactor) CompactDay(ctx context.Context, day time.Time) error {
+	groups := make(map[string][]string)
+	for _, prefix := range append([]string{""}, c.prefixes...) {
+		prefix += day.UTC().Format("2006/01/02") + "/"
+		log.Printf("Compacting s3://%s/%s", c.bucket, prefix)
+		if err := c.listFragments(ctx, prefix, groups); err != nil {
+			return err
+		}
+	}
+
+	if len(groups) == 0 {
+		log.Println("No fragments found to compact")
+		return nil
+	}
+
+	var failed int
+	for dir, keys := range groups {
+		if err := c.compactChannel(ctx, day, dir, keys); err != nil {
+			log.Printf("Error compacting %s: %v", dir, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d channel(s) failed to compact", failed, len(groups))
+	}
+	return nil
+}
+
+// listFragments adds the .jsonl objects under prefix to groups, by their
+// platform/channel directory
+func (c *Compactor) listFragments(ctx context.Context, prefix string, groups map[string][]string) error {
+	paginator := s3.NewListObjectsV2Paginator(c.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if !strings.HasSuffix(key, ".jsonl") || strings.HasSuffix(key, annotate.Ext) {
+				continue // Skip already compacted objects and annotation sidecars
+			}
+			dir := path.Dir(key)
+			groups[dir] = append(groups[dir], key)
+		}
+	}
+
+	return nil
+}
+
+// compactChannel merges the fragments in one platform/channel directory
+// into its compacted object, along with what an earlier run (or a run for
+// fragments that arrived late) already compacted there. Fragments the
+// manifest lists were merged before and are only deleted, not merged
+// again. Each input is sorted into a temporary run file and the runs are
+// merged into a temporary gzip file, so a busy channel-day is never held
+// in memory. The object is only replaced if it hasn't changed since it was
+// read, and fragments are only deleted once it and the manifest have been.
+func (c *Compactor) compactChannel(ctx context.Context, day time.Time, dir string, keys []string) error {
+	// dir is YYYY/MM/DD/platform/channel
+	platform := path.Base(path.Dir(dir))
+	channel := path.Base(dir)
+	compactedKey := fmt.Sprintf("%s/%s_%s_%s%s", dir, platform, channel, day.UTC().Format("20060102"), Ext)
+	manifestKey := ManifestKey(compactedKey)
+
+	merged, err := ReadManifest(ctx, c.s3Client, c.bucket, manifestKey)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", manifestKey, err)
+	}
+	var fresh []string
+	for _, key := range keys {
+		if !merged[path.Base(key)] {
+			fresh = append(fresh, key)
+		}
+	}
+	if len(fresh) == 0 {
+		log.Printf("Every fragment in %s is already compacted", dir)
+		return c.deleteMerged(ctx, dir, keys)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "chatlog-compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	runs := &runSet{dir: tmpDir}
+
+	etag, err := c.readCompacted(ctx, compactedKey, runs)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", compactedKey, err)
+	}
+	for _, key := range fresh {
+		if err := c.readFragment(ctx, key, runs); err != nil {
+			return fmt.Errorf("read %s: %w", key, err)
+		}
+	}
+
+	output, err := os.CreateTemp(tmpDir, "compacted-*.jsonl.gz")
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	records, err := runs.merge(output)
+	if err != nil {
+		return fmt.Errorf("merge: %w", err)
+	}
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:          aws.String(c.bucket),
+		Key:             aws.String(compactedKey),
+		Body:            output,
+		ContentType:     aws.String("application/x-ndjson"),
+		ContentEncoding: aws.String("gzip"),
+	}
+	if etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(etag)
+	}
+	c.objectOpts.Apply(input)
+
+	if _, err := c.s3Client.PutObject(ctx, input); err != nil {
+		if code := apiErrorCode(err); code == "PreconditionFailed" || code == "ConditionalRequestConflict" {
+			return fmt.Errorf("s3://%s/%s changed while compacting (another compaction running?); the fragments were kept, run again", c.bucket, compactedKey)
+		}
+		return fmt.Errorf("put object: %w", err)
+	}
+
+	earlier := ""
+	if etag != "" {
+		earlier = " and the earlier compacted object"
+	}
+	log.Printf("Compacted %d file(s)%s (%d records) into s3://%s/%s", len(fresh), earlier, records, c.bucket, compactedKey)
+
+	// A rerun after a failure here merges the fragments again, but the
+	// merge drops the records it already holds
+	for _, key := range fresh {
+		merged[path.Base(key)] = true
+	}
+	if err := c.writeManifest(ctx, manifestKey, merged); err != nil {
+		return fmt.Errorf("write %s: %w", manifestKey, err)
+	}
+
+	return c.deleteMerged(ctx, dir, keys)
+}
+
+// writeManifest stores the names of the fragments merged into a compacted
+// object
+func (c *Compactor) writeManifest(ctx context.Context, key string, merged map[string]bool) error {
+	var manifest Manifest
+	for name := range merged {
+		manifest.Fragments = append(manifest.Fragments, name)
+	}
+	sort.Strings(manifest.Fragments)
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(c.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+	c.objectOpts.Apply(input)
+	if _, err := c.s3Client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("put object: %w", err)
+	}
+	return nil
+}
+
+// deleteMerged deletes fragments once they are compacted, unless they are
+// being kept
+func (c *Compactor) deleteMerged(ctx context.Context, dir string, keys []string) error {
+	if !c.deleteFragments {
+		return nil
+	}
+	if err := c.deleteKeys(ctx, keys); err != nil {
+		return fmt.Errorf("delete fragments: %w", err)
+	}
+	log.Printf("Deleted %d fragment(s) from %s", len(keys), dir)
+	return nil
+}
+
+// readCompacted adds the existing compacted object at key, if any, to runs
+// and returns its ETag, or "" if there is none
+func (c *Compactor) readCompacted(ctx context.Context, key string, runs *runSet) (string, error) {
+	resp, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	if code := apiErrorCode(err); code == "NoSuchKey" || code == "NotFound" {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("decompress: %w", err)
+	}
+	// It was written sorted, so it is copied as a run without sorting
+	if err := runs.addSorted(gz); err != nil {
+		return "", err
+	}
+	return aws.ToString(resp.ETag), nil
+}
+
+// readFragment downloads a JSONL object and adds it to runs
+func (c *Compactor) readFragment(ctx context.Context, key string, runs *runSet) error {
+	resp, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("get object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return runs.add(resp.Body)
+}
+
+// apiErrorCode returns the S3 error code of err, or "" if it has none
+func apiErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// deleteKeys deletes objects in batches
+func (c *Compactor) deleteKeys(ctx context.Context, keys []string) error {
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := min(start+maxDeleteBatch, len(keys))
+
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		resp, err := c.s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(c.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(resp.Errors) > 0 {
+			return fmt.Errorf("%d object(s) failed to delete, first: %s", len(resp.Errors), aws.ToString(resp.Errors[0].Message))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,323 @@
+package compactor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/john/chatlog/internal/uploader"
+)
+
+// fakeS3 is an in-memory bucket honouring conditional puts
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	beforePut func() // Called before each put, e.g. to race another writer
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte)}
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, aws.ToString(in.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	out := &s3.ListObjectsV2Output{}
+	for _, key := range keys {
+		out.Contents = append(out.Contents, types.Object{Key: aws.String(key), Size: aws.Int64(int64(len(f.objects[key])))})
+	}
+	return out, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data)), ETag: aws.String(etagOf(data))}, nil
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if f.beforePut != nil {
+		f.beforePut()
+	}
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, exists := f.objects[aws.ToString(in.Key)]
+	if aws.ToString(in.IfNoneMatch) == "*" && exists {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	if in.IfMatch != nil && (!exists || etagOf(current) != aws.ToString(in.IfMatch)) {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	f.objects[aws.ToString(in.Key)] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, obj := range in.Delete.Objects {
+		delete(f.objects, aws.ToString(obj.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func (f *fakeS3) put(key string, lines ...string) {
+	f.objects[key] = []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func record(ts string, seq int, text string) string {
+	return fmt.Sprintf(`{"timestamp":"%s","seq":%d,"message":"%s"}`, ts, seq, text)
+}
+
+// compacted returns the decompressed lines of the object at key
+func (f *fakeS3) compacted(t *testing.T, key string) []string {
+	t.Helper()
+	data, ok := f.objects[key]
+	if !ok {
+		t.Fatalf("%s was not written", key)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(plain), "\n"), "\n")
+}
+
+func newTestCompactor(client objectAPI) *Compactor {
+	return &Compactor{s3Client: client, bucket: "test", objectOpts: uploader.ObjectOptions{}, deleteFragments: true}
+}
+
+const (
+	testDay       = "2024/01/02/twitch/chan/"
+	testCompacted = testDay + "twitch_chan_20240102" + Ext
+)
+
+func TestCompactDaySortsAndKeepsEarliestHeader(t *testing.T) {
+	fake := newFakeS3()
+	fake.put(testDay+"b.jsonl",
+		`{"type":"header","timestamp":"2024-01-02T01:00:00Z"}`,
+		record("2024-01-02T01:00:02Z", 5, "c"),
+		record("2024-01-02T01:00:01Z", 4, "b"),
+	)
+	fake.put(testDay+"a.jsonl",
+		`{"type":"header","timestamp":"2024-01-02T00:00:00Z"}`,
+		record("2024-01-02T00:30:00Z", 1, "a"),
+	)
+
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if err := newTestCompactor(fake).CompactDay(context.Background(), day); err != nil {
+		t.Fatal(err)
+	}
+
+	got := fake.compacted(t, testCompacted)
+	want := []string{
+		`{"type":"header","timestamp":"2024-01-02T00:00:00Z"}`,
+		record("2024-01-02T00:30:00Z", 1, "a"),
+		record("2024-01-02T01:00:01Z", 4, "b"),
+		record("2024-01-02T01:00:02Z", 5, "c"),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("compacted =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if _, ok := fake.objects[testDay+"a.jsonl"]; ok {
+		t.Error("fragment was not deleted")
+	}
+}
+
+func TestCompactDayRerunMergesExistingObject(t *testing.T) {
+	fake := newFakeS3()
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	c := newTestCompactor(fake)
+
+	fake.put(testDay+"a.jsonl", record("2024-01-02T10:00:00Z", 1, "first"), record("2024-01-02T12:00:00Z", 2, "third"))
+	if err := c.CompactDay(context.Background(), day); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fragment arrives late, after the first run deleted its sources
+	fake.put(testDay+"late.jsonl", record("2024-01-02T11:00:00Z", 7, "second"))
+	if err := c.CompactDay(context.Background(), day); err != nil {
+		t.Fatal(err)
+	}
+
+	got := fake.compacted(t, testCompacted)
+	want := []string{
+		record("2024-01-02T10:00:00Z", 1, "first"),
+		record("2024-01-02T11:00:00Z", 7, "second"),
+		record("2024-01-02T12:00:00Z", 2, "third"),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("compacted after rerun =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if _, ok := fake.objects[testDay+"late.jsonl"]; ok {
+		t.Error("late fragment was not deleted")
+	}
+
+	// Running again with nothing new leaves the object alone
+	if err := c.CompactDay(context.Background(), day); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.compacted(t, testCompacted); len(got) != 3 {
+		t.Errorf("compacted has %d records after an idle rerun, want 3", len(got))
+	}
+}
+
+func TestCompactDayKeptFragmentsMergeOnce(t *testing.T) {
+	fake := newFakeS3()
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	c := newTestCompactor(fake)
+	c.deleteFragments = false
+
+	fake.put(testDay+"a.jsonl", record("2024-01-02T10:00:00Z", 1, "first"))
+	for run := 0; run < 3; run++ {
+		if err := c.CompactDay(context.Background(), day); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.put(testDay+"b.jsonl", record("2024-01-02T11:00:00Z", 2, "second"))
+	if err := c.CompactDay(context.Background(), day); err != nil {
+		t.Fatal(err)
+	}
+
+	got := fake.compacted(t, testCompacted)
+	want := []string{record("2024-01-02T10:00:00Z", 1, "first"), record("2024-01-02T11:00:00Z", 2, "second")}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("compacted after reruns =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	merged, err := ReadManifest(context.Background(), fake, "test", ManifestKey(testCompacted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != 2 || !merged["a.jsonl"] || !merged["b.jsonl"] {
+		t.Errorf("manifest = %v, want a.jsonl and b.jsonl", merged)
+	}
+
+	// Without the manifest (say its write failed), merging again still
+	// doesn't duplicate records
+	delete(fake.objects, ManifestKey(testCompacted))
+	if err := c.CompactDay(context.Background(), day); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.compacted(t, testCompacted); len(got) != 2 {
+		t.Errorf("compacted has %d records after merging fragments again, want 2", len(got))
+	}
+}
+
+func TestCompactDayPrefixes(t *testing.T) {
+	fake := newFakeS3()
+	fake.put(testDay+"a.jsonl", record("2024-01-02T10:00:00Z", 1, "plain"))
+	fake.put("vips/"+testDay+"a.jsonl", record("2024-01-02T10:00:00Z", 1, "vip"))
+
+	c := newTestCompactor(fake)
+	c.EnablePrefixes([]string{"", "vips/"})
+	if err := c.CompactDay(context.Background(), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fake.compacted(t, testCompacted); len(got) != 1 || !strings.Contains(got[0], "plain") {
+		t.Errorf("compacted = %q", got)
+	}
+	if got := fake.compacted(t, "vips/"+testCompacted); len(got) != 1 || !strings.Contains(got[0], "vip") {
+		t.Errorf("compacted under prefix = %q", got)
+	}
+}
+
+func TestCompactDayKeepsFragmentsWhenObjectChanges(t *testing.T) {
+	fake := newFakeS3()
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	fake.put(testDay+"a.jsonl", record("2024-01-02T10:00:00Z", 1, "mine"))
+
+	// Another compaction writes the object between our read and write
+	fake.beforePut = func() {
+		fake.mu.Lock()
+		fake.objects[testCompacted] = []byte("theirs")
+		fake.mu.Unlock()
+	}
+	if err := newTestCompactor(fake).CompactDay(context.Background(), day); err == nil {
+		t.Fatal("CompactDay succeeded despite a concurrent write")
+	}
+	if string(fake.objects[testCompacted]) != "theirs" {
+		t.Error("the concurrent write was overwritten")
+	}
+	if _, ok := fake.objects[testDay+"a.jsonl"]; !ok {
+		t.Error("fragment was deleted although its records weren't stored")
+	}
+}
+
+func TestMergeManyRuns(t *testing.T) {
+	rs := &runSet{dir: t.TempDir()}
+	runs := maxOpenRuns*2 + 3
+	for i := 0; i < runs; i++ {
+		// Every run has a record at the same time, to check ties keep
+		// input order across merge passes
+		input := record("2024-01-02T00:00:00Z", 0, fmt.Sprint(i)) + "\n" +
+			record(time.Date(2024, 1, 2, 1, 0, runs-i, 0, time.UTC).Format(time.RFC3339), 0, fmt.Sprint("late", i)) + "\n"
+		if err := rs.add(strings.NewReader(input)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	records, err := rs.merge(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records != runs*2 {
+		t.Fatalf("merged %d records, want %d", records, runs*2)
+	}
+
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := io.ReadAll(gz)
+	lines := strings.Split(strings.TrimSpace(string(plain)), "\n")
+	for i := 0; i < runs; i++ {
+		if want := record("2024-01-02T00:00:00Z", 0, fmt.Sprint(i)); lines[i] != want {
+			t.Fatalf("line %d = %s, want %s", i, lines[i], want)
+		}
+	}
+	for i := 1; i < runs; i++ {
+		prev, cur := parseLine([]byte(lines[runs+i-1])), parseLine([]byte(lines[runs+i]))
+		if cur.before(prev) {
+			t.Fatalf("line %d is out of order", runs+i)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package compactor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// maxLine caps a single JSONL record
+const maxLine = 10 * 1024 * 1024
+
+// line is a single JSONL record with its parsed sort keys
+type line struct {
+	timestamp time.Time
+	sequence  uint64
+	header    bool // A file header record
+	data      []byte
+}
+
+// parseLine reads a record's sort keys, keeping malformed lines at the zero
+// timestamp rather than dropping data
+func parseLine(data []byte) line {
+	var record struct {
+		Timestamp string `json:"timestamp"`
+		Sequence  uint64 `json:"seq"`
+		Type      string `json:"type"`
+	}
+	var ts time.Time
+	if err := json.Unmarshal(data, &record); err == nil {
+		ts, _ = time.Parse(time.RFC3339Nano, record.Timestamp)
+	}
+	return line{
+		timestamp: ts,
+		sequence:  record.Sequence,
+		header:    record.Type == message.TypeHeader,
+		data:      data,
+	}
+}
+
+// before orders records by message timestamp, then receive sequence
+func (l line) before(other line) bool {
+	if !l.timestamp.Equal(other.timestamp) {
+		return l.timestamp.Before(other.timestamp)
+	}
+	return l.sequence < other.sequence
+}
+
+// readLines splits JSONL data into lines
+func readLines(r io.Reader) ([]line, error) {
+	var lines []line
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	for scanner.Scan() {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		lines = append(lines, parseLine(append([]byte(nil), data...)))
+	}
+
+	return lines, scanner.Err()
+}
+
+// runSet collects the inputs of one compacted object as sorted temporary
+// files, in input order, and keeps the earliest file header aside so it
+// can lead the output
+type runSet struct {
+	dir    string
+	paths  []string
+	header *line
+}
+
+// add sorts one fragment's records into a new run. A fragment is at most a
+// rotated file, so it is sorted in memory; stable so ties keep file order.
+func (rs *runSet) add(r io.Reader) error {
+	lines, err := readLines(r)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].before(lines[j]) })
+
+	return rs.write(func(emit func(line) error) error {
+		for _, l := range lines {
+			if err := emit(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addSorted copies records that are already in order into a new run,
+// without holding them in memory
+func (rs *runSet) addSorted(r io.Reader) error {
+	return rs.write(func(emit func(line) error) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLine)
+		for scanner.Scan() {
+			data := bytes.TrimSpace(scanner.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+			if err := emit(parseLine(data)); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	})
+}
+
+// write creates a run file from the records produce emits, setting headers
+// aside
+func (rs *runSet) write(produce func(emit func(line) error) error) error {
+	file, err := os.CreateTemp(rs.dir, "run-*.jsonl")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	err = produce(func(l line) error {
+		if l.header {
+			// The earliest header leads; ties keep the earlier input's
+			if rs.header == nil || l.before(*rs.header) {
+				kept := l
+				kept.data = append([]byte(nil), l.data...)
+				rs.header = &kept
+			}
+			return nil
+		}
+		if _, err := w.Write(l.data); err != nil {
+			return err
+		}
+		return w.WriteByte('\n')
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	rs.paths = append(rs.paths, file.Name())
+	return file.Close()
+}
+
+// maxOpenRuns caps the run files merged at once, to stay well within file
+// descriptor limits; beyond it, consecutive runs are merged in passes
+const maxOpenRuns = 256
+
+// merge writes the header and then every run's records in order, gzipped,
+// to w and returns how many records it wrote. A record identical to one
+// already written with the same timestamp and sequence, such as from a
+// fragment merged again, is written once.
+func (rs *runSet) merge(w io.Writer) (int, error) {
+	// Merging consecutive runs keeps ties in input order
+	for len(rs.paths) > maxOpenRuns {
+		var next []string
+		for start := 0; start < len(rs.paths); start += maxOpenRuns {
+			group := rs.paths[start:min(start+maxOpenRuns, len(rs.paths))]
+			if len(group) == 1 {
+				next = append(next, group[0])
+				continue
+			}
+			merged, err := rs.mergeToRun(group)
+			if err != nil {
+				return 0, err
+			}
+			next = append(next, merged)
+		}
+		rs.paths = next
+	}
+
+	gz := gzip.NewWriter(w)
+	out := bufio.NewWriter(gz)
+	records := 0
+	writeLine := func(data []byte) error {
+		records++
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+		return out.WriteByte('\n')
+	}
+
+	if rs.header != nil {
+		if err := writeLine(rs.header.data); err != nil {
+			return 0, fmt.Errorf("compress: %w", err)
+		}
+	}
+	// Equal records sort together, so only the current tie is remembered
+	var tie line
+	seen := make(map[string]bool)
+	err := mergeRuns(rs.paths, func(l line) error {
+		if tie.before(l) || l.before(tie) {
+			tie = l
+			clear(seen)
+		}
+		if seen[string(l.data)] {
+			return nil
+		}
+		seen[string(l.data)] = true
+		return writeLine(l.data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := out.Flush(); err != nil {
+		return 0, fmt.Errorf("compress: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("compress: %w", err)
+	}
+	return records, nil
+}
+
+// mergeToRun merges runs into a new run file and removes them
+func (rs *runSet) mergeToRun(paths []string) (string, error) {
+	file, err := os.CreateTemp(rs.dir, "run-*.jsonl")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	err = mergeRuns(paths, func(l line) error {
+		if _, err := w.Write(l.data); err != nil {
+			return err
+		}
+		return w.WriteByte('\n')
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	for _, p := range paths {
+		os.Remove(p)
+	}
+	return file.Name(), file.Close()
+}
+
+// mergeRuns passes the records of the run files at paths to emit in order,
+// ties going to the earlier run
+func mergeRuns(paths []string, emit func(line) error) error {
+	var h runHeap
+	for i, p := range paths {
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r := &runReader{index: i, reader: bufio.NewReaderSize(file, 64*1024)}
+		ok, err := r.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, r)
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		r := h[0]
+		if err := emit(r.current); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+		ok, err := r.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// runReader reads one run's records in order
+type runReader struct {
+	index   int // Input order, breaking ties between runs
+	reader  *bufio.Reader
+	current line
+}
+
+// advance reads the next record, reporting false at the end of the run
+func (r *runReader) advance() (bool, error) {
+	data, err := r.reader.ReadBytes('\n')
+	if err == io.EOF && len(data) == 0 {
+		return false, nil
+	}
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	r.current = parseLine(bytes.TrimRight(data, "\n"))
+	return true, nil
+}
+
+// runHeap orders runs by their current record
+type runHeap []*runReader
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	a, b := h[i].current, h[j].current
+	if a.before(b) {
+		return true
+	}
+	if b.before(a) {
+		return false
+	}
+	return h[i].index < h[j].index
+}
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ChannelGroup applies shared settings to a named set of channels, so
+// per-channel customization doesn't mean repeating the same settings
+type ChannelGroup struct {
+	Name            string               `yaml:"name"`
+	Channels        []string             `yaml:"channels"`         // "platform:channel", e.g. "twitch:xqc"; "platform:*" matches every channel on a platform
+	RotateMinutes   int                  `yaml:"rotate_minutes"`   // Overrides recorder.rotate_minutes
+	RotateMegabytes int                  `yaml:"rotate_megabytes"` // Overrides recorder.rotate_megabytes
+	KeyPrefix       string               `yaml:"key_prefix"`       // Prepended to the group's object keys, e.g. "vips/"
+	Sinks           []string             `yaml:"sinks"`            // Sinks that receive the group's messages; omit for all, [] for none
+	HighVolume      *GroupHighVolumeRule `yaml:"high_volume"`      // High-volume handling for every channel in the group
+	S3              *S3Destination       `yaml:"s3"`               // Uploads the group's files to its own bucket instead of s3.bucket
+}
+
+// S3Destination is a bucket a group or tenant uploads to instead of
+// s3.bucket, e.g. a customer's. Unset fields other than the bucket fall
+// back to the s3 settings; credentials fall back only if neither role_arn
+// nor access_key_id is set.
+type S3Destination struct {
+	Bucket               string `yaml:"bucket"`
+	Region               string `yaml:"region"`
+	Endpoint             string `yaml:"endpoint"`
+	RoleARN              string `yaml:"role_arn"`
+	AccessKeyID          string `yaml:"access_key_id"`
+	SecretAccessKey      string `yaml:"secret_access_key"`      // Or set CHATLOG_GROUP_<NAME>_SECRET_ACCESS_KEY (CHATLOG_TENANT_ for tenants), NAME uppercased with _ for -
+	ServerSideEncryption string `yaml:"server_side_encryption"` // "AES256" or "aws:kms"; unset uses s3.server_side_encryption
+	KMSKeyID             string `yaml:"kms_key_id"`             // Required for aws:kms
+}
+
+// check validates a destination, naming its owner in errors
+func (d *S3Destination) check(owner string) error {
+	if d.Bucket == "" {
+		return fmt.Errorf("%s: s3.bucket is required when s3 is set", owner)
+	}
+	if d.AccessKeyID != "" && d.SecretAccessKey == "" {
+		return fmt.Errorf("%s: s3.secret_access_key is required when using access_key_id", owner)
+	}
+	if d.RoleARN != "" && d.AccessKeyID != "" {
+		return fmt.Errorf("%s: s3.role_arn and s3.access_key_id are mutually exclusive", owner)
+	}
+	switch d.ServerSideEncryption {
+	case "", "AES256":
+		if d.KMSKeyID != "" {
+			return fmt.Errorf("%s: s3.kms_key_id requires s3.server_side_encryption to be aws:kms", owner)
+		}
+	case "aws:kms":
+		if d.KMSKeyID == "" {
+			return fmt.Errorf("%s: s3.kms_key_id is required when s3.server_side_encryption is aws:kms", owner)
+		}
+	default:
+		return fmt.Errorf("%s: s3.server_side_encryption must be AES256 or aws:kms, got %q", owner, d.ServerSideEncryption)
+	}
+	return nil
+}
+
+// envName converts a group or tenant name to its part of an environment
+// variable name
+func envName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// GroupHighVolumeRule is a high-volume rule applied to each channel in a
+// group. Group rules take precedence over high_volume.rules.
+type GroupHighVolumeRule struct {
+	Threshold  float64 `yaml:"threshold"`   // Messages per second, averaged over 10 seconds
+	Mode       string  `yaml:"mode"`        // "sample" or "aggregate"
+	SampleRate int     `yaml:"sample_rate"` // Record 1 in N messages in sample mode (default 10)
+}
+
+// Group returns the group a channel belongs to, or nil if it is in none.
+// Groups naming the channel explicitly take precedence over "platform:*"
+// entries.
+func (c *Config) Group(platform, channel string) *ChannelGroup {
+	var wildcard *ChannelGroup
+	for i := range c.Groups {
+		group := &c.Groups[i]
+		for _, entry := range group.Channels {
+			p, ch, _ := strings.Cut(entry, ":")
+			if p != platform {
+				continue
+			}
+			if strings.EqualFold(ch, channel) {
+				return group
+			}
+			if ch == "*" && wildcard == nil {
+				wildcard = group
+			}
+		}
+	}
+	return wildcard
+}
+
+// GroupIndex looks up channel groups by channel without scanning every
+// group, for paths that run once per message or file
+type GroupIndex struct {
+	channels  map[string]*ChannelGroup // "platform:channel", channel lowercased
+	wildcards map[string]*ChannelGroup // By platform
+}
+
+// NewGroupIndex indexes groups the way Config.Group matches them
+func NewGroupIndex(groups []ChannelGroup) *GroupIndex {
+	idx := &GroupIndex{channels: make(map[string]*ChannelGroup), wildcards: make(map[string]*ChannelGroup)}
+	for i := range groups {
+		group := &groups[i]
+		for _, entry := range group.Channels {
+			p, ch, _ := strings.Cut(entry, ":")
+			m, key := idx.channels, p+":"+strings.ToLower(ch)
+			if ch == "*" {
+				m, key = idx.wildcards, p
+			}
+			if _, ok := m[key]; !ok {
+				m[key] = group
+			}
+		}
+	}
+	return idx
+}
+
+// Lookup returns the group a channel belongs to, or nil if it is in none
+func (idx *GroupIndex) Lookup(platform, channel string) *ChannelGroup {
+	if group, ok := idx.channels[platform+":"+strings.ToLower(channel)]; ok {
+		return group
+	}
+	return idx.wildcards[platform]
+}
+
+// KeyPrefixes returns the distinct key prefixes groups upload under in
+// s3.bucket, so tools that list the archive can find every channel
+func (c *Config) KeyPrefixes() []string {
+	var prefixes []string
+	for _, g := range c.Groups {
+		if g.KeyPrefix != "" && g.S3 == nil && !slices.Contains(prefixes, g.KeyPrefix) {
+			prefixes = append(prefixes, g.KeyPrefix)
+		}
+	}
+	return prefixes
+}
+
+// groupNameRe restricts group names to what is safe in keys and logs
+var groupNameRe = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// sinkNames lists the sinks a group may select
+var sinkNames = []string{"redis", "postgres", "overlay"}
+
+// validateGroups checks channel groups for naming and membership conflicts.
+// customSinks are the names of sinks.custom, which groups may also select.
+func validateGroups(groups []ChannelGroup, customSinks []CustomSink) error {
+	sinks := slices.Clone(sinkNames)
+	for _, custom := range customSinks {
+		sinks = append(sinks, custom.Name)
+	}
+
+	names := make(map[string]bool)
+	members := make(map[string]string)
+	for i, group := range groups {
+		if !groupNameRe.MatchString(group.Name) {
+			return fmt.Errorf("groups[%d].name must be lowercase letters, digits and dashes, got %q", i, group.Name)
+		}
+		if names[group.Name] {
+			return fmt.Errorf("groups[%d].name %q is used more than once", i, group.Name)
+		}
+		names[group.Name] = true
+
+		for _, entry := range group.Channels {
+			platform, channel, ok := strings.Cut(entry, ":")
+			if !ok || platform == "" || channel == "" {
+				return fmt.Errorf("group %s: channel %q must be platform:channel, e.g. twitch:xqc", group.Name, entry)
+			}
+			key := platform + ":" + strings.ToLower(channel)
+			if other, ok := members[key]; ok {
+				return fmt.Errorf("group %s: %s is already in group %s", group.Name, entry, other)
+			}
+			members[key] = group.Name
+		}
+
+		if group.RotateMinutes < 0 || group.RotateMegabytes < 0 {
+			return fmt.Errorf("group %s: rotate_minutes and rotate_megabytes must not be negative", group.Name)
+		}
+		for _, name := range group.Sinks {
+			if !slices.Contains(sinks, name) {
+				return fmt.Errorf("group %s: unknown sink %q (expected %s)", group.Name, name, strings.Join(sinks, ", "))
+			}
+		}
+		if group.S3 != nil {
+			if err := group.S3.check("group " + group.Name); err != nil {
+				return err
+			}
+		}
+		if hv := group.HighVolume; hv != nil {
+			if hv.Mode != "sample" && hv.Mode != "aggregate" {
+				return fmt.Errorf("group %s: high_volume.mode must be sample or aggregate, got %q", group.Name, hv.Mode)
+			}
+			if hv.Threshold <= 0 {
+				return fmt.Errorf("group %s: high_volume.threshold must be greater than 0", group.Name)
+			}
+			if hv.Mode == "sample" && hv.SampleRate != 0 && hv.SampleRate < 2 {
+				return fmt.Errorf("group %s: high_volume.sample_rate must be at least 2, got %d", group.Name, hv.SampleRate)
+			}
+		}
+	}
+	return nil
+}
+
+// groupHighVolumeRules expands group high-volume settings into per-channel
+// rules, explicitly named channels first so they win over wildcards
+func groupHighVolumeRules(groups []ChannelGroup) []HighVolumeRule {
+	var exact, wildcard []HighVolumeRule
+	for _, group := range groups {
+		hv := group.HighVolume
+		if hv == nil {
+			continue
+		}
+		sampleRate := hv.SampleRate
+		if hv.Mode == "sample" && sampleRate == 0 {
+			sampleRate = 10
+		}
+		for _, entry := range group.Channels {
+			platform, channel, _ := strings.Cut(entry, ":")
+			rule := HighVolumeRule{
+				Platform:   platform,
+				Channel:    channel,
+				Threshold:  hv.Threshold,
+				Mode:       hv.Mode,
+				SampleRate: sampleRate,
+			}
+			if channel == "*" {
+				wildcard = append(wildcard, rule)
+			} else {
+				exact = append(exact, rule)
+			}
+		}
+	}
+	return append(exact, wildcard...)
+}
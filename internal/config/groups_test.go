@@ -0,0 +1,30 @@
+package config
+
+import "testing"
+
+func TestGroupIndexMatchesGroup(t *testing.T) {
+	cfg := &Config{Groups: []ChannelGroup{
+		{Name: "all-kick", Channels: []string{"kick:*"}, KeyPrefix: "kick/"},
+		{Name: "vips", Channels: []string{"twitch:xQc", "kick:trainwreckstv"}, KeyPrefix: "vips/"},
+		{Name: "partners", Channels: []string{"twitch:*"}, KeyPrefix: "vips/", S3: &S3Destination{Bucket: "theirs"}},
+	}}
+	idx := NewGroupIndex(cfg.Groups)
+
+	for _, tt := range []struct{ platform, channel string }{
+		{"twitch", "xqc"},
+		{"twitch", "XQC"},
+		{"twitch", "someone"},
+		{"kick", "trainwreckstv"},
+		{"kick", "someone"},
+		{"youtube", "xqc"},
+	} {
+		if got, want := idx.Lookup(tt.platform, tt.channel), cfg.Group(tt.platform, tt.channel); got != want {
+			t.Errorf("Lookup(%s, %s) = %v, want %v", tt.platform, tt.channel, got, want)
+		}
+	}
+
+	prefixes := cfg.KeyPrefixes()
+	if len(prefixes) != 2 || prefixes[0] != "kick/" || prefixes[1] != "vips/" {
+		t.Errorf("KeyPrefixes = %q, want [kick/ vips/]", prefixes)
+	}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UploadScheduleConfig throttles or pauses uploads during daily windows.
+// Outside every window uploads run at full speed.
+type UploadScheduleConfig struct {
+	Timezone string               `yaml:"timezone"` // IANA name, e.g. "America/New_York" (default UTC)
+	Windows  []UploadWindowConfig `yaml:"windows"`  // The first window containing the current time applies
+}
+
+// UploadWindowConfig is a daily period with an upload rate limit or pause
+type UploadWindowConfig struct {
+	Start              string   `yaml:"start"`                // "HH:MM"
+	End                string   `yaml:"end"`                  // "HH:MM"; before start wraps past midnight
+	Days               []string `yaml:"days"`                 // "mon".."sun" the window starts on; empty means every day
+	MegabytesPerSecond float64  `yaml:"megabytes_per_second"` // Shared by all uploads in the window
+	Pause              bool     `yaml:"pause"`                // Start no uploads in the window
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Location returns the schedule's time zone
+func (s UploadScheduleConfig) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+// Clock returns the window's start and end as offsets from midnight
+func (w UploadWindowConfig) Clock() (start, end time.Duration, err error) {
+	if start, err = parseClock(w.Start); err != nil {
+		return 0, 0, fmt.Errorf("start: %w", err)
+	}
+	if end, err = parseClock(w.End); err != nil {
+		return 0, 0, fmt.Errorf("end: %w", err)
+	}
+	return start, end, nil
+}
+
+// Weekdays returns the days the window starts on
+func (w UploadWindowConfig) Weekdays() ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, name := range w.Days {
+		day, ok := weekdays[strings.ToLower(name)[:min(3, len(name))]]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", name)
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// parseClock parses "HH:MM", allowing "24:00" for the end of the day
+func parseClock(s string) (time.Duration, error) {
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// validateSchedule checks uploader.schedule
+func validateSchedule(s UploadScheduleConfig) error {
+	if _, err := s.Location(); err != nil {
+		return fmt.Errorf("uploader.schedule.timezone: %w", err)
+	}
+	for i, w := range s.Windows {
+		start, end, err := w.Clock()
+		if err != nil {
+			return fmt.Errorf("uploader.schedule.windows[%d]: %w", i, err)
+		}
+		if start == end {
+			return fmt.Errorf("uploader.schedule.windows[%d]: start and end are the same", i)
+		}
+		if _, err := w.Weekdays(); err != nil {
+			return fmt.Errorf("uploader.schedule.windows[%d]: %w", i, err)
+		}
+		if w.Pause == (w.MegabytesPerSecond > 0) {
+			return fmt.Errorf("uploader.schedule.windows[%d]: set either pause or megabytes_per_second", i)
+		}
+		if w.MegabytesPerSecond < 0 {
+			return fmt.Errorf("uploader.schedule.windows[%d]: megabytes_per_second must be positive", i)
+		}
+	}
+	return nil
+}
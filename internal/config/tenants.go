@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Tenant is a customer recorded by this process with its own recorder,
+// uploader and storage, sharing only the platform connections with other
+// tenants
+type Tenant struct {
+	Name      string            `yaml:"name"`
+	Channels  []string          `yaml:"channels"`   // "platform:channel" or "platform:*", joined through the platform's settings
+	OutputDir string            `yaml:"output_dir"` // Default {recorder.output_dir}/tenants/{name}
+	KeyPrefix string            `yaml:"key_prefix"` // Prepended to object keys; default "{name}/" unless s3 is set
+	S3        *S3Destination    `yaml:"s3"`         // The tenant's bucket; unset uploads as uploader.mode does
+	Labels    map[string]string `yaml:"labels"`     // Added to the tenant's metrics besides tenant="name"
+	Filters   TenantFilters     `yaml:"filters"`
+	Sinks     []string          `yaml:"sinks"` // Shared sinks that also get the tenant's messages; default none
+}
+
+// TenantFilters drops records before a tenant's recorder
+type TenantFilters struct {
+	Types        []string `yaml:"types"`         // Record only these types, "chat" for chat messages; empty records all
+	ExcludeUsers []string `yaml:"exclude_users"` // Usernames never recorded, e.g. bots
+}
+
+// Tenant returns the tenant a channel belongs to, or nil if it belongs to
+// none. Tenants naming the channel explicitly take precedence over
+// "platform:*" entries.
+func (c *Config) Tenant(platform, channel string) *Tenant {
+	var wildcard *Tenant
+	for i := range c.Tenants {
+		tenant := &c.Tenants[i]
+		for _, entry := range tenant.Channels {
+			p, ch, _ := strings.Cut(entry, ":")
+			if p != platform {
+				continue
+			}
+			if strings.EqualFold(ch, channel) {
+				return tenant
+			}
+			if ch == "*" && wildcard == nil {
+				wildcard = tenant
+			}
+		}
+	}
+	return wildcard
+}
+
+// metricLabelRe restricts tenant label names to valid Prometheus names
+var metricLabelRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are set by chatlog's own metrics
+var reservedLabels = []string{"tenant", "platform", "channel", "window", "reason", "result"}
+
+// applyTenantDefaults fills in tenant output directories and key prefixes
+func applyTenantDefaults(cfg *Config) {
+	for i := range cfg.Tenants {
+		t := &cfg.Tenants[i]
+		if t.OutputDir == "" {
+			t.OutputDir = filepath.Join(cfg.Recorder.OutputDir, "tenants", t.Name)
+		}
+		if t.KeyPrefix == "" && t.S3 == nil {
+			t.KeyPrefix = t.Name + "/"
+		}
+	}
+}
+
+// validateTenants checks tenants for naming, membership and directory
+// conflicts. Tenants may select sinks.custom as well as the built-in sinks.
+func validateTenants(cfg *Config) error {
+	sinks := slices.Clone(sinkNames)
+	for _, custom := range cfg.Sinks.Custom {
+		sinks = append(sinks, custom.Name)
+	}
+
+	names := make(map[string]bool)
+	members := make(map[string]string)
+	dirs := map[string]string{filepath.Clean(cfg.Recorder.OutputDir): "recorder.output_dir"}
+	for i, t := range cfg.Tenants {
+		if !groupNameRe.MatchString(t.Name) {
+			return fmt.Errorf("tenants[%d].name must be lowercase letters, digits and dashes, got %q", i, t.Name)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("tenants[%d].name %q is used more than once", i, t.Name)
+		}
+		names[t.Name] = true
+
+		if len(t.Channels) == 0 {
+			return fmt.Errorf("tenant %s: channels is required", t.Name)
+		}
+		for _, entry := range t.Channels {
+			platform, channel, ok := strings.Cut(entry, ":")
+			if !ok || platform == "" || channel == "" {
+				return fmt.Errorf("tenant %s: channel %q must be platform:channel, e.g. twitch:xqc", t.Name, entry)
+			}
+			key := platform + ":" + strings.ToLower(channel)
+			if other, ok := members[key]; ok {
+				return fmt.Errorf("tenant %s: %s is already in tenant %s", t.Name, entry, other)
+			}
+			members[key] = t.Name
+		}
+
+		dir := filepath.Clean(t.OutputDir)
+		if other, ok := dirs[dir]; ok {
+			return fmt.Errorf("tenant %s: output_dir %s is already used by %s", t.Name, t.OutputDir, other)
+		}
+		dirs[dir] = "tenant " + t.Name
+
+		if t.S3 != nil {
+			if err := t.S3.check("tenant " + t.Name); err != nil {
+				return err
+			}
+		}
+		for name := range t.Labels {
+			if !metricLabelRe.MatchString(name) || slices.Contains(reservedLabels, name) {
+				return fmt.Errorf("tenant %s: label %q must be a Prometheus label name other than %s", t.Name, name, strings.Join(reservedLabels, ", "))
+			}
+		}
+		for _, name := range t.Sinks {
+			if !slices.Contains(sinks, name) {
+				return fmt.Errorf("tenant %s: unknown sink %q (expected %s)", t.Name, name, strings.Join(sinks, ", "))
+			}
+		}
+	}
+	return nil
+}
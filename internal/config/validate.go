@@ -0,0 +1,497 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeStrict parses YAML, rejecting fields that don't exist in Config so
+// typos aren't silently replaced by defaults
+func decodeStrict(data []byte, cfg *Config) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(cfg)
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		for i, msg := range typeErr.Errors {
+			typeErr.Errors[i] = withSuggestion(msg)
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		return nil // Empty file; required fields are reported by validation
+	}
+	return err
+}
+
+// unknownFieldRe matches yaml.v3's unknown field error
+var unknownFieldRe = regexp.MustCompile(`^line \d+: field (\S+) not found in type config\.(\w+)$`)
+
+// withSuggestion appends the closest known field name to an unknown field
+// error, e.g. rotate_minuts -> rotate_minutes
+func withSuggestion(msg string) string {
+	m := unknownFieldRe.FindStringSubmatch(msg)
+	if m == nil {
+		return msg
+	}
+
+	field, typeName := m[1], m[2]
+	best, bestDistance := "", len(field)/2+1
+	for _, known := range yamlFields()[typeName] {
+		if d := editDistance(field, known); d < bestDistance {
+			best, bestDistance = known, d
+		}
+	}
+	if best == "" {
+		return msg
+	}
+	return fmt.Sprintf("%s (did you mean %q?)", msg, best)
+}
+
+// yamlFields maps each config struct type name to its YAML field names
+func yamlFields() map[string][]string {
+	fields := make(map[string][]string)
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for t.Kind() == reflect.Slice || t.Kind() == reflect.Map || t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		if _, seen := fields[t.Name()]; seen {
+			return
+		}
+		fields[t.Name()] = nil
+
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			fields[t.Name()] = append(fields[t.Name()], name)
+			walk(t.Field(i).Type)
+		}
+	}
+	walk(reflect.TypeOf(Config{}))
+	return fields
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// checkRanges validates numeric settings after defaults are applied
+func checkRanges(cfg *Config) error {
+	checks := []struct {
+		name  string
+		value int64
+		min   int64
+	}{
+		{"recorder.rotate_minutes", int64(cfg.Recorder.RotateMinutes), 1},
+		{"recorder.rotate_megabytes", int64(cfg.Recorder.RotateMegabytes), 1},
+		{"recorder.buffer_size", int64(cfg.Recorder.BufferSize), 1},
+		{"recorder.min_free_megabytes", int64(cfg.Recorder.MinFreeMegabytes), 0},
+		{"recorder.spill_buffer_size", int64(cfg.Recorder.SpillBufferSize), -1},
+		{"recorder.idle_minutes", int64(cfg.Recorder.IdleMinutes), -1},
+		{"recorder.max_open_files", int64(cfg.Recorder.MaxOpenFiles), 1},
+		{"recorder.shards", int64(cfg.Recorder.Shards), 1},
+		{"agent.batch_size", int64(cfg.Agent.BatchSize), 1},
+		{"agent.flush_ms", int64(cfg.Agent.FlushMs), 1},
+		{"shutdown.timeout_seconds", int64(cfg.Shutdown.TimeoutSeconds), 1},
+		{"shutdown.progress_seconds", int64(cfg.Shutdown.ProgressSeconds), 1},
+		{"recorder.fsync_seconds", int64(cfg.Recorder.FsyncSeconds), 1},
+		{"recorder.memory_megabytes", int64(cfg.Recorder.MemoryMegabytes), -1},
+		{"recorder.overflow.max_megabytes", int64(cfg.Recorder.Overflow.MaxMegabytes), 1},
+		{"recorder.summaries.top_chatters", int64(cfg.Recorder.Summaries.TopChatters), 0},
+		{"recorder.seekable.frame_minutes", int64(cfg.Recorder.Seekable.FrameMinutes), 1},
+		{"uploader.check_interval_seconds", int64(cfg.Uploader.CheckIntervalSeconds), 1},
+		{"uploader.max_retries", int64(cfg.Uploader.MaxRetries), 0},
+		{"uploader.hedge.min_seconds", int64(cfg.Uploader.Hedge.MinSeconds), 1},
+		{"uploader.dead_letter_retry.base_minutes", int64(cfg.Uploader.DeadLetterRetry.BaseMinutes), 1},
+		{"uploader.dead_letter_retry.max_hours", int64(cfg.Uploader.DeadLetterRetry.MaxHours), 1},
+		{"uploader.dead_letter_retry.max_age_hours", int64(cfg.Uploader.DeadLetterRetry.MaxAgeHours), 1},
+		{"twitch.discovery.max_channels", int64(cfg.Twitch.Discovery.MaxChannels), 1},
+		{"twitch.discovery.interval_minutes", int64(cfg.Twitch.Discovery.IntervalMinutes), 1},
+		{"twitch.presence.interval_minutes", int64(cfg.Twitch.Presence.IntervalMinutes), 0},
+		{"twitch.channels_per_connection", int64(cfg.Twitch.ChannelsPerConnection), 1},
+		{"twitch.rate_limits.joins", int64(cfg.Twitch.RateLimits.Joins), 1},
+		{"twitch.rate_limits.join_window_seconds", int64(cfg.Twitch.RateLimits.JoinWindowSeconds), 1},
+		{"twitch.rate_limits.messages", int64(cfg.Twitch.RateLimits.Messages), 1},
+		{"twitch.rate_limits.message_window_seconds", int64(cfg.Twitch.RateLimits.MessageWindowSeconds), 1},
+		{"twitch.rate_limits.join_timeout_seconds", int64(cfg.Twitch.RateLimits.JoinTimeoutSeconds), 1},
+		{"optout.reload_minutes", int64(cfg.OptOut.ReloadMinutes), 1},
+		{"stats.silent_minutes", int64(cfg.Stats.SilentMinutes), -1},
+		{"stats.live_check_minutes", int64(cfg.Stats.LiveCheckMinutes), 1},
+		{"youtube.live_check_minutes", int64(cfg.YouTube.LiveCheckMinutes), 1},
+		{"mastodon.poll_seconds", int64(cfg.Mastodon.PollSeconds), 10},
+		{"s3.presign.max_expiry_hours", int64(cfg.S3.Presign.MaxExpiryHours), 1},
+		{"annotations.hold_minutes", int64(cfg.Annotations.HoldMinutes), 1},
+		{"sinks.buffer_size", int64(cfg.Sinks.BufferSize), 1},
+		{"sinks.redis.db", int64(cfg.Sinks.Redis.DB), 0},
+		{"sinks.redis.max_len", cfg.Sinks.Redis.MaxLen, 0},
+		{"sinks.postgres.retention_days", int64(cfg.Sinks.Postgres.RetentionDays), 1},
+		{"highlights.window_seconds", int64(cfg.Highlights.WindowSeconds), 2},
+		{"highlights.baseline_minutes", int64(cfg.Highlights.BaselineMinutes), 1},
+		{"highlights.cooldown_seconds", int64(cfg.Highlights.CooldownSeconds), 0},
+		{"highlights.top_terms", int64(cfg.Highlights.TopTerms), 0},
+		{"sinks.postgres.batch_size", int64(cfg.Sinks.Postgres.BatchSize), 1},
+		{"leader.lease_seconds", int64(cfg.Leader.LeaseSeconds), 3},
+		{"instance_check.heartbeat.interval_seconds", int64(cfg.Instance.Heartbeat.IntervalSeconds), 5},
+		{"kick.resolve_interval_ms", int64(cfg.Kick.ResolveIntervalMs), 100},
+		{"kick.resolve_retry.base_ms", int64(cfg.Kick.ResolveRetry.BaseMs), 1},
+		{"kick.resolve_retry.max_seconds", int64(cfg.Kick.ResolveRetry.MaxSeconds), 1},
+		{"twitch.raw_log.max_megabytes", int64(cfg.Twitch.RawLog.MaxMegabytes), 1},
+		{"twitch.raw_log.max_files", int64(cfg.Twitch.RawLog.MaxFiles), 1},
+		{"kick.raw_log.max_megabytes", int64(cfg.Kick.RawLog.MaxMegabytes), 1},
+		{"kick.raw_log.max_files", int64(cfg.Kick.RawLog.MaxFiles), 1},
+		{"irc.raw_log.max_megabytes", int64(cfg.IRC.RawLog.MaxMegabytes), 1},
+		{"irc.raw_log.max_files", int64(cfg.IRC.RawLog.MaxFiles), 1},
+		{"kick.resolve_retry.max_elapsed_seconds", int64(cfg.Kick.ResolveRetry.MaxElapsedSeconds), -1},
+		{"uploader.retry.base_ms", int64(cfg.Uploader.Retry.BaseMs), 1},
+		{"uploader.retry.max_seconds", int64(cfg.Uploader.Retry.MaxSeconds), 1},
+		{"uploader.retry.max_elapsed_seconds", int64(cfg.Uploader.Retry.MaxElapsedSeconds), -1},
+		{"kick.rename_check_minutes", int64(cfg.Kick.RenameCheckMinutes), -1},
+		{"kick.max_clock_skew_seconds", int64(cfg.Kick.MaxClockSkewSeconds), -1},
+		{"twitch.user_info.cache_hours", int64(cfg.Twitch.UserInfo.CacheHours), 1},
+		{"twitch.user_info.max_users", int64(cfg.Twitch.UserInfo.MaxUsers), 1},
+		{"twitch.chatters.interval_minutes", int64(cfg.Twitch.Chatters.IntervalMinutes), 1},
+		{"twitch.chatters.max_users", int64(cfg.Twitch.Chatters.MaxUsers), 1},
+	}
+	for _, c := range checks {
+		if c.value < c.min {
+			return fmt.Errorf("%s must be at least %d, got %d", c.name, c.min, c.value)
+		}
+	}
+	// SigV4 presigned URLs can't outlive seven days
+	if cfg.S3.Presign.MaxExpiryHours > 168 {
+		return fmt.Errorf("s3.presign.max_expiry_hours must be at most 168, got %d", cfg.S3.Presign.MaxExpiryHours)
+	}
+
+	for i, ch := range cfg.Kick.Channels {
+		if ch.Slug == "" {
+			return fmt.Errorf("kick.channels[%d].slug is required", i)
+		}
+		if ch.ChatroomID < 0 {
+			return fmt.Errorf("kick.channels[%d].chatroom_id must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// ircNetworkNameRe restricts IRC network names to what is safe in file names
+var ircNetworkNameRe = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// postgresTableRe matches Postgres sink table names that need no quoting
+var postgresTableRe = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,49}$`)
+
+// validateIRC checks the IRC networks when IRC logging is enabled
+func validateIRC(irc IRCConfig) error {
+	if !irc.Enabled {
+		return nil
+	}
+
+	names := make(map[string]bool)
+	for i, network := range irc.Networks {
+		if !ircNetworkNameRe.MatchString(network.Name) {
+			return fmt.Errorf("irc.networks[%d].name must be lowercase letters, digits and dashes, got %q", i, network.Name)
+		}
+		if names[network.Name] {
+			return fmt.Errorf("irc.networks[%d].name %q is used more than once", i, network.Name)
+		}
+		names[network.Name] = true
+
+		if network.Server == "" {
+			return fmt.Errorf("irc.networks[%d].server is required", i)
+		}
+		if network.Nick == "" {
+			return fmt.Errorf("irc.networks[%d].nick is required", i)
+		}
+		if (network.SASLUsername == "") != (network.SASLPassword == "") {
+			return fmt.Errorf("irc.networks[%d]: sasl_username and sasl_password must be set together", i)
+		}
+	}
+	return nil
+}
+
+// adminRoles are the roles an admin token may have
+var adminRoles = []string{"viewer", "operator", "admin"}
+
+// validateAdmin checks admin.tokens
+func validateAdmin(a AdminConfig) error {
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for i, t := range a.Tokens {
+		if t.Name == "" {
+			return fmt.Errorf("admin.tokens[%d].name is required", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("admin.tokens[%d].name %q is used more than once", i, t.Name)
+		}
+		names[t.Name] = true
+
+		if t.Token == "" {
+			return fmt.Errorf("admin.tokens[%d] (%s): token is required", i, t.Name)
+		}
+		if tokens[t.Token] {
+			return fmt.Errorf("admin.tokens[%d] (%s): token is the same as another token's", i, t.Name)
+		}
+		tokens[t.Token] = true
+
+		if !slices.Contains(adminRoles, strings.ToLower(t.Role)) {
+			return fmt.Errorf("admin.tokens[%d] (%s): role must be one of %s, got %q", i, t.Name, strings.Join(adminRoles, ", "), t.Role)
+		}
+	}
+	return nil
+}
+
+// collectWarnings returns settings that are valid but probably not what
+// was intended
+func collectWarnings(cfg *Config) []string {
+	var warnings []string
+	warn := func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	if cfg.Kick.Enabled && len(cfg.Kick.Channels) == 0 {
+		warn("kick.enabled is true but no kick.channels are configured")
+	}
+	if !cfg.Kick.Enabled && len(cfg.Kick.Channels) > 0 {
+		warn("kick.channels are configured but kick.enabled is false, so they are ignored")
+	}
+	if cfg.Bluesky.Enabled && len(cfg.Bluesky.Hashtags) == 0 && len(cfg.Bluesky.Handles) == 0 {
+		warn("bluesky.enabled is true but no bluesky.hashtags or bluesky.handles are configured")
+	}
+	if !cfg.Bluesky.Enabled && len(cfg.Bluesky.Hashtags)+len(cfg.Bluesky.Handles) > 0 {
+		warn("bluesky hashtags or handles are configured but bluesky.enabled is false, so they are ignored")
+	}
+	if cfg.Mastodon.Enabled && len(cfg.Mastodon.Hashtags) == 0 && len(cfg.Mastodon.Accounts) == 0 {
+		warn("mastodon.enabled is true but no mastodon.hashtags or mastodon.accounts are configured")
+	}
+	if !cfg.Mastodon.Enabled && len(cfg.Mastodon.Hashtags)+len(cfg.Mastodon.Accounts) > 0 {
+		warn("mastodon hashtags or accounts are configured but mastodon.enabled is false, so they are ignored")
+	}
+	if cfg.YouTube.Enabled && len(cfg.YouTube.Channels) == 0 {
+		warn("youtube.enabled is true but no youtube.channels are configured")
+	}
+	if !cfg.YouTube.Enabled && len(cfg.YouTube.Channels) > 0 {
+		warn("youtube.channels are configured but youtube.enabled is false, so they are ignored")
+	}
+	if cfg.YouTube.Enabled && cfg.YouTube.LiveCheckMinutes > 0 {
+		// Live searches cost 100 units each against a default quota of 10,000 a day
+		searched := 0
+		for _, ch := range cfg.YouTube.Channels {
+			if ch.VideoID == "" {
+				searched++
+			}
+		}
+		if units := searched * 100 * (24 * 60 / cfg.YouTube.LiveCheckMinutes); units > 10000 {
+			warn("youtube live checks for %d channel(s) every %d minute(s) use about %d API quota units a day, over the default 10,000", searched, cfg.YouTube.LiveCheckMinutes, units)
+		}
+	}
+	if cfg.IRC.Enabled && len(cfg.IRC.Networks) == 0 {
+		warn("irc.enabled is true but no irc.networks are configured")
+	}
+	for _, network := range cfg.IRC.Networks {
+		if cfg.IRC.Enabled && len(network.Channels) == 0 {
+			warn("irc network %q has no channels", network.Name)
+		}
+		if network.Plaintext && (network.SASLPassword != "" || network.NickServPassword != "" || network.Password != "") {
+			warn("irc network %q sends passwords over a plaintext connection", network.Name)
+		}
+	}
+	rawLogs := []struct {
+		name string
+		path string
+	}{{"twitch", cfg.Twitch.RawLog.Path}, {"kick", cfg.Kick.RawLog.Path}, {"irc", cfg.IRC.RawLog.Path}}
+	for _, r := range rawLogs {
+		if r.path != "" {
+			warn("%s.raw_log writes every frame to %s, including chat by opted-out users; enable it only while debugging", r.name, r.path)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, channel := range cfg.Twitch.Channels {
+		channel = strings.ToLower(strings.TrimPrefix(channel, "#"))
+		if seen[channel] {
+			warn("twitch channel %q is listed more than once", channel)
+		}
+		seen[channel] = true
+	}
+
+	if p := cfg.Twitch.Presence; p.Enabled && p.JoinMessage == "" && p.Message == "" && p.Command == "" {
+		warn("twitch.presence.enabled is true but no join_message, message or command is set")
+	}
+	if cfg.Twitch.StreamSnapshots && cfg.Twitch.Anonymous() {
+		warn("twitch.stream_snapshots needs twitch.username and twitch.oauth for Helix, so no snapshots are recorded")
+	} else if cfg.Twitch.StreamSnapshots && cfg.Twitch.ClientID == "" {
+		warn("twitch.stream_snapshots needs twitch.client_id (or TWITCH_CLIENT_ID), so no snapshots are recorded")
+	}
+	if cfg.Twitch.UserInfo.Enabled && cfg.Twitch.Anonymous() {
+		warn("twitch.user_info needs twitch.username and twitch.oauth for Helix, so messages are not enriched")
+	}
+	if cfg.Twitch.Chatters.Enabled && cfg.Twitch.Anonymous() {
+		warn("twitch.chatters needs twitch.username and twitch.oauth for Helix, so no chatters snapshots are recorded")
+	}
+	if cfg.Twitch.Transport == TwitchTransportEventSub {
+		if len(cfg.Twitch.Accounts) > 0 {
+			warn("twitch.accounts are only used with twitch.transport irc, so EventSub reads chat with twitch.username alone")
+		}
+		if n := len(cfg.Twitch.Channels); n > 300 {
+			warn("twitch.transport eventsub holds at most 300 channel subscriptions, but %d channels are configured", n)
+		}
+		if cfg.Twitch.Discovery.Enabled && len(cfg.Twitch.Channels)+cfg.Twitch.Discovery.MaxChannels > 300 {
+			warn("twitch.discovery.max_channels may take twitch.transport eventsub past its 300 subscription limit")
+		}
+	}
+	if cfg.Stats.SilentMinutes > 0 && len(cfg.Twitch.Channels) > 0 && cfg.Twitch.ClientID == "" {
+		warn("silent channel detection needs twitch.client_id (or TWITCH_CLIENT_ID) to check live status")
+	}
+
+	if cfg.Recorder.RotateMinutes > 24*60 {
+		warn("recorder.rotate_minutes is over a day; files are filed under the day they start")
+	}
+
+	if cfg.Uploader.Mode != UploadModeS3 && cfg.S3.Bucket != "" {
+		warn("s3 settings are ignored because uploader.mode is %s", cfg.Uploader.Mode)
+	}
+	if cfg.Uploader.Mode == UploadModeS3 && !cfg.Uploader.DeleteAfterUpload {
+		warn("uploader.delete_after_upload is false, so uploaded files accumulate in %s", cfg.Recorder.OutputDir)
+	}
+	if rl := cfg.Twitch.RateLimits; cfg.Twitch.Username == "" && (rl.Verified || rl.Joins > 20) {
+		warn("twitch.rate_limits above the defaults apply only to verified bot accounts, but twitch.username is empty")
+	}
+	if cfg.Recorder.EmoteStats.Enabled {
+		if len(cfg.Recorder.Encryption.Recipients) > 0 {
+			warn("recorder.emote_stats sidecars are not encrypted, though the chat files are")
+		}
+		if !slices.ContainsFunc(cfg.Uploader.Scan.Patterns, func(p string) bool {
+			ok, _ := filepath.Match(p, "x.emotes.json")
+			return ok
+		}) {
+			warn("uploader.scan.patterns don't match *.emotes.json, so recorder.emote_stats sidecars left by a previous run aren't uploaded")
+		}
+	}
+	if cfg.Recorder.SearchIndex.Enabled {
+		if len(cfg.Recorder.Encryption.Recipients) > 0 {
+			warn("recorder.search_index sidecars are not encrypted, so they reveal which users and terms appear in the encrypted chat files")
+		}
+		if !slices.ContainsFunc(cfg.Uploader.Scan.Patterns, func(p string) bool {
+			ok, _ := filepath.Match(p, "x.search.json")
+			return ok
+		}) {
+			warn("uploader.scan.patterns don't match *.search.json, so recorder.search_index sidecars left by a previous run aren't uploaded")
+		}
+	}
+	if cfg.Leader.Enabled && cfg.Instance.Heartbeat.Enabled {
+		warn("instance_check.heartbeat is ignored with leader election, whose standbys record the same channels by design")
+	}
+	if cfg.Leader.Enabled && cfg.Twitch.Presence.Enabled {
+		warn("twitch.presence messages are sent by every instance, including leader standbys")
+	}
+	if cfg.Sinks.Overlay.Enabled && cfg.Sinks.Overlay.Token == "" {
+		warn("sinks.overlay has no token, so anyone who can reach the health port can read live chat")
+	}
+	if len(cfg.Admin.Tokens) == 0 {
+		warn("admin.tokens is empty, so /admin/stats, /admin/retry-failed, /admin/pauses and /admin/features refuse every request")
+	}
+	for _, t := range cfg.Admin.Tokens {
+		if len(t.Token) < 16 && !strings.Contains(t.Token, "://") { // References are checked once resolved
+			warn("admin token %q is shorter than 16 characters", t.Name)
+		}
+	}
+	if !cfg.Alerts.Enabled && len(cfg.Alerts.Rules) > 0 {
+		warn("alerts.rules are configured but alerts.enabled is false, so no alerts are sent")
+	}
+	if cfg.Alerts.Enabled && cfg.Alerts.WebhookSecret == "" {
+		warn("alerts.webhook_secret is not set, so alert webhooks are unsigned")
+	}
+	if cfg.Highlights.Enabled && cfg.Highlights.WebhookURL != "" && cfg.Highlights.WebhookSecret == "" {
+		warn("highlights.webhook_secret is not set, so highlight webhooks are unsigned")
+	}
+	for _, group := range cfg.Groups {
+		if len(group.Channels) == 0 {
+			warn("group %s has no channels", group.Name)
+		}
+		if slices.Contains(group.Sinks, "redis") && !cfg.Sinks.Redis.Enabled {
+			warn("group %s sends to the redis sink, but sinks.redis.enabled is false", group.Name)
+		}
+		if slices.Contains(group.Sinks, "postgres") && !cfg.Sinks.Postgres.Enabled {
+			warn("group %s sends to the postgres sink, but sinks.postgres.enabled is false", group.Name)
+		}
+		if slices.Contains(group.Sinks, "overlay") && !cfg.Sinks.Overlay.Enabled {
+			warn("group %s sends to the overlay sink, but sinks.overlay.enabled is false", group.Name)
+		}
+		if group.KeyPrefix != "" && cfg.Uploader.Mode == UploadModeNone {
+			warn("group %s key_prefix is ignored because uploader.mode is none", group.Name)
+		}
+		if group.S3 != nil && cfg.Uploader.Mode != UploadModeS3 {
+			warn("group %s s3 is ignored because uploader.mode is %s, so its files are not kept apart", group.Name, cfg.Uploader.Mode)
+		}
+		sharedSinks := cfg.Sinks.Redis.Enabled || cfg.Sinks.Postgres.Enabled || cfg.Sinks.Overlay.Enabled || len(cfg.Sinks.Custom) > 0
+		if group.S3 != nil && sharedSinks && (group.Sinks == nil || len(group.Sinks) > 0) {
+			warn("group %s uploads to its own bucket, but its messages still reach shared sinks; set sinks: [] to keep them apart", group.Name)
+		}
+	}
+	for _, tenant := range cfg.Tenants {
+		for _, entry := range tenant.Channels {
+			platform, channel, _ := strings.Cut(entry, ":")
+			group := cfg.Group(platform, channel)
+			if group != nil && (group.RotateMinutes != 0 || group.RotateMegabytes != 0 || group.KeyPrefix != "" || group.S3 != nil) {
+				warn("tenant %s channel %s is in group %s, whose rotation, key_prefix and s3 settings don't apply to tenants", tenant.Name, entry, group.Name)
+			}
+		}
+	}
+	if cfg.Uploader.Mode == UploadModeNone && len(cfg.Uploader.Schedule.Windows) > 0 {
+		warn("uploader.schedule is ignored because uploader.mode is none")
+	}
+	if cfg.Uploader.Hedge.Enabled && cfg.Uploader.Mode != UploadModeS3 {
+		warn("uploader.hedge only applies to uploader.mode s3")
+	}
+	if cfg.Uploader.DeadLetterRetry.Enabled && cfg.Uploader.Mode == UploadModeNone {
+		warn("uploader.dead_letter_retry has no effect with uploader.mode none")
+	}
+	if cfg.Uploader.Mode == UploadModeNone && cfg.Uploader.Notify.Enabled() {
+		warn("uploader.notify is ignored because uploader.mode is none")
+	}
+	if (cfg.Uploader.Notify.SQSQueueURL != "" || cfg.Uploader.Notify.SNSTopicARN != "") && cfg.Uploader.Notify.Region == "" {
+		warn("uploader.notify has no region (set uploader.notify.region or s3.region), so AWS_REGION must be set")
+	}
+	if cfg.Uploader.Mode == UploadModeS3 && cfg.S3.AccessKeyID != "" && cfg.S3.RoleARN == "" {
+		warn("static S3 credentials are deprecated; consider s3.role_arn (OIDC)")
+	}
+	if cfg.S3.OIDC.Source != "" && cfg.S3.RoleARN == "" {
+		warn("s3.oidc is set but s3.role_arn is not, so no OIDC token is exchanged")
+	}
+	if cfg.Shutdown.ProgressSeconds >= cfg.Shutdown.TimeoutSeconds {
+		warn("shutdown.progress_seconds is not less than shutdown.timeout_seconds, so no progress is logged before the timeout")
+	}
+
+	return warnings
+}
@@ -0,0 +1,215 @@
+// Package egress routes connectors' outbound connections through a proxy
+// and custom DNS servers, for networks without direct internet access
+package egress
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialTimeout bounds connecting to a proxy or, without one, a server
+const dialTimeout = 30 * time.Second
+
+// Network is how a connector reaches servers: directly or through a proxy,
+// resolving hostnames with the system's DNS or the given servers. The zero
+// value connects directly with the system's DNS.
+type Network struct {
+	proxy    *url.URL      // nil connects directly
+	resolver *net.Resolver // nil uses the system's DNS
+}
+
+// New creates a network. proxyURL is an http, https, socks5 or socks5h URL,
+// optionally with user:password, or empty to connect directly. dnsServers
+// are host or host:port addresses tried in order, or empty for the
+// system's DNS. They resolve the proxy's host, and servers' hosts when
+// connecting directly or through a socks5 proxy; http, https and socks5h
+// proxies resolve servers' hosts themselves.
+func New(proxyURL string, dnsServers []string) (*Network, error) {
+	n := &Network{}
+	if proxyURL != "" {
+		u, err := ParseProxy(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		n.proxy = u
+	}
+	if len(dnsServers) > 0 {
+		servers := make([]string, len(dnsServers))
+		for i, server := range dnsServers {
+			servers[i] = DNSAddress(server)
+		}
+		n.resolver = newResolver(servers)
+	}
+	return n, nil
+}
+
+// ParseProxy parses a proxy URL, checking its scheme and host. Errors leave
+// out the URL, which may hold credentials.
+func ParseProxy(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, errors.New("invalid proxy URL")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy scheme must be http, https, socks5 or socks5h, got %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("proxy URL has no host")
+	}
+	return u, nil
+}
+
+// DNSAddress adds the default port, 53, to a DNS server address without one
+func DNSAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+}
+
+// newResolver creates a resolver that queries servers in order, moving on
+// to the next when one can't be reached
+func newResolver(servers []string) *net.Resolver {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var lastErr error
+			for _, server := range servers {
+				conn, err := dialer.DialContext(ctx, network, server)
+				if err == nil {
+					return conn, nil
+				}
+				lastErr = err
+			}
+			return nil, lastErr
+		},
+	}
+}
+
+// String describes the network for logs, without proxy credentials
+func (n *Network) String() string {
+	var parts []string
+	if n.proxy != nil {
+		parts = append(parts, "proxy "+n.proxy.Scheme+"://"+n.proxy.Host)
+	}
+	if n.resolver != nil {
+		parts = append(parts, "custom DNS")
+	}
+	if len(parts) == 0 {
+		return "direct"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// dialer returns a dialer using the network's resolver
+func (n *Network) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second, Resolver: n.resolver}
+}
+
+// DialContext connects to addr over TCP, tunnelling through the proxy if
+// there is one
+func (n *Network) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if n.proxy == nil {
+		return n.dialer().DialContext(ctx, network, addr)
+	}
+
+	conn, err := n.dialer().DialContext(ctx, "tcp", proxyAddress(n.proxy))
+	if err != nil {
+		return nil, fmt.Errorf("dial proxy: %w", err)
+	}
+	if n.proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: n.proxy.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	// Bound the handshake by the context's deadline, or the dial timeout
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(dialTimeout)
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	switch n.proxy.Scheme {
+	case "socks5":
+		var resolved string
+		if resolved, err = n.resolve(ctx, addr); err == nil {
+			err = socks5Connect(conn, n.proxy.User, resolved)
+		}
+	case "socks5h":
+		err = socks5Connect(conn, n.proxy.User, addr)
+	default:
+		err = httpConnect(conn, n.proxy.User, addr)
+	}
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("proxy %s: %w", n.proxy.Host, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// resolve replaces addr's hostname with its first address
+func (n *Network) resolve(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, err
+	}
+	resolver := n.resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0].IP.String(), port), nil
+}
+
+// proxyAddress returns the proxy's host:port, with its scheme's default
+// port if the URL has none
+func proxyAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[u.Scheme]
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// HTTPClient returns a client with the given timeout whose requests go
+// through the network
+func (n *Network) HTTPClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = n.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// WebSocketDialer returns a WebSocket dialer that connects through the
+// network
+func (n *Network) WebSocketDialer() *websocket.Dialer {
+	return &websocket.Dialer{
+		NetDialContext:   n.DialContext,
+		HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+	}
+}
@@ -0,0 +1,79 @@
+package egress
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+)
+
+// RelayTLS listens on a loopback port and connects each connection
+// accepted there to addr over TLS through the network, for clients that
+// can't be given a dialer. Clients connect to the returned address in
+// plaintext; the certificate is verified against addr's host. If tap is
+// set, it is called for each connection and what the client sends and
+// receives is copied to the writers it returns. The relay stops when ctx
+// is done.
+func (n *Network) RelayTLS(ctx context.Context, addr string, tap func() (sent, received io.Writer)) (string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	context.AfterFunc(ctx, func() { listener.Close() })
+
+	go func() {
+		for {
+			local, err := listener.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("Relay to %s stopped: %v", addr, err)
+				}
+				return
+			}
+			go n.relay(ctx, local, addr, host, tap)
+		}
+	}()
+	return listener.Addr().String(), nil
+}
+
+// relay connects one local connection to addr and copies between them
+// until either side closes, then closes both
+func (n *Network) relay(ctx context.Context, local net.Conn, addr, host string, tap func() (sent, received io.Writer)) {
+	defer local.Close()
+
+	conn, err := n.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		log.Printf("Relay to %s failed: %v", addr, err)
+		return
+	}
+	remote := tls.Client(conn, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+	defer remote.Close()
+	if err := remote.HandshakeContext(ctx); err != nil {
+		log.Printf("Relay to %s failed: TLS handshake: %v", addr, err)
+		return
+	}
+
+	var from, to io.Reader = local, remote
+	if tap != nil {
+		sent, received := tap()
+		from, to = io.TeeReader(local, sent), io.TeeReader(remote, received)
+	}
+
+	// The deferred closes end the other copy once one finishes
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, from)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, to)
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,195 @@
+package egress
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// httpConnect opens a tunnel to addr through an HTTP proxy with CONNECT,
+// authenticating with Basic credentials if user is set
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("send CONNECT: %w", err)
+	}
+
+	// Read the response a byte at a time, so nothing the server sends
+	// after it is buffered away from the caller
+	resp, err := http.ReadResponse(bufio.NewReaderSize(byteReader{conn}, 1), req)
+	if err != nil {
+		return fmt.Errorf("read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	return nil
+}
+
+// byteReader reads at most one byte at a time
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
+
+// SOCKS5 protocol values (RFC 1928, RFC 1929)
+const (
+	socksVersion     = 5
+	socksNoAuth      = 0
+	socksPassword    = 2
+	socksNoMethod    = 0xff
+	socksConnect     = 1
+	socksIPv4        = 1
+	socksDomain      = 3
+	socksIPv6        = 4
+	socksSucceeded   = 0
+	socksAuthVersion = 1
+)
+
+// socksReplies describes SOCKS5 reply codes
+var socksReplies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect opens a tunnel to addr through a SOCKS5 proxy, with
+// username/password authentication if user is set. Hostnames in addr are
+// sent to the proxy to resolve.
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", portText)
+	}
+
+	methods := []byte{socksNoAuth}
+	if user != nil {
+		methods = []byte{socksPassword}
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("read method: %w", err)
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("not a SOCKS5 proxy")
+	}
+	switch reply[1] {
+	case socksNoAuth:
+	case socksPassword:
+		if user == nil {
+			return errors.New("proxy requires a username and password")
+		}
+		if err := socks5Authenticate(conn, user); err != nil {
+			return err
+		}
+	case socksNoMethod:
+		return errors.New("proxy accepted no authentication method")
+	default:
+		return fmt.Errorf("proxy chose unsupported authentication method %d", reply[1])
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip.To4() != nil {
+		req = append(append(req, socksIPv4), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, socksIPv6), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("hostname too long: %s", host)
+		}
+		req = append(append(req, socksDomain, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Reply: version, status, reserved, then the bound address
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return fmt.Errorf("read reply: %w", err)
+	}
+	if head[1] != socksSucceeded {
+		if text, ok := socksReplies[head[1]]; ok {
+			return fmt.Errorf("connect %s: %s", addr, text)
+		}
+		return fmt.Errorf("connect %s: reply code %d", addr, head[1])
+	}
+	var skip int
+	switch head[3] {
+	case socksIPv4:
+		skip = net.IPv4len
+	case socksIPv6:
+		skip = net.IPv6len
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return fmt.Errorf("read reply: %w", err)
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("unknown bound address type %d", head[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return fmt.Errorf("read reply: %w", err)
+	}
+	return nil
+}
+
+// socks5Authenticate sends a username and password (RFC 1929)
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("proxy username or password too long")
+	}
+	req := append([]byte{socksAuthVersion, byte(len(username))}, username...)
+	req = append(append(req, byte(len(password))), password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("read authentication reply: %w", err)
+	}
+	if reply[1] != socksSucceeded {
+		return errors.New("proxy rejected the username and password")
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package enrich
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+func init() {
+	Register("links", newLinks)
+	Register("emotes", newEmotes)
+	Register("language", newLanguage)
+	Register("profanity", newProfanity)
+}
+
+// links records the URLs in a message as "links"
+type links struct{}
+
+// linkRe matches http(s) URLs and bare www. links
+var linkRe = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+func newLinks(options map[string]any) (Processor, error) {
+	return links{}, nil
+}
+
+func (links) Process(msg *message.Message) {
+	found := linkRe.FindAllString(msg.Message, -1)
+	if len(found) == 0 {
+		return
+	}
+	for i, link := range found {
+		link = strings.TrimRight(link, ".,;:!?)]}'")
+		if strings.HasPrefix(strings.ToLower(link), "www.") {
+			link = "https://" + link
+		}
+		found[i] = link
+	}
+	msg.Enrich("links", found)
+}
+
+// emotes normalizes inline emote markup, such as Kick's [emote:123:name],
+// into the message's emote list and records the message with the markup
+// replaced by emote names as "plain_text"
+type emotes struct{}
+
+// inlineEmoteRe matches Kick-style inline emotes
+var inlineEmoteRe = regexp.MustCompile(`\[emote:\d+:([^\]]+)\]`)
+
+func newEmotes(options map[string]any) (Processor, error) {
+	return emotes{}, nil
+}
+
+func (emotes) Process(msg *message.Message) {
+	matches := inlineEmoteRe.FindAllStringSubmatch(msg.Message, -1)
+	if len(matches) == 0 {
+		return
+	}
+	if len(msg.Emotes) == 0 {
+		for _, m := range matches {
+			msg.Emotes = append(msg.Emotes, m[1])
+		}
+	}
+	msg.Enrich("plain_text", inlineEmoteRe.ReplaceAllString(msg.Message, "$1"))
+}
+
+// language guesses a message's language as an ISO 639-1 code in "lang".
+// Non-Latin scripts are identified by script; Latin-script messages by
+// common words, only when at least min_words of them agree. Messages that
+// can't be told apart, which is most short chat, get no field.
+type language struct {
+	minWords int
+}
+
+func newLanguage(options map[string]any) (Processor, error) {
+	l := language{minWords: 2}
+	if raw, ok := options["min_words"]; ok {
+		n, ok := raw.(int)
+		if !ok || n < 1 {
+			return nil, fmt.Errorf("min_words must be a positive integer")
+		}
+		l.minWords = n
+	}
+	return l, nil
+}
+
+// scripts maps Unicode scripts to the language they most likely indicate
+var scripts = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// stopwords are frequent words that belong to one language; words shared
+// by several languages map to ""
+var stopwords = map[string]string{}
+
+func init() {
+	for lang, words := range map[string]string{
+		"en": "the and you that this what with have for are not just was but your its dont like they know",
+		"es": "que los las por pero como esta para una muy porque cuando tiene eso hay todo bien",
+		"pt": "que não uma com para você isso muito mas tem está foi mais então também",
+		"fr": "les des est pas une pour que qui dans sur avec mais tout cest très oui",
+		"de": "und der die das ist nicht ich du mit auf ein eine aber auch was wie",
+		"it": "che non per una sono della come anche questo perché molto cosa",
+		"nl": "het een niet van dat ook maar wat zijn hij voor naar heb",
+		"pl": "nie jest się tak jak ale czy już tylko może jestem",
+		"tr": "bir bu ve için ama çok ne var yok gibi daha",
+	} {
+		for _, word := range strings.Fields(words) {
+			if other, taken := stopwords[word]; taken && other != lang {
+				stopwords[word] = ""
+			} else {
+				stopwords[word] = lang
+			}
+		}
+	}
+}
+
+func (l language) Process(msg *message.Message) {
+	if lang := l.detect(msg); lang != "" {
+		msg.Enrich("lang", lang)
+	}
+}
+
+// detect returns the most likely language of a message, or ""
+func (l language) detect(msg *message.Message) string {
+	text := msg.Message
+	if plain, ok := msg.Enrichment["plain_text"].(string); ok {
+		text = plain
+	}
+
+	counts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range scripts {
+			if unicode.Is(s.table, r) {
+				counts[s.lang]++
+				break
+			}
+		}
+	}
+	// Kana marks Japanese even when mixed with Han
+	if counts["ja"] > 0 {
+		return "ja"
+	}
+	if lang, n := best(counts); n*2 > letters {
+		return lang
+	}
+
+	counts = make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		if lang := stopwords[strings.ReplaceAll(word, "'", "")]; lang != "" {
+			counts[lang]++
+		}
+	}
+	lang, n := best(counts)
+	if n < l.minWords {
+		return ""
+	}
+	for other, m := range counts {
+		if other != lang && m == n {
+			return "" // Tie
+		}
+	}
+	return lang
+}
+
+// best returns the key with the highest count
+func best(counts map[string]int) (string, int) {
+	var lang string
+	var n int
+	for k, v := range counts {
+		if v > n || (v == n && k < lang) {
+			lang, n = k, v
+		}
+	}
+	return lang, n
+}
+
+// profanity flags messages containing listed words with "profanity": true.
+// Words come from the words option and/or a file with one word per line;
+// matching is case-insensitive on whole words.
+type profanity struct {
+	words map[string]bool
+}
+
+func newProfanity(options map[string]any) (Processor, error) {
+	p := profanity{words: make(map[string]bool)}
+
+	words, err := stringList(options, "words")
+	if err != nil {
+		return nil, err
+	}
+	path, err := stringOption(options, "file")
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("open word list: %w", err)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				words = append(words, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read word list: %w", err)
+		}
+	}
+
+	for _, word := range words {
+		p.words[strings.ToLower(word)] = true
+	}
+	if len(p.words) == 0 {
+		return nil, fmt.Errorf("words or file is required")
+	}
+	return p, nil
+}
+
+func (p profanity) Process(msg *message.Message) {
+	for _, word := range strings.FieldsFunc(strings.ToLower(msg.Message), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if p.words[word] {
+			msg.Enrich("profanity", true)
+			return
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package enrich
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// Processor adds fields to chat messages. Process is called for every chat
+// message in order from a single goroutine, and typically records its
+// results with msg.Enrich.
+type Processor interface {
+	Process(msg *message.Message)
+}
+
+// Factory creates a processor from its YAML options, which may be nil
+type Factory func(options map[string]any) (Processor, error)
+
+var (
+	registry   = make(map[string]Factory)
+	registryMu sync.Mutex
+)
+
+// Register makes a processor available to the enrich.processors config
+// under name. It is typically called from an init function; registering a
+// name twice panics.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("enrich: processor %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Names returns the registered processor names, sorted
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Spec names a processor and its options
+type Spec struct {
+	Name    string
+	Options map[string]any
+}
+
+// Pipeline runs processors over each message in order
+type Pipeline struct {
+	names      []string
+	processors []Processor
+	switches   []func() bool // Per processor, reporting whether it is switched on; nil is always on
+}
+
+// Build creates the processors named in specs, in order
+func Build(specs []Spec) (*Pipeline, error) {
+	p := &Pipeline{}
+	for _, spec := range specs {
+		registryMu.Lock()
+		factory, ok := registry[spec.Name]
+		registryMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown processor %q (available: %s)", spec.Name, strings.Join(Names(), ", "))
+		}
+
+		proc, err := factory(spec.Options)
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %w", spec.Name, err)
+		}
+		p.names = append(p.names, spec.Name)
+		p.processors = append(p.processors, proc)
+	}
+	return p, nil
+}
+
+// Add appends a processor built outside the registry, such as one that
+// needs the platform credentials from the main config
+func (p *Pipeline) Add(name string, proc Processor) {
+	p.names = append(p.names, name)
+	p.processors = append(p.processors, proc)
+}
+
+// EnableSwitches runs each processor only while the function register
+// returns for its name reports true. It must be called after every
+// processor is added, and before Filter.
+func (p *Pipeline) EnableSwitches(register func(name string) func() bool) {
+	p.switches = make([]func() bool, len(p.names))
+	for i, name := range p.names {
+		p.switches[i] = register(name)
+	}
+}
+
+// Names returns the pipeline's processor names in order
+func (p *Pipeline) Names() []string {
+	return p.names
+}
+
+// Process runs every processor over a chat message; other records are
+// left alone
+func (p *Pipeline) Process(msg *message.Message) {
+	if msg.Type != "" {
+		return
+	}
+	for i, proc := range p.processors {
+		if i < len(p.switches) && p.switches[i] != nil && !p.switches[i]() {
+			continue
+		}
+		proc.Process(msg)
+	}
+}
+
+// Filter enriches messages from in and forwards them to out. It closes out
+// when in is closed.
+func (p *Pipeline) Filter(ctx context.Context, in <-chan message.Message, out chan<- message.Message) error {
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				close(out)
+				return nil
+			}
+			p.Process(&msg)
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// stringList reads an option holding a list of strings
+func stringList(options map[string]any, key string) ([]string, error) {
+	raw, ok := options[key]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	items, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list", key)
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of strings", key)
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// stringOption reads an option holding a string
+func stringOption(options map[string]any, key string) (string, error) {
+	raw, ok := options[key]
+	if !ok || raw == nil {
+		return "", nil
+	}
+	s, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
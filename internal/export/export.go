@@ -0,0 +1,184 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// Formats lists the supported export formats
+var Formats = []string{"csv", "text", "html"}
+
+// Transcript is a channel excerpt to render
+type Transcript struct {
+	Platform string
+	Channel  string
+	Start    time.Time // Zero if unbounded
+	End      time.Time // Zero if unbounded
+	Location *time.Location
+	Messages []message.Message
+}
+
+// Write renders the transcript in the given format. Only chat messages are
+// included; aggregate and other non-chat records are skipped.
+func Write(w io.Writer, format string, t Transcript) error {
+	if t.Location == nil {
+		t.Location = time.UTC
+	}
+
+	chat := make([]message.Message, 0, len(t.Messages))
+	for _, msg := range t.Messages {
+		if msg.Type == "" {
+			chat = append(chat, msg)
+		}
+	}
+	t.Messages = chat
+
+	switch format {
+	case "csv":
+		return writeCSV(w, t)
+	case "text":
+		return writeText(w, t)
+	case "html":
+		return writeHTML(w, t)
+	default:
+		return fmt.Errorf("unknown format %q (expected %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// localTime formats a message timestamp in the transcript's location
+func (t Transcript) localTime(timestamp, layout string) string {
+	ts, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return ts.In(t.Location).Format(layout)
+}
+
+// writeCSV writes one row per message with a header row
+func writeCSV(w io.Writer, t Transcript) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "platform", "channel", "username", "user_id", "badges", "message"})
+	for _, msg := range t.Messages {
+		cw.Write([]string{
+			t.localTime(msg.Timestamp, message.TimestampFormat),
+			msg.Platform,
+			msg.Channel,
+			msg.Username,
+			msg.UserID,
+			msg.Badges,
+			msg.Message,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeText writes a plain transcript with a short header
+func writeText(w io.Writer, t Transcript) error {
+	if _, err := fmt.Fprintf(w, "%s\n\n", t.title()); err != nil {
+		return err
+	}
+	for _, msg := range t.Messages {
+		name := msg.Username
+		if msg.Badges != "" {
+			name = fmt.Sprintf("[%s] %s", msg.Badges, name)
+		}
+		if _, err := fmt.Fprintf(w, "%s  %s: %s\n",
+			t.localTime(msg.Timestamp, "2006-01-02 15:04:05"), name, msg.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// title describes the transcript's channel and range
+func (t Transcript) title() string {
+	title := fmt.Sprintf("%s/%s", t.Platform, t.Channel)
+	const layout = "2006-01-02 15:04 MST"
+	switch {
+	case !t.Start.IsZero() && !t.End.IsZero():
+		title += fmt.Sprintf(", %s to %s", t.Start.In(t.Location).Format(layout), t.End.In(t.Location).Format(layout))
+	case !t.Start.IsZero():
+		title += fmt.Sprintf(", from %s", t.Start.In(t.Location).Format(layout))
+	case !t.End.IsZero():
+		title += fmt.Sprintf(", until %s", t.End.In(t.Location).Format(layout))
+	}
+	return title + fmt.Sprintf(" (%d messages)", len(t.Messages))
+}
+
+// htmlMessage is a message prepared for the HTML template
+type htmlMessage struct {
+	Date     string
+	Time     string
+	Username string
+	UserID   string
+	Badges   []string
+	Message  string
+	NewDay   bool
+}
+
+// writeHTML writes a self-contained, styled transcript page
+func writeHTML(w io.Writer, t Transcript) error {
+	rows := make([]htmlMessage, 0, len(t.Messages))
+	lastDate := ""
+	for _, msg := range t.Messages {
+		row := htmlMessage{
+			Date:     t.localTime(msg.Timestamp, "Monday, 2 January 2006"),
+			Time:     t.localTime(msg.Timestamp, "15:04:05"),
+			Username: msg.Username,
+			UserID:   msg.UserID,
+			Message:  msg.Message,
+		}
+		if msg.Badges != "" {
+			row.Badges = strings.Split(msg.Badges, ",")
+		}
+		row.NewDay = row.Date != lastDate
+		lastDate = row.Date
+		rows = append(rows, row)
+	}
+
+	return htmlTemplate.Execute(w, struct {
+		Title     string
+		Generated string
+		Zone      string
+		Messages  []htmlMessage
+	}{
+		Title:     t.title(),
+		Generated: time.Now().In(t.Location).Format("2006-01-02 15:04:05 MST"),
+		Zone:      t.Location.String(),
+		Messages:  rows,
+	})
+}
+
+var htmlTemplate = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; margin: 2rem auto; max-width: 60rem; color: #1f2328; }
+h1 { font-size: 1.3rem; margin-bottom: 0.2rem; }
+.meta { color: #656d76; font-size: 0.85rem; margin-bottom: 1.5rem; }
+.day { font-weight: 600; margin: 1.2rem 0 0.4rem; border-bottom: 1px solid #d0d7de; padding-bottom: 0.2rem; }
+.msg { display: flex; gap: 0.6rem; padding: 0.15rem 0; line-height: 1.4; }
+.msg:hover { background: #f6f8fa; }
+.time { color: #656d76; font-family: ui-monospace, monospace; font-size: 0.85rem; flex: none; padding-top: 0.1rem; }
+.badge { display: inline-block; background: #ddf4ff; color: #0969da; border-radius: 0.3rem; font-size: 0.7rem; padding: 0 0.3rem; margin-right: 0.2rem; vertical-align: middle; }
+.user { font-weight: 600; }
+.text { white-space: pre-wrap; word-break: break-word; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="meta">Times in {{.Zone}}. Generated {{.Generated}} by chatlog.</div>
+{{range .Messages}}{{if .NewDay}}<div class="day">{{.Date}}</div>
+{{end}}<div class="msg"><span class="time">{{.Time}}</span><span>{{range .Badges}}<span class="badge">{{.}}</span>{{end}}<span class="user" title="{{.UserID}}">{{.Username}}</span>: <span class="text">{{.Message}}</span></span></div>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,204 @@
+// Package features switches optional pipeline components, such as sinks,
+// enrichment processors and filters, on and off at runtime. Switches are
+// kept in a state file so a restart doesn't undo them.
+package features
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/john/chatlog/internal/status"
+)
+
+// Feature is a switchable component and whether it is on
+type Feature struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Set holds the switches of the registered components. A nil Set
+// registers nothing and leaves every component on.
+type Set struct {
+	statePath string
+	saved     map[string]bool // Loaded state, applied as components register
+	switches  map[string]*atomic.Bool
+	status    *status.Component
+	mu        sync.Mutex
+}
+
+// New creates a set, loading the switches saved in statePath
+func New(statePath string) (*Set, error) {
+	s := &Set{
+		statePath: statePath,
+		saved:     make(map[string]bool),
+		switches:  make(map[string]*atomic.Bool),
+	}
+
+	data, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read feature state: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.saved); err != nil {
+		return nil, fmt.Errorf("parse feature state: %w", err)
+	}
+	return s, nil
+}
+
+// Register adds a component that starts on unless it was switched off
+// before a restart, and returns a function reporting whether it is on,
+// for the component to check per message. On a nil Set it returns nil,
+// which components treat as always on. Components must be registered
+// before Handler serves requests.
+func (s *Set) Register(name string) func() bool {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	on := &atomic.Bool{}
+	on.Store(true)
+	if enabled, ok := s.saved[name]; ok {
+		on.Store(enabled)
+		if !enabled {
+			log.Printf("Feature %s is switched off", name)
+		}
+	}
+	s.switches[name] = on
+	s.reportLocked()
+	return on.Load
+}
+
+// EnableStatus reports the switched-off components to comp. It must be
+// called before Start.
+func (s *Set) EnableStatus(comp *status.Component) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = comp
+	s.reportLocked()
+}
+
+// Switch turns a registered component on or off
+func (s *Set) Switch(name string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	on, ok := s.switches[name]
+	if !ok {
+		return fmt.Errorf("unknown feature %q", name)
+	}
+	if on.Swap(enabled) == enabled {
+		return nil
+	}
+	s.saved[name] = enabled
+	s.saveLocked()
+	s.reportLocked()
+	if enabled {
+		log.Printf("Feature %s switched on", name)
+	} else {
+		log.Printf("Feature %s switched off", name)
+	}
+	return nil
+}
+
+// List returns the registered components, sorted by name
+func (s *Set) List() []Feature {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Feature, 0, len(s.switches))
+	for name, on := range s.switches {
+		list = append(list, Feature{Name: name, Enabled: on.Load()})
+	}
+	slices.SortFunc(list, func(a, b Feature) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return list
+}
+
+// saveLocked writes the state file atomically; the caller must hold s.mu.
+// Switches of components no longer configured are kept. A failure is
+// logged, leaving the switch in effect until restart.
+func (s *Set) saveLocked() {
+	data, err := json.MarshalIndent(s.saved, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.statePath), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(s.statePath+".tmp", data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(s.statePath+".tmp", s.statePath)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to save feature state, switches will be lost on restart: %v", err)
+	}
+}
+
+// reportLocked updates the status component; the caller must hold s.mu
+func (s *Set) reportLocked() {
+	off := []string{}
+	for name, on := range s.switches {
+		if !on.Load() {
+			off = append(off, name)
+		}
+	}
+	slices.Sort(off)
+	s.status.Set("disabled", off)
+}
+
+// switchRequest is the body of a switch request
+type switchRequest struct {
+	Name    string `json:"name"`
+	Enabled *bool  `json:"enabled"`
+}
+
+// Handler serves the feature API: GET lists components and POST switches
+// one with {"name": ..., "enabled": ...}
+func (s *Set) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.List())
+
+		case http.MethodPost:
+			var req switchRequest
+			dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+				return
+			}
+			if req.Enabled == nil {
+				http.Error(w, "enabled is required", http.StatusBadRequest)
+				return
+			}
+			if err := s.Switch(req.Name, *req.Enabled); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, Feature{Name: req.Name, Enabled: *req.Enabled})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
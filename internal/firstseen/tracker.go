@@ -0,0 +1,142 @@
+package firstseen
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Tracker remembers every user seen in each channel, so a user's first
+// message in a channel can be told apart from later ones across restarts.
+// Each channel's user IDs are kept in memory and in an append-only file
+// with one ID per line. IDs are written when Flush is called; IDs seen
+// since the last flush are counted as new again after a crash.
+type Tracker struct {
+	dir      string
+	channels map[string]*channelUsers
+	mu       sync.Mutex
+}
+
+// channelUsers is the set of users seen in one channel
+type channelUsers struct {
+	seen    map[string]struct{}
+	pending []string // Seen since the last flush
+}
+
+// New creates a tracker storing its state in dir
+func New(dir string) *Tracker {
+	return &Tracker{
+		dir:      dir,
+		channels: make(map[string]*channelUsers),
+	}
+}
+
+// Open creates the state directory. It must be called before Seen.
+func (t *Tracker) Open() error {
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return fmt.Errorf("create first-seen directory: %w", err)
+	}
+	return nil
+}
+
+// Seen records that userID chatted in a channel and reports whether it is
+// the first time
+func (t *Tracker) Seen(platform, channel, userID string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	users, err := t.load(platform, channel)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := users.seen[userID]; ok {
+		return false, nil
+	}
+	users.seen[userID] = struct{}{}
+	users.pending = append(users.pending, userID)
+	return true, nil
+}
+
+// Flush appends a channel's newly seen users to its state file
+func (t *Tracker) Flush(platform, channel string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	users, ok := t.channels[channelKey(platform, channel)]
+	if !ok || len(users.pending) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(t.path(platform, channel), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open first-seen state: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	for _, id := range users.pending {
+		w.WriteString(id)
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write first-seen state: %w", err)
+	}
+	users.pending = users.pending[:0]
+	return nil
+}
+
+// Users returns how many users have been seen in a channel
+func (t *Tracker) Users(platform, channel string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if users, ok := t.channels[channelKey(platform, channel)]; ok {
+		return len(users.seen)
+	}
+	return 0
+}
+
+// load returns a channel's users, reading its state file on first use;
+// the caller must hold t.mu
+func (t *Tracker) load(platform, channel string) (*channelUsers, error) {
+	key := channelKey(platform, channel)
+	if users, ok := t.channels[key]; ok {
+		return users, nil
+	}
+
+	users := &channelUsers{seen: make(map[string]struct{})}
+	file, err := os.Open(t.path(platform, channel))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read first-seen state: %w", err)
+	}
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if id := strings.TrimSpace(scanner.Text()); id != "" {
+				users.seen[id] = struct{}{}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read first-seen state: %w", err)
+		}
+	}
+
+	t.channels[key] = users
+	return users, nil
+}
+
+// path returns the state file of a channel
+func (t *Tracker) path(platform, channel string) string {
+	return filepath.Join(t.dir, fmt.Sprintf("%s_%s.users", platform, strings.ToLower(channel)))
+}
+
+// channelKey identifies a channel in the tracker
+func channelKey(platform, channel string) string {
+	return platform + "/" + strings.ToLower(channel)
+}
@@ -0,0 +1,223 @@
+// Package forward carries records from chatlog-agent processes, which run
+// only connectors, to a central chatlog that records and uploads them. An
+// agent POSTs batches of JSON lines to the hub's /agents/ingest endpoint.
+package forward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+// Limits on what the receiver accepts
+const (
+	maxRecordBytes = 1 << 20  // One JSON line
+	maxBatchBytes  = 64 << 20 // One request body
+)
+
+// Retry backoff while the hub is unreachable
+const (
+	minBackoff = 1 * time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Client forwards an agent's records to the hub in batches. While the hub
+// is unreachable the current batch is retried and connectors back up behind
+// the agent's buffer, as they would behind a stalled recorder.
+type Client struct {
+	url       string
+	token     string
+	batchSize int
+	flush     time.Duration
+	http      *http.Client
+
+	sent   atomic.Int64
+	status *status.Component
+}
+
+// NewClient creates a client posting batches of up to batchSize records to
+// url, at least every flush
+func NewClient(url, token string, batchSize int, flush time.Duration) *Client {
+	return &Client{
+		url:       url,
+		token:     token,
+		batchSize: max(batchSize, 1),
+		flush:     flush,
+		http:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// EnableStatus reports forwarding state and counts to comp. It must be
+// called before Start.
+func (c *Client) EnableStatus(comp *status.Component) {
+	c.status = comp
+}
+
+// Start forwards records from in until in is closed, sending what it holds
+// before returning. Cancelling the context abandons a batch the hub is not
+// accepting.
+func (c *Client) Start(ctx context.Context, in <-chan message.Message) error {
+	ticker := time.NewTicker(c.flush)
+	defer ticker.Stop()
+
+	var batch []message.Message
+	for {
+		select {
+		case msg, open := <-in:
+			if !open {
+				return c.send(ctx, batch)
+			}
+			batch = append(batch, msg)
+			if len(batch) < c.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		if err := c.send(ctx, batch); err != nil {
+			return err
+		}
+		batch = batch[:0]
+	}
+}
+
+// send posts a batch, retrying with backoff until the hub accepts it or the
+// context is cancelled
+func (c *Client) send(ctx context.Context, batch []message.Message) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, msg := range batch {
+		if err := enc.Encode(msg); err != nil {
+			return fmt.Errorf("encode record: %w", err)
+		}
+	}
+
+	backoff := minBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.post(ctx, body.Bytes())
+		if err == nil {
+			c.sent.Add(int64(len(batch)))
+			c.status.SetState(status.StateConnected)
+			c.status.Set("forwarded", c.sent.Load())
+			c.status.MessageReceived()
+			return nil
+		}
+
+		c.status.SetState(status.StateReconnecting)
+		c.status.Set("last_error", err.Error())
+		if attempt == 1 || attempt%10 == 0 {
+			log.Printf("Warning: Failed to forward %d record(s) to the hub (attempt %d): %v", len(batch), attempt, err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			log.Printf("Error: Dropped %d record(s) the hub did not accept before shutdown", len(batch))
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post sends one batch of JSON lines
+func (c *Client) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("hub returned %s: %s", resp.Status, bytes.TrimSpace(text))
+	}
+	return nil
+}
+
+// Receiver accepts batches of records from agents and sends them into the
+// hub's pipeline
+type Receiver struct {
+	ctx      context.Context
+	out      chan<- message.Message
+	received atomic.Int64
+}
+
+// NewReceiver creates a receiver sending records to out until the context
+// is cancelled, after which batches are refused so agents retry them
+func NewReceiver(ctx context.Context, out chan<- message.Message) *Receiver {
+	return &Receiver{ctx: ctx, out: out}
+}
+
+// ServeHTTP accepts a POST of JSON lines. The whole batch is checked
+// before any of it is sent on, and only acknowledged once every record is
+// in the pipeline. A batch refused part way through shutdown is sent again
+// by the agent, so delivery is at least once.
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batch []message.Message
+	var bad error
+	err := message.ScanLines(http.MaxBytesReader(w, req.Body, maxBatchBytes), maxRecordBytes, func(line int, data []byte) bool {
+		msg, err := message.Decode(data, "")
+		if err != nil {
+			bad = fmt.Errorf("line %d: %w", line, err)
+			return false
+		}
+		batch = append(batch, msg)
+		return true
+	})
+	if bad != nil {
+		http.Error(w, bad.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("read body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	for _, msg := range batch {
+		select {
+		case r.out <- msg:
+			r.received.Add(1)
+		case <-r.ctx.Done():
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"accepted": len(batch)})
+}
+
+// WriteMetrics writes the count of records received from agents in
+// Prometheus text format
+func (r *Receiver) WriteMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP chatlog_agent_records_received_total Records received from chatlog-agent processes.")
+	fmt.Fprintln(w, "# TYPE chatlog_agent_records_received_total counter")
+	fmt.Fprintf(w, "chatlog_agent_records_received_total %d\n", r.received.Load())
+}
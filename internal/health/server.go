@@ -2,29 +2,111 @@ package health
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/john/chatlog/internal/status"
 )
 
 // Server provides HTTP health check endpoint
 type Server struct {
 	server *http.Server
+	mux    *http.ServeMux
+
+	checks   map[string]func() error
+	metrics  []func(io.Writer)
+	checksMu sync.Mutex
+
+	status *status.Registry
+}
+
+// healthResponse is the JSON body served at /health
+type healthResponse struct {
+	Status   string   `json:"status"` // "ok" or "critical"
+	Failures []string `json:"failures,omitempty"`
+	status.Report
+}
+
+// New creates a new health check server reporting the components in reg
+func New(addr string, reg *status.Registry) *Server {
+	s := &Server{
+		checks: make(map[string]func() error),
+		status: reg,
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/health", s.handleHealth)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+
+	s.server = &http.Server{
+		Addr:    addr,
+		Handler: s.mux,
+	}
+	return s
 }
 
-// New creates a new health check server
-func New(addr string) *Server {
-	mux := http.NewServeMux()
+// Handle registers an additional endpoint, such as an admin API route.
+// It must be called before Start.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// AddMetrics registers a function that writes metrics in the Prometheus
+// text format; /metrics concatenates the output of all of them
+func (s *Server) AddMetrics(write func(io.Writer)) {
+	s.checksMu.Lock()
+	defer s.checksMu.Unlock()
+	s.metrics = append(s.metrics, write)
+}
+
+// AddCheck registers a component check. If any check returns an error,
+// /health responds with 503 and the failure details.
+func (s *Server) AddCheck(name string, check func() error) {
+	s.checksMu.Lock()
+	defer s.checksMu.Unlock()
+	s.checks[name] = check
+}
+
+// handleHealth runs all registered checks and reports component status as
+// JSON. The status code is 503 if any check fails.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.checksMu.Lock()
+	var failures []string
+	for name, check := range s.checks {
+		if err := check(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	s.checksMu.Unlock()
+
+	response := healthResponse{Status: "ok", Report: s.status.Report()}
+	code := http.StatusOK
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		response.Status = "critical"
+		response.Failures = failures
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
 
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+// handleMetrics writes all registered metrics
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.checksMu.Lock()
+	metrics := append([]func(io.Writer){}, s.metrics...)
+	s.checksMu.Unlock()
 
-	return &Server{
-		server: &http.Server{
-			Addr:    addr,
-			Handler: mux,
-		},
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, write := range metrics {
+		write(w)
 	}
 }
 
@@ -0,0 +1,505 @@
+package highlight
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+const (
+	// warmupSeconds is how long a channel's baseline is averaged, after its
+	// first full window, before it is trusted
+	warmupSeconds = 60
+
+	// maxDuration ends a highlight that runs this long, so a channel whose
+	// rate rose for good still gets a record
+	maxDuration = 5 * time.Minute
+
+	// exitRatio is the fraction of the z-score threshold the rate must fall
+	// below to end a highlight, so spikes aren't split by a brief dip
+	exitRatio = 0.5
+
+	// minTermRunes and maxMessageTerms bound what counts as a term
+	minTermRunes    = 3
+	maxMessageTerms = 20
+
+	// queueSize is how many events wait for delivery before new ones are dropped
+	queueSize = 100
+
+	// sendAttempts is how many times an event is posted before giving up
+	sendAttempts = 3
+)
+
+// stopWords are common words left out of top terms
+var stopWords = map[string]bool{
+	"the": true, "and": true, "you": true, "that": true, "this": true, "for": true,
+	"are": true, "was": true, "with": true, "have": true, "not": true, "but": true,
+	"what": true, "just": true, "like": true, "it's": true, "its": true, "can": true,
+	"don't": true, "dont": true, "he's": true, "she's": true, "they": true, "his": true,
+	"her": true, "him": true, "all": true, "from": true, "about": true, "your": true,
+}
+
+// Event is the webhook body sent for a highlight
+type Event struct {
+	Platform string `json:"platform"`
+	Channel  string `json:"channel"`
+	message.Highlight
+}
+
+// slot is one second of a channel's chat
+type slot struct {
+	messages int
+	users    map[string]bool
+	terms    map[string]int
+}
+
+// spike is a highlight in progress
+type spike struct {
+	start    time.Time
+	baseline float64
+	peakRate float64
+	peakZ    float64
+	messages int
+	users    map[string]bool
+	terms    map[string]int
+}
+
+// channelState tracks one channel's recent chat and usual rate
+type channelState struct {
+	slots []slot // Ring buffer over the detection window
+	slot  int    // Index of the current second
+	ticks int    // Seconds watched
+	quiet int    // Seconds without messages, for eviction
+
+	// Exponentially weighted mean and variance of the windowed rate
+	mean     float64
+	variance float64
+
+	active *spike
+	last   time.Time // When the last highlight ended
+}
+
+// Detector watches each channel's chat rate and records a highlight when it
+// spikes: when the messages per second over a short window are well above
+// the channel's usual rate, measured as a z-score against an exponentially
+// weighted baseline. Highlights are added to the channel's records and,
+// with a webhook, posted as they end. Editors use them to find clip-worthy
+// moments.
+type Detector struct {
+	window    int     // Seconds
+	alpha     float64 // Baseline smoothing per second
+	evict     int     // Quiet seconds before a channel is forgotten
+	threshold float64
+	minRate   float64
+	cooldown  time.Duration
+	topTerms  int
+
+	channels map[string]*channelState // key: "platform/channel"
+	detected atomic.Int64
+
+	url     string
+	secret  string
+	client  *http.Client
+	queue   chan Event
+	sent    atomic.Int64
+	dropped atomic.Int64
+
+	status *status.Component
+	on     func() bool // Reports whether detection is switched on; nil is always on
+}
+
+// New creates a detector comparing the rate over window with a baseline
+// averaged over about baseline. A highlight starts when the z-score reaches
+// threshold with at least minRate messages per second, at most once per
+// cooldown per channel, and lists its topTerms most used words and emotes.
+func New(window, baseline time.Duration, threshold, minRate float64, cooldown time.Duration, topTerms int) *Detector {
+	return &Detector{
+		window:    int(window.Seconds()),
+		alpha:     1 - math.Exp(-1/baseline.Seconds()),
+		evict:     2 * int(baseline.Seconds()),
+		threshold: threshold,
+		minRate:   minRate,
+		cooldown:  cooldown,
+		topTerms:  topTerms,
+		channels:  make(map[string]*channelState),
+	}
+}
+
+// EnableWebhook posts each highlight to url as it ends. If secret is set,
+// the body's HMAC-SHA256 is sent in the X-Chatlog-Signature header, as with
+// alerts. It must be called before Start.
+func (d *Detector) EnableWebhook(url, secret string) {
+	d.url = url
+	d.secret = secret
+	d.client = &http.Client{Timeout: 10 * time.Second}
+	d.queue = make(chan Event, queueSize)
+}
+
+// EnableStatus reports detected highlights and webhook deliveries to comp.
+// It must be called before Filter.
+func (d *Detector) EnableStatus(comp *status.Component) {
+	d.status = comp
+}
+
+// EnableSwitch counts chat only while on reports true; channels then go
+// quiet and are forgotten as usual. It must be called before Filter.
+func (d *Detector) EnableSwitch(on func() bool) {
+	d.on = on
+}
+
+// Filter forwards messages from in to out, adding highlight records as
+// they end, until the context is cancelled or in is closed. Closing in
+// ends highlights in progress and closes out.
+func (d *Detector) Filter(ctx context.Context, in <-chan message.Message, out chan<- message.Message) error {
+	d.status.SetState(status.StateRunning)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	send := func(msg message.Message) bool {
+		select {
+		case out <- msg:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				for _, record := range d.flushAll(time.Now()) {
+					if !send(record) {
+						return ctx.Err()
+					}
+				}
+				close(out)
+				return nil
+			}
+			d.observe(msg)
+			if !send(msg) {
+				return ctx.Err()
+			}
+
+		case now := <-ticker.C:
+			for _, record := range d.tick(now) {
+				if !send(record) {
+					return ctx.Err()
+				}
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// observe counts a chat message toward its channel's current second
+func (d *Detector) observe(msg message.Message) {
+	if msg.Type != "" {
+		return // Only chat counts
+	}
+	if d.on != nil && !d.on() {
+		return
+	}
+
+	key := msg.Platform + "/" + msg.Channel
+	state, ok := d.channels[key]
+	if !ok {
+		state = &channelState{slots: make([]slot, d.window)}
+		d.channels[key] = state
+	}
+
+	s := &state.slots[state.slot]
+	if s.users == nil {
+		s.users = make(map[string]bool)
+		s.terms = make(map[string]int)
+	}
+	user := msg.UserID + "/" + msg.Username
+	terms := messageTerms(msg)
+	s.messages++
+	s.users[user] = true
+	for _, term := range terms {
+		s.terms[term]++
+	}
+
+	if sp := state.active; sp != nil {
+		sp.messages++
+		sp.users[user] = true
+		for _, term := range terms {
+			sp.terms[term]++
+		}
+	}
+}
+
+// tick advances every channel by one second, starting and ending
+// highlights, and returns the records of those that ended
+func (d *Detector) tick(now time.Time) []message.Message {
+	var records []message.Message
+
+	for key, state := range d.channels {
+		total := 0
+		for _, s := range state.slots {
+			total += s.messages
+		}
+		rate := float64(total) / float64(d.window)
+
+		// The spread of a windowed Poisson rate is a floor for the standard
+		// deviation, so quiet channels don't spike on a handful of messages
+		std := math.Sqrt(max(state.variance, max(state.mean, 1/float64(d.window))/float64(d.window)))
+		z := (rate - state.mean) / std
+		warm := state.ticks >= d.window+warmupSeconds
+
+		if sp := state.active; sp != nil {
+			sp.peakRate = max(sp.peakRate, rate)
+			sp.peakZ = max(sp.peakZ, z)
+			if z < d.threshold*exitRatio || rate < d.minRate || now.Sub(sp.start) >= maxDuration {
+				platform, channel, _ := strings.Cut(key, "/")
+				records = append(records, d.end(platform, channel, sp, now))
+				state.active = nil
+				state.last = now
+			}
+		} else if warm && z >= d.threshold && rate >= d.minRate && now.Sub(state.last) >= d.cooldown {
+			state.active = state.begin(now, d.window, rate, z)
+		}
+
+		// Fold the rate into the baseline. During warm-up it is a plain
+		// running average, so a new channel's baseline settles quickly.
+		// Highlights move only the mean, so a spike doesn't dull detection of
+		// the next one, while a lasting rise is eventually taken as the
+		// usual rate.
+		if state.ticks >= d.window {
+			alpha := d.alpha
+			if !warm {
+				alpha = max(alpha, 1/float64(state.ticks-d.window+1))
+			}
+			delta := rate - state.mean
+			state.mean += alpha * delta
+			if state.active == nil {
+				state.variance = (1 - alpha) * (state.variance + alpha*delta*delta)
+			}
+		}
+		state.ticks++
+
+		if total == 0 {
+			state.quiet++
+		} else {
+			state.quiet = 0
+		}
+		if state.quiet >= d.evict && state.active == nil {
+			delete(d.channels, key)
+			continue
+		}
+
+		state.slot = (state.slot + 1) % d.window
+		s := &state.slots[state.slot]
+		s.messages = 0
+		clear(s.users)
+		clear(s.terms)
+	}
+
+	return records
+}
+
+// begin starts a highlight covering the detection window that triggered it
+func (s *channelState) begin(now time.Time, window int, rate, z float64) *spike {
+	sp := &spike{
+		start:    now.Add(-time.Duration(window) * time.Second),
+		baseline: s.mean,
+		peakRate: rate,
+		peakZ:    z,
+		users:    make(map[string]bool),
+		terms:    make(map[string]int),
+	}
+	for _, sl := range s.slots {
+		sp.messages += sl.messages
+		for user := range sl.users {
+			sp.users[user] = true
+		}
+		for term, count := range sl.terms {
+			sp.terms[term] += count
+		}
+	}
+	return sp
+}
+
+// end builds a highlight's record and queues its webhook event
+func (d *Detector) end(platform, channel string, sp *spike, now time.Time) message.Message {
+	terms := make([]message.TermCount, 0, len(sp.terms))
+	for term, count := range sp.terms {
+		terms = append(terms, message.TermCount{Term: term, Count: count})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Count != terms[j].Count {
+			return terms[i].Count > terms[j].Count
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	if len(terms) > d.topTerms {
+		terms = terms[:d.topTerms]
+	}
+
+	highlight := message.Highlight{
+		Start:        sp.start.UTC().Format(message.TimestampFormat),
+		End:          now.UTC().Format(message.TimestampFormat),
+		Messages:     sp.messages,
+		Users:        len(sp.users),
+		PeakRate:     math.Round(sp.peakRate*100) / 100,
+		BaselineRate: math.Round(sp.baseline*100) / 100,
+		ZScore:       math.Round(sp.peakZ*100) / 100,
+		TopTerms:     terms,
+	}
+	log.Printf("Highlight in %s/%s: %d messages over %s, peak %.1f msg/s against a usual %.1f",
+		platform, channel, sp.messages, now.Sub(sp.start).Round(time.Second), highlight.PeakRate, highlight.BaselineRate)
+	d.detected.Add(1)
+	d.status.Set("highlights", d.detected.Load())
+
+	if d.queue != nil {
+		d.enqueue(Event{Platform: platform, Channel: channel, Highlight: highlight})
+	}
+
+	record := message.New(platform, sp.start)
+	record.Channel = channel
+	record.Type = message.TypeHighlight
+	record.Highlight = &highlight
+	return record
+}
+
+// flushAll ends every highlight in progress, for shutdown
+func (d *Detector) flushAll(now time.Time) []message.Message {
+	var records []message.Message
+	for key, state := range d.channels {
+		if state.active != nil {
+			platform, channel, _ := strings.Cut(key, "/")
+			records = append(records, d.end(platform, channel, state.active, now))
+			state.active = nil
+		}
+	}
+	return records
+}
+
+// messageTerms returns the distinct words and emotes of a message. Emotes
+// keep their case; words are lowercased, with short words, stop words,
+// mentions and links left out.
+func messageTerms(msg message.Message) []string {
+	var terms []string
+	for _, field := range strings.Fields(msg.Message) {
+		if len(terms) == maxMessageTerms {
+			break
+		}
+
+		term := field
+		if !slices.Contains(msg.Emotes, field) {
+			if strings.HasPrefix(field, "@") || strings.Contains(field, "://") {
+				continue
+			}
+			term = strings.ToLower(strings.TrimFunc(field, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			}))
+			if utf8.RuneCountInString(term) < minTermRunes || stopWords[term] {
+				continue
+			}
+		}
+		if !slices.Contains(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// enqueue queues an event for delivery, dropping it if the queue is full
+func (d *Detector) enqueue(e Event) {
+	select {
+	case d.queue <- e:
+	default:
+		d.dropped.Add(1)
+		d.status.Set("dropped", d.dropped.Load())
+		log.Printf("Warning: Highlight queue full, dropped highlight for %s/%s", e.Platform, e.Channel)
+	}
+}
+
+// Start delivers queued webhook events until the context is cancelled. It
+// is only needed with EnableWebhook.
+func (d *Detector) Start(ctx context.Context) error {
+	for {
+		select {
+		case e := <-d.queue:
+			d.deliver(ctx, e)
+		case <-ctx.Done():
+			if n := len(d.queue); n > 0 {
+				log.Printf("Warning: %d highlight(s) were not sent before shutdown", n)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// deliver posts an event, retrying with backoff
+func (d *Detector) deliver(ctx context.Context, e Event) {
+	var err error
+	for attempt := 1; attempt <= sendAttempts; attempt++ {
+		if err = d.post(ctx, e); err == nil {
+			d.sent.Add(1)
+			d.status.Set("sent", d.sent.Load())
+			return
+		}
+		if attempt < sendAttempts {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	log.Printf("Error: Failed to send highlight for %s/%s: %v", e.Platform, e.Channel, err)
+	d.status.Set("last_error", err.Error())
+}
+
+// post sends one event to the webhook
+func (d *Detector) post(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.secret != "" {
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		mac.Write(body)
+		req.Header.Set("X-Chatlog-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package importer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// Supported input formats
+const (
+	FormatJustlog    = "justlog"    // justlog/rustlog API JSON ({"messages": [...]})
+	FormatChatterino = "chatterino" // Chatterino text logs ("[15:04:05] user: text")
+	FormatIRC        = "irc"        // Raw IRC lines with IRCv3 tags, as stored by justlog
+)
+
+// Options controls how third-party logs are converted
+type Options struct {
+	Format   string
+	Platform string         // Platform recorded on imported messages, usually "twitch"
+	Channel  string         // Channel override; required when the log doesn't name it
+	Location *time.Location // Time zone of Chatterino timestamps
+}
+
+// ReadFile converts a single log file, calling fn with each message as it
+// is read and stopping at the first error fn returns. Files ending in .gz
+// are decompressed.
+func ReadFile(path string, opts Options, fn func(message.Message) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	switch opts.Format {
+	case FormatJustlog:
+		return parseJustlog(r, opts, fn)
+	case FormatChatterino:
+		return parseChatterino(r, filepath.Base(path), opts, fn)
+	case FormatIRC:
+		return parseIRC(r, opts, fn)
+	default:
+		return fmt.Errorf("unknown format %q (expected justlog, chatterino or irc)", opts.Format)
+	}
+}
+
+// newMessage builds an imported message. The original send time is used as
+// the receive time too, since the real receive time is unknown.
+func newMessage(opts Options, channel string, t time.Time) message.Message {
+	msg := message.New(opts.Platform, t)
+	msg.ReceivedAt = msg.Timestamp
+	msg.Channel = strings.ToLower(strings.TrimPrefix(channel, "#"))
+	if opts.Channel != "" {
+		msg.Channel = strings.ToLower(strings.TrimPrefix(opts.Channel, "#"))
+	}
+	return msg
+}
+
+// justlogMessage is a message as returned by the justlog/rustlog JSON API
+type justlogMessage struct {
+	Text        string            `json:"text"`
+	Username    string            `json:"username"`
+	DisplayName string            `json:"displayName"`
+	Channel     string            `json:"channel"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Type        int               `json:"type"`
+	Tags        map[string]string `json:"tags"`
+}
+
+// justlogPrivmsg is justlog's message type for regular chat messages
+const justlogPrivmsg = 1
+
+// parseJustlog decodes one or more justlog API responses, or a stream of
+// individual justlog messages. Only one response, a channel's day or
+// month, is held in memory at a time.
+func parseJustlog(r io.Reader, opts Options, fn func(message.Message) error) error {
+	add := func(m justlogMessage) error {
+		if m.Type != justlogPrivmsg || m.Timestamp.IsZero() {
+			return nil
+		}
+		msg := newMessage(opts, m.Channel, m.Timestamp)
+		msg.Username = m.DisplayName
+		if msg.Username == "" {
+			msg.Username = m.Username
+		}
+		msg.UserID = m.Tags["user-id"]
+		msg.Message = m.Text
+		msg.Badges = badgeNames(m.Tags["badges"])
+		if msg.Channel == "" {
+			return nil
+		}
+		return fn(msg)
+	}
+
+	decoder := json.NewDecoder(r)
+	for {
+		var value struct {
+			Messages []justlogMessage `json:"messages"`
+			justlogMessage
+		}
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("decode JSON: %w", err)
+		}
+
+		if value.Messages != nil {
+			for _, m := range value.Messages {
+				if err := add(m); err != nil {
+					return err
+				}
+			}
+		} else if err := add(value.justlogMessage); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseChatterino parses a Chatterino log. Chatterino names files
+// {channel}-{YYYY-MM-DD}.log and only writes the time of day on each line.
+func parseChatterino(r io.Reader, filename string, opts Options, fn func(message.Message) error) error {
+	const dateLayout = "2006-01-02"
+	base := strings.TrimSuffix(strings.TrimSuffix(filename, ".gz"), ".log")
+	if len(base) < len(dateLayout)+2 || base[len(base)-len(dateLayout)-1] != '-' {
+		return fmt.Errorf("filename %q is not in Chatterino's channel-YYYY-MM-DD.log form", filename)
+	}
+	// The channel name may itself contain dashes, so split before the date
+	channel := base[:len(base)-len(dateLayout)-1]
+	day, err := time.ParseInLocation(dateLayout, base[len(base)-len(dateLayout):], opts.Location)
+	if err != nil {
+		return fmt.Errorf("filename %q: %w", filename, err)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "[") {
+			continue // "# Start logging at ..." and other headers
+		}
+
+		clock, rest, ok := strings.Cut(line[1:], "] ")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse("15:04:05", clock)
+		if err != nil {
+			continue
+		}
+		user, text, ok := strings.Cut(rest, ": ")
+		// Localized names are logged as "DisplayName (login)"
+		if name, _, found := strings.Cut(user, " ("); found && strings.HasSuffix(user, ")") {
+			user = name
+		}
+		if !ok || strings.ContainsAny(user, " ") {
+			continue // System messages such as timeouts and sub notices
+		}
+
+		ts := day.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second)
+		msg := newMessage(opts, channel, ts)
+		msg.Username = user
+		msg.Message = text
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// parseIRC parses raw IRC lines, keeping PRIVMSGs. The send time comes from
+// the tmi-sent-ts or IRCv3 server-time tag; lines without one are skipped.
+func parseIRC(r io.Reader, opts Options, fn func(message.Message) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		tags, prefix, command, params := parseIRCLine(scanner.Text())
+		if command != "PRIVMSG" || len(params) < 2 {
+			continue
+		}
+
+		var ts time.Time
+		if ms := tags["tmi-sent-ts"]; ms != "" {
+			var millis int64
+			if _, err := fmt.Sscan(ms, &millis); err == nil {
+				ts = time.UnixMilli(millis)
+			}
+		} else if st := tags["time"]; st != "" {
+			ts, _ = time.Parse(time.RFC3339Nano, st)
+		}
+		if ts.IsZero() {
+			continue
+		}
+
+		msg := newMessage(opts, params[0], ts)
+		msg.Username = tags["display-name"]
+		if msg.Username == "" {
+			msg.Username, _, _ = strings.Cut(prefix, "!")
+		}
+		msg.UserID = tags["user-id"]
+		msg.Message = strings.TrimSuffix(strings.TrimPrefix(params[1], "\x01ACTION "), "\x01")
+		msg.Badges = badgeNames(tags["badges"])
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// parseIRCLine splits a raw IRC line into tags, prefix, command and params
+func parseIRCLine(line string) (map[string]string, string, string, []string) {
+	tags := make(map[string]string)
+	line = strings.TrimRight(line, "\r\n")
+
+	if strings.HasPrefix(line, "@") {
+		var rawTags string
+		rawTags, line, _ = strings.Cut(line[1:], " ")
+		for _, tag := range strings.Split(rawTags, ";") {
+			key, value, _ := strings.Cut(tag, "=")
+			tags[key] = unescapeTag(value)
+		}
+	}
+
+	var prefix string
+	if strings.HasPrefix(line, ":") {
+		prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+
+	var params []string
+	command, line, _ := strings.Cut(line, " ")
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			params = append(params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		params = append(params, param)
+	}
+
+	return tags, prefix, command, params
+}
+
+// unescapeTag reverses IRCv3 tag value escaping
+func unescapeTag(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	return strings.NewReplacer(`\s`, " ", `\:`, ";", `\\`, `\`, `\r`, "\r", `\n`, "\n").Replace(value)
+}
+
+// badgeNames converts a Twitch badges tag ("moderator/1,subscriber/12") to
+// the comma-separated badge names chatlog records
+func badgeNames(tag string) string {
+	if tag == "" {
+		return ""
+	}
+	var names []string
+	for _, badge := range strings.Split(tag, ",") {
+		name, _, _ := strings.Cut(badge, "/")
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,40 @@
+package importer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+func TestReadFileStreams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chan.log")
+	log := "@tmi-sent-ts=1704189600000;display-name=A;user-id=1 :a!a@a PRIVMSG #chan :first\n" +
+		":tmi.twitch.tv PING\n" +
+		"@tmi-sent-ts=1704189601000;display-name=B;user-id=2 :b!b@b PRIVMSG #chan :\x01ACTION second\x01\n" +
+		"@tmi-sent-ts=1704189602000;display-name=C;user-id=3 :c!c@c PRIVMSG #chan :third\n"
+	if err := os.WriteFile(path, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []message.Message
+	stop := errors.New("stop")
+	err := ReadFile(path, Options{Format: FormatIRC, Platform: "twitch"}, func(msg message.Message) error {
+		got = append(got, msg)
+		if len(got) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("err = %v, want the callback's error", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("read %d messages after the callback failed, want 2", len(got))
+	}
+	if got[0].Channel != "chan" || got[0].Username != "A" || got[1].Message != "second" {
+		t.Errorf("messages = %+v", got)
+	}
+}
@@ -0,0 +1,155 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// maxOpenFiles caps how many output files a Writer keeps open; the least
+// recently written is closed and reopened for appending when needed
+const maxOpenFiles = 64
+
+// Writer streams converted messages into per-channel, per-UTC-day files
+// named like the recorder's platform_channel_YYYYMMDD_HHMM.jsonl, so a
+// conversion never holds a whole log in memory. Messages are written in
+// the order they arrive, as the recorder writes them.
+type Writer struct {
+	dir   string
+	files map[string]*outFile // By platform/channel/day
+	paths []string            // In creation order
+	open  int
+	clock uint64 // Counts writes, to find the least recently written file
+}
+
+// outFile is one output file
+type outFile struct {
+	path string
+	file *os.File // nil while closed to stay under maxOpenFiles
+	buf  *bufio.Writer
+	last time.Time // Latest message time, set as the modification time
+	used uint64
+}
+
+// NewWriter creates a writer of files in dir
+func NewWriter(dir string) *Writer {
+	return &Writer{dir: dir, files: make(map[string]*outFile)}
+}
+
+// Write appends a message to the file of its channel and UTC day, creating
+// it if needed. A new file never replaces an existing one.
+func (w *Writer) Write(msg message.Message) error {
+	if err := message.CheckName(msg.Platform); err != nil {
+		return fmt.Errorf("platform: %w", err)
+	}
+	if err := message.CheckName(msg.Channel); err != nil {
+		return fmt.Errorf("channel: %w", err)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, msg.Timestamp)
+	if err != nil {
+		return fmt.Errorf("parse timestamp: %w", err)
+	}
+	ts = ts.UTC()
+
+	key := msg.Platform + "/" + msg.Channel + "/" + ts.Format("2006-01-02")
+	f, ok := w.files[key]
+	if !ok {
+		f = &outFile{}
+		if err := w.makeRoom(); err != nil {
+			return err
+		}
+		if f.path, f.file, err = w.create(msg.Platform, msg.Channel, ts); err != nil {
+			return err
+		}
+		f.buf = bufio.NewWriter(f.file)
+		w.files[key] = f
+		w.paths = append(w.paths, f.path)
+		w.open++
+	} else if f.file == nil {
+		if err := w.makeRoom(); err != nil {
+			return err
+		}
+		if f.file, err = os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0); err != nil {
+			return err
+		}
+		f.buf.Reset(f.file)
+		w.open++
+	}
+
+	w.clock++
+	f.used = w.clock
+	if ts.After(f.last) {
+		f.last = ts
+	}
+	if err := json.NewEncoder(f.buf).Encode(msg); err != nil {
+		return fmt.Errorf("encode message: %w", err)
+	}
+	return nil
+}
+
+// create opens a new file for a channel's messages starting at ts. A file
+// of the same minute (from another batch, or an earlier run into the same
+// directory) is never reused: seconds, then nanoseconds, tell them apart,
+// as in the recorder.
+func (w *Writer) create(platform, channel string, ts time.Time) (string, *os.File, error) {
+	var err error
+	for _, layout := range []string{"20060102_1504", "20060102_150405", "20060102_150405.000000000"} {
+		path := filepath.Join(w.dir, fmt.Sprintf("%s_%s_%s.jsonl", platform, channel, ts.Format(layout)))
+		var file *os.File
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			return path, file, nil
+		}
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	return "", nil, fmt.Errorf("create file: %w", err)
+}
+
+// makeRoom closes the least recently written file if maxOpenFiles are open
+func (w *Writer) makeRoom() error {
+	if w.open < maxOpenFiles {
+		return nil
+	}
+	var oldest *outFile
+	for _, f := range w.files {
+		if f.file != nil && (oldest == nil || f.used < oldest.used) {
+			oldest = f
+		}
+	}
+	return w.closeFile(oldest)
+}
+
+// closeFile flushes and closes f, keeping it to be reopened
+func (w *Writer) closeFile(f *outFile) error {
+	err := f.buf.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.file = nil
+	w.open--
+	return err
+}
+
+// Close closes every file, setting its modification time to its last
+// message so the file's end time is preserved, and returns their paths
+func (w *Writer) Close() ([]string, error) {
+	var firstErr error
+	for _, f := range w.files {
+		if f.file != nil {
+			if err := w.closeFile(f); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if err := os.Chtimes(f.path, f.last, f.last); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return w.paths, firstErr
+}
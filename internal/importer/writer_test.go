@@ -0,0 +1,114 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+func testMessage(channel string, ts time.Time, text string) message.Message {
+	msg := message.New("twitch", ts)
+	msg.Channel = channel
+	msg.Message = text
+	return msg
+}
+
+// lines counts the lines of a file
+func lines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	n := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); n++ {
+	}
+	return n
+}
+
+func TestWriterNeverReplacesFiles(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	existing := filepath.Join(dir, "twitch_chan_20240102_1030.jsonl")
+	if err := os.WriteFile(existing, []byte("keep me\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWriter(dir)
+	for i := 0; i < 3; i++ {
+		if err := w.Write(testMessage("chan", start.Add(time.Duration(i)*time.Hour), "hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The next UTC day goes to its own file
+	if err := w.Write(testMessage("chan", start.Add(14*time.Hour), "tomorrow")); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(existing); string(data) != "keep me\n" {
+		t.Error("an existing file was overwritten")
+	}
+	if len(paths) != 2 {
+		t.Fatalf("wrote %v, want 2 files", paths)
+	}
+	if filepath.Base(paths[0]) != "twitch_chan_20240102_103000.jsonl" || lines(t, paths[0]) != 3 {
+		t.Errorf("first file %s has %d lines", paths[0], lines(t, paths[0]))
+	}
+	stat, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(2 * time.Hour); !stat.ModTime().Equal(want) {
+		t.Errorf("modification time = %v, want the last message's %v", stat.ModTime(), want)
+	}
+}
+
+func TestWriterReopensClosedFiles(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	channels := maxOpenFiles + 5
+
+	w := NewWriter(dir)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < channels; i++ {
+			if err := w.Write(testMessage(fmt.Sprintf("chan%d", i), start.Add(time.Duration(round)*time.Minute), "hi")); err != nil {
+				t.Fatal(err)
+			}
+			if w.open > maxOpenFiles {
+				t.Fatalf("%d files open, want at most %d", w.open, maxOpenFiles)
+			}
+		}
+	}
+	paths, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != channels {
+		t.Fatalf("wrote %d files, want %d", len(paths), channels)
+	}
+	for _, path := range paths {
+		if n := lines(t, path); n != 2 {
+			t.Errorf("%s has %d lines, want 2", path, n)
+		}
+	}
+}
+
+func TestWriterRejectsUnsafeNames(t *testing.T) {
+	w := NewWriter(t.TempDir())
+	defer w.Close()
+	for _, channel := range []string{"..", "../escape", "a/b"} {
+		if err := w.Write(testMessage(channel, time.Now(), "hi")); err == nil {
+			t.Errorf("wrote a file for channel %q", channel)
+		}
+	}
+}
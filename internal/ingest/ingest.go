@@ -0,0 +1,134 @@
+// Package ingest accepts chat records pushed over HTTP by external
+// scrapers, such as a browser extension or a tool for a platform chatlog
+// has no connector for, into the recording pipeline
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// maxRecordBytes limits one JSON line
+const maxRecordBytes = 1 << 20
+
+// maxErrors is how many rejected lines a response describes
+const maxErrors = 10
+
+// Handler streams JSON lines from a request body into the pipeline, each
+// record as soon as it is read, so a scraper can hold one request open and
+// keep writing to it
+type Handler struct {
+	ctx      context.Context
+	out      chan<- message.Message
+	accepted atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewHandler creates a handler sending records to out until the context is
+// cancelled, after which requests are refused
+func NewHandler(ctx context.Context, out chan<- message.Message) *Handler {
+	return &Handler{ctx: ctx, out: out}
+}
+
+// result is the response to an ingest request
+type result struct {
+	Accepted int      `json:"accepted"`
+	Rejected int      `json:"rejected"`
+	Errors   []string `json:"errors,omitempty"` // The first few rejected lines' errors
+}
+
+// ServeHTTP accepts a POST of message.Message JSON lines. Lines that don't
+// parse, or lack a platform or channel fit for file names, are rejected and
+// the rest still taken. received_at and seq are assigned here, and
+// timestamp defaults to the receive time.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var res result
+	reject := func(line int, err error) {
+		res.Rejected++
+		h.rejected.Add(1)
+		if len(res.Errors) < maxErrors {
+			res.Errors = append(res.Errors, fmt.Sprintf("line %d: %v", line, err))
+		}
+	}
+
+	var stopped, gone bool
+	err := message.ScanLines(req.Body, maxRecordBytes, func(line int, data []byte) bool {
+		msg, err := parse(data)
+		if err != nil {
+			reject(line, err)
+			return true
+		}
+
+		select {
+		case h.out <- msg:
+			res.Accepted++
+			h.accepted.Add(1)
+			return true
+		case <-h.ctx.Done():
+			stopped = true
+		case <-req.Context().Done():
+			gone = true
+		}
+		return false
+	})
+	switch {
+	case gone:
+		return
+	case stopped:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(res)
+		return
+	case err != nil:
+		res.Errors = append(res.Errors, fmt.Sprintf("read body: %v", err))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// parse decodes a record and stamps it as received now
+func parse(line []byte) (message.Message, error) {
+	msg, err := message.Decode(line, "")
+	if err != nil {
+		return msg, err
+	}
+
+	var sent time.Time
+	if msg.Timestamp != "" {
+		var err error
+		if sent, err = time.Parse(time.RFC3339Nano, msg.Timestamp); err != nil {
+			return msg, fmt.Errorf("timestamp: %w", err)
+		}
+	}
+	stamped := message.New(msg.Platform, sent)
+	msg.Timestamp = stamped.Timestamp
+	msg.ReceivedAt = stamped.ReceivedAt
+	msg.Sequence = stamped.Sequence
+	return msg, nil
+}
+
+// WriteMetrics writes the counts of accepted and rejected records in
+// Prometheus text format
+func (h *Handler) WriteMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP chatlog_ingest_records_total Records pushed to /ingest, by result.")
+	fmt.Fprintln(w, "# TYPE chatlog_ingest_records_total counter")
+	fmt.Fprintf(w, "chatlog_ingest_records_total{result=\"accepted\"} %d\n", h.accepted.Load())
+	fmt.Fprintf(w, "chatlog_ingest_records_total{result=\"rejected\"} %d\n", h.rejected.Load())
+}
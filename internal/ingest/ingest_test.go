@@ -0,0 +1,55 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+func TestServeHTTPRejectsUnsafeNames(t *testing.T) {
+	out := make(chan message.Message, 10)
+	h := NewHandler(context.Background(), out)
+
+	body := strings.Join([]string{
+		`{"platform":"web","channel":"good","message":"kept"}`,
+		`{"platform":"web","channel":"../../../tmp/x","message":"traversal"}`,
+		``,
+		`{"platform":"we/b","channel":"good"}`,
+		`{"platform":"web","channel":".."}`,
+		`{"platform":"web"}`,
+		`{"platform":"web","channel":"also_good","timestamp":"2024-01-02T03:04:05Z"}`,
+	}, "\n")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var res result
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Accepted != 2 || res.Rejected != 4 {
+		t.Errorf("accepted %d, rejected %d; want 2, 4 (errors %q)", res.Accepted, res.Rejected, res.Errors)
+	}
+	if len(res.Errors) > 0 && !strings.HasPrefix(res.Errors[0], "line 2:") {
+		t.Errorf("first error %q, want it on line 2", res.Errors[0])
+	}
+
+	close(out)
+	var channels []string
+	for msg := range out {
+		channels = append(channels, msg.Channel)
+		if msg.ReceivedAt == "" || msg.Sequence == 0 {
+			t.Errorf("%s was not stamped", msg.Channel)
+		}
+	}
+	if strings.Join(channels, ",") != "good,also_good" {
+		t.Errorf("sent %v", channels)
+	}
+}
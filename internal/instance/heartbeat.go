@@ -0,0 +1,197 @@
+package instance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/john/chatlog/internal/status"
+)
+
+// staleIntervals is how many heartbeat intervals an instance may miss
+// before it is assumed to have stopped
+const staleIntervals = 3
+
+// Heartbeat publishes this instance's Info as an object under a prefix of
+// the archive bucket, refreshed every interval, and watches the other
+// instances' objects there for ones recording the same channels
+type Heartbeat struct {
+	client   *s3.Client
+	bucket   string
+	prefix   string
+	info     Info
+	interval time.Duration
+
+	status *status.Component
+}
+
+// Conflict is another live instance recording some of the same channels
+type Conflict struct {
+	Instance Info
+	Channels []string // Channels both instances record
+}
+
+// NewHeartbeat creates a heartbeat for info stored under prefix in bucket
+func NewHeartbeat(client *s3.Client, bucket, prefix string, info Info, interval time.Duration) *Heartbeat {
+	return &Heartbeat{client: client, bucket: bucket, prefix: prefix, info: info, interval: interval}
+}
+
+// EnableStatus reports conflicts to comp. It must be called before Run.
+func (h *Heartbeat) EnableStatus(comp *status.Component) {
+	h.status = comp
+}
+
+// key is the object holding this instance's heartbeat
+func (h *Heartbeat) key() string {
+	return h.prefix + strings.ReplaceAll(h.info.ID, "/", "_") + ".json"
+}
+
+// Check returns the live instances recording any of this instance's
+// channels. Heartbeats from an earlier run in the same output directory on
+// this host are ignored: holding the directory's lock means it has exited.
+func (h *Heartbeat) Check(ctx context.Context) ([]Conflict, error) {
+	var conflicts []Conflict
+	paginator := s3.NewListObjectsV2Paginator(h.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(h.bucket),
+		Prefix: aws.String(h.prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list heartbeats: %w", err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if key == h.key() || time.Since(aws.ToTime(obj.LastModified)) > staleIntervals*h.interval {
+				continue
+			}
+			other, err := h.read(ctx, key)
+			if err != nil {
+				log.Printf("Warning: Skipping heartbeat %s: %v", key, err)
+				continue
+			}
+			if other.Host == h.info.Host && other.OutputDir == h.info.OutputDir {
+				continue
+			}
+			if shared := overlap(h.info.Channels, other.Channels); len(shared) > 0 {
+				conflicts = append(conflicts, Conflict{Instance: other, Channels: shared})
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// Run publishes the heartbeat and checks for conflicts every interval
+// until the context is cancelled, then deletes the heartbeat so a
+// replacement instance can start at once
+func (h *Heartbeat) Run(ctx context.Context) error {
+	h.status.SetState(status.StateRunning)
+	defer h.status.SetState(status.StateStopped)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		h.beat(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			deleteCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := h.client.DeleteObject(deleteCtx, &s3.DeleteObjectInput{
+				Bucket: aws.String(h.bucket),
+				Key:    aws.String(h.key()),
+			}); err != nil {
+				log.Printf("Warning: Failed to delete instance heartbeat: %v", err)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// beat checks for conflicting instances, then publishes the heartbeat
+func (h *Heartbeat) beat(ctx context.Context) {
+	conflicts, err := h.Check(ctx)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Warning: Instance heartbeat check failed: %v", err)
+		h.status.Set("last_error", err.Error())
+	} else if err == nil {
+		for _, c := range conflicts {
+			log.Printf("Error: Duplicate instance %s is recording %s to the same bucket; the archive will hold duplicates",
+				c.Instance, FormatChannels(c.Channels))
+		}
+		h.status.Set("conflicts", len(conflicts))
+		if len(conflicts) > 0 {
+			h.status.SetState(status.StateDegraded)
+		} else {
+			h.status.SetState(status.StateRunning)
+		}
+	}
+
+	info := h.info
+	info.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	if _, err := h.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(h.bucket),
+		Key:         aws.String(h.key()),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}); err != nil && ctx.Err() == nil {
+		log.Printf("Warning: Failed to publish instance heartbeat: %v", err)
+		h.status.Set("last_error", err.Error())
+	}
+}
+
+// read fetches another instance's heartbeat
+func (h *Heartbeat) read(ctx context.Context, key string) (Info, error) {
+	var info Info
+	out, err := h.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(h.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return info, err
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return info, err
+	}
+	return info, json.Unmarshal(data, &info)
+}
+
+// overlap returns the channels in both a and b
+func overlap(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	for _, channel := range a {
+		seen[channel] = true
+	}
+	var shared []string
+	for _, channel := range b {
+		if seen[channel] {
+			shared = append(shared, channel)
+		}
+	}
+	return shared
+}
+
+// FormatChannels lists channels for a log message, abbreviating long lists
+func FormatChannels(channels []string) string {
+	const shown = 5
+	if len(channels) <= shown {
+		return strings.Join(channels, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(channels[:shown], ", "), len(channels)-shown)
+}
@@ -0,0 +1,113 @@
+package instance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockFileName is the lock file created in the output directory
+const LockFileName = ".chatlog.lock"
+
+// ErrInUse is returned by Lock when another instance holds the lock
+var ErrInUse = errors.New("in use by another instance")
+
+// Info identifies a running instance. It is the content of the lock file
+// and of heartbeat objects.
+type Info struct {
+	ID        string    `json:"id"`
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	Version   string    `json:"version"`
+	OutputDir string    `json:"output_dir"`
+	Channels  []string  `json:"channels"` // "platform/channel" for each configured channel
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// NewInfo describes this process as instance id recording channels to
+// outputDir
+func NewInfo(id, version, outputDir string, channels []string) Info {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	if abs, err := filepath.Abs(outputDir); err == nil {
+		outputDir = abs
+	}
+	return Info{
+		ID:        id,
+		Host:      host,
+		PID:       os.Getpid(),
+		Version:   version,
+		OutputDir: outputDir,
+		Channels:  channels,
+		StartedAt: time.Now().UTC(),
+	}
+}
+
+// String describes the instance for log messages
+func (i Info) String() string {
+	return fmt.Sprintf("%s (host %s, pid %d, started %s)", i.ID, i.Host, i.PID, i.StartedAt.Format(time.RFC3339))
+}
+
+// LockFile is an exclusive lock on an output directory, held by the
+// process until Unlock or exit. The lock is taken with flock, so a crashed
+// instance never leaves a stale lock behind.
+type LockFile struct {
+	file *os.File
+}
+
+// Lock takes the lock file in dir, writing info to it. If another process
+// holds it, the error names that process.
+func Lock(dir string, info Info) (*LockFile, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create output directory: %w", err)
+	}
+	path := filepath.Join(dir, LockFileName)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+
+	locked, err := tryLock(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	if !locked {
+		defer file.Close()
+		var holder Info
+		if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &holder) == nil {
+			return nil, fmt.Errorf("%s is %w: %s", dir, ErrInUse, holder)
+		}
+		return nil, fmt.Errorf("%s is %w", dir, ErrInUse)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("write lock file: %w", err)
+	}
+	if _, err := file.WriteAt(append(data, '\n'), 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("write lock file: %w", err)
+	}
+	return &LockFile{file: file}, nil
+}
+
+// Unlock releases the lock. The file is left in place; removing it could
+// race with another instance taking the lock.
+func (l *LockFile) Unlock() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package instance
+
+import "os"
+
+// tryLock is not supported on this platform; the lock file records the
+// instance but doesn't stop a second one
+func tryLock(file *os.File) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,448 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/egress"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/rawlog"
+	"github.com/john/chatlog/internal/status"
+)
+
+const (
+	// pingInterval is how often the client pings an otherwise quiet server
+	pingInterval = 2 * time.Minute
+	// readTimeout is how long without any line before the connection is dead
+	readTimeout = pingInterval + 30*time.Second
+
+	maxReconnectBackoff = 60 * time.Second
+
+	// maxJoinLength keeps JOIN lines well under the 512 byte limit
+	maxJoinLength = 400
+	// saslChunkSize is the maximum AUTHENTICATE payload per line
+	saslChunkSize = 400
+)
+
+// wantedCaps are the IRCv3 capabilities requested when the server offers them
+var wantedCaps = []string{"server-time", "message-tags", "account-tag"}
+
+// NetworkConfig describes one IRC network and the channels to log on it
+type NetworkConfig struct {
+	Name      string // Short name used in recorded channel names, e.g. "libera"
+	Server    string // host:port
+	Plaintext bool   // Connect without TLS
+	Nick      string
+	Username  string // Defaults to Nick
+	Realname  string // Defaults to Nick
+	Password  string // Server password (PASS)
+
+	SASLUsername     string // SASL PLAIN credentials
+	SASLPassword     string
+	NickServPassword string // Identify with NickServ after connecting, if SASL isn't used
+
+	Channels []string
+}
+
+// Connector logs channels on a single IRC network. Messages are recorded
+// with platform "irc" and channel "{network}.{channel}".
+type Connector struct {
+	network NetworkConfig
+
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	nick string // Current nick; may differ from the configured one after a collision
+
+	egress *egress.Network // nil connects directly
+	rawLog *rawlog.Log     // nil unless EnableRawLog was called
+	status *status.Component
+}
+
+// New creates a new IRC connector for a network
+func New(network NetworkConfig) *Connector {
+	if network.Username == "" {
+		network.Username = network.Nick
+	}
+	if network.Realname == "" {
+		network.Realname = network.Nick
+	}
+	return &Connector{network: network}
+}
+
+// EnableStatus reports connection state and message times to comp. It must
+// be called before Start.
+func (c *Connector) EnableStatus(comp *status.Component) {
+	c.status = comp
+}
+
+// EnableRawLog copies every line sent and received to l, as the network's
+// name. It must be called before Start.
+func (c *Connector) EnableRawLog(l *rawlog.Log) {
+	c.rawLog = l
+}
+
+// EnableEgress connects to the network's server through network. It must
+// be called before Start.
+func (c *Connector) EnableEgress(network *egress.Network) {
+	c.egress = network
+}
+
+// Start connects to the network and records channel messages until the
+// context is cancelled, reconnecting with exponential backoff
+func (c *Connector) Start(ctx context.Context, messageChan chan<- message.Message) error {
+	if len(c.network.Channels) == 0 {
+		return fmt.Errorf("no channels configured for IRC network %s", c.network.Name)
+	}
+
+	backoff := time.Second
+	for {
+		connectedAt := time.Now()
+		err := c.runConnection(ctx, messageChan)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if time.Since(connectedAt) > maxReconnectBackoff {
+			backoff = time.Second
+		}
+
+		log.Printf("IRC connection to %s lost: %v. Reconnecting in %v", c.network.Name, err, backoff)
+		c.status.SetState(status.StateReconnecting)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// registration tracks capability negotiation and login on one connection
+type registration struct {
+	offered    map[string]bool
+	saslActive bool
+	registered bool
+}
+
+// runConnection handles a single connection lifetime
+func (c *Connector) runConnection(ctx context.Context, messageChan chan<- message.Message) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	c.writeMu.Lock()
+	c.conn = conn
+	c.writeMu.Unlock()
+	defer func() {
+		c.writeMu.Lock()
+		c.conn = nil
+		c.writeMu.Unlock()
+	}()
+
+	// Close the connection when the context is cancelled to unblock reads
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.send("QUIT :shutting down")
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	c.nick = c.network.Nick
+	reg := &registration{offered: make(map[string]bool)}
+	c.send("CAP LS 302")
+	if c.network.Password != "" {
+		c.send("PASS " + c.network.Password)
+	}
+	c.send("NICK " + c.nick)
+	c.send(fmt.Sprintf("USER %s 0 * :%s", c.network.Username, c.network.Realname))
+
+	go c.keepalive(done)
+
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		raw, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+		c.rawLog.Received(c.network.Name, []byte(raw))
+
+		line, err := ParseLine(raw)
+		if err != nil {
+			continue
+		}
+		if err := c.handle(ctx, line, reg, messageChan); err != nil {
+			return err
+		}
+	}
+}
+
+// dial opens the TCP (and usually TLS) connection, through the egress
+// network if there is one
+func (c *Connector) dial(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	dialContext := (&net.Dialer{}).DialContext
+	if c.egress != nil {
+		dialContext = c.egress.DialContext
+	}
+	conn, err := dialContext(ctx, "tcp", c.network.Server)
+	if err != nil || c.network.Plaintext {
+		return conn, err
+	}
+
+	host, _, err := net.SplitHostPort(c.network.Server)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// handle processes one line from the server
+func (c *Connector) handle(ctx context.Context, line Line, reg *registration, messageChan chan<- message.Message) error {
+	switch line.Command {
+	case "PING":
+		c.send("PONG :" + line.Param(0))
+
+	case "CAP":
+		c.handleCap(line, reg)
+
+	case "AUTHENTICATE":
+		if line.Param(0) == "+" {
+			c.sendSASLPlain()
+		}
+
+	case "903": // RPL_SASLSUCCESS
+		log.Printf("IRC %s: SASL authentication succeeded", c.network.Name)
+		c.send("CAP END")
+
+	case "902", "904", "905", "906", "908": // SASL failures
+		return fmt.Errorf("SASL authentication failed: %s", line.Param(len(line.Params)-1))
+
+	case "001": // RPL_WELCOME
+		reg.registered = true
+		c.nick = line.Param(0)
+		log.Printf("Connected to IRC network %s as %s", c.network.Name, c.nick)
+		c.status.SetState(status.StateConnected)
+		if c.network.NickServPassword != "" && !reg.saslActive {
+			c.send("PRIVMSG NickServ :IDENTIFY " + c.network.NickServPassword)
+		}
+		c.join()
+
+	case "433": // ERR_NICKNAMEINUSE
+		if !reg.registered {
+			c.nick += "_"
+			log.Printf("IRC %s: nick in use, trying %s", c.network.Name, c.nick)
+			c.send("NICK " + c.nick)
+		}
+
+	case "NICK":
+		if line.Nick() == c.nick {
+			c.nick = line.Param(0)
+		}
+
+	case "JOIN":
+		if line.Nick() == c.nick {
+			log.Printf("Joined IRC channel: %s/%s", c.network.Name, line.Param(0))
+		}
+
+	case "KICK":
+		if line.Param(1) == c.nick {
+			log.Printf("Warning: Kicked from IRC channel %s/%s: %s (rejoining)", c.network.Name, line.Param(0), line.Param(2))
+			c.send("JOIN " + line.Param(0))
+		}
+
+	case "471", "473", "474", "475", "477": // Cannot join channel
+		log.Printf("Warning: Cannot join IRC channel %s/%s: %s", c.network.Name, line.Param(1), line.Param(2))
+
+	case "ERROR":
+		return fmt.Errorf("server error: %s", line.Param(0))
+
+	case "PRIVMSG":
+		chatMessage := c.convertMessage(line)
+		if chatMessage == nil {
+			return nil
+		}
+		c.status.MessageReceived()
+		select {
+		case messageChan <- *chatMessage:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// handleCap negotiates IRCv3 capabilities and starts SASL if configured
+func (c *Connector) handleCap(line Line, reg *registration) {
+	switch line.Param(1) {
+	case "LS":
+		// Multi-line replies have "*" before the final parameter
+		for _, capability := range strings.Fields(line.Param(len(line.Params) - 1)) {
+			name, _, _ := strings.Cut(capability, "=")
+			reg.offered[name] = true
+		}
+		if line.Param(2) == "*" {
+			return
+		}
+
+		var request []string
+		for _, capability := range wantedCaps {
+			if reg.offered[capability] {
+				request = append(request, capability)
+			}
+		}
+		if c.network.SASLUsername != "" {
+			if reg.offered["sasl"] {
+				request = append(request, "sasl")
+			} else {
+				log.Printf("Warning: IRC network %s does not offer SASL; continuing without it", c.network.Name)
+			}
+		}
+		if len(request) == 0 {
+			c.send("CAP END")
+			return
+		}
+		c.send("CAP REQ :" + strings.Join(request, " "))
+
+	case "ACK":
+		for _, capability := range strings.Fields(line.Param(2)) {
+			if capability == "sasl" {
+				reg.saslActive = true
+			}
+		}
+		if reg.saslActive {
+			c.send("AUTHENTICATE PLAIN")
+		} else {
+			c.send("CAP END")
+		}
+
+	case "NAK":
+		log.Printf("Warning: IRC network %s rejected capabilities: %s", c.network.Name, line.Param(2))
+		c.send("CAP END")
+	}
+}
+
+// sendSASLPlain sends SASL PLAIN credentials, split into 400 byte chunks
+func (c *Connector) sendSASLPlain() {
+	payload := base64.StdEncoding.EncodeToString(
+		[]byte(c.network.SASLUsername + "\x00" + c.network.SASLUsername + "\x00" + c.network.SASLPassword))
+
+	for len(payload) >= saslChunkSize {
+		c.send("AUTHENTICATE " + payload[:saslChunkSize])
+		payload = payload[saslChunkSize:]
+	}
+	if payload == "" {
+		payload = "+" // Terminates a payload that was an exact multiple of the chunk size
+	}
+	c.send("AUTHENTICATE " + payload)
+}
+
+// join joins all configured channels, batching them into as few lines as fit
+func (c *Connector) join() {
+	var batch []string
+	length := 0
+	for _, channel := range c.network.Channels {
+		if length+len(channel)+1 > maxJoinLength && len(batch) > 0 {
+			c.send("JOIN " + strings.Join(batch, ","))
+			batch, length = nil, 0
+		}
+		batch = append(batch, channel)
+		length += len(channel) + 1
+	}
+	if len(batch) > 0 {
+		c.send("JOIN " + strings.Join(batch, ","))
+	}
+}
+
+// keepalive pings the server so dead connections are noticed
+func (c *Connector) keepalive(done <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.send("PING :chatlog"); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// send writes a raw line to the server
+func (c *Connector) send(line string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	c.rawLog.Sent(c.network.Name, []byte(line))
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// convertMessage converts a channel PRIVMSG to our generic message format.
+// Private messages to the logger are ignored.
+func (c *Connector) convertMessage(line Line) *message.Message {
+	target := line.Param(0)
+	if target == "" || !strings.ContainsRune("#&+!", rune(target[0])) {
+		return nil
+	}
+
+	text := line.Param(1)
+	if action, ok := ctcpAction(text); ok {
+		text = "/me " + action
+	} else if strings.HasPrefix(text, "\x01") {
+		return nil // Other CTCP requests aren't chat
+	}
+
+	var sentAt time.Time
+	if ts, ok := line.Tags["time"]; ok {
+		sentAt, _ = time.Parse(time.RFC3339Nano, ts)
+	}
+
+	chatMessage := message.New("irc", sentAt)
+	chatMessage.Channel = ChannelName(c.network.Name, target)
+	chatMessage.Username = line.Nick()
+	// Services account, if the server sends account-tag; nicks aren't stable IDs
+	chatMessage.UserID = line.Tags["account"]
+	chatMessage.ID = line.Tags["msgid"]
+	chatMessage.Message = text
+
+	return &chatMessage
+}
+
+// ChannelName returns the recorded channel name for an IRC channel:
+// "{network}.{channel}" lowercased, without channel prefixes
+func ChannelName(network, channel string) string {
+	channel = strings.ToLower(strings.TrimLeft(channel, "#&+!"))
+	channel = strings.ReplaceAll(channel, "/", "-") // Keep it usable in file names
+	return strings.ToLower(network) + "." + channel
+}
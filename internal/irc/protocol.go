@@ -0,0 +1,116 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Line is a parsed IRC protocol line with IRCv3 message tags
+type Line struct {
+	Tags    map[string]string
+	Source  string // Full prefix, e.g. "nick!user@host"
+	Command string
+	Params  []string
+}
+
+// Nick returns the nickname part of the line's source
+func (l Line) Nick() string {
+	nick, _, _ := strings.Cut(l.Source, "!")
+	return nick
+}
+
+// Param returns the i-th parameter, or "" if there are fewer
+func (l Line) Param(i int) string {
+	if i >= 0 && i < len(l.Params) {
+		return l.Params[i]
+	}
+	return ""
+}
+
+// ParseLine parses a raw IRC line without its trailing CRLF
+func ParseLine(raw string) (Line, error) {
+	var line Line
+	raw = strings.TrimRight(raw, "\r\n")
+
+	if strings.HasPrefix(raw, "@") {
+		var tags string
+		tags, raw, _ = strings.Cut(raw[1:], " ")
+		line.Tags = parseTags(tags)
+		raw = strings.TrimLeft(raw, " ")
+	}
+	if strings.HasPrefix(raw, ":") {
+		line.Source, raw, _ = strings.Cut(raw[1:], " ")
+		raw = strings.TrimLeft(raw, " ")
+	}
+
+	line.Command, raw, _ = strings.Cut(raw, " ")
+	if line.Command == "" {
+		return line, fmt.Errorf("missing command")
+	}
+	line.Command = strings.ToUpper(line.Command)
+
+	for raw != "" {
+		raw = strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(raw, ":") {
+			line.Params = append(line.Params, raw[1:])
+			break
+		}
+		var param string
+		param, raw, _ = strings.Cut(raw, " ")
+		if param != "" {
+			line.Params = append(line.Params, param)
+		}
+	}
+
+	return line, nil
+}
+
+// parseTags parses the IRCv3 tag section, unescaping values
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, "=")
+		tags[key] = unescapeTag(value)
+	}
+	return tags
+}
+
+// tagEscapes maps the character after a backslash in a tag value to what it
+// stands for
+var tagEscapes = map[byte]byte{':': ';', 's': ' ', '\\': '\\', 'r': '\r', 'n': '\n'}
+
+// unescapeTag unescapes an IRCv3 tag value
+func unescapeTag(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+1 == len(value) {
+			break // Trailing backslash is dropped
+		}
+		i++
+		if c, ok := tagEscapes[value[i]]; ok {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// ctcpAction extracts the text of a CTCP ACTION (/me), if text is one
+func ctcpAction(text string) (string, bool) {
+	if !strings.HasPrefix(text, "\x01ACTION ") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(text, "\x01ACTION "), "\x01"), true
+}
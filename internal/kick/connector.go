@@ -1,215 +1,503 @@
 package kick
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	kickchat "github.com/johanvandegriff/kick-chat-wrapper"
+	"github.com/john/chatlog/internal/egress"
 	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/rawlog"
+	"github.com/john/chatlog/internal/retry"
+	"github.com/john/chatlog/internal/status"
 )
 
 // KickChannelResponse represents the API response from Kick
 type KickChannelResponse struct {
 	ID       int    `json:"id"`
+	UserID   int    `json:"user_id"`
 	Slug     string `json:"slug"`
 	Chatroom struct {
 		ID int `json:"id"`
 	} `json:"chatroom"`
 }
 
+// ChatMessage represents a chat message event from a Kick chatroom
+type ChatMessage struct {
+	ID         string    `json:"id"`
+	ChatroomID int       `json:"chatroom_id"`
+	Content    string    `json:"content"`
+	Type       string    `json:"type"`
+	CreatedAt  time.Time `json:"created_at"`
+	Sender     Sender    `json:"sender"`
+	Metadata   *Metadata `json:"metadata"`
+}
+
+// Metadata holds the message a reply answers; it is set when Type is "reply"
+type Metadata struct {
+	OriginalSender struct {
+		ID       flexibleID `json:"id"`
+		Username string     `json:"username"`
+	} `json:"original_sender"`
+	OriginalMessage struct {
+		ID      string `json:"id"`
+		Content string `json:"content"`
+	} `json:"original_message"`
+}
+
+// Sender represents the author of a Kick chat message
+type Sender struct {
+	ID       int      `json:"id"`
+	Username string   `json:"username"`
+	Slug     string   `json:"slug"`
+	Identity Identity `json:"identity"`
+}
+
+// Identity holds a sender's chat appearance
+type Identity struct {
+	Color  string  `json:"color"`
+	Badges []Badge `json:"badges"`
+}
+
+// Badge represents a Kick chat badge
+type Badge struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Count int    `json:"count"`
+}
+
+// flexibleID is an ID Kick sends as either a number or a string
+type flexibleID string
+
+func (id *flexibleID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = flexibleID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*id = flexibleID(n.String())
+	return nil
+}
+
+// chatMessageEvent is the Pusher event name for chat messages
+const chatMessageEvent = `App\Events\ChatMessageEvent`
+
 // ChannelConfig represents a Kick channel with optional pre-configured chatroom ID
 type ChannelConfig struct {
 	Slug       string
 	ChatroomID int // 0 means not pre-configured, needs resolution
 }
 
+// DefaultResolveRetry is the retry policy for channels that fail to
+// resolve at startup
+var DefaultResolveRetry = retry.Policy{Base: 30 * time.Second, Multiplier: 2, Max: 30 * time.Minute}
+
 // Connector manages Kick chat connections
 type Connector struct {
-	channels   []ChannelConfig
-	channelIDs map[string]int // channel slug -> chatroom ID
-	idToSlug   map[int]string // chatroom ID -> channel slug (for reverse lookup)
-	client     *kickchat.Client
+	channels     []ChannelConfig
+	channelIDs   map[string]int // channel slug -> chatroom ID
+	idToSlug     map[int]string // chatroom ID -> channel slug (for reverse lookup)
+	renamed      map[int]string // chatroom ID -> current slug, for channels renamed since configured
+	broadcasters map[int]int    // chatroom ID -> broadcaster user ID, once known
+	channelsMu   sync.RWMutex
+	resolver     *Resolver
+	client       *PusherClient
+	status       *status.Component
+	egress       *egress.Network // Set by EnableEgress; nil connects directly
+
+	renameInterval time.Duration // How often joined slugs are re-resolved; 0 disables
+	resolveRetry   retry.Policy  // Delays between lookups of channels that failed to resolve
+	maxSkew        time.Duration // Furthest created_at may be from receive time; 0 disables
+	skewed         atomic.Int64  // Messages whose created_at was replaced
 }
 
-// New creates a new Kick connector
-func New(channels []ChannelConfig) *Connector {
+// New creates a new Kick connector. An empty cluster or app key selects
+// the defaults used by the Kick web client.
+func New(channels []ChannelConfig, pusherCluster, pusherAppKey string) *Connector {
 	return &Connector{
-		channels:   channels,
-		channelIDs: make(map[string]int),
-		idToSlug:   make(map[int]string),
+		channels:     channels,
+		channelIDs:   make(map[string]int),
+		idToSlug:     make(map[int]string),
+		renamed:      make(map[int]string),
+		broadcasters: make(map[int]int),
+		resolver:     NewResolver(time.Second, ""),
+		client:       NewPusherClient(pusherCluster, pusherAppKey),
+		resolveRetry: DefaultResolveRetry,
 	}
 }
 
+// EnableRenameCheck re-resolves each joined channel's slug every interval
+// to notice renames. Chatroom IDs survive renames, so the chatroom keeps
+// being recorded either way; a rename is also noticed as soon as the
+// broadcaster chats. It must be called before Start.
+func (c *Connector) EnableRenameCheck(interval time.Duration) {
+	c.renameInterval = interval
+}
+
+// EnableSkewCheck replaces created_at times more than maxSkew from when a
+// message was received with the receive time, keeping the original in
+// platform_timestamp. It must be called before Start.
+func (c *Connector) EnableSkewCheck(maxSkew time.Duration) {
+	c.maxSkew = maxSkew
+}
+
+// EnableResolveRetry replaces DefaultResolveRetry for channels that fail to
+// resolve at startup. A channel is given up on once p's MaxElapsed passes.
+// It must be called before Start.
+func (c *Connector) EnableResolveRetry(p retry.Policy) {
+	c.resolveRetry = p
+}
+
+// EnableResolver replaces the default resolver, which makes one request a
+// second and caches nothing. It must be called before Start.
+func (c *Connector) EnableResolver(r *Resolver) {
+	c.resolver = r
+}
+
+// EnableStatus reports connection state and message times to comp. It must
+// be called before Start.
+func (c *Connector) EnableStatus(comp *status.Component) {
+	c.status = comp
+	c.client.status = comp
+}
+
+// EnableRawLog copies every Pusher frame sent and received to l. It must
+// be called before Start.
+func (c *Connector) EnableRawLog(l *rawlog.Log) {
+	c.client.rawLog = l
+}
+
 // Start begins listening to Kick chat
 func (c *Connector) Start(ctx context.Context, messageChan chan<- message.Message) error {
-	// Step 1: Resolve all channel names to chatroom IDs
+	if c.egress != nil {
+		c.resolver.EnableEgress(c.egress)
+	}
+
+	// Step 1: Resolve channel names to chatroom IDs and subscribe; subscriptions
+	// are sent once connected. Channels that fail are retried in the background.
 	log.Println("Resolving Kick channel IDs...")
+	var failed []string
 	for _, channel := range c.channels {
-		var chatroomID int
-		var slug string
-		var err error
-
 		if channel.ChatroomID > 0 {
-			// Use pre-configured chatroom ID
-			chatroomID = channel.ChatroomID
-			slug = channel.Slug
-			log.Printf("Using pre-configured Kick channel: %s -> ID %d", slug, chatroomID)
-		} else {
-			// Need to resolve via API
-			chatroomID, slug, err = c.resolveChannelID(channel.Slug)
-			if err != nil {
-				log.Printf("Warning: Failed to resolve Kick channel '%s': %v (skipping)", channel.Slug, err)
-				continue
+			current := channel.Slug
+			// Keep a rename noticed before the restart
+			if cached, ok := c.resolver.cached(channel.Slug); ok && cached.ChatroomID == channel.ChatroomID {
+				current = cached.Slug
 			}
-			log.Printf("Resolved Kick channel: %s -> ID %d", slug, chatroomID)
+			log.Printf("Using pre-configured Kick channel: %s -> ID %d", current, channel.ChatroomID)
+			c.join(channel.Slug, current, channel.ChatroomID)
+			continue
 		}
 
-		c.channelIDs[slug] = chatroomID
-		c.idToSlug[chatroomID] = slug
-	}
-
-	if len(c.channelIDs) == 0 {
-		return fmt.Errorf("no valid Kick channels could be resolved")
-	}
-
-	// Step 2: Create WebSocket client
-	log.Println("Connecting to Kick chat...")
-	client, err := kickchat.NewClient()
-	if err != nil {
-		return fmt.Errorf("failed to create Kick client: %w", err)
-	}
-	c.client = client
-	log.Println("Connected to Kick WebSocket")
-
-	// Step 3: Join all chatrooms
-	for slug, chatroomID := range c.channelIDs {
-		if err := c.client.JoinChannelByID(chatroomID); err != nil {
-			log.Printf("Warning: Failed to join Kick channel '%s' (ID %d): %v", slug, chatroomID, err)
+		chatroomID, slug, err := c.resolver.Resolve(ctx, channel.Slug)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Warning: Failed to resolve Kick channel '%s': %v (retrying in background)", channel.Slug, err)
+			failed = append(failed, channel.Slug)
 			continue
 		}
-		log.Printf("Joined Kick channel: %s", slug)
+		log.Printf("Resolved Kick channel: %s -> ID %d", slug, chatroomID)
+		c.join(channel.Slug, slug, chatroomID)
 	}
 
-	// Step 4: Start listening for messages
-	messages := c.client.ListenForMessages()
+	if len(failed) > 0 {
+		c.status.Set("unresolved_channels", failed)
+		go c.retryResolve(ctx, failed)
+	}
+	if c.renameInterval > 0 {
+		go c.checkRenames(ctx)
+	}
 
-	// Process messages until context is cancelled
+	// Step 3: Process events until the client stops
 	go func() {
-		for {
-			select {
-			case msg, ok := <-messages:
-				if !ok {
-					log.Println("Kick message channel closed")
-					return
-				}
+		for event := range c.client.Events() {
+			if event.Event != chatMessageEvent {
+				continue
+			}
 
-				// Convert Kick message to generic message format
-				chatMessage := c.convertMessage(msg)
-				if chatMessage == nil {
-					continue // Skip invalid messages
-				}
+			var msg ChatMessage
+			if err := json.Unmarshal(event.Data, &msg); err != nil {
+				log.Printf("Warning: Failed to decode Kick chat message: %v", err)
+				continue
+			}
 
-				// Send to message channel
-				select {
-				case messageChan <- *chatMessage:
-				case <-ctx.Done():
-					return
-				}
+			// Convert Kick message to generic message format
+			chatMessage := c.convertMessage(msg)
+			if chatMessage == nil {
+				continue // Skip invalid messages
+			}
+
+			c.status.MessageReceived()
 
+			// Send to message channel
+			select {
+			case messageChan <- *chatMessage:
 			case <-ctx.Done():
 				return
 			}
 		}
 	}()
 
-	// Wait for context cancellation
-	<-ctx.Done()
+	// Step 4: Connect and run until context cancellation
+	log.Println("Connecting to Kick chat...")
+	err := c.client.Run(ctx)
+
+	log.Println("Disconnected from Kick chat")
+	return err
+}
 
-	// Cleanup
-	log.Println("Disconnecting from Kick chat...")
-	if c.client != nil {
-		c.client.Close()
+// join records a resolved channel and subscribes to its chatroom. slug is
+// the configured slug, which the channel's records keep as their channel,
+// and current the slug it resolved to, which differs after a rename.
+func (c *Connector) join(slug, current string, chatroomID int) {
+	if strings.EqualFold(slug, current) {
+		slug = current // The API's spelling
+	}
+	c.channelsMu.Lock()
+	c.channelIDs[slug] = chatroomID
+	c.idToSlug[chatroomID] = slug
+	if current != slug {
+		c.renamed[chatroomID] = current
 	}
+	if cached, ok := c.resolver.cached(current); ok && cached.ChatroomID == chatroomID && cached.UserID != 0 {
+		c.broadcasters[chatroomID] = cached.UserID
+	}
+	joined := len(c.channelIDs)
+	c.channelsMu.Unlock()
+	c.status.Set("channels", joined)
 
-	return ctx.Err()
+	if err := c.client.Subscribe(chatroomChannel(chatroomID)); err != nil {
+		log.Printf("Warning: Failed to join Kick channel '%s' (ID %d): %v", slug, chatroomID, err)
+		return
+	}
+	log.Printf("Joined Kick channel: %s", slug)
 }
 
-// resolveChannelID fetches channel information from Kick API
-func (c *Connector) resolveChannelID(channelName string) (int, string, error) {
-	url := fmt.Sprintf("https://kick.com/api/v2/channels/%s", channelName)
+// retryResolve keeps resolving channels that failed at startup, backing off
+// per channel, until they all resolve or are given up on, or ctx is
+// cancelled
+func (c *Connector) retryResolve(ctx context.Context, pending []string) {
+	backoffs := make(map[string]*retry.Backoff, len(pending))
+	due := make(map[string]time.Time, len(pending))
+	var remaining []string
+	for _, slug := range pending {
+		backoffs[slug] = c.resolveRetry.Start()
+		if delay, ok := backoffs[slug].Next(); ok {
+			due[slug] = time.Now().Add(delay)
+			remaining = append(remaining, slug)
+		} else {
+			log.Printf("Error: Giving up on resolving Kick channel '%s'", slug)
+		}
+	}
+	pending = remaining
+	c.status.Set("unresolved_channels", pending)
+
+	for len(pending) > 0 {
+		next := due[pending[0]]
+		for _, slug := range pending[1:] {
+			if due[slug].Before(next) {
+				next = due[slug]
+			}
+		}
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return
+		}
 
-	// Create request with headers to bypass CloudFlare blocking
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return 0, "", fmt.Errorf("failed to create request: %w", err)
+		var remaining []string
+		for _, slug := range pending {
+			if time.Now().Before(due[slug]) {
+				remaining = append(remaining, slug)
+				continue
+			}
+			chatroomID, canonical, err := c.resolver.Resolve(ctx, slug)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				delay, ok := backoffs[slug].Next()
+				if !ok {
+					log.Printf("Error: Failed to resolve Kick channel '%s': %v (giving up)", slug, err)
+					continue
+				}
+				due[slug] = time.Now().Add(delay)
+				log.Printf("Warning: Failed to resolve Kick channel '%s': %v (retrying in %s)", slug, err, delay.Round(time.Second))
+				remaining = append(remaining, slug)
+				continue
+			}
+			log.Printf("Resolved Kick channel: %s -> ID %d", canonical, chatroomID)
+			c.join(slug, canonical, chatroomID)
+		}
+		pending = remaining
+		c.status.Set("unresolved_channels", pending)
 	}
+}
 
-	// Set comprehensive browser headers to appear more legitimate
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/143.0.0.0 Safari/537.36")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
-	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-	req.Header.Set("Referer", "https://kick.com/")
-	req.Header.Set("Origin", "https://kick.com")
-	req.Header.Set("Sec-Fetch-Dest", "empty")
-	req.Header.Set("Sec-Fetch-Mode", "cors")
-	req.Header.Set("Sec-Fetch-Site", "same-origin")
-	req.Header.Set("sec-ch-ua", `"Chromium";v="143", "Not.A/Brand";v="24", "Google Chrome";v="143"`)
-	req.Header.Set("sec-ch-ua-mobile", "?0")
-	req.Header.Set("sec-ch-ua-platform", `"Windows"`)
+// checkRenames re-resolves every joined slug each renameInterval. A slug
+// that now names the same chatroom under a different spelling is a rename;
+// one that is gone or names another chatroom is only reported, since the
+// new name can't be looked up from the chatroom ID.
+func (c *Connector) checkRenames(ctx context.Context) {
+	ticker := time.NewTicker(c.renameInterval)
+	defer ticker.Stop()
+
+	reported := make(map[string]bool) // Slugs already warned about
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, "", fmt.Errorf("HTTP request failed: %w", err)
-	}
-	defer resp.Body.Close()
+		c.channelsMu.RLock()
+		// Renamed channels are looked up by their current slug
+		joined := make(map[string]int, len(c.idToSlug))
+		for chatroomID, slug := range c.idToSlug {
+			joined[cmp.Or(c.renamed[chatroomID], slug)] = chatroomID
+		}
+		c.channelsMu.RUnlock()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return 0, "", fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+		var missing []string
+		for slug, chatroomID := range joined {
+			resolved, err := c.resolver.Refresh(ctx, slug)
+			if ctx.Err() != nil {
+				return
+			}
+			switch {
+			case errors.Is(err, ErrNotFound):
+				missing = append(missing, slug)
+				if !reported[slug] {
+					reported[slug] = true
+					log.Printf("Warning: Kick channel '%s' (chatroom %d) no longer exists under that name and may have been renamed; its chat is still recorded as %s until its broadcaster chats", slug, chatroomID, slug)
+				}
+			case err != nil:
+				log.Printf("Warning: Failed to re-resolve Kick channel '%s': %v", slug, err)
+			case resolved.ChatroomID != chatroomID:
+				missing = append(missing, slug)
+				if !reported[slug] {
+					reported[slug] = true
+					log.Printf("Warning: Kick slug '%s' now belongs to chatroom %d; still recording chatroom %d as %s", slug, resolved.ChatroomID, chatroomID, slug)
+				}
+			default:
+				delete(reported, slug)
+				c.channelsMu.Lock()
+				if resolved.UserID != 0 {
+					c.broadcasters[chatroomID] = resolved.UserID
+				}
+				c.channelsMu.Unlock()
+				if !strings.EqualFold(resolved.Slug, slug) {
+					c.rename(chatroomID, resolved.Slug)
+				}
+			}
+		}
+		c.status.Set("renamed_or_missing", missing)
 	}
+}
 
-	var channelInfo KickChannelResponse
-	if err := json.NewDecoder(resp.Body).Decode(&channelInfo); err != nil {
-		return 0, "", fmt.Errorf("JSON decode failed: %w", err)
+// rename records a chatroom's new slug. Its records keep the configured
+// slug as their channel, so groups, tenants and file names stay with it,
+// and carry the new one as current_channel.
+func (c *Connector) rename(chatroomID int, newSlug string) {
+	c.channelsMu.Lock()
+	slug, ok := c.idToSlug[chatroomID]
+	oldSlug := cmp.Or(c.renamed[chatroomID], slug)
+	if !ok || oldSlug == newSlug {
+		c.channelsMu.Unlock()
+		return
 	}
+	if strings.EqualFold(newSlug, slug) {
+		delete(c.renamed, chatroomID)
+	} else {
+		c.renamed[chatroomID] = newSlug
+	}
+	c.channelsMu.Unlock()
 
-	return channelInfo.Chatroom.ID, channelInfo.Slug, nil
+	c.resolver.Rename(oldSlug, newSlug, chatroomID)
+	log.Printf("Kick channel '%s' was renamed to '%s' (chatroom %d); still recording it as '%s'", oldSlug, newSlug, chatroomID, slug)
+}
+
+// chatroomChannel returns the Pusher channel name for a chatroom
+func chatroomChannel(chatroomID int) string {
+	return fmt.Sprintf("chatrooms.%d.v2", chatroomID)
 }
 
 // convertMessage converts a Kick ChatMessage to our generic message.Message
-func (c *Connector) convertMessage(msg kickchat.ChatMessage) *message.Message {
+func (c *Connector) convertMessage(msg ChatMessage) *message.Message {
 	// Look up channel slug from chatroom ID
+	c.channelsMu.RLock()
 	slug, ok := c.idToSlug[msg.ChatroomID]
+	current := c.renamed[msg.ChatroomID]
+	broadcaster := c.broadcasters[msg.ChatroomID]
+	c.channelsMu.RUnlock()
 	if !ok {
 		log.Printf("Warning: Received message from unknown chatroom ID: %d", msg.ChatroomID)
 		return nil
 	}
 
+	// The broadcaster's own messages carry the channel's current slug
+	if broadcaster != 0 && msg.Sender.ID == broadcaster && msg.Sender.Slug != "" && !strings.EqualFold(msg.Sender.Slug, cmp.Or(current, slug)) {
+		c.rename(msg.ChatroomID, msg.Sender.Slug)
+		current = msg.Sender.Slug
+		if strings.EqualFold(current, slug) {
+			current = ""
+		}
+	}
+
 	// Format badges
 	badges := c.formatBadges(msg.Sender.Identity.Badges)
 
-	return &message.Message{
-		Platform:  "kick",
-		Timestamp: msg.CreatedAt.Format(time.RFC3339),
-		Channel:   slug,
-		Username:  msg.Sender.Username,
-		UserID:    strconv.Itoa(msg.Sender.ID),
-		Message:   msg.Content,
-		Badges:    badges,
+	var chatMessage message.Message
+	if c.maxSkew == 0 {
+		chatMessage = message.New("kick", msg.CreatedAt)
+	} else {
+		var skewed bool
+		if chatMessage, skewed = message.NewWithinSkew("kick", msg.CreatedAt, c.maxSkew); skewed {
+			n := c.skewed.Add(1)
+			if n == 1 || n%1000 == 0 {
+				log.Printf("Warning: Kick message in %s has created_at %s, received %s; recording the receive time (%d so far)",
+					slug, chatMessage.PlatformTimestamp, chatMessage.ReceivedAt, n)
+			}
+			c.status.Set("skewed_timestamps", n)
+		}
 	}
+	chatMessage.Channel = slug
+	chatMessage.CurrentChannel = current
+	chatMessage.ChannelID = strconv.Itoa(msg.ChatroomID)
+	chatMessage.Username = msg.Sender.Username
+	chatMessage.UserID = strconv.Itoa(msg.Sender.ID)
+	chatMessage.ID = msg.ID
+	chatMessage.Message = msg.Content
+	chatMessage.Badges = badges
+	chatMessage.Emotes, chatMessage.EmoteRefs = parseEmotes(msg.Content)
+	if m := msg.Metadata; msg.Type == "reply" && m != nil && m.OriginalMessage.ID != "" {
+		chatMessage.Reply = &message.Reply{
+			ParentID:       m.OriginalMessage.ID,
+			ParentUserID:   string(m.OriginalSender.ID),
+			ParentUsername: m.OriginalSender.Username,
+			ParentText:     m.OriginalMessage.Content,
+		}
+	}
+
+	return &chatMessage
 }
 
 // formatBadges converts Kick badges to a comma-separated string
-func (c *Connector) formatBadges(badges []kickchat.Badge) string {
+func (c *Connector) formatBadges(badges []Badge) string {
 	if len(badges) == 0 {
 		return ""
 	}
@@ -0,0 +1,51 @@
+package kick
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenameKeepsConfiguredChannel(t *testing.T) {
+	c := New(nil, "", "")
+	c.join("oldname", "oldname", 7)
+	c.broadcasters[7] = 99
+
+	chat := func(senderID int, senderSlug string) ChatMessage {
+		return ChatMessage{ID: "m", ChatroomID: 7, Content: "hi", CreatedAt: time.Now(), Sender: Sender{ID: senderID, Username: senderSlug, Slug: senderSlug}}
+	}
+
+	msg := c.convertMessage(chat(1, "viewer"))
+	if msg.Channel != "oldname" || msg.CurrentChannel != "" {
+		t.Fatalf("before rename: channel %q, current %q", msg.Channel, msg.CurrentChannel)
+	}
+
+	// The broadcaster chats under a new slug
+	msg = c.convertMessage(chat(99, "newname"))
+	if msg.Channel != "oldname" || msg.CurrentChannel != "newname" || msg.ChannelID != "7" {
+		t.Errorf("broadcaster's message: channel %q, current %q, id %q", msg.Channel, msg.CurrentChannel, msg.ChannelID)
+	}
+	msg = c.convertMessage(chat(1, "viewer"))
+	if msg.Channel != "oldname" || msg.CurrentChannel != "newname" {
+		t.Errorf("after rename: channel %q, current %q", msg.Channel, msg.CurrentChannel)
+	}
+
+	// Renaming back clears it
+	c.rename(7, "oldname")
+	msg = c.convertMessage(chat(1, "viewer"))
+	if msg.Channel != "oldname" || msg.CurrentChannel != "" {
+		t.Errorf("after renaming back: channel %q, current %q", msg.Channel, msg.CurrentChannel)
+	}
+}
+
+func TestJoinRenamedBeforeRestart(t *testing.T) {
+	c := New(nil, "", "")
+	c.join("Configured", "configured", 1) // Only the API's spelling differs
+	c.join("oldname", "newname", 2)       // Renamed in a previous run
+
+	if got := c.convertMessage(ChatMessage{ChatroomID: 1, Sender: Sender{ID: 5}}); got.Channel != "configured" || got.CurrentChannel != "" {
+		t.Errorf("respelled: channel %q, current %q", got.Channel, got.CurrentChannel)
+	}
+	if got := c.convertMessage(ChatMessage{ChatroomID: 2, Sender: Sender{ID: 5}}); got.Channel != "oldname" || got.CurrentChannel != "newname" {
+		t.Errorf("renamed: channel %q, current %q", got.Channel, got.CurrentChannel)
+	}
+}
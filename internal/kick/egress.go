@@ -0,0 +1,20 @@
+package kick
+
+import (
+	"time"
+
+	"github.com/john/chatlog/internal/egress"
+)
+
+// EnableEgress connects to Pusher and looks channels up through network,
+// including with a resolver set by EnableResolver. It must be called
+// before Start.
+func (c *Connector) EnableEgress(network *egress.Network) {
+	c.egress = network
+	c.client.dialer = network.WebSocketDialer()
+}
+
+// EnableEgress sends the resolver's API requests through network
+func (r *Resolver) EnableEgress(network *egress.Network) {
+	r.httpClient = network.HTTPClient(10 * time.Second)
+}
@@ -0,0 +1,40 @@
+package kick
+
+import (
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// emoteRe matches the inline markup Kick sends for emotes, [emote:ID:name]
+var emoteRe = regexp.MustCompile(`\[emote:(\d+):([^\]]+)\]`)
+
+// parseEmotes returns the names of the emotes in a message's content, in
+// order, and where each one's markup sits. Offsets are in runes of the
+// content as received, which keeps the markup.
+func parseEmotes(content string) ([]string, []message.EmoteRef) {
+	matches := emoteRe.FindAllStringSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(matches))
+	refs := make([]message.EmoteRef, 0, len(matches))
+	offset, last := 0, 0 // Runes before byte last
+	for _, m := range matches {
+		offset += utf8.RuneCountInString(content[last:m[0]])
+		length := utf8.RuneCountInString(content[m[0]:m[1]])
+		name := content[m[4]:m[5]]
+		names = append(names, name)
+		refs = append(refs, message.EmoteRef{
+			ID:    content[m[2]:m[3]],
+			Name:  name,
+			Start: offset,
+			End:   offset + length,
+		})
+		offset += length
+		last = m[1]
+	}
+	return names, refs
+}
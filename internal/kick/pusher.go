@@ -0,0 +1,298 @@
+package kick
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/john/chatlog/internal/rawlog"
+	"github.com/john/chatlog/internal/status"
+)
+
+const (
+	// DefaultPusherCluster is the Pusher cluster Kick chat is served from
+	DefaultPusherCluster = "us2"
+	// DefaultPusherAppKey is the public Pusher app key used by the Kick web client
+	DefaultPusherAppKey = "32cbd69e4b950bf97679"
+
+	pusherProtocol = 7
+	pusherVersion  = "8.4.0-rc2"
+
+	// defaultActivityTimeout is used until the server tells us its own value
+	defaultActivityTimeout = 120 * time.Second
+	pongTimeout            = 30 * time.Second
+
+	maxReconnectBackoff = 60 * time.Second
+)
+
+// PusherEvent is a raw event received from the Pusher WebSocket.
+// Data holds the event payload, unwrapped from its JSON string encoding.
+type PusherEvent struct {
+	Event   string
+	Channel string
+	Data    []byte
+}
+
+// pusherFrame is the wire format of a Pusher protocol message
+type pusherFrame struct {
+	Event   string          `json:"event"`
+	Channel string          `json:"channel,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// PusherClient is a minimal Pusher protocol client with automatic reconnection
+type PusherClient struct {
+	url string
+
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	subscriptions map[string]bool
+	subMu         sync.Mutex
+
+	events chan PusherEvent
+
+	status *status.Component // Set by Connector.EnableStatus
+	dialer *websocket.Dialer // Replaced by Connector.EnableEgress
+	rawLog *rawlog.Log       // Set by Connector.EnableRawLog
+}
+
+// NewPusherClient creates a new Pusher client for the given cluster and app key
+func NewPusherClient(cluster, appKey string) *PusherClient {
+	if cluster == "" {
+		cluster = DefaultPusherCluster
+	}
+	if appKey == "" {
+		appKey = DefaultPusherAppKey
+	}
+
+	return &PusherClient{
+		url: fmt.Sprintf("wss://ws-%s.pusher.com/app/%s?protocol=%d&client=js&version=%s&flash=false",
+			cluster, appKey, pusherProtocol, pusherVersion),
+		subscriptions: make(map[string]bool),
+		events:        make(chan PusherEvent, 100),
+		dialer:        websocket.DefaultDialer,
+	}
+}
+
+// Events returns the channel on which all non-protocol events are delivered
+func (p *PusherClient) Events() <-chan PusherEvent {
+	return p.events
+}
+
+// Run connects to Pusher and processes events until the context is cancelled,
+// reconnecting with exponential backoff whenever the connection drops.
+func (p *PusherClient) Run(ctx context.Context) error {
+	defer close(p.events)
+
+	backoff := time.Second
+	for {
+		connectedAt := time.Now()
+		err := p.runConnection(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Reset backoff if the connection was healthy for a while
+		if time.Since(connectedAt) > maxReconnectBackoff {
+			backoff = time.Second
+		}
+
+		log.Printf("Kick Pusher connection lost: %v. Reconnecting in %v", err, backoff)
+		p.status.SetState(status.StateReconnecting)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// runConnection handles a single WebSocket connection lifetime
+func (p *PusherClient) runConnection(ctx context.Context) error {
+	conn, _, err := p.dialer.DialContext(ctx, p.url, nil)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	p.writeMu.Lock()
+	p.conn = conn
+	p.writeMu.Unlock()
+	defer func() {
+		p.writeMu.Lock()
+		p.conn = nil
+		p.writeMu.Unlock()
+	}()
+
+	// Close the connection when the context is cancelled to unblock reads
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	// The keepalive goroutine pings every activityTimeout, so a connection
+	// that stays silent for longer than that plus pongTimeout is dead
+	activityTimeout := defaultActivityTimeout
+	conn.SetReadDeadline(time.Now().Add(activityTimeout + pongTimeout))
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+		p.rawLog.Received("pusher", raw)
+		var frame pusherFrame
+		if err := json.Unmarshal(raw, &frame); err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(activityTimeout + pongTimeout))
+
+		data := unwrapData(frame.Data)
+
+		switch frame.Event {
+		case "pusher:connection_established":
+			var established struct {
+				SocketID        string `json:"socket_id"`
+				ActivityTimeout int    `json:"activity_timeout"`
+			}
+			if err := json.Unmarshal(data, &established); err == nil && established.ActivityTimeout > 0 {
+				activityTimeout = time.Duration(established.ActivityTimeout) * time.Second
+				conn.SetReadDeadline(time.Now().Add(activityTimeout + pongTimeout))
+			}
+			log.Println("Connected to Kick Pusher WebSocket")
+			p.status.SetState(status.StateConnected)
+			if err := p.resubscribe(); err != nil {
+				return fmt.Errorf("resubscribe: %w", err)
+			}
+			go p.keepalive(conn, activityTimeout, done)
+
+		case "pusher:ping":
+			if err := p.send(pusherFrame{Event: "pusher:pong", Data: json.RawMessage("{}")}); err != nil {
+				return fmt.Errorf("send pong: %w", err)
+			}
+
+		case "pusher:pong":
+			// Response to our keepalive ping
+
+		case "pusher:error":
+			log.Printf("Kick Pusher error: %s", string(data))
+
+		case "pusher_internal:subscription_succeeded":
+			log.Printf("Subscribed to Kick Pusher channel: %s", frame.Channel)
+
+		default:
+			select {
+			case p.events <- PusherEvent{Event: frame.Event, Channel: frame.Channel, Data: data}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// keepalive sends client pings at the activity timeout interval
+func (p *PusherClient) keepalive(conn *websocket.Conn, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.send(pusherFrame{Event: "pusher:ping", Data: json.RawMessage("{}")}); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Subscribe subscribes to a public Pusher channel. The subscription is
+// remembered and restored automatically after a reconnect.
+func (p *PusherClient) Subscribe(channel string) error {
+	p.subMu.Lock()
+	p.subscriptions[channel] = true
+	p.subMu.Unlock()
+
+	return p.sendSubscription("pusher:subscribe", channel)
+}
+
+// Unsubscribe unsubscribes from a Pusher channel
+func (p *PusherClient) Unsubscribe(channel string) error {
+	p.subMu.Lock()
+	delete(p.subscriptions, channel)
+	p.subMu.Unlock()
+
+	return p.sendSubscription("pusher:unsubscribe", channel)
+}
+
+// resubscribe restores all subscriptions on a fresh connection
+func (p *PusherClient) resubscribe() error {
+	p.subMu.Lock()
+	channels := make([]string, 0, len(p.subscriptions))
+	for channel := range p.subscriptions {
+		channels = append(channels, channel)
+	}
+	p.subMu.Unlock()
+
+	for _, channel := range channels {
+		if err := p.sendSubscription("pusher:subscribe", channel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendSubscription sends a subscribe or unsubscribe request
+func (p *PusherClient) sendSubscription(event, channel string) error {
+	data, err := json.Marshal(map[string]string{
+		"channel": channel,
+		"auth":    "",
+	})
+	if err != nil {
+		return fmt.Errorf("marshal subscription: %w", err)
+	}
+
+	return p.send(pusherFrame{Event: event, Data: data})
+}
+
+// send writes a frame to the current connection. If there is no connection
+// yet the frame is dropped; subscriptions are replayed once connected.
+func (p *PusherClient) send(frame pusherFrame) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	if p.conn == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	p.rawLog.Sent("pusher", raw)
+	p.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return p.conn.WriteMessage(websocket.TextMessage, raw)
+}
+
+// unwrapData decodes Pusher's double-encoded data field. Most events carry
+// their payload as a JSON string containing JSON; some carry an object directly.
+func unwrapData(raw json.RawMessage) []byte {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s)
+	}
+	return raw
+}
@@ -0,0 +1,242 @@
+package kick
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver looks up chatroom IDs for Kick channel slugs. Requests are
+// spaced at least interval apart plus up to half an interval of random
+// jitter, so resolving many channels doesn't trip Cloudflare, and results
+// are cached on disk so restarts don't repeat them.
+type Resolver struct {
+	interval  time.Duration
+	cachePath string
+
+	cache   map[string]resolvedChannel // Lowercase slug -> channel
+	cacheMu sync.Mutex
+
+	next   time.Time // Earliest time for the next request
+	nextMu sync.Mutex
+
+	fetch      func(ctx context.Context, slug string) (resolvedChannel, error)
+	httpClient *http.Client
+}
+
+// resolvedChannel is a cached resolution
+type resolvedChannel struct {
+	ChatroomID int    `json:"chatroom_id"`
+	Slug       string `json:"slug"`
+	UserID     int    `json:"user_id,omitempty"` // Broadcaster's user ID
+}
+
+// ErrNotFound is returned when Kick has no channel with a slug
+var ErrNotFound = errors.New("channel not found")
+
+// NewResolver creates a resolver making at most one request per interval.
+// If cachePath is not empty, resolved channels are cached in that file.
+func NewResolver(interval time.Duration, cachePath string) *Resolver {
+	r := &Resolver{
+		interval:   interval,
+		cachePath:  cachePath,
+		cache:      make(map[string]resolvedChannel),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	r.fetch = r.fetchChannel
+	r.loadCache()
+	return r
+}
+
+// Resolve returns the chatroom ID and canonical slug of a channel, from
+// the cache if possible
+func (r *Resolver) Resolve(ctx context.Context, slug string) (int, string, error) {
+	key := strings.ToLower(slug)
+
+	r.cacheMu.Lock()
+	cached, ok := r.cache[key]
+	r.cacheMu.Unlock()
+	if ok {
+		return cached.ChatroomID, cached.Slug, nil
+	}
+
+	resolved, err := r.Refresh(ctx, slug)
+	if err != nil {
+		return 0, "", err
+	}
+	return resolved.ChatroomID, resolved.Slug, nil
+}
+
+// Refresh looks a channel up through the API, bypassing and then updating
+// the cache
+func (r *Resolver) Refresh(ctx context.Context, slug string) (resolvedChannel, error) {
+	if err := r.wait(ctx); err != nil {
+		return resolvedChannel{}, err
+	}
+	resolved, err := r.fetch(ctx, slug)
+	if err != nil {
+		return resolvedChannel{}, err
+	}
+	if resolved.ChatroomID == 0 {
+		return resolvedChannel{}, fmt.Errorf("API returned no chatroom")
+	}
+	if resolved.Slug == "" {
+		resolved.Slug = slug
+	}
+
+	r.cacheMu.Lock()
+	r.cache[strings.ToLower(slug)] = resolved
+	r.cacheMu.Unlock()
+	r.saveCache()
+
+	return resolved, nil
+}
+
+// Rename records that a chatroom's channel now goes by newSlug, so lookups
+// of either slug give the new one, including after a restart
+func (r *Resolver) Rename(oldSlug, newSlug string, chatroomID int) {
+	r.cacheMu.Lock()
+	resolved := r.cache[strings.ToLower(oldSlug)]
+	if resolved.ChatroomID != chatroomID {
+		resolved = resolvedChannel{ChatroomID: chatroomID}
+	}
+	resolved.Slug = newSlug
+	r.cache[strings.ToLower(oldSlug)] = resolved
+	r.cache[strings.ToLower(newSlug)] = resolved
+	r.cacheMu.Unlock()
+	r.saveCache()
+}
+
+// cached returns the cached resolution of a slug, if any
+func (r *Resolver) cached(slug string) (resolvedChannel, bool) {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	resolved, ok := r.cache[strings.ToLower(slug)]
+	return resolved, ok
+}
+
+// wait blocks until the next request may be made
+func (r *Resolver) wait(ctx context.Context) error {
+	r.nextMu.Lock()
+	now := time.Now()
+	start := r.next
+	if start.Before(now) {
+		start = now
+	}
+	gap := r.interval
+	if r.interval > 0 {
+		gap += time.Duration(rand.Int63n(int64(r.interval)/2 + 1))
+	}
+	r.next = start.Add(gap)
+	r.nextMu.Unlock()
+
+	select {
+	case <-time.After(time.Until(start)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loadCache reads the cache file, if any
+func (r *Resolver) loadCache() {
+	if r.cachePath == "" {
+		return
+	}
+	data, err := os.ReadFile(r.cachePath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &r.cache)
+	}
+	if err != nil {
+		log.Printf("Warning: Ignoring Kick channel cache %s: %v", r.cachePath, err)
+		r.cache = make(map[string]resolvedChannel)
+		return
+	}
+	log.Printf("Loaded %d cached Kick channel(s) from %s", len(r.cache), r.cachePath)
+}
+
+// saveCache writes the cache file atomically
+func (r *Resolver) saveCache() {
+	if r.cachePath == "" {
+		return
+	}
+
+	r.cacheMu.Lock()
+	data, err := json.MarshalIndent(r.cache, "", "  ")
+	r.cacheMu.Unlock()
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(r.cachePath), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(r.cachePath+".tmp", data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(r.cachePath+".tmp", r.cachePath)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to save Kick channel cache: %v", err)
+	}
+}
+
+// fetchChannel fetches channel information from the Kick API
+func (r *Resolver) fetchChannel(ctx context.Context, channelName string) (resolvedChannel, error) {
+	url := fmt.Sprintf("https://kick.com/api/v2/channels/%s", channelName)
+
+	// Create request with headers to bypass CloudFlare blocking
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return resolvedChannel{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set comprehensive browser headers to appear more legitimate
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/143.0.0.0 Safari/537.36")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	req.Header.Set("Referer", "https://kick.com/")
+	req.Header.Set("Origin", "https://kick.com")
+	req.Header.Set("Sec-Fetch-Dest", "empty")
+	req.Header.Set("Sec-Fetch-Mode", "cors")
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	req.Header.Set("sec-ch-ua", `"Chromium";v="143", "Not.A/Brand";v="24", "Google Chrome";v="143"`)
+	req.Header.Set("sec-ch-ua-mobile", "?0")
+	req.Header.Set("sec-ch-ua-platform", `"Windows"`)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return resolvedChannel{}, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return resolvedChannel{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return resolvedChannel{}, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var channelInfo KickChannelResponse
+	if err := json.NewDecoder(resp.Body).Decode(&channelInfo); err != nil {
+		return resolvedChannel{}, fmt.Errorf("JSON decode failed: %w", err)
+	}
+
+	return resolvedChannel{
+		ChatroomID: channelInfo.Chatroom.ID,
+		Slug:       channelInfo.Slug,
+		UserID:     channelInfo.UserID,
+	}, nil
+}
@@ -0,0 +1,156 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+// Lock is a lease shared by every instance of a deployment
+type Lock interface {
+	// Acquire takes the lease for holder, or renews it if holder already
+	// has it, and reports whether holder now holds it
+	Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// Release gives up the lease if holder has it
+	Release(ctx context.Context, holder string) error
+}
+
+// Elector keeps one instance of a hot-standby deployment recording. Every
+// instance connects to chat, but only the lease holder passes messages on;
+// standbys drop them until the leader's lease lapses, so failover loses at
+// most one lease period of chat.
+type Elector struct {
+	lock   Lock
+	id     string
+	ttl    time.Duration
+	leader atomic.Bool
+
+	status *status.Component
+}
+
+// New creates an elector competing for lock as instance id with leases of
+// ttl, renewed every third of ttl
+func New(lock Lock, id string, ttl time.Duration) *Elector {
+	return &Elector{lock: lock, id: id, ttl: ttl}
+}
+
+// InstanceID returns a name for this instance: the Fly.io machine ID if
+// available, otherwise the hostname and process ID
+func InstanceID() string {
+	if id := os.Getenv("FLY_MACHINE_ID"); id != "" {
+		if region := os.Getenv("FLY_REGION"); region != "" {
+			return region + "/" + id
+		}
+		return id
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+// EnableStatus reports whether this instance is leading to comp. It must be
+// called before Run.
+func (e *Elector) EnableStatus(comp *status.Component) {
+	e.status = comp
+	e.status.Set("instance", e.id)
+}
+
+// IsLeader reports whether this instance currently holds the lease
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run acquires and renews the lease until the context is cancelled, then
+// releases it so a standby can take over without waiting for it to expire.
+// Messages already received keep flowing so the pipeline can drain.
+func (e *Elector) Run(ctx context.Context) error {
+	e.status.SetState(status.StateStandby)
+	defer e.status.SetState(status.StateStopped)
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	log.Printf("Competing for leader lease as %s", e.id)
+
+	var renewedAt time.Time
+	for {
+		acquireCtx, cancel := context.WithTimeout(ctx, e.ttl/3)
+		ok, err := e.lock.Acquire(acquireCtx, e.id, e.ttl)
+		cancel()
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("Warning: Leader lease check failed: %v", err)
+			e.status.Set("last_error", err.Error())
+			// Keep leading only while the last renewal is still valid
+			if e.IsLeader() && time.Since(renewedAt) >= e.ttl {
+				e.setLeader(false)
+			}
+		case ok:
+			renewedAt = time.Now()
+			e.setLeader(true)
+		case err == nil:
+			e.setLeader(false)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if e.IsLeader() {
+				releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := e.lock.Release(releaseCtx, e.id); err != nil {
+					log.Printf("Warning: Failed to release leader lease: %v", err)
+				} else {
+					log.Println("Released leader lease")
+				}
+				cancel()
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// setLeader records a change of leadership
+func (e *Elector) setLeader(leader bool) {
+	if e.leader.Swap(leader) == leader {
+		return
+	}
+	if leader {
+		log.Printf("Acquired leader lease as %s, recording", e.id)
+		e.status.SetState(status.StateRunning)
+	} else {
+		log.Printf("Not the leader (%s), standing by", e.id)
+		e.status.SetState(status.StateStandby)
+	}
+}
+
+// Filter forwards messages from in to out while this instance is the
+// leader and drops them otherwise. It closes out when in is closed.
+func (e *Elector) Filter(ctx context.Context, in <-chan message.Message, out chan<- message.Message) error {
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				close(out)
+				return nil
+			}
+			if !e.IsLeader() {
+				continue
+			}
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
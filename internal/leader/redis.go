@@ -0,0 +1,61 @@
+package leader
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisLock is a lease stored in a Redis key holding the holder's ID
+type RedisLock struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisLock creates a lease stored under key
+func NewRedisLock(addr, password string, db int, key string) *RedisLock {
+	return &RedisLock{
+		client: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: password,
+			DB:       db,
+		}),
+		key: key,
+	}
+}
+
+// acquireScript sets the key if it is free, or extends it if the caller
+// already holds it
+var acquireScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current == false then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+if current == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// releaseScript deletes the key only if the caller holds it
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (l *RedisLock) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	n, err := acquireScript.Run(ctx, l.client, []string{l.key}, holder, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (l *RedisLock) Release(ctx context.Context, holder string) error {
+	return releaseScript.Run(ctx, l.client, []string{l.key}, holder).Err()
+}
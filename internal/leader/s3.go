@@ -0,0 +1,125 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// S3Lock is a lease stored as a small JSON object in S3. Writes are
+// conditional on the object's ETag, so two instances can't both take an
+// expired lease. Expiry is judged by each instance's clock, so hosts need
+// reasonably synchronized time.
+type S3Lock struct {
+	client *s3.Client
+	bucket string
+	key    string
+}
+
+// lease is the content of the lease object
+type lease struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewS3Lock creates a lease stored at key in bucket
+func NewS3Lock(client *s3.Client, bucket, key string) *S3Lock {
+	return &S3Lock{client: client, bucket: bucket, key: key}
+}
+
+func (l *S3Lock) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	current, etag, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	if current != nil && current.Holder != holder && time.Now().Before(current.ExpiresAt) {
+		return false, nil
+	}
+
+	err = l.write(ctx, lease{Holder: holder, ExpiresAt: time.Now().Add(ttl).UTC()}, etag)
+	if code := apiErrorCode(err); code == "PreconditionFailed" || code == "ConditionalRequestConflict" {
+		return false, nil // Another instance wrote the lease first
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *S3Lock) Release(ctx context.Context, holder string) error {
+	current, etag, err := l.read(ctx)
+	if err != nil || current == nil || current.Holder != holder {
+		return err
+	}
+	// Expire the lease rather than deleting it, keeping the write conditional
+	err = l.write(ctx, lease{Holder: holder, ExpiresAt: time.Now().UTC()}, etag)
+	if code := apiErrorCode(err); code == "PreconditionFailed" || code == "ConditionalRequestConflict" {
+		return nil
+	}
+	return err
+}
+
+// read returns the current lease and its ETag, or nil if there is none
+func (l *S3Lock) read(ctx context.Context) (*lease, string, error) {
+	out, err := l.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(l.bucket),
+		Key:    aws.String(l.key),
+	})
+	if code := apiErrorCode(err); code == "NoSuchKey" || code == "NotFound" {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("read lease: %w", err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("read lease: %w", err)
+	}
+	var current lease
+	if err := json.Unmarshal(data, &current); err != nil {
+		// Unreadable lease; treat it as expired so it gets replaced
+		return &lease{}, aws.ToString(out.ETag), nil
+	}
+	return &current, aws.ToString(out.ETag), nil
+}
+
+// write stores a lease, only if the object still has etag, or doesn't
+// exist yet if etag is empty
+func (l *S3Lock) write(ctx context.Context, next lease, etag string) error {
+	data, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(l.bucket),
+		Key:         aws.String(l.key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+	if etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(etag)
+	}
+	_, err = l.client.PutObject(ctx, input)
+	return err
+}
+
+// apiErrorCode returns the S3 error code of err, or "" if it has none
+func apiErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
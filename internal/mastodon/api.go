@@ -0,0 +1,102 @@
+package mastodon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// post is a Mastodon status as returned by the REST and streaming APIs
+type post struct {
+	ID                 string    `json:"id"`
+	CreatedAt          time.Time `json:"created_at"`
+	Content            string    `json:"content"` // HTML
+	SpoilerText        string    `json:"spoiler_text"`
+	URL                string    `json:"url"`
+	InReplyToID        string    `json:"in_reply_to_id"`
+	InReplyToAccountID string    `json:"in_reply_to_account_id"`
+	Reblog             *post     `json:"reblog"`
+	Account            account   `json:"account"`
+	Tags               []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+}
+
+// account is the author of a post
+type account struct {
+	ID          string `json:"id"`
+	Acct        string `json:"acct"` // "user" for local accounts, "user@host" for remote ones
+	DisplayName string `json:"display_name"`
+	URL         string `json:"url"`
+}
+
+// getJSON performs an authenticated GET against the server's REST API
+func (c *Connector) getJSON(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.server+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("JSON decode failed: %w", err)
+	}
+	return nil
+}
+
+// streamingURL returns the server's WebSocket streaming endpoint, which
+// some servers host on a separate domain
+func (c *Connector) streamingURL(ctx context.Context) string {
+	var instance struct {
+		URLs struct {
+			StreamingAPI string `json:"streaming_api"`
+		} `json:"urls"`
+	}
+	base := c.server
+	if err := c.getJSON(ctx, "/api/v1/instance", &instance); err == nil && instance.URLs.StreamingAPI != "" {
+		base = instance.URLs.StreamingAPI
+	}
+	base = strings.Replace(base, "https://", "wss://", 1)
+	base = strings.Replace(base, "http://", "ws://", 1)
+	return strings.TrimSuffix(base, "/") + "/api/v1/streaming"
+}
+
+var (
+	// lineBreakRe matches the HTML that separates lines of a post
+	lineBreakRe = regexp.MustCompile(`(?i)<br\s*/?>|</p>\s*<p>`)
+	// tagRe matches any other HTML tag
+	tagRe = regexp.MustCompile(`<[^>]*>`)
+)
+
+// plainText converts a post's HTML content to plain text
+func plainText(content string) string {
+	text := lineBreakRe.ReplaceAllString(content, "\n")
+	text = tagRe.ReplaceAllString(text, "")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+// newerID reports whether post ID a is newer than b. IDs are decimal
+// snowflakes, so longer is newer and equal lengths compare as strings.
+func newerID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
@@ -0,0 +1,372 @@
+package mastodon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/john/chatlog/internal/egress"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+const (
+	maxReconnectBackoff = 60 * time.Second
+
+	// pageLimit is the most posts fetched per REST request
+	pageLimit = 40
+
+	// readTimeout drops a stream that has gone quiet; servers ping every
+	// few seconds
+	readTimeout = 2 * time.Minute
+
+	// maxSeen is how many recent post IDs are remembered per channel, to
+	// skip posts both streamed and fetched by a backfill or poll
+	maxSeen = 1000
+)
+
+// streamEvent is a message on the streaming WebSocket
+type streamEvent struct {
+	Stream  []string `json:"stream"`  // e.g. ["hashtag", "foo"]
+	Event   string   `json:"event"`   // "update" for a new post
+	Payload string   `json:"payload"` // The post as JSON, for updates
+}
+
+// Connector records posts from a Mastodon server: hashtag timelines over
+// the streaming API, and accounts' posts by polling, since the streaming
+// API has no per-account stream
+type Connector struct {
+	server       string // https://host
+	host         string
+	accessToken  string
+	hashtags     []string // Lowercase, without '#'
+	accounts     []string
+	pollInterval time.Duration
+
+	startedAt time.Time
+	tracked   map[string]string   // Account ID -> channel
+	lastID    map[string]string   // Channel -> newest post ID recorded, to fetch only newer ones
+	seen      map[string]*seenSet // Channel -> recently recorded post IDs
+	mu        sync.Mutex
+
+	httpClient *http.Client
+	dialer     *websocket.Dialer
+	status     *status.Component
+}
+
+// New creates a connector for the server at serverURL. Posts tagged with
+// hashtags are recorded under the tag; posts by accounts ("user" on the
+// server or "user@host") under the account's full address. An access token
+// is needed by servers that restrict their streaming API.
+func New(serverURL, accessToken string, hashtags, accounts []string, pollInterval time.Duration) (*Connector, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid Mastodon server URL %q", serverURL)
+	}
+
+	tags := make([]string, 0, len(hashtags))
+	for _, tag := range hashtags {
+		tags = append(tags, strings.ToLower(strings.TrimPrefix(tag, "#")))
+	}
+
+	return &Connector{
+		server:       u.Scheme + "://" + u.Host,
+		host:         u.Host,
+		accessToken:  accessToken,
+		hashtags:     tags,
+		accounts:     accounts,
+		pollInterval: pollInterval,
+		tracked:      make(map[string]string),
+		lastID:       make(map[string]string),
+		seen:         make(map[string]*seenSet),
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		dialer:       websocket.DefaultDialer,
+	}, nil
+}
+
+// EnableStatus reports connection state and message times to comp. It must
+// be called before Start.
+func (c *Connector) EnableStatus(comp *status.Component) {
+	c.status = comp
+}
+
+// EnableEgress streams and polls the server through network. It must be
+// called before Start.
+func (c *Connector) EnableEgress(network *egress.Network) {
+	c.httpClient = network.HTTPClient(10 * time.Second)
+	c.dialer = network.WebSocketDialer()
+}
+
+// Start records posts until the context is cancelled. Only posts made after
+// it starts are recorded.
+func (c *Connector) Start(ctx context.Context, messageChan chan<- message.Message) error {
+	c.startedAt = time.Now()
+
+	for _, acct := range c.accounts {
+		var a account
+		acct = strings.TrimPrefix(acct, "@")
+		if err := c.getJSON(ctx, "/api/v1/accounts/lookup?"+url.Values{"acct": {acct}}.Encode(), &a); err != nil {
+			log.Printf("Warning: Failed to look up Mastodon account '%s': %v (skipping)", acct, err)
+			continue
+		}
+		c.tracked[a.ID] = c.fullAcct(a.Acct)
+		log.Printf("Resolved Mastodon account: %s -> %s", acct, a.ID)
+	}
+
+	if len(c.hashtags) == 0 && len(c.tracked) == 0 {
+		return fmt.Errorf("no Mastodon hashtags or accounts to follow")
+	}
+
+	if len(c.hashtags) == 0 {
+		c.status.SetState(status.StateConnected)
+		c.pollAccounts(ctx, messageChan)
+		return ctx.Err()
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	if len(c.tracked) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.pollAccounts(ctx, messageChan)
+		}()
+	}
+
+	backoff := time.Second
+	for {
+		connectedAt := time.Now()
+		err := c.runStream(ctx, messageChan)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if time.Since(connectedAt) > maxReconnectBackoff {
+			backoff = time.Second
+		}
+
+		log.Printf("Mastodon streaming connection lost: %v. Reconnecting in %v", err, backoff)
+		c.status.SetState(status.StateReconnecting)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// runStream handles a single streaming connection, subscribed to every
+// hashtag
+func (c *Connector) runStream(ctx context.Context, messageChan chan<- message.Message) error {
+	header := http.Header{}
+	if c.accessToken != "" {
+		header.Set("Authorization", "Bearer "+c.accessToken)
+	}
+	conn, _, err := c.dialer.DialContext(ctx, c.streamingURL(ctx), header)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	for _, tag := range c.hashtags {
+		if err := conn.WriteJSON(map[string]string{"type": "subscribe", "stream": "hashtag", "tag": tag}); err != nil {
+			return fmt.Errorf("subscribe to #%s: %w", tag, err)
+		}
+	}
+	log.Printf("Connected to Mastodon streaming API on %s", c.host)
+	c.status.SetState(status.StateConnected)
+
+	// Posts made while disconnected are fetched once subscribed, so none
+	// fall between the two
+	c.backfillHashtags(ctx, messageChan)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		var event streamEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+		if event.Event != "update" || len(event.Stream) < 2 || event.Stream[0] != "hashtag" {
+			continue
+		}
+
+		var p post
+		if err := json.Unmarshal([]byte(event.Payload), &p); err != nil {
+			continue
+		}
+		tag := strings.ToLower(event.Stream[1])
+		if !slices.Contains(c.hashtags, tag) {
+			continue
+		}
+		if err := c.record(ctx, messageChan, tag, p); err != nil {
+			return err
+		}
+	}
+}
+
+// backfillHashtags records posts made since the last one recorded for each
+// hashtag, or since the connector started, up to one page each
+func (c *Connector) backfillHashtags(ctx context.Context, messageChan chan<- message.Message) {
+	for _, tag := range c.hashtags {
+		c.mu.Lock()
+		since := c.lastID[tag]
+		c.mu.Unlock()
+
+		var posts []post
+		query := url.Values{"limit": {fmt.Sprint(pageLimit)}}
+		if since != "" {
+			query.Set("since_id", since)
+		}
+		if err := c.getJSON(ctx, "/api/v1/timelines/tag/"+url.PathEscape(tag)+"?"+query.Encode(), &posts); err != nil {
+			log.Printf("Warning: Failed to backfill Mastodon #%s: %v", tag, err)
+			continue
+		}
+		c.recordPage(ctx, messageChan, tag, posts)
+	}
+}
+
+// pollAccounts records tracked accounts' new posts every poll interval
+func (c *Connector) pollAccounts(ctx context.Context, messageChan chan<- message.Message) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for id, channel := range c.tracked {
+			c.mu.Lock()
+			since := c.lastID[channel]
+			c.mu.Unlock()
+
+			query := url.Values{"limit": {fmt.Sprint(pageLimit)}, "exclude_reblogs": {"true"}}
+			if since != "" {
+				query.Set("since_id", since)
+			}
+			var posts []post
+			if err := c.getJSON(ctx, "/api/v1/accounts/"+url.PathEscape(id)+"/statuses?"+query.Encode(), &posts); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Warning: Failed to poll Mastodon account %s: %v", channel, err)
+				}
+				continue
+			}
+			c.recordPage(ctx, messageChan, channel, posts)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordPage records a REST page of posts, which lists the newest first
+func (c *Connector) recordPage(ctx context.Context, messageChan chan<- message.Message, channel string, posts []post) {
+	for i := len(posts) - 1; i >= 0; i-- {
+		if c.record(ctx, messageChan, channel, posts[i]) != nil {
+			return
+		}
+	}
+}
+
+// record sends a post as a message unless it is a boost, predates the
+// connector, or was recently recorded for the channel. Posts federated from
+// other servers can arrive after newer ones, so they are matched by ID
+// rather than skipped for being older than the last.
+func (c *Connector) record(ctx context.Context, messageChan chan<- message.Message, channel string, p post) error {
+	if p.Reblog != nil || p.CreatedAt.Before(c.startedAt) {
+		return nil
+	}
+	c.mu.Lock()
+	seen, ok := c.seen[channel]
+	if !ok {
+		seen = newSeenSet(maxSeen)
+		c.seen[channel] = seen
+	}
+	if !seen.add(p.ID) {
+		c.mu.Unlock()
+		return nil
+	}
+	if last := c.lastID[channel]; last == "" || newerID(p.ID, last) {
+		c.lastID[channel] = p.ID
+	}
+	c.mu.Unlock()
+
+	chatMessage := message.New("mastodon", p.CreatedAt)
+	chatMessage.Channel = channel
+	chatMessage.ID = p.ID
+	chatMessage.Username = c.fullAcct(p.Account.Acct)
+	chatMessage.UserID = p.Account.ID
+	chatMessage.Message = plainText(p.Content)
+	if p.SpoilerText != "" {
+		// Keep the content warning with the text it hides
+		chatMessage.Message = p.SpoilerText + "\n\n" + chatMessage.Message
+	}
+	if p.InReplyToID != "" {
+		chatMessage.Reply = &message.Reply{ParentID: p.InReplyToID, ParentUserID: p.InReplyToAccountID}
+	}
+	c.status.MessageReceived()
+
+	select {
+	case messageChan <- chatMessage:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fullAcct qualifies a local account's acct with the server's host
+func (c *Connector) fullAcct(acct string) string {
+	if acct == "" || strings.Contains(acct, "@") {
+		return strings.ToLower(acct)
+	}
+	return strings.ToLower(acct + "@" + c.host)
+}
+
+// seenSet remembers the most recent IDs added to it, forgetting the oldest
+// once full
+type seenSet struct {
+	ids  map[string]bool
+	ring []string
+	next int
+}
+
+func newSeenSet(size int) *seenSet {
+	return &seenSet{ids: make(map[string]bool, size), ring: make([]string, size)}
+}
+
+// add adds id, reporting false if it is already in the set
+func (s *seenSet) add(id string) bool {
+	if s.ids[id] {
+		return false
+	}
+	if old := s.ring[s.next]; old != "" {
+		delete(s.ids, old)
+	}
+	s.ring[s.next] = id
+	s.next = (s.next + 1) % len(s.ring)
+	s.ids[id] = true
+	return true
+}
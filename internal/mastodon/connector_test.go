@@ -0,0 +1,56 @@
+package mastodon
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+func TestRecordDedupesByID(t *testing.T) {
+	c, err := New("https://example.social", "", []string{"news"}, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.startedAt = time.Now().Add(-time.Hour)
+
+	messages := make(chan message.Message, 10)
+	now := time.Now()
+	for _, id := range []string{"200", "100", "200", "300", "100"} {
+		p := post{ID: id, CreatedAt: now}
+		p.Account.Acct = "someone"
+		if err := c.record(context.Background(), messages, "news", p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(messages)
+
+	var got []string
+	for msg := range messages {
+		got = append(got, msg.ID)
+	}
+	// A federated post older than the last one recorded is kept
+	if fmt.Sprint(got) != "[200 100 300]" {
+		t.Errorf("recorded %v, want [200 100 300]", got)
+	}
+	if c.lastID["news"] != "300" {
+		t.Errorf("lastID = %q, want the newest, 300", c.lastID["news"])
+	}
+}
+
+func TestSeenSetForgetsOldest(t *testing.T) {
+	s := newSeenSet(2)
+	for _, id := range []string{"a", "b", "c"} {
+		if !s.add(id) {
+			t.Errorf("add(%q) = false for a new ID", id)
+		}
+	}
+	if s.add("c") || s.add("b") {
+		t.Error("recent IDs were added again")
+	}
+	if !s.add("a") {
+		t.Error("the oldest ID was not forgotten")
+	}
+}
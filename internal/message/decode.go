@@ -0,0 +1,77 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// maxNameLength caps a platform or channel name, well within file name
+// limits once the rest of a log file's name is added
+const maxNameLength = 128
+
+// CheckName reports whether name is safe as a platform or channel name,
+// which become directory, file and object key names. Only letters, digits
+// and _ . @ + - are allowed, and never "." or "..", so a name can't leave
+// the output directory or bucket prefix.
+func CheckName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name is empty")
+	}
+	if len(name) > maxNameLength {
+		return fmt.Errorf("name is longer than %d bytes", maxNameLength)
+	}
+	if name == "." || strings.Contains(name, "..") {
+		return fmt.Errorf("name %q is a relative path", name)
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_.@+-", r) {
+			return fmt.Errorf("name %q contains %q", name, r)
+		}
+	}
+	return nil
+}
+
+// Decode parses a JSON record received from outside the process, from an
+// agent, plugin or scraper. The platform defaults to defaultPlatform, and
+// the platform and channel must be present and pass CheckName.
+func Decode(line []byte, defaultPlatform string) (Message, error) {
+	var msg Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return msg, err
+	}
+	if msg.Platform == "" {
+		msg.Platform = defaultPlatform
+	}
+	if msg.Platform == "" || msg.Channel == "" {
+		return msg, fmt.Errorf("platform and channel are required")
+	}
+	if err := CheckName(msg.Platform); err != nil {
+		return msg, fmt.Errorf("platform: %w", err)
+	}
+	if err := CheckName(msg.Channel); err != nil {
+		return msg, fmt.Errorf("channel: %w", err)
+	}
+	return msg, nil
+}
+
+// ScanLines calls fn with each non-blank line of r, up to maxBytes long,
+// and its line number, stopping early when fn returns false. The line is
+// only valid until fn returns.
+func ScanLines(r io.Reader, maxBytes int, fn func(line int, data []byte) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxBytes)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if !fn(line, scanner.Bytes()) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
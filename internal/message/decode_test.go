@@ -0,0 +1,47 @@
+package message
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckName(t *testing.T) {
+	valid := []string{"twitch", "some_channel", "libera.chat-rust", "user@mastodon.social", "dQw4w9WgXcQ", "c++", "канал"}
+	for _, name := range valid {
+		if err := CheckName(name); err != nil {
+			t.Errorf("CheckName(%q) = %v", name, err)
+		}
+	}
+
+	invalid := []string{"", ".", "..", "../etc", "a/b", `a\b`, "a..b", "with space", "nul\x00", "tab\t", strings.Repeat("a", maxNameLength+1)}
+	for _, name := range invalid {
+		if err := CheckName(name); err == nil {
+			t.Errorf("CheckName(%q) accepted it", name)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	msg, err := Decode([]byte(`{"platform":"twitch","channel":"chan","message":"hi"}`), "")
+	if err != nil || msg.Platform != "twitch" || msg.Channel != "chan" {
+		t.Errorf("Decode = %+v, %v", msg, err)
+	}
+
+	msg, err = Decode([]byte(`{"channel":"chan"}`), "plugin")
+	if err != nil || msg.Platform != "plugin" {
+		t.Errorf("default platform: %+v, %v", msg, err)
+	}
+
+	for _, line := range []string{
+		`{"channel":"chan"}`,
+		`{"platform":"twitch"}`,
+		`{"platform":"twitch","channel":"../../etc"}`,
+		`{"platform":"../x","channel":"chan"}`,
+		`{"platform":"twitch","channel":"a/b"}`,
+		`not json`,
+	} {
+		if _, err := Decode([]byte(line), ""); err == nil {
+			t.Errorf("Decode(%s) accepted it", line)
+		}
+	}
+}
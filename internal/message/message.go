@@ -1,12 +1,300 @@
 package message
 
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TimestampFormat is RFC3339 with fixed millisecond precision, so timestamps
+// sort correctly as strings
+const TimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
 // Message represents a chat message from any platform (Twitch, Kick, etc.)
 type Message struct {
-	Platform  string `json:"platform"`           // Platform name: "twitch", "kick", etc.
-	Timestamp string `json:"timestamp"`          // Message timestamp in RFC3339 format (UTC)
-	Channel   string `json:"channel"`            // Channel name or slug
-	Username  string `json:"username"`           // User's display name
-	UserID    string `json:"user_id"`            // Platform-specific user ID
-	Message   string `json:"message"`            // Chat message content
-	Badges    string `json:"badges,omitempty"`   // Comma-separated list of badges
+	Platform   string   `json:"platform"`             // Platform name: "twitch", "kick", etc.
+	Timestamp  string   `json:"timestamp"`            // Platform-reported send time in TimestampFormat (UTC)
+	ReceivedAt string   `json:"received_at"`          // Local receive time in TimestampFormat (UTC), monotonic-backed
+	Sequence   uint64   `json:"seq"`                  // Process-wide receive order, for deterministic sorting
+	ID         string   `json:"id,omitempty"`         // Platform message ID, when the platform assigns one
+	Channel    string   `json:"channel"`              // Channel name or slug
+	ChannelID  string   `json:"channel_id,omitempty"` // Platform ID of the channel that survives renames (Kick chatroom ID)
+	Username   string   `json:"username"`             // User's display name
+	UserID     string   `json:"user_id"`              // Platform-specific user ID
+	Message    string   `json:"message"`              // Chat message content
+	Badges     string   `json:"badges,omitempty"`     // Comma-separated list of badges
+	Emotes     []string `json:"emotes,omitempty"`     // Emote names used in the message, in order
+
+	// Emote IDs and positions, when the platform reports them. Kick
+	// positions cover the [emote:ID:name] markup left in Message.
+	EmoteRefs []EmoteRef `json:"emote_refs,omitempty"`
+
+	// The platform-reported send time, set when it was too far from the
+	// receive time to trust and Timestamp holds the receive time instead
+	PlatformTimestamp string `json:"platform_timestamp,omitempty"`
+
+	// The channel's current name, set when it was renamed since it was
+	// configured (Kick); Channel stays the configured name
+	CurrentChannel string `json:"current_channel,omitempty"`
+
+	// Set when the message was relayed from another channel (Twitch Shared
+	// Chat); Channel is still the channel it was received in
+	SourceRoomID  string `json:"source_room_id,omitempty"` // Platform ID of the originating channel
+	SourceChannel string `json:"source_channel,omitempty"` // Name of the originating channel, if known
+
+	// Set when the message replies to another (Twitch and Kick replies)
+	Reply *Reply `json:"reply,omitempty"`
+
+	// Fields added by enrichment processors, keyed by field name
+	Enrichment map[string]any `json:"enrichment,omitempty"`
+
+	// Names of keyword alert rules the message matched, for rules that tag
+	Alerts []string `json:"alerts,omitempty"`
+
+	// Type distinguishes records that are not chat messages; empty for chat
+	Type      string     `json:"type,omitempty"`
+	Aggregate *Aggregate `json:"aggregate,omitempty"`  // Set when Type is TypeAggregate
+	Stream    *Stream    `json:"stream,omitempty"`     // Set when Type is TypeStream
+	Chatters  *Chatters  `json:"chatters,omitempty"`   // Set when Type is TypeChatters
+	Summary   *Summary   `json:"summary,omitempty"`    // Set when Type is TypeSummary
+	Highlight *Highlight `json:"highlight,omitempty"`  // Set when Type is TypeHighlight
+	Paid      *Paid      `json:"paid,omitempty"`       // Set for paid events, such as TypeSuperChat, and chat with Bits
+	RoomState *RoomState `json:"room_state,omitempty"` // Set when Type is TypeRoomState
+	Notice    *Notice    `json:"notice,omitempty"`     // Set for notices and redemptions, such as TypeAnnouncement
+	Header    *Header    `json:"header,omitempty"`     // Set when Type is TypeHeader
+}
+
+// Record types
+const (
+	TypeAggregate = "aggregate"  // Summary of a window of messages that were not all recorded
+	TypeStream    = "stream"     // Snapshot of a channel's stream: viewers, title, category
+	TypeChatters  = "chatters"   // Snapshot of who is in a channel's chat, lurkers included
+	TypeSummary   = "summary"    // Chatter statistics for the file it closes
+	TypeHighlight = "highlight"  // A spike in chat activity
+	TypeRoomState = "room_state" // A channel's chat modes, on joining and when they change
+	TypeHeader    = "header"     // First record of a file, describing it
+
+	// Notices; Message holds the user's text, if any
+	TypeAnnouncement = "announcement" // Message a moderator posted with /announce
+	TypeUserNotice   = "user_notice"  // Event Twitch announces in chat, such as a sub or raid
+	TypeNotice       = "notice"       // Message from Twitch about the channel, such as a ban notice
+	TypeRedemption   = "redemption"   // Chat message sent with a channel point reward
+
+	// Paid events; Message holds the user's comment, if any
+	TypeSuperChat    = "super_chat"           // Paid highlighted message
+	TypeSuperSticker = "super_sticker"        // Paid sticker
+	TypeMembership   = "membership"           // New or upgraded channel membership
+	TypeMilestone    = "membership_milestone" // Membership anniversary shared in chat
+	TypeMemberGift   = "membership_gift"      // Memberships gifted to other viewers
+	TypeGiftReceived = "membership_gift_received"
+)
+
+// Aggregate summarizes a window of a channel's messages recorded in sampled
+// or aggregated mode
+type Aggregate struct {
+	WindowSeconds int          `json:"window_seconds"`
+	Messages      int          `json:"messages"` // Messages received in the window
+	Recorded      int          `json:"recorded"` // Messages recorded in full
+	Users         int          `json:"users"`    // Distinct authors
+	TopEmotes     []EmoteCount `json:"top_emotes,omitempty"`
+}
+
+// Stream is a point-in-time snapshot of a channel's broadcast
+type Stream struct {
+	Live        bool       `json:"live"`
+	ViewerCount int        `json:"viewer_count,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Category    string     `json:"category,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+}
+
+// Chatters lists the users connected to a channel's chat at one moment,
+// whether or not they talk, so lurkers can be told from chatters
+type Chatters struct {
+	Total     int       `json:"total"`               // Users in chat, as the platform counts them
+	Users     []Chatter `json:"users,omitempty"`     // Up to the configured maximum
+	Truncated bool      `json:"truncated,omitempty"` // Users stops short of everyone in chat
+}
+
+// Chatter is one user in a Chatters snapshot
+type Chatter struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// Summary describes who chatted in a file. It is the last record of the
+// file and covers the chat messages before it.
+type Summary struct {
+	Start          string         `json:"start"` // File start in TimestampFormat
+	End            string         `json:"end"`   // File end in TimestampFormat
+	Messages       int            `json:"messages"`
+	UniqueChatters int            `json:"unique_chatters"`
+	NewChatters    int            `json:"new_chatters"`   // Chatters seen in the channel for the first time
+	KnownChatters  int            `json:"known_chatters"` // Chatters ever seen in the channel, including these
+	TopChatters    []ChatterCount `json:"top_chatters,omitempty"`
+}
+
+// Highlight describes a spike in a channel's chat rate, a likely clip-worthy
+// moment. The record's timestamp is the spike's start.
+type Highlight struct {
+	Start        string      `json:"start"` // In TimestampFormat
+	End          string      `json:"end"`
+	Messages     int         `json:"messages"`      // Chat messages during the spike
+	Users        int         `json:"users"`         // Distinct authors
+	PeakRate     float64     `json:"peak_rate"`     // Highest messages per second over the detection window
+	BaselineRate float64     `json:"baseline_rate"` // The channel's usual messages per second before it
+	ZScore       float64     `json:"z_score"`       // Peak standard deviations above the baseline
+	TopTerms     []TermCount `json:"top_terms,omitempty"`
+}
+
+// RoomState holds a channel's chat modes. On joining every mode is set;
+// after that only those that changed are. Message holds the announcement
+// when the change came from a NOTICE.
+type RoomState struct {
+	EmoteOnly     *bool  `json:"emote_only,omitempty"`
+	SubsOnly      *bool  `json:"subs_only,omitempty"`
+	UniqueChat    *bool  `json:"unique_chat,omitempty"`            // R9K: repeated messages are rejected
+	SlowSeconds   *int   `json:"slow_seconds,omitempty"`           // Seconds between a user's messages; 0 is off
+	FollowersOnly *int   `json:"followers_only_minutes,omitempty"` // Minutes following required; -1 is off, 0 any follower
+	Notice        string `json:"notice,omitempty"`                 // NOTICE msg-id, e.g. slow_on
+}
+
+// SchemaVersion is the version of the record format, written in file
+// headers. It is raised when a change would break existing readers.
+const SchemaVersion = 1
+
+// Header describes the file it starts. The record's platform, channel and
+// timestamp are the file's, the timestamp being when it was created.
+type Header struct {
+	Schema  int    `json:"schema"`  // SchemaVersion of the records that follow
+	Version string `json:"version"` // Version of chatlog that wrote the file
+}
+
+// Notice holds the tags of a platform notice or of a chat message sent
+// with a channel point reward
+type Notice struct {
+	MsgID         string            `json:"msg_id,omitempty"`         // e.g. announcement, resub, highlighted-message
+	SystemMessage string            `json:"system_message,omitempty"` // Text the platform shows for the event
+	RewardID      string            `json:"reward_id,omitempty"`      // Custom channel point reward
+	Params        map[string]string `json:"params,omitempty"`         // Event details, e.g. color of an announcement
+}
+
+// TermCount is a word or emote and how many messages used it
+type TermCount struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// ChatterCount is a user and how many messages they sent
+type ChatterCount struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Count    int    `json:"count"`
+}
+
+// Paid holds the monetary details of a paid event. Amounts are in
+// millionths of the currency unit, so they add up exactly.
+type Paid struct {
+	AmountMicros int64       `json:"amount_micros,omitempty"`
+	Currency     string      `json:"currency,omitempty"`       // ISO 4217 code
+	Display      string      `json:"amount_display,omitempty"` // Amount as shown to viewers, e.g. "$5.00"
+	Tier         int         `json:"tier,omitempty"`           // Platform tier, e.g. Super Chat color tier
+	Bits         int         `json:"bits,omitempty"`           // Twitch Bits cheered in a chat message
+	Cheermotes   []Cheermote `json:"cheermotes,omitempty"`     // The Bits broken down by cheermote, in order of use
+	Pinned       bool        `json:"pinned,omitempty"`         // Twitch Hype Chat: paid to pin the chat message
+	StickerID    string      `json:"sticker_id,omitempty"`
+	StickerAlt   string      `json:"sticker_alt,omitempty"`
+	Level        string      `json:"level,omitempty"`  // Membership level name
+	Months       int         `json:"months,omitempty"` // Membership milestone months
+	Upgrade      bool        `json:"upgrade,omitempty"`
+	Gifts        int         `json:"gifts,omitempty"`     // Memberships gifted
+	GifterID     string      `json:"gifter_id,omitempty"` // Who gifted a received membership
+}
+
+// Cheermote is one cheermote in a message, e.g. "Cheer100"
+type Cheermote struct {
+	Prefix string `json:"prefix"`
+	Bits   int    `json:"bits"`
+}
+
+// Reply identifies the message a reply answers, so threads can be rebuilt
+// without the parent being in the same file
+type Reply struct {
+	ParentID       string `json:"parent_id"`                 // Platform message ID of the parent
+	ParentUserID   string `json:"parent_user_id,omitempty"`  // Platform user ID of the parent's author
+	ParentUsername string `json:"parent_username,omitempty"` // Display name of the parent's author
+	ParentText     string `json:"parent_text,omitempty"`     // Parent message text as quoted by the platform
+	ThreadID       string `json:"thread_id,omitempty"`       // ID of the thread's first message, when reported
+}
+
+// EmoteRef is one use of an emote in a message
+type EmoteRef struct {
+	ID    string `json:"id"`    // Platform emote ID
+	Name  string `json:"name"`  // Emote code as typed
+	Start int    `json:"start"` // Offset of the first rune in Message
+	End   int    `json:"end"`   // Offset after the last rune
+}
+
+// EmoteCount is an emote and how often it was used
+type EmoteCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Enrich sets an enrichment field on the message
+func (m *Message) Enrich(key string, value any) {
+	if m.Enrichment == nil {
+		m.Enrichment = make(map[string]any)
+	}
+	m.Enrichment[key] = value
+}
+
+var (
+	// clockBase anchors receive times; elapsed time is measured with the
+	// monotonic clock so wall clock adjustments can't reorder messages
+	clockBase = time.Now()
+	sequence  atomic.Uint64
+)
+
+// ReceiveTime returns the current time derived from the monotonic clock
+func ReceiveTime() time.Time {
+	return clockBase.Add(time.Since(clockBase)).UTC()
+}
+
+// FormatTime formats t in TimestampFormat (UTC)
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimestampFormat)
+}
+
+// New creates a message stamped with its receive time and sequence number.
+// If the platform did not report a send time, the receive time is used.
+func New(platform string, platformTime time.Time) Message {
+	return stamp(platform, platformTime, ReceiveTime())
+}
+
+// NewWithinSkew is New, except that a platform time more than maxSkew from
+// the receive time is kept in PlatformTimestamp and the receive time used
+// as Timestamp, so a skewed clock doesn't misplace the message when
+// sorting. It reports whether the time was replaced.
+func NewWithinSkew(platform string, platformTime time.Time, maxSkew time.Duration) (Message, bool) {
+	received := ReceiveTime()
+	if skew := platformTime.Sub(received); platformTime.IsZero() || (skew <= maxSkew && skew >= -maxSkew) {
+		return stamp(platform, platformTime, received), false
+	}
+	msg := stamp(platform, received, received)
+	msg.PlatformTimestamp = FormatTime(platformTime)
+	return msg, true
+}
+
+// stamp creates a message with the given times and the next sequence number
+func stamp(platform string, platformTime, received time.Time) Message {
+	if platformTime.IsZero() {
+		platformTime = received
+	}
+
+	return Message{
+		Platform:   platform,
+		Timestamp:  FormatTime(platformTime),
+		ReceivedAt: FormatTime(received),
+		Sequence:   sequence.Add(1),
+	}
 }
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// Event describes a file that was uploaded
+type Event struct {
+	Bucket       string    `json:"bucket,omitempty"` // Empty for local uploads
+	Key          string    `json:"key"`
+	Location     string    `json:"location"` // e.g. s3://bucket/key
+	Platform     string    `json:"platform"`
+	Channel      string    `json:"channel"`
+	StartTime    time.Time `json:"start_time"`
+	EndTime      time.Time `json:"end_time"`
+	MessageCount int64     `json:"message_count"`
+	Bytes        int64     `json:"bytes"`
+}
+
+// Notifier delivers upload events to a downstream consumer
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+	// Name identifies the notifier in logs
+	Name() string
+}
+
+// SQS sends each event as a message to an SQS queue
+type SQS struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+// NewSQS creates a notifier for the queue at queueURL
+func NewSQS(cfg aws.Config, queueURL string) *SQS {
+	return &SQS{client: sqs.NewFromConfig(cfg), queueURL: queueURL}
+}
+
+func (n *SQS) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = n.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(n.queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("send SQS message: %w", err)
+	}
+	return nil
+}
+
+func (n *SQS) Name() string { return "sqs" }
+
+// SNS publishes each event to an SNS topic, with the platform and channel
+// as message attributes so subscriptions can filter on them
+type SNS struct {
+	client   *sns.Client
+	topicARN string
+}
+
+// NewSNS creates a notifier for the topic topicARN
+func NewSNS(cfg aws.Config, topicARN string) *SNS {
+	return &SNS{client: sns.NewFromConfig(cfg), topicARN: topicARN}
+}
+
+func (n *SNS) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = n.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(n.topicARN),
+		Message:  aws.String(string(body)),
+		MessageAttributes: map[string]snstypes.MessageAttributeValue{
+			"platform": stringAttribute(event.Platform),
+			"channel":  stringAttribute(event.Channel),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("publish SNS message: %w", err)
+	}
+	return nil
+}
+
+func (n *SNS) Name() string { return "sns" }
+
+// stringAttribute builds a String SNS message attribute
+func stringAttribute(value string) snstypes.MessageAttributeValue {
+	return snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+}
+
+// Webhook POSTs each event as JSON to a URL. If a secret is set, the body's
+// HMAC-SHA256 is sent in the X-Chatlog-Signature header as "sha256=<hex>".
+type Webhook struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhook creates a notifier posting to url
+func NewWebhook(url, secret string) *Webhook {
+	return &Webhook{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *Webhook) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.secret))
+		mac.Write(body)
+		req.Header.Set("X-Chatlog-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (n *Webhook) Name() string { return "webhook" }
@@ -0,0 +1,219 @@
+package optout
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// Actions applied to messages from opted-out users
+const (
+	ActionDrop      = "drop"      // Discard the message
+	ActionAnonymize = "anonymize" // Keep the text but remove the author's identity
+)
+
+// anonymousName replaces the username of anonymized messages
+const anonymousName = "[anonymous]"
+
+// List is a set of opted-out users, loaded from a file or HTTP endpoint and
+// reloaded periodically. Each line holds a user ID, optionally prefixed with
+// a platform ("twitch:12345"); blank lines and lines starting with # are
+// ignored. IDs without a platform apply to every platform.
+type List struct {
+	source   string
+	action   string
+	interval time.Duration
+
+	users map[string]bool // "platform:id" or ":id"
+	mu    sync.RWMutex
+
+	httpClient *http.Client
+}
+
+// New creates an opt-out list. source is a file path or an http(s) URL.
+func New(source, action string, interval time.Duration) *List {
+	return &List{
+		source:     source,
+		action:     action,
+		interval:   interval,
+		users:      make(map[string]bool),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Load reads the list once, replacing the current entries
+func (l *List) Load(ctx context.Context) error {
+	rc, err := l.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	users := make(map[string]bool)
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		platform, id, found := strings.Cut(line, ":")
+		if !found {
+			platform, id = "", line
+		}
+		users[strings.ToLower(platform)+":"+strings.TrimSpace(id)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read opt-out list: %w", err)
+	}
+
+	l.mu.Lock()
+	changed := len(users) != len(l.users)
+	l.users = users
+	l.mu.Unlock()
+
+	if changed {
+		log.Printf("Loaded opt-out list: %d user(s)", len(users))
+	}
+	return nil
+}
+
+// open opens the list source
+func (l *List) open(ctx context.Context) (io.ReadCloser, error) {
+	if !strings.HasPrefix(l.source, "http://") && !strings.HasPrefix(l.source, "https://") {
+		file, err := os.Open(l.source)
+		if err != nil {
+			return nil, fmt.Errorf("open opt-out list: %w", err)
+		}
+		return file, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", l.source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch opt-out list: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetch opt-out list: status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// Start reloads the list until the context is cancelled. A failed reload
+// keeps the previous entries.
+func (l *List) Start(ctx context.Context) error {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Load(ctx); err != nil {
+				log.Printf("Warning: Failed to reload opt-out list: %v (keeping previous list)", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Contains reports whether a user has opted out
+func (l *List) Contains(platform, userID string) bool {
+	if userID == "" {
+		return false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.users[platform+":"+userID] || l.users[":"+userID]
+}
+
+// Apply returns the message to record, or false if it should be dropped.
+// Whatever the action, opted-out users are also scrubbed from messages by
+// others: the parent of a reply to them, a membership they gifted, and
+// chatters snapshots.
+func (l *List) Apply(msg message.Message) (message.Message, bool) {
+	if msg.Chatters != nil {
+		msg.Chatters = l.withoutOptOuts(msg.Platform, msg.Chatters)
+	}
+	if msg.Reply != nil && l.Contains(msg.Platform, msg.Reply.ParentUserID) {
+		reply := *msg.Reply
+		reply.ParentUserID = ""
+		reply.ParentUsername = anonymousName
+		reply.ParentText = ""
+		msg.Reply = &reply
+	}
+	if msg.Paid != nil && l.Contains(msg.Platform, msg.Paid.GifterID) {
+		paid := *msg.Paid
+		paid.GifterID = ""
+		msg.Paid = &paid
+	}
+	if !l.Contains(msg.Platform, msg.UserID) {
+		return msg, true
+	}
+	if l.action == ActionDrop {
+		return msg, false
+	}
+
+	msg.Username = anonymousName
+	msg.UserID = ""
+	msg.Badges = ""
+	return msg, true
+}
+
+// withoutOptOuts returns chatters without the opted-out users, copying the
+// snapshot only if it lists any. The total still counts them.
+func (l *List) withoutOptOuts(platform string, chatters *message.Chatters) *message.Chatters {
+	for i, user := range chatters.Users {
+		if !l.Contains(platform, user.UserID) {
+			continue
+		}
+		filtered := *chatters
+		filtered.Users = append([]message.Chatter(nil), chatters.Users[:i]...)
+		for _, user := range chatters.Users[i+1:] {
+			if !l.Contains(platform, user.UserID) {
+				filtered.Users = append(filtered.Users, user)
+			}
+		}
+		return &filtered
+	}
+	return chatters
+}
+
+// Filter forwards messages from in to out, applying the list, until the
+// context is cancelled or in is closed, which closes out
+func (l *List) Filter(ctx context.Context, in <-chan message.Message, out chan<- message.Message) error {
+	for {
+		select {
+		case msg, open := <-in:
+			if !open {
+				close(out)
+				return nil
+			}
+			msg, ok := l.Apply(msg)
+			if !ok {
+				continue
+			}
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
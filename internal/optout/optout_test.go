@@ -0,0 +1,124 @@
+package optout
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// newTestList loads a list holding twitch user 42 and user 7 on every
+// platform
+func newTestList(t *testing.T, action string) *List {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "optout.txt")
+	if err := os.WriteFile(path, []byte("# opted out\ntwitch:42\n7\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l := New(path, action, time.Hour)
+	if err := l.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestApplyAuthor(t *testing.T) {
+	msg := message.Message{Platform: "twitch", Username: "someone", UserID: "42", Badges: "vip/1", Message: "hi"}
+
+	if _, ok := newTestList(t, ActionDrop).Apply(msg); ok {
+		t.Error("drop kept an opted-out author's message")
+	}
+
+	got, ok := newTestList(t, ActionAnonymize).Apply(msg)
+	if !ok {
+		t.Fatal("anonymize dropped the message")
+	}
+	if got.Username != anonymousName || got.UserID != "" || got.Badges != "" {
+		t.Errorf("anonymized author = %q/%q/%q", got.Username, got.UserID, got.Badges)
+	}
+
+	// Scoped to twitch, so the same ID elsewhere is someone else
+	msg.Platform = "kick"
+	if got, ok := newTestList(t, ActionDrop).Apply(msg); !ok || got.UserID != "42" {
+		t.Error("an ID opted out on twitch was applied to kick")
+	}
+}
+
+func TestApplyReplyParent(t *testing.T) {
+	parent := &message.Reply{ParentID: "p1", ParentUserID: "42", ParentUsername: "someone", ParentText: "their words", ThreadID: "t1"}
+	msg := message.Message{Platform: "twitch", Username: "replier", UserID: "1", Message: "@someone no", Reply: parent}
+
+	for _, action := range []string{ActionDrop, ActionAnonymize} {
+		got, ok := newTestList(t, action).Apply(msg)
+		if !ok {
+			t.Fatalf("%s dropped a reply by a user who didn't opt out", action)
+		}
+		if got.UserID != "1" || got.Username != "replier" {
+			t.Errorf("%s changed the reply's author", action)
+		}
+		if got.Reply.ParentUserID != "" {
+			t.Errorf("%s kept parent_user_id %q", action, got.Reply.ParentUserID)
+		}
+		if got.Reply.ParentUsername != anonymousName {
+			t.Errorf("%s kept parent_username %q", action, got.Reply.ParentUsername)
+		}
+		if got.Reply.ParentText != "" {
+			t.Errorf("%s kept parent_text %q", action, got.Reply.ParentText)
+		}
+		if got.Reply.ParentID != "p1" || got.Reply.ThreadID != "t1" {
+			t.Errorf("%s lost the reply's message IDs", action)
+		}
+	}
+	if parent.ParentUserID != "42" || parent.ParentText != "their words" {
+		t.Error("Apply modified the original reply, which other consumers may share")
+	}
+
+	// Replies to users who didn't opt out are untouched
+	msg.Reply = &message.Reply{ParentID: "p2", ParentUserID: "5", ParentUsername: "other", ParentText: "fine"}
+	got, _ := newTestList(t, ActionDrop).Apply(msg)
+	if *got.Reply != *msg.Reply {
+		t.Errorf("reply to another user changed: %+v", *got.Reply)
+	}
+}
+
+func TestApplyGifter(t *testing.T) {
+	// IDs without a platform apply everywhere
+	paid := &message.Paid{Level: "Member", GifterID: "7"}
+	msg := message.Message{Platform: "youtube", Type: message.TypeGiftReceived, Username: "recipient", UserID: "3", Paid: paid}
+
+	got, ok := newTestList(t, ActionDrop).Apply(msg)
+	if !ok {
+		t.Fatal("dropped a gift received by a user who didn't opt out")
+	}
+	if got.Paid.GifterID != "" {
+		t.Errorf("kept gifter_id %q", got.Paid.GifterID)
+	}
+	if got.Paid.Level != "Member" || got.UserID != "3" {
+		t.Error("scrubbing the gifter changed the rest of the record")
+	}
+	if paid.GifterID != "7" {
+		t.Error("Apply modified the original paid details")
+	}
+}
+
+func TestApplyChatters(t *testing.T) {
+	chatters := &message.Chatters{Total: 3, Users: []message.Chatter{{UserID: "1", Username: "a"}, {UserID: "42", Username: "b"}, {UserID: "2", Username: "c"}}}
+	msg := message.Message{Platform: "twitch", Type: message.TypeChatters, Chatters: chatters}
+
+	got, ok := newTestList(t, ActionAnonymize).Apply(msg)
+	if !ok {
+		t.Fatal("dropped a chatters snapshot")
+	}
+	if len(got.Chatters.Users) != 2 || got.Chatters.Users[0].UserID != "1" || got.Chatters.Users[1].UserID != "2" {
+		t.Errorf("chatters = %+v", got.Chatters.Users)
+	}
+	if got.Chatters.Total != 3 {
+		t.Errorf("total = %d, want 3", got.Chatters.Total)
+	}
+	if len(chatters.Users) != 3 {
+		t.Error("Apply modified the original snapshot")
+	}
+}
@@ -0,0 +1,437 @@
+package overflow
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/john/chatlog/internal/message"
+)
+
+const (
+	// segmentMessages is how many messages are written to a segment before
+	// a new one is started
+	segmentMessages = 10000
+	segmentPrefix   = "overflow-"
+	segmentExt      = ".jsonl"
+	// encryptedExt is appended to the names of encrypted segments
+	encryptedExt = ".age"
+	// dropLogInterval limits how often dropped messages are logged
+	dropLogInterval = time.Minute
+)
+
+// Queue sits between the connectors and the rest of the pipeline. Messages
+// pass straight through while downstream keeps up; when it stalls, they are
+// appended to segment files on disk and fed back in order once it recovers,
+// so connectors are never blocked by a slow recorder. Segments left by an
+// unclean shutdown are replayed on the next start.
+type Queue struct {
+	dir      string
+	maxBytes int64
+
+	recipients []age.Recipient
+	identity   *age.X25519Identity // Reads back this run's encrypted segments
+
+	segments []string // Names of closed segments waiting to be read, oldest first
+	nextID   int64
+
+	writer      *os.File
+	encrypter   io.WriteCloser
+	writerBuf   *bufio.Writer
+	writerName  string
+	writerCount int
+
+	reader     *bufio.Scanner
+	readerF    *os.File
+	readerName string
+	next       *message.Message // Head of the backlog, ready to send
+
+	diskBytes   int64
+	dropped     int64
+	lastDropLog time.Time
+}
+
+// New creates an overflow queue storing segments in dir, using at most
+// maxMegabytes of disk. Messages beyond that are dropped.
+func New(dir string, maxMegabytes int) *Queue {
+	return &Queue{
+		dir:      dir,
+		maxBytes: int64(maxMegabytes) * 1024 * 1024,
+	}
+}
+
+// EnableEncryption encrypts segments to the given age recipients and to a
+// key generated for this run, which only this process holds, so spilled
+// messages never reach the disk in plaintext. Segments left by a previous
+// run can then only be read with the recipients' identities, and are kept
+// rather than replayed. It must be called before Open.
+func (q *Queue) EnableEncryption(recipients []age.Recipient) {
+	q.recipients = recipients
+}
+
+// Open creates the queue directory and finds segments left by a previous
+// run. It must be called before Run.
+func (q *Queue) Open() error {
+	if len(q.recipients) > 0 {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			return fmt.Errorf("generate overflow key: %w", err)
+		}
+		q.identity = identity
+	}
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return fmt.Errorf("create overflow directory: %w", err)
+	}
+	return q.loadSegments()
+}
+
+// Run forwards messages from in to out until the context is cancelled or in
+// is closed, which closes out. Any backlog still on disk is kept for the
+// next run.
+func (q *Queue) Run(ctx context.Context, in <-chan message.Message, out chan<- message.Message) error {
+	defer q.close()
+
+	for {
+		if !q.backlogged() {
+			// Pass-through: send directly, spilling only if out is full
+			select {
+			case msg, ok := <-in:
+				if !ok {
+					close(out)
+					return nil
+				}
+				select {
+				case out <- msg:
+				default:
+					log.Printf("Warning: Pipeline is falling behind, spilling messages to %s", q.dir)
+					q.spill(msg)
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+
+		if q.next == nil {
+			q.readNext()
+			if q.next == nil {
+				continue // Backlog exhausted or unreadable
+			}
+		}
+
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				log.Printf("Leaving overflow backlog in %s for the next run", q.dir)
+				close(out)
+				return nil
+			}
+			q.spill(msg)
+		case out <- *q.next:
+			q.next = nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// backlogged reports whether any messages are waiting on disk
+func (q *Queue) backlogged() bool {
+	return q.next != nil || q.reader != nil || len(q.segments) > 0 || q.writerCount > 0
+}
+
+// loadSegments finds segments left by a previous run
+func (q *Queue) loadSegments() error {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return fmt.Errorf("read overflow directory: %w", err)
+	}
+
+	var ids []int64
+	names := make(map[int64]string)
+	unreadable := 0
+	for _, entry := range entries {
+		id, encrypted, ok := parseSegmentName(entry.Name())
+		if !ok {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			q.diskBytes += info.Size()
+		}
+		q.nextID = max(q.nextID, id+1)
+		// The key an encrypted segment was read back with went with the
+		// run that wrote it
+		if encrypted {
+			unreadable++
+			continue
+		}
+		ids = append(ids, id)
+		names[id] = entry.Name()
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		q.segments = append(q.segments, names[id])
+	}
+
+	if unreadable > 0 {
+		log.Printf("Warning: Keeping %d encrypted overflow segment(s) in %s from a previous run; decrypt them with a recipient's identity to recover them", unreadable, q.dir)
+	}
+	if len(q.segments) > 0 {
+		log.Printf("Replaying %d overflow segment(s) from %s", len(q.segments), q.dir)
+	}
+	return nil
+}
+
+// spill appends a message to the current write segment
+func (q *Queue) spill(msg message.Message) {
+	if q.diskBytes >= q.maxBytes {
+		q.drop()
+		return
+	}
+
+	if q.writer == nil {
+		if err := q.openWriter(); err != nil {
+			log.Printf("Error opening overflow segment: %v", err)
+			q.drop()
+			return
+		}
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding overflow message: %v", err)
+		return
+	}
+	data = append(data, '\n')
+	if _, err := q.writerBuf.Write(data); err != nil {
+		log.Printf("Error writing overflow segment: %v", err)
+		q.drop()
+		return
+	}
+	q.diskBytes += int64(len(data))
+	q.writerCount++
+
+	if q.writerCount >= segmentMessages {
+		q.closeWriter()
+	}
+}
+
+// drop counts a message that could not be queued, logging at most once a minute
+func (q *Queue) drop() {
+	q.dropped++
+	if time.Since(q.lastDropLog) >= dropLogInterval {
+		log.Printf("Warning: Overflow queue is full (%d MB), %d message(s) dropped", q.maxBytes/1024/1024, q.dropped)
+		q.lastDropLog = time.Now()
+	}
+}
+
+// openWriter starts a new write segment
+func (q *Queue) openWriter() error {
+	name := q.segmentName(q.nextID)
+	file, encrypter, err := q.createSegment(filepath.Join(q.dir, name))
+	if err != nil {
+		return err
+	}
+	var out io.Writer = file
+	if encrypter != nil {
+		out = encrypter
+	}
+	q.nextID++
+	q.writer = file
+	q.encrypter = encrypter
+	q.writerBuf = bufio.NewWriterSize(out, 64*1024)
+	q.writerName = name
+	q.writerCount = 0
+	return nil
+}
+
+// createSegment creates a segment file and, when encrypting, the
+// encrypter that must be closed before the file
+func (q *Queue) createSegment(path string) (*os.File, io.WriteCloser, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if q.identity == nil {
+		return file, nil, nil
+	}
+	encrypter, err := age.Encrypt(file, append([]age.Recipient{q.identity.Recipient()}, q.recipients...)...)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("encrypt: %w", err)
+	}
+	return file, encrypter, nil
+}
+
+// closeWriter flushes the write segment and queues it for reading
+func (q *Queue) closeWriter() {
+	if q.writer == nil {
+		return
+	}
+	if err := q.writerBuf.Flush(); err != nil {
+		log.Printf("Error flushing overflow segment: %v", err)
+	}
+	if q.encrypter != nil {
+		if err := q.encrypter.Close(); err != nil {
+			log.Printf("Error flushing overflow segment: %v", err)
+		}
+	}
+	q.writer.Close()
+	q.segments = append(q.segments, q.writerName)
+	q.writer, q.encrypter, q.writerBuf, q.writerCount = nil, nil, nil, 0
+}
+
+// readNext loads the next backlog message into q.next, moving through
+// segments and finishing the partially written one once the rest are read
+func (q *Queue) readNext() {
+	for q.next == nil {
+		if q.reader == nil {
+			if len(q.segments) == 0 {
+				if q.writerCount == 0 {
+					return
+				}
+				q.closeWriter()
+			}
+			if err := q.openReader(q.segments[0]); err != nil {
+				log.Printf("Error opening overflow segment: %v (skipping)", err)
+				q.removeSegment(q.segments[0])
+				q.segments = q.segments[1:]
+				continue
+			}
+			q.segments = q.segments[1:]
+		}
+
+		if !q.reader.Scan() {
+			if err := q.reader.Err(); err != nil {
+				log.Printf("Error reading overflow segment: %v (skipping rest)", err)
+			}
+			q.readerF.Close()
+			q.removeSegment(q.readerName)
+			q.reader, q.readerF = nil, nil
+			if !q.backlogged() {
+				if q.dropped > 0 {
+					log.Printf("Overflow backlog drained (%d message(s) were dropped)", q.dropped)
+				} else {
+					log.Println("Overflow backlog drained")
+				}
+				q.dropped = 0
+			}
+			continue
+		}
+
+		var msg message.Message
+		if err := json.Unmarshal(q.reader.Bytes(), &msg); err != nil {
+			continue // Torn write from a crash
+		}
+		q.next = &msg
+	}
+}
+
+// openReader opens a segment for reading
+func (q *Queue) openReader(name string) error {
+	file, err := os.Open(filepath.Join(q.dir, name))
+	if err != nil {
+		return err
+	}
+	var r io.Reader = file
+	if strings.HasSuffix(name, encryptedExt) {
+		if r, err = age.Decrypt(file, q.identity); err != nil {
+			file.Close()
+			return fmt.Errorf("decrypt: %w", err)
+		}
+	}
+	q.readerF = file
+	q.readerName = name
+	q.reader = bufio.NewScanner(r)
+	q.reader.Buffer(make([]byte, 64*1024), 1024*1024)
+	return nil
+}
+
+// removeSegment deletes a fully read segment
+func (q *Queue) removeSegment(name string) {
+	path := filepath.Join(q.dir, name)
+	if info, err := os.Stat(path); err == nil {
+		q.diskBytes -= info.Size()
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing overflow segment: %v", err)
+	}
+}
+
+// close flushes the write segment and releases files, leaving any backlog
+// on disk for the next run
+func (q *Queue) close() {
+	if q.reader != nil {
+		q.truncateReader()
+	}
+	q.closeWriter()
+}
+
+// truncateReader rewrites the segment being read so it holds only the
+// messages not yet sent, starting with q.next
+func (q *Queue) truncateReader() {
+	defer q.readerF.Close()
+
+	path := filepath.Join(q.dir, q.readerName)
+	tmp, encrypter, err := q.createSegment(path + ".tmp")
+	if err != nil {
+		log.Printf("Error saving overflow position: %v (some messages will be replayed twice)", err)
+		return
+	}
+
+	var out io.Writer = tmp
+	if encrypter != nil {
+		out = encrypter
+	}
+	w := bufio.NewWriter(out)
+	if q.next != nil {
+		data, _ := json.Marshal(q.next)
+		w.Write(append(data, '\n'))
+	}
+	for q.reader.Scan() {
+		w.Write(q.reader.Bytes())
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if encrypter != nil && err == nil {
+		err = encrypter.Close()
+	}
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		log.Printf("Error saving overflow position: %v (some messages will be replayed twice)", err)
+		os.Remove(path + ".tmp")
+	}
+}
+
+// segmentName returns the file name of a new segment
+func (q *Queue) segmentName(id int64) string {
+	name := fmt.Sprintf("%s%020d%s", segmentPrefix, id, segmentExt)
+	if q.identity != nil {
+		name += encryptedExt
+	}
+	return name
+}
+
+// parseSegmentName returns the ID of a segment file name and whether it is
+// encrypted
+func parseSegmentName(name string) (id int64, encrypted bool, ok bool) {
+	name, encrypted = strings.CutSuffix(name, encryptedExt)
+	if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentExt) {
+		return 0, false, false
+	}
+	id, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentExt), 10, 64)
+	return id, encrypted, err == nil
+}
@@ -0,0 +1,104 @@
+package overflow
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/john/chatlog/internal/message"
+)
+
+func TestEncryptedSegments(t *testing.T) {
+	operator, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	q := New(dir, 10)
+	q.EnableEncryption([]age.Recipient{operator.Recipient()})
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, text := range []string{"secret one", "secret two"} {
+		q.spill(message.Message{Platform: "twitch", Channel: "chan", Message: text})
+	}
+	q.closeWriter()
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(paths) != 1 || !strings.HasSuffix(paths[0], segmentExt+encryptedExt) {
+		t.Fatalf("segments = %v", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Error("segment holds plaintext")
+	}
+
+	// The queue reads its own segments back in order
+	var got []string
+	for q.backlogged() {
+		q.readNext()
+		if q.next != nil {
+			got = append(got, q.next.Message)
+			q.next = nil
+		}
+	}
+	if strings.Join(got, ",") != "secret one,secret two" {
+		t.Errorf("read back %v", got)
+	}
+
+	// The operator's identity opens a segment a previous run left
+	q.spill(message.Message{Platform: "twitch", Channel: "chan", Message: "left over"})
+	q.close()
+	next := New(dir, 10)
+	next.EnableEncryption([]age.Recipient{operator.Recipient()})
+	if err := next.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if next.backlogged() {
+		t.Error("a new run tried to replay a segment it has no key for")
+	}
+	paths, _ = filepath.Glob(filepath.Join(dir, "*"))
+	if len(paths) != 1 {
+		t.Fatalf("segments = %v", paths)
+	}
+	file, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	plain, err := age.Decrypt(file, operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	buf.ReadFrom(plain)
+	if !strings.Contains(buf.String(), "left over") {
+		t.Errorf("decrypted %q", buf.String())
+	}
+}
+
+func TestPlaintextSegmentsReplay(t *testing.T) {
+	dir := t.TempDir()
+	q := New(dir, 10)
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+	q.spill(message.Message{Platform: "twitch", Channel: "chan", Message: "kept"})
+	q.close()
+
+	next := New(dir, 10)
+	if err := next.Open(); err != nil {
+		t.Fatal(err)
+	}
+	next.readNext()
+	if next.next == nil || next.next.Message != "kept" {
+		t.Fatalf("replayed %+v", next.next)
+	}
+}
@@ -0,0 +1,359 @@
+package pause
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+// Modes of a paused channel
+const (
+	ModeDrop = "drop" // Stay joined and discard the channel's records
+	ModePart = "part" // Leave the channel, and join it again on resume
+)
+
+// expiryCheckInterval is how often pauses with an end time are checked
+const expiryCheckInterval = 10 * time.Second
+
+// Joiner joins and parts a platform's channels at runtime
+type Joiner interface {
+	Join(channel string)
+	Part(channel string)
+}
+
+// Pause is a channel that isn't being recorded
+type Pause struct {
+	Platform string     `json:"platform"`
+	Channel  string     `json:"channel"`
+	Mode     string     `json:"mode"`
+	Reason   string     `json:"reason,omitempty"`
+	Since    time.Time  `json:"since"`
+	Until    *time.Time `json:"until,omitempty"` // Resumed automatically at this time
+	Dropped  int64      `json:"dropped"`         // Records discarded while paused
+}
+
+// key returns the pause's map key
+func (p *Pause) key() string {
+	return p.Platform + "/" + p.Channel
+}
+
+// Gate drops the records of paused channels. Pauses are kept in a state
+// file so a restart doesn't resume recording a channel early.
+type Gate struct {
+	statePath string
+	paused    map[string]*Pause // key: "platform/channel"
+	joiners   map[string]Joiner
+	status    *status.Component
+	mu        sync.Mutex
+}
+
+// New creates a gate, loading the pauses saved in statePath
+func New(statePath string) (*Gate, error) {
+	g := &Gate{
+		statePath: statePath,
+		paused:    make(map[string]*Pause),
+		joiners:   make(map[string]Joiner),
+	}
+
+	data, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read pause state: %w", err)
+	}
+	var pauses []*Pause
+	if err := json.Unmarshal(data, &pauses); err != nil {
+		return nil, fmt.Errorf("parse pause state: %w", err)
+	}
+	for _, p := range pauses {
+		g.paused[p.key()] = p
+	}
+	if len(pauses) > 0 {
+		log.Printf("Loaded %d paused channel(s) from %s", len(pauses), statePath)
+	}
+	return g, nil
+}
+
+// EnableJoiner lets channels of platform be paused by parting them. It
+// must be called before Start.
+func (g *Gate) EnableJoiner(platform string, j Joiner) {
+	g.joiners[platform] = j
+}
+
+// EnableStatus reports the paused channels to comp. It must be called
+// before Start.
+func (g *Gate) EnableStatus(comp *status.Component) {
+	g.status = comp
+	g.mu.Lock()
+	g.reportLocked()
+	g.mu.Unlock()
+}
+
+// Parted returns the channels of platform paused by parting, which should
+// not be joined at startup
+func (g *Gate) Parted(platform string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var channels []string
+	for _, p := range g.paused {
+		if p.Platform == platform && p.Mode == ModePart {
+			channels = append(channels, p.Channel)
+		}
+	}
+	slices.Sort(channels)
+	return channels
+}
+
+// Pause stops recording a channel until it is resumed, or until the given
+// time if it isn't zero. Pausing a paused channel replaces its pause.
+func (g *Gate) Pause(platform, channel, mode, reason string, until time.Time) (Pause, error) {
+	p := &Pause{
+		Platform: strings.ToLower(platform),
+		Channel:  strings.ToLower(strings.TrimPrefix(channel, "#")),
+		Mode:     mode,
+		Reason:   reason,
+		Since:    time.Now().UTC(),
+	}
+	if !until.IsZero() {
+		until = until.UTC()
+		p.Until = &until
+	}
+	if p.Platform == "" || p.Channel == "" {
+		return Pause{}, fmt.Errorf("platform and channel are required")
+	}
+	if p.Mode == "" {
+		p.Mode = ModeDrop
+	}
+	if p.Mode != ModeDrop && p.Mode != ModePart {
+		return Pause{}, fmt.Errorf("mode must be %s or %s, got %q", ModeDrop, ModePart, p.Mode)
+	}
+	joiner := g.joiners[p.Platform]
+	if p.Mode == ModePart && joiner == nil {
+		return Pause{}, fmt.Errorf("%s channels can't be parted at runtime; use mode %s", p.Platform, ModeDrop)
+	}
+
+	g.mu.Lock()
+	previous := g.paused[p.key()]
+	g.paused[p.key()] = p
+	g.saveLocked()
+	g.reportLocked()
+	g.mu.Unlock()
+
+	switch {
+	case p.Mode == ModePart && (previous == nil || previous.Mode != ModePart):
+		joiner.Part(p.Channel)
+	case p.Mode == ModeDrop && previous != nil && previous.Mode == ModePart:
+		joiner.Join(p.Channel)
+	}
+	log.Printf("Paused recording of %s (%s)", p.key(), p.Mode)
+	return *p, nil
+}
+
+// Resume records a paused channel again, reporting whether it was paused
+func (g *Gate) Resume(platform, channel string) (Pause, bool) {
+	key := strings.ToLower(platform) + "/" + strings.ToLower(strings.TrimPrefix(channel, "#"))
+
+	g.mu.Lock()
+	p, ok := g.paused[key]
+	if ok {
+		delete(g.paused, key)
+		g.saveLocked()
+		g.reportLocked()
+	}
+	g.mu.Unlock()
+	if !ok {
+		return Pause{}, false
+	}
+
+	if p.Mode == ModePart {
+		if joiner := g.joiners[p.Platform]; joiner != nil {
+			joiner.Join(p.Channel)
+		}
+	}
+	log.Printf("Resumed recording of %s after %s (%d record(s) dropped)", key,
+		time.Since(p.Since).Round(time.Second), p.Dropped)
+	return *p, true
+}
+
+// List returns the paused channels, sorted
+func (g *Gate) List() []Pause {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	pauses := make([]Pause, 0, len(g.paused))
+	for _, p := range g.paused {
+		pauses = append(pauses, *p)
+	}
+	slices.SortFunc(pauses, func(a, b Pause) int {
+		return strings.Compare(a.key(), b.key())
+	})
+	return pauses
+}
+
+// Start resumes pauses as they reach their end time, until the context is
+// cancelled
+func (g *Gate) Start(ctx context.Context) error {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, p := range g.List() {
+				if p.Until != nil && !now.Before(*p.Until) {
+					g.Resume(p.Platform, p.Channel)
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// allow reports whether a record is kept, counting it if it is dropped
+func (g *Gate) allow(msg message.Message) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.paused) == 0 {
+		return true
+	}
+	p, ok := g.paused[msg.Platform+"/"+strings.ToLower(msg.Channel)]
+	if !ok {
+		return true
+	}
+	p.Dropped++
+	return false
+}
+
+// Filter forwards messages from in to out, dropping those of paused
+// channels, until the context is cancelled or in is closed, which closes
+// out
+func (g *Gate) Filter(ctx context.Context, in <-chan message.Message, out chan<- message.Message) error {
+	for {
+		select {
+		case msg, open := <-in:
+			if !open {
+				close(out)
+				return nil
+			}
+			if !g.allow(msg) {
+				continue
+			}
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// saveLocked writes the state file atomically; the caller must hold g.mu.
+// A failure is logged, leaving the pause in effect until restart.
+func (g *Gate) saveLocked() {
+	pauses := make([]*Pause, 0, len(g.paused))
+	for _, p := range g.paused {
+		pauses = append(pauses, p)
+	}
+	data, err := json.MarshalIndent(pauses, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(g.statePath), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(g.statePath+".tmp", data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(g.statePath+".tmp", g.statePath)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to save pause state, pauses will be lost on restart: %v", err)
+	}
+}
+
+// reportLocked updates the status component; the caller must hold g.mu
+func (g *Gate) reportLocked() {
+	channels := make([]string, 0, len(g.paused))
+	for key, p := range g.paused {
+		channels = append(channels, key+" ("+p.Mode+")")
+	}
+	slices.Sort(channels)
+	g.status.Set("paused", channels)
+}
+
+// pauseRequest is the body of a pause request
+type pauseRequest struct {
+	Platform string `json:"platform"`
+	Channel  string `json:"channel"`
+	Mode     string `json:"mode"`    // drop (default) or part
+	Reason   string `json:"reason"`  // Logged and listed, e.g. who asked
+	Minutes  int    `json:"minutes"` // Resume automatically after this long; 0 waits for a resume
+}
+
+// Handler serves the pause API: GET lists paused channels, POST pauses
+// one and DELETE ?platform=&channel= resumes one
+func (g *Gate) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, g.List())
+
+		case http.MethodPost:
+			var req pauseRequest
+			dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+				return
+			}
+			if req.Minutes < 0 {
+				http.Error(w, "minutes must not be negative", http.StatusBadRequest)
+				return
+			}
+			var until time.Time
+			if req.Minutes > 0 {
+				until = time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+			}
+			p, err := g.Pause(req.Platform, req.Channel, req.Mode, req.Reason, until)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusCreated, p)
+
+		case http.MethodDelete:
+			p, ok := g.Resume(r.URL.Query().Get("platform"), r.URL.Query().Get("channel"))
+			if !ok {
+				http.Error(w, "channel is not paused", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, p)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
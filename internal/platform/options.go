@@ -0,0 +1,38 @@
+package platform
+
+import "fmt"
+
+// StringList reads an option holding a list of strings, or nil if it is
+// unset
+func StringList(options map[string]any, key string) ([]string, error) {
+	raw, ok := options[key]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	items, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list", key)
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of strings", key)
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// StringOption reads an option holding a string, or "" if it is unset
+func StringOption(options map[string]any, key string) (string, error) {
+	raw, ok := options[key]
+	if !ok || raw == nil {
+		return "", nil
+	}
+	s, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
@@ -0,0 +1,82 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/john/chatlog/internal/egress"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+// Connector reads chat from a platform. Start sends messages until the
+// context is cancelled, reconnecting as needed, and returns ctx.Err() then.
+type Connector interface {
+	Start(ctx context.Context, messageChan chan<- message.Message) error
+}
+
+// StatusReporter is implemented by connectors that report their state.
+// EnableStatus is called before Start with a component named after the
+// connector.
+type StatusReporter interface {
+	EnableStatus(comp *status.Component)
+}
+
+// EgressUser is implemented by connectors that can connect through a
+// proxy. EnableEgress is called before Start when the connector's outbound
+// settings aren't direct.
+type EgressUser interface {
+	EnableEgress(network *egress.Network)
+}
+
+// Factory creates a connector from its YAML options, which may be nil
+type Factory func(options map[string]any) (Connector, error)
+
+var (
+	registry   = make(map[string]Factory)
+	registryMu sync.Mutex
+)
+
+// Register makes a connector available to the connectors config under
+// name. It is typically called from an init function; registering a name
+// twice panics.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("platform: connector %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Names returns the registered connector names, sorted
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the connector registered under name
+func New(name string, options map[string]any) (Connector, error) {
+	registryMu.Lock()
+	factory, ok := registry[name]
+	registryMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown connector %q (registered: %v)", name, Names())
+	}
+
+	conn, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("connector %s: %w", name, err)
+	}
+	return conn, nil
+}
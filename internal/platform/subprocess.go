@@ -0,0 +1,230 @@
+package platform
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+func init() {
+	Register("subprocess", newSubprocess)
+}
+
+const (
+	// subprocessMaxLine is the longest message line accepted from a plugin
+	subprocessMaxLine = 1024 * 1024
+
+	// subprocessStopTimeout is how long a plugin has to exit after its stdin
+	// is closed and it is sent SIGTERM, before it is killed
+	subprocessStopTimeout = 5 * time.Second
+
+	// subprocessStableRun is how long a plugin must run for its restart
+	// backoff to reset
+	subprocessStableRun = time.Minute
+)
+
+// subprocess runs a plugin program as a connector. The plugin gets its
+// config as one JSON line ({"config": ...}) on stdin, which stays open
+// until shutdown, and writes one chatlog message object per line to
+// stdout. Its stderr is logged. It is restarted with backoff when it exits.
+type subprocess struct {
+	name     string
+	command  []string
+	dir      string
+	env      []string
+	platform string
+	config   any
+}
+
+func newSubprocess(options map[string]any) (Connector, error) {
+	command, err := StringList(options, "command")
+	if err != nil {
+		return nil, err
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("command is required")
+	}
+	platform, err := StringOption(options, "platform")
+	if err != nil {
+		return nil, err
+	}
+	if platform == "" {
+		return nil, fmt.Errorf("platform is required")
+	}
+	dir, err := StringOption(options, "dir")
+	if err != nil {
+		return nil, err
+	}
+
+	var env []string
+	if raw, ok := options["env"]; ok && raw != nil {
+		vars, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("env must be a map")
+		}
+		for key, value := range vars {
+			env = append(env, fmt.Sprintf("%s=%v", key, value))
+		}
+	}
+
+	name, err := StringOption(options, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	return &subprocess{
+		name:     cmp.Or(name, platform),
+		command:  command,
+		dir:      dir,
+		env:      env,
+		platform: platform,
+		config:   options["config"],
+	}, nil
+}
+
+// Start runs the plugin until the context is cancelled, restarting it with
+// exponential backoff whenever it exits
+func (s *subprocess) Start(ctx context.Context, messageChan chan<- message.Message) error {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := s.run(ctx, messageChan)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if time.Since(started) >= subprocessStableRun {
+			backoff = time.Second
+		}
+		log.Printf("Warning: Plugin %s exited (%v), restarting in %s", s.name, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// run starts the plugin once and reads its messages until it exits
+func (s *subprocess) run(ctx context.Context, messageChan chan<- message.Message) error {
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Dir = s.dir
+	cmd.Env = append(os.Environ(), s.env...)
+	cmd.WaitDelay = subprocessStopTimeout
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	// Output goes through pipes exec copies into, so WaitDelay also stops
+	// waiting on output held open by the plugin's own children
+	stdout, stdoutWriter := io.Pipe()
+	stderr, stderrWriter := io.Pipe()
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+	// At shutdown, close stdin and ask the plugin to exit; WaitDelay kills
+	// it if it hasn't in time
+	cmd.Cancel = func() error {
+		stdin.Close()
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	log.Printf("Plugin %s started (pid %d)", s.name, cmd.Process.Pid)
+
+	hello, err := json.Marshal(map[string]any{"config": s.config})
+	if err == nil {
+		_, err = stdin.Write(append(hello, '\n'))
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to send config to plugin %s: %v", s.name, err)
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		s.logStderr(stderr)
+		done <- struct{}{}
+	}()
+	go func() {
+		s.readMessages(ctx, stdout, messageChan)
+		done <- struct{}{}
+	}()
+
+	err = cmd.Wait()
+	stdin.Close()
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	<-done
+	<-done
+	return err
+}
+
+// readMessages converts the plugin's stdout lines to messages
+func (s *subprocess) readMessages(ctx context.Context, stdout io.Reader, messageChan chan<- message.Message) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), subprocessMaxLine)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		msg, err := s.convert(line)
+		if err != nil {
+			log.Printf("Warning: Plugin %s sent an invalid message: %v", s.name, err)
+			continue
+		}
+		select {
+		case messageChan <- msg:
+		case <-ctx.Done():
+			io.Copy(io.Discard, stdout)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading from plugin %s: %v", s.name, err)
+	}
+	// Drain the rest so the plugin isn't blocked writing while it exits
+	io.Copy(io.Discard, stdout)
+}
+
+// convert decodes a message line. Its receive time and sequence number
+// are assigned here; the platform defaults to the configured one, and a
+// missing or invalid timestamp to the receive time. Platform and channel
+// names unfit for file names are rejected.
+func (s *subprocess) convert(line []byte) (message.Message, error) {
+	msg, err := message.Decode(line, s.platform)
+	if err != nil {
+		return msg, err
+	}
+
+	sentAt, _ := time.Parse(time.RFC3339Nano, msg.Timestamp)
+	stamped := message.New(msg.Platform, sentAt)
+	msg.Timestamp = stamped.Timestamp
+	msg.ReceivedAt = stamped.ReceivedAt
+	msg.Sequence = stamped.Sequence
+	msg.Channel = strings.ToLower(msg.Channel)
+	return msg, nil
+}
+
+// logStderr logs each line the plugin writes to stderr
+func (s *subprocess) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		log.Printf("[%s] %s", s.name, scanner.Text())
+	}
+}
@@ -0,0 +1,139 @@
+package presign
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Signer generates time-limited download URLs for archived files, so they
+// can be shared with people who have no AWS credentials. Objects are
+// expected in the default YYYY/MM/DD/platform/channel/filename layout,
+// optionally under a key prefix.
+type Signer struct {
+	client    *s3.Client
+	presigner *s3.PresignClient
+	bucket    string
+	prefixes  []string
+	maxExpiry time.Duration
+}
+
+// File is an archived object with a presigned GET URL
+type File struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	URL          string    `json:"url"`
+	Expires      time.Time `json:"expires"`
+}
+
+// New creates a signer. Requested expiries are capped at maxExpiry.
+func New(client *s3.Client, bucket string, maxExpiry time.Duration) *Signer {
+	return &Signer{
+		client:    client,
+		presigner: s3.NewPresignClient(client),
+		bucket:    bucket,
+		prefixes:  []string{""},
+		maxExpiry: maxExpiry,
+	}
+}
+
+// EnablePrefixes also looks for files under these key prefixes, such as
+// channel group prefixes
+func (s *Signer) EnablePrefixes(prefixes []string) {
+	for _, prefix := range prefixes {
+		if prefix != "" {
+			s.prefixes = append(s.prefixes, prefix)
+		}
+	}
+}
+
+// Files returns signed URLs for a channel's files on a UTC day, valid for
+// expiry (capped at the signer's maximum)
+func (s *Signer) Files(ctx context.Context, platform, channel string, day time.Time, expiry time.Duration) ([]File, error) {
+	if platform == "" || channel == "" || strings.Contains(platform+channel, "/") {
+		return nil, fmt.Errorf("invalid platform or channel")
+	}
+	expiry = min(expiry, s.maxExpiry)
+
+	var files []File
+	for _, prefix := range s.prefixes {
+		paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(s.bucket),
+			Prefix: aws.String(fmt.Sprintf("%s%s/%s/%s/", prefix, day.UTC().Format("2006/01/02"), platform, channel)),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("list objects: %w", err)
+			}
+			for _, obj := range page.Contents {
+				key := aws.ToString(obj.Key)
+				req, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+					Bucket: aws.String(s.bucket),
+					Key:    aws.String(key),
+				}, s3.WithPresignExpires(expiry))
+				if err != nil {
+					return nil, fmt.Errorf("presign %s: %w", key, err)
+				}
+				files = append(files, File{
+					Key:          key,
+					Size:         aws.ToInt64(obj.Size),
+					LastModified: aws.ToTime(obj.LastModified),
+					URL:          req.URL,
+					Expires:      time.Now().Add(expiry).UTC(),
+				})
+			}
+		}
+	}
+	return files, nil
+}
+
+// Handler serves GET ?platform=&channel=&date=YYYY-MM-DD[&expires=1h] with
+// the matching files as JSON. The caller is responsible for authenticating
+// requests.
+func (s *Signer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		day, err := time.Parse("2006-01-02", query.Get("date"))
+		if err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		expiry := time.Hour
+		if v := query.Get("expires"); v != "" {
+			if expiry, err = time.ParseDuration(v); err != nil || expiry <= 0 {
+				http.Error(w, "invalid expires duration", http.StatusBadRequest)
+				return
+			}
+		}
+
+		platform, channel := query.Get("platform"), strings.ToLower(query.Get("channel"))
+		if platform == "" || channel == "" {
+			http.Error(w, "platform and channel are required", http.StatusBadRequest)
+			return
+		}
+
+		files, err := s.Files(r.Context(), platform, channel, day, expiry)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if files == nil {
+			files = []File{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(files)
+	})
+}
@@ -0,0 +1,200 @@
+// Package rawlog captures connectors' raw protocol frames (IRC lines,
+// Pusher events) to a size-capped, rotating debug log, so parsing bugs can
+// be reproduced from what servers actually sent. Each line is
+//
+//	2026-01-02T15:04:05.000Z {source} < {frame received}
+//	2026-01-02T15:04:05.000Z {source} > {frame sent}
+//
+// with line breaks inside frames escaped, and credentials in sent IRC lines
+// redacted.
+package rawlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// Log appends frames to a file, which is moved to path.1 (and older files
+// up to path.{maxFiles}) once it reaches maxBytes. Its methods are safe to
+// call on a nil Log, so connectors can record unconditionally.
+type Log struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	failed bool // Opening the file failed; logged once
+}
+
+// New creates a log writing to path, keeping maxFiles rotated files of
+// maxMegabytes each. The file is opened on the first frame.
+func New(path string, maxMegabytes, maxFiles int) *Log {
+	return &Log{path: path, maxBytes: int64(maxMegabytes) * 1024 * 1024, maxFiles: maxFiles}
+}
+
+// Received records a frame read from source
+func (l *Log) Received(source string, frame []byte) {
+	l.write(source, "<", frame)
+}
+
+// Sent records a frame written to source, with credentials redacted
+func (l *Log) Sent(source string, frame []byte) {
+	l.write(source, ">", redact(bytes.TrimRight(frame, "\r\n")))
+}
+
+// escaper keeps each frame on one line
+var escaper = strings.NewReplacer("\r", `\r`, "\n", `\n`)
+
+// write appends one line, rotating the file first if it is full
+func (l *Log) write(source, direction string, frame []byte) {
+	if l == nil {
+		return
+	}
+	frame = bytes.TrimRight(frame, "\r\n")
+	line := fmt.Sprintf("%s %s %s %s\n", message.FormatTime(time.Now()), source, direction, escaper.Replace(string(frame)))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil && l.size+int64(len(line)) > l.maxBytes {
+		l.rotate()
+	}
+	if l.file == nil && !l.open() {
+		return
+	}
+	n, err := l.file.WriteString(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("Warning: Failed to write raw log %s: %v", l.path, err)
+	}
+}
+
+// open opens the log for appending, warning once if it can't (caller
+// holds mu)
+func (l *Log) open() bool {
+	file, err := openFile(l.path)
+	if err != nil {
+		if !l.failed {
+			log.Printf("Warning: Failed to open raw log %s: %v", l.path, err)
+		}
+		l.failed = true
+		return false
+	}
+	l.file, l.size, l.failed = file, 0, false
+	if stat, err := file.Stat(); err == nil {
+		l.size = stat.Size()
+	}
+	return true
+}
+
+// openFile opens path for appending, creating its directory if needed.
+// Raw frames hold chat, so only the owner can read them.
+func openFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+}
+
+// rotate closes the full file and shifts it and the older ones up a
+// number, dropping the oldest (caller holds mu)
+func (l *Log) rotate() {
+	l.file.Close()
+	l.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		log.Printf("Warning: Failed to rotate raw log %s: %v", l.path, err)
+	}
+}
+
+// Close closes the file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Streams returns writers for a byte stream's two directions, which record
+// each complete line they are given as a frame of source. Each connection
+// needs its own streams.
+func (l *Log) Streams(source string) (sent, received io.Writer) {
+	return &lineWriter{record: func(frame []byte) { l.Sent(source, frame) }},
+		&lineWriter{record: func(frame []byte) { l.Received(source, frame) }}
+}
+
+// maxPartialLine caps the bytes a lineWriter holds while waiting for a
+// line's end; longer lines are recorded in pieces
+const maxPartialLine = 64 * 1024
+
+// lineWriter splits a byte stream into lines
+type lineWriter struct {
+	record  func(frame []byte)
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.record(w.partial[:i+1])
+		w.partial = w.partial[i+1:]
+	}
+	if len(w.partial) > maxPartialLine {
+		w.record(w.partial)
+		w.partial = nil
+	}
+	// Keep the buffer from growing with everything ever written
+	if len(w.partial) == 0 {
+		w.partial = nil
+	}
+	return len(p), nil
+}
+
+// redact hides the credentials an IRC client sends: server passwords,
+// SASL payloads and NickServ identification
+func redact(frame []byte) []byte {
+	line := string(frame)
+	command, args, _ := strings.Cut(line, " ")
+	switch strings.ToUpper(command) {
+	case "PASS":
+		return []byte("PASS <redacted>")
+	case "AUTHENTICATE":
+		// Mechanism names, "+" (an empty payload) and "*" (abort) are kept
+		switch strings.ToUpper(args) {
+		case "+", "*", "PLAIN", "EXTERNAL":
+		default:
+			return []byte("AUTHENTICATE <redacted>")
+		}
+	case "PRIVMSG":
+		target, text, _ := strings.Cut(args, " ")
+		if strings.EqualFold(target, "NickServ") && strings.HasPrefix(strings.ToUpper(strings.TrimPrefix(text, ":")), "IDENTIFY") {
+			return []byte("PRIVMSG NickServ :IDENTIFY <redacted>")
+		}
+	}
+	return frame
+}
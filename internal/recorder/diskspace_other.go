@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package recorder
+
+import "errors"
+
+// diskFree is not supported on this platform; the disk monitor relies on
+// write errors alone
+func diskFree(path string) (int64, error) {
+	return 0, errors.New("disk space check not supported on this platform")
+}
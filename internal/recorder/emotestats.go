@@ -0,0 +1,196 @@
+package recorder
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// EmoteStatsExt is the extension of emote statistics sidecars, which are
+// named after their chat file: twitch_x_20251230_1030.emotes.json
+const EmoteStatsExt = ".emotes.json"
+
+// TypeEmoteStats is the type of emote statistics sidecars
+const TypeEmoteStats = "emote_stats"
+
+// EmoteStats is a chat file's emote usage, written as a single JSON object
+// next to it. Platform, channel and timestamp (the file's start) match the
+// chat file, so the sidecar is uploaded to the same partition.
+type EmoteStats struct {
+	Type               string               `json:"type"`
+	Platform           string               `json:"platform"`
+	Channel            string               `json:"channel"`
+	Timestamp          string               `json:"timestamp"`
+	File               string               `json:"file"` // Chat file name
+	End                string               `json:"end"`
+	Messages           int                  `json:"messages"` // Chat messages counted
+	MessagesWithEmotes int                  `json:"messages_with_emotes"`
+	Emotes             []message.EmoteCount `json:"emotes"` // Every emote used, most used first
+	Hours              []EmoteHour          `json:"hours,omitempty"`
+}
+
+// EmoteHour is the emote usage in one hour of message time
+type EmoteHour struct {
+	Hour     string               `json:"hour"` // Start of the hour in TimestampFormat
+	Messages int                  `json:"messages"`
+	Emotes   []message.EmoteCount `json:"emotes"`
+}
+
+// emoteTally counts a file's emotes while it is open
+type emoteTally struct {
+	messages   int
+	withEmotes int
+	counts     map[string]int
+	hours      map[time.Time]*hourTally
+}
+
+// hourTally counts one hour's emotes
+type hourTally struct {
+	messages int
+	counts   map[string]int
+}
+
+// EnableEmoteStats writes an emote statistics sidecar for every chat file
+// and queues it for upload after the file. hourly adds per-hour counts
+// by message time. It must be called before Start.
+func (r *Recorder) EnableEmoteStats(hourly bool) {
+	r.emoteStats = true
+	r.emoteStatsHourly = hourly
+}
+
+// countEmotes adds a chat message's emotes to its file's tally; the caller
+// must hold the shard's lock
+func (r *Recorder) countEmotes(fw *fileWriter, msg message.Message) {
+	if !r.emoteStats || msg.Type != "" || fw.resumed {
+		return
+	}
+	if fw.emotes == nil {
+		fw.emotes = &emoteTally{counts: make(map[string]int), hours: make(map[time.Time]*hourTally)}
+	}
+	tally := fw.emotes
+	tally.messages++
+	if len(msg.Emotes) > 0 {
+		tally.withEmotes++
+	}
+	for _, name := range msg.Emotes {
+		tally.counts[name]++
+	}
+	if !r.emoteStatsHourly {
+		return
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, msg.Timestamp)
+	if err != nil {
+		return
+	}
+	hour := ts.UTC().Truncate(time.Hour)
+	h := tally.hours[hour]
+	if h == nil {
+		h = &hourTally{counts: make(map[string]int)}
+		tally.hours[hour] = h
+	}
+	h.messages++
+	for _, name := range msg.Emotes {
+		h.counts[name]++
+	}
+}
+
+// writeEmoteStats writes the sidecar of a closed chat file and queues it
+// for upload; the caller must hold the shard's lock. Files appended to
+// after a restart get none, since counts missing their earlier messages
+// would understate the file.
+func (r *Recorder) writeEmoteStats(fw *fileWriter, fileChan chan<- FileInfo) {
+	if fw.emotes == nil || fw.resumed || fw.messageCount == 0 {
+		return
+	}
+
+	stats := EmoteStats{
+		Type:               TypeEmoteStats,
+		Platform:           fw.platform,
+		Channel:            fw.channel,
+		Timestamp:          message.FormatTime(fw.createdAt),
+		File:               filepath.Base(fw.filename),
+		End:                message.FormatTime(time.Now()),
+		Messages:           fw.emotes.messages,
+		MessagesWithEmotes: fw.emotes.withEmotes,
+		Emotes:             sortedEmoteCounts(fw.emotes.counts),
+	}
+	hours := make([]time.Time, 0, len(fw.emotes.hours))
+	for hour := range fw.emotes.hours {
+		hours = append(hours, hour)
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Before(hours[j]) })
+	for _, hour := range hours {
+		h := fw.emotes.hours[hour]
+		stats.Hours = append(stats.Hours, EmoteHour{
+			Hour:     message.FormatTime(hour),
+			Messages: h.messages,
+			Emotes:   sortedEmoteCounts(h.counts),
+		})
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		log.Printf("Error marshaling emote stats: %v", err)
+		return
+	}
+	path := filepath.Join(r.outputDir, emoteStatsName(fw.filename))
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Printf("Error writing emote stats for %s: %v", fw.filename, err)
+		return
+	}
+
+	r.queueSidecar(fw, path, int64(len(data)+1), fileChan)
+}
+
+// queueSidecar queues a sidecar written for a closed chat file for upload,
+// after the file itself
+func (r *Recorder) queueSidecar(fw *fileWriter, path string, size int64, fileChan chan<- FileInfo) {
+	info := FileInfo{
+		Path:         path,
+		Platform:     fw.platform,
+		Channel:      fw.channel,
+		StartTime:    fw.createdAt,
+		EndTime:      time.Now().UTC(),
+		MessageCount: 1,
+		Bytes:        size,
+	}
+	if r.draining.Load() {
+		fileChan <- info
+		return
+	}
+	select {
+	case fileChan <- info:
+	default:
+		log.Printf("Warning: upload queue full, file will be uploaded later: %s", info.Filename())
+	}
+}
+
+// emoteStatsName returns the sidecar name for a chat file
+func emoteStatsName(filename string) string {
+	for _, ext := range []string{EncryptedExt, SeekableExt, ".jsonl"} {
+		filename = strings.TrimSuffix(filename, ext)
+	}
+	return filename + EmoteStatsExt
+}
+
+// sortedEmoteCounts lists counts most used first, then by name
+func sortedEmoteCounts(counts map[string]int) []message.EmoteCount {
+	emotes := make([]message.EmoteCount, 0, len(counts))
+	for name, count := range counts {
+		emotes = append(emotes, message.EmoteCount{Name: name, Count: count})
+	}
+	sort.Slice(emotes, func(i, j int) bool {
+		if emotes[i].Count != emotes[j].Count {
+			return emotes[i].Count > emotes[j].Count
+		}
+		return emotes[i].Name < emotes[j].Name
+	})
+	return emotes
+}
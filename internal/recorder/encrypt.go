@@ -0,0 +1,37 @@
+package recorder
+
+import (
+	"fmt"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// EncryptedExt is appended to the names of files encrypted with age
+const EncryptedExt = ".age"
+
+// ParseRecipients parses age X25519 public keys ("age1...")
+func ParseRecipients(keys []string) ([]age.Recipient, error) {
+	recipients := make([]age.Recipient, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("parse age recipient %q: %w", key, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// EnableEncryption encrypts new files to the given age recipients as they
+// are written, so plaintext never reaches the disk. Encrypted files are
+// named *.jsonl.age. age writes in 64 KiB chunks, so up to one chunk per
+// open file is only held in memory until the file is closed. It must be
+// called before Start.
+func (r *Recorder) EnableEncryption(recipients []age.Recipient) {
+	r.recipients = recipients
+}
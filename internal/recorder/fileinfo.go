@@ -0,0 +1,137 @@
+package recorder
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/search"
+)
+
+// FileInfo describes a completed log file handed to the uploader
+type FileInfo struct {
+	Path         string    // Local file path
+	Platform     string    // Platform name: "twitch", "kick", etc.
+	Channel      string    // Channel name or slug
+	StartTime    time.Time // When the file was created (UTC)
+	EndTime      time.Time // When the file was closed (UTC)
+	MessageCount int64     // Number of messages written
+	Bytes        int64     // File size in bytes
+
+	// Records per minute of receive time, keyed by stats.ReceivedMinuteLayout,
+	// for end-to-end latency. Unknown for files from a previous run.
+	ReceivedMinutes map[string]int64
+}
+
+// Filename returns the base name of the file
+func (f FileInfo) Filename() string {
+	return filepath.Base(f.Path)
+}
+
+// ParseFileInfo reconstructs file metadata for a file left on disk from a
+// previous run. Platform and channel are read from the first message, which
+// handles any channel name; the filename is only used as a fallback. The end
+// time is the file's modification time.
+func ParseFileInfo(path string) (FileInfo, error) {
+	info := FileInfo{Path: path}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return info, err
+	}
+	info.Bytes = stat.Size()
+	info.EndTime = stat.ModTime().UTC()
+
+	// Parse filename: platform_channel_YYYYMMDD_HHMM[SS[.nnnnnnnnn]].jsonl[.age|.gz], .emotes.json or .search.json
+	// Channel names may contain underscores, so parse from the end
+	nameWithoutExt := filepath.Base(path)
+	for _, ext := range []string{EmoteStatsExt, search.Ext, EncryptedExt, SeekableExt, ".jsonl"} {
+		nameWithoutExt = strings.TrimSuffix(nameWithoutExt, ext)
+	}
+	parts := strings.Split(nameWithoutExt, "_")
+	if len(parts) >= 4 {
+		stamp := parts[len(parts)-2] + "_" + parts[len(parts)-1]
+		t, err := time.Parse("20060102_1504", stamp)
+		if err != nil {
+			t, err = time.Parse("20060102_150405", stamp)
+		}
+		if err == nil {
+			info.Platform = parts[0]
+			info.Channel = strings.Join(parts[1:len(parts)-2], "_")
+			info.StartTime = t
+		}
+	}
+
+	// Encrypted files can't be read here, so they rely on the filename alone
+	if !strings.HasSuffix(path, EncryptedExt) {
+		if first, count, err := scanMessages(path); err == nil {
+			info.MessageCount = count
+			info.Platform = first.Platform
+			info.Channel = first.Channel
+			if info.StartTime.IsZero() {
+				info.StartTime, _ = time.Parse(time.RFC3339Nano, first.Timestamp)
+			}
+		}
+	}
+
+	if info.Platform == "" || info.Channel == "" || info.StartTime.IsZero() {
+		return info, fmt.Errorf("cannot determine platform, channel and time for %s", path)
+	}
+	return info, nil
+}
+
+// scanMessages decodes the first line of a JSONL file, plain or seekable,
+// and counts its lines, leaving out a header
+func scanMessages(path string) (struct{ Platform, Channel, Timestamp, Type string }, int64, error) {
+	var first struct{ Platform, Channel, Timestamp, Type string }
+
+	file, err := os.Open(path)
+	if err != nil {
+		return first, 0, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, SeekableExt) {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return first, 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var count, lines int64
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		lines++
+		if lines == 1 {
+			if err := json.Unmarshal(scanner.Bytes(), &first); err != nil {
+				return first, 0, err
+			}
+			if first.Type == message.TypeHeader {
+				continue
+			}
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return first, 0, err
+	}
+
+	if lines == 0 {
+		return first, 0, fmt.Errorf("empty file")
+	}
+	if first.Platform == "" || first.Channel == "" {
+		return first, 0, fmt.Errorf("first message is missing platform or channel")
+	}
+	return first, count, nil
+}
@@ -0,0 +1,33 @@
+package recorder
+
+import (
+	"fmt"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// EnableHeaders starts every new file with a header record naming the
+// schema and chatlog version, platform, channel and start time, so files
+// describe themselves wherever they end up. Files appended to after a
+// restart keep the header they were created with. It must be called
+// before Start.
+func (r *Recorder) EnableHeaders(version string) {
+	r.headerVersion = version
+	r.headers = true
+}
+
+// writeHeader buffers a new file's header record. It is not counted as a
+// message, so rotation and upload accounting are unchanged.
+func (r *Recorder) writeHeader(fw *fileWriter) error {
+	if !r.headers {
+		return nil
+	}
+	header := message.New(fw.platform, fw.createdAt)
+	header.Type = message.TypeHeader
+	header.Channel = fw.channel
+	header.Header = &message.Header{Schema: message.SchemaVersion, Version: r.headerVersion}
+	if err := fw.encode(&header); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package recorder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// nestedDepth is how many directories deep the nested layout files are:
+// {platform}/{channel}/{date}
+const nestedDepth = 3
+
+// EnableNestedLayout writes files into {platform}/{channel}/{YYYY-MM-DD}/
+// subdirectories of the output directory, by the UTC day each file starts,
+// instead of directly into it. File names are the same in both layouts. It
+// must be called before Start.
+func (r *Recorder) EnableNestedLayout() {
+	r.nested = true
+}
+
+// fileDir returns the directory a new file goes in, relative to the output
+// directory, creating it if needed
+func (r *Recorder) fileDir(platform, channel string, createdAt time.Time) (string, error) {
+	if !r.nested {
+		return "", nil
+	}
+	dir := filepath.Join(platform, channel, createdAt.Format(time.DateOnly))
+	if err := os.MkdirAll(filepath.Join(r.outputDir, dir), 0755); err != nil {
+		return "", fmt.Errorf("create directory: %w", err)
+	}
+	return dir, nil
+}
+
+// LocalFiles returns the paths of the files directly in dir and in the
+// nested layout's {platform}/{channel}/{date} subdirectories, so files of
+// either layout are found after switching. Files at other depths, such as
+// overflow segments and dead letters, are left out. A missing dir has none.
+func LocalFiles(dir string) ([]string, error) {
+	var paths []string
+	var walk func(path string, depth int) error
+	walk = func(path string, depth int) error {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			child := filepath.Join(path, entry.Name())
+			switch {
+			case entry.IsDir() && depth < nestedDepth:
+				if err := walk(child, depth+1); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			case entry.Type().IsRegular() && (depth == 0 || depth == nestedDepth):
+				paths = append(paths, child)
+			}
+		}
+		return nil
+	}
+
+	if err := walk(dir, 0); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// PruneDirs removes the now empty nested layout directories above a file
+// that was moved or deleted, up to root
+func PruneDirs(root, path string) {
+	prefix := filepath.Clean(root) + string(filepath.Separator)
+	for dir := filepath.Dir(filepath.Clean(path)); strings.HasPrefix(dir, prefix); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return // Not empty, or already gone
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package recorder
+
+import (
+	"log"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// MemoryPolicy is what happens to a message that would take the file
+// writers' buffers over the memory budget
+type MemoryPolicy int
+
+const (
+	MemoryFlush MemoryPolicy = iota // Flush the message's file, dropping the message if that fails
+	MemoryDrop                      // Drop the message until flushes bring the buffers back under budget
+)
+
+// recordOverhead approximates the memory of a buffered record besides its
+// strings: the struct, slice headers and allocator slack
+const recordOverhead = 512
+
+// EnableMemoryBudget caps the memory held in the file writers' message
+// buffers across all channels at megabytes, so a file whose flushes keep
+// failing can't grow without bound. Sizes are estimated, not measured. It
+// must be called before Start.
+func (r *Recorder) EnableMemoryBudget(megabytes int, policy MemoryPolicy) {
+	r.memoryLimit = int64(megabytes) * 1024 * 1024
+	r.memoryPolicy = policy
+}
+
+// recordSize estimates the memory a buffered record holds
+func recordSize(msg *message.Message) int64 {
+	n := recordOverhead + len(msg.Platform) + len(msg.Timestamp) + len(msg.ReceivedAt) + len(msg.ID) +
+		len(msg.Channel) + len(msg.ChannelID) + len(msg.Username) + len(msg.UserID) + len(msg.Message) +
+		len(msg.Badges) + len(msg.Type)
+	for _, emote := range msg.Emotes {
+		n += len(emote) + 16
+	}
+	n += 128 * (len(msg.Enrichment) + len(msg.Alerts))
+	return int64(n)
+}
+
+// reserve accounts for a record of size about to be buffered in fw, and
+// reports whether it fits the memory budget. Over budget, MemoryFlush
+// writes out fw's buffer and takes the record if that succeeded: the
+// buffers of files that flush are bounded by buffer_size anyway, so it is
+// files whose flushes fail that lose records. The caller must hold s.mu.
+func (r *Recorder) reserve(fw *fileWriter, size int64) bool {
+	if r.memoryLimit <= 0 || r.buffered.Load()+size <= r.memoryLimit {
+		r.account(fw, size)
+		return true
+	}
+
+	var err error
+	if r.memoryPolicy == MemoryFlush {
+		if len(fw.messageBuffer) > 0 {
+			err = r.flushFileWriter(fw)
+		}
+		if err == nil {
+			r.account(fw, size)
+			return true
+		}
+	}
+
+	dropped := r.memoryDropped.Add(1)
+	if dropped == 1 || dropped%1000 == 0 {
+		if err != nil {
+			log.Printf("Warning: Message buffers are over the %d MB memory budget and %s can't be flushed (%v), dropped %d message(s)",
+				r.memoryLimit/1024/1024, fw.filename, err, dropped)
+		} else {
+			log.Printf("Warning: Message buffers are over the %d MB memory budget, dropped %d message(s)",
+				r.memoryLimit/1024/1024, dropped)
+		}
+	}
+	r.status.Set("memory_dropped", dropped)
+	return false
+}
+
+// account adds a record's size to fw's buffer and the budget
+func (r *Recorder) account(fw *fileWriter, size int64) {
+	fw.bufferedBytes += size
+	r.buffered.Add(size)
+}
+
+// release returns a file writer's buffered records to the memory budget,
+// once they are written or discarded
+func (r *Recorder) release(fw *fileWriter) {
+	if fw.bufferedBytes == 0 {
+		return
+	}
+	r.status.Set("buffered_bytes", r.buffered.Add(-fw.bufferedBytes))
+	fw.bufferedBytes = 0
+}
@@ -4,99 +4,364 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"filippo.io/age"
+	"github.com/john/chatlog/internal/firstseen"
 	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/search"
+	"github.com/john/chatlog/internal/seekable"
+	"github.com/john/chatlog/internal/stats"
+	"github.com/john/chatlog/internal/status"
 )
 
 // fileWriter manages a single JSONL file
 type fileWriter struct {
 	file          *os.File
+	encrypter     io.WriteCloser   // Set when the file is encrypted
+	frames        *seekable.Writer // Set when the file is seekable
 	writer        *bufio.Writer
 	createdAt     time.Time
 	bytesWritten  int64
+	messageCount  int64
+	lastMessage   time.Time
 	messageBuffer []message.Message
 	platform      string
 	channel       string
 	filename      string
+	jitter        float64 // Fraction the time limit is shortened by, to spread rotations
+
+	// Chatter statistics for the summary record, when enabled
+	chatters     map[string]*message.ChatterCount
+	chatMessages int
+	newChatters  int
+
+	// Record timestamps for the rotation event
+	firstTimestamp string
+	lastTimestamp  string
+
+	received map[string]int64 // Records per minute of receive time
+
+	emotes *emoteTally // Set once a chat message is counted, when emote stats are enabled
+
+	search  *search.Builder // Set once a record is indexed, when search indexes are enabled
+	resumed bool            // Reopened after a restart, so records before it were not indexed
+
+	closingAt time.Time // When closing started, to time it for the rotation record
+
+	encoder *json.Encoder // Encodes into writer, counting bytes; created on first flush
+
+	unsynced bool // Flushed since the last sync
+
+	bufferedBytes int64 // Estimated size of messageBuffer, counted against the memory budget
+}
+
+// countingWriter writes encoded records to a file's buffered writer and
+// counts the bytes
+type countingWriter struct {
+	fw *fileWriter
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.fw.writer.Write(p)
+	c.fw.bytesWritten += int64(n)
+	return n, err
 }
 
+// diskCheckInterval is how often free disk space is checked
+const diskCheckInterval = 10 * time.Second
+
+// resumeHeadroom is the extra free space required above the minimum before
+// writing resumes, so the recorder doesn't flap around the threshold
+const resumeHeadroom = 32 * 1024 * 1024
+
 // Recorder handles buffering and writing chat messages to disk
 type Recorder struct {
 	outputDir       string
 	bufferSize      int
 	rotateMinutes   int
 	rotateMegabytes int64
+	minFreeBytes    int64
+	idleMinutes     int
+	maxOpenFiles    int
+
+	// Channels are hashed to shards, each writing its own files
+	shards    []*shard
+	openFiles atomic.Int64
+
+	// Degraded mode: while the disk is full, messages are held in spill
+	// instead of being written. mu guards these; a shard's lock is always
+	// taken before it.
+	degraded       bool
+	degradedReason string
+	spill          *spillBuffer
+	mu             sync.Mutex
+
+	draining atomic.Bool // Input closed; final files are handed to the uploader blocking
+
+	stats      *stats.Registry
+	status     *status.Component
+	recipients []age.Recipient
+
+	frameDuration time.Duration // Seekable frame window; zero writes plain JSONL
+	nested        bool          // Files go in {platform}/{channel}/{date}/ subdirectories
+
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+
+	rotationFor  func(platform, channel string) (minutes, megabytes int)
+	rotateJitter float64 // Most a file's time limit is shortened by, as a fraction
+
+	firstSeen   *firstseen.Tracker
+	topChatters int
+
+	emoteStats       bool
+	emoteStatsHourly bool
+	searchIndex      bool
+
+	headers       bool
+	headerVersion string
 
-	currentFiles map[string]*fileWriter // key: "platform_channel"
-	mu           sync.Mutex
+	rotations rotationLog
+
+	// Memory budget for buffered messages across all file writers
+	memoryLimit   int64
+	memoryPolicy  MemoryPolicy
+	buffered      atomic.Int64
+	memoryDropped atomic.Int64
+
+	// Files carried across restarts; suspended is guarded by mu
+	appendAfterRestart bool
+	resumable          []resumeEntry
+	suspended          []resumeEntry
 }
 
-// New creates a new recorder
-func New(outputDir string, bufferSize, rotateMinutes, rotateMegabytes int) *Recorder {
+// New creates a new recorder. When free disk space drops below
+// minFreeMegabytes, or a write fails because the disk is full, writing pauses
+// and up to spillSize messages are held in memory until space frees up.
+// Files with no messages for idleMinutes are closed, and at most maxOpenFiles
+// are kept open at once, closing the least recently written file as needed.
+func New(outputDir string, bufferSize, rotateMinutes, rotateMegabytes, minFreeMegabytes, spillSize, idleMinutes, maxOpenFiles int) *Recorder {
 	return &Recorder{
 		outputDir:       outputDir,
 		bufferSize:      bufferSize,
 		rotateMinutes:   rotateMinutes,
 		rotateMegabytes: int64(rotateMegabytes) * 1024 * 1024,
-		currentFiles:    make(map[string]*fileWriter),
+		minFreeBytes:    int64(minFreeMegabytes) * 1024 * 1024,
+		idleMinutes:     idleMinutes,
+		maxOpenFiles:    maxOpenFiles,
+		shards:          newShards(1),
+		spill:           newSpillBuffer(spillSize),
+		rotations:       rotationLog{counts: make(map[string]*rotationCounts)},
+	}
+}
+
+// EnableStats counts every received message in reg. It must be called
+// before Start.
+func (r *Recorder) EnableStats(reg *stats.Registry) {
+	r.stats = reg
+}
+
+// EnableRotationOverrides lets channels rotate on their own schedule.
+// rotationFor returns a channel's rotation minutes and megabytes; zero
+// values fall back to the recorder's defaults. It must be called before
+// Start.
+func (r *Recorder) EnableRotationOverrides(rotationFor func(platform, channel string) (minutes, megabytes int)) {
+	r.rotationFor = rotationFor
+}
+
+// EnableRotationJitter shortens each file's time limit by a random amount
+// of up to percent, so channels whose files opened together rotate at
+// different times instead of all uploading at once. It must be called
+// before Start.
+func (r *Recorder) EnableRotationJitter(percent int) {
+	r.rotateJitter = float64(percent) / 100
+}
+
+// rotation returns the rotation limits for a file
+func (r *Recorder) rotation(fw *fileWriter) (minutes int, maxBytes int64) {
+	minutes, maxBytes = r.rotateMinutes, r.rotateMegabytes
+	if r.rotationFor == nil {
+		return minutes, maxBytes
+	}
+	m, mb := r.rotationFor(fw.platform, fw.channel)
+	if m > 0 {
+		minutes = m
+	}
+	if mb > 0 {
+		maxBytes = int64(mb) * 1024 * 1024
 	}
+	return minutes, maxBytes
 }
 
-// Start begins recording messages
-func (r *Recorder) Start(ctx context.Context, messageChan <-chan message.Message, fileChan chan<- string) error {
+// EnableStatus reports open files, flushes and degraded mode to comp. It
+// must be called before Start.
+func (r *Recorder) EnableStatus(comp *status.Component) {
+	r.status = comp
+}
+
+// Status returns an error describing why the recorder is degraded, or nil
+// if it is writing normally
+func (r *Recorder) Status() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.degraded {
+		return fmt.Errorf("recording paused: %s (%d messages spilled to memory, %d dropped)",
+			r.degradedReason, r.spill.count, r.spill.dropped)
+	}
+	return nil
+}
+
+// OpenFiles returns how many files are open for writing
+func (r *Recorder) OpenFiles() int64 {
+	return r.openFiles.Load()
+}
+
+// Start begins recording messages. It returns when the context is
+// cancelled, or when messageChan is closed and every file has been closed
+// and handed to the uploader.
+func (r *Recorder) Start(ctx context.Context, messageChan <-chan message.Message, fileChan chan<- FileInfo) error {
 	// Create output directory
 	if err := os.MkdirAll(r.outputDir, 0755); err != nil {
 		return fmt.Errorf("create output directory: %w", err)
 	}
 
-	// Set up ticker for rotation checks
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
+	r.reopenResumed()
+
+	diskTicker := time.NewTicker(diskCheckInterval)
+	defer diskTicker.Stop()
+	r.checkDisk(fileChan)
+	if !r.isDegraded() {
+		r.status.SetState(status.StateRunning)
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range r.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.runShard(ctx, s, fileChan)
+		}()
+	}
 
 	for {
 		select {
-		case msg := <-messageChan:
-			if err := r.recordMessage(msg); err != nil {
-				log.Printf("Error recording message: %v", err)
+		case msg, ok := <-messageChan:
+			if !ok {
+				log.Println("Recorder input drained, closing files...")
+				r.draining.Store(true)
+				for _, s := range r.shards {
+					close(s.in)
+				}
+				wg.Wait()
+				r.finish()
+				return nil
+			}
+			if r.stats != nil && msg.Type == "" {
+				r.stats.Observe(msg.Platform, msg.Channel)
+			}
+			select {
+			case r.shardFor(msg.Platform, msg.Channel).in <- msg:
+			case <-ctx.Done():
 			}
 
-		case <-ticker.C:
-			r.checkRotation(fileChan)
+		case <-diskTicker.C:
+			r.checkDisk(fileChan)
 
 		case <-ctx.Done():
 			log.Println("Recorder shutting down, flushing buffers...")
-			r.flushAll(fileChan)
+			wg.Wait()
+			r.finish()
 			return ctx.Err()
 		}
 	}
 }
 
-// recordMessage records a single message
-func (r *Recorder) recordMessage(msg message.Message) error {
+// finish reports anything left unwritten once every shard has closed its
+// files
+func (r *Recorder) finish() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if r.degraded && r.spill.count > 0 {
+		log.Printf("Warning: %d spilled messages were not written before shutdown", r.spill.count)
+	}
+	r.saveResume()
+	log.Println("All files flushed and closed")
+	r.status.SetState(status.StateStopped)
+}
+
+// isDegraded reports whether writing is paused
+func (r *Recorder) isDegraded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.degraded
+}
+
+// recordMessage records a single message in its shard
+func (r *Recorder) recordMessage(s *shard, msg message.Message, fileChan chan<- FileInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.mu.Lock()
+	if r.degraded {
+		r.spill.push(msg)
+		r.mu.Unlock()
+		return nil
+	}
+	r.mu.Unlock()
+
+	return r.writeMessage(s, msg, fileChan)
+}
+
+// writeMessage buffers a message in its file writer; the caller must hold
+// s.mu
+func (r *Recorder) writeMessage(s *shard, msg message.Message, fileChan chan<- FileInfo) error {
 	key := fmt.Sprintf("%s_%s", msg.Platform, msg.Channel)
-	fw := r.currentFiles[key]
+	fw := s.files[key]
 
 	// Create new file writer if needed
 	if fw == nil {
+		// Make room if every shard together is at the open file limit
+		if !r.reserveFile() {
+			r.evictForRoom(s, fileChan)
+			if !r.reserveFile() {
+				// No file could be closed; go over rather than drop chat
+				r.openFiles.Add(1)
+			}
+		}
+
 		var err error
 		fw, err = r.createFileWriter(msg.Platform, msg.Channel)
 		if err != nil {
+			r.status.Set("open_files", r.openFiles.Add(-1))
 			return fmt.Errorf("create file writer: %w", err)
 		}
-		r.currentFiles[key] = fw
+		s.files[key] = fw
+		r.status.Set("open_files", r.openFiles.Load())
 	}
 
 	// Add message to buffer
+	if !r.reserve(fw, recordSize(&msg)) {
+		return nil
+	}
 	fw.messageBuffer = append(fw.messageBuffer, msg)
+	fw.lastMessage = time.Now()
+	fw.trackTimestamps(msg)
+	fw.countReceived(msg)
+	r.countChatter(fw, msg)
+	r.countEmotes(fw, msg)
+	r.indexRecord(fw, msg)
 
 	// Flush if buffer is full
 	if len(fw.messageBuffer) >= r.bufferSize {
@@ -110,148 +375,431 @@ func (r *Recorder) recordMessage(msg message.Message) error {
 
 // createFileWriter creates a new file writer
 func (r *Recorder) createFileWriter(platform, channel string) (*fileWriter, error) {
-	timestamp := time.Now().UTC().Format("20060102_1504")
-	filename := fmt.Sprintf("%s_%s_%s.jsonl", platform, channel, timestamp)
-	filepath := filepath.Join(r.outputDir, filename)
+	// Names become paths, so one that could leave the output directory is
+	// refused whatever sent it
+	if err := message.CheckName(platform); err != nil {
+		return nil, fmt.Errorf("platform: %w", err)
+	}
+	if err := message.CheckName(channel); err != nil {
+		return nil, fmt.Errorf("channel: %w", err)
+	}
 
-	file, err := os.Create(filepath)
+	createdAt := time.Now().UTC()
+	ext := ".jsonl"
+	if len(r.recipients) > 0 {
+		ext += EncryptedExt
+	} else if r.frameDuration > 0 {
+		ext += seekable.Ext
+	}
+
+	dir, err := r.fileDir(platform, channel, createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never reuse a name: a file from earlier this minute (rotated, or left
+	// by a previous run) may still be waiting for upload. Seconds, then
+	// nanoseconds, tell apart files opened in the same minute; ParseFileInfo
+	// reads all three.
+	var file *os.File
+	var filename string
+	for _, layout := range []string{"20060102_1504", "20060102_150405", "20060102_150405.000000000"} {
+		filename = filepath.Join(dir, fmt.Sprintf("%s_%s_%s%s", platform, channel, createdAt.Format(layout), ext))
+		file, err = os.OpenFile(filepath.Join(r.outputDir, filename), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("create file: %w", err)
 	}
 
+	var out io.Writer = file
+	var encrypter io.WriteCloser
+	if len(r.recipients) > 0 {
+		encrypter, err = age.Encrypt(file, r.recipients...)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("encrypt file: %w", err)
+		}
+		out = encrypter
+	}
+	var frames *seekable.Writer
+	if r.frameDuration > 0 && encrypter == nil {
+		frames = seekable.NewWriter(file, r.frameDuration)
+		out = frames
+	}
+
 	log.Printf("Created new log file: %s", filename)
 
-	return &fileWriter{
+	fw := &fileWriter{
 		file:          file,
-		writer:        bufio.NewWriter(file),
-		createdAt:     time.Now(),
+		encrypter:     encrypter,
+		frames:        frames,
+		writer:        bufio.NewWriter(out),
+		createdAt:     createdAt,
+		lastMessage:   time.Now(),
 		bytesWritten:  0,
 		messageBuffer: make([]message.Message, 0, r.bufferSize),
 		platform:      platform,
 		channel:       channel,
 		filename:      filename,
-	}, nil
+		jitter:        r.rotateJitter * rand.Float64(),
+		chatters:      make(map[string]*message.ChatterCount),
+		received:      make(map[string]int64),
+	}
+	if err := r.writeHeader(fw); err != nil {
+		fw.close(false)
+		return nil, err
+	}
+	return fw, nil
+}
+
+// encode writes a record to the file's buffered writer. The encoder writes
+// the same JSON as Marshal, plus the newline, in a single write from its
+// own pooled buffer: a record that fails to marshal writes nothing, and
+// none is copied on the way to the file.
+func (fw *fileWriter) encode(msg *message.Message) error {
+	if fw.encoder == nil {
+		fw.encoder = json.NewEncoder(countingWriter{fw})
+	}
+	return fw.encoder.Encode(msg)
 }
 
 // flushFileWriter writes buffered messages to disk
 func (r *Recorder) flushFileWriter(fw *fileWriter) error {
-	for _, msg := range fw.messageBuffer {
-		data, err := json.Marshal(msg)
-		if err != nil {
-			log.Printf("Error marshaling message: %v", err)
-			continue
-		}
-
-		n, err := fw.writer.Write(data)
-		if err != nil {
+	for i := range fw.messageBuffer {
+		if err := fw.encode(&fw.messageBuffer[i]); err != nil {
+			var marshalErr *json.UnsupportedValueError
+			var typeErr *json.UnsupportedTypeError
+			var jsonErr *json.MarshalerError
+			if errors.As(err, &marshalErr) || errors.As(err, &typeErr) || errors.As(err, &jsonErr) {
+				log.Printf("Error marshaling message: %v", err)
+				continue
+			}
 			return fmt.Errorf("write message: %w", err)
 		}
-		fw.bytesWritten += int64(n)
-
-		if err := fw.writer.WriteByte('\n'); err != nil {
-			return fmt.Errorf("write newline: %w", err)
-		}
-		fw.bytesWritten += 1
+		fw.messageCount++
 	}
 
 	// Clear buffer
 	fw.messageBuffer = fw.messageBuffer[:0]
+	r.release(fw)
 
 	// Flush to disk
-	return fw.writer.Flush()
+	if err := fw.writer.Flush(); err != nil {
+		return err
+	}
+	if fw.frames != nil {
+		if err := fw.frames.Flush(); err != nil {
+			return err
+		}
+	}
+	fw.unsynced = true
+	if r.syncPolicy == SyncEveryFlush {
+		if err := fw.sync(); err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	r.status.Set("last_flush", time.Now().UTC())
+	return nil
 }
 
-// checkRotation checks if any files need rotation
-func (r *Recorder) checkRotation(fileChan chan<- string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// close finishes any encryption or seekable index and closes the
+// underlying file, syncing it first if sync is set
+func (fw *fileWriter) close(sync bool) error {
+	if fw.frames != nil {
+		if err := fw.frames.Close(); err != nil {
+			fw.file.Close()
+			return err
+		}
+	}
+	if fw.encrypter != nil {
+		if err := fw.encrypter.Close(); err != nil {
+			fw.file.Close()
+			return err
+		}
+	}
+	if sync {
+		fw.unsynced = true // The trailers above bypass the flush
+		if err := fw.sync(); err != nil {
+			fw.file.Close()
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	return fw.file.Close()
+}
 
-	for key, fw := range r.currentFiles {
-		needsRotation := false
+// countReceived counts a record under the minute it was received, cut from
+// its received_at rather than parsed
+func (fw *fileWriter) countReceived(msg message.Message) {
+	if len(msg.ReceivedAt) >= len(stats.ReceivedMinuteLayout) {
+		fw.received[msg.ReceivedAt[:len(stats.ReceivedMinuteLayout)]]++
+	}
+}
 
-		// Check time-based rotation
-		if time.Since(fw.createdAt).Minutes() >= float64(r.rotateMinutes) {
-			needsRotation = true
-			log.Printf("Rotating file %s (time limit)", fw.filename)
-		}
+// fileInfo builds the metadata for a file writer that is being closed
+func (r *Recorder) fileInfo(fw *fileWriter) FileInfo {
+	return FileInfo{
+		Path:         filepath.Join(r.outputDir, fw.filename),
+		Platform:     fw.platform,
+		Channel:      fw.channel,
+		StartTime:    fw.createdAt,
+		EndTime:      time.Now().UTC(),
+		MessageCount: fw.messageCount,
+		Bytes:        fw.bytesWritten,
+
+		ReceivedMinutes: fw.received,
+	}
+}
 
-		// Check size-based rotation
-		if fw.bytesWritten >= r.rotateMegabytes {
-			needsRotation = true
-			log.Printf("Rotating file %s (size limit)", fw.filename)
-		}
+// checkDisk enters or leaves degraded mode based on free disk space
+func (r *Recorder) checkDisk(fileChan chan<- FileInfo) {
+	free, err := diskFree(r.outputDir)
+	if err != nil {
+		return
+	}
 
-		if needsRotation {
-			r.rotateFile(key, fw, fileChan)
+	degraded := r.isDegraded()
+	if !degraded && free < r.minFreeBytes {
+		r.enterDegraded(fmt.Sprintf("low disk space (%d MB free)", free/1024/1024), fileChan)
+	} else if degraded && free >= r.minFreeBytes+resumeHeadroom {
+		r.resume(fileChan)
+	}
+}
+
+// enterDegraded stops writing to disk. Buffered messages are moved to the
+// spill buffer and open files are closed and queued for upload, which also
+// frees space when delete_after_upload is enabled.
+func (r *Recorder) enterDegraded(reason string, fileChan chan<- FileInfo) {
+	r.mu.Lock()
+	if r.degraded {
+		r.mu.Unlock()
+		return
+	}
+	log.Printf("CRITICAL: Pausing recording: %s", reason)
+	r.degraded = true
+	r.degradedReason = reason
+	r.status.SetState(status.StateDegraded)
+	r.status.Set("degraded_reason", reason)
+	r.mu.Unlock()
+
+	// Shards see degraded once they next take their lock, so nothing is
+	// written to the files after they are closed here
+	for _, s := range r.shards {
+		s.mu.Lock()
+		// resume holds every shard while it writes out the spill, so if it
+		// ran since, the rest of the files hold that batch and stay open
+		if !r.isDegraded() {
+			s.mu.Unlock()
+			break
 		}
+		for key, fw := range s.files {
+			fw.closingAt = time.Now()
+			// Keep whatever couldn't be written; the bufio.Writer is unusable
+			// after a failed write, so it is discarded either way
+			if err := r.flushFileWriter(fw); err != nil {
+				r.mu.Lock()
+				for _, msg := range fw.messageBuffer {
+					r.spill.push(msg)
+				}
+				r.mu.Unlock()
+			}
+			r.release(fw)
+			if err := fw.close(r.syncPolicy != SyncNever); err != nil {
+				log.Printf("Error closing file: %v", err)
+			}
+			r.queueUpload(fw, fileChan, ReasonDiskFull)
+			delete(s.files, key)
+			r.openFiles.Add(-1)
+		}
+		s.mu.Unlock()
 	}
+	r.status.Set("open_files", r.openFiles.Load())
 }
 
-// rotateFile closes current file and creates a new one
-func (r *Recorder) rotateFile(key string, fw *fileWriter, fileChan chan<- string) {
-	// Flush remaining buffer
-	if err := r.flushFileWriter(fw); err != nil {
-		log.Printf("Error flushing file writer during rotation: %v", err)
+// resume leaves degraded mode and writes out the spilled messages. Every
+// shard is held until they are written, so they stay ahead of newer
+// messages.
+func (r *Recorder) resume(fileChan chan<- FileInfo) {
+	for _, s := range r.shards {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	r.mu.Lock()
+	spilled := r.spill.drain()
+	log.Printf("Disk space recovered, resuming recording (%d spilled messages, %d dropped)",
+		len(spilled), r.spill.dropped)
+
+	r.degraded = false
+	r.degradedReason = ""
+	r.spill.dropped = 0
+	r.status.SetState(status.StateRunning)
+	r.status.Set("degraded_reason", "")
+	r.mu.Unlock()
+
+	for _, msg := range spilled {
+		if err := r.writeMessage(r.shardFor(msg.Platform, msg.Channel), msg, fileChan); err != nil {
+			log.Printf("Error recording spilled message: %v", err)
+		}
 	}
+}
 
-	// Close file
-	if err := fw.writer.Flush(); err != nil {
-		log.Printf("Error flushing writer during rotation: %v", err)
+// queueUpload records the rotation of a closed file and sends it to the
+// uploader without blocking. Files that never received a message are
+// deleted instead.
+func (r *Recorder) queueUpload(fw *fileWriter, fileChan chan<- FileInfo, reason string) {
+	if fw.messageCount == 0 {
+		path := filepath.Join(r.outputDir, fw.filename)
+		if err := os.Remove(path); err != nil {
+			log.Printf("Error removing empty file %s: %v", fw.filename, err)
+		}
+		PruneDirs(r.outputDir, path)
+		return
 	}
-	if err := fw.file.Close(); err != nil {
-		log.Printf("Error closing file during rotation: %v", err)
+	r.recordRotation(fw, reason)
+
+	if r.draining.Load() {
+		// Shutting down: wait for the uploader rather than leaving it behind
+		fileChan <- r.fileInfo(fw)
+		log.Printf("Queued file for upload: %s", fw.filename)
+		return
 	}
 
-	// Send filepath to uploader
-	filepath := filepath.Join(r.outputDir, fw.filename)
 	select {
-	case fileChan <- filepath:
+	case fileChan <- r.fileInfo(fw):
 		log.Printf("Queued file for upload: %s", fw.filename)
 	default:
 		log.Printf("Warning: upload queue full, file will be uploaded later: %s", fw.filename)
 	}
+}
 
-	// Create new file
-	newFw, err := r.createFileWriter(fw.platform, fw.channel)
-	if err != nil {
-		log.Printf("Error creating new file writer: %v", err)
-		delete(r.currentFiles, key)
-		return
-	}
+// checkRotation checks if any of a shard's files need rotation
+func (r *Recorder) checkRotation(s *shard, fileChan chan<- FileInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	r.currentFiles[key] = newFw
-}
+	for key, fw := range s.files {
+		reason := ""
+		rotateMinutes, rotateBytes := r.rotation(fw)
 
-// flushAll flushes all file writers and closes files
-func (r *Recorder) flushAll(fileChan chan<- string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+		// Check time-based rotation
+		if time.Since(fw.createdAt).Minutes() >= float64(rotateMinutes)*(1-fw.jitter) {
+			reason = ReasonTime
+			log.Printf("Rotating file %s (time limit)", fw.filename)
+		}
 
-	for key, fw := range r.currentFiles {
-		// Flush buffer
-		if err := r.flushFileWriter(fw); err != nil {
-			log.Printf("Error flushing file writer: %v", err)
+		// Check size-based rotation
+		if fw.bytesWritten >= rotateBytes {
+			reason = ReasonSize
+			log.Printf("Rotating file %s (size limit)", fw.filename)
 		}
 
-		// Close file
-		if err := fw.writer.Flush(); err != nil {
-			log.Printf("Error flushing writer: %v", err)
+		if reason != "" {
+			r.rotateFile(s, key, fw, fileChan, reason)
+			continue
 		}
-		if err := fw.file.Close(); err != nil {
-			log.Printf("Error closing file: %v", err)
+
+		// Close files for channels that have gone quiet
+		if r.idleMinutes > 0 && time.Since(fw.lastMessage).Minutes() >= float64(r.idleMinutes) {
+			log.Printf("Closing idle file %s (no messages for %d minutes)", fw.filename, r.idleMinutes)
+			r.closeFileWriter(s, key, fw, fileChan, ReasonIdle)
 		}
+	}
+}
 
-		// Send to uploader
-		filepath := filepath.Join(r.outputDir, fw.filename)
-		select {
-		case fileChan <- filepath:
-			log.Printf("Queued final file for upload: %s", fw.filename)
-		default:
-			log.Printf("Warning: upload queue full for final file: %s", fw.filename)
+// reserveFile counts a file about to be opened, reporting false instead if
+// maxOpenFiles are open across all shards
+func (r *Recorder) reserveFile() bool {
+	for {
+		n := r.openFiles.Load()
+		if r.maxOpenFiles > 0 && n >= int64(r.maxOpenFiles) {
+			return false
 		}
+		if r.openFiles.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
 
-		delete(r.currentFiles, key)
+// evictForRoom closes the least recently written file of the shard, or of
+// another shard if it has none; the caller must hold s.mu. Other shards'
+// locks are only tried, never waited for, so two shards making room at
+// once can't deadlock.
+func (r *Recorder) evictForRoom(s *shard, fileChan chan<- FileInfo) {
+	if len(s.files) > 0 {
+		r.evictLeastRecent(s, fileChan)
+		return
 	}
+	for _, other := range r.shards {
+		if other == s || !other.mu.TryLock() {
+			continue
+		}
+		evicted := len(other.files) > 0
+		if evicted {
+			r.evictLeastRecent(other, fileChan)
+		}
+		other.mu.Unlock()
+		if evicted {
+			return
+		}
+	}
+}
 
-	log.Println("All files flushed and closed")
+// evictLeastRecent closes the shard's file that was written to least
+// recently; the caller must hold s.mu
+func (r *Recorder) evictLeastRecent(s *shard, fileChan chan<- FileInfo) {
+	var oldestKey string
+	var oldest *fileWriter
+	for key, fw := range s.files {
+		if oldest == nil || fw.lastMessage.Before(oldest.lastMessage) {
+			oldestKey, oldest = key, fw
+		}
+	}
+	if oldest == nil {
+		return
+	}
+
+	log.Printf("Closing %s (open file limit of %d reached)", oldest.filename, r.maxOpenFiles)
+	r.closeFileWriter(s, oldestKey, oldest, fileChan, ReasonEvicted)
+}
+
+// closeFileWriter flushes and closes a file, queues it for upload and
+// removes it from the open set; the caller must hold s.mu
+func (r *Recorder) closeFileWriter(s *shard, key string, fw *fileWriter, fileChan chan<- FileInfo, reason string) {
+	fw.closingAt = time.Now()
+	r.appendSummary(fw)
+	if err := r.flushFileWriter(fw); err != nil {
+		log.Printf("Error flushing file writer: %v", err)
+	}
+	r.release(fw)
+	if err := fw.close(r.syncPolicy != SyncNever); err != nil {
+		log.Printf("Error closing file: %v", err)
+	}
+
+	r.queueUpload(fw, fileChan, reason)
+	r.writeEmoteStats(fw, fileChan)
+	r.writeSearchIndex(fw, fileChan)
+	delete(s.files, key)
+	r.status.Set("open_files", r.openFiles.Add(-1))
+}
+
+// rotateFile closes the current file; a new one is created when the
+// channel's next message arrives
+func (r *Recorder) rotateFile(s *shard, key string, fw *fileWriter, fileChan chan<- FileInfo, reason string) {
+	r.closeFileWriter(s, key, fw, fileChan, reason)
+}
+
+// flushAll flushes and closes all of a shard's files at shutdown
+func (r *Recorder) flushAll(s *shard, fileChan chan<- FileInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, fw := range s.files {
+		if r.appendAfterRestart {
+			r.suspendFileWriter(s, key, fw)
+			continue
+		}
+		r.closeFileWriter(s, key, fw, fileChan, ReasonShutdown)
+	}
 }
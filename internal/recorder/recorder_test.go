@@ -0,0 +1,115 @@
+package recorder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+func TestCreateFileWriterNeverReusesNames(t *testing.T) {
+	dir := t.TempDir()
+	r := New(dir, 10, 60, 10, 0, 0, -1, 0)
+
+	// Left by a previous run, maybe still waiting for upload
+	stale := filepath.Join(dir, "twitch_chan_"+time.Now().UTC().Format("20060102_1504")+".jsonl")
+	if err := os.WriteFile(stale, []byte("keep me\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		fw, err := r.createFileWriter("twitch", "chan")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.file.Close()
+		if seen[fw.filename] {
+			t.Fatalf("%s was created twice", fw.filename)
+		}
+		seen[fw.filename] = true
+
+		info, err := ParseFileInfo(filepath.Join(dir, fw.filename))
+		if err != nil {
+			t.Fatalf("ParseFileInfo(%s): %v", fw.filename, err)
+		}
+		if info.Platform != "twitch" || info.Channel != "chan" || info.StartTime.IsZero() {
+			t.Errorf("ParseFileInfo(%s) = %+v", fw.filename, info)
+		}
+	}
+
+	if data, _ := os.ReadFile(stale); string(data) != "keep me\n" {
+		t.Error("an existing file was truncated")
+	}
+}
+
+func TestCreateFileWriterRejectsUnsafeNames(t *testing.T) {
+	r := New(t.TempDir(), 10, 60, 10, 0, 0, -1, 0)
+	r.EnableNestedLayout()
+	for _, name := range []string{"..", "../escape", "a/b"} {
+		if _, err := r.createFileWriter("twitch", name); err == nil {
+			t.Errorf("created a file for channel %q", name)
+		}
+		if _, err := r.createFileWriter(name, "chan"); err == nil {
+			t.Errorf("created a file for platform %q", name)
+		}
+	}
+}
+
+func TestResumedFilesGetNoEmoteStats(t *testing.T) {
+	dir := t.TempDir()
+	r := New(dir, 10, 60, 10, 0, 0, -1, 0)
+	r.EnableEmoteStats(false)
+
+	fw, err := r.createFileWriter("twitch", "chan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fw.file.Close()
+	fw.resumed = true
+	fw.messageCount = 1
+	r.countEmotes(fw, message.Message{Platform: "twitch", Channel: "chan", Emotes: []string{"Kappa"}})
+
+	files := make(chan FileInfo, 1)
+	r.writeEmoteStats(fw, files)
+	if len(files) != 0 {
+		t.Errorf("queued %+v for a resumed file", <-files)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.emotes.json")); len(matches) != 0 {
+		t.Errorf("wrote %v for a resumed file", matches)
+	}
+}
+
+func TestOpenFileLimitSpansShards(t *testing.T) {
+	r := New(t.TempDir(), 10, 60, 10, 0, 0, -1, 3)
+	r.EnableShards(4)
+	files := make(chan FileInfo, 100)
+
+	for i := 0; i < 10; i++ {
+		msg := message.New("twitch", time.Now())
+		msg.Channel = fmt.Sprintf("chan%d", i)
+		s := r.shardFor(msg.Platform, msg.Channel)
+		s.mu.Lock()
+		err := r.writeMessage(s, msg, files)
+		s.mu.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := r.OpenFiles(); n > 3 {
+			t.Fatalf("%d files open after %d channels, want at most 3", n, i+1)
+		}
+	}
+	open := 0
+	for _, s := range r.shards {
+		open += len(s.files)
+		for _, fw := range s.files {
+			fw.file.Close()
+		}
+	}
+	if open != 3 {
+		t.Errorf("shards hold %d files, want 3", open)
+	}
+}
@@ -0,0 +1,121 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/john/chatlog/internal/seekable"
+)
+
+// recoverTailSize is how much of a file's end is read to find its last
+// complete line
+const recoverTailSize = 1024 * 1024
+
+// Recover finalizes files left in dir by a previous run that stopped
+// without closing them. A partial last line from a crash is truncated and
+// files with no complete message are removed, so the startup scan uploads
+// only valid JSONL. Encrypted files can't be checked without the identity
+// and are left as they are. Seekable files keep their complete frames and
+// get a rebuilt index. It must be called before the recorder starts.
+func Recover(dir string) error {
+	paths, err := LocalFiles(dir)
+	if err != nil {
+		return fmt.Errorf("read output directory: %w", err)
+	}
+
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if strings.HasSuffix(name, ".jsonl"+SeekableExt) {
+			recoverSeekable(path)
+			continue
+		}
+		if !strings.HasSuffix(name, ".jsonl") {
+			continue
+		}
+		truncated, size, err := repairFile(path)
+		switch {
+		case err != nil:
+			log.Printf("Warning: Could not check %s: %v", name, err)
+		case size == 0:
+			log.Printf("Removing %s: no complete messages", name)
+			if err := os.Remove(path); err != nil {
+				log.Printf("Error removing %s: %v", name, err)
+			}
+		case truncated > 0:
+			log.Printf("Recovered %s: truncated %d byte(s) of partial message", name, truncated)
+		}
+	}
+	return nil
+}
+
+// recoverSeekable repairs a seekable file, removing it if it holds no
+// complete message
+func recoverSeekable(path string) {
+	records, err := seekable.Repair(path)
+	switch {
+	case err != nil:
+		log.Printf("Warning: Could not check %s: %v", filepath.Base(path), err)
+	case records == 0:
+		log.Printf("Removing %s: no complete messages", filepath.Base(path))
+		if err := os.Remove(path); err != nil {
+			log.Printf("Error removing %s: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+// repairFile truncates a JSONL file after its last complete, valid line.
+// It returns how many bytes were removed and the resulting size.
+func repairFile(path string) (truncated, size int64, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size = stat.Size()
+
+	offset := max(size-recoverTailSize, 0)
+	tail := make([]byte, size-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return 0, size, err
+	}
+
+	// Drop trailing bytes until the tail ends with a complete JSON line
+	end := len(tail)
+	for end > 0 {
+		if tail[end-1] != '\n' {
+			end = bytes.LastIndexByte(tail[:end], '\n') + 1
+			continue
+		}
+		start := bytes.LastIndexByte(tail[:end-1], '\n') + 1
+		if start == 0 && offset > 0 {
+			break // Line starts before the tail; assume it is intact
+		}
+		if json.Valid(tail[start : end-1]) {
+			break
+		}
+		end = start
+	}
+
+	if end == 0 && offset > 0 {
+		return 0, size, fmt.Errorf("last line is over %d bytes", recoverTailSize)
+	}
+	newSize := offset + int64(end)
+	if newSize == size {
+		return 0, size, nil
+	}
+	if err := file.Truncate(newSize); err != nil {
+		return 0, size, fmt.Errorf("truncate: %w", err)
+	}
+	return size - newSize, newSize, nil
+}
@@ -0,0 +1,174 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// resumeManifest lists the files left open at shutdown. It is written to
+// the output directory and removed when read back at the next start.
+const resumeManifest = ".resume.json"
+
+// resumeEntry is a file left open at shutdown to be appended to
+type resumeEntry struct {
+	File           string    `json:"file"`
+	Platform       string    `json:"platform"`
+	Channel        string    `json:"channel"`
+	OpenedAt       time.Time `json:"opened_at"`
+	Records        int64     `json:"records"`
+	Bytes          int64     `json:"bytes"`
+	FirstTimestamp string    `json:"first_timestamp,omitempty"`
+	LastTimestamp  string    `json:"last_timestamp,omitempty"`
+}
+
+// EnableAppend leaves open files unfinalized at shutdown, so the next run
+// can append to them with Resume instead of starting new ones. Only plain
+// JSONL files can be appended to. It must be called before Start.
+func (r *Recorder) EnableAppend() {
+	r.appendAfterRestart = true
+}
+
+// Resume reads the files the previous run left open and adopts those still
+// within their rotation limits: each is reopened for appending when the
+// recorder starts. A file that changed since shutdown, or whose rotation
+// time or size has been reached, is left for the startup scan. It returns
+// the adopted files' paths, which the scan must skip. It must be called
+// before Start.
+func (r *Recorder) Resume() ([]string, error) {
+	manifestPath := filepath.Join(r.outputDir, resumeManifest)
+	data, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read resume manifest: %w", err)
+	}
+	if err := os.Remove(manifestPath); err != nil {
+		return nil, fmt.Errorf("remove resume manifest: %w", err)
+	}
+
+	var entries []resumeEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse resume manifest: %w", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		path := filepath.Join(r.outputDir, entry.File)
+		minutes, maxBytes := r.rotation(&fileWriter{platform: entry.Platform, channel: entry.Channel})
+
+		stat, err := os.Stat(path)
+		switch {
+		case err != nil:
+			continue
+		case stat.Size() != entry.Bytes:
+			log.Printf("Not appending to %s: changed since shutdown", entry.File)
+			continue
+		case time.Since(entry.OpenedAt).Minutes() >= float64(minutes) || entry.Bytes >= maxBytes:
+			log.Printf("Not appending to %s: rotation limit reached", entry.File)
+			continue
+		}
+		r.resumable = append(r.resumable, entry)
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// reopenResumed reopens the files adopted by Resume in their shards
+func (r *Recorder) reopenResumed() {
+	for _, entry := range r.resumable {
+		file, err := os.OpenFile(filepath.Join(r.outputDir, entry.File), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			log.Printf("Error reopening %s, it will be uploaded at the next start: %v", entry.File, err)
+			continue
+		}
+
+		s := r.shardFor(entry.Platform, entry.Channel)
+		s.mu.Lock()
+		s.files[fmt.Sprintf("%s_%s", entry.Platform, entry.Channel)] = &fileWriter{
+			file:           file,
+			writer:         bufio.NewWriter(file),
+			createdAt:      entry.OpenedAt,
+			lastMessage:    time.Now(),
+			bytesWritten:   entry.Bytes,
+			messageCount:   entry.Records,
+			messageBuffer:  make([]message.Message, 0, r.bufferSize),
+			platform:       entry.Platform,
+			channel:        entry.Channel,
+			filename:       entry.File,
+			chatters:       make(map[string]*message.ChatterCount),
+			received:       make(map[string]int64),
+			firstTimestamp: entry.FirstTimestamp,
+			lastTimestamp:  entry.LastTimestamp,
+			resumed:        true,
+		}
+		s.mu.Unlock()
+		r.status.Set("open_files", r.openFiles.Add(1))
+		log.Printf("Appending to %s from before restart", entry.File)
+	}
+	r.resumable = nil
+}
+
+// suspendFileWriter flushes and closes a file without finalizing it, to be
+// listed in the resume manifest; the caller must hold s.mu
+func (r *Recorder) suspendFileWriter(s *shard, key string, fw *fileWriter) {
+	if err := r.flushFileWriter(fw); err != nil {
+		log.Printf("Error flushing file writer: %v", err)
+	}
+	r.release(fw)
+	if err := fw.close(r.syncPolicy != SyncNever); err != nil {
+		log.Printf("Error closing file: %v", err)
+	}
+	delete(s.files, key)
+	r.status.Set("open_files", r.openFiles.Add(-1))
+
+	if fw.messageCount == 0 {
+		if err := os.Remove(filepath.Join(r.outputDir, fw.filename)); err != nil {
+			log.Printf("Error removing empty file %s: %v", fw.filename, err)
+		}
+		return
+	}
+
+	r.mu.Lock()
+	r.suspended = append(r.suspended, resumeEntry{
+		File:           fw.filename,
+		Platform:       fw.platform,
+		Channel:        fw.channel,
+		OpenedAt:       fw.createdAt,
+		Records:        fw.messageCount,
+		Bytes:          fw.bytesWritten,
+		FirstTimestamp: fw.firstTimestamp,
+		LastTimestamp:  fw.lastTimestamp,
+	})
+	r.mu.Unlock()
+	log.Printf("Left %s open to append to after restart", fw.filename)
+}
+
+// saveResume writes the resume manifest; the caller must hold r.mu
+func (r *Recorder) saveResume() {
+	if len(r.suspended) == 0 {
+		return
+	}
+	data, err := json.Marshal(r.suspended)
+	if err != nil {
+		log.Printf("Error saving resume manifest: %v", err)
+		return
+	}
+
+	path := filepath.Join(r.outputDir, resumeManifest)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Error saving resume manifest, open files will be uploaded at the next start: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("Error saving resume manifest, open files will be uploaded at the next start: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// Reasons a file is closed
+const (
+	ReasonTime     = "time"      // rotate_minutes reached
+	ReasonSize     = "size"      // rotate_megabytes reached
+	ReasonIdle     = "idle"      // No messages for idle_minutes
+	ReasonEvicted  = "evicted"   // Closed to stay under max_open_files
+	ReasonDiskFull = "disk_full" // Recording paused for disk space
+	ReasonShutdown = "shutdown"
+)
+
+// Rotation accounts for a closed file, so the archive can be reconciled
+// against what was recorded. Rotations are logged as JSON, counted in the
+// recorder's metrics and, when enabled, appended to the rotation index.
+type Rotation struct {
+	File           string `json:"file"`
+	Platform       string `json:"platform"`
+	Channel        string `json:"channel"`
+	Reason         string `json:"reason"`
+	Records        int64  `json:"records"`       // Lines in the file, including non-chat records
+	Bytes          int64  `json:"bytes"`         // Size on disk, as the uploaded object will be
+	WrittenBytes   int64  `json:"written_bytes"` // JSONL bytes before compression or encryption
+	FirstTimestamp string `json:"first_timestamp,omitempty"`
+	LastTimestamp  string `json:"last_timestamp,omitempty"`
+	OpenedAt       string `json:"opened_at"`
+	ClosedAt       string `json:"closed_at"`
+
+	// Time spent flushing and finalizing the file, including its summary,
+	// seekable index or encryption
+	CloseSeconds float64 `json:"close_seconds"`
+}
+
+// rotationCounts totals rotations by reason for metrics
+type rotationCounts struct {
+	files, records, bytes int64
+}
+
+// rotationLog holds the rotation index and metric counters
+type rotationLog struct {
+	indexPath string
+	counts    map[string]*rotationCounts
+	mu        sync.Mutex
+}
+
+// EnableRotationIndex appends a JSON line for every closed file to path.
+// It must be called before Start.
+func (r *Recorder) EnableRotationIndex(path string) {
+	r.rotations.indexPath = path
+}
+
+// trackTimestamps notes a record's timestamp as the file's first or last;
+// the caller must hold the shard's lock
+func (fw *fileWriter) trackTimestamps(msg message.Message) {
+	if fw.firstTimestamp == "" {
+		fw.firstTimestamp = msg.Timestamp
+	}
+	fw.lastTimestamp = msg.Timestamp
+}
+
+// recordRotation logs, counts and indexes a file that has been closed
+func (r *Recorder) recordRotation(fw *fileWriter, reason string) {
+	rotation := Rotation{
+		File:           fw.filename,
+		Platform:       fw.platform,
+		Channel:        fw.channel,
+		Reason:         reason,
+		Records:        fw.messageCount,
+		Bytes:          fw.bytesWritten,
+		WrittenBytes:   fw.bytesWritten,
+		FirstTimestamp: fw.firstTimestamp,
+		LastTimestamp:  fw.lastTimestamp,
+		OpenedAt:       message.FormatTime(fw.createdAt),
+		ClosedAt:       message.FormatTime(time.Now()),
+	}
+	if !fw.closingAt.IsZero() {
+		rotation.CloseSeconds = time.Since(fw.closingAt).Seconds()
+	}
+	if stat, err := os.Stat(filepath.Join(r.outputDir, fw.filename)); err == nil {
+		rotation.Bytes = stat.Size()
+	}
+
+	data, err := json.Marshal(rotation)
+	if err != nil {
+		return
+	}
+	log.Printf("Rotation: %s", data)
+
+	r.rotations.mu.Lock()
+	defer r.rotations.mu.Unlock()
+	counts := r.rotations.counts[reason]
+	if counts == nil {
+		counts = &rotationCounts{}
+		r.rotations.counts[reason] = counts
+	}
+	counts.files++
+	counts.records += rotation.Records
+	counts.bytes += rotation.Bytes
+
+	if r.rotations.indexPath != "" {
+		if err := appendLine(r.rotations.indexPath, data); err != nil {
+			log.Printf("Error writing rotation index: %v", err)
+		}
+	}
+}
+
+// appendLine appends data and a newline to the file at path
+func appendLine(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteMetrics writes rotation totals by reason in the Prometheus text format
+func (r *Recorder) WriteMetrics(w io.Writer) {
+	r.rotations.mu.Lock()
+	reasons := make([]string, 0, len(r.rotations.counts))
+	counts := make(map[string]rotationCounts, len(r.rotations.counts))
+	for reason, c := range r.rotations.counts {
+		reasons = append(reasons, reason)
+		counts[reason] = *c
+	}
+	r.rotations.mu.Unlock()
+	sort.Strings(reasons)
+
+	for _, metric := range []struct {
+		name, help string
+		value      func(rotationCounts) int64
+	}{
+		{"chatlog_rotated_files_total", "Files closed by the recorder.", func(c rotationCounts) int64 { return c.files }},
+		{"chatlog_rotated_records_total", "Records in closed files.", func(c rotationCounts) int64 { return c.records }},
+		{"chatlog_rotated_bytes_total", "On-disk bytes of closed files.", func(c rotationCounts) int64 { return c.bytes }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", metric.name)
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "%s{reason=%q} %d\n", metric.name, reason, metric.value(counts[reason]))
+		}
+	}
+}
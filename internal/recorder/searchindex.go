@@ -0,0 +1,53 @@
+package recorder
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/search"
+)
+
+// EnableSearchIndex writes a search index sidecar for every chat file and
+// queues it for upload after the file, so searches can skip files that
+// can't match. It must be called before Start.
+func (r *Recorder) EnableSearchIndex() {
+	r.searchIndex = true
+}
+
+// indexRecord adds a record to its file's search index; the caller must
+// hold the shard's lock
+func (r *Recorder) indexRecord(fw *fileWriter, msg message.Message) {
+	if !r.searchIndex || fw.resumed {
+		return
+	}
+	if fw.search == nil {
+		fw.search = search.NewBuilder()
+	}
+	fw.search.Add(msg)
+}
+
+// writeSearchIndex writes the sidecar of a closed chat file and queues it
+// for upload; the caller must hold the shard's lock. Files appended to
+// after a restart get none, since an index missing their earlier records
+// would make searches skip them wrongly.
+func (r *Recorder) writeSearchIndex(fw *fileWriter, fileChan chan<- FileInfo) {
+	if fw.search == nil || fw.resumed || fw.messageCount == 0 {
+		return
+	}
+
+	idx := fw.search.Index(fw.platform, fw.channel, filepath.Base(fw.filename), fw.createdAt)
+	data, err := json.Marshal(idx)
+	if err != nil {
+		log.Printf("Error marshaling search index: %v", err)
+		return
+	}
+	path := filepath.Join(r.outputDir, search.IndexName(fw.filename))
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Printf("Error writing search index for %s: %v", fw.filename, err)
+		return
+	}
+	r.queueSidecar(fw, path, int64(len(data)+1), fileChan)
+}
@@ -0,0 +1,20 @@
+package recorder
+
+import (
+	"time"
+
+	"github.com/john/chatlog/internal/seekable"
+)
+
+// SeekableExt is the extension of seekable files, which are named
+// *.jsonl.gz
+const SeekableExt = seekable.Ext
+
+// EnableSeekable writes new files as seekable gzip: one gzip member per
+// frameDuration window of message time, followed by an index of the
+// frames, so readers can fetch a time range with range requests. Rotation
+// limits still apply to uncompressed bytes. Encrypted files are never
+// seekable. It must be called before Start.
+func (r *Recorder) EnableSeekable(frameDuration time.Duration) {
+	r.frameDuration = frameDuration
+}
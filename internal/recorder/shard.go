@@ -0,0 +1,99 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// shardQueueSize is how many messages wait for a busy shard before the
+// recorder's input backs up
+const shardQueueSize = 1024
+
+// shard owns the files of the channels hashed to it. Each shard marshals
+// and writes on its own goroutine, so a channel stalled on a slow disk
+// write only holds up the channels sharing its shard.
+type shard struct {
+	in    chan message.Message
+	files map[string]*fileWriter // key: "platform_channel"
+	mu    sync.Mutex
+}
+
+// newShards creates n empty shards
+func newShards(n int) []*shard {
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{
+			in:    make(chan message.Message, shardQueueSize),
+			files: make(map[string]*fileWriter),
+		}
+	}
+	return shards
+}
+
+// EnableShards splits recording across n workers, each handling the
+// channels that hash to it with its own buffers and files. The open file
+// limit is shared across them. It must be called before Start.
+func (r *Recorder) EnableShards(n int) {
+	r.shards = newShards(max(n, 1))
+}
+
+// shardFor returns the shard that records a channel
+func (r *Recorder) shardFor(platform, channel string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(platform))
+	h.Write([]byte{'_'})
+	h.Write([]byte(channel))
+	return r.shards[h.Sum32()%uint32(len(r.shards))]
+}
+
+// runShard records a shard's messages until its input is closed or the
+// context is cancelled, then closes its files
+func (r *Recorder) runShard(ctx context.Context, s *shard, fileChan chan<- FileInfo) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	var syncC <-chan time.Time
+	if r.syncPolicy == SyncInterval {
+		syncTicker := time.NewTicker(r.syncInterval)
+		defer syncTicker.Stop()
+		syncC = syncTicker.C
+	}
+
+	for {
+		select {
+		case msg, ok := <-s.in:
+			if !ok {
+				r.flushAll(s, fileChan)
+				return
+			}
+			if err := r.recordMessage(s, msg, fileChan); err != nil {
+				log.Printf("Error recording message: %v", err)
+				if errors.Is(err, syscall.ENOSPC) {
+					r.enterDegraded("disk full", fileChan)
+				}
+			}
+
+		case <-ticker.C:
+			r.checkRotation(s, fileChan)
+
+		case <-syncC:
+			if err := r.syncShard(s); err != nil {
+				log.Printf("Error syncing files: %v", err)
+				if errors.Is(err, syscall.ENOSPC) {
+					r.enterDegraded("disk full", fileChan)
+				}
+			}
+
+		case <-ctx.Done():
+			r.flushAll(s, fileChan)
+			return
+		}
+	}
+}
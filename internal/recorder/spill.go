@@ -0,0 +1,54 @@
+package recorder
+
+import "github.com/john/chatlog/internal/message"
+
+// spillBuffer is a fixed-size ring buffer holding messages while the disk is
+// unavailable. When full, the oldest messages are overwritten.
+type spillBuffer struct {
+	messages []message.Message
+	start    int
+	count    int
+	dropped  int64
+}
+
+// newSpillBuffer creates a spill buffer with the given capacity.
+// A capacity of zero or less disables spilling; every message is dropped.
+func newSpillBuffer(capacity int) *spillBuffer {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &spillBuffer{
+		messages: make([]message.Message, capacity),
+	}
+}
+
+// push adds a message, overwriting the oldest one if the buffer is full
+func (b *spillBuffer) push(msg message.Message) {
+	if len(b.messages) == 0 {
+		b.dropped++
+		return
+	}
+
+	if b.count == len(b.messages) {
+		b.messages[b.start] = msg
+		b.start = (b.start + 1) % len(b.messages)
+		b.dropped++
+		return
+	}
+
+	b.messages[(b.start+b.count)%len(b.messages)] = msg
+	b.count++
+}
+
+// drain returns all buffered messages oldest first and empties the buffer
+func (b *spillBuffer) drain() []message.Message {
+	out := make([]message.Message, 0, b.count)
+	for i := 0; i < b.count; i++ {
+		idx := (b.start + i) % len(b.messages)
+		out = append(out, b.messages[idx])
+		b.messages[idx] = message.Message{}
+	}
+	b.start = 0
+	b.count = 0
+	return out
+}
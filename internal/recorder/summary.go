@@ -0,0 +1,83 @@
+package recorder
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/john/chatlog/internal/firstseen"
+	"github.com/john/chatlog/internal/message"
+)
+
+// EnableSummaries ends each file with a summary record of its chatters:
+// unique, new to the channel according to tracker, and the topN most
+// active. It must be called before Start.
+func (r *Recorder) EnableSummaries(tracker *firstseen.Tracker, topN int) {
+	r.firstSeen = tracker
+	r.topChatters = topN
+}
+
+// countChatter updates the file's chatter statistics for a chat message;
+// the caller must hold the shard's lock
+func (r *Recorder) countChatter(fw *fileWriter, msg message.Message) {
+	if r.firstSeen == nil || msg.Type != "" || msg.UserID == "" {
+		return
+	}
+	fw.chatMessages++
+
+	chatter, ok := fw.chatters[msg.UserID]
+	if !ok {
+		chatter = &message.ChatterCount{UserID: msg.UserID}
+		fw.chatters[msg.UserID] = chatter
+
+		isNew, err := r.firstSeen.Seen(msg.Platform, msg.Channel, msg.UserID)
+		if err != nil {
+			log.Printf("Error tracking first-seen users: %v", err)
+		} else if isNew {
+			fw.newChatters++
+		}
+	}
+	chatter.Username = msg.Username
+	chatter.Count++
+}
+
+// appendSummary buffers the summary record for a file about to be closed
+// and saves the channel's first-seen users; the caller must hold the
+// shard's lock
+func (r *Recorder) appendSummary(fw *fileWriter) {
+	if r.firstSeen == nil || fw.chatMessages == 0 {
+		return
+	}
+
+	top := make([]message.ChatterCount, 0, len(fw.chatters))
+	for _, chatter := range fw.chatters {
+		top = append(top, *chatter)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].UserID < top[j].UserID
+	})
+	if len(top) > r.topChatters {
+		top = top[:r.topChatters]
+	}
+
+	record := message.New(fw.platform, time.Time{})
+	record.Channel = fw.channel
+	record.Type = message.TypeSummary
+	record.Summary = &message.Summary{
+		Start:          message.FormatTime(fw.createdAt),
+		End:            record.Timestamp,
+		Messages:       fw.chatMessages,
+		UniqueChatters: len(fw.chatters),
+		NewChatters:    fw.newChatters,
+		KnownChatters:  r.firstSeen.Users(fw.platform, fw.channel),
+		TopChatters:    top,
+	}
+	fw.messageBuffer = append(fw.messageBuffer, record)
+
+	if err := r.firstSeen.Flush(fw.platform, fw.channel); err != nil {
+		log.Printf("Error saving first-seen users: %v", err)
+	}
+}
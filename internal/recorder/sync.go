@@ -0,0 +1,61 @@
+package recorder
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncPolicy is when written data is forced to stable storage. Flushing
+// the buffered writer only hands data to the OS, so without a sync an OS
+// crash or power loss can lose what it hasn't written back yet.
+type SyncPolicy int
+
+const (
+	SyncNever      SyncPolicy = iota // Leave write-back to the OS
+	SyncOnRotate                     // Sync each file as it is closed
+	SyncInterval                     // Also flush and sync open files every interval
+	SyncEveryFlush                   // Sync after every buffer flush
+)
+
+// EnableSync syncs files by policy; interval is only used by SyncInterval.
+// Each sync waits for the disk, so the stricter policies cost throughput.
+// Encrypted files can only be synced up to their last complete chunk. It
+// must be called before Start.
+func (r *Recorder) EnableSync(policy SyncPolicy, interval time.Duration) {
+	r.syncPolicy = policy
+	r.syncInterval = interval
+}
+
+// syncShard flushes buffered messages and syncs a shard's files with
+// unsynced writes, for SyncInterval
+func (r *Recorder) syncShard(s *shard) error {
+	degraded := r.isDegraded()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, fw := range s.files {
+		if len(fw.messageBuffer) > 0 && !degraded {
+			if err := r.flushFileWriter(fw); err != nil {
+				return fmt.Errorf("flush buffer: %w", err)
+			}
+		}
+		if err := fw.sync(); err != nil {
+			return fmt.Errorf("sync %s: %w", fw.filename, err)
+		}
+	}
+	return nil
+}
+
+// sync forces the file's written data to disk if there is any since the
+// last sync
+func (fw *fileWriter) sync() error {
+	if !fw.unsynced {
+		return nil
+	}
+	if err := fw.file.Sync(); err != nil {
+		return err
+	}
+	fw.unsynced = false
+	return nil
+}
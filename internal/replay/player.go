@@ -0,0 +1,199 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/john/chatlog/internal/message"
+)
+
+// Sink receives replayed messages
+type Sink interface {
+	Emit(msg message.Message) error
+}
+
+// Play emits messages to the sink, waiting between them according to their
+// original timing divided by speed
+func Play(ctx context.Context, messages []message.Message, speed float64, sink Sink) error {
+	var prev time.Time
+	for _, msg := range messages {
+		ts, err := time.Parse(time.RFC3339Nano, msg.Timestamp)
+		if err != nil {
+			continue
+		}
+
+		if !prev.IsZero() && ts.After(prev) {
+			delay := time.Duration(float64(ts.Sub(prev)) / speed)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		prev = ts
+
+		if err := sink.Emit(msg); err != nil {
+			return fmt.Errorf("emit: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// WriterSink writes messages to a writer in one of the supported formats:
+// "json" (JSONL, as archived), "irc" (Twitch-style PRIVMSG lines) or
+// "text" (human-readable transcript lines)
+type WriterSink struct {
+	w      io.Writer
+	format string
+}
+
+// NewWriterSink creates a writer sink, validating the format
+func NewWriterSink(w io.Writer, format string) (*WriterSink, error) {
+	switch format {
+	case "json", "irc", "text":
+	default:
+		return nil, fmt.Errorf("unknown format %q (expected json, irc or text)", format)
+	}
+	return &WriterSink{w: w, format: format}, nil
+}
+
+// Emit writes a single message
+func (s *WriterSink) Emit(msg message.Message) error {
+	var line string
+	switch s.format {
+	case "json":
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		line = string(data)
+	case "irc":
+		line = FormatIRC(msg)
+	case "text":
+		line = fmt.Sprintf("[%s] %s: %s", msg.Timestamp, msg.Username, msg.Message)
+	}
+
+	_, err := fmt.Fprintln(s.w, line)
+	return err
+}
+
+// FormatIRC formats a message as a Twitch-style IRC PRIVMSG line with tags
+func FormatIRC(msg message.Message) string {
+	login := strings.ToLower(msg.Username)
+	tags := fmt.Sprintf("@badges=%s;display-name=%s;user-id=%s;tmi-sent-ts=%s",
+		msg.Badges, msg.Username, msg.UserID, ircTimestamp(msg.Timestamp))
+	return fmt.Sprintf("%s :%s!%s@%s.tmi.twitch.tv PRIVMSG #%s :%s",
+		tags, login, login, login, msg.Channel, msg.Message)
+}
+
+// ircTimestamp converts a message timestamp to Unix milliseconds
+func ircTimestamp(timestamp string) string {
+	ts, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprint(ts.UnixMilli())
+}
+
+// WebSocketSink broadcasts messages as JSON to all connected WebSocket clients
+type WebSocketSink struct {
+	server   *http.Server
+	upgrader websocket.Upgrader
+
+	clients map[*websocket.Conn]bool
+	mu      sync.Mutex
+}
+
+// NewWebSocketSink creates a sink serving WebSocket clients at /ws on addr
+func NewWebSocketSink(addr string) *WebSocketSink {
+	s := &WebSocketSink{
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		clients: make(map[*websocket.Conn]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	s.server = &http.Server{Addr: addr, Handler: mux}
+
+	return s
+}
+
+// Start begins serving WebSocket clients
+func (s *WebSocketSink) Start() error {
+	log.Printf("Replay WebSocket listening on ws://%s/ws", s.server.Addr)
+	if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown closes the server and all clients
+func (s *WebSocketSink) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	return s.server.Shutdown(ctx)
+}
+
+// handleWebSocket registers a new client
+func (s *WebSocketSink) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	s.clients[conn] = true
+	s.mu.Unlock()
+	log.Printf("Replay client connected: %s", r.RemoteAddr)
+
+	// Drain reads so control frames are handled; remove the client on close
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				s.mu.Lock()
+				delete(s.clients, conn)
+				s.mu.Unlock()
+				conn.Close()
+				return
+			}
+		}
+	}()
+}
+
+// ClientCount returns the number of connected clients
+func (s *WebSocketSink) ClientCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// Emit broadcasts a message to all clients, dropping any that fail
+func (s *WebSocketSink) Emit(msg message.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.clients {
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.WriteJSON(msg); err != nil {
+			conn.Close()
+			delete(s.clients, conn)
+		}
+	}
+	return nil
+}
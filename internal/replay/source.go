@@ -0,0 +1,419 @@
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/john/chatlog/internal/annotate"
+	"github.com/john/chatlog/internal/compactor"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/recorder"
+	"github.com/john/chatlog/internal/seekable"
+)
+
+// Filter selects which archived messages to replay
+type Filter struct {
+	Platform string
+	Channel  string
+	Start    time.Time // Zero means no lower bound
+	End      time.Time // Zero means no upper bound
+}
+
+// Source lists and opens archived files for a filter
+type Source interface {
+	// Files returns the names of files that may contain matching messages
+	Files(ctx context.Context, filter Filter) ([]string, error)
+	// Open opens a file returned by Files
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// RangeSource is a source that can read parts of a file, so only the
+// needed frames of seekable files are fetched
+type RangeSource interface {
+	OpenRange(ctx context.Context, name string) (RangeReader, error)
+}
+
+// RangeReader reads byte ranges of a file of known size
+type RangeReader interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// LocalSource reads archives from a local directory, as written by the recorder
+type LocalSource struct {
+	Dir string
+}
+
+// Files returns the recorder files in Dir for the filter's platform and channel
+func (l LocalSource) Files(ctx context.Context, filter Filter) ([]string, error) {
+	paths, err := recorder.LocalFiles(l.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	prefix := filter.Platform + "_" + filter.Channel + "_"
+	var files []string
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if !strings.HasPrefix(name, prefix) || !isArchive(name) {
+			continue
+		}
+		// The remainder must be just the date/time suffix, so channel "foo"
+		// doesn't match "foo_bar"
+		rest := strings.TrimPrefix(name, prefix)
+		if strings.Count(trimArchiveExt(rest), "_") > 1 {
+			continue
+		}
+		files = append(files, path)
+	}
+
+	return files, nil
+}
+
+// Open opens a local file
+func (l LocalSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// OpenRange opens a local file for reading ranges
+func (l LocalSource) OpenRange(ctx context.Context, name string) (RangeReader, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return localRange{File: file, size: stat.Size()}, nil
+}
+
+// localRange is a local file with its size
+type localRange struct {
+	*os.File
+	size int64
+}
+
+func (l localRange) Size() int64 { return l.size }
+
+// S3Source reads archives from S3 using the YYYY/MM/DD/platform/channel layout
+type S3Source struct {
+	Client   *s3.Client
+	Bucket   string
+	Prefixes []string // Key prefixes the layout is also found under, such as channel group prefixes
+}
+
+// Files lists archived objects for each day in the filter's range
+func (s S3Source) Files(ctx context.Context, filter Filter) ([]string, error) {
+	if filter.Start.IsZero() {
+		return nil, fmt.Errorf("a start time is required when replaying from S3")
+	}
+	end := filter.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	prefixes := []string{""}
+	for _, prefix := range s.Prefixes {
+		if prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	var keys []string
+	// Start a day early, since a file started before midnight may run past it
+	for day := filter.Start.UTC().AddDate(0, 0, -1).Truncate(24 * time.Hour); !day.After(end); day = day.AddDate(0, 0, 1) {
+		for _, prefix := range prefixes {
+			if err := s.list(ctx, prefix+fmt.Sprintf("%s/%s/%s/", day.Format("2006/01/02"), filter.Platform, filter.Channel), &keys); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// list appends the archives in the channel directory prefix to keys.
+// Fragments kept beside a compacted object that already holds them are
+// left out, so their records aren't read twice.
+func (s S3Source) list(ctx context.Context, prefix string, keys *[]string) error {
+	var found []string
+	compacted := ""
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if strings.HasSuffix(key, compactor.Ext) {
+				compacted = key
+			}
+			if isArchive(key) {
+				found = append(found, key)
+			}
+		}
+	}
+	if compacted == "" {
+		*keys = append(*keys, found...)
+		return nil
+	}
+
+	merged, err := compactor.ReadManifest(ctx, s.Client, s.Bucket, compactor.ManifestKey(compacted))
+	if err != nil {
+		return fmt.Errorf("read manifest of %s: %w", compacted, err)
+	}
+	for _, key := range found {
+		if !merged[path.Base(key)] {
+			*keys = append(*keys, key)
+		}
+	}
+	return nil
+}
+
+// Open downloads an object
+func (s S3Source) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get object: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// OpenRange returns a reader fetching byte ranges of an object with S3
+// range requests
+func (s S3Source) OpenRange(ctx context.Context, name string) (RangeReader, error) {
+	head, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("head object: %w", err)
+	}
+	return &s3Range{ctx: ctx, source: s, key: name, size: aws.ToInt64(head.ContentLength)}, nil
+}
+
+// s3Range reads byte ranges of an S3 object
+type s3Range struct {
+	ctx    context.Context
+	source S3Source
+	key    string
+	size   int64
+}
+
+func (r *s3Range) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	resp, err := r.source.Client.GetObject(r.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.source.Bucket),
+		Key:    aws.String(r.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("get object range: %w", err)
+	}
+	defer resp.Body.Close()
+	return io.ReadFull(resp.Body, p)
+}
+
+func (r *s3Range) Size() int64  { return r.size }
+func (r *s3Range) Close() error { return nil }
+
+// Load reads all messages matching the filter from a source, sorted by
+// timestamp and sequence number
+func Load(ctx context.Context, source Source, filter Filter) ([]message.Message, error) {
+	files, err := source.Files(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return LoadFiles(ctx, source, files, filter)
+}
+
+// LoadFiles reads the messages within the filter's range from files, as
+// returned by source.Files, sorted by timestamp
+func LoadFiles(ctx context.Context, source Source, files []string, filter Filter) ([]message.Message, error) {
+	var entries []entry
+	for _, name := range files {
+		fileEntries, err := readFile(ctx, source, name, filter)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].ts.Equal(entries[j].ts) {
+			return entries[i].ts.Before(entries[j].ts)
+		}
+		return entries[i].msg.Sequence < entries[j].msg.Sequence
+	})
+
+	messages := make([]message.Message, len(entries))
+	for i, e := range entries {
+		messages[i] = e.msg
+	}
+	return messages, nil
+}
+
+// entry is a message with its parsed timestamp
+type entry struct {
+	ts  time.Time
+	msg message.Message
+}
+
+// readFile reads the messages in one file that fall within the filter's range
+func readFile(ctx context.Context, source Source, name string, filter Filter) ([]entry, error) {
+	if _, ok := source.(DecryptSource); strings.HasSuffix(name, ".age") && !ok {
+		return nil, fmt.Errorf("file is encrypted and no age identity was given")
+	}
+
+	// Seekable files only need the frames overlapping the range
+	if rs, ok := source.(RangeSource); ok && strings.HasSuffix(name, ".jsonl.gz") && (!filter.Start.IsZero() || !filter.End.IsZero()) {
+		entries, err := readFrames(ctx, rs, name, filter)
+		if !errors.Is(err, seekable.ErrNoIndex) {
+			return entries, err
+		}
+	}
+
+	rc, err := source.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	return scanEntries(r, filter)
+}
+
+// readFrames reads the frames of a seekable file that overlap the filter's
+// range. It returns seekable.ErrNoIndex if the file has no index.
+func readFrames(ctx context.Context, source RangeSource, name string, filter Filter) ([]entry, error) {
+	ra, err := source.OpenRange(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer ra.Close()
+
+	index, err := seekable.ReadIndex(ra, ra.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	for _, frame := range index.Overlapping(filter.Start, filter.End) {
+		rc, err := seekable.OpenFrame(ra, frame)
+		if err != nil {
+			return nil, fmt.Errorf("open frame at %d: %w", frame.Offset, err)
+		}
+		frameEntries, err := scanEntries(rc, filter)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read frame at %d: %w", frame.Offset, err)
+		}
+		entries = append(entries, frameEntries...)
+	}
+	return entries, nil
+}
+
+// scanEntries parses JSONL messages that fall within the filter's range.
+// File headers are skipped.
+func scanEntries(r io.Reader, filter Filter) ([]entry, error) {
+	var entries []entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		var msg message.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue // Skip malformed lines
+		}
+		if msg.Type == message.TypeHeader {
+			continue
+		}
+
+		ts, err := time.Parse(time.RFC3339Nano, msg.Timestamp)
+		if err != nil {
+			continue
+		}
+		if !filter.Start.IsZero() && ts.Before(filter.Start) {
+			continue
+		}
+		if !filter.End.IsZero() && ts.After(filter.End) {
+			continue
+		}
+
+		entries = append(entries, entry{ts: ts, msg: msg})
+	}
+
+	return entries, scanner.Err()
+}
+
+// isArchive reports whether name is a JSONL archive: plain, compacted or
+// encrypted. Annotation sidecars are not.
+func isArchive(name string) bool {
+	if strings.HasSuffix(name, annotate.Ext) {
+		return false
+	}
+	return strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".jsonl.gz") || strings.HasSuffix(name, ".jsonl.age")
+}
+
+// trimArchiveExt strips the archive extension from a file name
+func trimArchiveExt(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, ".age"), ".gz"), ".jsonl")
+}
+
+// DecryptSource wraps a source to decrypt age-encrypted (.age) files
+type DecryptSource struct {
+	Source
+	Identities []age.Identity
+}
+
+// Open opens a file, decrypting it if it is encrypted
+func (d DecryptSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := d.Source.Open(ctx, name)
+	if err != nil || !strings.HasSuffix(name, ".age") {
+		return rc, err
+	}
+
+	r, err := age.Decrypt(rc, d.Identities...)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, rc}, nil
+}
@@ -0,0 +1,83 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/john/chatlog/internal/compactor"
+)
+
+// newFakeS3 serves objects, by key, from a path-style S3 endpoint for
+// bucket "archive", and returns a client for it
+func newFakeS3(t *testing.T, objects map[string]string) *s3.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/archive")
+		key = strings.TrimPrefix(key, "/")
+		if key != "" {
+			data, ok := objects[key]
+			if !ok {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, data)
+			return
+		}
+		var keys []string
+		for key := range objects {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, "<ListBucketResult>")
+		for _, key := range keys {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", key, len(objects[key]))
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	}))
+	t.Cleanup(server.Close)
+
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+}
+
+func TestS3SourceSkipsCompactedFragments(t *testing.T) {
+	dir := "2024/01/02/twitch/chan/"
+	compacted := dir + "twitch_chan_20240102" + compactor.Ext
+	client := newFakeS3(t, map[string]string{
+		dir + "twitch_chan_20240102_1000.jsonl":    "kept after compaction\n",
+		dir + "twitch_chan_20240102_1100.jsonl":    "uploaded late\n",
+		compacted:                                  "compacted\n",
+		compactor.ManifestKey(compacted):           `{"fragments":["twitch_chan_20240102_1000.jsonl"]}`,
+		"2024/01/02/twitch/other/other_1000.jsonl": "another channel\n",
+	})
+
+	source := S3Source{Client: client, Bucket: "archive"}
+	files, err := source.Files(context.Background(), Filter{
+		Platform: "twitch",
+		Channel:  "chan",
+		Start:    time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		End:      time.Date(2024, 1, 2, 23, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{compacted, dir + "twitch_chan_20240102_1100.jsonl"}
+	if strings.Join(files, " ") != strings.Join(want, " ") {
+		t.Errorf("Files = %v, want %v", files, want)
+	}
+}
@@ -0,0 +1,83 @@
+// Package retry computes the delays between attempts of an operation that
+// is retried after failures, such as an upload or a channel lookup
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// maxDelay keeps a delay that grew without a cap within time.Duration
+const maxDelay = time.Duration(1 << 62)
+
+// Policy is an exponential backoff. The zero Policy waits 1s, 2s, 4s, ...
+// without limit.
+type Policy struct {
+	Base       time.Duration // First delay (default 1s)
+	Multiplier float64       // Growth of each delay over the last (default 2)
+	Jitter     float64       // Fraction of each delay taken off at random, 0 to 1
+	Max        time.Duration // Cap on one delay; zero for none
+	MaxElapsed time.Duration // Give up once a retry would start this long after the first attempt; zero for never
+}
+
+// Delay returns the delay before retry n, counting from 1 for the retry
+// after the first failed attempt
+func (p Policy) Delay(n int) time.Duration {
+	base := p.Base
+	if base <= 0 {
+		base = time.Second
+	}
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	delay := float64(base) * math.Pow(multiplier, float64(max(n-1, 0)))
+	if p.Max > 0 && delay > float64(p.Max) {
+		delay = float64(p.Max)
+	}
+	delay = min(delay, float64(maxDelay))
+	if p.Jitter > 0 {
+		delay -= delay * min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// Backoff tracks the retries of one operation
+type Backoff struct {
+	policy  Policy
+	started time.Time
+	retries int
+}
+
+// Start begins tracking an operation whose first attempt is about to be
+// made
+func (p Policy) Start() *Backoff {
+	return &Backoff{policy: p, started: time.Now()}
+}
+
+// Next returns the delay before the next retry, or false if the policy's
+// MaxElapsed would be passed by then
+func (b *Backoff) Next() (time.Duration, bool) {
+	b.retries++
+	delay := b.policy.Delay(b.retries)
+	if b.policy.MaxElapsed > 0 && time.Since(b.started)+delay > b.policy.MaxElapsed {
+		return 0, false
+	}
+	return delay, true
+}
+
+// Sleep waits for delay, returning the context's error if it is cancelled
+// first
+func Sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
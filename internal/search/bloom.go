@@ -0,0 +1,62 @@
+package search
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// falsePositiveRate is the chance a Bloom filter reports a string it was
+// never given, at the size NewBloom picks
+const falsePositiveRate = 0.01
+
+// Bloom is a Bloom filter of strings. It can report strings it was never
+// given, but never misses one it was.
+type Bloom struct {
+	Bits   []byte `json:"bits"` // Base64 in JSON
+	Hashes int    `json:"hashes"`
+}
+
+// NewBloom creates a filter sized for n strings
+func NewBloom(n int) *Bloom {
+	n = max(n, 1)
+	bits := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	bytes := max(int(math.Ceil(bits/8)), 8)
+	hashes := int(math.Round(float64(bytes*8) / float64(n) * math.Ln2))
+	return &Bloom{Bits: make([]byte, bytes), Hashes: min(max(hashes, 1), 16)}
+}
+
+// Add adds s to the filter
+func (b *Bloom) Add(s string) {
+	h1, h2 := hashes(s)
+	m := uint32(len(b.Bits) * 8)
+	for i := range uint32(b.Hashes) {
+		bit := (h1 + i*h2) % m
+		b.Bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Test reports whether s may have been added. A nil or empty filter may
+// hold anything.
+func (b *Bloom) Test(s string) bool {
+	if b == nil || len(b.Bits) == 0 {
+		return true
+	}
+	h1, h2 := hashes(s)
+	m := uint32(len(b.Bits) * 8)
+	for i := range uint32(b.Hashes) {
+		bit := (h1 + i*h2) % m
+		if b.Bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashes derives the two hashes that every probe position is combined
+// from (Kirsch-Mitzenmacher double hashing)
+func hashes(s string) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
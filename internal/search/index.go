@@ -0,0 +1,173 @@
+// Package search builds compact per-file indexes of archived chat, written
+// next to each file, so searches can skip files without downloading them,
+// and an in-memory index of recent local chat served over HTTP
+package search
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// Ext is the extension of search index sidecars, which are named after
+// their chat file: twitch_x_20251230_1030.search.json
+const Ext = ".search.json"
+
+// TypeIndex is the type of search index sidecars
+const TypeIndex = "search_index"
+
+// maxTerms caps the distinct terms indexed per file. Past it the file's
+// index has no terms, so searches by term read the file.
+const maxTerms = 200000
+
+// Index summarizes a chat file: the span of its records' timestamps, and
+// Bloom filters of who wrote them and the terms they used. Platform,
+// channel and timestamp (the file's start) match the chat file, so the
+// sidecar is uploaded to the same partition.
+type Index struct {
+	Type         string `json:"type"`
+	Platform     string `json:"platform"`
+	Channel      string `json:"channel"`
+	Timestamp    string `json:"timestamp"`
+	File         string `json:"file"` // Chat file name
+	MinTimestamp string `json:"min_timestamp,omitempty"`
+	MaxTimestamp string `json:"max_timestamp,omitempty"`
+	Records      int    `json:"records"`
+	Users        *Bloom `json:"users"`           // Lowercased usernames
+	Terms        *Bloom `json:"terms,omitempty"` // Lowercased terms; unset if the file had too many
+}
+
+// Query is what a search looks for. Empty fields match anything.
+type Query struct {
+	User  string    // Username, case-insensitive
+	Terms []string  // Terms that must all appear, case-insensitive
+	Start time.Time // Zero means no lower bound
+	End   time.Time // Zero means no upper bound
+}
+
+// MayMatch reports whether the indexed file may hold records matching q.
+// False means it certainly doesn't.
+func (idx *Index) MayMatch(q Query) bool {
+	if first, err := time.Parse(time.RFC3339Nano, idx.MinTimestamp); err == nil && !q.End.IsZero() && first.After(q.End) {
+		return false
+	}
+	if last, err := time.Parse(time.RFC3339Nano, idx.MaxTimestamp); err == nil && !q.Start.IsZero() && last.Before(q.Start) {
+		return false
+	}
+	if q.User != "" && !idx.Users.Test(strings.ToLower(q.User)) {
+		return false
+	}
+	for _, term := range q.Terms {
+		for _, token := range Tokens(term) {
+			if !idx.Terms.Test(token) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Matches reports whether msg matches q
+func (q Query) Matches(msg message.Message) bool {
+	if q.User != "" && !strings.EqualFold(msg.Username, q.User) {
+		return false
+	}
+	if len(q.Terms) == 0 {
+		return true
+	}
+	tokens := make(map[string]bool)
+	for _, token := range Tokens(msg.Message) {
+		tokens[token] = true
+	}
+	for _, term := range q.Terms {
+		for _, token := range Tokens(term) {
+			if !tokens[token] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Tokens splits text into lowercased terms of letters and digits, as they
+// are indexed
+func Tokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// IndexName returns the sidecar name for a chat file name or key
+func IndexName(name string) string {
+	for _, ext := range []string{".age", ".gz", ".jsonl"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name + Ext
+}
+
+// Builder collects a file's users and terms while it is open
+type Builder struct {
+	users    map[string]struct{}
+	terms    map[string]struct{}
+	overflow bool // More than maxTerms terms were seen
+	records  int
+	min, max string // Timestamps, which in TimestampFormat (UTC) sort as strings
+}
+
+// NewBuilder creates an empty builder
+func NewBuilder() *Builder {
+	return &Builder{users: make(map[string]struct{}), terms: make(map[string]struct{})}
+}
+
+// Add indexes a record
+func (b *Builder) Add(msg message.Message) {
+	b.records++
+	if msg.Timestamp != "" {
+		if b.min == "" || msg.Timestamp < b.min {
+			b.min = msg.Timestamp
+		}
+		if msg.Timestamp > b.max {
+			b.max = msg.Timestamp
+		}
+	}
+	if msg.Username != "" {
+		b.users[strings.ToLower(msg.Username)] = struct{}{}
+	}
+	if b.overflow {
+		return
+	}
+	for _, token := range Tokens(msg.Message) {
+		b.terms[token] = struct{}{}
+	}
+	if len(b.terms) > maxTerms {
+		b.overflow = true
+		b.terms = nil
+	}
+}
+
+// Index builds the index of the file named file, started at start
+func (b *Builder) Index(platform, channel, file string, start time.Time) Index {
+	idx := Index{
+		Type:         TypeIndex,
+		Platform:     platform,
+		Channel:      channel,
+		Timestamp:    message.FormatTime(start),
+		File:         file,
+		MinTimestamp: b.min,
+		MaxTimestamp: b.max,
+		Records:      b.records,
+		Users:        NewBloom(len(b.users)),
+	}
+	for user := range b.users {
+		idx.Users.Add(user)
+	}
+	if !b.overflow {
+		idx.Terms = NewBloom(len(b.terms))
+		for term := range b.terms {
+			idx.Terms.Add(term)
+		}
+	}
+	return idx
+}
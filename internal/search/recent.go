@@ -0,0 +1,279 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// segmentSpan is the stretch of time each segment of a Recent index
+// covers. Expiry drops whole segments, so the window is kept to within a
+// segment.
+const segmentSpan = 10 * time.Minute
+
+// Recent is an in-memory inverted index of the chat in local files over a
+// trailing window, read as the files grow, so chat can be searched before
+// it is uploaded
+type Recent struct {
+	window time.Duration
+
+	mu       sync.RWMutex
+	offsets  map[string]int64   // Path -> bytes indexed, up to the last complete line
+	segments map[int64]*segment // Segment start (Unix seconds) -> segment
+	messages int                // Across segments
+}
+
+// segment indexes the messages sent within one segmentSpan
+type segment struct {
+	start time.Time
+	docs  []message.Message
+	terms map[string][]int32 // Term -> positions in docs, ascending
+	users map[string][]int32 // Lowercased username -> positions in docs
+}
+
+// NewRecent creates an empty index of the chat sent within window
+func NewRecent(window time.Duration) *Recent {
+	return &Recent{
+		window:   window,
+		offsets:  make(map[string]int64),
+		segments: make(map[int64]*segment),
+	}
+}
+
+// Tail indexes what was appended to the files at paths since the last
+// call and drops chat older than the window. Only plain .jsonl files are
+// read. Files not modified within the window, and paths no longer given,
+// are forgotten.
+func (r *Recent) Tail(paths []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := time.Now().Add(-r.window)
+	seen := make(map[string]bool, len(paths))
+	var errs []string
+	for _, path := range paths {
+		if !strings.HasSuffix(path, ".jsonl") {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Before(cutoff) {
+			continue
+		}
+		seen[path] = true
+		if info.Size() < r.offsets[path] {
+			r.offsets[path] = 0 // Replaced or truncated
+		}
+		if info.Size() == r.offsets[path] {
+			continue
+		}
+		if err := r.tailFile(path, cutoff); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+	for path := range r.offsets {
+		if !seen[path] {
+			delete(r.offsets, path)
+		}
+	}
+	r.expire(cutoff)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to read %d files: %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// tailFile indexes the complete lines after the file's offset (caller
+// holds mu)
+func (r *Recent) tailFile(path string, cutoff time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(r.offsets[path], io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(f, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// A partial last line is still being written; it is read
+			// whole next time
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		r.offsets[path] += int64(len(line))
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var msg message.Message
+		if err := json.Unmarshal(line, &msg); err != nil || msg.Type != "" {
+			continue
+		}
+		if sentAt, ok := sentTime(msg); ok && !sentAt.Before(cutoff) {
+			r.add(msg, sentAt)
+		}
+	}
+}
+
+// sentTime returns when msg was sent, falling back to its receive time
+func sentTime(msg message.Message) (time.Time, bool) {
+	for _, ts := range []string{msg.Timestamp, msg.ReceivedAt} {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// add indexes msg in the segment covering sentAt (caller holds mu)
+func (r *Recent) add(msg message.Message, sentAt time.Time) {
+	start := sentAt.Truncate(segmentSpan)
+	seg, ok := r.segments[start.Unix()]
+	if !ok {
+		seg = &segment{start: start, terms: make(map[string][]int32), users: make(map[string][]int32)}
+		r.segments[start.Unix()] = seg
+	}
+
+	pos := int32(len(seg.docs))
+	seg.docs = append(seg.docs, msg)
+	r.messages++
+	if msg.Username != "" {
+		user := strings.ToLower(msg.Username)
+		seg.users[user] = append(seg.users[user], pos)
+	}
+	for _, token := range Tokens(msg.Message) {
+		// A term repeated in a message is only listed once
+		if list := seg.terms[token]; len(list) == 0 || list[len(list)-1] != pos {
+			seg.terms[token] = append(list, pos)
+		}
+	}
+}
+
+// expire drops the segments entirely before cutoff (caller holds mu)
+func (r *Recent) expire(cutoff time.Time) {
+	for key, seg := range r.segments {
+		if !seg.start.Add(segmentSpan).After(cutoff) {
+			r.messages -= len(seg.docs)
+			delete(r.segments, key)
+		}
+	}
+}
+
+// Messages returns how many messages are indexed
+func (r *Recent) Messages() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.messages
+}
+
+// Search returns up to limit indexed messages matching q on platform and
+// channel, newest first. Empty platform and channel match any. q must
+// name a user or terms.
+func (r *Recent) Search(q Query, platform, channel string, limit int) []message.Message {
+	var tokens []string
+	for _, term := range q.Terms {
+		tokens = append(tokens, Tokens(term)...)
+	}
+	user := strings.ToLower(q.User)
+	if user == "" && len(tokens) == 0 {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	segments := make([]*segment, 0, len(r.segments))
+	for _, seg := range r.segments {
+		segments = append(segments, seg)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].start.After(segments[j].start) })
+
+	var results []message.Message
+	for _, seg := range segments {
+		if !q.End.IsZero() && seg.start.After(q.End) {
+			continue
+		}
+		if !q.Start.IsZero() && seg.start.Add(segmentSpan).Before(q.Start) {
+			break
+		}
+
+		var matches []message.Message
+		for _, pos := range seg.candidates(user, tokens) {
+			msg := seg.docs[pos]
+			if platform != "" && msg.Platform != platform {
+				continue
+			}
+			if channel != "" && !strings.EqualFold(msg.Channel, channel) {
+				continue
+			}
+			if sentAt, _ := sentTime(msg); (!q.Start.IsZero() && sentAt.Before(q.Start)) || (!q.End.IsZero() && sentAt.After(q.End)) {
+				continue
+			}
+			matches = append(matches, msg)
+		}
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].Timestamp > matches[j].Timestamp })
+		results = append(results, matches...)
+
+		// Segments don't overlap, so the rest are all older
+		if len(results) >= limit {
+			return results[:limit]
+		}
+	}
+	return results
+}
+
+// candidates returns the positions of the segment's messages by user (if
+// set) using every token, by intersecting their posting lists
+func (seg *segment) candidates(user string, tokens []string) []int32 {
+	var lists [][]int32
+	if user != "" {
+		lists = append(lists, seg.users[user])
+	}
+	for _, token := range tokens {
+		lists = append(lists, seg.terms[token])
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	result := lists[0]
+	for _, list := range lists[1:] {
+		if len(result) == 0 {
+			break
+		}
+		result = intersect(result, list)
+	}
+	return result
+}
+
+// intersect returns the positions in both ascending lists
+func intersect(a, b []int32) []int32 {
+	var out []int32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
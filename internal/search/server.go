@@ -0,0 +1,104 @@
+package search
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+const (
+	// defaultLimit is how many messages a search returns without a limit
+	defaultLimit = 100
+
+	// maxLimit caps the limit a search may ask for
+	maxLimit = 1000
+)
+
+// Server answers searches of a Recent index over HTTP
+type Server struct {
+	recent *Recent
+	token  string
+}
+
+// NewServer creates a server searching recent
+func NewServer(recent *Recent) *Server {
+	return &Server{recent: recent}
+}
+
+// EnableToken requires token as a bearer token on searches. It must be
+// called before Handler.
+func (s *Server) EnableToken(token string) {
+	s.token = token
+}
+
+// result is the response to a search
+type result struct {
+	Count    int               `json:"count"`
+	Indexed  int               `json:"indexed"` // Messages searched
+	Messages []message.Message `json:"messages"`
+}
+
+// Handler serves GET /search?q=&user=&channel=&platform=&since=&limit=,
+// and /healthz
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /search", s.serveSearch)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+
+	if s.token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if r.URL.Path != "/healthz" && subtle.ConstantTimeCompare([]byte(bearer), []byte(s.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// serveSearch answers a search. q holds terms that must all appear;
+// since is a duration back from now, such as 1h.
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := Query{User: params.Get("user")}
+	if text := params.Get("q"); text != "" {
+		q.Terms = Tokens(text)
+	}
+	if q.User == "" && len(q.Terms) == 0 {
+		http.Error(w, "q or user is required", http.StatusBadRequest)
+		return
+	}
+	if since := params.Get("since"); since != "" {
+		d, err := time.ParseDuration(since)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		q.Start = time.Now().Add(-d)
+	}
+	limit := defaultLimit
+	if text := params.Get("limit"); text != "" {
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxLimit)
+	}
+
+	messages := s.recent.Search(q, params.Get("platform"), params.Get("channel"), limit)
+	if messages == nil {
+		messages = []message.Message{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result{Count: len(messages), Indexed: s.recent.Messages(), Messages: messages})
+}
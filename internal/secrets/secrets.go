@@ -0,0 +1,240 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Reference schemes
+const (
+	SchemeSecretsManager = "secretsmanager://" // secretsmanager://name[#json-key]
+	SchemeSSM            = "ssm://"            // ssm://name or ssm://path/to/name (read as /path/to/name)
+	SchemeVault          = "vault://"          // vault://mount/path#key, a KV v2 secret
+)
+
+// IsRef reports whether s is a secret reference rather than a value
+func IsRef(s string) bool {
+	return strings.HasPrefix(s, SchemeSecretsManager) || strings.HasPrefix(s, SchemeSSM) || strings.HasPrefix(s, SchemeVault)
+}
+
+// Resolver fetches secret references from AWS Secrets Manager, SSM
+// Parameter Store and Vault. AWS clients are created on first use with
+// loadAWS; Vault is reached at VAULT_ADDR with VAULT_TOKEN (and
+// VAULT_NAMESPACE, if set).
+type Resolver struct {
+	loadAWS func(ctx context.Context) (aws.Config, error)
+
+	awsCfg     *aws.Config
+	httpClient *http.Client
+	mu         sync.Mutex
+}
+
+// New creates a resolver. loadAWS supplies AWS credentials and region.
+func New(loadAWS func(ctx context.Context) (aws.Config, error)) *Resolver {
+	return &Resolver{
+		loadAWS:    loadAWS,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Resolve returns the value of a secret reference
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, SchemeSecretsManager):
+		return r.secretsManager(ctx, strings.TrimPrefix(ref, SchemeSecretsManager))
+	case strings.HasPrefix(ref, SchemeSSM):
+		return r.ssm(ctx, strings.TrimPrefix(ref, SchemeSSM))
+	case strings.HasPrefix(ref, SchemeVault):
+		return r.vault(ctx, strings.TrimPrefix(ref, SchemeVault))
+	}
+	return "", fmt.Errorf("not a secret reference")
+}
+
+// ResolveAll replaces every secret reference among the strings reachable
+// from v, a pointer to a struct, in place. Each reference is fetched once.
+// Errors name the reference but never a secret value.
+func (r *Resolver) ResolveAll(ctx context.Context, v any) error {
+	resolved := make(map[string]string)
+	var walk func(val reflect.Value) error
+	walk = func(val reflect.Value) error {
+		switch val.Kind() {
+		case reflect.Pointer, reflect.Interface:
+			if !val.IsNil() {
+				return walk(val.Elem())
+			}
+		case reflect.Struct:
+			for i := 0; i < val.NumField(); i++ {
+				if val.Type().Field(i).IsExported() {
+					if err := walk(val.Field(i)); err != nil {
+						return err
+					}
+				}
+			}
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < val.Len(); i++ {
+				if err := walk(val.Index(i)); err != nil {
+					return err
+				}
+			}
+		case reflect.Map:
+			if val.Type().Elem().Kind() != reflect.String {
+				return nil // Option maps hold arbitrary values; only string maps are resolved
+			}
+			for _, key := range val.MapKeys() {
+				value, err := r.lookup(ctx, val.MapIndex(key).String(), resolved)
+				if err != nil {
+					return err
+				}
+				val.SetMapIndex(key, reflect.ValueOf(value).Convert(val.Type().Elem()))
+			}
+		case reflect.String:
+			if !val.CanSet() {
+				return nil
+			}
+			value, err := r.lookup(ctx, val.String(), resolved)
+			if err != nil {
+				return err
+			}
+			val.SetString(value)
+		}
+		return nil
+	}
+	return walk(reflect.ValueOf(v))
+}
+
+// lookup resolves s if it is a reference, caching results in resolved
+func (r *Resolver) lookup(ctx context.Context, s string, resolved map[string]string) (string, error) {
+	if !IsRef(s) {
+		return s, nil
+	}
+	if value, ok := resolved[s]; ok {
+		return value, nil
+	}
+	value, err := r.Resolve(ctx, s)
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", s, err)
+	}
+	resolved[s] = value
+	return value, nil
+}
+
+// aws returns the AWS config, loading it on first use
+func (r *Resolver) aws(ctx context.Context) (aws.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.awsCfg == nil {
+		cfg, err := r.loadAWS(ctx)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		r.awsCfg = &cfg
+	}
+	return *r.awsCfg, nil
+}
+
+// secretsManager reads a Secrets Manager secret. With a "#key" suffix the
+// secret is parsed as a JSON object and that key's value returned.
+func (r *Resolver) secretsManager(ctx context.Context, ref string) (string, error) {
+	name, key, _ := strings.Cut(ref, "#")
+	cfg, err := r.aws(ctx)
+	if err != nil {
+		return "", err
+	}
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	value := aws.ToString(out.SecretString)
+	if key == "" {
+		return value, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object")
+	}
+	field, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string key %q", key)
+	}
+	return field, nil
+}
+
+// ssm reads a Parameter Store parameter, decrypting SecureStrings.
+// Hierarchical names get their leading slash back.
+func (r *Resolver) ssm(ctx context.Context, name string) (string, error) {
+	if strings.Contains(name, "/") && !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	cfg, err := r.aws(ctx)
+	if err != nil {
+		return "", err
+	}
+	out, err := ssm.NewFromConfig(cfg).GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.Parameter.Value), nil
+}
+
+// vault reads a key from a Vault KV version 2 secret at mount/path
+func (r *Resolver) vault(ctx context.Context, ref string) (string, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	secretPath, key, _ := strings.Cut(ref, "#")
+	mount, rest, ok := strings.Cut(secretPath, "/")
+	if !ok || key == "" {
+		return "", fmt.Errorf("vault references look like vault://mount/path#key")
+	}
+
+	url := strings.TrimRight(addr, "/") + "/v1/" + mount + "/data/" + rest
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode vault response: %w", err)
+	}
+	value, ok := body.Data.Data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string key %q", key)
+	}
+	return value, nil
+}
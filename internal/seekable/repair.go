@@ -0,0 +1,146 @@
+package seekable
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Repair finishes a seekable file left open by a crash. Complete frames
+// are kept, the readable records of a partly written last frame are
+// rewritten as a new frame, and the index is rebuilt. Files that already
+// have an index are left alone. It returns the number of records in the
+// file.
+func Repair(path string) (int, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if index, err := ReadIndex(file, stat.Size()); err == nil {
+		records := 0
+		for _, f := range index.Frames {
+			records += f.Records
+		}
+		return records, nil
+	}
+
+	var index Index
+	var salvage []byte
+	records := 0
+	r := &countingReader{r: bufio.NewReader(file)}
+	var gz *gzip.Reader
+	end := int64(0) // End of the last complete frame
+	for {
+		offset := r.n
+		if gz == nil {
+			gz, err = gzip.NewReader(r)
+		} else {
+			err = gz.Reset(r)
+		}
+		if err == io.EOF {
+			break // Clean end between frames
+		}
+		if err != nil {
+			break // Partial header; nothing to salvage
+		}
+		gz.Multistream(false)
+
+		data, err := io.ReadAll(gz)
+		if err != nil {
+			salvage = completeLines(data)
+			break
+		}
+		if gz.Name == indexName {
+			break // Index written but footer missing; rebuild both
+		}
+
+		frame := Frame{Offset: offset, Length: r.n - offset}
+		for _, line := range bytes.SplitAfter(data, []byte{'\n'}) {
+			if len(line) == 0 {
+				continue
+			}
+			frame.Records++
+			if ts, err := recordTime(line); err == nil {
+				if frame.Start.IsZero() || ts.Before(frame.Start) {
+					frame.Start = ts
+				}
+				if ts.After(frame.End) {
+					frame.End = ts
+				}
+			}
+		}
+		index.Frames = append(index.Frames, frame)
+		records += frame.Records
+		end = r.n
+	}
+
+	if err := file.Truncate(end); err != nil {
+		return 0, fmt.Errorf("truncate: %w", err)
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if records == 0 && len(salvage) == 0 {
+		return 0, nil
+	}
+
+	// Salvaged records go in one frame, whatever their windows
+	w := &Writer{
+		out:           &countingWriter{w: file, n: end},
+		frameDuration: 100 * 365 * 24 * time.Hour,
+		index:         index,
+	}
+	if _, err := w.Write(salvage); err != nil {
+		return 0, fmt.Errorf("write salvaged records: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return records + bytes.Count(salvage, []byte{'\n'}), nil
+}
+
+// completeLines returns data up to its last complete, valid JSON line
+func completeLines(data []byte) []byte {
+	end := bytes.LastIndexByte(data, '\n') + 1
+	for end > 0 {
+		start := bytes.LastIndexByte(data[:end-1], '\n') + 1
+		if json.Valid(data[start : end-1]) {
+			break
+		}
+		end = start
+	}
+	return data[:end]
+}
+
+// countingReader counts bytes consumed from a buffered reader. It
+// implements io.ByteReader so gzip reads from it directly, without
+// buffering ahead of the member it is decoding.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
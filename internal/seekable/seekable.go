@@ -0,0 +1,413 @@
+// Package seekable writes and reads JSONL files compressed as a series of
+// independent gzip members ("frames"), each covering a short window of
+// message time, followed by an index of the frames. The result is a valid
+// gzip file that ordinary tools decompress as plain JSONL, while readers
+// that understand the index can fetch just the frames for a time range,
+// e.g. with S3 range requests.
+//
+// Layout:
+//
+//	frame 1 | frame 2 | ... | index member | footer member
+//
+// The index member is empty, with the frame list in binary in its gzip
+// extra field. The footer is an empty member of fixed size whose extra
+// field holds the index member's offset and length, so readers start by
+// reading the last FooterSize bytes.
+package seekable
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Ext is the extension of seekable files, after .jsonl
+const Ext = ".gz"
+
+// maxFrameBytes caps the uncompressed size of a frame, so a burst of chat
+// within one window still splits into fetchable pieces
+const maxFrameBytes = 8 * 1024 * 1024
+
+// indexName is the gzip name of the index member
+const indexName = "chatlog-index"
+
+// Gzip extra subfield IDs of the index and footer
+var (
+	indexID  = [2]byte{'C', 'I'}
+	footerID = [2]byte{'C', 'L'}
+)
+
+// frameSize is the encoded size of a frame in the index: offset, length,
+// start and end (Unix milliseconds) and record count
+const frameSize = 8 + 8 + 8 + 8 + 4
+
+// maxIndexFrames is how many frames fit in a gzip extra field; files with
+// more have adjacent frames merged in the index
+const maxIndexFrames = (65535 - 4) / frameSize
+
+// ErrNoIndex is returned by ReadIndex for gzip files without an index,
+// such as compacted archives or files recovered after a crash
+var ErrNoIndex = errors.New("no seekable index")
+
+// Frame is one independently decompressible gzip member
+type Frame struct {
+	Offset  int64
+	Length  int64
+	Start   time.Time // Earliest record timestamp, to the millisecond
+	End     time.Time // Latest record timestamp, to the millisecond
+	Records int
+}
+
+// Index lists the frames of a file in file order
+type Index struct {
+	Frames []Frame
+}
+
+// Overlapping returns the frames that may hold records between start and
+// end; zero times are unbounded
+func (idx Index) Overlapping(start, end time.Time) []Frame {
+	var frames []Frame
+	for _, f := range idx.Frames {
+		// Index times are truncated to the millisecond
+		if !start.IsZero() && f.End.Add(time.Millisecond).Before(start) {
+			continue
+		}
+		if !end.IsZero() && f.Start.After(end) {
+			continue
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// Writer compresses JSONL records into frames. Records are split into a
+// new frame when their timestamp enters a later window of frameDuration
+// or the frame reaches maxFrameBytes. Close writes the index; it does not
+// close the underlying writer.
+type Writer struct {
+	out           *countingWriter
+	frameDuration time.Duration
+
+	gz      *gzip.Writer // Current frame, nil between frames
+	frame   Frame
+	window  time.Time // Window of the current frame
+	written int64     // Uncompressed bytes in the current frame
+
+	index   Index
+	pending []byte // Partial line not yet written
+	closed  bool
+}
+
+// NewWriter creates a writer starting a new frame for every window of
+// frameDuration
+func NewWriter(w io.Writer, frameDuration time.Duration) *Writer {
+	return &Writer{
+		out:           &countingWriter{w: w},
+		frameDuration: frameDuration,
+	}
+}
+
+// Write buffers p and compresses each complete line into the current frame
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("seekable writer is closed")
+	}
+
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(w.pending[:i+1]); err != nil {
+			return 0, err
+		}
+		w.pending = w.pending[i+1:]
+	}
+	if len(w.pending) == 0 {
+		w.pending = nil // Release the buffer between writes
+	}
+	return len(p), nil
+}
+
+// writeLine writes one record, starting a frame as needed
+func (w *Writer) writeLine(line []byte) error {
+	ts, _ := recordTime(line)
+	window := ts.Truncate(w.frameDuration)
+	if w.gz != nil && (window.After(w.window) || w.written >= maxFrameBytes) {
+		if err := w.endFrame(); err != nil {
+			return err
+		}
+	}
+	if w.gz == nil {
+		w.frame = Frame{Offset: w.out.n}
+		w.window = window
+		w.written = 0
+		w.gz = gzip.NewWriter(w.out)
+	}
+
+	if _, err := w.gz.Write(line); err != nil {
+		return err
+	}
+	w.written += int64(len(line))
+	w.frame.Records++
+	if !ts.IsZero() {
+		if w.frame.Start.IsZero() || ts.Before(w.frame.Start) {
+			w.frame.Start = ts
+		}
+		if ts.After(w.frame.End) {
+			w.frame.End = ts
+		}
+	}
+	return nil
+}
+
+// endFrame finishes the current gzip member and adds it to the index
+func (w *Writer) endFrame() error {
+	if err := w.gz.Close(); err != nil {
+		return err
+	}
+	w.gz = nil
+	w.frame.Length = w.out.n - w.frame.Offset
+	w.index.Frames = append(w.index.Frames, w.frame)
+	return nil
+}
+
+// Flush pushes the current frame's compressed data to the underlying
+// writer without ending the frame, so it can be recovered after a crash
+func (w *Writer) Flush() error {
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Flush()
+}
+
+// Close ends the current frame and writes the index and footer
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if len(w.pending) > 0 {
+		// A partial last line is kept, as a plain writer would
+		if err := w.writeLine(w.pending); err != nil {
+			return err
+		}
+		w.pending = nil
+	}
+	if w.gz != nil {
+		if err := w.endFrame(); err != nil {
+			return err
+		}
+	}
+	return writeIndex(w.out, w.index)
+}
+
+// writeIndex appends the index and footer members at the writer's offset
+func writeIndex(out *countingWriter, index Index) error {
+	offset := out.n
+	gz := gzip.NewWriter(out)
+	gz.Name = indexName
+	gz.Extra = encodeIndex(index.Frames)
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+
+	footer, err := encodeFooter(offset, out.n-offset)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(footer); err != nil {
+		return fmt.Errorf("write footer: %w", err)
+	}
+	return nil
+}
+
+// encodeIndex encodes frames as a gzip extra subfield, merging adjacent
+// frames until they fit. A merged frame is a run of gzip members, which
+// gzip readers decode as one stream.
+func encodeIndex(frames []Frame) []byte {
+	for len(frames) > maxIndexFrames {
+		merged := make([]Frame, 0, (len(frames)+1)/2)
+		for i := 0; i < len(frames); i += 2 {
+			f := frames[i]
+			if i+1 < len(frames) {
+				next := frames[i+1]
+				f.Length = next.Offset + next.Length - f.Offset
+				f.Records += next.Records
+				if !next.Start.IsZero() && (f.Start.IsZero() || next.Start.Before(f.Start)) {
+					f.Start = next.Start
+				}
+				if next.End.After(f.End) {
+					f.End = next.End
+				}
+			}
+			merged = append(merged, f)
+		}
+		frames = merged
+	}
+
+	extra := make([]byte, 4, 4+len(frames)*frameSize)
+	extra[0], extra[1] = indexID[0], indexID[1]
+	binary.LittleEndian.PutUint16(extra[2:], uint16(len(frames)*frameSize))
+	for _, f := range frames {
+		extra = binary.BigEndian.AppendUint64(extra, uint64(f.Offset))
+		extra = binary.BigEndian.AppendUint64(extra, uint64(f.Length))
+		extra = binary.BigEndian.AppendUint64(extra, uint64(unixMilli(f.Start)))
+		extra = binary.BigEndian.AppendUint64(extra, uint64(unixMilli(f.End)))
+		extra = binary.BigEndian.AppendUint32(extra, uint32(f.Records))
+	}
+	return extra
+}
+
+// decodeIndex decodes the frames of an index subfield
+func decodeIndex(extra []byte) ([]Frame, bool) {
+	if len(extra) < 4 || extra[0] != indexID[0] || extra[1] != indexID[1] {
+		return nil, false
+	}
+	data := extra[4:]
+	if int(binary.LittleEndian.Uint16(extra[2:])) != len(data) || len(data)%frameSize != 0 {
+		return nil, false
+	}
+
+	frames := make([]Frame, 0, len(data)/frameSize)
+	for ; len(data) > 0; data = data[frameSize:] {
+		frames = append(frames, Frame{
+			Offset:  int64(binary.BigEndian.Uint64(data)),
+			Length:  int64(binary.BigEndian.Uint64(data[8:])),
+			Start:   fromUnixMilli(int64(binary.BigEndian.Uint64(data[16:]))),
+			End:     fromUnixMilli(int64(binary.BigEndian.Uint64(data[24:]))),
+			Records: int(binary.BigEndian.Uint32(data[32:])),
+		})
+	}
+	return frames, true
+}
+
+// unixMilli returns t in Unix milliseconds, or 0 for the zero time
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// fromUnixMilli is the inverse of unixMilli
+func fromUnixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+// encodeFooter builds the footer member pointing at the index member
+func encodeFooter(offset, length int64) ([]byte, error) {
+	extra := make([]byte, 4+16)
+	extra[0], extra[1] = footerID[0], footerID[1]
+	binary.LittleEndian.PutUint16(extra[2:], 16)
+	binary.BigEndian.PutUint64(extra[4:], uint64(offset))
+	binary.BigEndian.PutUint64(extra[12:], uint64(length))
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Extra = extra
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("write footer: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// FooterSize is the size of the footer member at the end of a seekable file
+var FooterSize = func() int64 {
+	footer, err := encodeFooter(0, 0)
+	if err != nil {
+		panic(err)
+	}
+	return int64(len(footer))
+}()
+
+// ReadIndex reads the index of a seekable file of the given size. It
+// returns ErrNoIndex if the file has none.
+func ReadIndex(r io.ReaderAt, size int64) (Index, error) {
+	var index Index
+	if size < FooterSize {
+		return index, ErrNoIndex
+	}
+
+	footer := make([]byte, FooterSize)
+	if _, err := r.ReadAt(footer, size-FooterSize); err != nil {
+		return index, fmt.Errorf("read footer: %w", err)
+	}
+	offset, length, ok := decodeFooter(footer)
+	if !ok || offset < 0 || length <= 0 || offset+length > size-FooterSize {
+		return index, ErrNoIndex
+	}
+
+	member := make([]byte, length)
+	if _, err := r.ReadAt(member, offset); err != nil {
+		return index, fmt.Errorf("read index: %w", err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(member))
+	if err != nil || gz.Name != indexName {
+		return index, ErrNoIndex
+	}
+	frames, ok := decodeIndex(gz.Extra)
+	if !ok {
+		return index, fmt.Errorf("decode index: invalid frame list")
+	}
+	index.Frames = frames
+	return index, nil
+}
+
+// decodeFooter returns the index location from a footer member
+func decodeFooter(footer []byte) (offset, length int64, ok bool) {
+	gz, err := gzip.NewReader(bytes.NewReader(footer))
+	if err != nil {
+		return 0, 0, false
+	}
+	extra := gz.Extra
+	if len(extra) != 20 || extra[0] != footerID[0] || extra[1] != footerID[1] {
+		return 0, 0, false
+	}
+	return int64(binary.BigEndian.Uint64(extra[4:])), int64(binary.BigEndian.Uint64(extra[12:])), true
+}
+
+// OpenFrame returns a reader for the decompressed records of one frame.
+// The frame is read with a single ReadAt, which is one request when r is
+// backed by range requests.
+func OpenFrame(r io.ReaderAt, frame Frame) (io.ReadCloser, error) {
+	data := make([]byte, frame.Length)
+	if _, err := r.ReadAt(data, frame.Offset); err != nil {
+		return nil, err
+	}
+	return gzip.NewReader(bytes.NewReader(data))
+}
+
+// recordTime returns the "timestamp" of a JSONL record
+func recordTime(line []byte) (time.Time, error) {
+	var record struct {
+		Timestamp string `json:"timestamp"`
+	}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return time.Time{}, err
+	}
+	ts, err := time.Parse(time.RFC3339Nano, record.Timestamp)
+	return ts.UTC(), err
+}
+
+// countingWriter counts bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// Sink receives a copy of every recorded message
+type Sink interface {
+	// Start consumes messages until the context is cancelled
+	Start(ctx context.Context, messages <-chan message.Message) error
+}
+
+// output is a sink registered with a fanout
+type output struct {
+	name    string
+	sink    Sink
+	ch      chan message.Message
+	accept  func(message.Message) bool // nil accepts every message
+	dropped atomic.Int64
+}
+
+// Fanout copies messages to the recorder and to any number of sinks.
+// The recorder is authoritative and receives every message; sinks have
+// their own buffers and drop messages rather than slow down recording.
+type Fanout struct {
+	primary    chan<- message.Message
+	bufferSize int
+	outputs    []*output
+}
+
+// NewFanout creates a fanout forwarding to primary. Each sink gets a buffer
+// of bufferSize messages.
+func NewFanout(primary chan<- message.Message, bufferSize int) *Fanout {
+	return &Fanout{
+		primary:    primary,
+		bufferSize: bufferSize,
+	}
+}
+
+// Add registers a sink; it must be called before Start
+func (f *Fanout) Add(name string, s Sink) {
+	f.outputs = append(f.outputs, &output{
+		name: name,
+		sink: s,
+		ch:   make(chan message.Message, f.bufferSize),
+	})
+}
+
+// AddFiltered registers a sink that only receives messages accept returns
+// true for; it must be called before Start
+func (f *Fanout) AddFiltered(name string, s Sink, accept func(message.Message) bool) {
+	f.Add(name, s)
+	f.outputs[len(f.outputs)-1].accept = accept
+}
+
+// Len returns the number of registered sinks
+func (f *Fanout) Len() int {
+	return len(f.outputs)
+}
+
+// Start runs the sinks and distributes messages until the context is
+// cancelled, or until in is closed, in which case the primary channel is
+// closed too so the recorder can drain
+func (f *Fanout) Start(ctx context.Context, in <-chan message.Message) error {
+	for _, out := range f.outputs {
+		go func(out *output) {
+			if err := out.sink.Start(ctx, out.ch); err != nil && err != context.Canceled {
+				log.Printf("Sink %s error: %v", out.name, err)
+			}
+		}(out)
+	}
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				close(f.primary)
+				return nil
+			}
+			select {
+			case f.primary <- msg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			for _, out := range f.outputs {
+				if out.accept != nil && !out.accept(msg) {
+					continue
+				}
+				select {
+				case out.ch <- msg:
+				default:
+					out.dropped.Add(1)
+				}
+			}
+
+		case <-ticker.C:
+			for _, out := range f.outputs {
+				if dropped := out.dropped.Swap(0); dropped > 0 {
+					log.Printf("Warning: sink %s is falling behind, dropped %d message(s) in the last minute", out.name, dropped)
+				}
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package sink
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/john/chatlog/internal/message"
+)
+
+// overlayClientBuffer is how many events are queued per overlay client
+// before it is considered too slow and events are dropped
+const overlayClientBuffer = 256
+
+// OverlaySink relays live chat to browser overlays (OBS browser sources,
+// StreamElements custom widgets) over WebSocket. Clients connect with
+// ?platform=twitch&channel=name and receive one JSON event per chat
+// message, shaped like a StreamElements "message" event, with emote image
+// URLs resolved and an HTML rendering of the text.
+type OverlaySink struct {
+	token    string
+	upgrader websocket.Upgrader
+
+	clients map[*overlayClient]struct{}
+	mu      sync.Mutex
+}
+
+// overlayClient is a connected overlay watching one channel
+type overlayClient struct {
+	conn     *websocket.Conn
+	platform string
+	channel  string
+	send     chan []byte
+}
+
+// NewOverlaySink creates an overlay relay. If token is not empty, clients
+// must pass it as ?token=.
+func NewOverlaySink(token string) *OverlaySink {
+	return &OverlaySink{
+		token: token,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		clients: make(map[*overlayClient]struct{}),
+	}
+}
+
+// ServeHTTP upgrades an overlay connection
+func (s *OverlaySink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(query.Get("token")), []byte(s.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	channel := strings.ToLower(query.Get("channel"))
+	if channel == "" {
+		http.Error(w, "channel is required", http.StatusBadRequest)
+		return
+	}
+	platform := query.Get("platform")
+	if platform == "" {
+		platform = "twitch"
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Overlay WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	client := &overlayClient{
+		conn:     conn,
+		platform: platform,
+		channel:  channel,
+		send:     make(chan []byte, overlayClientBuffer),
+	}
+	s.mu.Lock()
+	s.clients[client] = struct{}{}
+	s.mu.Unlock()
+	log.Printf("Overlay client connected for %s/%s: %s", platform, channel, r.RemoteAddr)
+
+	go s.writeLoop(client)
+	// Drain reads so control frames are handled; remove the client on close
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				s.remove(client)
+				return
+			}
+		}
+	}()
+}
+
+// writeLoop sends queued events to a client until it is removed
+func (s *OverlaySink) writeLoop(client *overlayClient) {
+	for data := range client.send {
+		client.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := client.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			s.remove(client)
+			return
+		}
+	}
+}
+
+// remove disconnects a client
+func (s *OverlaySink) remove(client *overlayClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+	delete(s.clients, client)
+	close(client.send)
+	client.conn.Close()
+}
+
+// Start relays chat messages to matching clients until the context is
+// cancelled
+func (s *OverlaySink) Start(ctx context.Context, messages <-chan message.Message) error {
+	for {
+		select {
+		case msg := <-messages:
+			if msg.Type != "" {
+				continue // Only chat is shown on overlays
+			}
+			s.broadcast(msg)
+
+		case <-ctx.Done():
+			s.mu.Lock()
+			for client := range s.clients {
+				delete(s.clients, client)
+				close(client.send)
+				client.conn.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// broadcast queues a message for the clients watching its channel
+func (s *OverlaySink) broadcast(msg message.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var data []byte
+	for client := range s.clients {
+		if client.platform != msg.Platform || client.channel != strings.ToLower(msg.Channel) {
+			continue
+		}
+		if data == nil {
+			var err error
+			if data, err = json.Marshal(overlayMessage(msg)); err != nil {
+				log.Printf("Error encoding overlay event: %v", err)
+				return
+			}
+		}
+		select {
+		case client.send <- data:
+		default:
+			// Slow client; overlays only care about recent chat
+		}
+	}
+}
+
+// overlayEvent is a StreamElements-style widget event
+type overlayEvent struct {
+	Listener string `json:"listener"`
+	Event    struct {
+		Service      string      `json:"service"`
+		Data         overlayData `json:"data"`
+		RenderedText string      `json:"renderedText"`
+	} `json:"event"`
+}
+
+// overlayData is the message payload of an overlay event
+type overlayData struct {
+	Time        int64          `json:"time"` // Unix milliseconds
+	Nick        string         `json:"nick"`
+	UserID      string         `json:"userId"`
+	DisplayName string         `json:"displayName"`
+	Badges      []overlayBadge `json:"badges"`
+	Channel     string         `json:"channel"`
+	Text        string         `json:"text"`
+	Emotes      []overlayEmote `json:"emotes"`
+	MsgID       string         `json:"msgId"`
+}
+
+// overlayBadge is a chat badge
+type overlayBadge struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+}
+
+// overlayEmote is an emote use with its image URLs at 1x, 2x and 4x
+type overlayEmote struct {
+	Type  string            `json:"type"`
+	Name  string            `json:"name"`
+	ID    string            `json:"id"`
+	Gif   bool              `json:"gif"`
+	URLs  map[string]string `json:"urls"`
+	Start int               `json:"start"` // Rune offsets in text, end exclusive
+	End   int               `json:"end"`
+}
+
+// kickEmoteRe matches Kick's inline emote markup
+var kickEmoteRe = regexp.MustCompile(`\[emote:(\d+):([^\]]+)\]`)
+
+// overlayMessage converts a chat message to an overlay event
+func overlayMessage(msg message.Message) overlayEvent {
+	var ev overlayEvent
+	ev.Listener = "message"
+	ev.Event.Service = msg.Platform
+
+	text, emotes := overlayEmotes(msg)
+	data := overlayData{
+		Nick:        strings.ToLower(msg.Username),
+		UserID:      msg.UserID,
+		DisplayName: msg.Username,
+		Badges:      []overlayBadge{},
+		Channel:     msg.Channel,
+		Text:        text,
+		Emotes:      emotes,
+		MsgID:       strconv.FormatUint(msg.Sequence, 10),
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, msg.Timestamp); err == nil {
+		data.Time = ts.UnixMilli()
+	}
+	if msg.Badges != "" {
+		for _, badge := range strings.Split(msg.Badges, ",") {
+			kind, version, _ := strings.Cut(badge, ":")
+			data.Badges = append(data.Badges, overlayBadge{Type: kind, Version: version})
+		}
+	}
+
+	ev.Event.Data = data
+	ev.Event.RenderedText = renderText(text, emotes)
+	return ev
+}
+
+// overlayEmotes returns the message text with any inline emote markup
+// replaced by emote names, and the emotes in it with their URLs
+func overlayEmotes(msg message.Message) (string, []overlayEmote) {
+	emotes := []overlayEmote{}
+	switch {
+	case len(msg.EmoteRefs) > 0 && msg.Platform == "twitch":
+		for _, ref := range msg.EmoteRefs {
+			base := "https://static-cdn.jtvnw.net/emoticons/v2/" + ref.ID + "/default/dark/"
+			emotes = append(emotes, overlayEmote{
+				Type:  "twitch",
+				Name:  ref.Name,
+				ID:    ref.ID,
+				URLs:  map[string]string{"1": base + "1.0", "2": base + "2.0", "4": base + "3.0"},
+				Start: ref.Start,
+				End:   ref.End,
+			})
+		}
+		return msg.Message, emotes
+
+	case msg.Platform == "kick":
+		var out strings.Builder
+		offset := 0 // Runes written to out
+		last := 0
+		for _, m := range kickEmoteRe.FindAllStringSubmatchIndex(msg.Message, -1) {
+			before := msg.Message[last:m[0]]
+			out.WriteString(before)
+			offset += len([]rune(before))
+
+			id, name := msg.Message[m[2]:m[3]], msg.Message[m[4]:m[5]]
+			url := fmt.Sprintf("https://files.kick.com/emotes/%s/fullsize", id)
+			emotes = append(emotes, overlayEmote{
+				Type:  "kick",
+				Name:  name,
+				ID:    id,
+				URLs:  map[string]string{"1": url, "2": url, "4": url},
+				Start: offset,
+				End:   offset + len([]rune(name)),
+			})
+			out.WriteString(name)
+			offset += len([]rune(name))
+			last = m[1]
+		}
+		out.WriteString(msg.Message[last:])
+		return out.String(), emotes
+	}
+	return msg.Message, emotes
+}
+
+// renderText renders text as HTML with emotes replaced by images
+func renderText(text string, emotes []overlayEmote) string {
+	runes := []rune(text)
+	var out strings.Builder
+	pos := 0
+	for _, e := range emotes {
+		if e.Start < pos || e.End > len(runes) || e.Start >= e.End {
+			continue // Overlapping or out of range
+		}
+		out.WriteString(html.EscapeString(string(runes[pos:e.Start])))
+		fmt.Fprintf(&out, `<img src="%s" alt="%s" class="emote">`, html.EscapeString(e.URLs["1"]), html.EscapeString(e.Name))
+		pos = e.End
+	}
+	out.WriteString(html.EscapeString(string(runes[pos:])))
+	return out.String()
+}
@@ -0,0 +1,348 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/john/chatlog/internal/message"
+)
+
+const (
+	// postgresFlushInterval is the longest a message waits to be written
+	postgresFlushInterval = time.Second
+
+	// postgresMaintainInterval is how often partitions are created ahead
+	// and expired ones dropped
+	postgresMaintainInterval = time.Hour
+
+	// postgresPartitionFormat is the date suffix of daily partitions
+	postgresPartitionFormat = "20060102"
+)
+
+// postgresColumns are the columns written for each message
+var postgresColumns = []string{
+	"sent_at", "received_at", "seq", "platform", "channel", "channel_id",
+	"id", "username", "user_id", "type", "message", "record",
+}
+
+// PostgresSink keeps the last few days of messages in a PostgreSQL table
+// for operational queries; S3 stays the long-term archive. The table is
+// partitioned by day on the send time, so expiring old messages drops a
+// whole partition instead of deleting rows. With TimescaleDB it is a
+// hypertable with a retention policy instead. Messages are written in
+// batches with COPY; a batch that fails is dropped.
+type PostgresSink struct {
+	dsn       string
+	table     string
+	retention int // Days
+	batchSize int
+	timescale bool
+
+	ready      bool            // The table and indexes exist
+	partitions map[string]bool // Days known to have a partition
+}
+
+// NewPostgresSink creates a sink writing to table, keeping retentionDays
+// days of messages. With timescale, the table is made a TimescaleDB
+// hypertable instead of using native partitions.
+func NewPostgresSink(dsn, table string, retentionDays, batchSize int, timescale bool) *PostgresSink {
+	return &PostgresSink{
+		dsn:        dsn,
+		table:      table,
+		retention:  retentionDays,
+		batchSize:  batchSize,
+		timescale:  timescale,
+		partitions: make(map[string]bool),
+	}
+}
+
+// Start writes messages until the context is cancelled
+func (s *PostgresSink) Start(ctx context.Context, messages <-chan message.Message) error {
+	pool, err := pgxpool.New(ctx, s.dsn)
+	if err != nil {
+		return fmt.Errorf("postgres sink: %w", err)
+	}
+	defer pool.Close()
+
+	if err := s.setup(ctx, pool); err != nil {
+		log.Printf("Warning: Postgres sink cannot set up table %s: %v (will keep trying)", s.table, err)
+	} else {
+		log.Printf("Connected to Postgres sink, writing to table %s", s.table)
+		s.maintain(ctx, pool)
+	}
+
+	flushTicker := time.NewTicker(postgresFlushInterval)
+	defer flushTicker.Stop()
+	maintainTicker := time.NewTicker(postgresMaintainInterval)
+	defer maintainTicker.Stop()
+
+	batch := make([]message.Message, 0, s.batchSize)
+	var failures int
+	lastLog := time.Time{}
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.write(ctx, pool, batch); err != nil {
+			failures += len(batch)
+			// Avoid flooding the log while Postgres is down
+			if time.Since(lastLog) > time.Minute {
+				log.Printf("Error writing to Postgres: %v (%d message(s) dropped)", err, failures)
+				lastLog = time.Now()
+				failures = 0
+			}
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case msg := <-messages:
+			batch = append(batch, msg)
+			if len(batch) >= s.batchSize {
+				flush(ctx)
+			}
+
+		case <-flushTicker.C:
+			flush(ctx)
+
+		case <-maintainTicker.C:
+			s.maintain(ctx, pool)
+
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			flush(flushCtx)
+			cancel()
+			return ctx.Err()
+		}
+	}
+}
+
+// setup creates the table and its indexes if they don't exist
+func (s *PostgresSink) setup(ctx context.Context, pool *pgxpool.Pool) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	table := pgx.Identifier{s.table}.Sanitize()
+	partitioning := " PARTITION BY RANGE (sent_at)"
+	if s.timescale {
+		partitioning = ""
+	}
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ` + table + ` (
+			sent_at timestamptz NOT NULL,
+			received_at timestamptz NOT NULL,
+			seq bigint NOT NULL,
+			platform text NOT NULL,
+			channel text NOT NULL,
+			channel_id text,
+			id text,
+			username text,
+			user_id text,
+			type text,
+			message text,
+			record jsonb NOT NULL
+		)` + partitioning,
+		`CREATE INDEX IF NOT EXISTS ` + pgx.Identifier{s.table + "_channel_idx"}.Sanitize() +
+			` ON ` + table + ` (platform, channel, sent_at)`,
+		`CREATE INDEX IF NOT EXISTS ` + pgx.Identifier{s.table + "_user_idx"}.Sanitize() +
+			` ON ` + table + ` (platform, user_id, sent_at)`,
+	}
+	for _, statement := range statements {
+		if _, err := pool.Exec(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	if s.timescale {
+		if _, err := pool.Exec(ctx, `SELECT create_hypertable($1::regclass, 'sent_at', chunk_time_interval => INTERVAL '1 day', if_not_exists => TRUE)`, table); err != nil {
+			return fmt.Errorf("create hypertable: %w", err)
+		}
+		if _, err := pool.Exec(ctx, `SELECT add_retention_policy($1::regclass, make_interval(days => $2::int), if_not_exists => TRUE)`, table, s.retention); err != nil {
+			return fmt.Errorf("add retention policy: %w", err)
+		}
+	}
+
+	s.ready = true
+	return nil
+}
+
+// maintain creates partitions for today and tomorrow, so writes around
+// midnight don't wait on one, and drops partitions past the retention
+// period. TimescaleDB does both itself.
+func (s *PostgresSink) maintain(ctx context.Context, pool *pgxpool.Pool) {
+	if !s.ready || s.timescale {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for _, day := range []time.Time{today, today.AddDate(0, 0, 1)} {
+		if err := s.ensurePartition(ctx, pool, day); err != nil {
+			log.Printf("Warning: Failed to create Postgres partition for %s: %v", day.Format(time.DateOnly), err)
+		}
+	}
+
+	rows, err := pool.Query(ctx, `SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = $1::regclass`,
+		pgx.Identifier{s.table}.Sanitize())
+	if err != nil {
+		log.Printf("Warning: Failed to list Postgres partitions of %s: %v", s.table, err)
+		return
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Printf("Warning: Failed to list Postgres partitions of %s: %v", s.table, err)
+		return
+	}
+
+	cutoff := s.cutoff()
+	for _, name := range names {
+		suffix, ok := strings.CutPrefix(name, s.table+"_")
+		if !ok {
+			continue
+		}
+		day, err := time.Parse(postgresPartitionFormat, suffix)
+		if err != nil || day.AddDate(0, 0, 1).After(cutoff) {
+			continue
+		}
+		if _, err := pool.Exec(ctx, `DROP TABLE IF EXISTS `+pgx.Identifier{name}.Sanitize()); err != nil {
+			log.Printf("Warning: Failed to drop expired Postgres partition %s: %v", name, err)
+			continue
+		}
+		delete(s.partitions, suffix)
+		log.Printf("Dropped expired Postgres partition %s", name)
+	}
+}
+
+// ensurePartition creates the partition holding day, a UTC midnight
+func (s *PostgresSink) ensurePartition(ctx context.Context, pool *pgxpool.Pool, day time.Time) error {
+	suffix := day.Format(postgresPartitionFormat)
+	if s.partitions[suffix] {
+		return nil
+	}
+	// Bounds are UTC literals, so the session time zone doesn't shift them
+	statement := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')`,
+		pgx.Identifier{s.table + "_" + suffix}.Sanitize(),
+		pgx.Identifier{s.table}.Sanitize(),
+		day.Format("2006-01-02 15:04:05Z07:00"),
+		day.AddDate(0, 0, 1).Format("2006-01-02 15:04:05Z07:00"))
+	if _, err := pool.Exec(ctx, statement); err != nil {
+		return err
+	}
+	s.partitions[suffix] = true
+	return nil
+}
+
+// cutoff returns the send time before which messages have expired
+func (s *PostgresSink) cutoff() time.Time {
+	return time.Now().AddDate(0, 0, -s.retention)
+}
+
+// write copies a batch into the table. Messages already past the retention
+// period, such as replayed ones, are skipped.
+func (s *PostgresSink) write(ctx context.Context, pool *pgxpool.Pool, batch []message.Message) error {
+	if !s.ready {
+		if err := s.setup(ctx, pool); err != nil {
+			return fmt.Errorf("set up table: %w", err)
+		}
+		s.maintain(ctx, pool)
+	}
+
+	cutoff := s.cutoff()
+	rows := make([][]any, 0, len(batch))
+	for _, msg := range batch {
+		sentAt, err := time.Parse(time.RFC3339Nano, msg.Timestamp)
+		if err != nil {
+			continue
+		}
+		if sentAt.Before(cutoff) {
+			continue
+		}
+		receivedAt, err := time.Parse(time.RFC3339Nano, msg.ReceivedAt)
+		if err != nil {
+			receivedAt = sentAt
+		}
+		if !s.timescale {
+			if err := s.ensurePartition(ctx, pool, sentAt.UTC().Truncate(24*time.Hour)); err != nil {
+				return fmt.Errorf("create partition: %w", err)
+			}
+		}
+
+		record, err := postgresJSON(msg)
+		if err != nil {
+			return fmt.Errorf("marshal message: %w", err)
+		}
+
+		rows = append(rows, []any{
+			sentAt, receivedAt, int64(msg.Sequence), postgresText(msg.Platform), postgresText(msg.Channel),
+			nullable(postgresText(msg.ChannelID)), nullable(postgresText(msg.ID)), postgresText(msg.Username),
+			postgresText(msg.UserID), nullable(postgresText(msg.Type)), postgresText(msg.Message), json.RawMessage(record),
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err := pool.CopyFrom(ctx, pgx.Identifier{s.table}, postgresColumns, pgx.CopyFromRows(rows))
+	return err
+}
+
+// postgresText drops NUL characters, which Postgres text can't hold
+func postgresText(s string) string {
+	return strings.ReplaceAll(s, "\x00", "")
+}
+
+// postgresJSON marshals v for a jsonb column, which can't hold NUL
+// characters either. Any in a string or key anywhere in the record, such
+// as a reply's text or enrichment, are dropped.
+func postgresJSON(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || !bytes.Contains(data, []byte(`\u0000`)) {
+		return data, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var decoded any
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(stripNUL(decoded))
+}
+
+// stripNUL drops NUL characters from the strings of a decoded JSON value
+func stripNUL(v any) any {
+	switch v := v.(type) {
+	case string:
+		return postgresText(v)
+	case []any:
+		for i := range v {
+			v[i] = stripNUL(v[i])
+		}
+	case map[string]any:
+		stripped := make(map[string]any, len(v))
+		for key, value := range v {
+			stripped[postgresText(key)] = stripNUL(value)
+		}
+		return stripped
+	}
+	return v
+}
+
+// nullable stores an empty string as NULL
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,33 @@
+package sink
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+func TestPostgresJSONDropsNUL(t *testing.T) {
+	msg := message.New("twitch", time.Now())
+	msg.Channel = "chan"
+	msg.Username = "nul\x00name"
+	msg.Message = `a literal \u0000 stays`
+	msg.Reply = &message.Reply{ParentID: "1", ParentText: "parent\x00text"}
+	msg.Enrichment = map[string]any{"key\x00": []any{"nested\x00"}}
+
+	record, err := postgresJSON(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got message.Message
+	if err := json.Unmarshal(record, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != "nulname" || got.Message != `a literal \u0000 stays` || got.Reply.ParentText != "parenttext" {
+		t.Errorf("got username %q, message %q, reply %q", got.Username, got.Message, got.Reply.ParentText)
+	}
+	if nested, ok := got.Enrichment["key"].([]any); !ok || nested[0] != "nested" {
+		t.Errorf("enrichment = %v", got.Enrichment)
+	}
+}
@@ -0,0 +1,102 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisSink appends messages to per-channel Redis Streams.
+// Streams are named {prefix}:{platform}:{channel}, so consumers can create
+// consumer groups per channel or subscribe to several with one XREADGROUP.
+type RedisSink struct {
+	client      *redis.Client
+	keyPrefix   string
+	maxLen      int64
+	approximate bool
+}
+
+// NewRedisSink creates a Redis Streams sink. Streams are trimmed to about
+// maxLen entries (exactly, if approximate is false); 0 disables trimming.
+func NewRedisSink(addr, password string, db int, keyPrefix string, maxLen int64, approximate bool) *RedisSink {
+	return &RedisSink{
+		client: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: password,
+			DB:       db,
+		}),
+		keyPrefix:   keyPrefix,
+		maxLen:      maxLen,
+		approximate: approximate,
+	}
+}
+
+// Start XADDs messages until the context is cancelled
+func (s *RedisSink) Start(ctx context.Context, messages <-chan message.Message) error {
+	defer s.client.Close()
+
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		log.Printf("Warning: Redis sink cannot reach server: %v (will keep trying)", err)
+	} else {
+		log.Printf("Connected to Redis sink at %s", s.client.Options().Addr)
+	}
+
+	var failures int
+	lastLog := time.Time{}
+
+	for {
+		select {
+		case msg := <-messages:
+			if err := s.add(ctx, msg); err != nil {
+				failures++
+				// Avoid flooding the log while Redis is down
+				if time.Since(lastLog) > time.Minute {
+					log.Printf("Error writing to Redis stream: %v (%d failure(s))", err, failures)
+					lastLog = time.Now()
+					failures = 0
+				}
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// StreamKey returns the stream a message is written to
+func (s *RedisSink) StreamKey(msg message.Message) string {
+	return fmt.Sprintf("%s:%s:%s", s.keyPrefix, msg.Platform, msg.Channel)
+}
+
+// add writes a single message
+func (s *RedisSink) add(ctx context.Context, msg message.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.StreamKey(msg),
+		MaxLen: s.maxLen,
+		Approx: s.approximate,
+		Values: map[string]any{
+			"platform":  msg.Platform,
+			"channel":   msg.Channel,
+			"timestamp": msg.Timestamp,
+			"seq":       strconv.FormatUint(msg.Sequence, 10),
+			"username":  msg.Username,
+			"user_id":   msg.UserID,
+			"message":   msg.Message,
+			"json":      string(data),
+		},
+	}).Err()
+}
@@ -0,0 +1,57 @@
+package sink
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory creates a sink from its YAML options, which may be nil
+type Factory func(options map[string]any) (Sink, error)
+
+var (
+	registry   = make(map[string]Factory)
+	registryMu sync.Mutex
+)
+
+// Register makes a sink available to the sinks.custom config under name.
+// It is typically called from an init function; registering a name twice
+// panics.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("sink: %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Names returns the registered sink names, sorted
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the sink registered under name
+func New(name string, options map[string]any) (Sink, error) {
+	registryMu.Lock()
+	factory, ok := registry[name]
+	registryMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q (registered: %v)", name, Names())
+	}
+
+	s, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("sink %s: %w", name, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,67 @@
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+// tickInterval is how often rates are folded into the moving averages
+const tickInterval = 5 * time.Second
+
+// Smoothing factors for 1, 5 and 15 minute windows at tickInterval,
+// as used for Unix load averages
+var (
+	alpha1m  = 1 - math.Exp(-tickInterval.Seconds()/60)
+	alpha5m  = 1 - math.Exp(-tickInterval.Seconds()/300)
+	alpha15m = 1 - math.Exp(-tickInterval.Seconds()/900)
+)
+
+// ewma is an exponentially weighted moving average of a per-second rate.
+// It is not safe for concurrent use; the registry serializes access.
+type ewma struct {
+	alpha       float64
+	rate        float64
+	initialized bool
+}
+
+// tick folds count events over one tickInterval into the average
+func (e *ewma) tick(count int64) {
+	instant := float64(count) / tickInterval.Seconds()
+	if !e.initialized {
+		e.rate = instant
+		e.initialized = true
+		return
+	}
+	e.rate += e.alpha * (instant - e.rate)
+}
+
+// rates tracks 1m, 5m and 15m moving averages of one event stream
+type rates struct {
+	pending int64 // events since the last tick
+	total   int64
+	m1      ewma
+	m5      ewma
+	m15     ewma
+}
+
+func newRates() *rates {
+	return &rates{
+		m1:  ewma{alpha: alpha1m},
+		m5:  ewma{alpha: alpha5m},
+		m15: ewma{alpha: alpha15m},
+	}
+}
+
+// mark records one event
+func (r *rates) mark() {
+	r.pending++
+	r.total++
+}
+
+// tick folds pending events into the averages
+func (r *rates) tick() {
+	r.m1.tick(r.pending)
+	r.m5.tick(r.pending)
+	r.m15.tick(r.pending)
+	r.pending = 0
+}
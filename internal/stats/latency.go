@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the end-to-end latency
+// histogram: from 1 minute to 2 hours, dense around typical rotation times
+var LatencyBuckets = []float64{60, 120, 300, 600, 900, 1200, 1800, 2700, 3600, 5400, 7200}
+
+// ReceivedMinuteLayout keys receive-time counts by minute. It is a prefix of
+// message.TimestampFormat, so a record's received_at can be cut to it
+// without parsing.
+const ReceivedMinuteLayout = "2006-01-02T15:04"
+
+// channelLatency is one channel's histogram
+type channelLatency struct {
+	counts []int64 // Per bucket, plus +Inf
+	sum    float64
+	total  int64
+}
+
+// Latency is a per-channel histogram of how long messages take from being
+// received to being stored durably, in the file that holds them
+type Latency struct {
+	channels map[string]*channelLatency // key: "platform\x00channel"
+	mu       sync.Mutex
+}
+
+// NewLatency creates an empty latency histogram
+func NewLatency() *Latency {
+	return &Latency{channels: make(map[string]*channelLatency)}
+}
+
+// ObserveFile records the messages of a file stored at storedAt, given how
+// many were received in each minute (keyed by ReceivedMinuteLayout). Each
+// minute's messages are counted at its middle, so latencies are accurate to
+// within 30 seconds.
+func (l *Latency) ObserveFile(platform, channel string, received map[string]int64, storedAt time.Time) {
+	if len(received) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := platform + "\x00" + channel
+	c := l.channels[key]
+	if c == nil {
+		c = &channelLatency{counts: make([]int64, len(LatencyBuckets)+1)}
+		l.channels[key] = c
+	}
+	for minute, n := range received {
+		t, err := time.Parse(ReceivedMinuteLayout, minute)
+		if err != nil || n <= 0 {
+			continue
+		}
+		seconds := max(storedAt.Sub(t.Add(30*time.Second)).Seconds(), 0)
+		bucket, _ := slices.BinarySearch(LatencyBuckets, seconds)
+		c.counts[bucket] += n
+		c.sum += seconds * float64(n)
+		c.total += n
+	}
+}
+
+// WriteMetrics writes the histograms in Prometheus text format
+func (l *Latency) WriteMetrics(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := make([]string, 0, len(l.channels))
+	for key := range l.channels {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	const name = "chatlog_end_to_end_latency_seconds"
+	fmt.Fprintf(w, "# HELP %s Time from receiving a message to storing the file that holds it.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, key := range keys {
+		platform, channel, _ := strings.Cut(key, "\x00")
+		labels := fmt.Sprintf("platform=%q,channel=%q", platform, channel)
+		c := l.channels[key]
+
+		var cumulative int64
+		for i, bound := range LatencyBuckets {
+			cumulative += c.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, c.total)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, c.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, c.total)
+	}
+}
@@ -0,0 +1,253 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ChannelStats is a point-in-time view of one channel's message rates
+type ChannelStats struct {
+	Platform    string     `json:"platform"`
+	Channel     string     `json:"channel"`
+	Total       int64      `json:"total"`
+	Rate1m      float64    `json:"rate_1m"` // Messages per second
+	Rate5m      float64    `json:"rate_5m"`
+	Rate15m     float64    `json:"rate_15m"`
+	LastMessage *time.Time `json:"last_message,omitempty"`
+	Live        bool       `json:"live"`   // Stream is live, when the platform reports it
+	Silent      bool       `json:"silent"` // Live but no messages for the silence threshold
+}
+
+// Snapshot is a point-in-time view of all message rates
+type Snapshot struct {
+	Total    int64          `json:"total"`
+	Rate1m   float64        `json:"rate_1m"`
+	Rate5m   float64        `json:"rate_5m"`
+	Rate15m  float64        `json:"rate_15m"`
+	Channels []ChannelStats `json:"channels"`
+}
+
+// channelState holds the rates and liveness of one channel
+type channelState struct {
+	platform    string
+	channel     string
+	rates       *rates
+	lastMessage time.Time
+	live        bool
+	liveSince   time.Time
+	silent      bool
+}
+
+// Registry tracks global and per-channel message rates, and flags channels
+// that are live but have gone silent, which a binary health check misses
+type Registry struct {
+	silentAfter time.Duration
+
+	global   *rates
+	channels map[string]*channelState // key: "platform/channel"
+	mu       sync.Mutex
+}
+
+// New creates a stats registry. Live channels with no messages for
+// silentAfter are flagged as silent; 0 disables the detector.
+func New(silentAfter time.Duration) *Registry {
+	return &Registry{
+		silentAfter: silentAfter,
+		global:      newRates(),
+		channels:    make(map[string]*channelState),
+	}
+}
+
+// Observe records one message for a channel
+func (r *Registry) Observe(platform, channel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := r.channel(platform, channel)
+	state.rates.mark()
+	state.lastMessage = time.Now()
+	r.global.mark()
+}
+
+// SetLive replaces the set of live channels for a platform. Channels of the
+// platform not in live are marked offline.
+func (r *Registry) SetLive(platform string, live map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for channel := range live {
+		r.channel(platform, channel)
+	}
+	for _, state := range r.channels {
+		if state.platform != platform {
+			continue
+		}
+		isLive := live[state.channel]
+		if isLive && !state.live {
+			state.liveSince = now
+		}
+		state.live = isLive
+	}
+}
+
+// channel returns the state for a channel, creating it if needed.
+// r.mu must be held.
+func (r *Registry) channel(platform, channel string) *channelState {
+	key := platform + "/" + channel
+	state, ok := r.channels[key]
+	if !ok {
+		state = &channelState{platform: platform, channel: channel, rates: newRates()}
+		r.channels[key] = state
+	}
+	return state
+}
+
+// Start updates the moving averages until the context is cancelled
+func (r *Registry) Start(ctx context.Context) error {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.tick()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// tick folds the last interval into every average and updates silence flags
+func (r *Registry) tick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.global.tick()
+	for _, state := range r.channels {
+		state.rates.tick()
+
+		silent := false
+		if r.silentAfter > 0 && state.live {
+			// Measure from when the stream went live if it has been quiet since
+			quietSince := state.lastMessage
+			if quietSince.Before(state.liveSince) {
+				quietSince = state.liveSince
+			}
+			silent = now.Sub(quietSince) >= r.silentAfter
+		}
+
+		if silent && !state.silent {
+			log.Printf("Warning: %s channel %s is live but has had no messages for %v", state.platform, state.channel, r.silentAfter)
+		} else if !silent && state.silent {
+			log.Printf("%s channel %s is no longer silent", state.platform, state.channel)
+		}
+		state.silent = silent
+	}
+}
+
+// Snapshot returns the current rates, with channels sorted by platform and name
+func (r *Registry) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snap := Snapshot{
+		Total:    r.global.total,
+		Rate1m:   r.global.m1.rate,
+		Rate5m:   r.global.m5.rate,
+		Rate15m:  r.global.m15.rate,
+		Channels: make([]ChannelStats, 0, len(r.channels)),
+	}
+	for _, state := range r.channels {
+		var lastMessage *time.Time
+		if !state.lastMessage.IsZero() {
+			t := state.lastMessage.UTC()
+			lastMessage = &t
+		}
+		snap.Channels = append(snap.Channels, ChannelStats{
+			Platform:    state.platform,
+			Channel:     state.channel,
+			Total:       state.rates.total,
+			Rate1m:      state.rates.m1.rate,
+			Rate5m:      state.rates.m5.rate,
+			Rate15m:     state.rates.m15.rate,
+			LastMessage: lastMessage,
+			Live:        state.live,
+			Silent:      state.silent,
+		})
+	}
+	sort.Slice(snap.Channels, func(i, j int) bool {
+		a, b := snap.Channels[i], snap.Channels[j]
+		if a.Platform != b.Platform {
+			return a.Platform < b.Platform
+		}
+		return a.Channel < b.Channel
+	})
+	return snap
+}
+
+// ServeHTTP serves the snapshot as JSON
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(r.Snapshot())
+}
+
+// WriteMetrics writes the snapshot in the Prometheus text format
+func (r *Registry) WriteMetrics(w io.Writer) {
+	snap := r.Snapshot()
+
+	fmt.Fprintln(w, "# HELP chatlog_messages_total Messages received.")
+	fmt.Fprintln(w, "# TYPE chatlog_messages_total counter")
+	fmt.Fprintf(w, "chatlog_messages_total %d\n", snap.Total)
+	for _, c := range snap.Channels {
+		fmt.Fprintf(w, "chatlog_messages_total{%s} %d\n", labels(c), c.Total)
+	}
+
+	fmt.Fprintln(w, "# HELP chatlog_message_rate Messages per second, exponentially weighted over the window.")
+	fmt.Fprintln(w, "# TYPE chatlog_message_rate gauge")
+	for _, window := range []struct {
+		name string
+		rate float64
+	}{{"1m", snap.Rate1m}, {"5m", snap.Rate5m}, {"15m", snap.Rate15m}} {
+		fmt.Fprintf(w, "chatlog_message_rate{window=%q} %g\n", window.name, window.rate)
+	}
+	for _, c := range snap.Channels {
+		fmt.Fprintf(w, "chatlog_message_rate{%s,window=\"1m\"} %g\n", labels(c), c.Rate1m)
+		fmt.Fprintf(w, "chatlog_message_rate{%s,window=\"5m\"} %g\n", labels(c), c.Rate5m)
+		fmt.Fprintf(w, "chatlog_message_rate{%s,window=\"15m\"} %g\n", labels(c), c.Rate15m)
+	}
+
+	fmt.Fprintln(w, "# HELP chatlog_channel_live Whether the channel's stream is live.")
+	fmt.Fprintln(w, "# TYPE chatlog_channel_live gauge")
+	for _, c := range snap.Channels {
+		fmt.Fprintf(w, "chatlog_channel_live{%s} %d\n", labels(c), boolValue(c.Live))
+	}
+
+	fmt.Fprintln(w, "# HELP chatlog_channel_silent Whether the channel is live but receiving no messages.")
+	fmt.Fprintln(w, "# TYPE chatlog_channel_silent gauge")
+	for _, c := range snap.Channels {
+		fmt.Fprintf(w, "chatlog_channel_silent{%s} %d\n", labels(c), boolValue(c.Silent))
+	}
+}
+
+// labels formats a channel's Prometheus labels
+func labels(c ChannelStats) string {
+	return fmt.Sprintf("platform=%q,channel=%q", c.Platform, c.Channel)
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+package status
+
+import (
+	"sync"
+	"time"
+)
+
+// Component states
+const (
+	StateStarting     = "starting"
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	StateRunning      = "running"
+	StateDegraded     = "degraded"
+	StateStandby      = "standby"
+	StateStopping     = "stopping"
+	StateStopped      = "stopped"
+)
+
+// Registry collects the state of every component for the health endpoint
+type Registry struct {
+	version   string
+	startedAt time.Time
+
+	components map[string]*Component
+	mu         sync.Mutex
+}
+
+// Component is the status of one part of the pipeline. Its methods are safe
+// to call on a nil Component, so components can report unconditionally.
+type Component struct {
+	state       string
+	since       time.Time
+	lastMessage time.Time
+	details     map[string]any
+	mu          sync.Mutex
+}
+
+// ComponentStatus is a snapshot of a component
+type ComponentStatus struct {
+	State       string         `json:"state"`
+	Since       time.Time      `json:"since"`
+	LastMessage *time.Time     `json:"last_message,omitempty"`
+	Details     map[string]any `json:"details,omitempty"`
+}
+
+// Report is a snapshot of the whole registry
+type Report struct {
+	Version       string                     `json:"version"`
+	StartedAt     time.Time                  `json:"started_at"`
+	UptimeSeconds int64                      `json:"uptime_seconds"`
+	Components    map[string]ComponentStatus `json:"components"`
+}
+
+// New creates a status registry
+func New(version string) *Registry {
+	return &Registry{
+		version:    version,
+		startedAt:  time.Now().UTC(),
+		components: make(map[string]*Component),
+	}
+}
+
+// Component returns the named component, registering it if needed
+func (r *Registry) Component(name string) *Component {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.components[name]
+	if !ok {
+		c = &Component{state: StateStarting, since: time.Now().UTC(), details: make(map[string]any)}
+		r.components[name] = c
+	}
+	return c
+}
+
+// Report returns a snapshot of all components
+func (r *Registry) Report() Report {
+	r.mu.Lock()
+	components := make(map[string]*Component, len(r.components))
+	for name, c := range r.components {
+		components[name] = c
+	}
+	r.mu.Unlock()
+
+	report := Report{
+		Version:       r.version,
+		StartedAt:     r.startedAt,
+		UptimeSeconds: int64(time.Since(r.startedAt).Seconds()),
+		Components:    make(map[string]ComponentStatus, len(components)),
+	}
+	for name, c := range components {
+		report.Components[name] = c.snapshot()
+	}
+	return report
+}
+
+// SetState records the component's state, keeping the time it was entered
+// if it hasn't changed
+func (c *Component) SetState(state string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != state {
+		c.state = state
+		c.since = time.Now().UTC()
+	}
+}
+
+// MessageReceived records the time of the latest message
+func (c *Component) MessageReceived() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.lastMessage = time.Now()
+	c.mu.Unlock()
+}
+
+// Set records a component-specific detail, such as a count or timestamp
+func (c *Component) Set(key string, value any) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.details[key] = value
+	c.mu.Unlock()
+}
+
+// snapshot copies the component's status
+func (c *Component) snapshot() ComponentStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := ComponentStatus{State: c.state, Since: c.since}
+	if !c.lastMessage.IsZero() {
+		t := c.lastMessage.UTC()
+		s.LastMessage = &t
+	}
+	if len(c.details) > 0 {
+		s.Details = make(map[string]any, len(c.details))
+		for k, v := range c.details {
+			s.Details[k] = v
+		}
+	}
+	return s
+}
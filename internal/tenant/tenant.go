@@ -0,0 +1,238 @@
+// Package tenant runs a recorder and uploader per tenant behind one set of
+// platform connections, so customers are kept apart without a process each
+package tenant
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/recorder"
+	"github.com/john/chatlog/internal/uploader"
+)
+
+// Filter drops records before a tenant's recorder
+type Filter struct {
+	Types        []string // Record only these types, "chat" for chat messages; empty records all
+	ExcludeUsers []string // Usernames never recorded, compared case-insensitively
+}
+
+// keep reports whether the filter passes msg
+func (f Filter) keep(msg message.Message) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, cmp.Or(msg.Type, "chat")) {
+		return false
+	}
+	for _, user := range f.ExcludeUsers {
+		if strings.EqualFold(user, msg.Username) {
+			return false
+		}
+	}
+	return true
+}
+
+// tenant is one tenant's recorder and uploader and the queues feeding them
+type tenant struct {
+	name     string
+	labels   string // Prometheus labels, starting with tenant="name"
+	filter   Filter
+	rec      *recorder.Recorder
+	up       *uploader.Uploader
+	messages chan message.Message
+	files    chan recorder.FileInfo
+
+	routed   atomic.Int64
+	filtered atomic.Int64
+	dropped  atomic.Int64
+	failed   atomic.Bool // Set if the recorder stopped with an error
+}
+
+// Supervisor routes records to tenants and runs their recorders and
+// uploaders. Records of channels in no tenant pass through to the shared
+// recorder and uploader.
+type Supervisor struct {
+	route      func(platform, channel string) string
+	bufferSize int
+	tenants    map[string]*tenant
+}
+
+// New creates a supervisor. route names the tenant a channel belongs to,
+// or returns "" for none.
+func New(route func(platform, channel string) string, bufferSize int) *Supervisor {
+	return &Supervisor{route: route, bufferSize: bufferSize, tenants: make(map[string]*tenant)}
+}
+
+// Add registers a tenant with its own recorder and uploader. labels are
+// added to its metrics. It must be called before Route.
+func (s *Supervisor) Add(name string, labels map[string]string, filter Filter, rec *recorder.Recorder, up *uploader.Uploader) {
+	text := fmt.Sprintf("tenant=%q", name)
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		text += fmt.Sprintf(",%s=%q", key, labels[key])
+	}
+	s.tenants[name] = &tenant{
+		name:     name,
+		labels:   text,
+		filter:   filter,
+		rec:      rec,
+		up:       up,
+		messages: make(chan message.Message, s.bufferSize),
+		files:    make(chan recorder.FileInfo, 100),
+	}
+}
+
+// Len returns the number of tenants
+func (s *Supervisor) Len() int {
+	return len(s.tenants)
+}
+
+// tenantOf returns the tenant a channel belongs to, or nil
+func (s *Supervisor) tenantOf(platform, channel string) *tenant {
+	name := s.route(platform, channel)
+	if name == "" {
+		return nil
+	}
+	return s.tenants[name]
+}
+
+// Route sends tenants' messages, if their filters pass them, to their
+// recorders and everything else to out. When in is closed, out and the
+// tenants' queues are closed in turn.
+func (s *Supervisor) Route(ctx context.Context, in <-chan message.Message, out chan<- message.Message) {
+	defer func() {
+		close(out)
+		for _, t := range s.tenants {
+			close(t.messages)
+		}
+	}()
+
+	for msg := range in {
+		t := s.tenantOf(msg.Platform, msg.Channel)
+		if t == nil {
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		t.routed.Add(1)
+		switch {
+		case !t.filter.keep(msg):
+			t.filtered.Add(1)
+		case t.failed.Load():
+			t.dropped.Add(1)
+		default:
+			select {
+			case t.messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// RunRecorders runs every tenant's recorder, sending closed files to
+// fileChan, and returns once all have stopped. A recorder that fails has
+// its tenant's messages dropped so the others carry on.
+func (s *Supervisor) RunRecorders(ctx context.Context, fileChan chan<- recorder.FileInfo) {
+	var wg sync.WaitGroup
+	for _, t := range s.tenants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := t.rec.Start(ctx, t.messages, fileChan)
+			if err == nil || err == context.Canceled {
+				return
+			}
+			log.Printf("Error: tenant %s recorder stopped, dropping its messages: %v", t.name, err)
+			t.failed.Store(true)
+			for range t.messages {
+				t.dropped.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// RouteFiles sends tenants' files to their uploaders and everything else to
+// out. When in is closed, out and the tenants' queues are closed in turn.
+func (s *Supervisor) RouteFiles(ctx context.Context, in <-chan recorder.FileInfo, out chan<- recorder.FileInfo) {
+	defer func() {
+		close(out)
+		for _, t := range s.tenants {
+			close(t.files)
+		}
+	}()
+
+	for info := range in {
+		dest := out
+		if t := s.tenantOf(info.Platform, info.Channel); t != nil {
+			dest = t.files
+		}
+		select {
+		case dest <- info:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunUploaders runs every tenant's uploader and returns once all have
+// finished their queued files
+func (s *Supervisor) RunUploaders(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range s.tenants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := t.up.Start(ctx, t.files); err != nil && err != context.Canceled {
+				log.Printf("Tenant %s uploader error: %v", t.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Pending returns the number of tenants' files queued or being uploaded
+func (s *Supervisor) Pending() int64 {
+	var n int64
+	for _, t := range s.tenants {
+		n += t.up.Pending()
+	}
+	return n
+}
+
+// WriteMetrics writes per-tenant counters in Prometheus text format
+func (s *Supervisor) WriteMetrics(w io.Writer) {
+	names := slices.Sorted(maps.Keys(s.tenants))
+	for _, metric := range []struct {
+		name, help, kind string
+		value            func(*tenant) int64
+	}{
+		{"chatlog_tenant_messages_total", "Records routed to the tenant.", "counter", func(t *tenant) int64 { return t.routed.Load() }},
+		{"chatlog_tenant_filtered_total", "Records the tenant's filters dropped.", "counter", func(t *tenant) int64 { return t.filtered.Load() }},
+		{"chatlog_tenant_dropped_total", "Records dropped because the tenant's recorder failed.", "counter", func(t *tenant) int64 { return t.dropped.Load() }},
+		{"chatlog_tenant_pending_uploads", "Tenant files queued or being uploaded.", "gauge", func(t *tenant) int64 { return t.up.Pending() }},
+		{"chatlog_tenant_up", "Whether the tenant's recorder is running.", "gauge", func(t *tenant) int64 {
+			if t.failed.Load() {
+				return 0
+			}
+			return 1
+		}},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", metric.name, metric.kind)
+		for _, name := range names {
+			t := s.tenants[name]
+			fmt.Fprintf(w, "%s{%s} %d\n", metric.name, t.labels, metric.value(t))
+		}
+	}
+}
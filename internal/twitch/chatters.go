@@ -0,0 +1,136 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// ChattersMonitor periodically records who is connected to joined channels'
+// chat, lurkers included, as chatters snapshots. Helix only lists chatters
+// to the broadcaster and their moderators, so channels the token can't read
+// are skipped after the first attempt.
+type ChattersMonitor struct {
+	helix     *HelixClient
+	connector *Connector
+	interval  time.Duration
+	maxUsers  int
+	channels  map[string]bool // Channels to snapshot; empty for every joined channel
+	snapshots chan<- message.Message
+
+	broadcasterIDs map[string]string // Channel login -> user ID
+	denied         map[string]bool   // Channels whose chatters the token can't list
+}
+
+// NewChattersMonitor creates a monitor sending a snapshot of up to maxUsers
+// chatters per channel into messageChan every interval. channels limits it
+// to those channels; empty means all joined channels. The Helix client's
+// token needs the moderator:read:chatters scope.
+func NewChattersMonitor(helix *HelixClient, connector *Connector, interval time.Duration, maxUsers int, channels []string, messageChan chan<- message.Message) *ChattersMonitor {
+	only := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		only[strings.ToLower(strings.TrimPrefix(channel, "#"))] = true
+	}
+	return &ChattersMonitor{
+		helix:          helix,
+		connector:      connector,
+		interval:       interval,
+		maxUsers:       maxUsers,
+		channels:       only,
+		snapshots:      messageChan,
+		broadcasterIDs: make(map[string]string),
+		denied:         make(map[string]bool),
+	}
+}
+
+// Start records snapshots until the context is cancelled
+func (m *ChattersMonitor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.poll(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// poll records one snapshot of each channel. Channels that fail are tried
+// again next time.
+func (m *ChattersMonitor) poll(ctx context.Context) {
+	// Get Chatters is asked as the token's user, a moderator of the channel
+	if m.helix.userID == "" {
+		if err := m.helix.ValidateToken(ctx); err != nil {
+			log.Printf("Warning: Failed to validate the Twitch token for chatters snapshots: %v", err)
+			return
+		}
+	}
+
+	var channels, unresolved []string
+	for _, channel := range m.connector.Channels() {
+		if m.denied[channel] || (len(m.channels) > 0 && !m.channels[channel]) {
+			continue
+		}
+		channels = append(channels, channel)
+		if _, ok := m.broadcasterIDs[channel]; !ok {
+			unresolved = append(unresolved, channel)
+		}
+	}
+	if len(unresolved) > 0 {
+		broadcasters, err := m.helix.GetUsers(ctx, unresolved)
+		if err != nil {
+			log.Printf("Warning: Twitch broadcaster lookup for chatters snapshots failed: %v", err)
+			return
+		}
+		for _, b := range broadcasters {
+			m.broadcasterIDs[b.Login] = b.ID
+		}
+	}
+
+	for _, channel := range channels {
+		broadcasterID, ok := m.broadcasterIDs[channel]
+		if !ok {
+			continue
+		}
+		chatters, total, err := m.helix.GetChatters(ctx, broadcasterID, m.maxUsers)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+			log.Printf("Warning: Not recording chatters of %s, the token can't list them (it needs moderator:read:chatters and to be a moderator there): %v", channel, err)
+			m.denied[channel] = true
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Warning: Failed to get Twitch chatters of %s: %v", channel, err)
+			continue
+		}
+
+		snapshot := message.New("twitch", time.Time{})
+		snapshot.Channel = channel
+		snapshot.Type = message.TypeChatters
+		snapshot.Chatters = &message.Chatters{
+			Total:     total,
+			Users:     make([]message.Chatter, len(chatters)),
+			Truncated: len(chatters) >= m.maxUsers && total > len(chatters),
+		}
+		for i, chatter := range chatters {
+			snapshot.Chatters.Users[i] = message.Chatter{UserID: chatter.UserID, Username: chatter.UserLogin}
+		}
+		select {
+		case m.snapshots <- snapshot:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -3,11 +3,16 @@ package twitch
 import (
 	"context"
 	"log"
+	"sort"
 	"strings"
-	"time"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/john/chatlog/internal/egress"
 	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/rawlog"
+	"github.com/john/chatlog/internal/status"
 )
 
 // Message represents a Twitch chat message
@@ -20,83 +25,241 @@ type Message struct {
 	Badges    string `json:"badges,omitempty"`
 }
 
-// Connector manages Twitch chat connections
+// Connector manages Twitch chat connections. Channels are read over one IRC
+// connection of one account unless EnablePool spreads them out.
 type Connector struct {
-	username string
-	oauth    string
 	channels []string
-	client   *twitch.Client
+	presence *presence
+	eventSub *eventSub
+
+	rateLimits *RateLimits // nil unless EnableRateLimits was called
+
+	egress       *egress.Network // nil connects directly
+	relayAddress string          // Loopback IRC relay, once started
+	rawLog       *rawlog.Log     // nil unless EnableRawLog was called
+	rawConns     atomic.Int64    // IRC connections through the relay, numbering them in the raw log
+
+	accounts    []*account
+	maxChannels int                 // Per connection; 0 for no limit
+	conns       []*ircConn          // In the order they were opened
+	assigned    map[string]*ircConn // Channel -> the connection it is joined on
+	started     bool                // New connections connect right away
+	connsMu     sync.Mutex
+
+	messageChan chan<- message.Message
+	ctx         context.Context
+
+	joined   map[string]bool // static and runtime-joined channels
+	joinedMu sync.Mutex
+
+	roomNames   map[string]string // room ID -> channel login, learned from received messages
+	roomNamesMu sync.Mutex
+
+	status *status.Component
 }
 
-// New creates a new Twitch connector
+// New creates a new Twitch connector. With an empty username the connector
+// joins anonymously as a justinfan user, which can read but not send chat.
 func New(username, oauth string, channels []string) *Connector {
 	return &Connector{
-		username: username,
-		oauth:    oauth,
-		channels: channels,
+		channels:  channels,
+		accounts:  []*account{{Account: Account{Username: username, OAuth: oauth}}},
+		assigned:  make(map[string]*ircConn),
+		joined:    toSet(channels),
+		roomNames: make(map[string]string),
 	}
 }
 
-// Start begins listening to Twitch chat
-func (c *Connector) Start(ctx context.Context, messageChan chan<- message.Message) error {
-	// Create Twitch IRC client
-	c.client = twitch.NewClient(c.username, c.oauth)
-
-	// Set up message handler
-	c.client.OnPrivateMessage(func(msg twitch.PrivateMessage) {
-		// Convert to our Message format
-		badges := formatBadges(msg.User.Badges)
-
-		chatMessage := message.Message{
-			Platform:  "twitch",
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-			Channel:   strings.TrimPrefix(msg.Channel, "#"),
-			Username:  msg.User.DisplayName,
-			UserID:    msg.User.ID,
-			Message:   msg.Message,
-			Badges:    badges,
+// Join joins a channel at runtime. It is safe to call before or after Start.
+func (c *Connector) Join(channel string) {
+	channel = strings.ToLower(channel)
+	c.joinedMu.Lock()
+	c.joined[channel] = true
+	c.joinedMu.Unlock()
+
+	if c.eventSub != nil {
+		if err := c.eventSub.subscribe(context.Background(), channel); err != nil {
+			log.Printf("Warning: Failed to subscribe to Twitch chat for %s: %v", channel, err)
+			return
+		}
+		c.status.Set("subscriptions", c.eventSub.count())
+	} else {
+		c.connsMu.Lock()
+		conn, added := c.assign(channel)
+		c.connsMu.Unlock()
+		if !added {
+			return
 		}
+		if conn.tracker != nil {
+			conn.tracker.requested(channel)
+		}
+		conn.client.Join(channel)
+	}
+	log.Printf("Joined channel: %s", channel)
+}
+
+// Part leaves a channel at runtime
+func (c *Connector) Part(channel string) {
+	channel = strings.ToLower(channel)
+	c.joinedMu.Lock()
+	delete(c.joined, channel)
+	c.joinedMu.Unlock()
 
-		// Send to message channel
-		select {
-		case messageChan <- chatMessage:
-		case <-ctx.Done():
+	if c.eventSub != nil {
+		if err := c.eventSub.unsubscribe(context.Background(), channel); err != nil {
+			log.Printf("Warning: Failed to unsubscribe from Twitch chat for %s: %v", channel, err)
+		}
+		c.status.Set("subscriptions", c.eventSub.count())
+	} else {
+		c.connsMu.Lock()
+		conn := c.unassign(channel)
+		c.connsMu.Unlock()
+		if conn == nil {
 			return
 		}
-	})
+		if conn.tracker != nil {
+			conn.tracker.parted(channel)
+		}
+		conn.client.Depart(channel)
+	}
+	log.Printf("Parted channel: %s", channel)
+}
 
-	// Set up connection event handlers
-	c.client.OnConnect(func() {
-		log.Println("Connected to Twitch IRC")
-	})
+// Channels returns the channels currently joined, sorted
+func (c *Connector) Channels() []string {
+	c.joinedMu.Lock()
+	defer c.joinedMu.Unlock()
 
-	c.client.OnReconnectMessage(func(msg twitch.ReconnectMessage) {
-		log.Println("Reconnecting to Twitch IRC...")
-	})
+	channels := make([]string, 0, len(c.joined))
+	for channel := range c.joined {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
 
-	// Join all channels
-	for _, channel := range c.channels {
-		c.client.Join(channel)
-		log.Printf("Joined channel: %s", channel)
+// attributeSource records the originating channel of a message relayed
+// through a Shared Chat session. Source rooms are named when a message from
+// that room's own chat has been seen, i.e. when we also join it.
+func (c *Connector) attributeSource(chatMessage *message.Message, msg twitch.PrivateMessage) {
+	c.roomNamesMu.Lock()
+	defer c.roomNamesMu.Unlock()
+
+	if msg.RoomID != "" {
+		c.roomNames[msg.RoomID] = chatMessage.Channel
+	}
+
+	sourceRoomID := msg.Tags["source-room-id"]
+	if sourceRoomID == "" || sourceRoomID == msg.RoomID {
+		return
+	}
+	chatMessage.SourceRoomID = sourceRoomID
+	chatMessage.SourceChannel = c.roomNames[sourceRoomID]
+}
+
+// EnableStatus reports connection state and message times to comp. It must
+// be called before Start.
+func (c *Connector) EnableStatus(comp *status.Component) {
+	c.status = comp
+}
+
+// Start begins listening to Twitch chat
+func (c *Connector) Start(ctx context.Context, messageChan chan<- message.Message) error {
+	if c.eventSub != nil {
+		return c.runEventSub(ctx, messageChan)
+	}
+	if err := c.startRelay(ctx); err != nil {
+		return err
 	}
 
-	// Start the client in a goroutine
-	go func() {
-		if err := c.client.Connect(); err != nil {
-			log.Printf("Twitch IRC connection error: %v", err)
+	// Assign the static channels, then connect; connections opened later
+	// for runtime joins connect as they are opened
+	c.connsMu.Lock()
+	c.ctx = ctx
+	c.messageChan = messageChan
+	for _, channel := range c.channels {
+		conn, added := c.assign(strings.ToLower(channel))
+		if added {
+			conn.client.Join(channel)
+			log.Printf("Joined channel: %s", channel)
 		}
-	}()
+	}
+	if len(c.conns) == 0 {
+		c.newConn() // Discovery joins later
+	}
+	c.started = true
+	for _, conn := range c.conns {
+		c.connect(conn)
+	}
+	if len(c.conns) > 1 {
+		log.Printf("Reading %d Twitch channel(s) over %d connection(s) on %d account(s)", len(c.assigned), len(c.conns), len(c.accounts))
+	}
+	c.connsMu.Unlock()
+
+	if c.rateLimits != nil {
+		go c.verifyJoins(ctx)
+	}
+	if c.presence != nil {
+		go c.presence.runPeriodic(ctx, c.sayPaced)
+	}
 
 	// Wait for context cancellation
 	<-ctx.Done()
 
 	// Disconnect gracefully
 	log.Println("Disconnecting from Twitch IRC...")
-	c.client.Disconnect()
+	c.disconnectAll()
 
 	return ctx.Err()
 }
 
+// onPrivateMessage converts a chat message from any connection
+func (c *Connector) onPrivateMessage(msg twitch.PrivateMessage) {
+	// msg.Time is the tmi-sent-ts tag set by Twitch
+	chatMessage := message.New("twitch", msg.Time)
+	chatMessage.Channel = strings.TrimPrefix(msg.Channel, "#")
+	chatMessage.Username = msg.User.DisplayName
+	chatMessage.UserID = msg.User.ID
+	chatMessage.ID = msg.ID
+	chatMessage.Message = msg.Message
+	chatMessage.Badges = formatBadges(msg.User.Badges)
+	chatMessage.Emotes = emoteNames(msg.Emotes)
+	chatMessage.EmoteRefs = emoteRefs(msg.Emotes)
+	chatMessage.Reply = replyOf(msg)
+	chatMessage.Paid = paidOf(msg)
+	if notice := redemptionOf(msg); notice != nil {
+		chatMessage.Type = message.TypeRedemption
+		chatMessage.Notice = notice
+	}
+	c.attributeSource(&chatMessage, msg)
+	c.status.MessageReceived()
+
+	if c.presence != nil {
+		c.presence.onMessage(c.sayNow, chatMessage.Channel, msg.Message)
+	}
+
+	// Send to message channel
+	select {
+	case c.messageChan <- chatMessage:
+	case <-c.ctx.Done():
+	}
+}
+
+// replyOf returns the parent of a reply from its reply-parent-* and
+// reply-thread-parent-msg-id tags, or nil for other messages
+func replyOf(msg twitch.PrivateMessage) *message.Reply {
+	if msg.Reply == nil || msg.Reply.ParentMsgID == "" {
+		return nil
+	}
+	return &message.Reply{
+		ParentID:       msg.Reply.ParentMsgID,
+		ParentUserID:   msg.Reply.ParentUserID,
+		ParentUsername: msg.Reply.ParentDisplayName,
+		ParentText:     msg.Reply.ParentMsgBody,
+		ThreadID:       msg.Tags["reply-thread-parent-msg-id"],
+	}
+}
+
 // formatBadges converts the badges map to a comma-separated string
 func formatBadges(badges map[string]int) string {
 	if len(badges) == 0 {
@@ -110,3 +273,40 @@ func formatBadges(badges map[string]int) string {
 
 	return strings.Join(parts, ",")
 }
+
+// emoteNames lists the emotes in a message in the order they appear
+func emoteNames(emotes []*twitch.Emote) []string {
+	type use struct {
+		start int
+		name  string
+	}
+	var uses []use
+	for _, emote := range emotes {
+		for _, pos := range emote.Positions {
+			uses = append(uses, use{start: pos.Start, name: emote.Name})
+		}
+	}
+	if len(uses) == 0 {
+		return nil
+	}
+
+	sort.Slice(uses, func(i, j int) bool { return uses[i].start < uses[j].start })
+	names := make([]string, len(uses))
+	for i, u := range uses {
+		names[i] = u.name
+	}
+	return names
+}
+
+// emoteRefs lists each emote use with its ID and rune range, in order
+func emoteRefs(emotes []*twitch.Emote) []message.EmoteRef {
+	var refs []message.EmoteRef
+	for _, emote := range emotes {
+		for _, pos := range emote.Positions {
+			// Twitch positions are inclusive
+			refs = append(refs, message.EmoteRef{ID: emote.ID, Name: emote.Name, Start: pos.Start, End: pos.End + 1})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Start < refs[j].Start })
+	return refs
+}
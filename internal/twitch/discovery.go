@@ -0,0 +1,177 @@
+package twitch
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiscoveryConfig controls automatic channel discovery
+type DiscoveryConfig struct {
+	Categories  []string      // Category (game) names whose top live channels are joined
+	Teams       []string      // Team names whose live members are joined
+	MaxChannels int           // Cap on discovered channels joined at once
+	Interval    time.Duration // How often to refresh the discovered set
+	Allow       []string      // If non-empty, only these channels may be auto-joined
+	Deny        []string      // Channels never auto-joined
+}
+
+// Discoverer periodically queries Helix for channels in categories or teams
+// and joins/parts them on the connector. Statically configured channels are
+// never parted.
+type Discoverer struct {
+	helix     *HelixClient
+	connector *Connector
+	cfg       DiscoveryConfig
+
+	static  map[string]bool
+	allow   map[string]bool
+	deny    map[string]bool
+	joined  map[string]bool
+	gameIDs map[string]string // category name -> game ID
+}
+
+// NewDiscoverer creates a new channel discoverer
+func NewDiscoverer(helix *HelixClient, connector *Connector, staticChannels []string, cfg DiscoveryConfig) *Discoverer {
+	return &Discoverer{
+		helix:     helix,
+		connector: connector,
+		cfg:       cfg,
+		static:    toSet(staticChannels),
+		allow:     toSet(cfg.Allow),
+		deny:      toSet(cfg.Deny),
+		joined:    make(map[string]bool),
+		gameIDs:   make(map[string]string),
+	}
+}
+
+// Start runs discovery until the context is cancelled
+func (d *Discoverer) Start(ctx context.Context) error {
+	if err := d.helix.ValidateToken(ctx); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		d.refresh(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// refresh recomputes the discovered set and applies the difference
+func (d *Discoverer) refresh(ctx context.Context) {
+	desired, err := d.discover(ctx)
+	if err != nil {
+		log.Printf("Twitch discovery error: %v (keeping current channels)", err)
+		return
+	}
+
+	for channel := range desired {
+		if !d.joined[channel] {
+			d.connector.Join(channel)
+			d.joined[channel] = true
+		}
+	}
+	for channel := range d.joined {
+		if !desired[channel] {
+			d.connector.Part(channel)
+			delete(d.joined, channel)
+		}
+	}
+
+	log.Printf("Twitch discovery: %d discovered channel(s) joined", len(d.joined))
+}
+
+// discover returns the set of channels that should be joined, in addition
+// to the static channels
+func (d *Discoverer) discover(ctx context.Context) (map[string]bool, error) {
+	type candidate struct {
+		login   string
+		viewers int
+	}
+	seen := make(map[string]bool)
+	var candidates []candidate
+
+	add := func(login string, viewers int) {
+		login = strings.ToLower(login)
+		if seen[login] || d.static[login] || d.deny[login] {
+			return
+		}
+		if len(d.allow) > 0 && !d.allow[login] {
+			return
+		}
+		seen[login] = true
+		candidates = append(candidates, candidate{login: login, viewers: viewers})
+	}
+
+	for _, category := range d.cfg.Categories {
+		gameID, ok := d.gameIDs[category]
+		if !ok {
+			var err error
+			gameID, err = d.helix.GetGameID(ctx, category)
+			if err != nil {
+				return nil, err
+			}
+			d.gameIDs[category] = gameID
+		}
+
+		streams, err := d.helix.GetStreams(ctx, gameID, d.cfg.MaxChannels)
+		if err != nil {
+			return nil, err
+		}
+		for _, stream := range streams {
+			add(stream.UserLogin, stream.ViewerCount)
+		}
+	}
+
+	// Team members are only joined while live, like category channels
+	var members []string
+	for _, team := range d.cfg.Teams {
+		teamMembers, err := d.helix.GetTeamMembers(ctx, team)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, teamMembers...)
+	}
+	if len(members) > 0 {
+		streams, err := d.helix.GetLiveStreams(ctx, members)
+		if err != nil {
+			return nil, err
+		}
+		for _, stream := range streams {
+			add(stream.UserLogin, stream.ViewerCount)
+		}
+	}
+
+	// Apply the cap, preferring the biggest live channels
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].viewers > candidates[j].viewers
+	})
+	if d.cfg.MaxChannels > 0 && len(candidates) > d.cfg.MaxChannels {
+		candidates = candidates[:d.cfg.MaxChannels]
+	}
+
+	desired := make(map[string]bool, len(candidates))
+	for _, c := range candidates {
+		desired[c.login] = true
+	}
+	return desired, nil
+}
+
+// toSet builds a lowercase lookup set from channel names
+func toSet(channels []string) map[string]bool {
+	set := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		set[strings.ToLower(strings.TrimPrefix(channel, "#"))] = true
+	}
+	return set
+}
@@ -0,0 +1,48 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/john/chatlog/internal/egress"
+)
+
+// ircAddressTLS is Twitch's IRC server
+const ircAddressTLS = "irc.chat.twitch.tv:6697"
+
+// EnableEgress connects to IRC and EventSub through network. The IRC
+// client can't be given a dialer, so its connections go to a loopback
+// relay that makes the TLS connection through network instead. It must be
+// called before Start.
+func (c *Connector) EnableEgress(network *egress.Network) {
+	c.egress = network
+}
+
+// startRelay starts the IRC relay, if there is an egress network or a raw
+// log to capture IRC lines for
+func (c *Connector) startRelay(ctx context.Context) error {
+	if c.egress == nil && c.rawLog == nil {
+		return nil
+	}
+	network := c.egress
+	if network == nil {
+		network = &egress.Network{}
+	}
+	var tap func() (sent, received io.Writer)
+	if c.rawLog != nil {
+		tap = c.rawStreams
+	}
+	addr, err := network.RelayTLS(ctx, ircAddressTLS, tap)
+	if err != nil {
+		return fmt.Errorf("start IRC relay: %w", err)
+	}
+	c.relayAddress = addr
+	return nil
+}
+
+// EnableEgress sends Helix API requests through network
+func (h *HelixClient) EnableEgress(network *egress.Network) {
+	h.httpClient = network.HTTPClient(10 * time.Second)
+}
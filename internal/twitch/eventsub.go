@@ -0,0 +1,491 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+const (
+	eventSubURL = "wss://eventsub.wss.twitch.tv/ws"
+
+	// eventSubMaxSubscriptions is how many subscriptions one WebSocket
+	// session may hold
+	eventSubMaxSubscriptions = 300
+
+	maxEventSubBackoff = 60 * time.Second
+)
+
+// eventSub reads chat through EventSub channel.chat.message subscriptions
+// on a WebSocket session instead of IRC
+type eventSub struct {
+	helix *HelixClient
+
+	sessionID string
+	subs      map[string]string // channel login -> subscription ID
+	ids       map[string]string // channel login -> broadcaster user ID
+	mu        sync.Mutex
+}
+
+// eventSubFrame is a message on the EventSub WebSocket
+type eventSubFrame struct {
+	Metadata struct {
+		MessageID        string    `json:"message_id"`
+		MessageType      string    `json:"message_type"`
+		MessageTimestamp time.Time `json:"message_timestamp"`
+		SubscriptionType string    `json:"subscription_type"`
+	} `json:"metadata"`
+	Payload struct {
+		Session *struct {
+			ID                      string `json:"id"`
+			KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+			ReconnectURL            string `json:"reconnect_url"`
+		} `json:"session"`
+		Subscription *struct {
+			ID        string `json:"id"`
+			Status    string `json:"status"`
+			Condition struct {
+				BroadcasterUserID string `json:"broadcaster_user_id"`
+			} `json:"condition"`
+		} `json:"subscription"`
+		Event json.RawMessage `json:"event"`
+	} `json:"payload"`
+}
+
+// chatEvent is a channel.chat.message event
+type chatEvent struct {
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	ChatterUserID        string `json:"chatter_user_id"`
+	ChatterUserLogin     string `json:"chatter_user_login"`
+	ChatterUserName      string `json:"chatter_user_name"`
+	MessageID            string `json:"message_id"`
+	Message              struct {
+		Text      string         `json:"text"`
+		Fragments []chatFragment `json:"fragments"`
+	} `json:"message"`
+	Badges []struct {
+		SetID string `json:"set_id"`
+		ID    string `json:"id"`
+	} `json:"badges"`
+	Cheer *struct {
+		Bits int `json:"bits"`
+	} `json:"cheer"`
+	Reply *struct {
+		ParentMessageID   string `json:"parent_message_id"`
+		ParentMessageBody string `json:"parent_message_body"`
+		ParentUserID      string `json:"parent_user_id"`
+		ParentUserName    string `json:"parent_user_name"`
+		ThreadMessageID   string `json:"thread_message_id"`
+	} `json:"reply"`
+	SourceBroadcasterUserID     string `json:"source_broadcaster_user_id"`
+	SourceBroadcasterUserLogin  string `json:"source_broadcaster_user_login"`
+	MessageType                 string `json:"message_type"`                    // text, channel_points_highlighted, ...
+	ChannelPointsCustomRewardID string `json:"channel_points_custom_reward_id"` // Set when sent with a custom reward
+}
+
+// chatFragment is a run of a chat message: text, emote, cheermote or mention
+type chatFragment struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emote *struct {
+		ID string `json:"id"`
+	} `json:"emote"`
+	Cheermote *struct {
+		Prefix string `json:"prefix"`
+		Bits   int    `json:"bits"`
+	} `json:"cheermote"`
+}
+
+// EnableEventSub reads chat through the EventSub WebSocket transport
+// (channel.chat.message) instead of IRC. The Helix client's token must
+// belong to the logging account and have the user:read:chat scope.
+// Presence messages need IRC and are not sent. It must be called before
+// Start.
+func (c *Connector) EnableEventSub(helix *HelixClient) {
+	c.eventSub = &eventSub{
+		helix: helix,
+		subs:  make(map[string]string),
+		ids:   make(map[string]string),
+	}
+}
+
+// runEventSub keeps an EventSub session open until the context is
+// cancelled, reconnecting with backoff
+func (c *Connector) runEventSub(ctx context.Context, messageChan chan<- message.Message) error {
+	if err := c.eventSub.helix.ValidateToken(ctx); err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	for {
+		connectedAt := time.Now()
+		err := c.runEventSubSession(ctx, messageChan)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if time.Since(connectedAt) > maxEventSubBackoff {
+			backoff = time.Second
+		}
+		log.Printf("Twitch EventSub connection lost: %v. Reconnecting in %v", err, backoff)
+		c.status.SetState(status.StateReconnecting)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, maxEventSubBackoff)
+	}
+}
+
+// eventSubConn is an EventSub WebSocket connection whose frames are read
+// into a channel, so a session can listen on two while it moves
+type eventSubConn struct {
+	conn  *websocket.Conn
+	reads chan eventSubRead
+	stop  chan struct{}
+}
+
+// eventSubRead is a frame read from a connection, or the error ending it
+type eventSubRead struct {
+	raw []byte
+	err error
+}
+
+// dialEventSub connects to an EventSub URL and starts reading it. Reads
+// time out after keepalive (in nanoseconds, updated by welcomes) plus a
+// margin.
+func (c *Connector) dialEventSub(ctx context.Context, url string, keepalive *atomic.Int64) (*eventSubConn, error) {
+	dialer := websocket.DefaultDialer
+	if c.egress != nil {
+		dialer = c.egress.WebSocketDialer()
+	}
+	conn, _, err := dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+
+	ec := &eventSubConn{conn: conn, reads: make(chan eventSubRead), stop: make(chan struct{})}
+	go func() {
+		for {
+			conn.SetReadDeadline(time.Now().Add(time.Duration(keepalive.Load()) + 10*time.Second))
+			_, raw, err := conn.ReadMessage()
+			select {
+			case ec.reads <- eventSubRead{raw: raw, err: err}:
+			case <-ec.stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ec, nil
+}
+
+// close closes the connection and stops its reader
+func (ec *eventSubConn) close() {
+	close(ec.stop)
+	ec.conn.Close()
+}
+
+// runEventSubSession handles one EventSub session. When Twitch asks the
+// client to move, the new connection is opened alongside the old one, which
+// is read until the new one's welcome arrives so no events are missed; the
+// moved session keeps its subscriptions. A new session subscribes every
+// joined channel.
+func (c *Connector) runEventSubSession(ctx context.Context, messageChan chan<- message.Message) error {
+	var keepalive atomic.Int64
+	keepalive.Store(int64(10 * time.Second)) // Until the welcome says otherwise
+
+	current, err := c.dialEventSub(ctx, eventSubURL, &keepalive)
+	if err != nil {
+		return err
+	}
+	var next *eventSubConn // The connection being moved to
+	defer func() {
+		current.close()
+		if next != nil {
+			next.close()
+		}
+	}()
+
+	es := c.eventSub
+	moved := false
+	for {
+		var read eventSubRead
+		fromNext := false
+		var nextReads chan eventSubRead
+		if next != nil {
+			nextReads = next.reads
+		}
+		select {
+		case read = <-current.reads:
+		case read = <-nextReads:
+			fromNext = true
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if read.err != nil {
+			if fromNext {
+				return fmt.Errorf("reconnect: read: %w", read.err)
+			}
+			return fmt.Errorf("read: %w", read.err)
+		}
+		c.rawLog.Received("eventsub", read.raw)
+		var frame eventSubFrame
+		if err := json.Unmarshal(read.raw, &frame); err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+
+		if fromNext {
+			// The first frame on the new connection is its welcome, after
+			// which Twitch sends nothing more on the old one
+			if frame.Metadata.MessageType != "session_welcome" {
+				return fmt.Errorf("reconnect: got %s before the welcome", frame.Metadata.MessageType)
+			}
+			current.close()
+			current, next = next, nil
+			moved = true
+		}
+
+		switch frame.Metadata.MessageType {
+		case "session_welcome":
+			session := frame.Payload.Session
+			if session == nil {
+				return fmt.Errorf("welcome without a session")
+			}
+			if session.KeepaliveTimeoutSeconds > 0 {
+				keepalive.Store(int64(time.Duration(session.KeepaliveTimeoutSeconds) * time.Second))
+			}
+			es.mu.Lock()
+			es.sessionID = session.ID
+			if !moved {
+				es.subs = make(map[string]string) // Subscriptions end with their session
+			}
+			es.mu.Unlock()
+			log.Println("Connected to Twitch EventSub")
+			c.status.SetState(status.StateConnected)
+			if !moved {
+				go c.subscribeAll(ctx)
+			}
+
+		case "session_reconnect":
+			session := frame.Payload.Session
+			if next != nil || session == nil || session.ReconnectURL == "" {
+				continue
+			}
+			log.Println("Twitch EventSub requested a reconnect")
+			if next, err = c.dialEventSub(ctx, session.ReconnectURL, &keepalive); err != nil {
+				return fmt.Errorf("reconnect: %w", err)
+			}
+
+		case "notification":
+			if frame.Metadata.SubscriptionType != "channel.chat.message" {
+				continue
+			}
+			var event chatEvent
+			if err := json.Unmarshal(frame.Payload.Event, &event); err != nil {
+				log.Printf("Warning: Failed to decode Twitch chat event: %v", err)
+				continue
+			}
+			chatMessage := convertChatEvent(event, frame.Metadata.MessageTimestamp)
+			c.status.MessageReceived()
+			select {
+			case messageChan <- chatMessage:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+		case "revocation":
+			if sub := frame.Payload.Subscription; sub != nil {
+				log.Printf("Warning: Twitch EventSub subscription for broadcaster %s revoked: %s", sub.Condition.BroadcasterUserID, sub.Status)
+				es.forget(sub.ID)
+				c.status.Set("subscriptions", es.count())
+			}
+		}
+	}
+}
+
+// subscribeAll subscribes the current session to every joined channel
+func (c *Connector) subscribeAll(ctx context.Context) {
+	channels := c.Channels()
+	if len(channels) > eventSubMaxSubscriptions {
+		log.Printf("Warning: %d Twitch channels joined but an EventSub session holds at most %d; the rest are not recorded",
+			len(channels), eventSubMaxSubscriptions)
+		channels = channels[:eventSubMaxSubscriptions]
+	}
+	if err := c.eventSub.lookupIDs(ctx, channels); err != nil {
+		log.Printf("Warning: Failed to look up Twitch channel IDs: %v", err)
+	}
+	for _, channel := range channels {
+		if err := c.eventSub.subscribe(ctx, channel); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Warning: Failed to subscribe to Twitch chat for %s: %v", channel, err)
+		}
+	}
+	c.status.Set("channels", len(channels))
+	c.status.Set("subscriptions", c.eventSub.count())
+}
+
+// lookupIDs caches the broadcaster IDs of channels not looked up yet
+func (es *eventSub) lookupIDs(ctx context.Context, channels []string) error {
+	es.mu.Lock()
+	var missing []string
+	for _, channel := range channels {
+		if _, ok := es.ids[channel]; !ok {
+			missing = append(missing, channel)
+		}
+	}
+	es.mu.Unlock()
+	if len(missing) == 0 {
+		return nil
+	}
+
+	users, err := es.helix.GetUsers(ctx, missing)
+	if err != nil {
+		return err
+	}
+	es.mu.Lock()
+	for _, user := range users {
+		es.ids[user.Login] = user.ID
+	}
+	es.mu.Unlock()
+	return nil
+}
+
+// subscribe subscribes the current session to a channel's chat, if it
+// isn't already
+func (es *eventSub) subscribe(ctx context.Context, channel string) error {
+	if err := es.lookupIDs(ctx, []string{channel}); err != nil {
+		return err
+	}
+
+	es.mu.Lock()
+	sessionID := es.sessionID
+	broadcasterID, known := es.ids[channel]
+	_, subscribed := es.subs[channel]
+	es.mu.Unlock()
+	if sessionID == "" || subscribed {
+		return nil // Subscribed once the session is welcomed
+	}
+	if !known {
+		return fmt.Errorf("channel does not exist")
+	}
+
+	id, err := es.helix.CreateChatSubscription(ctx, sessionID, broadcasterID)
+	if err != nil {
+		return err
+	}
+	es.mu.Lock()
+	es.subs[channel] = id
+	es.mu.Unlock()
+	return nil
+}
+
+// unsubscribe removes a channel's subscription, if it has one
+func (es *eventSub) unsubscribe(ctx context.Context, channel string) error {
+	es.mu.Lock()
+	id, ok := es.subs[channel]
+	delete(es.subs, channel)
+	es.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return es.helix.DeleteSubscription(ctx, id)
+}
+
+// forget drops a subscription Twitch has revoked
+func (es *eventSub) forget(id string) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for channel, subID := range es.subs {
+		if subID == id {
+			delete(es.subs, channel)
+		}
+	}
+}
+
+// count returns the number of active subscriptions
+func (es *eventSub) count() int {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return len(es.subs)
+}
+
+// convertChatEvent converts a channel.chat.message event to our format.
+// Badges keep their versions ("subscriber:12"), and emotes come from the
+// message fragments.
+func convertChatEvent(event chatEvent, sentAt time.Time) message.Message {
+	chatMessage := message.New("twitch", sentAt)
+	chatMessage.Channel = event.BroadcasterUserLogin
+	chatMessage.Username = event.ChatterUserName
+	chatMessage.UserID = event.ChatterUserID
+	chatMessage.ID = event.MessageID
+	chatMessage.Message = event.Message.Text
+
+	var badges []string
+	for _, badge := range event.Badges {
+		badges = append(badges, badge.SetID+":"+badge.ID)
+	}
+	chatMessage.Badges = strings.Join(badges, ",")
+
+	var cheers []message.Cheermote
+	offset := 0 // Runes into the text
+	for _, fragment := range event.Message.Fragments {
+		length := len([]rune(fragment.Text))
+		if fragment.Type == "emote" && fragment.Emote != nil {
+			chatMessage.Emotes = append(chatMessage.Emotes, fragment.Text)
+			chatMessage.EmoteRefs = append(chatMessage.EmoteRefs, message.EmoteRef{
+				ID:    fragment.Emote.ID,
+				Name:  fragment.Text,
+				Start: offset,
+				End:   offset + length,
+			})
+		}
+		if fragment.Type == "cheermote" && fragment.Cheermote != nil {
+			cheers = append(cheers, message.Cheermote{Prefix: fragment.Cheermote.Prefix, Bits: fragment.Cheermote.Bits})
+		}
+		offset += length
+	}
+
+	if event.Cheer != nil && event.Cheer.Bits > 0 {
+		chatMessage.Paid = &message.Paid{Bits: event.Cheer.Bits, Cheermotes: cheers}
+	}
+
+	if r := event.Reply; r != nil && r.ParentMessageID != "" {
+		chatMessage.Reply = &message.Reply{
+			ParentID:       r.ParentMessageID,
+			ParentUserID:   r.ParentUserID,
+			ParentUsername: r.ParentUserName,
+			ParentText:     r.ParentMessageBody,
+			ThreadID:       r.ThreadMessageID,
+		}
+	}
+
+	// Shared Chat: the source broadcaster is named in the event itself
+	if event.SourceBroadcasterUserID != "" && event.SourceBroadcasterUserID != event.BroadcasterUserID {
+		chatMessage.SourceRoomID = event.SourceBroadcasterUserID
+		chatMessage.SourceChannel = event.SourceBroadcasterUserLogin
+	}
+
+	if msgID, rewardID := eventSubRedemptions[event.MessageType], event.ChannelPointsCustomRewardID; msgID != "" || rewardID != "" {
+		chatMessage.Type = message.TypeRedemption
+		chatMessage.Notice = &message.Notice{MsgID: msgID, RewardID: rewardID}
+	}
+
+	return chatMessage
+}
@@ -0,0 +1,490 @@
+package twitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	helixBaseURL     = "https://api.twitch.tv/helix"
+	oauthValidateURL = "https://id.twitch.tv/oauth2/validate"
+
+	// helixMaxLogins is the maximum number of logins accepted per Get Users request
+	helixMaxLogins = 100
+)
+
+// HelixUser represents a user returned by the Helix Get Users endpoint
+type HelixUser struct {
+	ID              string    `json:"id"`
+	Login           string    `json:"login"`
+	DisplayName     string    `json:"display_name"`
+	BroadcasterType string    `json:"broadcaster_type"` // "partner", "affiliate" or ""
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// HelixClient is a minimal client for the Twitch Helix API
+type HelixClient struct {
+	clientID   string
+	token      string
+	userID     string    // Token owner, set by ValidateToken
+	tokenInfo  TokenInfo // Set by ValidateToken
+	httpClient *http.Client
+}
+
+// TokenInfo describes a validated OAuth token
+type TokenInfo struct {
+	Login     string
+	Scopes    []string
+	ExpiresIn time.Duration // Zero for tokens that don't expire
+}
+
+// NewHelixClient creates a new Helix API client.
+// The token may be given with or without the IRC "oauth:" prefix.
+func NewHelixClient(clientID, token string) *HelixClient {
+	return &HelixClient{
+		clientID:   clientID,
+		token:      strings.TrimPrefix(token, "oauth:"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// validateResponse represents the response from the OAuth validate endpoint
+type validateResponse struct {
+	ClientID  string   `json:"client_id"`
+	Login     string   `json:"login"`
+	UserID    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expires_in"`
+}
+
+// ValidateToken checks the OAuth token and fills in the client ID if one
+// was not configured, since Helix requires both.
+func (h *HelixClient) ValidateToken(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", oauthValidateURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "OAuth "+h.token)
+
+	var result validateResponse
+	if err := h.do(req, &result); err != nil {
+		return fmt.Errorf("validate token: %w", err)
+	}
+
+	if h.clientID == "" {
+		h.clientID = result.ClientID
+	}
+	h.userID = result.UserID
+	h.tokenInfo = TokenInfo{
+		Login:     result.Login,
+		Scopes:    result.Scopes,
+		ExpiresIn: time.Duration(result.ExpiresIn) * time.Second,
+	}
+
+	return nil
+}
+
+// TokenInfo returns the token's owner, scopes and lifetime as of the last
+// ValidateToken
+func (h *HelixClient) TokenInfo() TokenInfo {
+	return h.tokenInfo
+}
+
+// GetUsers looks up users by login name, batching requests as needed.
+// Logins that do not exist are omitted from the result.
+func (h *HelixClient) GetUsers(ctx context.Context, logins []string) ([]HelixUser, error) {
+	return h.getUsers(ctx, "login", logins)
+}
+
+// GetUsersByID looks up users by ID, batching requests as needed. IDs that
+// do not exist, or whose accounts are suspended, are omitted from the
+// result.
+func (h *HelixClient) GetUsersByID(ctx context.Context, ids []string) ([]HelixUser, error) {
+	return h.getUsers(ctx, "id", ids)
+}
+
+// getUsers looks up users by the given query parameter, "login" or "id"
+func (h *HelixClient) getUsers(ctx context.Context, key string, values []string) ([]HelixUser, error) {
+	var users []HelixUser
+
+	for start := 0; start < len(values); start += helixMaxLogins {
+		end := min(start+helixMaxLogins, len(values))
+
+		query := url.Values{}
+		for _, value := range values[start:end] {
+			query.Add(key, value)
+		}
+
+		req, err := h.newRequest(ctx, "/users?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Data []HelixUser `json:"data"`
+		}
+		if err := h.do(req, &result); err != nil {
+			return nil, fmt.Errorf("get users: %w", err)
+		}
+
+		users = append(users, result.Data...)
+	}
+
+	return users, nil
+}
+
+// APIError is a Helix response with an error status
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// do executes a request and decodes the JSON response into v
+func (h *HelixClient) do(req *http.Request, v any) error {
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(resp.Body)
+		return &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("JSON decode failed: %w", err)
+	}
+
+	return nil
+}
+
+// ResolveChannels validates the configured channels against Helix and
+// returns the ones that exist. Channels that cannot be found are logged
+// as errors so typos are obvious instead of silently never receiving messages.
+func ResolveChannels(ctx context.Context, helix *HelixClient, channels []string) ([]string, error) {
+	if err := helix.ValidateToken(ctx); err != nil {
+		return nil, err
+	}
+
+	logins := make([]string, len(channels))
+	for i, channel := range channels {
+		logins[i] = strings.ToLower(strings.TrimPrefix(channel, "#"))
+	}
+
+	users, err := helix.GetUsers(ctx, logins)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]HelixUser, len(users))
+	for _, user := range users {
+		found[user.Login] = user
+	}
+
+	var valid []string
+	for i, login := range logins {
+		user, ok := found[login]
+		if !ok {
+			log.Printf("Error: Twitch channel '%s' does not exist (check for typos), skipping", channels[i])
+			continue
+		}
+		log.Printf("Resolved Twitch channel: %s -> %s (ID %s)", login, user.DisplayName, user.ID)
+		valid = append(valid, login)
+	}
+
+	return valid, nil
+}
+
+// GetFollowedAt returns when a user followed a channel, or the zero time if
+// they don't follow it. The token must have the moderator:read:followers
+// scope and belong to the broadcaster or one of their moderators.
+func (h *HelixClient) GetFollowedAt(ctx context.Context, broadcasterID, userID string) (time.Time, error) {
+	query := url.Values{"broadcaster_id": {broadcasterID}, "user_id": {userID}}
+	req, err := h.newRequest(ctx, "/channels/followers?"+query.Encode())
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var result struct {
+		Data []struct {
+			FollowedAt time.Time `json:"followed_at"`
+		} `json:"data"`
+	}
+	if err := h.do(req, &result); err != nil {
+		return time.Time{}, fmt.Errorf("get channel followers: %w", err)
+	}
+	if len(result.Data) == 0 {
+		return time.Time{}, nil
+	}
+	return result.Data[0].FollowedAt, nil
+}
+
+// HelixStream represents a live stream returned by the Helix Get Streams endpoint
+type HelixStream struct {
+	UserID      string    `json:"user_id"`
+	UserLogin   string    `json:"user_login"`
+	GameID      string    `json:"game_id"`
+	GameName    string    `json:"game_name"`
+	Title       string    `json:"title"`
+	ViewerCount int       `json:"viewer_count"`
+	StartedAt   time.Time `json:"started_at"`
+}
+
+// GetGameID looks up a category's ID by its exact name
+func (h *HelixClient) GetGameID(ctx context.Context, name string) (string, error) {
+	req, err := h.newRequest(ctx, "/games?"+url.Values{"name": {name}}.Encode())
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := h.do(req, &result); err != nil {
+		return "", fmt.Errorf("get games: %w", err)
+	}
+	if len(result.Data) == 0 {
+		return "", fmt.Errorf("category %q not found", name)
+	}
+
+	return result.Data[0].ID, nil
+}
+
+// GetStreams returns up to limit live streams in a category, ordered by
+// viewer count
+func (h *HelixClient) GetStreams(ctx context.Context, gameID string, limit int) ([]HelixStream, error) {
+	var streams []HelixStream
+	cursor := ""
+
+	for len(streams) < limit {
+		query := url.Values{
+			"game_id": {gameID},
+			"first":   {fmt.Sprint(min(limit-len(streams), 100))},
+		}
+		if cursor != "" {
+			query.Set("after", cursor)
+		}
+
+		req, err := h.newRequest(ctx, "/streams?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Data       []HelixStream `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		if err := h.do(req, &result); err != nil {
+			return nil, fmt.Errorf("get streams: %w", err)
+		}
+
+		streams = append(streams, result.Data...)
+		if result.Pagination.Cursor == "" || len(result.Data) == 0 {
+			break
+		}
+		cursor = result.Pagination.Cursor
+	}
+
+	return streams, nil
+}
+
+// GetLiveStreams returns the live streams among the given channel logins
+func (h *HelixClient) GetLiveStreams(ctx context.Context, logins []string) ([]HelixStream, error) {
+	var streams []HelixStream
+
+	// Helix accepts at most 100 logins per request
+	for start := 0; start < len(logins); start += 100 {
+		end := min(start+100, len(logins))
+
+		query := url.Values{"first": {"100"}}
+		for _, login := range logins[start:end] {
+			query.Add("user_login", login)
+		}
+
+		req, err := h.newRequest(ctx, "/streams?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Data []HelixStream `json:"data"`
+		}
+		if err := h.do(req, &result); err != nil {
+			return nil, fmt.Errorf("get streams: %w", err)
+		}
+		streams = append(streams, result.Data...)
+	}
+
+	return streams, nil
+}
+
+// HelixChatter is a user connected to a channel's chat, as returned by the
+// Helix Get Chatters endpoint
+type HelixChatter struct {
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+}
+
+// GetChatters returns up to limit of the users connected to a channel's
+// chat, and how many there are in all. The token must have the
+// moderator:read:chatters scope and belong to the broadcaster or one of
+// their moderators; ValidateToken must have been called.
+func (h *HelixClient) GetChatters(ctx context.Context, broadcasterID string, limit int) ([]HelixChatter, int, error) {
+	var chatters []HelixChatter
+	total := 0
+	cursor := ""
+
+	for len(chatters) < limit {
+		query := url.Values{
+			"broadcaster_id": {broadcasterID},
+			"moderator_id":   {h.userID},
+			"first":          {fmt.Sprint(min(limit-len(chatters), 1000))},
+		}
+		if cursor != "" {
+			query.Set("after", cursor)
+		}
+
+		req, err := h.newRequest(ctx, "/chat/chatters?"+query.Encode())
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var result struct {
+			Data       []HelixChatter `json:"data"`
+			Total      int            `json:"total"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		if err := h.do(req, &result); err != nil {
+			return nil, 0, fmt.Errorf("get chatters: %w", err)
+		}
+
+		chatters = append(chatters, result.Data...)
+		total = result.Total
+		if result.Pagination.Cursor == "" || len(result.Data) == 0 {
+			break
+		}
+		cursor = result.Pagination.Cursor
+	}
+
+	return chatters, total, nil
+}
+
+// GetTeamMembers returns the login names of a Twitch team's members
+func (h *HelixClient) GetTeamMembers(ctx context.Context, teamName string) ([]string, error) {
+	req, err := h.newRequest(ctx, "/teams?"+url.Values{"name": {teamName}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Data []struct {
+			Users []struct {
+				UserLogin string `json:"user_login"`
+			} `json:"users"`
+		} `json:"data"`
+	}
+	if err := h.do(req, &result); err != nil {
+		return nil, fmt.Errorf("get teams: %w", err)
+	}
+	if len(result.Data) == 0 {
+		return nil, fmt.Errorf("team %q not found", teamName)
+	}
+
+	logins := make([]string, 0, len(result.Data[0].Users))
+	for _, user := range result.Data[0].Users {
+		logins = append(logins, user.UserLogin)
+	}
+	return logins, nil
+}
+
+// CreateChatSubscription subscribes an EventSub WebSocket session to a
+// broadcaster's chat messages, read as the token's user, and returns the
+// subscription ID. The token needs the user:read:chat scope.
+func (h *HelixClient) CreateChatSubscription(ctx context.Context, sessionID, broadcasterID string) (string, error) {
+	body := map[string]any{
+		"type":    "channel.chat.message",
+		"version": "1",
+		"condition": map[string]string{
+			"broadcaster_user_id": broadcasterID,
+			"user_id":             h.userID,
+		},
+		"transport": map[string]string{
+			"method":     "websocket",
+			"session_id": sessionID,
+		},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := h.newMethodRequest(ctx, http.MethodPost, "/eventsub/subscriptions", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := h.do(req, &result); err != nil {
+		return "", fmt.Errorf("create subscription: %w", err)
+	}
+	if len(result.Data) == 0 {
+		return "", fmt.Errorf("create subscription: empty response")
+	}
+	return result.Data[0].ID, nil
+}
+
+// DeleteSubscription removes an EventSub subscription
+func (h *HelixClient) DeleteSubscription(ctx context.Context, id string) error {
+	req, err := h.newMethodRequest(ctx, http.MethodDelete, "/eventsub/subscriptions?"+url.Values{"id": {id}}.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := h.do(req, nil); err != nil {
+		return fmt.Errorf("delete subscription: %w", err)
+	}
+	return nil
+}
+
+// newRequest creates an authenticated GET request for a Helix path
+func (h *HelixClient) newRequest(ctx context.Context, path string) (*http.Request, error) {
+	return h.newMethodRequest(ctx, http.MethodGet, path, nil)
+}
+
+// newMethodRequest creates an authenticated request for a Helix path
+func (h *HelixClient) newMethodRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, helixBaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Client-Id", h.clientID)
+	req.Header.Set("Authorization", "Bearer "+h.token)
+	return req, nil
+}
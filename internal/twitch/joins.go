@@ -0,0 +1,227 @@
+package twitch
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+)
+
+const (
+	// joinCheckInterval is how often unconfirmed joins are checked
+	joinCheckInterval = 5 * time.Second
+
+	// maxJoinBackoff caps the wait between rejoins of one channel
+	maxJoinBackoff = 30 * time.Minute
+)
+
+// joinState is a channel whose JOIN has not been confirmed by a ROOMSTATE
+type joinState struct {
+	since    time.Time // When the JOIN was requested
+	retryAt  time.Time // Earliest next rejoin
+	attempts int
+	notice   string // Last NOTICE Twitch sent for the channel, e.g. msg_channel_suspended
+}
+
+// joinTracker holds a connection's joins awaiting confirmation and its
+// account's IRC rate limiters
+type joinTracker struct {
+	name     string // The connection's, for logs
+	limits   RateLimits
+	joins    *windowLimiter
+	messages *windowLimiter
+
+	pending   map[string]*joinState
+	confirmed int
+	mu        sync.Mutex
+}
+
+// EnableRateLimits paces each account's JOINs and sent messages to limits,
+// and rejoins channels that Twitch does not confirm with a ROOMSTATE. It
+// must be called before Start.
+func (c *Connector) EnableRateLimits(limits RateLimits) {
+	c.rateLimits = &limits
+}
+
+// newJoinTracker creates a connection's tracker using its account's limiters
+func newJoinTracker(name string, limits RateLimits, joins, messages *windowLimiter) *joinTracker {
+	return &joinTracker{
+		name:     name,
+		limits:   limits,
+		joins:    joins,
+		messages: messages,
+		pending:  make(map[string]*joinState),
+	}
+}
+
+// requested marks channels as joined but not yet confirmed
+func (j *joinTracker) requested(channels ...string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	for _, channel := range channels {
+		j.pending[normalizeChannel(channel)] = &joinState{since: now}
+	}
+}
+
+// reset marks every channel unconfirmed, as after a reconnect
+func (j *joinTracker) reset(channels []string) {
+	j.mu.Lock()
+	j.pending = make(map[string]*joinState, len(channels))
+	j.confirmed = 0
+	j.mu.Unlock()
+	j.requested(channels...)
+}
+
+// parted forgets a channel
+func (j *joinTracker) parted(channel string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.pending, normalizeChannel(channel))
+}
+
+// onRoomState confirms a join. Twitch sends ROOMSTATE to the joining client
+// only once the JOIN has been accepted.
+func (j *joinTracker) onRoomState(msg twitch.RoomStateMessage) {
+	channel := normalizeChannel(msg.Channel)
+
+	j.mu.Lock()
+	state, ok := j.pending[channel]
+	if ok {
+		delete(j.pending, channel)
+		j.confirmed++
+	}
+	remaining := len(j.pending)
+	confirmed := j.confirmed
+	j.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if state.attempts > 0 {
+		log.Printf("Joined Twitch channel %s after %d rejoin(s)", channel, state.attempts)
+	}
+	if remaining == 0 {
+		log.Printf("All %d Twitch channel(s) joined on %s", confirmed, j.name)
+	}
+}
+
+// onNotice records why Twitch refused a pending join
+func (j *joinTracker) onNotice(msg twitch.NoticeMessage) {
+	channel := normalizeChannel(msg.Channel)
+
+	j.mu.Lock()
+	state, ok := j.pending[channel]
+	if ok {
+		state.notice = msg.MsgID
+	}
+	j.mu.Unlock()
+
+	if ok {
+		log.Printf("Warning: Twitch refused to join %s: %s (%s)", channel, msg.Message, msg.MsgID)
+	}
+}
+
+// due returns the pending channels to rejoin now and schedules their next
+// check. A channel is only given up on once the join queue has drained:
+// the timeout counts from the later of its request and the last JOIN sent.
+func (j *joinTracker) due(now time.Time) []string {
+	lastJoin := j.joins.lastSent()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var channels []string
+	for channel, state := range j.pending {
+		start := state.since
+		if lastJoin.After(start) {
+			start = lastJoin
+		}
+		if now.Sub(start) < j.limits.JoinTimeout || now.Before(state.retryAt) {
+			continue
+		}
+		state.attempts++
+		backoff := min(j.limits.JoinTimeout<<min(state.attempts-1, 16), maxJoinBackoff)
+		state.since = now
+		state.retryAt = now.Add(backoff)
+		channels = append(channels, channel)
+
+		reason := "no ROOMSTATE received"
+		if state.notice != "" {
+			reason = state.notice
+		}
+		log.Printf("Warning: Join of Twitch channel %s not confirmed (%s); rejoining (attempt %d)", channel, reason, state.attempts)
+	}
+	return channels
+}
+
+// pendingCount returns the number of unconfirmed joins
+func (j *joinTracker) pendingCount() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.pending)
+}
+
+// verifyJoins rejoins channels whose joins go unconfirmed until ctx is done
+func (c *Connector) verifyJoins(ctx context.Context) {
+	ticker := time.NewTicker(joinCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.connsMu.Lock()
+			conns := append([]*ircConn(nil), c.conns...)
+			c.connsMu.Unlock()
+
+			pending := 0
+			for _, conn := range conns {
+				for _, channel := range conn.tracker.due(time.Now()) {
+					// Join is a no-op for channels the client already has
+					conn.client.Depart(channel)
+					conn.client.Join(channel)
+				}
+				pending += conn.tracker.pendingCount()
+			}
+			c.status.Set("joins_pending", pending)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sayNow sends a reply if the message limit allows it, dropping it
+// otherwise; it is used from the IRC read loop, which must not block
+func (c *Connector) sayNow(channel, text string) {
+	conn := c.connFor(channel)
+	if conn == nil {
+		return
+	}
+	if conn.tracker != nil && !conn.tracker.messages.tryTake() {
+		log.Printf("Warning: Dropped Twitch message to %s: message rate limit reached", channel)
+		return
+	}
+	conn.client.Say(channel, text)
+}
+
+// sayPaced sends a message, waiting for the message limit to allow it
+func (c *Connector) sayPaced(channel, text string) {
+	conn := c.connFor(channel)
+	if conn == nil {
+		return
+	}
+	if conn.tracker != nil {
+		conn.tracker.messages.Throttle(1)
+	}
+	conn.client.Say(channel, text)
+}
+
+// normalizeChannel returns the lowercase login of an IRC channel name
+func normalizeChannel(channel string) string {
+	return strings.ToLower(strings.TrimPrefix(channel, "#"))
+}
@@ -0,0 +1,119 @@
+package twitch
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// LiveMonitor periodically asks Helix which joined channels are live and
+// reports the set to onUpdate
+type LiveMonitor struct {
+	helix     *HelixClient
+	connector *Connector
+	interval  time.Duration
+	onUpdate  func(live map[string]bool)
+
+	snapshots chan<- message.Message // nil unless snapshots are enabled
+	wasLive   map[string]bool        // live set from the previous poll
+}
+
+// NewLiveMonitor creates a new live status monitor
+func NewLiveMonitor(helix *HelixClient, connector *Connector, interval time.Duration, onUpdate func(live map[string]bool)) *LiveMonitor {
+	return &LiveMonitor{
+		helix:     helix,
+		connector: connector,
+		interval:  interval,
+		onUpdate:  onUpdate,
+	}
+}
+
+// EnableSnapshots records a stream snapshot (viewer count, title, category)
+// into messageChan for every live channel on each poll, plus one when a
+// channel goes offline. It must be called before Start.
+func (m *LiveMonitor) EnableSnapshots(messageChan chan<- message.Message) {
+	m.snapshots = messageChan
+	m.wasLive = make(map[string]bool)
+}
+
+// Start polls until the context is cancelled
+func (m *LiveMonitor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.poll(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// poll fetches the live set once. On error the previous state is kept.
+func (m *LiveMonitor) poll(ctx context.Context) {
+	channels := m.connector.Channels()
+	if len(channels) == 0 {
+		return
+	}
+
+	streams, err := m.helix.GetLiveStreams(ctx, channels)
+	if err != nil {
+		log.Printf("Warning: Failed to check Twitch live status: %v", err)
+		return
+	}
+
+	live := make(map[string]bool, len(streams))
+	for _, stream := range streams {
+		live[strings.ToLower(stream.UserLogin)] = true
+	}
+	if m.onUpdate != nil {
+		m.onUpdate(live)
+	}
+	if m.snapshots != nil {
+		m.recordSnapshots(ctx, streams, live)
+	}
+}
+
+// recordSnapshots sends a snapshot per live stream and for each channel
+// that went offline since the previous poll
+func (m *LiveMonitor) recordSnapshots(ctx context.Context, streams []HelixStream, live map[string]bool) {
+	var snapshots []message.Message
+	for _, stream := range streams {
+		startedAt := stream.StartedAt
+		snapshot := message.New("twitch", time.Time{})
+		snapshot.Channel = strings.ToLower(stream.UserLogin)
+		snapshot.Type = message.TypeStream
+		snapshot.Stream = &message.Stream{
+			Live:        true,
+			ViewerCount: stream.ViewerCount,
+			Title:       stream.Title,
+			Category:    stream.GameName,
+			StartedAt:   &startedAt,
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	for channel := range m.wasLive {
+		if !live[channel] {
+			snapshot := message.New("twitch", time.Time{})
+			snapshot.Channel = channel
+			snapshot.Type = message.TypeStream
+			snapshot.Stream = &message.Stream{Live: false}
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	m.wasLive = live
+
+	for _, snapshot := range snapshots {
+		select {
+		case m.snapshots <- snapshot:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
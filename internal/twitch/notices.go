@@ -0,0 +1,101 @@
+package twitch
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/john/chatlog/internal/message"
+)
+
+// redemptionMsgIDs are the PRIVMSG msg-ids of chat messages sent with a
+// built-in channel point reward
+var redemptionMsgIDs = map[string]bool{
+	"highlighted-message":    true, // Highlight My Message
+	"skip-subs-mode-message": true, // Send a Message in Sub-Only Mode
+}
+
+// eventSubRedemptions maps the channel.chat.message message types of
+// built-in channel point rewards to their IRC msg-ids
+var eventSubRedemptions = map[string]string{
+	"channel_points_highlighted": "highlighted-message",
+	"channel_points_sub_only":    "skip-subs-mode-message",
+}
+
+// redemptionOf returns the reward a chat message was sent with, from its
+// msg-id and custom-reward-id tags, or nil for ordinary chat
+func redemptionOf(msg twitch.PrivateMessage) *message.Notice {
+	msgID, rewardID := msg.Tags["msg-id"], msg.Tags["custom-reward-id"]
+	if !redemptionMsgIDs[msgID] && rewardID == "" {
+		return nil
+	}
+	return &message.Notice{MsgID: msgID, RewardID: rewardID}
+}
+
+// onUserNotice records a USERNOTICE: an announcement, or an event such as
+// a sub, gift or raid with the text Twitch shows for it
+func (c *Connector) onUserNotice(msg twitch.UserNoticeMessage) {
+	record := message.New("twitch", msg.Time)
+	record.Type = message.TypeUserNotice
+	if msg.MsgID == "announcement" {
+		record.Type = message.TypeAnnouncement
+	}
+	record.Channel = strings.TrimPrefix(msg.Channel, "#")
+	record.Username = msg.User.DisplayName
+	record.UserID = msg.User.ID
+	record.ID = msg.ID
+	record.Message = msg.Message
+	record.Badges = formatBadges(msg.User.Badges)
+	record.Emotes = emoteNames(msg.Emotes)
+	record.EmoteRefs = emoteRefs(msg.Emotes)
+	record.Notice = &message.Notice{
+		MsgID:         msg.MsgID,
+		SystemMessage: msg.SystemMsg,
+		Params:        noticeParams(msg.MsgParams),
+	}
+	c.status.MessageReceived()
+
+	select {
+	case c.messageChan <- record:
+	case <-c.ctx.Done():
+	}
+}
+
+// noticeParams strips the msg-param- prefix from a USERNOTICE's tags
+func noticeParams(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	params := make(map[string]string, len(tags))
+	for tag, value := range tags {
+		params[strings.TrimPrefix(tag, "msg-param-")] = value
+	}
+	return params
+}
+
+// onNotice records a NOTICE about a channel. Mode changes become room
+// state records; notices for no channel, such as login failures, are only
+// logged.
+func (c *Connector) onNotice(msg twitch.NoticeMessage) {
+	if _, ok := modeNotices[msg.MsgID]; ok {
+		c.onModeNotice(msg)
+		return
+	}
+	channel := strings.TrimPrefix(msg.Channel, "#")
+	if channel == "" || channel == "*" {
+		log.Printf("Twitch notice %s: %s", msg.MsgID, msg.Message)
+		return
+	}
+
+	record := message.New("twitch", time.Time{})
+	record.Type = message.TypeNotice
+	record.Channel = channel
+	record.Message = msg.Message
+	record.Notice = &message.Notice{MsgID: msg.MsgID}
+
+	select {
+	case c.messageChan <- record:
+	case <-c.ctx.Done():
+	}
+}
@@ -0,0 +1,94 @@
+package twitch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/john/chatlog/internal/message"
+)
+
+// cheermotePattern matches a word that may be a cheermote: a prefix ending
+// in a letter, then the Bits, e.g. "Cheer100" or "4Head50"
+var cheermotePattern = regexp.MustCompile(`^(\S*[A-Za-z])([1-9][0-9]*)$`)
+
+// hypeChatLevels maps pinned-chat-paid-level to a tier
+var hypeChatLevels = map[string]int{
+	"ONE": 1, "TWO": 2, "THREE": 3, "FOUR": 4, "FIVE": 5,
+	"SIX": 6, "SEVEN": 7, "EIGHT": 8, "NINE": 9, "TEN": 10,
+}
+
+// paidOf returns the Bits and Hype Chat payment of a chat message, or nil
+// for an unpaid one
+func paidOf(msg twitch.PrivateMessage) *message.Paid {
+	var paid *message.Paid
+	if msg.Bits > 0 {
+		paid = &message.Paid{Bits: msg.Bits, Cheermotes: cheermotesIn(msg.Message, msg.Bits)}
+	}
+	if amount := msg.Tags["pinned-chat-paid-amount"]; amount != "" {
+		if paid == nil {
+			paid = &message.Paid{}
+		}
+		hypeChat(paid, msg.Tags)
+	}
+	return paid
+}
+
+// cheermotesIn breaks a message's Bits down by cheermote. IRC doesn't mark
+// cheermotes, so words that look like one are taken only if they add up
+// to the Bits the message carries; otherwise nil.
+func cheermotesIn(text string, bits int) []message.Cheermote {
+	var cheers []message.Cheermote
+	total := 0
+	for _, word := range strings.Fields(text) {
+		match := cheermotePattern.FindStringSubmatch(word)
+		if match == nil {
+			continue
+		}
+		amount, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		cheers = append(cheers, message.Cheermote{Prefix: match[1], Bits: amount})
+		total += amount
+	}
+	if total != bits {
+		return nil
+	}
+	return cheers
+}
+
+// hypeChat sets a Hype Chat's amount from its pinned-chat-paid-* tags. The
+// amount is in the currency's minor units, with the exponent giving their
+// number of decimal places.
+func hypeChat(paid *message.Paid, tags map[string]string) {
+	paid.Pinned = true
+	paid.Currency = tags["pinned-chat-paid-currency"]
+	paid.Tier = hypeChatLevels[tags["pinned-chat-paid-level"]]
+
+	amount, err := strconv.ParseInt(tags["pinned-chat-paid-amount"], 10, 64)
+	if err != nil {
+		return
+	}
+	exponent, err := strconv.Atoi(tags["pinned-chat-paid-exponent"])
+	if err != nil || exponent < 0 || exponent > 6 {
+		return
+	}
+	micros := amount
+	for range 6 - exponent {
+		micros *= 10
+	}
+	paid.AmountMicros = micros
+
+	unit := int64(1)
+	for range exponent {
+		unit *= 10
+	}
+	display := strconv.FormatInt(amount/unit, 10)
+	if exponent > 0 {
+		display += fmt.Sprintf(".%0*d", exponent, amount%unit)
+	}
+	paid.Display = strings.TrimSpace(display + " " + paid.Currency)
+}
@@ -0,0 +1,215 @@
+package twitch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/john/chatlog/internal/status"
+)
+
+// Account is a Twitch login chat is read with. An empty Username reads
+// anonymously as a justinfan user.
+type Account struct {
+	Username string
+	OAuth    string
+}
+
+// account is a login and the rate limiters its connections share, since
+// Twitch applies join and message limits per account
+type account struct {
+	Account
+	conns    int
+	joins    *windowLimiter // nil without rate limits
+	messages *windowLimiter
+}
+
+// ircConn is one IRC connection and the channels assigned to it
+type ircConn struct {
+	name     string // For logs, e.g. "connection 2 (bot2)"
+	account  *account
+	client   *twitch.Client
+	tracker  *joinTracker // nil without rate limits
+	channels map[string]bool
+}
+
+// EnablePool spreads channels over several accounts and IRC connections
+// per account, at most maxChannels per connection (0 for no limit). A new
+// connection is opened when all are full, on the account with the fewest,
+// so the accounts' separate join limits add up. accounts replace the login
+// passed to New. It must be called before Start.
+func (c *Connector) EnablePool(accounts []Account, maxChannels int) {
+	c.accounts = c.accounts[:0]
+	for _, a := range accounts {
+		c.accounts = append(c.accounts, &account{Account: a})
+	}
+	c.maxChannels = maxChannels
+}
+
+// assign returns the connection a channel is joined on, picking the
+// least loaded one with room and opening a new one if none has. The caller
+// must hold c.connsMu.
+func (c *Connector) assign(channel string) (*ircConn, bool) {
+	if conn, ok := c.assigned[channel]; ok {
+		return conn, false
+	}
+
+	var best *ircConn
+	for _, conn := range c.conns {
+		if c.maxChannels > 0 && len(conn.channels) >= c.maxChannels {
+			continue
+		}
+		if best == nil || len(conn.channels) < len(best.channels) {
+			best = conn
+		}
+	}
+	if best == nil {
+		best = c.newConn()
+	}
+	best.channels[channel] = true
+	c.assigned[channel] = best
+	return best, true
+}
+
+// unassign removes a channel from its connection, returning the connection
+// or nil if the channel wasn't assigned. The caller must hold c.connsMu.
+func (c *Connector) unassign(channel string) *ircConn {
+	conn, ok := c.assigned[channel]
+	if !ok {
+		return nil
+	}
+	delete(conn.channels, channel)
+	delete(c.assigned, channel)
+	return conn
+}
+
+// connFor returns the connection a channel is joined on, or nil
+func (c *Connector) connFor(channel string) *ircConn {
+	c.connsMu.Lock()
+	defer c.connsMu.Unlock()
+	return c.assigned[normalizeChannel(channel)]
+}
+
+// newConn opens a connection on the account with the fewest, connecting
+// it right away if the connector has started. The caller must hold
+// c.connsMu.
+func (c *Connector) newConn() *ircConn {
+	acct := c.accounts[0]
+	for _, a := range c.accounts[1:] {
+		if a.conns < acct.conns {
+			acct = a
+		}
+	}
+	acct.conns++
+
+	client := twitch.NewAnonymousClient()
+	login := "anonymous"
+	if acct.Username != "" {
+		client = twitch.NewClient(acct.Username, acct.OAuth)
+		login = acct.Username
+	}
+	conn := &ircConn{
+		name:     fmt.Sprintf("connection %d (%s)", len(c.conns)+1, login),
+		account:  acct,
+		client:   client,
+		channels: make(map[string]bool),
+	}
+
+	if c.rateLimits != nil {
+		if acct.joins == nil {
+			acct.joins = newWindowLimiter(c.rateLimits.Joins, c.rateLimits.JoinWindow)
+			acct.messages = newWindowLimiter(c.rateLimits.Messages, c.rateLimits.MessageWindow)
+		}
+		conn.tracker = newJoinTracker(conn.name, *c.rateLimits, acct.joins, acct.messages)
+		client.SetJoinRateLimiter(acct.joins)
+	}
+	c.setupHandlers(conn)
+
+	c.conns = append(c.conns, conn)
+	c.status.Set("connections", len(c.conns))
+	if c.started {
+		c.connect(conn)
+	}
+	return conn
+}
+
+// connect runs a connection's client in the background until it is
+// disconnected
+func (c *Connector) connect(conn *ircConn) {
+	if c.relayAddress != "" {
+		conn.client.IrcAddress = c.relayAddress
+		conn.client.TLS = false
+	}
+	go func() {
+		err := conn.client.Connect()
+		if err != nil && !errors.Is(err, twitch.ErrClientDisconnected) {
+			log.Printf("Twitch IRC connection error on %s: %v", conn.name, err)
+			c.status.SetState(status.StateStopped)
+			c.status.Set("error", err.Error())
+		}
+	}()
+}
+
+// setupHandlers registers a connection's IRC event handlers
+func (c *Connector) setupHandlers(conn *ircConn) {
+	conn.client.OnPrivateMessage(func(msg twitch.PrivateMessage) {
+		c.onPrivateMessage(msg)
+	})
+
+	conn.client.OnConnect(func() {
+		c.connsMu.Lock()
+		channels := make([]string, 0, len(conn.channels))
+		for channel := range conn.channels {
+			channels = append(channels, channel)
+		}
+		c.connsMu.Unlock()
+
+		log.Printf("Connected to Twitch IRC on %s with %d channel(s)", conn.name, len(channels))
+		c.status.SetState(status.StateConnected)
+		c.status.Set("channels", len(c.Channels()))
+
+		// The client rejoins every channel on each connect
+		if conn.tracker != nil {
+			conn.tracker.reset(channels)
+		}
+	})
+
+	conn.client.OnRoomStateMessage(func(msg twitch.RoomStateMessage) {
+		if conn.tracker != nil {
+			conn.tracker.onRoomState(msg)
+		}
+		c.onRoomState(msg)
+	})
+	conn.client.OnNoticeMessage(func(msg twitch.NoticeMessage) {
+		if conn.tracker != nil {
+			conn.tracker.onNotice(msg)
+		}
+		c.onNotice(msg)
+	})
+	conn.client.OnUserNoticeMessage(func(msg twitch.UserNoticeMessage) {
+		c.onUserNotice(msg)
+	})
+
+	conn.client.OnReconnectMessage(func(msg twitch.ReconnectMessage) {
+		log.Printf("Reconnecting to Twitch IRC on %s...", conn.name)
+		c.status.SetState(status.StateReconnecting)
+	})
+
+	if c.presence != nil {
+		conn.client.OnSelfJoinMessage(func(msg twitch.UserJoinMessage) {
+			c.presence.onJoin(c.sayNow, msg.Channel)
+		})
+	}
+}
+
+// disconnectAll closes every connection
+func (c *Connector) disconnectAll() {
+	c.connsMu.Lock()
+	conns := append([]*ircConn(nil), c.conns...)
+	c.connsMu.Unlock()
+
+	for _, conn := range conns {
+		conn.client.Disconnect()
+	}
+}
@@ -0,0 +1,132 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// commandCooldown limits how often the status command is answered per channel
+const commandCooldown = 30 * time.Second
+
+// PresenceConfig controls messages the bot account sends to disclose logging
+type PresenceConfig struct {
+	JoinMessage string        // Sent once per channel after joining (empty disables)
+	Message     string        // Sent periodically (empty disables)
+	Interval    time.Duration // Period for Message
+	Command     string        // Chat command answered with archive status, e.g. "!chatlog" (empty disables)
+	Channels    []string      // Limit presence messages to these channels (empty means all)
+}
+
+// sayFunc sends a chat message to a channel
+type sayFunc func(channel, text string)
+
+// presence tracks per-channel state for presence messages
+type presence struct {
+	cfg      PresenceConfig
+	channels map[string]bool
+
+	announced   map[string]bool
+	lastCommand map[string]time.Time
+	stats       map[string]*channelStats
+	mu          sync.Mutex
+}
+
+// channelStats holds what is reported by the status command
+type channelStats struct {
+	since    time.Time
+	messages int64
+}
+
+// EnablePresence turns on presence messages; it must be called before Start
+func (c *Connector) EnablePresence(cfg PresenceConfig) {
+	c.presence = &presence{
+		cfg:         cfg,
+		channels:    toSet(cfg.Channels),
+		announced:   make(map[string]bool),
+		lastCommand: make(map[string]time.Time),
+		stats:       make(map[string]*channelStats),
+	}
+}
+
+// enabledFor reports whether presence messages should be sent to channel
+func (p *presence) enabledFor(channel string) bool {
+	return len(p.channels) == 0 || p.channels[channel]
+}
+
+// onJoin sends the join message the first time a channel is joined
+func (p *presence) onJoin(say sayFunc, channel string) {
+	p.mu.Lock()
+	if _, ok := p.stats[channel]; !ok {
+		p.stats[channel] = &channelStats{since: time.Now()}
+	}
+	first := !p.announced[channel]
+	p.announced[channel] = true
+	p.mu.Unlock()
+
+	// Reconnects trigger a fresh JOIN; only announce once per process
+	if first && p.cfg.JoinMessage != "" && p.enabledFor(channel) {
+		say(channel, p.cfg.JoinMessage)
+	}
+}
+
+// onMessage counts a message and answers the status command
+func (p *presence) onMessage(say sayFunc, channel, text string) {
+	p.mu.Lock()
+	stats, ok := p.stats[channel]
+	if !ok {
+		stats = &channelStats{since: time.Now()}
+		p.stats[channel] = stats
+	}
+	stats.messages++
+
+	isCommand := p.cfg.Command != "" && strings.EqualFold(strings.TrimSpace(text), p.cfg.Command)
+	onCooldown := time.Since(p.lastCommand[channel]) < commandCooldown
+	if isCommand && !onCooldown {
+		p.lastCommand[channel] = time.Now()
+	}
+	since, count := stats.since, stats.messages
+	p.mu.Unlock()
+
+	if !isCommand || onCooldown || !p.enabledFor(channel) {
+		return
+	}
+
+	say(channel, fmt.Sprintf("Chat logging is active for #%s: %d messages archived since %s UTC",
+		channel, count, since.UTC().Format("2006-01-02 15:04")))
+}
+
+// runPeriodic sends the periodic message to every joined channel
+func (p *presence) runPeriodic(ctx context.Context, say sayFunc) {
+	if p.cfg.Message == "" || p.cfg.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			channels := make([]string, 0, len(p.announced))
+			for channel := range p.announced {
+				channels = append(channels, channel)
+			}
+			p.mu.Unlock()
+
+			for _, channel := range channels {
+				if p.enabledFor(channel) {
+					say(channel, p.cfg.Message)
+				}
+			}
+			log.Printf("Sent presence message to %d Twitch channel(s)", len(channels))
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package twitch
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimits are the account's Twitch IRC limits. Twitch silently drops
+// JOINs and messages over them, so they are paced here instead.
+type RateLimits struct {
+	Joins         int           // Channels joined per JoinWindow
+	JoinWindow    time.Duration // Sliding window for Joins
+	Messages      int           // Chat messages sent per MessageWindow
+	MessageWindow time.Duration // Sliding window for Messages
+	JoinTimeout   time.Duration // Rejoin a channel with no ROOMSTATE this long after the last JOIN was sent
+}
+
+// windowLimiter allows at most limit events in any sliding window. It
+// implements go-twitch-irc's RateLimiter, which calls Throttle before
+// writing each JOIN with the number of channels in it.
+type windowLimiter struct {
+	limit  int
+	window time.Duration
+
+	sent []time.Time
+	last time.Time // When events were last let through
+	mu   sync.Mutex
+}
+
+// newWindowLimiter creates a limiter of limit events per window
+func newWindowLimiter(limit int, window time.Duration) *windowLimiter {
+	return &windowLimiter{limit: limit, window: window}
+}
+
+// GetLimit is the most channels go-twitch-irc puts in one JOIN
+func (l *windowLimiter) GetLimit() int {
+	return l.limit
+}
+
+// IsUnlimited is always false
+func (l *windowLimiter) IsUnlimited() bool {
+	return false
+}
+
+// Throttle blocks until count more events fit in the window
+func (l *windowLimiter) Throttle(count int) {
+	for {
+		wait := l.reserve(count)
+		if wait == 0 {
+			return
+		}
+		time.Sleep(wait)
+	}
+}
+
+// tryTake records one event if it fits in the window now
+func (l *windowLimiter) tryTake() bool {
+	return l.reserve(1) == 0
+}
+
+// reserve records count events and returns zero if they fit in the window,
+// or else how long until they would
+func (l *windowLimiter) reserve(count int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	kept := l.sent[:0]
+	for _, t := range l.sent {
+		if now.Sub(t) < l.window {
+			kept = append(kept, t)
+		}
+	}
+	l.sent = kept
+
+	// A batch larger than the limit goes through alone
+	if len(l.sent)+count <= l.limit || len(l.sent) == 0 {
+		for range count {
+			l.sent = append(l.sent, now)
+		}
+		l.last = now
+		return 0
+	}
+	oldest := l.sent[min(len(l.sent)+count-l.limit, len(l.sent))-1]
+	return max(oldest.Add(l.window).Sub(now), time.Millisecond)
+}
+
+// lastSent returns when events were last let through
+func (l *windowLimiter) lastSent() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
@@ -0,0 +1,21 @@
+package twitch
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/john/chatlog/internal/rawlog"
+)
+
+// EnableRawLog copies every IRC line sent and received to l, numbering the
+// connections. The IRC client can't be tapped directly, so connections go
+// through the loopback relay even without an egress network. It must be
+// called before Start.
+func (c *Connector) EnableRawLog(l *rawlog.Log) {
+	c.rawLog = l
+}
+
+// rawStreams returns the raw log writers of a new relayed connection
+func (c *Connector) rawStreams() (sent, received io.Writer) {
+	return c.rawLog.Streams(fmt.Sprintf("irc#%d", c.rawConns.Add(1)))
+}
@@ -0,0 +1,81 @@
+package twitch
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/john/chatlog/internal/message"
+)
+
+// modeNotices are the NOTICE msg-ids announcing a chat mode change, with
+// the state each sets. Durations of slow_on and followers_on are only in
+// the announcement's text.
+var modeNotices = map[string]func(*message.RoomState){
+	"emote_only_on":     func(s *message.RoomState) { s.EmoteOnly = ptr(true) },
+	"emote_only_off":    func(s *message.RoomState) { s.EmoteOnly = ptr(false) },
+	"subs_on":           func(s *message.RoomState) { s.SubsOnly = ptr(true) },
+	"subs_off":          func(s *message.RoomState) { s.SubsOnly = ptr(false) },
+	"r9k_on":            func(s *message.RoomState) { s.UniqueChat = ptr(true) },
+	"r9k_off":           func(s *message.RoomState) { s.UniqueChat = ptr(false) },
+	"slow_on":           func(s *message.RoomState) {},
+	"slow_off":          func(s *message.RoomState) { s.SlowSeconds = ptr(0) },
+	"followers_on":      func(s *message.RoomState) {},
+	"followers_on_zero": func(s *message.RoomState) { s.FollowersOnly = ptr(0) },
+	"followers_off":     func(s *message.RoomState) { s.FollowersOnly = ptr(-1) },
+}
+
+// ptr returns a pointer to v
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// onRoomState records a channel's chat modes, sent in full when the channel
+// is joined and with only the changed mode afterwards
+func (c *Connector) onRoomState(msg twitch.RoomStateMessage) {
+	state := &message.RoomState{}
+	for tag, value := range msg.State {
+		switch tag {
+		case "emote-only":
+			state.EmoteOnly = ptr(value == 1)
+		case "subs-only":
+			state.SubsOnly = ptr(value == 1)
+		case "r9k":
+			state.UniqueChat = ptr(value == 1)
+		case "slow":
+			state.SlowSeconds = ptr(value)
+		case "followers-only":
+			state.FollowersOnly = ptr(value)
+		}
+	}
+	if *state == (message.RoomState{}) {
+		return // Only modes chatlog doesn't record, such as rituals
+	}
+	c.sendRoomState(msg.Channel, "", state)
+}
+
+// onModeNotice records a NOTICE announcing a chat mode change. Twitch sends
+// these to moderators; other clients see the change as a ROOMSTATE.
+func (c *Connector) onModeNotice(msg twitch.NoticeMessage) {
+	apply, ok := modeNotices[msg.MsgID]
+	if !ok {
+		return
+	}
+	state := &message.RoomState{Notice: msg.MsgID}
+	apply(state)
+	c.sendRoomState(msg.Channel, msg.Message, state)
+}
+
+// sendRoomState sends a room state record for a channel
+func (c *Connector) sendRoomState(channel, text string, state *message.RoomState) {
+	record := message.New("twitch", time.Time{})
+	record.Type = message.TypeRoomState
+	record.Channel = strings.TrimPrefix(channel, "#")
+	record.Message = text
+	record.RoomState = state
+
+	select {
+	case c.messageChan <- record:
+	case <-c.ctx.Done():
+	}
+}
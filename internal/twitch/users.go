@@ -0,0 +1,314 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+const (
+	// userLookupInterval is how often queued lookups are sent to Helix
+	userLookupInterval = 2 * time.Second
+
+	// maxFollowLookups caps follower lookups per interval, one request
+	// each, to stay well inside Helix's 800 requests a minute
+	maxFollowLookups = 10
+)
+
+// userInfo is a cached Get Users result. A zero createdAt means the
+// account no longer exists or is suspended.
+type userInfo struct {
+	createdAt       time.Time
+	broadcasterType string
+	fetchedAt       time.Time
+}
+
+// followInfo is a cached follower lookup; a zero followedAt means the user
+// doesn't follow the channel
+type followInfo struct {
+	followedAt time.Time
+	fetchedAt  time.Time
+}
+
+// followKey identifies a user in a channel
+type followKey struct {
+	channel string
+	userID  string
+}
+
+// UserCache adds Twitch account details from Helix to chat messages:
+// "account_created", "account_age_days" at the time of the message and
+// "broadcaster_type", plus "follower" and "followed_at" with follower
+// lookups enabled. It is an enrich processor, so it never blocks the
+// pipeline on Helix: a user not yet cached is queued for a batched lookup
+// by Run, and their messages are enriched once it completes, usually
+// within seconds.
+type UserCache struct {
+	helix     *HelixClient
+	ttl       time.Duration
+	maxUsers  int
+	followers bool
+	status    *status.Component
+
+	users          map[string]userInfo // User ID -> details
+	follows        map[followKey]followInfo
+	broadcasterIDs map[string]string // Channel login -> user ID
+	noFollowers    map[string]bool   // Channels whose followers the token can't read
+	pendingUsers   map[string]bool
+	pendingFollows map[followKey]bool
+	mu             sync.Mutex
+}
+
+// NewUserCache creates a cache keeping each user's details for ttl and at
+// most maxUsers users. With followers, whether each chatter follows the
+// channel is looked up too, which needs a moderator's token with the
+// moderator:read:followers scope.
+func NewUserCache(helix *HelixClient, ttl time.Duration, maxUsers int, followers bool) *UserCache {
+	return &UserCache{
+		helix:          helix,
+		ttl:            ttl,
+		maxUsers:       maxUsers,
+		followers:      followers,
+		users:          make(map[string]userInfo),
+		follows:        make(map[followKey]followInfo),
+		broadcasterIDs: make(map[string]string),
+		noFollowers:    make(map[string]bool),
+		pendingUsers:   make(map[string]bool),
+		pendingFollows: make(map[followKey]bool),
+	}
+}
+
+// EnableStatus reports the cache size and pending lookups to comp. It must
+// be called before Run.
+func (u *UserCache) EnableStatus(comp *status.Component) {
+	u.status = comp
+}
+
+// Process enriches a Twitch chat message from the cache, queueing lookups
+// for what is missing or stale
+func (u *UserCache) Process(msg *message.Message) {
+	if msg.Platform != "twitch" || msg.UserID == "" {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+
+	info, ok := u.users[msg.UserID]
+	if (!ok || now.Sub(info.fetchedAt) > u.ttl) && len(u.pendingUsers) < u.maxUsers {
+		u.pendingUsers[msg.UserID] = true
+	}
+	if ok && !info.createdAt.IsZero() {
+		msg.Enrich("account_created", info.createdAt.UTC().Format(time.RFC3339))
+		if sentAt, err := time.Parse(time.RFC3339Nano, msg.Timestamp); err == nil {
+			msg.Enrich("account_age_days", int(sentAt.Sub(info.createdAt).Hours()/24))
+		}
+		if info.broadcasterType != "" {
+			msg.Enrich("broadcaster_type", info.broadcasterType)
+		}
+	}
+
+	if !u.followers || u.noFollowers[msg.Channel] {
+		return
+	}
+	key := followKey{channel: msg.Channel, userID: msg.UserID}
+	follow, ok := u.follows[key]
+	if (!ok || now.Sub(follow.fetchedAt) > u.ttl) && len(u.pendingFollows) < u.maxUsers {
+		u.pendingFollows[key] = true
+	}
+	if ok {
+		msg.Enrich("follower", !follow.followedAt.IsZero())
+		if !follow.followedAt.IsZero() {
+			msg.Enrich("followed_at", follow.followedAt.UTC().Format(time.RFC3339))
+		}
+	}
+}
+
+// Run looks up queued users until ctx is cancelled
+func (u *UserCache) Run(ctx context.Context) error {
+	for {
+		err := u.helix.ValidateToken(ctx)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Warning: Twitch user lookups can't start, retrying in a minute: %v", err)
+		u.status.SetState(status.StateDegraded)
+		select {
+		case <-time.After(time.Minute):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	u.status.SetState(status.StateRunning)
+
+	ticker := time.NewTicker(userLookupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		u.lookupUsers(ctx)
+		u.lookupFollows(ctx)
+
+		u.mu.Lock()
+		u.status.Set("cached_users", len(u.users))
+		u.status.Set("pending_lookups", len(u.pendingUsers)+len(u.pendingFollows))
+		u.mu.Unlock()
+	}
+}
+
+// lookupUsers fetches up to one Get Users batch of queued users
+func (u *UserCache) lookupUsers(ctx context.Context) {
+	u.mu.Lock()
+	ids := make([]string, 0, min(len(u.pendingUsers), helixMaxLogins))
+	for id := range u.pendingUsers {
+		if len(ids) == helixMaxLogins {
+			break
+		}
+		ids = append(ids, id)
+	}
+	u.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	users, err := u.helix.GetUsersByID(ctx, ids)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Warning: Twitch user lookup failed, retrying: %v", err)
+			u.status.SetState(status.StateDegraded)
+		}
+		return
+	}
+	u.status.SetState(status.StateRunning)
+
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.makeRoom(len(ids))
+	// IDs missing from the result are remembered as gone, so they aren't
+	// looked up again until the entry expires
+	for _, id := range ids {
+		u.users[id] = userInfo{fetchedAt: now}
+		delete(u.pendingUsers, id)
+	}
+	for _, user := range users {
+		u.users[user.ID] = userInfo{createdAt: user.CreatedAt, broadcasterType: user.BroadcasterType, fetchedAt: now}
+	}
+}
+
+// lookupFollows fetches up to maxFollowLookups queued follower lookups.
+// Channels whose followers the token isn't allowed to read are skipped
+// from then on.
+func (u *UserCache) lookupFollows(ctx context.Context) {
+	u.mu.Lock()
+	keys := make([]followKey, 0, maxFollowLookups)
+	var channels []string
+	for key := range u.pendingFollows {
+		if len(keys) == maxFollowLookups {
+			break
+		}
+		keys = append(keys, key)
+		if _, ok := u.broadcasterIDs[key.channel]; !ok {
+			channels = append(channels, key.channel)
+		}
+	}
+	u.mu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+
+	if len(channels) > 0 {
+		broadcasters, err := u.helix.GetUsers(ctx, channels)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Warning: Twitch broadcaster lookup failed, retrying: %v", err)
+			}
+			return
+		}
+		u.mu.Lock()
+		for _, b := range broadcasters {
+			u.broadcasterIDs[b.Login] = b.ID
+		}
+		u.mu.Unlock()
+	}
+
+	for _, key := range keys {
+		u.mu.Lock()
+		broadcasterID, ok := u.broadcasterIDs[key.channel]
+		denied := u.noFollowers[key.channel]
+		u.mu.Unlock()
+		if denied {
+			continue
+		}
+		if !ok {
+			u.mu.Lock()
+			delete(u.pendingFollows, key) // Channel no longer exists
+			u.mu.Unlock()
+			continue
+		}
+
+		followedAt, err := u.helix.GetFollowedAt(ctx, broadcasterID, key.userID)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+			log.Printf("Warning: Not looking up followers of %s, the token can't read them (it needs moderator:read:followers and to be a moderator there): %v", key.channel, err)
+			u.mu.Lock()
+			u.noFollowers[key.channel] = true
+			for pending := range u.pendingFollows {
+				if pending.channel == key.channel {
+					delete(u.pendingFollows, pending)
+				}
+			}
+			u.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Warning: Twitch follower lookup failed, retrying: %v", err)
+			}
+			return
+		}
+
+		u.mu.Lock()
+		u.follows[key] = followInfo{followedAt: followedAt, fetchedAt: time.Now()}
+		delete(u.pendingFollows, key)
+		u.mu.Unlock()
+	}
+}
+
+// makeRoom evicts users so n more fit: expired ones first, then arbitrary
+// ones. Follower entries are cleared along with a full user cache. The
+// caller must hold u.mu.
+func (u *UserCache) makeRoom(n int) {
+	if len(u.users)+n <= u.maxUsers {
+		return
+	}
+	now := time.Now()
+	for id, info := range u.users {
+		if now.Sub(info.fetchedAt) > u.ttl {
+			delete(u.users, id)
+		}
+	}
+	for id := range u.users {
+		if len(u.users)+n <= u.maxUsers {
+			break
+		}
+		delete(u.users, id)
+	}
+	if len(u.follows) > u.maxUsers {
+		clear(u.follows)
+	}
+}
@@ -0,0 +1,268 @@
+package twitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+const (
+	gqlURL = "https://gql.twitch.tv/gql"
+
+	// gqlClientID is the public client ID of Twitch's web player, which the
+	// chat replay queries require
+	gqlClientID = "kimne78kx3ncx6brgo4mv6wki5h1ko"
+
+	// videoCommentsHash is the persisted query the web player uses to page
+	// through a VOD's chat replay
+	videoCommentsHash = "b70a3591ff0f4e0313d126c6a1502d79a1c02baebb288227c582044aa76adf6a"
+)
+
+// VOD describes a past broadcast whose chat can be replayed
+type VOD struct {
+	ID        string
+	Channel   string // Broadcaster login
+	ChannelID string
+	CreatedAt time.Time
+	Length    time.Duration
+}
+
+// VODClient downloads chat replays from Twitch's GQL API, the Rechat
+// successor used by the web player
+type VODClient struct {
+	clientID   string
+	httpClient *http.Client
+}
+
+// NewVODClient creates a chat replay client. An empty client ID uses the
+// web player's.
+func NewVODClient(clientID string) *VODClient {
+	if clientID == "" {
+		clientID = gqlClientID
+	}
+	return &VODClient{
+		clientID:   clientID,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GetVOD looks up a VOD by ID
+func (v *VODClient) GetVOD(ctx context.Context, id string) (*VOD, error) {
+	body := map[string]any{
+		"query":     `query($id: ID!) { video(id: $id) { id createdAt lengthSeconds owner { id login } } }`,
+		"variables": map[string]any{"id": id},
+	}
+
+	var result struct {
+		Data struct {
+			Video *struct {
+				ID            string    `json:"id"`
+				CreatedAt     time.Time `json:"createdAt"`
+				LengthSeconds int       `json:"lengthSeconds"`
+				Owner         struct {
+					ID    string `json:"id"`
+					Login string `json:"login"`
+				} `json:"owner"`
+			} `json:"video"`
+		} `json:"data"`
+	}
+	if err := v.query(ctx, body, &result); err != nil {
+		return nil, fmt.Errorf("get VOD %s: %w", id, err)
+	}
+
+	video := result.Data.Video
+	if video == nil {
+		return nil, fmt.Errorf("VOD %s not found", id)
+	}
+	return &VOD{
+		ID:        video.ID,
+		Channel:   video.Owner.Login,
+		ChannelID: video.Owner.ID,
+		CreatedAt: video.CreatedAt,
+		Length:    time.Duration(video.LengthSeconds) * time.Second,
+	}, nil
+}
+
+// vodComment is one chat message in a VOD's replay
+type vodComment struct {
+	ID                   string    `json:"id"`
+	CreatedAt            time.Time `json:"createdAt"`
+	ContentOffsetSeconds float64   `json:"contentOffsetSeconds"`
+	Commenter            *struct {
+		ID          string `json:"id"`
+		Login       string `json:"login"`
+		DisplayName string `json:"displayName"`
+	} `json:"commenter"`
+	Message struct {
+		Fragments []struct {
+			Text  string `json:"text"`
+			Emote *struct {
+				EmoteID string `json:"emoteID"`
+			} `json:"emote"`
+		} `json:"fragments"`
+		UserBadges []struct {
+			SetID   string `json:"setID"`
+			Version string `json:"version"`
+		} `json:"userBadges"`
+	} `json:"message"`
+}
+
+// Comments downloads a VOD's chat replay, calling fn with each page of
+// messages in order. Replays only keep chat messages: no Bits amounts,
+// reply parents or Shared Chat sources.
+func (v *VODClient) Comments(ctx context.Context, vod *VOD, fn func([]message.Message) error) error {
+	cursor := ""
+	for {
+		variables := map[string]any{"videoID": vod.ID}
+		if cursor == "" {
+			variables["contentOffsetSeconds"] = 0
+		} else {
+			variables["cursor"] = cursor
+		}
+		body := map[string]any{
+			"operationName": "VideoCommentsByOffsetOrCursor",
+			"variables":     variables,
+			"extensions": map[string]any{
+				"persistedQuery": map[string]any{"version": 1, "sha256Hash": videoCommentsHash},
+			},
+		}
+
+		var result struct {
+			Data struct {
+				Video *struct {
+					Comments *struct {
+						Edges []struct {
+							Cursor string     `json:"cursor"`
+							Node   vodComment `json:"node"`
+						} `json:"edges"`
+						PageInfo struct {
+							HasNextPage bool `json:"hasNextPage"`
+						} `json:"pageInfo"`
+					} `json:"comments"`
+				} `json:"video"`
+			} `json:"data"`
+		}
+		if err := v.query(ctx, body, &result); err != nil {
+			return fmt.Errorf("get comments of VOD %s: %w", vod.ID, err)
+		}
+		if result.Data.Video == nil || result.Data.Video.Comments == nil {
+			return fmt.Errorf("VOD %s has no chat replay", vod.ID)
+		}
+
+		comments := result.Data.Video.Comments
+		messages := make([]message.Message, 0, len(comments.Edges))
+		for _, edge := range comments.Edges {
+			if edge.Node.Commenter == nil {
+				continue // Deleted accounts
+			}
+			messages = append(messages, convertVODComment(edge.Node, vod))
+		}
+		if err := fn(messages); err != nil {
+			return err
+		}
+
+		if !comments.PageInfo.HasNextPage || len(comments.Edges) == 0 {
+			return nil
+		}
+		cursor = comments.Edges[len(comments.Edges)-1].Cursor
+	}
+}
+
+// convertVODComment converts a replayed comment to our format. The send
+// time comes from the comment, or its offset into the VOD if it has none;
+// the receive time is set to it too, since the message was never received
+// live.
+func convertVODComment(comment vodComment, vod *VOD) message.Message {
+	sentAt := comment.CreatedAt
+	if sentAt.IsZero() {
+		sentAt = vod.CreatedAt.Add(time.Duration(comment.ContentOffsetSeconds * float64(time.Second)))
+	}
+
+	chatMessage := message.New("twitch", sentAt)
+	chatMessage.ReceivedAt = chatMessage.Timestamp
+	chatMessage.Channel = vod.Channel
+	chatMessage.ID = comment.ID
+	chatMessage.Username = comment.Commenter.DisplayName
+	if chatMessage.Username == "" {
+		chatMessage.Username = comment.Commenter.Login
+	}
+	chatMessage.UserID = comment.Commenter.ID
+
+	var badges []string
+	for _, badge := range comment.Message.UserBadges {
+		if badge.SetID != "" {
+			badges = append(badges, badge.SetID)
+		}
+	}
+	chatMessage.Badges = strings.Join(badges, ",")
+
+	var text strings.Builder
+	offset := 0 // Runes into the text
+	for _, fragment := range comment.Message.Fragments {
+		length := len([]rune(fragment.Text))
+		if fragment.Emote != nil {
+			chatMessage.Emotes = append(chatMessage.Emotes, fragment.Text)
+			chatMessage.EmoteRefs = append(chatMessage.EmoteRefs, message.EmoteRef{
+				ID:    strings.SplitN(fragment.Emote.EmoteID, ";", 2)[0],
+				Name:  fragment.Text,
+				Start: offset,
+				End:   offset + length,
+			})
+		}
+		text.WriteString(fragment.Text)
+		offset += length
+	}
+	chatMessage.Message = text.String()
+
+	return chatMessage
+}
+
+// query posts a GQL request and decodes the response into v
+func (v *VODClient) query(ctx context.Context, body any, result any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gqlURL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Client-Id", v.clientID)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(raw))
+	}
+
+	// GQL reports errors in the body with a 200 status
+	var gqlErrors struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(raw, &gqlErrors); err == nil && len(gqlErrors.Errors) > 0 {
+		return fmt.Errorf("API returned error: %s", gqlErrors.Errors[0].Message)
+	}
+
+	if err := json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("JSON decode failed: %w", err)
+	}
+	return nil
+}
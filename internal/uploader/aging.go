@@ -0,0 +1,114 @@
+package uploader
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/john/chatlog/internal/status"
+)
+
+// deadLetterCheckInterval is how often the dead-letter directory is checked
+// for files due a retry
+const deadLetterCheckInterval = time.Minute
+
+// EnableDeadLetterRetry retries dead-lettered files automatically, first
+// when Start is called, so after every restart. A file that has failed n
+// upload runs is retried base*2^(n-1) after its last failure, but at most
+// max after it, and its count is kept in its failure record across
+// restarts. Files still failing maxAge after their first failure are
+// logged as errors and degrade the uploader's status. It must be called
+// before Start.
+func (u *Uploader) EnableDeadLetterRetry(base, max, maxAge time.Duration) {
+	u.agingBase = base
+	u.agingMax = max
+	u.maxFailureAge = maxAge
+}
+
+// retryDelay returns how long after its last failure a file that has
+// failed attempts upload runs is retried
+func (u *Uploader) retryDelay(attempts int) time.Duration {
+	delay := u.agingBase
+	for i := 1; i < attempts && delay < u.agingMax; i++ {
+		delay *= 2
+	}
+	return min(delay, u.agingMax)
+}
+
+// retryDeadLetters retries dead-lettered files as they come due, until ctx
+// is done
+func (u *Uploader) retryDeadLetters(ctx context.Context) {
+	alerted := make(map[string]bool) // Files already reported as over the maximum age
+	ticker := time.NewTicker(deadLetterCheckInterval)
+	defer ticker.Stop()
+	for {
+		u.retryDueDeadLetters(ctx, alerted)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// retryDueDeadLetters retries the dead-lettered files whose delay has
+// passed, then reports how many are left and how many are over the
+// maximum age
+func (u *Uploader) retryDueDeadLetters(ctx context.Context, alerted map[string]bool) {
+	u.retryMu.Lock()
+	defer u.retryMu.Unlock()
+
+	letters, err := u.deadLetters()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+
+	var left, overAge int
+	var oldest time.Time
+	for _, letter := range letters {
+		if ctx.Err() != nil {
+			return
+		}
+		record := letter.record
+		if record.FailedAt.IsZero() || time.Since(record.FailedAt) >= u.retryDelay(record.Attempts) {
+			log.Printf("Retrying dead-lettered %s (failed %d time(s) so far)", filepath.Base(letter.path), record.Attempts)
+			if err := u.retryDeadLetter(ctx, letter.path); err == nil {
+				delete(alerted, letter.path)
+				continue
+			}
+			record, _ = readFailureRecord(letter.path + failureExt)
+		}
+
+		left++
+		first := record.firstFailure()
+		if first.IsZero() {
+			continue
+		}
+		if oldest.IsZero() || first.Before(oldest) {
+			oldest = first
+		}
+		if age := time.Since(first); u.maxFailureAge > 0 && age > u.maxFailureAge {
+			overAge++
+			if !alerted[letter.path] {
+				alerted[letter.path] = true
+				log.Printf("Error: %s has failed to upload %d time(s) over %s, past the maximum age (last error: %s)",
+					filepath.Base(letter.path), record.Attempts, age.Round(time.Minute), record.Error)
+			}
+		}
+	}
+
+	u.status.Set("dead_letters", left)
+	u.status.Set("dead_letters_over_max_age", overAge)
+	if oldest.IsZero() {
+		u.status.Set("oldest_failure", nil)
+	} else {
+		u.status.Set("oldest_failure", oldest.UTC())
+	}
+	if overAge > 0 {
+		u.status.SetState(status.StateDegraded)
+	} else {
+		u.status.SetState(status.StateRunning)
+	}
+}
@@ -0,0 +1,246 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/john/chatlog/internal/recorder"
+)
+
+// failureExt is appended to a dead-lettered file's name for its failure record
+const failureExt = ".error.json"
+
+// quarantineDir is the subdirectory of the dead-letter directory for files
+// that can't be retried because where they belong can't be read from them
+const quarantineDir = "quarantine"
+
+// failureRecord describes why a dead-lettered file could not be uploaded
+type failureRecord struct {
+	OriginalPath  string    `json:"original_path"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"` // Upload runs that failed, each with its own retries
+	FailedAt      time.Time `json:"failed_at"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+}
+
+// firstFailure returns when the file first failed; records written before
+// first_failed_at was kept only have the latest failure
+func (r failureRecord) firstFailure() time.Time {
+	if r.FirstFailedAt.IsZero() {
+		return r.FailedAt
+	}
+	return r.FirstFailedAt
+}
+
+// EnableDeadLetter moves files that still fail after all retries into dir,
+// next to a .error.json record of the failure, instead of leaving them for
+// the next restart's scan. It must be called before Start.
+func (u *Uploader) EnableDeadLetter(dir string) {
+	u.deadLetterDir = dir
+}
+
+// deadLetter moves a permanently failed file into the dead-letter directory
+func (u *Uploader) deadLetter(info recorder.FileInfo, uploadErr error) {
+	if u.deadLetterDir == "" {
+		return
+	}
+
+	u.deadLetterMu.Lock()
+	defer u.deadLetterMu.Unlock()
+
+	if err := os.MkdirAll(u.deadLetterDir, 0755); err != nil {
+		log.Printf("Error creating dead-letter directory: %v", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	record := failureRecord{OriginalPath: info.Path, Attempts: 1, FirstFailedAt: now}
+	dest := filepath.Join(u.deadLetterDir, info.Filename())
+	if info.Path == dest {
+		// Retried from the dead-letter directory; keep the original location
+		// and the count of failures so far
+		if previous, err := readFailureRecord(dest + failureExt); err == nil {
+			record.OriginalPath = previous.OriginalPath
+			record.Attempts = previous.Attempts + 1
+			record.FirstFailedAt = previous.firstFailure()
+		}
+	} else if err := os.Rename(info.Path, dest); err != nil {
+		log.Printf("Error moving %s to dead-letter directory: %v", info.Filename(), err)
+		return
+	} else {
+		u.pruneDirs(info.Path)
+	}
+
+	record.Error = uploadErr.Error()
+	record.FailedAt = now
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err == nil {
+		err = os.WriteFile(dest+failureExt, data, 0644)
+	}
+	if err != nil {
+		log.Printf("Error writing failure record for %s: %v", info.Filename(), err)
+	}
+
+	log.Printf("Moved %s to dead-letter directory %s", info.Filename(), u.deadLetterDir)
+}
+
+// readFailureRecord reads a .error.json file
+func readFailureRecord(path string) (failureRecord, error) {
+	var record failureRecord
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return record, err
+	}
+	return record, json.Unmarshal(data, &record)
+}
+
+// RetryFailed uploads every file in the dead-letter directory. Files that
+// upload are removed from it (or moved back to where they were recorded, if
+// local files are kept); files that fail again stay with an updated record.
+func (u *Uploader) RetryFailed(ctx context.Context) (retried, failed int, err error) {
+	if u.deadLetterDir == "" {
+		return 0, 0, fmt.Errorf("no dead-letter directory configured")
+	}
+	u.retryMu.Lock()
+	defer u.retryMu.Unlock()
+
+	letters, err := u.deadLetters()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, letter := range letters {
+		if err := u.retryDeadLetter(ctx, letter.path); err != nil {
+			failed++
+			continue
+		}
+		retried++
+	}
+	return retried, failed, nil
+}
+
+// deadLetterFile is a file in the dead-letter directory and its failure record
+type deadLetterFile struct {
+	path   string
+	record failureRecord
+}
+
+// deadLetters lists the files in the dead-letter directory. A file without
+// a readable record gets an empty one.
+func (u *Uploader) deadLetters() ([]deadLetterFile, error) {
+	entries, err := os.ReadDir(u.deadLetterDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read dead-letter directory: %w", err)
+	}
+
+	var letters []deadLetterFile
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), failureExt) {
+			continue
+		}
+		path := filepath.Join(u.deadLetterDir, entry.Name())
+		record, _ := readFailureRecord(path + failureExt)
+		letters = append(letters, deadLetterFile{path: path, record: record})
+	}
+	return letters, nil
+}
+
+// retryDeadLetter uploads one dead-lettered file, removing it from the
+// dead-letter directory if it uploads and updating its record if not. A
+// file whose platform, channel or time can't be read is quarantined rather
+// than retried forever. Uploads cut short by ctx are not counted as
+// failures.
+func (u *Uploader) retryDeadLetter(ctx context.Context, path string) error {
+	name := filepath.Base(path)
+	info, err := recorder.ParseFileInfo(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			u.quarantine(path, err)
+		}
+		return err
+	}
+	if err = u.Upload(ctx, info); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Retry of %s failed: %v", name, err)
+			u.deadLetter(info, err)
+		}
+		return err
+	}
+
+	u.deadLetterMu.Lock()
+	defer u.deadLetterMu.Unlock()
+	record, _ := readFailureRecord(path + failureExt)
+	os.Remove(path + failureExt)
+	if _, err := os.Stat(path); err == nil && record.OriginalPath != "" {
+		// Local files are kept after upload; put it back where it came from
+		if err := os.Rename(path, record.OriginalPath); err != nil {
+			log.Printf("Error moving %s back to %s: %v", name, record.OriginalPath, err)
+		}
+	}
+	return nil
+}
+
+// quarantine moves a dead-lettered file that can't be parsed, with its
+// failure record, into the quarantine directory, where retries skip it
+func (u *Uploader) quarantine(path string, parseErr error) {
+	u.deadLetterMu.Lock()
+	defer u.deadLetterMu.Unlock()
+
+	dir := filepath.Join(u.deadLetterDir, quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Error creating quarantine directory: %v", err)
+		return
+	}
+	dest := filepath.Join(dir, filepath.Base(path))
+	if err := os.Rename(path, dest); err != nil {
+		log.Printf("Error moving %s to quarantine: %v", filepath.Base(path), err)
+		return
+	}
+
+	now := time.Now().UTC()
+	record, err := readFailureRecord(path + failureExt)
+	if err != nil {
+		record = failureRecord{OriginalPath: path, FirstFailedAt: now}
+	}
+	os.Remove(path + failureExt)
+	record.Error = parseErr.Error()
+	record.Attempts++
+	record.FailedAt = now
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err == nil {
+		err = os.WriteFile(dest+failureExt, data, 0644)
+	}
+	if err != nil {
+		log.Printf("Error writing failure record for %s: %v", filepath.Base(path), err)
+	}
+
+	log.Printf("Error: Moved %s to %s, since it can't be retried: %v", filepath.Base(path), dir, parseErr)
+}
+
+// RetryHandler serves POST requests that retry all dead-lettered files
+func (u *Uploader) RetryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		retried, failed, err := u.RetryFailed(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"retried": retried, "failed": failed})
+	})
+}
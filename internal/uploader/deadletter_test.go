@@ -0,0 +1,67 @@
+package uploader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// memoryStore keeps uploaded files by key
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memoryStore) put(ctx context.Context, localPath, key string) (string, error) {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	return key, nil
+}
+
+func (m *memoryStore) describe(key string) string { return "memory:" + key }
+
+func TestRetryQuarantinesUnparseableFiles(t *testing.T) {
+	dir := t.TempDir()
+	u := &Uploader{store: &memoryStore{objects: make(map[string][]byte)}}
+	u.EnableDeadLetter(dir)
+
+	path := filepath.Join(dir, "garbage.jsonl")
+	if err := os.WriteFile(path, []byte("not a record\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+failureExt, []byte(`{"original_path":"/out/garbage.jsonl","attempts":2}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	retried, failed, err := u.RetryFailed(context.Background())
+	if err != nil || retried != 0 || failed != 1 {
+		t.Fatalf("first retry = %d retried, %d failed, %v", retried, failed, err)
+	}
+	quarantined := filepath.Join(dir, quarantineDir, "garbage.jsonl")
+	if _, err := os.Stat(quarantined); err != nil {
+		t.Fatalf("file was not quarantined: %v", err)
+	}
+	record, err := readFailureRecord(quarantined + failureExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.OriginalPath != "/out/garbage.jsonl" || record.Attempts != 3 || record.Error == "" {
+		t.Errorf("quarantined record = %+v", record)
+	}
+	if _, err := os.Stat(path + failureExt); !os.IsNotExist(err) {
+		t.Error("the failure record was left behind")
+	}
+
+	// Later retries leave it alone
+	retried, failed, err = u.RetryFailed(context.Background())
+	if err != nil || retried != 0 || failed != 0 {
+		t.Errorf("second retry = %d retried, %d failed, %v", retried, failed, err)
+	}
+}
@@ -0,0 +1,41 @@
+package uploader
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// AddDestination registers a bucket, reached with its own client and
+// object settings, that channels can be routed to by EnableDestinations.
+// It must be called before Start.
+func (u *Uploader) AddDestination(name string, client *s3.Client, bucket string, objectOpts ObjectOptions) {
+	if u.destinations == nil {
+		u.destinations = make(map[string]*s3Store)
+	}
+	u.destinations[name] = &s3Store{s3Client: client, bucket: bucket, objectOpts: objectOpts, schedule: u.schedule, hedge: u.hedge}
+}
+
+// EnableDestinations uploads each channel's files to the destination named
+// by route, or to the uploader's own store if route returns "". A name with
+// no destination fails the upload rather than falling back, so a channel's
+// files never land in another bucket. It must be called before Start.
+func (u *Uploader) EnableDestinations(route func(platform, channel string) string) {
+	u.route = route
+}
+
+// storeFor returns the store a channel's files are uploaded to
+func (u *Uploader) storeFor(platform, channel string) (objectStore, error) {
+	if u.route == nil {
+		return u.store, nil
+	}
+	name := u.route(platform, channel)
+	if name == "" {
+		return u.store, nil
+	}
+	st, ok := u.destinations[name]
+	if !ok {
+		return nil, fmt.Errorf("no upload destination %q", name)
+	}
+	return st, nil
+}
@@ -0,0 +1,148 @@
+package uploader
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// hedgeWindow is how many recent upload times the hedge delay is taken from
+	hedgeWindow = 256
+
+	// hedgeMinSamples is how many uploads are timed before any is hedged
+	hedgeMinSamples = 20
+)
+
+// Hedge starts a second attempt of an S3 PUT that runs past a percentile
+// of recent upload times, and takes whichever attempt finishes first. Both
+// write the same key with If-None-Match, so at most one creates the object
+// and the other is cancelled or rejected.
+type Hedge struct {
+	percentile float64
+	min        time.Duration
+
+	samples []time.Duration // Ring of recent upload times
+	next    int
+	mu      sync.Mutex
+
+	hedged atomic.Int64 // Uploads that started a second attempt
+	won    atomic.Int64 // Of those, uploads the second attempt finished first
+}
+
+// NewHedge creates a hedge that starts a second attempt once an upload has
+// run past percentile (0-100) of recent upload times, but never sooner
+// than min
+func NewHedge(percentile float64, min time.Duration) *Hedge {
+	return &Hedge{percentile: percentile, min: min}
+}
+
+// EnableHedging hedges S3 uploads, including those to destinations, with
+// h. Hedged attempts count against the schedule's rate limit like any
+// other. It must be called before Start.
+func (u *Uploader) EnableHedging(h *Hedge) {
+	u.hedge = h
+	if st, ok := u.store.(*s3Store); ok {
+		st.hedge = h
+	}
+	for _, st := range u.destinations {
+		st.hedge = h
+	}
+}
+
+// delay returns how long an upload runs before it is hedged, or false
+// until enough uploads have been timed to tell
+func (h *Hedge) delay() (time.Duration, bool) {
+	h.mu.Lock()
+	sorted := slices.Clone(h.samples)
+	h.mu.Unlock()
+	if len(sorted) < hedgeMinSamples {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	i := min(int(float64(len(sorted))*h.percentile/100), len(sorted)-1)
+	return max(sorted[i], h.min), true
+}
+
+// observe records how long an upload took
+func (h *Hedge) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < hedgeWindow {
+		h.samples = append(h.samples, d)
+		return
+	}
+	h.samples[h.next] = d
+	h.next = (h.next + 1) % hedgeWindow
+}
+
+// putObjectHedged runs putObject, starting a second attempt if the first
+// is still running after the hedge delay. The first attempt to succeed
+// wins and the other is cancelled. If both fail, a PreconditionFailed
+// error is returned in preference, since it means an attempt created the
+// object.
+func (st *s3Store) putObjectHedged(ctx context.Context, localPath, key string, sum []byte) error {
+	if st.hedge == nil {
+		return st.putObject(ctx, localPath, key, sum)
+	}
+	h := st.hedge
+	start := time.Now()
+	delay, ok := h.delay()
+	if !ok {
+		err := st.putObject(ctx, localPath, key, sum)
+		if err == nil {
+			h.observe(time.Since(start))
+		}
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		err    error
+		hedged bool
+	}
+	results := make(chan result, 2)
+	attempt := func(hedged bool) {
+		results <- result{st.putObject(ctx, localPath, key, sum), hedged}
+	}
+	go attempt(false)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	running := 1
+	var errs []error
+	for {
+		select {
+		case <-timer.C:
+			log.Printf("Upload to %s still running after %v, starting a hedged attempt", st.describe(key), delay.Round(time.Millisecond))
+			h.hedged.Add(1)
+			running++
+			go attempt(true)
+
+		case r := <-results:
+			running--
+			if r.err == nil {
+				h.observe(time.Since(start))
+				if r.hedged {
+					h.won.Add(1)
+				}
+				return nil
+			}
+			errs = append(errs, r.err)
+			if running > 0 {
+				continue // The other attempt may still succeed
+			}
+			for _, err := range errs {
+				if apiErrorCode(err) == "PreconditionFailed" {
+					return err
+				}
+			}
+			return errs[0]
+		}
+	}
+}
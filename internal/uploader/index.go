@@ -0,0 +1,139 @@
+package uploader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// indexEntry records a local file as it was when it was uploaded
+type indexEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // Unix nanoseconds
+	Key     string `json:"key"`
+}
+
+// uploadIndex is an append-only record of uploaded files that are kept
+// locally, so startup scans skip them instead of hashing and checking each
+// against the bucket again. Each entry is synced as it is appended; a
+// partial last line from a crash is ignored, and that file is checked
+// against the bucket as before.
+type uploadIndex struct {
+	path    string
+	file    *os.File
+	entries map[string]indexEntry // key: local path
+	mu      sync.Mutex
+}
+
+// EnableUploadIndex records files uploaded and kept (without
+// delete_after_upload) in the index at path, and skips them in
+// ScanAndUploadExisting while they are unchanged. Entries for files no
+// longer on disk are dropped when it loads. It must be called before
+// Start.
+func (u *Uploader) EnableUploadIndex(path string) error {
+	index := &uploadIndex{path: path, entries: make(map[string]indexEntry)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read upload index: %w", err)
+	}
+	lines, stale := 0, 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var entry indexEntry
+		if len(line) == 0 || json.Unmarshal(line, &entry) != nil {
+			continue
+		}
+		lines++
+		if _, err := os.Stat(entry.Path); err != nil {
+			stale++
+			continue
+		}
+		index.entries[entry.Path] = entry
+	}
+
+	// Rewrite the index without stale or superseded entries, or a partial
+	// last line the next entry would be appended to
+	if len(index.entries) < lines || (len(data) > 0 && data[len(data)-1] != '\n') {
+		if err := index.rewrite(); err != nil {
+			return err
+		}
+	}
+	if stale > 0 {
+		log.Printf("Dropped %d upload index entries for files no longer on disk", stale)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create upload index directory: %w", err)
+	}
+	index.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open upload index: %w", err)
+	}
+	u.index = index
+	return nil
+}
+
+// rewrite replaces the index file with the loaded entries
+func (x *uploadIndex) rewrite() error {
+	var buf bytes.Buffer
+	for _, entry := range x.entries {
+		line, _ := json.Marshal(entry)
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	tmp := x.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err == nil {
+		_, err = file.Write(buf.Bytes())
+		if err == nil {
+			err = file.Sync()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, x.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("rewrite upload index: %w", err)
+	}
+	return nil
+}
+
+// add records that the file at path was uploaded to key
+func (x *uploadIndex) add(path, key string) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	entry := indexEntry{Path: path, Size: stat.Size(), ModTime: stat.ModTime().UnixNano(), Key: key}
+	line, _ := json.Marshal(entry)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.entries[path] = entry
+	_, err = x.file.Write(append(line, '\n'))
+	if err == nil {
+		err = x.file.Sync()
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to record %s in the upload index: %v", filepath.Base(path), err)
+	}
+}
+
+// uploaded reports whether a file is in the index and unchanged since it
+// was uploaded
+func (x *uploadIndex) uploaded(path string, stat os.FileInfo) bool {
+	x.mu.Lock()
+	entry, ok := x.entries[path]
+	x.mu.Unlock()
+	return ok && entry.Size == stat.Size() && entry.ModTime == stat.ModTime().UnixNano()
+}
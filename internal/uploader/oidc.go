@@ -0,0 +1,261 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of the OIDC token exchanged for role credentials
+const (
+	TokenSourceFly        = "fly"        // Fly.io machine API socket
+	TokenSourceFile       = "file"       // A token file, re-read for each exchange
+	TokenSourceKubernetes = "kubernetes" // A projected service account token file
+	TokenSourceGCP        = "gcp"        // The GCP metadata server's identity token
+	TokenSourceHTTP       = "http"       // The body of a GET request
+)
+
+// DefaultTokenAudience is the audience AWS STS expects
+const DefaultTokenAudience = "sts.amazonaws.com"
+
+// DefaultKubernetesTokenFile is where EKS projects the service account token
+// for IAM roles for service accounts
+const DefaultKubernetesTokenFile = "/var/run/secrets/eks.amazonaws.com/serviceaccount/token"
+
+// tokenFetchTimeout bounds each token request
+const tokenFetchTimeout = 5 * time.Second
+
+// TokenSource fetches the OIDC token used to assume a role. It satisfies
+// stscreds.IdentityTokenRetriever.
+type TokenSource interface {
+	GetIdentityToken() ([]byte, error)
+}
+
+// TokenSourceOptions select and configure a TokenSource
+type TokenSourceOptions struct {
+	Source   string            // One of the TokenSource* constants; empty picks DefaultTokenSource
+	File     string            // Token file for file and kubernetes sources
+	URL      string            // Endpoint for the http source
+	Headers  map[string]string // Request headers for the http source
+	Audience string            // Token audience for fly and gcp; default DefaultTokenAudience
+}
+
+// tokenSource is used by LoadAWSConfig; it is replaced by SetTokenSource
+var (
+	tokenSource   = DefaultTokenSource()
+	tokenSourceMu sync.Mutex
+)
+
+// DefaultTokenSource reads AWS_WEB_IDENTITY_TOKEN_FILE if it is set, as
+// the AWS SDKs do, and asks the Fly.io machine API otherwise
+func DefaultTokenSource() TokenSource {
+	if path := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); path != "" {
+		return fileTokenSource{path: path}
+	}
+	return flyTokenSource{socketPath: "/.fly/api", audience: DefaultTokenAudience}
+}
+
+// NewTokenSource creates the token source opts describe
+func NewTokenSource(opts TokenSourceOptions) (TokenSource, error) {
+	audience := opts.Audience
+	if audience == "" {
+		audience = DefaultTokenAudience
+	}
+
+	switch opts.Source {
+	case "":
+		return DefaultTokenSource(), nil
+	case TokenSourceFly:
+		return flyTokenSource{socketPath: "/.fly/api", audience: audience}, nil
+	case TokenSourceFile, TokenSourceKubernetes:
+		path := opts.File
+		if path == "" {
+			path = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+		}
+		if path == "" && opts.Source == TokenSourceKubernetes {
+			path = DefaultKubernetesTokenFile
+		}
+		if path == "" {
+			return nil, fmt.Errorf("the %s token source needs a token file", opts.Source)
+		}
+		return fileTokenSource{path: path}, nil
+	case TokenSourceGCP:
+		return gcpTokenSource{audience: audience}, nil
+	case TokenSourceHTTP:
+		if _, err := url.ParseRequestURI(opts.URL); err != nil {
+			return nil, fmt.Errorf("the http token source needs a URL: %w", err)
+		}
+		return httpTokenSource{url: opts.URL, headers: opts.Headers}, nil
+	default:
+		return nil, fmt.Errorf("unknown token source %q", opts.Source)
+	}
+}
+
+// SetTokenSource makes AWS configurations loaded from now on use src for
+// role authentication
+func SetTokenSource(src TokenSource) {
+	tokenSourceMu.Lock()
+	tokenSource = src
+	tokenSourceMu.Unlock()
+}
+
+// currentTokenSource returns the token source set by SetTokenSource
+func currentTokenSource() TokenSource {
+	tokenSourceMu.Lock()
+	defer tokenSourceMu.Unlock()
+	return tokenSource
+}
+
+// FetchOIDCToken fetches an OIDC token the way role authentication does
+func FetchOIDCToken() ([]byte, error) {
+	return currentTokenSource().GetIdentityToken()
+}
+
+// flyTokenSource fetches tokens from Fly.io's machine API Unix socket
+type flyTokenSource struct {
+	socketPath string
+	audience   string
+}
+
+// GetIdentityToken fetches an OIDC token from Fly.io's Unix socket API
+func (f flyTokenSource) GetIdentityToken() ([]byte, error) {
+	// Create HTTP client with Unix socket transport
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", f.socketPath)
+			},
+		},
+		Timeout: tokenFetchTimeout,
+	}
+
+	// Prepare request body
+	reqBody, err := json.Marshal(map[string]string{
+		"aud": f.audience,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	// Make POST request to Fly.io API
+	resp, err := client.Post("http://localhost/v1/tokens/oidc", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("request token: %w", err)
+	}
+	return readToken(resp)
+}
+
+// fileTokenSource reads a token file. The file is read for every exchange,
+// since projected tokens are rotated in place.
+type fileTokenSource struct {
+	path string
+}
+
+// GetIdentityToken reads the token file
+func (f fileTokenSource) GetIdentityToken() ([]byte, error) {
+	token, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("read token file: %w", err)
+	}
+	token = bytes.TrimSpace(token)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("token file %s is empty", f.path)
+	}
+	return token, nil
+}
+
+// gcpTokenSource fetches identity tokens from the GCP metadata server, for
+// workloads on GCE, GKE and Cloud Run
+type gcpTokenSource struct {
+	audience string
+}
+
+// GetIdentityToken fetches an identity token for the default service account
+func (g gcpTokenSource) GetIdentityToken() ([]byte, error) {
+	endpoint := "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/identity?" +
+		url.Values{"audience": {g.audience}, "format": {"full"}}.Encode()
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	client := &http.Client{Timeout: tokenFetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request token: %w", err)
+	}
+	return readToken(resp)
+}
+
+// httpTokenSource fetches tokens with a GET request, e.g. from a sidecar or
+// the GitHub Actions token endpoint. The body is the token, or a JSON object
+// holding it as token, id_token or value.
+type httpTokenSource struct {
+	url     string
+	headers map[string]string
+}
+
+// GetIdentityToken fetches a token from the endpoint
+func (h httpTokenSource) GetIdentityToken() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range h.headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: tokenFetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request token: %w", err)
+	}
+	token, err := readToken(resp)
+	if err != nil || token[0] != '{' {
+		return token, err
+	}
+
+	var body struct {
+		Token   string `json:"token"`
+		IDToken string `json:"id_token"`
+		Value   string `json:"value"`
+	}
+	if err := json.Unmarshal(token, &body); err != nil {
+		return nil, fmt.Errorf("parse token response: %w", err)
+	}
+	for _, t := range []string{body.Token, body.IDToken, body.Value} {
+		if t != "" {
+			return []byte(t), nil
+		}
+	}
+	return nil, fmt.Errorf("token response has no token, id_token or value field")
+}
+
+// readToken reads a token response body, failing on an error status or an
+// empty token
+func readToken(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("read token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	token := bytes.TrimSpace(body)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("token response is empty")
+	}
+	return token, nil
+}
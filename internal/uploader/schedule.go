@@ -0,0 +1,181 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most read from a file at once when throttled, so
+// concurrent uploads share the rate fairly
+const throttleChunk = 32 * 1024
+
+// Window is a daily period in which uploads are throttled or paused
+type Window struct {
+	Start, End     time.Duration  // Offsets from local midnight; End before Start wraps past midnight
+	Days           []time.Weekday // Days the window starts on; empty means every day
+	BytesPerSecond int64          // Shared rate limit for all uploads in the window
+	Pause          bool           // Start no uploads in the window
+}
+
+// contains reports whether local time t falls in the window
+func (w Window) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	onDay := func(day time.Weekday) bool {
+		return len(w.Days) == 0 || slices.Contains(w.Days, day)
+	}
+
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End && onDay(t.Weekday())
+	}
+	if offset >= w.Start {
+		return onDay(t.Weekday())
+	}
+	return offset < w.End && onDay(t.AddDate(0, 0, -1).Weekday())
+}
+
+// Schedule limits upload bandwidth by time of day. Outside its windows
+// uploads run at full speed. Files waiting for a paused window stay on disk
+// and in the upload queue.
+type Schedule struct {
+	windows  []Window
+	location *time.Location
+
+	next time.Time // When the shared rate next allows bytes to be sent
+	mu   sync.Mutex
+}
+
+// NewSchedule creates a schedule from windows in loc; the first window
+// containing the current time applies
+func NewSchedule(windows []Window, loc *time.Location) *Schedule {
+	return &Schedule{windows: windows, location: loc}
+}
+
+// current returns the window in effect at t, if any
+func (s *Schedule) current(t time.Time) (Window, bool) {
+	t = t.In(s.location)
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+// describeWindow returns a window's effect for status reports
+func describeWindow(w Window, ok bool) string {
+	switch {
+	case !ok:
+		return "unlimited"
+	case w.Pause:
+		return "paused"
+	default:
+		return fmt.Sprintf("%d bytes/s", w.BytesPerSecond)
+	}
+}
+
+// waitForWindow blocks while the schedule's current window pauses uploads
+func (u *Uploader) waitForWindow(ctx context.Context, filename string) error {
+	if u.schedule == nil {
+		return nil
+	}
+	logged := false
+	for {
+		w, ok := u.schedule.current(time.Now())
+		u.status.Set("upload_window", describeWindow(w, ok))
+		if !ok || !w.Pause {
+			return nil
+		}
+		if !logged {
+			log.Printf("Upload of %s waiting for the paused upload window to end", filename)
+			logged = true
+		}
+		select {
+		case <-time.After(time.Minute):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// wait blocks until n more bytes may be sent under the current window's
+// rate. Uploads already running when a paused window begins finish
+// unthrottled.
+func (s *Schedule) wait(ctx context.Context, n int) error {
+	w, ok := s.current(time.Now())
+	if !ok || w.Pause || w.BytesPerSecond <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	start := s.next
+	if start.Before(now) {
+		start = now
+	}
+	s.next = start.Add(time.Duration(float64(n) / float64(w.BytesPerSecond) * float64(time.Second)))
+	s.mu.Unlock()
+
+	if delay := time.Until(start); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// reader wraps r so reads are paced by the schedule. Seeks pass through,
+// so S3 uploads can rewind the body for retries.
+func (s *Schedule) reader(ctx context.Context, r io.ReadSeeker) io.ReadSeeker {
+	if s == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, schedule: s}
+}
+
+// throttledReader paces reads from a file through a Schedule
+type throttledReader struct {
+	ctx      context.Context
+	r        io.ReadSeeker
+	schedule *Schedule
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.schedule.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (t *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	return t.r.Seek(offset, whence)
+}
+
+// EnableSchedule throttles or pauses uploads by time of day. It must be
+// called before Start.
+func (u *Uploader) EnableSchedule(s *Schedule) {
+	u.schedule = s
+	switch st := u.store.(type) {
+	case *s3Store:
+		st.schedule = s
+	case localStore:
+		st.schedule = s
+		u.store = st
+	}
+	for _, st := range u.destinations {
+		st.schedule = s
+	}
+}
@@ -3,15 +3,22 @@ package uploader
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
-	"net"
-	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -19,72 +26,174 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/john/chatlog/internal/notify"
+	"github.com/john/chatlog/internal/recorder"
+	"github.com/john/chatlog/internal/retry"
+	"github.com/john/chatlog/internal/search"
+	"github.com/john/chatlog/internal/stats"
+	"github.com/john/chatlog/internal/status"
 )
 
+// DefaultKeyTemplate produces keys like 2025/12/30/twitch/ludwig/twitch_ludwig_20251230_1030.jsonl
+const DefaultKeyTemplate = `{{strftime .Time "%Y/%m/%d"}}/{{.Platform}}/{{.Channel}}/{{.Filename}}`
+
 // Uploader handles uploading completed log files to S3
 type Uploader struct {
-	s3Client     *s3.Client
-	bucket       string
-	deleteAfter  bool
-	maxRetries   int
+	store       objectStore
+	deleteAfter bool
+	maxRetries  int
+	retry       retry.Policy // Delays between attempts; the zero policy doubles from 1s
+	keyTemplate *template.Template
+
+	deadLetterDir string
+	deadLetterMu  sync.Mutex
+	index         *uploadIndex // Uploaded files kept locally; nil if disabled
+	pruneRoot     string       // Output directory whose emptied subdirectories are removed
+
+	retryMu       sync.Mutex    // Serializes retries of dead-lettered files
+	agingBase     time.Duration // First delay of automatic dead-letter retries; 0 disables them
+	agingMax      time.Duration
+	maxFailureAge time.Duration // How long a file may keep failing before it is reported
+
+	notifiers    []notify.Notifier
+	keyPrefix    func(platform, channel string) string
+	schedule     *Schedule
+	hedge        *Hedge                                // Hedges slow S3 uploads; nil if disabled
+	destinations map[string]*s3Store                   // Buckets channels can be routed to, by name
+	route        func(platform, channel string) string // Picks a channel's destination; nil sends everything to store
+
+	status   *status.Component
+	latency  *stats.Latency
+	pending  atomic.Int64 // Files queued or being uploaded
+	inflight sync.WaitGroup
+}
+
+// objectStore is a destination for completed files
+type objectStore interface {
+	// put stores the file at localPath under key and returns the key it
+	// was stored under, which differs if key already held other content
+	put(ctx context.Context, localPath, key string) (string, error)
+	// describe returns a human-readable location for key, for logging
+	describe(key string) string
 }
 
-// flyTokenRetriever implements stscreds.IdentityTokenRetriever for Fly.io OIDC
-type flyTokenRetriever struct {
-	socketPath string
-	audience   string
+// ObjectOptions holds settings applied to every uploaded object
+type ObjectOptions struct {
+	ServerSideEncryption string            // "AES256" (SSE-S3) or "aws:kms" (SSE-KMS); empty uses the bucket default
+	KMSKeyID             string            // KMS key ARN for SSE-KMS
+	StorageClass         string            // e.g. "STANDARD_IA", "GLACIER_IR"; empty uses STANDARD
+	Tags                 map[string]string // Object tags, e.g. for cost allocation
 }
 
-// GetIdentityToken fetches an OIDC token from Fly.io's Unix socket API
-func (f *flyTokenRetriever) GetIdentityToken() ([]byte, error) {
-	// Create HTTP client with Unix socket transport
-	client := &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
-				return net.Dial("unix", f.socketPath)
-			},
-		},
-		Timeout: 5 * time.Second,
+// New creates a new S3 uploader using OIDC authentication
+func New(ctx context.Context, bucket, region, roleARN string, deleteAfter bool, maxRetries int, objectOpts ObjectOptions, keyTemplate string, s3Opts ...func(*s3.Options)) (*Uploader, error) {
+	tmpl, err := ParseKeyTemplate(keyTemplate)
+	if err != nil {
+		return nil, err
 	}
 
-	// Prepare request body
-	reqBody, err := json.Marshal(map[string]string{
-		"aud": f.audience,
-	})
+	s3Client, err := NewS3Client(ctx, region, roleARN, "", "", s3Opts...)
 	if err != nil {
-		return nil, fmt.Errorf("marshal request: %w", err)
+		return nil, err
 	}
 
-	// Make POST request to Fly.io API
-	resp, err := client.Post("http://localhost/v1/tokens/oidc", "application/json", bytes.NewReader(reqBody))
+	return &Uploader{
+		store:       &s3Store{s3Client: s3Client, bucket: bucket, objectOpts: objectOpts},
+		deleteAfter: deleteAfter,
+		maxRetries:  maxRetries,
+		keyTemplate: tmpl,
+	}, nil
+}
+
+// NewWithStaticCredentials creates a new S3 uploader using static credentials (legacy)
+func NewWithStaticCredentials(ctx context.Context, bucket, region, accessKeyID, secretAccessKey string, deleteAfter bool, maxRetries int, objectOpts ObjectOptions, keyTemplate string, s3Opts ...func(*s3.Options)) (*Uploader, error) {
+	tmpl, err := ParseKeyTemplate(keyTemplate)
 	if err != nil {
-		return nil, fmt.Errorf("request token: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, string(body))
+	s3Client, err := NewS3Client(ctx, region, "", accessKeyID, secretAccessKey, s3Opts...)
+	if err != nil {
+		return nil, err
 	}
 
-	// Read and return token
-	token, err := io.ReadAll(resp.Body)
+	return &Uploader{
+		store:       &s3Store{s3Client: s3Client, bucket: bucket, objectOpts: objectOpts},
+		deleteAfter: deleteAfter,
+		maxRetries:  maxRetries,
+		keyTemplate: tmpl,
+	}, nil
+}
+
+// NewLocal creates an uploader that copies completed files into targetDir,
+// laid out by the key template, instead of uploading to S3. If targetDir is
+// empty, files are left where the recorder wrote them.
+func NewLocal(targetDir string, deleteAfter bool, keyTemplate string) (*Uploader, error) {
+	tmpl, err := ParseKeyTemplate(keyTemplate)
 	if err != nil {
-		return nil, fmt.Errorf("read token: %w", err)
+		return nil, err
+	}
+
+	if targetDir == "" {
+		return &Uploader{
+			store:       keepStore{},
+			keyTemplate: tmpl,
+		}, nil
 	}
 
-	return token, nil
+	return &Uploader{
+		store:       localStore{dir: targetDir},
+		deleteAfter: deleteAfter,
+		keyTemplate: tmpl,
+	}, nil
+}
+
+// NewS3Client creates an S3 client with credentials from LoadAWSConfig
+func NewS3Client(ctx context.Context, region, roleARN, accessKeyID, secretAccessKey string, optFns ...func(*s3.Options)) (*s3.Client, error) {
+	cfg, err := LoadAWSConfig(ctx, region, roleARN, accessKeyID, secretAccessKey)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, optFns...), nil
 }
 
-// New creates a new S3 uploader using OIDC authentication
-func New(ctx context.Context, bucket, region, roleARN string, deleteAfter bool, maxRetries int) (*Uploader, error) {
-	// Load default AWS config
-	cfg, err := config.LoadDefaultConfig(ctx,
+// WithEndpoint points an S3 client at an S3-compatible service, such as
+// MinIO or R2, with path-style addressing. An empty endpoint leaves the
+// client on AWS.
+func WithEndpoint(endpoint string) func(*s3.Options) {
+	return func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	}
+}
+
+// LoadAWSConfig loads the AWS configuration shared by the S3 client and
+// notifiers. If roleARN is set, OIDC authentication is used; otherwise the
+// static credentials are used if provided, falling back to the default AWS
+// credential chain.
+func LoadAWSConfig(ctx context.Context, region, roleARN, accessKeyID, secretAccessKey string) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
 		config.WithRegion(region),
-	)
+	}
+	if roleARN == "" && accessKeyID != "" {
+		// Create credentials provider
+		credProvider := credentials.NewStaticCredentialsProvider(
+			accessKeyID,
+			secretAccessKey,
+			"",
+		)
+		opts = append(opts, config.WithCredentialsProvider(credProvider))
+	}
+
+	// Load AWS config
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("load AWS config: %w", err)
+		return aws.Config{}, fmt.Errorf("load AWS config: %w", err)
 	}
 
 	// If roleARN is provided, assume role using OIDC credentials
@@ -92,85 +201,103 @@ func New(ctx context.Context, bucket, region, roleARN string, deleteAfter bool,
 		// Create STS client
 		stsClient := sts.NewFromConfig(cfg)
 
-		// Create Fly.io token retriever
-		tokenRetriever := &flyTokenRetriever{
-			socketPath: "/.fly/api",
-			audience:   "sts.amazonaws.com",
-		}
-
 		// Create credentials provider that assumes role with web identity
 		credProvider := stscreds.NewWebIdentityRoleProvider(
 			stsClient,
 			roleARN,
-			tokenRetriever,
+			currentTokenSource(),
 		)
 
 		// Update config with new credentials
 		cfg.Credentials = aws.NewCredentialsCache(credProvider)
 	}
 
-	// Create S3 client
-	s3Client := s3.NewFromConfig(cfg)
-
-	return &Uploader{
-		s3Client:    s3Client,
-		bucket:      bucket,
-		deleteAfter: deleteAfter,
-		maxRetries:  maxRetries,
-	}, nil
+	return cfg, nil
 }
 
-// NewWithStaticCredentials creates a new S3 uploader using static credentials (legacy)
-func NewWithStaticCredentials(ctx context.Context, bucket, region, accessKeyID, secretAccessKey string, deleteAfter bool, maxRetries int) (*Uploader, error) {
-	// Create credentials provider
-	credProvider := credentials.NewStaticCredentialsProvider(
-		accessKeyID,
-		secretAccessKey,
-		"",
-	)
-
-	// Load AWS config
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(region),
-		config.WithCredentialsProvider(credProvider),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("load AWS config: %w", err)
-	}
-
-	// Create S3 client
-	s3Client := s3.NewFromConfig(cfg)
+// DefaultScanPatterns match recorded files: plain, encrypted and seekable,
+// and emote statistics and search index sidecars
+var DefaultScanPatterns = []string{"*.jsonl", "*.jsonl" + recorder.EncryptedExt, "*.jsonl" + recorder.SeekableExt, "*" + recorder.EmoteStatsExt, "*" + search.Ext}
 
-	return &Uploader{
-		s3Client:    s3Client,
-		bucket:      bucket,
-		deleteAfter: deleteAfter,
-		maxRetries:  maxRetries,
-	}, nil
+// ScanOptions controls which files ScanAndUploadExisting picks up
+type ScanOptions struct {
+	Patterns  []string // File name globs to upload; empty means DefaultScanPatterns
+	Ignore    []string // File or directory name globs to skip, e.g. "*.tmp"
+	SkipDirs  []string // Directories under the output directory that hold other files (dead letters, overflow)
+	SkipFiles []string // Files still being recorded, e.g. reopened by the recorder after a restart
 }
 
-// ScanAndUploadExisting scans a directory for existing .jsonl files and uploads them
-func (u *Uploader) ScanAndUploadExisting(ctx context.Context, outputDir string) error {
+// ScanAndUploadExisting walks a directory tree for files left from earlier
+// runs and uploads them
+func (u *Uploader) ScanAndUploadExisting(ctx context.Context, outputDir string, opts ScanOptions) error {
 	log.Printf("Scanning %s for existing files to upload...", outputDir)
 
-	// Read directory
-	entries, err := os.ReadDir(outputDir)
-	if err != nil {
-		return fmt.Errorf("read directory: %w", err)
+	patterns := opts.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultScanPatterns
+	}
+	skipDirs := make(map[string]bool)
+	for _, dir := range opts.SkipDirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			skipDirs[abs] = true
+		}
+	}
+	skipFiles := make(map[string]bool)
+	for _, file := range opts.SkipFiles {
+		if abs, err := filepath.Abs(file); err == nil {
+			skipFiles[abs] = true
+		}
 	}
 
-	// Find all .jsonl files
-	var filesToUpload []string
-	for _, entry := range entries {
+	var filesToUpload []recorder.FileInfo
+	alreadyUploaded := 0
+	err := filepath.WalkDir(outputDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == outputDir {
+				return err
+			}
+			log.Printf("Warning: Skipping %s: %v", path, err)
+			return nil
+		}
+		name := entry.Name()
 		if entry.IsDir() {
-			continue
+			if path == outputDir {
+				return nil
+			}
+			abs, _ := filepath.Abs(path)
+			if skipDirs[abs] || matchAny(opts.Ignore, name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || matchAny(opts.Ignore, name) || !matchAny(patterns, name) {
+			return nil
+		}
+		if abs, _ := filepath.Abs(path); skipFiles[abs] {
+			return nil
+		}
+		if u.index != nil {
+			if stat, err := entry.Info(); err == nil {
+				if u.index.uploaded(path, stat) {
+					alreadyUploaded++
+					return nil
+				}
+			}
 		}
 
-		// Only process .jsonl files
-		if strings.HasSuffix(entry.Name(), ".jsonl") {
-			filePath := filepath.Join(outputDir, entry.Name())
-			filesToUpload = append(filesToUpload, filePath)
+		info, err := recorder.ParseFileInfo(path)
+		if err != nil {
+			log.Printf("Warning: Skipping %s: %v", path, err)
+			return nil
 		}
+		filesToUpload = append(filesToUpload, info)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("read directory: %w", err)
+	}
+	if alreadyUploaded > 0 {
+		log.Printf("Skipping %d file(s) the upload index shows as already uploaded", alreadyUploaded)
 	}
 
 	if len(filesToUpload) == 0 {
@@ -181,20 +308,153 @@ func (u *Uploader) ScanAndUploadExisting(ctx context.Context, outputDir string)
 	log.Printf("Found %d existing file(s) to upload", len(filesToUpload))
 
 	// Upload each file in a goroutine
-	for _, filePath := range filesToUpload {
-		go u.uploadWithRetry(ctx, filePath)
+	for _, info := range filesToUpload {
+		u.inflight.Add(1)
+		go u.uploadWithRetry(ctx, info)
 	}
 
 	return nil
 }
 
-// Start begins monitoring for files to upload
-func (u *Uploader) Start(ctx context.Context, fileChan <-chan string) error {
+// pruneDirs removes the directories a file left empty, if enabled
+func (u *Uploader) pruneDirs(path string) {
+	if u.pruneRoot != "" {
+		recorder.PruneDirs(u.pruneRoot, path)
+	}
+}
+
+// matchAny reports whether name matches any of the glob patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// EnableStatus reports the upload backlog and last success to comp. It must
+// be called before Start.
+func (u *Uploader) EnableStatus(comp *status.Component) {
+	u.status = comp
+	u.status.Set("pending", 0)
+}
+
+// EnableRetryPolicy sets the delays between upload attempts, which are
+// still limited to the constructor's maxRetries. It must be called before
+// Start.
+func (u *Uploader) EnableRetryPolicy(p retry.Policy) {
+	u.retry = p
+}
+
+// EnableDirPruning removes the nested layout directories under outputDir
+// that a deleted or dead-lettered file leaves empty. It must be called
+// before Start.
+func (u *Uploader) EnableDirPruning(outputDir string) {
+	u.pruneRoot = outputDir
+}
+
+// EnableLatency records each uploaded file's messages in l, timed from
+// their receipt. It must be called before Start.
+func (u *Uploader) EnableLatency(l *stats.Latency) {
+	u.latency = l
+}
+
+// EnableKeyPrefixes prepends keyPrefix(platform, channel) to each file's
+// rendered key, so groups of channels can be stored apart. It must be
+// called before Start.
+func (u *Uploader) EnableKeyPrefixes(keyPrefix func(platform, channel string) string) {
+	u.keyPrefix = keyPrefix
+}
+
+// EnableNotifications sends an event to each notifier after every
+// successful upload. It must be called before Start.
+func (u *Uploader) EnableNotifications(notifiers ...notify.Notifier) {
+	u.notifiers = append(u.notifiers, notifiers...)
+}
+
+// notifyAttempts is how many times a notification is tried before giving up
+const notifyAttempts = 3
+
+// notify tells every notifier that info was stored under key. Failures are
+// logged but don't fail the upload, since the file is already stored.
+func (u *Uploader) notify(ctx context.Context, store objectStore, info recorder.FileInfo, key string) {
+	if len(u.notifiers) == 0 {
+		return
+	}
+
+	event := notify.Event{
+		Key:          key,
+		Location:     store.describe(key),
+		Platform:     info.Platform,
+		Channel:      info.Channel,
+		StartTime:    info.StartTime.UTC(),
+		EndTime:      info.EndTime.UTC(),
+		MessageCount: info.MessageCount,
+		Bytes:        info.Bytes,
+	}
+	if st, ok := store.(*s3Store); ok {
+		event.Bucket = st.bucket
+	}
+
+	for _, n := range u.notifiers {
+		var err error
+		for attempt := 0; attempt < notifyAttempts; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-time.After(time.Duration(attempt) * time.Second):
+				case <-ctx.Done():
+				}
+			}
+			notifyCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+			err = n.Notify(notifyCtx, event)
+			cancel()
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("Warning: %s notification for %s failed: %v", n.Name(), info.Filename(), err)
+			u.status.Set("last_notify_error", err.Error())
+		}
+	}
+}
+
+// Pending returns the number of files queued or being uploaded
+func (u *Uploader) Pending() int64 {
+	return u.pending.Load()
+}
+
+// Start begins monitoring for files to upload. It returns when the context
+// is cancelled, or when fileChan is closed and all uploads have finished.
+func (u *Uploader) Start(ctx context.Context, fileChan <-chan recorder.FileInfo) error {
+	u.status.SetState(status.StateRunning)
+	defer u.status.SetState(status.StateStopped)
+
+	if u.agingBase > 0 && u.deadLetterDir != "" {
+		retryCtx, stop := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			u.retryDeadLetters(retryCtx)
+		}()
+		defer func() {
+			stop()
+			<-done
+		}()
+	}
+
 	for {
 		select {
-		case localPath := <-fileChan:
+		case info, ok := <-fileChan:
+			if !ok {
+				log.Println("Uploader waiting for in-flight uploads...")
+				u.inflight.Wait()
+				return nil
+			}
 			// Upload in a goroutine so we don't block
-			go u.uploadWithRetry(ctx, localPath)
+			u.inflight.Add(1)
+			go u.uploadWithRetry(ctx, info)
 
 		case <-ctx.Done():
 			log.Println("Uploader shutting down...")
@@ -203,100 +463,478 @@ func (u *Uploader) Start(ctx context.Context, fileChan <-chan string) error {
 	}
 }
 
-// uploadWithRetry uploads a file with retry logic
-func (u *Uploader) uploadWithRetry(ctx context.Context, localPath string) {
-	filename := filepath.Base(localPath)
+// uploadWithRetry uploads a file with retry logic. Files that still fail
+// are moved to the dead-letter directory, if enabled.
+func (u *Uploader) uploadWithRetry(ctx context.Context, info recorder.FileInfo) {
+	defer u.inflight.Done()
+	u.status.Set("pending", u.pending.Add(1))
+	defer func() { u.status.Set("pending", u.pending.Add(-1)) }()
+
+	if err := u.Upload(ctx, info); err != nil {
+		log.Printf("Error: %v", err)
+		u.status.Set("last_error", err.Error())
+		u.status.Set("last_error_at", time.Now().UTC())
+		if ctx.Err() == nil {
+			u.deadLetter(info, err)
+		}
+		return
+	}
+	u.status.Set("last_success", time.Now().UTC())
+	if u.hedge != nil {
+		u.status.Set("hedged_uploads", u.hedge.hedged.Load())
+		u.status.Set("hedges_won", u.hedge.won.Load())
+	}
+}
+
+// Upload uploads a single file, retrying with exponential backoff, and
+// deletes it afterwards if configured to
+func (u *Uploader) Upload(ctx context.Context, info recorder.FileInfo) error {
+	localPath := info.Path
+	filename := info.Filename()
 
-	s3Key, err := generateS3Key(filename)
+	s3Key, err := u.generateS3Key(info)
 	if err != nil {
-		log.Printf("Error generating S3 key for %s: %v", filename, err)
-		return
+		return fmt.Errorf("generate S3 key for %s: %w", filename, err)
+	}
+	store, err := u.storeFor(info.Platform, info.Channel)
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", filename, err)
 	}
 
+	storedKey, err := u.putWithRetry(ctx, store, localPath, s3Key, filename)
+	if err != nil {
+		return err
+	}
+	log.Printf("Successfully uploaded %s to %s (%d messages, %d bytes)",
+		filename, store.describe(storedKey), info.MessageCount, info.Bytes)
+	u.notify(ctx, store, info, storedKey)
+	u.uploaded(info, storedKey)
+	return nil
+}
+
+// putWithRetry stores localPath under key, retrying with exponential
+// backoff, and returns the key it was stored under. name identifies the
+// upload in logs and errors.
+func (u *Uploader) putWithRetry(ctx context.Context, store objectStore, localPath, key, name string) (string, error) {
+	var lastErr error
+	backoff := u.retry.Start()
 	for attempt := 0; attempt <= u.maxRetries; attempt++ {
-		err := u.uploadFile(ctx, localPath, s3Key)
+		if err := u.waitForWindow(ctx, name); err != nil {
+			return "", err
+		}
+		storedKey, err := store.put(ctx, localPath, key)
+		lastErr = err
 		if err == nil {
-			log.Printf("Successfully uploaded %s to s3://%s/%s", filename, u.bucket, s3Key)
-
-			// Delete local file if configured
-			if u.deleteAfter {
-				if err := os.Remove(localPath); err != nil {
-					log.Printf("Error deleting local file %s: %v", localPath, err)
-				} else {
-					log.Printf("Deleted local file %s", localPath)
-				}
-			}
-			return
+			return storedKey, nil
 		}
 
 		if attempt < u.maxRetries {
-			backoff := time.Duration(1<<uint(attempt)) * time.Second
+			delay, ok := backoff.Next()
+			if !ok {
+				return "", fmt.Errorf("failed to upload %s after %d attempts (retry time limit reached): %w", name, attempt+1, err)
+			}
 			log.Printf("Upload attempt %d/%d failed for %s: %v. Retrying in %v",
-				attempt+1, u.maxRetries, filename, err, backoff)
+				attempt+1, u.maxRetries, name, err, delay.Round(time.Millisecond))
 
-			select {
-			case <-time.After(backoff):
-			case <-ctx.Done():
-				return
+			if err := retry.Sleep(ctx, delay); err != nil {
+				return "", err
 			}
 		}
 	}
 
-	log.Printf("Failed to upload %s after %d attempts", filename, u.maxRetries)
+	return "", fmt.Errorf("failed to upload %s after %d attempts: %w", name, u.maxRetries+1, lastErr)
+}
+
+// uploaded records a file stored under key: its latency, and the upload
+// index entry or deletion of the local file
+func (u *Uploader) uploaded(info recorder.FileInfo, key string) {
+	if u.latency != nil {
+		u.latency.ObserveFile(info.Platform, info.Channel, info.ReceivedMinutes, time.Now())
+	}
+
+	if !u.deleteAfter && u.index != nil {
+		u.index.add(info.Path, key)
+	}
+
+	// Delete local file if configured
+	if u.deleteAfter {
+		if err := os.Remove(info.Path); err != nil {
+			log.Printf("Error deleting local file %s: %v", info.Path, err)
+		} else {
+			log.Printf("Deleted local file %s", info.Path)
+			u.pruneDirs(info.Path)
+		}
+	}
+}
+
+// objectAPI is the part of the S3 client s3Store uses
+type objectAPI interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// s3Store uploads files to an S3 bucket
+type s3Store struct {
+	s3Client   objectAPI
+	bucket     string
+	objectOpts ObjectOptions
+	schedule   *Schedule
+	hedge      *Hedge
 }
 
-// uploadFile uploads a specific file to S3
-func (u *Uploader) uploadFile(ctx context.Context, localPath, s3Key string) error {
+// put uploads a file to S3 without ever overwriting an existing object.
+// If the key already holds the same content (from an upload interrupted
+// before the local file was deleted), nothing is uploaded. If it holds
+// different content, the file is stored next to it under a key with the
+// file's hash appended. Uploads are verified against the local MD5 and size.
+func (st *s3Store) put(ctx context.Context, localPath, s3Key string) (string, error) {
+	sum, size, err := fileMD5(localPath)
+	if err != nil {
+		return "", fmt.Errorf("hash file: %w", err)
+	}
+
+	for _, key := range []string{s3Key, conflictKey(s3Key, sum)} {
+		exists, same, err := st.compare(ctx, key, sum, size)
+		if err != nil {
+			return "", err
+		}
+		if exists && same {
+			log.Printf("%s already holds this file, skipping upload", st.describe(key))
+			return key, nil
+		}
+		if exists {
+			log.Printf("Warning: %s already exists with different content, not overwriting", st.describe(key))
+			continue
+		}
+
+		err = st.putObjectHedged(ctx, localPath, key, sum)
+		if apiErrorCode(err) == "PreconditionFailed" {
+			// Another writer created the key since the check; see what it wrote
+			if _, same, err := st.compare(ctx, key, sum, size); err == nil && same {
+				return key, nil
+			}
+			log.Printf("Warning: %s was created concurrently with different content", st.describe(key))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// Verify what landed matches what we sent
+		exists, same, err = st.compare(ctx, key, sum, size)
+		if err != nil {
+			return "", fmt.Errorf("verify upload: %w", err)
+		}
+		if !exists || !same {
+			return "", fmt.Errorf("verify upload: %s does not match the local file", st.describe(key))
+		}
+		return key, nil
+	}
+
+	return "", fmt.Errorf("%s and its conflict key already hold different content", st.describe(s3Key))
+}
+
+// putObject uploads a file with If-None-Match: * so an existing object is
+// never replaced, and Content-MD5 so S3 rejects corrupted bodies
+func (st *s3Store) putObject(ctx context.Context, localPath, key string, sum []byte) error {
 	file, err := os.Open(localPath)
 	if err != nil {
 		return fmt.Errorf("open file: %w", err)
 	}
 	defer file.Close()
 
-	_, err = u.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(u.bucket),
-		Key:    aws.String(s3Key),
-		Body:   file,
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(st.bucket),
+		Key:           aws.String(key),
+		Body:          st.schedule.reader(ctx, file),
+		ContentLength: aws.Int64(stat.Size()),
+		IfNoneMatch:   aws.String("*"),
+		ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(sum)),
+		Metadata:      map[string]string{md5MetadataKey: hex.EncodeToString(sum)},
+	}
+	st.objectOpts.Apply(input)
+
+	if _, err := st.s3Client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("put object: %w", err)
+	}
+	return nil
+}
+
+// compare reports whether key exists and, if so, whether it holds content
+// with the given MD5 and size. The MD5 is read from object metadata written
+// by putObject, falling back to the ETag, which is only an MD5 for
+// single-part uploads without SSE-KMS; otherwise the object is downloaded
+// and hashed, since a matching size alone doesn't make it the same file.
+func (st *s3Store) compare(ctx context.Context, key string, sum []byte, size int64) (exists, same bool, err error) {
+	head, err := st.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
 	})
+	if code := apiErrorCode(err); code == "NotFound" || code == "NoSuchKey" {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("head object: %w", err)
+	}
+
+	if aws.ToInt64(head.ContentLength) != size {
+		return true, false, nil
+	}
 
+	want := hex.EncodeToString(sum)
+	if stored, ok := head.Metadata[md5MetadataKey]; ok {
+		return true, stored == want, nil
+	}
+	etag := strings.Trim(aws.ToString(head.ETag), `"`)
+	if head.ServerSideEncryption != types.ServerSideEncryptionAwsKms && !strings.Contains(etag, "-") {
+		return true, etag == want, nil
+	}
+
+	stored, err := st.objectMD5(ctx, key)
 	if err != nil {
-		return fmt.Errorf("put object: %w", err)
+		return true, false, fmt.Errorf("hash object: %w", err)
 	}
+	return true, bytes.Equal(stored, sum), nil
+}
 
-	return nil
+// objectMD5 downloads an object and returns its MD5
+func (st *s3Store) objectMD5(ctx context.Context, key string) ([]byte, error) {
+	resp, err := st.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// md5MetadataKey is the user metadata key holding an object's content MD5
+const md5MetadataKey = "md5"
+
+// fileMD5 returns a file's MD5 and size
+func fileMD5(path string) ([]byte, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	h := md5.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), size, nil
+}
+
+// conflictKey derives the key used when key already holds different
+// content: the file's short hash is inserted before the extension
+func conflictKey(key string, sum []byte) string {
+	dir, name := path.Split(key)
+	base, ext, _ := strings.Cut(name, ".")
+	if ext != "" {
+		ext = "." + ext
+	}
+	return dir + base + "-" + hex.EncodeToString(sum)[:8] + ext
 }
 
-// generateS3Key generates an S3 key from a filename
-// Input: twitch_ludwig_20251230_1030.jsonl
-// Output: 2025/12/30/twitch/ludwig/twitch_ludwig_20251230_1030.jsonl
-func generateS3Key(filename string) (string, error) {
-	// Remove extension for parsing
-	nameWithoutExt := strings.TrimSuffix(filename, ".jsonl")
+// apiErrorCode returns the S3 error code of err, or "" if it has none
+func apiErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func (st *s3Store) describe(key string) string {
+	return fmt.Sprintf("s3://%s/%s", st.bucket, key)
+}
 
-	// Parse filename: platform_channel_YYYYMMDD_HHMM
-	// Channel names may contain underscores, so parse from the end
-	parts := strings.Split(nameWithoutExt, "_")
-	if len(parts) < 4 {
-		return "", fmt.Errorf("invalid filename format: %s", filename)
+// localStore copies files into a local directory tree
+type localStore struct {
+	dir      string
+	schedule *Schedule
+}
+
+// put copies the file to dir/key, writing to a temporary file first so a
+// partial copy is never left under the final name
+func (st localStore) put(ctx context.Context, localPath, key string) (string, error) {
+	dest := filepath.Join(st.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("create directory: %w", err)
 	}
 
-	platform := parts[0]
-	// The last two parts are always date and time
-	dateStr := parts[len(parts)-2]  // YYYYMMDD
-	timeStr := parts[len(parts)-1]  // HHMM
-	// Everything in between is the channel name
-	channel := strings.Join(parts[1:len(parts)-2], "_")
+	src, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer src.Close()
 
-	// Parse date
-	timestamp := dateStr + "_" + timeStr
-	t, err := time.Parse("20060102_1504", timestamp)
+	tmp := dest + ".tmp"
+	dst, err := os.Create(tmp)
 	if err != nil {
-		return "", fmt.Errorf("parse timestamp: %w", err)
+		return "", fmt.Errorf("create file: %w", err)
 	}
+	if _, err := io.Copy(dst, st.schedule.reader(ctx, src)); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return "", fmt.Errorf("copy file: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("close file: %w", err)
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (st localStore) describe(key string) string {
+	return filepath.Join(st.dir, filepath.FromSlash(key))
+}
 
-	// Generate S3 key
-	s3Key := fmt.Sprintf("%04d/%02d/%02d/%s/%s/%s",
-		t.Year(), t.Month(), t.Day(), platform, channel, filename)
+// keepStore leaves files in place; used when no upload target is configured
+type keepStore struct{}
 
-	return s3Key, nil
+func (keepStore) put(ctx context.Context, localPath, key string) (string, error) {
+	return key, nil
+}
+
+func (keepStore) describe(key string) string {
+	return "local disk (upload disabled)"
+}
+
+// Apply sets encryption, storage class and tagging on a PutObject request
+func (o ObjectOptions) Apply(input *s3.PutObjectInput) {
+	if o.ServerSideEncryption != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(o.ServerSideEncryption)
+	}
+	if o.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(o.KMSKeyID)
+	}
+	if o.StorageClass != "" {
+		input.StorageClass = types.StorageClass(o.StorageClass)
+	}
+	if len(o.Tags) > 0 {
+		tags := url.Values{}
+		for key, value := range o.Tags {
+			tags.Set(key, value)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+}
+
+// keyData is the data available to the S3 key template
+type keyData struct {
+	Platform string
+	Channel  string
+	Time     time.Time // File start time (UTC)
+	EndTime  time.Time // File end time (UTC)
+	Filename string
+}
+
+// ParseKeyTemplate parses an S3 key template. Templates use Go template
+// syntax with the fields .Platform, .Channel, .Time, .EndTime and .Filename, plus a
+// strftime function, e.g. {{strftime .Time "%Y/%m/%d"}}. An empty template
+// selects DefaultKeyTemplate.
+func ParseKeyTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultKeyTemplate
+	}
+
+	tmpl, err := template.New("key").Funcs(template.FuncMap{
+		"strftime": strftime,
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse key template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// generateS3Key renders the key template for a file
+func (u *Uploader) generateS3Key(info recorder.FileInfo) (string, error) {
+	prefix := ""
+	if u.keyPrefix != nil {
+		prefix = u.keyPrefix(info.Platform, info.Channel)
+	}
+	return u.renderKey(keyData{
+		Platform: info.Platform,
+		Channel:  info.Channel,
+		Time:     info.StartTime.UTC(),
+		EndTime:  info.EndTime.UTC(),
+		Filename: info.Filename(),
+	}, prefix)
+}
+
+// renderKey executes the key template for data and prepends prefix
+func (u *Uploader) renderKey(data keyData, prefix string) (string, error) {
+	var buf bytes.Buffer
+	if err := u.keyTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("execute key template: %w", err)
+	}
+
+	key := strings.TrimPrefix(prefix+buf.String(), "/")
+	if key == "" {
+		return "", fmt.Errorf("key template produced an empty key")
+	}
+	return key, nil
+}
+
+// strftime formats t using strftime-style tokens
+func strftime(t time.Time, format string) string {
+	var out strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			out.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&out, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&out, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&out, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&out, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&out, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&out, "%02d", t.Second())
+		case 'j':
+			fmt.Fprintf(&out, "%03d", t.YearDay())
+		case 'G':
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(&out, "%04d", year)
+		case 'V':
+			_, week := t.ISOWeek()
+			fmt.Fprintf(&out, "%02d", week)
+		case 's':
+			fmt.Fprintf(&out, "%d", t.Unix())
+		case '%':
+			out.WriteByte('%')
+		default:
+			out.WriteByte('%')
+			out.WriteByte(format[i])
+		}
+	}
+	return out.String()
 }
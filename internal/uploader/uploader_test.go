@@ -0,0 +1,104 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeObject is a stored object as HeadObject describes it
+type fakeObject struct {
+	data     []byte
+	etag     string
+	metadata map[string]string
+	sse      types.ServerSideEncryption
+}
+
+// fakeS3 serves fixed objects
+type fakeS3 struct {
+	objects map[string]fakeObject
+	gets    int
+}
+
+func (f *fakeS3) HeadObject(ctx context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	obj, ok := f.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength:        aws.Int64(int64(len(obj.data))),
+		ETag:                 aws.String(`"` + obj.etag + `"`),
+		Metadata:             obj.metadata,
+		ServerSideEncryption: obj.sse,
+	}, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	obj, ok := f.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	f.gets++
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(obj.data))}, nil
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return &s3.PutObjectOutput{}, nil
+}
+
+func md5Of(data string) []byte {
+	sum := md5.Sum([]byte(data))
+	return sum[:]
+}
+
+func TestS3StoreCompare(t *testing.T) {
+	const local = "local content"
+	sum := md5Of(local)
+	hexSum := hex.EncodeToString(sum)
+	other := "other content" // Same size as local
+
+	tests := []struct {
+		name       string
+		obj        *fakeObject
+		exists     bool
+		same       bool
+		downloaded bool
+	}{
+		{name: "missing", obj: nil},
+		{name: "different size", obj: &fakeObject{data: []byte("short"), etag: "x"}, exists: true},
+		{name: "md5 metadata matches", obj: &fakeObject{data: []byte(other), etag: "x", metadata: map[string]string{md5MetadataKey: hexSum}}, exists: true, same: true},
+		{name: "md5 metadata differs", obj: &fakeObject{data: []byte(local), etag: hexSum, metadata: map[string]string{md5MetadataKey: "0"}}, exists: true},
+		{name: "single-part etag matches", obj: &fakeObject{data: []byte(local), etag: hexSum}, exists: true, same: true},
+		{name: "single-part etag differs", obj: &fakeObject{data: []byte(other), etag: hex.EncodeToString(md5Of(other))}, exists: true},
+		{name: "multipart same content", obj: &fakeObject{data: []byte(local), etag: "abc-2"}, exists: true, same: true, downloaded: true},
+		{name: "multipart different content", obj: &fakeObject{data: []byte(other), etag: "abc-2"}, exists: true, downloaded: true},
+		{name: "kms different content", obj: &fakeObject{data: []byte(other), etag: "def", sse: types.ServerSideEncryptionAwsKms}, exists: true, downloaded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeS3{objects: map[string]fakeObject{}}
+			if tt.obj != nil {
+				fake.objects["key"] = *tt.obj
+			}
+			st := &s3Store{s3Client: fake, bucket: "test"}
+
+			exists, same, err := st.compare(context.Background(), "key", sum, int64(len(local)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != tt.exists || same != tt.same {
+				t.Errorf("compare = exists %v, same %v; want %v, %v", exists, same, tt.exists, tt.same)
+			}
+			if downloaded := fake.gets > 0; downloaded != tt.downloaded {
+				t.Errorf("downloaded = %v, want %v", downloaded, tt.downloaded)
+			}
+		})
+	}
+}
@@ -0,0 +1,511 @@
+package verify
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/john/chatlog/internal/annotate"
+	"github.com/john/chatlog/internal/recorder"
+)
+
+// Verifier checks that an S3 archive is complete and readable. Objects are
+// expected in the default YYYY/MM/DD/platform/channel/filename layout,
+// optionally under a key prefix.
+type Verifier struct {
+	buckets    []*target
+	maxGap     time.Duration
+	identities []age.Identity
+	localDirs  []string
+}
+
+// target is a bucket to check and the key prefixes to list in it
+type target struct {
+	client   *s3.Client
+	name     string
+	prefixes []string
+	external bool // Added with AddBucket, so its keys are reported with the bucket name
+}
+
+// New creates a verifier of bucket reporting coverage gaps longer than
+// maxGap
+func New(client *s3.Client, bucket string, maxGap time.Duration) *Verifier {
+	return &Verifier{
+		buckets: []*target{newTarget(client, bucket, nil)},
+		maxGap:  maxGap,
+	}
+}
+
+// newTarget returns a target listing the default layout and prefixes
+func newTarget(client *s3.Client, name string, prefixes []string) *target {
+	b := &target{client: client, name: name, prefixes: []string{""}}
+	for _, prefix := range prefixes {
+		if prefix != "" && !slices.Contains(b.prefixes, prefix) {
+			b.prefixes = append(b.prefixes, prefix)
+		}
+	}
+	return b
+}
+
+// EnablePrefixes also checks objects under these key prefixes, such as
+// channel group prefixes
+func (v *Verifier) EnablePrefixes(prefixes []string) {
+	first := v.buckets[0]
+	v.buckets[0] = newTarget(first.client, first.name, append(first.prefixes, prefixes...))
+}
+
+// AddBucket also checks another bucket, such as one a channel group uploads
+// to, under the default layout and prefixes. Its objects are reported as
+// s3://bucket/key. It must be called before Run.
+func (v *Verifier) AddBucket(client *s3.Client, name string, prefixes []string) {
+	b := newTarget(client, name, prefixes)
+	b.external = true
+	v.buckets = append(v.buckets, b)
+}
+
+// EnableDecryption decrypts age-encrypted (.age) objects with identities.
+// Without it, encrypted objects are listed but not read.
+func (v *Verifier) EnableDecryption(identities []age.Identity) {
+	v.identities = identities
+}
+
+// EnableLocal compares the archive against recorder files in dirs that
+// have not been uploaded, such as the output and dead-letter directories
+func (v *Verifier) EnableLocal(dirs ...string) {
+	v.localDirs = append(v.localDirs, dirs...)
+}
+
+// Report is the result of verifying a time range
+type Report struct {
+	Start    time.Time        `json:"start"`
+	End      time.Time        `json:"end"`
+	Objects  int              `json:"objects"`
+	Records  int64            `json:"records"`
+	Channels []*ChannelReport `json:"channels"`
+}
+
+// ChannelReport describes one channel's archive in the range
+type ChannelReport struct {
+	Platform  string       `json:"platform"`
+	Channel   string       `json:"channel"`
+	Objects   int          `json:"objects"`
+	Records   int64        `json:"records"`
+	Coverage  []Interval   `json:"coverage"`             // Time spanned by archived records, merged
+	Gaps      []Gap        `json:"gaps,omitempty"`       // Uncovered stretches longer than the maximum gap
+	Corrupt   []Corruption `json:"corrupt,omitempty"`    // Unreadable objects and lines
+	Encrypted []string     `json:"encrypted,omitempty"`  // Objects not read for lack of an identity
+	Local     []LocalFile  `json:"local_only,omitempty"` // Local files missing from the archive
+}
+
+// Interval is a span of time
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Gap is a stretch of time with no archived records. Pending lists local
+// files that cover part of it and have yet to be uploaded.
+type Gap struct {
+	Interval
+	Pending []string `json:"pending,omitempty"`
+}
+
+// Corruption is a run of unreadable lines in an object, or the whole
+// object when Line is 0. The interval lies between the nearest readable
+// records, when known.
+type Corruption struct {
+	Key   string    `json:"key"`
+	Line  int       `json:"line,omitempty"`  // First bad line, 1-based
+	Lines int       `json:"lines,omitempty"` // Consecutive bad lines
+	After time.Time `json:"after,omitempty"`
+	Until time.Time `json:"until,omitempty"`
+	Error string    `json:"error"`
+}
+
+// LocalFile is a recorder file on disk
+type LocalFile struct {
+	Path  string    `json:"path"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Problems returns how many gaps, corrupt runs and local-only files were found
+func (r *Report) Problems() int {
+	var n int
+	for _, ch := range r.Channels {
+		n += len(ch.Gaps) + len(ch.Corrupt) + len(ch.Local)
+	}
+	return n
+}
+
+// Run verifies archived objects for the channels matching platform and
+// channel (empty for all) between start and end
+func (v *Verifier) Run(ctx context.Context, start, end time.Time, platform, channel string) (*Report, error) {
+	report := &Report{Start: start.UTC(), End: end.UTC()}
+	channels := make(map[string]*ChannelReport)
+	get := func(p, c string) *ChannelReport {
+		key := p + "/" + c
+		if ch, ok := channels[key]; ok {
+			return ch
+		}
+		ch := &ChannelReport{Platform: p, Channel: c}
+		channels[key] = ch
+		return ch
+	}
+
+	keys, err := v.list(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	archived := make(map[string]bool) // platform/channel/filename
+	spans := make(map[*ChannelReport][]Interval)
+	for _, obj := range keys {
+		p, c, name, ok := parseKey(obj.key)
+		if !ok || (platform != "" && p != platform) || (channel != "" && c != channel) {
+			continue
+		}
+		archived[p+"/"+c+"/"+archiveName(name)] = true
+
+		ch := get(p, c)
+		result, err := v.check(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		if result.encrypted {
+			ch.Encrypted = append(ch.Encrypted, obj.String())
+			continue
+		}
+		outside := !result.first.IsZero() && (result.last.Before(start) || result.first.After(end))
+		if outside && len(result.corrupt) == 0 {
+			continue // Listed for the day before, but entirely outside the range
+		}
+
+		ch.Objects++
+		ch.Records += result.records
+		ch.Corrupt = append(ch.Corrupt, result.corrupt...)
+		report.Objects++
+		report.Records += result.records
+		if !result.first.IsZero() {
+			spans[ch] = append(spans[ch], Interval{Start: result.first, End: result.last})
+		}
+	}
+
+	local, err := v.localFiles(start, end, platform, channel)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range local {
+		get(f.platform, f.channel)
+	}
+
+	for _, ch := range channels {
+		ch.Coverage = merge(spans[ch], v.maxGap)
+
+		// Local files count as archived if uploaded under the same name or
+		// covered by the archive, e.g. after compaction
+		for _, f := range local {
+			if f.platform != ch.Platform || f.channel != ch.Channel {
+				continue
+			}
+			if archived[f.platform+"/"+f.channel+"/"+archiveName(filepath.Base(f.Path))] || covered(ch.Coverage, f.Start, f.End) {
+				continue
+			}
+			ch.Local = append(ch.Local, f.LocalFile)
+		}
+
+		ch.Gaps = gaps(ch.Coverage, start.UTC(), end.UTC(), v.maxGap)
+		for i, gap := range ch.Gaps {
+			for _, f := range ch.Local {
+				if f.Start.Before(gap.End) && f.End.After(gap.Start) {
+					ch.Gaps[i].Pending = append(ch.Gaps[i].Pending, f.Path)
+				}
+			}
+		}
+		report.Channels = append(report.Channels, ch)
+	}
+	sort.Slice(report.Channels, func(i, j int) bool {
+		a, b := report.Channels[i], report.Channels[j]
+		if a.Platform != b.Platform {
+			return a.Platform < b.Platform
+		}
+		return a.Channel < b.Channel
+	})
+
+	return report, nil
+}
+
+// object is an archive object in one of the verified buckets
+type object struct {
+	bucket *target
+	key    string
+}
+
+// String returns the object's key as reports name it
+func (o object) String() string {
+	if o.bucket.external {
+		return "s3://" + o.bucket.name + "/" + o.key
+	}
+	return o.key
+}
+
+// list returns archive objects for each day in the range, starting a day
+// early since a file started before midnight may run past it
+func (v *Verifier) list(ctx context.Context, start, end time.Time) ([]object, error) {
+	var objects []object
+	for day := start.UTC().AddDate(0, 0, -1).Truncate(24 * time.Hour); day.Before(end); day = day.AddDate(0, 0, 1) {
+		for _, b := range v.buckets {
+			for _, prefix := range b.prefixes {
+				paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+					Bucket: aws.String(b.name),
+					Prefix: aws.String(prefix + day.Format("2006/01/02") + "/"),
+				})
+				for paginator.HasMorePages() {
+					page, err := paginator.NextPage(ctx)
+					if err != nil {
+						return nil, fmt.Errorf("list s3://%s: %w", b.name, err)
+					}
+					for _, obj := range page.Contents {
+						if key := aws.ToString(obj.Key); isArchive(key) {
+							objects = append(objects, object{bucket: b, key: key})
+						}
+					}
+				}
+			}
+		}
+	}
+	return objects, nil
+}
+
+// objectResult is what checking one object found
+type objectResult struct {
+	records     int64
+	first, last time.Time
+	corrupt     []Corruption
+	encrypted   bool
+}
+
+// check downloads an object and validates every line. Errors reading the
+// object are reported as corruption; only cancellation is returned.
+func (v *Verifier) check(ctx context.Context, obj object) (objectResult, error) {
+	var result objectResult
+	if strings.HasSuffix(obj.key, recorder.EncryptedExt) && len(v.identities) == 0 {
+		result.encrypted = true
+		return result, nil
+	}
+
+	key := obj.String()
+	resp, err := obj.bucket.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(obj.bucket.name),
+		Key:    aws.String(obj.key),
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		result.corrupt = append(result.corrupt, Corruption{Key: key, Error: fmt.Sprintf("get object: %v", err)})
+		return result, nil
+	}
+	defer resp.Body.Close()
+
+	var r io.Reader = resp.Body
+	if strings.HasSuffix(key, recorder.EncryptedExt) {
+		if r, err = age.Decrypt(r, v.identities...); err != nil {
+			result.corrupt = append(result.corrupt, Corruption{Key: key, Error: fmt.Sprintf("decrypt: %v", err)})
+			return result, nil
+		}
+	}
+	if strings.HasSuffix(key, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			result.corrupt = append(result.corrupt, Corruption{Key: key, Error: fmt.Sprintf("open gzip: %v", err)})
+			return result, nil
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var bad *Corruption
+	var lastGood time.Time
+	lineNo := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		lineNo++
+		ts, err := parseLine(scanner.Bytes())
+		if err != nil {
+			if bad == nil {
+				bad = &Corruption{Key: key, Line: lineNo, After: lastGood, Error: err.Error()}
+			}
+			bad.Lines++
+			continue
+		}
+
+		if bad != nil {
+			bad.Until = ts
+			result.corrupt = append(result.corrupt, *bad)
+			bad = nil
+		}
+		lastGood = ts
+		result.records++
+		if result.first.IsZero() || ts.Before(result.first) {
+			result.first = ts
+		}
+		if ts.After(result.last) {
+			result.last = ts
+		}
+	}
+	if bad != nil {
+		result.corrupt = append(result.corrupt, *bad)
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		result.corrupt = append(result.corrupt, Corruption{Key: key, Line: lineNo + 1, After: lastGood, Error: fmt.Sprintf("read: %v", err)})
+	}
+	return result, nil
+}
+
+// parseLine validates a JSONL record and returns its timestamp
+func parseLine(data []byte) (time.Time, error) {
+	var record struct {
+		Platform  string `json:"platform"`
+		Channel   string `json:"channel"`
+		Timestamp string `json:"timestamp"`
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return time.Time{}, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if record.Platform == "" || record.Channel == "" {
+		return time.Time{}, fmt.Errorf("missing platform or channel")
+	}
+	ts, err := time.Parse(time.RFC3339Nano, record.Timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", record.Timestamp)
+	}
+	return ts.UTC(), nil
+}
+
+// localFile is a recorder file with its channel
+type localFile struct {
+	LocalFile
+	platform, channel string
+}
+
+// localFiles returns the recorder files in the local directories that
+// overlap the range
+func (v *Verifier) localFiles(start, end time.Time, platform, channel string) ([]localFile, error) {
+	var files []localFile
+	for _, dir := range v.localDirs {
+		paths, err := recorder.LocalFiles(dir)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", dir, err)
+		}
+		for _, path := range paths {
+			if !isArchive(filepath.Base(path)) {
+				continue
+			}
+			info, err := recorder.ParseFileInfo(path)
+			if err != nil {
+				continue
+			}
+			if (platform != "" && info.Platform != platform) || (channel != "" && info.Channel != channel) {
+				continue
+			}
+			if info.EndTime.Before(start) || info.StartTime.After(end) {
+				continue
+			}
+			files = append(files, localFile{
+				LocalFile: LocalFile{Path: info.Path, Start: info.StartTime, End: info.EndTime},
+				platform:  info.Platform,
+				channel:   info.Channel,
+			})
+		}
+	}
+	return files, nil
+}
+
+// merge sorts intervals and joins those less than maxGap apart
+func merge(spans []Interval, maxGap time.Duration) []Interval {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+
+	var merged []Interval
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span.Start.Sub(merged[n-1].End) <= maxGap {
+			if span.End.After(merged[n-1].End) {
+				merged[n-1].End = span.End
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+// gaps returns the stretches of [start, end) longer than maxGap that
+// coverage doesn't reach
+func gaps(coverage []Interval, start, end time.Time, maxGap time.Duration) []Gap {
+	var found []Gap
+	cursor := start
+	for _, span := range coverage {
+		if span.Start.Sub(cursor) > maxGap {
+			found = append(found, Gap{Interval: Interval{Start: cursor, End: span.Start}})
+		}
+		if span.End.After(cursor) {
+			cursor = span.End
+		}
+	}
+	if end.Sub(cursor) > maxGap {
+		found = append(found, Gap{Interval: Interval{Start: cursor, End: end}})
+	}
+	return found
+}
+
+// covered reports whether one interval of coverage spans start to end
+func covered(coverage []Interval, start, end time.Time) bool {
+	for _, span := range coverage {
+		if !span.Start.After(start) && !span.End.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKey splits a .../platform/channel/filename key
+func parseKey(key string) (platform, channel, name string, ok bool) {
+	dir, name := path.Split(key)
+	dir = strings.TrimSuffix(dir, "/")
+	channel = path.Base(dir)
+	platform = path.Base(path.Dir(dir))
+	if platform == "." || platform == "/" || channel == "." || name == "" {
+		return "", "", "", false
+	}
+	return platform, channel, name, true
+}
+
+// archiveName strips compression so a local file matches its uploaded name
+func archiveName(name string) string {
+	return strings.TrimSuffix(name, ".gz")
+}
+
+// isArchive reports whether name is a JSONL archive: plain, compacted or
+// encrypted. Annotation sidecars are not.
+func isArchive(name string) bool {
+	if strings.HasSuffix(name, annotate.Ext) {
+		return false
+	}
+	return strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".jsonl.gz") || strings.HasSuffix(name, ".jsonl.age")
+}
@@ -0,0 +1,82 @@
+package vkplay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// blogInfo is the part of a channel ("blog") the connector needs
+type blogInfo struct {
+	BlogURL string `json:"blogUrl"`
+	Owner   struct {
+		ID int64 `json:"id"`
+	} `json:"owner"`
+	PublicWebSocketChannel string `json:"publicWebSocketChannel"`
+}
+
+// chatChannel returns the Centrifugo channel a blog's chat is published
+// on, "public-chat:{owner ID}"
+func (b blogInfo) chatChannel() string {
+	if strings.HasPrefix(b.PublicWebSocketChannel, "public-chat:") {
+		return b.PublicWebSocketChannel
+	}
+	return "public-chat:" + strconv.FormatInt(b.Owner.ID, 10)
+}
+
+// lookupBlog fetches a channel by the name in its URL
+func (c *Connector) lookupBlog(ctx context.Context, name string) (blogInfo, error) {
+	var blog blogInfo
+	if err := c.get(ctx, "/blog/"+url.PathEscape(name), &blog); err != nil {
+		return blogInfo{}, err
+	}
+	if blog.Owner.ID == 0 && blog.PublicWebSocketChannel == "" {
+		return blogInfo{}, fmt.Errorf("API returned no owner")
+	}
+	return blog, nil
+}
+
+// connectToken fetches an anonymous token for the WebSocket
+func (c *Connector) connectToken(ctx context.Context) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := c.get(ctx, "/ws/connect", &resp); err != nil {
+		return "", err
+	}
+	if resp.Token == "" {
+		return "", fmt.Errorf("API returned no token")
+	}
+	return resp.Token, nil
+}
+
+// get performs a GET against the API
+func (c *Connector) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Origin", webOrigin)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("JSON decode failed: %w", err)
+	}
+	return nil
+}
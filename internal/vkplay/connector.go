@@ -0,0 +1,395 @@
+// Package vkplay records chat from VK Play Live (live.vkvideo.ru) through
+// the public Centrifugo WebSocket its web player reads, so no account is
+// needed. It is enabled through the connectors config:
+//
+//	connectors:
+//	  - name: vkplay
+//	    options:
+//	      channels: [some_streamer]   # Names from the channel URLs
+package vkplay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/john/chatlog/internal/egress"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/platform"
+	"github.com/john/chatlog/internal/status"
+)
+
+func init() {
+	platform.Register("vkplay", newFromOptions)
+}
+
+const (
+	// DefaultAPIURL is the REST API channels and tokens are fetched from
+	DefaultAPIURL = "https://api.live.vkvideo.ru/v1"
+
+	// DefaultWebSocketURL is the Centrifugo endpoint chat is read from
+	DefaultWebSocketURL = "wss://pubsub.live.vkvideo.ru/connection/websocket?cf_protocol_version=v2"
+
+	// webOrigin is sent as the Origin of API and WebSocket requests
+	webOrigin = "https://live.vkvideo.ru"
+
+	// readTimeout is how long without any frame before the connection is
+	// dead; the server pings every 25 seconds
+	readTimeout = 90 * time.Second
+
+	maxReconnectBackoff = 60 * time.Second
+)
+
+// Connector follows the chat of VK Play Live channels. Messages are
+// recorded with platform "vkplay" and the channel's name from its URL.
+type Connector struct {
+	apiURL   string
+	wsURL    string
+	channels []string // Lowercase channel names
+
+	chatChannels map[string]string // Centrifugo channel -> channel name
+	nextID       atomic.Int64      // Command IDs
+
+	httpClient *http.Client
+	dialer     *websocket.Dialer
+	status     *status.Component
+}
+
+// New creates a VK Play Live connector. Empty URLs select DefaultAPIURL
+// and DefaultWebSocketURL.
+func New(channels []string, apiURL, wsURL string) *Connector {
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+	if wsURL == "" {
+		wsURL = DefaultWebSocketURL
+	}
+	names := make([]string, len(channels))
+	for i, channel := range channels {
+		names[i] = strings.ToLower(channel)
+	}
+	return &Connector{
+		apiURL:       strings.TrimSuffix(apiURL, "/"),
+		wsURL:        wsURL,
+		channels:     names,
+		chatChannels: make(map[string]string),
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		dialer:       websocket.DefaultDialer,
+	}
+}
+
+// newFromOptions creates a connector from the connectors config: channels
+// (required), and api_url and websocket_url to override the defaults
+func newFromOptions(options map[string]any) (platform.Connector, error) {
+	channels, err := platform.StringList(options, "channels")
+	if err != nil {
+		return nil, err
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("channels is required")
+	}
+	apiURL, err := platform.StringOption(options, "api_url")
+	if err != nil {
+		return nil, err
+	}
+	wsURL, err := platform.StringOption(options, "websocket_url")
+	if err != nil {
+		return nil, err
+	}
+	return New(channels, apiURL, wsURL), nil
+}
+
+// EnableStatus reports connection state and message times to comp. It must
+// be called before Start.
+func (c *Connector) EnableStatus(comp *status.Component) {
+	c.status = comp
+}
+
+// EnableEgress reaches the API and WebSocket through network. It must be
+// called before Start.
+func (c *Connector) EnableEgress(network *egress.Network) {
+	c.httpClient = network.HTTPClient(10 * time.Second)
+	c.dialer = network.WebSocketDialer()
+}
+
+// Start follows each channel's chat until the context is cancelled,
+// reconnecting with exponential backoff
+func (c *Connector) Start(ctx context.Context, messageChan chan<- message.Message) error {
+	for _, name := range c.channels {
+		blog, err := c.lookupBlog(ctx, name)
+		if err != nil {
+			log.Printf("Warning: Failed to look up VK Play channel '%s': %v (skipping)", name, err)
+			continue
+		}
+		c.chatChannels[blog.chatChannel()] = name
+		log.Printf("Resolved VK Play channel: %s -> %s", name, blog.chatChannel())
+	}
+	if len(c.chatChannels) == 0 {
+		return fmt.Errorf("no VK Play channels to follow")
+	}
+
+	backoff := time.Second
+	for {
+		connectedAt := time.Now()
+		err := c.runConnection(ctx, messageChan)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if time.Since(connectedAt) > maxReconnectBackoff {
+			backoff = time.Second
+		}
+
+		log.Printf("VK Play connection lost: %v. Reconnecting in %v", err, backoff)
+		c.status.SetState(status.StateReconnecting)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// frame is a Centrifugo protocol message: a command or reply carrying an
+// id, a push, or an empty ping
+type frame struct {
+	ID        int64           `json:"id,omitempty"`
+	Connect   json.RawMessage `json:"connect,omitempty"`
+	Subscribe json.RawMessage `json:"subscribe,omitempty"`
+	Error     *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	Push *struct {
+		Channel string `json:"channel"`
+		Pub     *struct {
+			Data json.RawMessage `json:"data"`
+		} `json:"pub"`
+	} `json:"push,omitempty"`
+}
+
+// runConnection handles a single WebSocket connection lifetime
+func (c *Connector) runConnection(ctx context.Context, messageChan chan<- message.Message) error {
+	token, err := c.connectToken(ctx)
+	if err != nil {
+		return fmt.Errorf("get token: %w", err)
+	}
+
+	conn, _, err := c.dialer.DialContext(ctx, c.wsURL, http.Header{"Origin": {webOrigin}})
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	connectID := c.nextID.Add(1)
+	if err := conn.WriteJSON(map[string]any{"id": connectID, "connect": map[string]string{"token": token, "name": "js"}}); err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	subscriptions := make(map[int64]string)
+	for _, channel := range c.sortedChatChannels() {
+		id := c.nextID.Add(1)
+		subscriptions[id] = channel
+		if err := conn.WriteJSON(map[string]any{"id": id, "subscribe": map[string]string{"channel": channel}}); err != nil {
+			return fmt.Errorf("subscribe: %w", err)
+		}
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+
+		// Frames may be batched, one per line
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			var f frame
+			if err := json.Unmarshal(line, &f); err != nil {
+				log.Printf("Warning: Failed to parse VK Play frame: %v", err)
+				continue
+			}
+
+			switch {
+			case f.ID == 0 && f.Push == nil && f.Error == nil:
+				if err := conn.WriteMessage(websocket.TextMessage, []byte("{}")); err != nil {
+					return fmt.Errorf("pong: %w", err)
+				}
+
+			case f.ID == connectID:
+				if f.Error != nil {
+					return fmt.Errorf("connect rejected: %d %s", f.Error.Code, f.Error.Message)
+				}
+				log.Println("Connected to VK Play chat")
+				c.status.SetState(status.StateConnected)
+
+			case subscriptions[f.ID] != "":
+				if f.Error != nil {
+					log.Printf("Warning: VK Play refused the chat of %s: %d %s", c.chatChannels[subscriptions[f.ID]], f.Error.Code, f.Error.Message)
+				}
+
+			case f.Push != nil && f.Push.Pub != nil:
+				msg, ok := c.convert(f.Push.Channel, f.Push.Pub.Data)
+				if !ok {
+					continue
+				}
+				c.status.MessageReceived()
+				select {
+				case messageChan <- msg:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// sortedChatChannels returns the Centrifugo channels to subscribe to, in a
+// stable order
+func (c *Connector) sortedChatChannels() []string {
+	channels := make([]string, 0, len(c.chatChannels))
+	for channel := range c.chatChannels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// publication is a push on a chat channel; chat messages have type
+// "message"
+type publication struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// chatMessage is a VK Play chat message
+type chatMessage struct {
+	ID        int64 `json:"id"`
+	CreatedAt int64 `json:"createdAt"` // Unix seconds
+	Author    struct {
+		ID          int64  `json:"id"`
+		DisplayName string `json:"displayName"`
+		Nick        string `json:"nick"`
+		Badges      []struct {
+			Name string `json:"name"`
+		} `json:"badges"`
+	} `json:"author"`
+	Data []contentPart `json:"data"`
+}
+
+// contentPart is one piece of a message's content
+type contentPart struct {
+	Type        string `json:"type"`        // text, smile, mention or link
+	Content     string `json:"content"`     // text and link: a JSON array whose first element is the text
+	Name        string `json:"name"`        // smile
+	DisplayName string `json:"displayName"` // mention
+	Nick        string `json:"nick"`        // mention
+	URL         string `json:"url"`         // link
+}
+
+// convert turns a chat channel push into a message, or false if it isn't
+// a chat message on a followed channel
+func (c *Connector) convert(chatChannel string, data json.RawMessage) (message.Message, bool) {
+	channel, ok := c.chatChannels[chatChannel]
+	if !ok {
+		return message.Message{}, false
+	}
+	var pub publication
+	if err := json.Unmarshal(data, &pub); err != nil || pub.Type != "message" {
+		return message.Message{}, false
+	}
+	var chat chatMessage
+	if err := json.Unmarshal(pub.Data, &chat); err != nil {
+		log.Printf("Warning: Failed to parse VK Play message: %v", err)
+		return message.Message{}, false
+	}
+
+	var sentAt time.Time
+	if chat.CreatedAt > 0 {
+		sentAt = time.Unix(chat.CreatedAt, 0)
+	}
+	msg := message.New("vkplay", sentAt)
+	msg.Channel = channel
+	if chat.ID != 0 {
+		msg.ID = strconv.FormatInt(chat.ID, 10)
+	}
+	msg.Username = chat.Author.DisplayName
+	if msg.Username == "" {
+		msg.Username = chat.Author.Nick
+	}
+	msg.UserID = strconv.FormatInt(chat.Author.ID, 10)
+	var badges []string
+	for _, badge := range chat.Author.Badges {
+		badges = append(badges, badge.Name)
+	}
+	msg.Badges = strings.Join(badges, ",")
+	msg.Message, msg.Emotes = render(chat.Data)
+	return msg, true
+}
+
+// render joins a message's content into text, with smiles as their names,
+// and lists the smiles used
+func render(parts []contentPart) (string, []string) {
+	var text strings.Builder
+	var emotes []string
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			text.WriteString(partText(part.Content))
+		case "link":
+			if s := partText(part.Content); s != "" {
+				text.WriteString(s)
+			} else {
+				text.WriteString(part.URL)
+			}
+		case "smile":
+			text.WriteString(part.Name)
+			emotes = append(emotes, part.Name)
+		case "mention":
+			name := part.DisplayName
+			if name == "" {
+				name = part.Nick
+			}
+			text.WriteString("@" + name)
+		}
+	}
+	return strings.TrimSpace(text.String()), emotes
+}
+
+// partText returns the text of a text or link part, whose content is a
+// JSON array of the text and its styling
+func partText(content string) string {
+	var fields []json.RawMessage
+	if err := json.Unmarshal([]byte(content), &fields); err != nil || len(fields) == 0 {
+		return content
+	}
+	var text string
+	if err := json.Unmarshal(fields[0], &text); err != nil {
+		return content
+	}
+	return text
+}
@@ -0,0 +1,282 @@
+package volume
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+// Modes applied while a channel is over its threshold
+const (
+	ModeSample    = "sample"    // Record 1 in SampleRate messages
+	ModeAggregate = "aggregate" // Record only per-minute aggregate records
+)
+
+const (
+	// rateWindow is how many one-second buckets the message rate is averaged over
+	rateWindow = 10
+	// exitRatio is the fraction of the threshold the rate must fall below
+	// before full capture resumes, so channels don't flap around it
+	exitRatio = 0.8
+	// topEmotes is how many emotes are kept in aggregate records
+	topEmotes = 10
+)
+
+// Rule limits recording for a channel above a message rate
+type Rule struct {
+	Platform   string  // "*" matches any platform
+	Channel    string  // "*" matches any channel
+	Threshold  float64 // Messages per second above which the mode applies
+	Mode       string
+	SampleRate int // Keep 1 in SampleRate messages in sample mode
+}
+
+// channelState tracks the rate and current window of one channel
+type channelState struct {
+	rule    *Rule
+	buckets [rateWindow]int // Messages per second, ring buffer
+	bucket  int             // Index of the current bucket
+	active  bool            // Over threshold: sampling or aggregating
+	counter int             // Messages seen while active, for sampling
+
+	windowStart time.Time
+	messages    int
+	recorded    int
+	users       map[string]bool
+	emotes      map[string]int
+}
+
+// Limiter switches high-volume channels from full capture to sampling or
+// per-minute aggregation while their message rate exceeds a threshold
+type Limiter struct {
+	rules    []Rule
+	channels map[string]*channelState // key: "platform/channel"
+	on       func() bool              // Reports whether limiting is switched on; nil is always on
+	observe  func(platform, channel string)
+}
+
+// New creates a limiter. Rules are matched in order, so put specific
+// channels before wildcards.
+func New(rules []Rule) *Limiter {
+	return &Limiter{
+		rules:    rules,
+		channels: make(map[string]*channelState),
+	}
+}
+
+// EnableSwitch limits only while on reports true, passing every record
+// through otherwise. Channels already over their threshold finish their
+// window. It must be called before Filter.
+func (l *Limiter) EnableSwitch(on func() bool) {
+	l.on = on
+}
+
+// EnableObserver calls observe with each chat message's channel as it
+// arrives, before sampling or aggregation, so message rates can count what
+// was received rather than what was recorded. It must be called before
+// Filter.
+func (l *Limiter) EnableObserver(observe func(platform, channel string)) {
+	l.observe = observe
+}
+
+// Filter forwards messages from in to out, applying the rules, until the
+// context is cancelled or in is closed. Closing in writes the aggregates of
+// channels still over their threshold and closes out.
+func (l *Limiter) Filter(ctx context.Context, in <-chan message.Message, out chan<- message.Message) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	send := func(msg message.Message) bool {
+		select {
+		case out <- msg:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				for _, record := range l.flushAll(time.Now()) {
+					if !send(record) {
+						return ctx.Err()
+					}
+				}
+				close(out)
+				return nil
+			}
+			if l.keep(msg) && !send(msg) {
+				return ctx.Err()
+			}
+
+		case now := <-ticker.C:
+			for _, record := range l.tick(now) {
+				if !send(record) {
+					return ctx.Err()
+				}
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// keep counts a message and reports whether it should be recorded
+func (l *Limiter) keep(msg message.Message) bool {
+	if msg.Type != "" {
+		return true // Never limit non-chat records
+	}
+	if l.observe != nil {
+		l.observe(msg.Platform, msg.Channel)
+	}
+	if l.on != nil && !l.on() {
+		return true
+	}
+
+	state := l.state(msg.Platform, msg.Channel)
+	if state == nil {
+		return true
+	}
+
+	state.buckets[state.bucket]++
+	if !state.active {
+		return true
+	}
+
+	state.messages++
+	state.users[msg.UserID+"/"+msg.Username] = true
+	for _, emote := range msg.Emotes {
+		state.emotes[emote]++
+	}
+
+	if state.rule.Mode == ModeSample {
+		state.counter++
+		if state.counter%state.rule.SampleRate == 0 {
+			state.recorded++
+			return true
+		}
+	}
+	return false
+}
+
+// state returns the state for a channel, or nil if no rule applies
+func (l *Limiter) state(platform, channel string) *channelState {
+	key := platform + "/" + channel
+	if state, ok := l.channels[key]; ok {
+		return state
+	}
+
+	var rule *Rule
+	for i := range l.rules {
+		r := &l.rules[i]
+		if (r.Platform == "*" || r.Platform == platform) && (r.Channel == "*" || strings.EqualFold(r.Channel, channel)) {
+			rule = r
+			break
+		}
+	}
+
+	var state *channelState
+	if rule != nil {
+		state = &channelState{rule: rule}
+	}
+	l.channels[key] = state
+	return state
+}
+
+// tick advances every channel's rate by one second, switches modes and
+// returns aggregate records for windows that ended
+func (l *Limiter) tick(now time.Time) []message.Message {
+	var records []message.Message
+
+	for key, state := range l.channels {
+		if state == nil {
+			continue
+		}
+		platform, channel, _ := strings.Cut(key, "/")
+
+		total := 0
+		for _, n := range state.buckets {
+			total += n
+		}
+		rate := float64(total) / rateWindow
+		state.bucket = (state.bucket + 1) % rateWindow
+		state.buckets[state.bucket] = 0
+
+		switch {
+		case !state.active && rate > state.rule.Threshold:
+			log.Printf("%s/%s is at %.0f msg/s, switching to %s mode", platform, channel, rate, state.rule.Mode)
+			state.active = true
+			state.counter = 0
+			state.startWindow(now)
+
+		case state.active && rate < state.rule.Threshold*exitRatio:
+			log.Printf("%s/%s is down to %.0f msg/s, resuming full capture", platform, channel, rate)
+			records = append(records, state.flush(platform, channel, now))
+			state.active = false
+
+		case state.active && now.Sub(state.windowStart) >= time.Minute:
+			records = append(records, state.flush(platform, channel, now))
+			state.startWindow(now)
+		}
+	}
+
+	return records
+}
+
+// flushAll returns the aggregate records of all channels currently over
+// their threshold, for shutdown
+func (l *Limiter) flushAll(now time.Time) []message.Message {
+	var records []message.Message
+	for key, state := range l.channels {
+		if state != nil && state.active {
+			platform, channel, _ := strings.Cut(key, "/")
+			records = append(records, state.flush(platform, channel, now))
+		}
+	}
+	return records
+}
+
+// startWindow begins a new aggregation window
+func (s *channelState) startWindow(now time.Time) {
+	s.windowStart = now
+	s.messages = 0
+	s.recorded = 0
+	s.users = make(map[string]bool)
+	s.emotes = make(map[string]int)
+}
+
+// flush builds the aggregate record for the current window
+func (s *channelState) flush(platform, channel string, now time.Time) message.Message {
+	emotes := make([]message.EmoteCount, 0, len(s.emotes))
+	for name, count := range s.emotes {
+		emotes = append(emotes, message.EmoteCount{Name: name, Count: count})
+	}
+	sort.Slice(emotes, func(i, j int) bool {
+		if emotes[i].Count != emotes[j].Count {
+			return emotes[i].Count > emotes[j].Count
+		}
+		return emotes[i].Name < emotes[j].Name
+	})
+	if len(emotes) > topEmotes {
+		emotes = emotes[:topEmotes]
+	}
+
+	record := message.New(platform, s.windowStart)
+	record.Channel = channel
+	record.Type = message.TypeAggregate
+	record.Aggregate = &message.Aggregate{
+		WindowSeconds: int(now.Sub(s.windowStart).Round(time.Second).Seconds()),
+		Messages:      s.messages,
+		Recorded:      s.recorded,
+		Users:         len(s.users),
+		TopEmotes:     emotes,
+	}
+	return record
+}
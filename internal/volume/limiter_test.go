@@ -0,0 +1,34 @@
+package volume
+
+import (
+	"testing"
+
+	"github.com/john/chatlog/internal/message"
+)
+
+func TestObserverCountsMessagesBeforeSampling(t *testing.T) {
+	l := New([]Rule{{Platform: "twitch", Channel: "*", Threshold: 1, Mode: ModeSample, SampleRate: 10}})
+	observed := 0
+	l.EnableObserver(func(platform, channel string) { observed++ })
+
+	// Put the channel over its threshold, as tick would
+	state := l.state("twitch", "busy")
+	state.active = true
+	state.users = make(map[string]bool)
+	state.emotes = make(map[string]int)
+
+	kept := 0
+	for i := 0; i < 100; i++ {
+		if l.keep(message.Message{Platform: "twitch", Channel: "busy", Message: "hi"}) {
+			kept++
+		}
+	}
+	l.keep(message.Message{Platform: "twitch", Channel: "busy", Type: "aggregate"})
+
+	if kept != 10 {
+		t.Errorf("kept %d messages, want 10", kept)
+	}
+	if observed != 100 {
+		t.Errorf("observed %d messages, want every chat message received (100)", observed)
+	}
+}
@@ -0,0 +1,218 @@
+package youtube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const apiBaseURL = "https://www.googleapis.com/youtube/v3"
+
+// apiError is a non-200 response from the Data API
+type apiError struct {
+	Status int
+	Reason string // The first error's reason, such as "quotaExceeded"
+	Body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API returned status %d: %s", e.Status, e.Body)
+}
+
+// newAPIError builds an apiError from a response's status and body
+func newAPIError(status int, body []byte) *apiError {
+	var result struct {
+		Error struct {
+			Errors []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	apiErr := &apiError{Status: status, Body: string(body)}
+	if json.Unmarshal(body, &result) == nil && len(result.Error.Errors) > 0 {
+		apiErr.Reason = result.Error.Errors[0].Reason
+	}
+	return apiErr
+}
+
+// quotaReasons are the 403 reasons that mean the API key is out of quota
+// or being rate limited, rather than that the chat is gone
+var quotaReasons = map[string]bool{
+	"quotaExceeded":         true,
+	"dailyLimitExceeded":    true,
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+}
+
+// quotaExceeded reports whether err means requests must slow down until
+// quota is available again
+func quotaExceeded(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && (apiErr.Status == http.StatusTooManyRequests ||
+		apiErr.Status == http.StatusForbidden && quotaReasons[apiErr.Reason])
+}
+
+// chatEnded reports whether err means the live chat is gone: ended,
+// disabled, or the stream went offline
+func chatEnded(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && !quotaExceeded(err) &&
+		(apiErr.Status == http.StatusForbidden || apiErr.Status == http.StatusNotFound)
+}
+
+// chatPage is a liveChatMessages.list response
+type chatPage struct {
+	NextPageToken         string        `json:"nextPageToken"`
+	PollingIntervalMillis int           `json:"pollingIntervalMillis"`
+	OfflineAt             string        `json:"offlineAt"`
+	Items                 []chatMessage `json:"items"`
+}
+
+// chatMessage is a liveChatMessage resource
+type chatMessage struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Type            string    `json:"type"`
+		AuthorChannelID string    `json:"authorChannelId"`
+		PublishedAt     time.Time `json:"publishedAt"`
+		DisplayMessage  string    `json:"displayMessage"`
+
+		TextMessageDetails *struct {
+			MessageText string `json:"messageText"`
+		} `json:"textMessageDetails"`
+		SuperChatDetails *struct {
+			AmountMicros        int64  `json:"amountMicros,string"`
+			Currency            string `json:"currency"`
+			AmountDisplayString string `json:"amountDisplayString"`
+			UserComment         string `json:"userComment"`
+			Tier                int    `json:"tier"`
+		} `json:"superChatDetails"`
+		SuperStickerDetails *struct {
+			SuperStickerMetadata struct {
+				StickerID string `json:"stickerId"`
+				AltText   string `json:"altText"`
+			} `json:"superStickerMetadata"`
+			AmountMicros        int64  `json:"amountMicros,string"`
+			Currency            string `json:"currency"`
+			AmountDisplayString string `json:"amountDisplayString"`
+			Tier                int    `json:"tier"`
+		} `json:"superStickerDetails"`
+		NewSponsorDetails *struct {
+			MemberLevelName string `json:"memberLevelName"`
+			IsUpgrade       bool   `json:"isUpgrade"`
+		} `json:"newSponsorDetails"`
+		MemberMilestoneChatDetails *struct {
+			MemberLevelName string `json:"memberLevelName"`
+			MemberMonth     int    `json:"memberMonth"`
+			UserComment     string `json:"userComment"`
+		} `json:"memberMilestoneChatDetails"`
+		MembershipGiftingDetails *struct {
+			GiftMembershipsCount     int    `json:"giftMembershipsCount"`
+			GiftMembershipsLevelName string `json:"giftMembershipsLevelName"`
+		} `json:"membershipGiftingDetails"`
+		GiftMembershipReceivedDetails *struct {
+			MemberLevelName string `json:"memberLevelName"`
+			GifterChannelID string `json:"gifterChannelId"`
+		} `json:"giftMembershipReceivedDetails"`
+	} `json:"snippet"`
+	AuthorDetails struct {
+		ChannelID       string `json:"channelId"`
+		DisplayName     string `json:"displayName"`
+		IsVerified      bool   `json:"isVerified"`
+		IsChatOwner     bool   `json:"isChatOwner"`
+		IsChatSponsor   bool   `json:"isChatSponsor"`
+		IsChatModerator bool   `json:"isChatModerator"`
+	} `json:"authorDetails"`
+}
+
+// findLive returns the ID of the channel's current live broadcast, or ""
+// if it isn't live. A search costs 100 quota units.
+func (c *Connector) findLive(ctx context.Context, channelID string) (string, error) {
+	var result struct {
+		Items []struct {
+			ID struct {
+				VideoID string `json:"videoId"`
+			} `json:"id"`
+		} `json:"items"`
+	}
+	query := url.Values{
+		"part":      {"id"},
+		"channelId": {channelID},
+		"eventType": {"live"},
+		"type":      {"video"},
+	}
+	if err := c.get(ctx, "/search", query, &result); err != nil {
+		return "", err
+	}
+	if len(result.Items) == 0 {
+		return "", nil
+	}
+	return result.Items[0].ID.VideoID, nil
+}
+
+// liveChatID returns the active live chat of a video, or "" if the video
+// has no live chat (not live, ended, or chat disabled)
+func (c *Connector) liveChatID(ctx context.Context, videoID string) (string, error) {
+	var result struct {
+		Items []struct {
+			LiveStreamingDetails struct {
+				ActiveLiveChatID string `json:"activeLiveChatId"`
+			} `json:"liveStreamingDetails"`
+		} `json:"items"`
+	}
+	query := url.Values{"part": {"liveStreamingDetails"}, "id": {videoID}}
+	if err := c.get(ctx, "/videos", query, &result); err != nil {
+		return "", err
+	}
+	if len(result.Items) == 0 {
+		return "", nil
+	}
+	return result.Items[0].LiveStreamingDetails.ActiveLiveChatID, nil
+}
+
+// chatMessages fetches the live chat messages after pageToken
+func (c *Connector) chatMessages(ctx context.Context, liveChatID, pageToken string) (*chatPage, error) {
+	query := url.Values{
+		"liveChatId": {liveChatID},
+		"part":       {"snippet,authorDetails"},
+		"maxResults": {"2000"},
+	}
+	if pageToken != "" {
+		query.Set("pageToken", pageToken)
+	}
+	var page chatPage
+	if err := c.get(ctx, "/liveChat/messages", query, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// get performs a GET against the Data API
+func (c *Connector) get(ctx context.Context, path string, query url.Values, v any) error {
+	query.Set("key", c.apiKey)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiBaseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return newAPIError(resp.StatusCode, body)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("JSON decode failed: %w", err)
+	}
+	return nil
+}
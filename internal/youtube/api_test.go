@@ -0,0 +1,34 @@
+package youtube
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestQuotaErrorsAreNotChatEnd(t *testing.T) {
+	reasonBody := func(reason string) []byte {
+		return []byte(fmt.Sprintf(`{"error":{"code":403,"errors":[{"reason":%q}]}}`, reason))
+	}
+	for _, tt := range []struct {
+		name         string
+		err          error
+		quota, ended bool
+	}{
+		{"quota", newAPIError(http.StatusForbidden, reasonBody("quotaExceeded")), true, false},
+		{"rate limit", newAPIError(http.StatusForbidden, reasonBody("rateLimitExceeded")), true, false},
+		{"too many requests", newAPIError(http.StatusTooManyRequests, nil), true, false},
+		{"chat ended", newAPIError(http.StatusForbidden, reasonBody("liveChatEnded")), false, true},
+		{"no reason", newAPIError(http.StatusForbidden, []byte("forbidden")), false, true},
+		{"not found", newAPIError(http.StatusNotFound, reasonBody("liveChatNotFound")), false, true},
+		{"server error", newAPIError(http.StatusInternalServerError, nil), false, false},
+	} {
+		err := fmt.Errorf("list messages: %w", tt.err)
+		if got := quotaExceeded(err); got != tt.quota {
+			t.Errorf("%s: quotaExceeded = %v, want %v", tt.name, got, tt.quota)
+		}
+		if got := chatEnded(err); got != tt.ended {
+			t.Errorf("%s: chatEnded = %v, want %v", tt.name, got, tt.ended)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package youtube
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/john/chatlog/internal/egress"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/status"
+)
+
+const (
+	minPollInterval = 2 * time.Second
+	maxErrorBackoff = 5 * time.Minute
+	quotaBackoff    = 15 * time.Minute // Wait after running out of API quota
+)
+
+// Channel is a YouTube channel to log
+type Channel struct {
+	ID      string // Channel ID, "UC..."
+	Name    string // Recorded channel name; defaults to ID
+	VideoID string // Follow this broadcast instead of searching for the live one
+}
+
+// Connector polls the live chat of YouTube channels' broadcasts through
+// the Data API. Chat, Super Chats, Super Stickers and memberships are
+// recorded; paid events carry their amounts in Message.Paid.
+type Connector struct {
+	apiKey    string
+	channels  []Channel
+	liveCheck time.Duration
+
+	httpClient *http.Client
+
+	live map[string]string // Channel name -> video ID being followed
+	mu   sync.Mutex
+
+	status *status.Component
+}
+
+// New creates a YouTube connector. Channels that aren't live are searched
+// for a live broadcast every liveCheck.
+func New(apiKey string, channels []Channel, liveCheck time.Duration) *Connector {
+	for i := range channels {
+		if channels[i].Name == "" {
+			channels[i].Name = channels[i].ID
+		}
+	}
+	return &Connector{
+		apiKey:     apiKey,
+		channels:   channels,
+		liveCheck:  liveCheck,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		live:       make(map[string]string),
+	}
+}
+
+// EnableStatus reports polling state and message times to comp. It must
+// be called before Start.
+func (c *Connector) EnableStatus(comp *status.Component) {
+	c.status = comp
+}
+
+// EnableEgress sends Data API requests through network. It must be called
+// before Start.
+func (c *Connector) EnableEgress(network *egress.Network) {
+	c.httpClient = network.HTTPClient(10 * time.Second)
+}
+
+// Start follows each channel's live chat until the context is cancelled
+func (c *Connector) Start(ctx context.Context, messageChan chan<- message.Message) error {
+	if len(c.channels) == 0 {
+		return fmt.Errorf("no YouTube channels to follow")
+	}
+	c.status.SetState(status.StateRunning)
+
+	var wg sync.WaitGroup
+	for _, ch := range c.channels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.watch(ctx, ch, messageChan)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// watch waits for a channel to go live and records its chat, repeating
+// for each broadcast. A channel with a fixed VideoID is followed until that
+// broadcast's chat ends.
+func (c *Connector) watch(ctx context.Context, ch Channel, messageChan chan<- message.Message) {
+	for {
+		videoID := ch.VideoID
+		var err error
+		if videoID == "" {
+			videoID, err = c.findLive(ctx, ch.ID)
+		}
+
+		var chatID string
+		if err == nil && videoID != "" {
+			chatID, err = c.liveChatID(ctx, videoID)
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Printf("Warning: Failed to find YouTube live chat for %s: %v", ch.Name, err)
+		case chatID != "":
+			log.Printf("Following YouTube live chat for %s (video %s)", ch.Name, videoID)
+			c.setLive(ch.Name, videoID)
+			err := c.poll(ctx, ch, chatID, messageChan)
+			c.setLive(ch.Name, "")
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("YouTube live chat for %s ended: %v", ch.Name, err)
+		}
+
+		if ch.VideoID != "" && chatID != "" {
+			return // The broadcast is over
+		}
+
+		select {
+		case <-time.After(c.liveCheck):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll records a live chat until it ends, waiting between pages as long as
+// the API asks
+func (c *Connector) poll(ctx context.Context, ch Channel, chatID string, messageChan chan<- message.Message) error {
+	pageToken := ""
+	backoff := minPollInterval
+	for {
+		page, err := c.chatMessages(ctx, chatID, pageToken)
+		if err != nil {
+			if ctx.Err() != nil || chatEnded(err) {
+				return err
+			}
+			wait := backoff
+			if quotaExceeded(err) {
+				// Quota comes back with time; the chat may well still be live
+				wait = quotaBackoff
+			}
+			log.Printf("Warning: YouTube live chat poll failed for %s: %v. Retrying in %v", ch.Name, err, wait)
+			c.status.SetState(status.StateDegraded)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff = min(backoff*2, maxErrorBackoff)
+			continue
+		}
+		backoff = minPollInterval
+		c.status.SetState(status.StateRunning)
+
+		for _, item := range page.Items {
+			chatMessage, ok := convertMessage(ch.Name, item)
+			if !ok {
+				continue
+			}
+			c.status.MessageReceived()
+			select {
+			case messageChan <- chatMessage:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if page.OfflineAt != "" {
+			return fmt.Errorf("stream went offline at %s", page.OfflineAt)
+		}
+		pageToken = page.NextPageToken
+
+		wait := max(time.Duration(page.PollingIntervalMillis)*time.Millisecond, minPollInterval)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setLive records which broadcast a channel is following, or clears it
+func (c *Connector) setLive(name, videoID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if videoID == "" {
+		delete(c.live, name)
+	} else {
+		c.live[name] = videoID
+	}
+	live := make(map[string]string, len(c.live))
+	for k, v := range c.live {
+		live[k] = v
+	}
+	c.status.Set("live_videos", live)
+}
+
+// convertMessage converts a live chat message to our format. Paid events
+// become typed records with their amounts in Paid; other event types
+// (deletions, bans, polls) are skipped.
+func convertMessage(channel string, item chatMessage) (message.Message, bool) {
+	snippet := item.Snippet
+	msg := message.New("youtube", snippet.PublishedAt)
+	msg.Channel = channel
+	msg.ID = item.ID
+	msg.Username = item.AuthorDetails.DisplayName
+	msg.UserID = item.AuthorDetails.ChannelID
+	msg.Badges = formatBadges(item)
+
+	switch snippet.Type {
+	case "textMessageEvent":
+		if snippet.TextMessageDetails != nil {
+			msg.Message = snippet.TextMessageDetails.MessageText
+		} else {
+			msg.Message = snippet.DisplayMessage
+		}
+
+	case "superChatEvent":
+		details := snippet.SuperChatDetails
+		if details == nil {
+			return msg, false
+		}
+		msg.Type = message.TypeSuperChat
+		msg.Message = details.UserComment
+		msg.Paid = &message.Paid{
+			AmountMicros: details.AmountMicros,
+			Currency:     details.Currency,
+			Display:      details.AmountDisplayString,
+			Tier:         details.Tier,
+		}
+
+	case "superStickerEvent":
+		details := snippet.SuperStickerDetails
+		if details == nil {
+			return msg, false
+		}
+		msg.Type = message.TypeSuperSticker
+		msg.Paid = &message.Paid{
+			AmountMicros: details.AmountMicros,
+			Currency:     details.Currency,
+			Display:      details.AmountDisplayString,
+			Tier:         details.Tier,
+			StickerID:    details.SuperStickerMetadata.StickerID,
+			StickerAlt:   details.SuperStickerMetadata.AltText,
+		}
+
+	case "newSponsorEvent":
+		msg.Type = message.TypeMembership
+		msg.Paid = &message.Paid{}
+		if details := snippet.NewSponsorDetails; details != nil {
+			msg.Paid.Level = details.MemberLevelName
+			msg.Paid.Upgrade = details.IsUpgrade
+		}
+
+	case "memberMilestoneChatEvent":
+		details := snippet.MemberMilestoneChatDetails
+		if details == nil {
+			return msg, false
+		}
+		msg.Type = message.TypeMilestone
+		msg.Message = details.UserComment
+		msg.Paid = &message.Paid{Level: details.MemberLevelName, Months: details.MemberMonth}
+
+	case "membershipGiftingEvent":
+		details := snippet.MembershipGiftingDetails
+		if details == nil {
+			return msg, false
+		}
+		msg.Type = message.TypeMemberGift
+		msg.Paid = &message.Paid{Level: details.GiftMembershipsLevelName, Gifts: details.GiftMembershipsCount}
+
+	case "giftMembershipReceivedEvent":
+		details := snippet.GiftMembershipReceivedDetails
+		if details == nil {
+			return msg, false
+		}
+		msg.Type = message.TypeGiftReceived
+		msg.Paid = &message.Paid{Level: details.MemberLevelName, GifterID: details.GifterChannelID}
+
+	default:
+		return msg, false
+	}
+	return msg, true
+}
+
+// formatBadges lists the author's roles as a comma-separated string
+func formatBadges(item chatMessage) string {
+	var parts []string
+	author := item.AuthorDetails
+	if author.IsChatOwner {
+		parts = append(parts, "owner")
+	}
+	if author.IsChatModerator {
+		parts = append(parts, "moderator")
+	}
+	if author.IsChatSponsor {
+		parts = append(parts, "member")
+	}
+	if author.IsVerified {
+		parts = append(parts, "verified")
+	}
+	return strings.Join(parts, ",")
+}
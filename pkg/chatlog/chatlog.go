@@ -0,0 +1,115 @@
+// Package chatlog builds chatlog into other Go programs. A program
+// registers its own connectors, sinks and enrichment processors, then hands
+// control to Main, which runs the full chatlog binary (every built-in
+// platform, the recorder, uploads, subcommands) with those extensions
+// available to the config:
+//
+//	func init() {
+//		chatlog.RegisterConnector("acme", func(options map[string]any) (chatlog.Connector, error) {
+//			return newAcmeConnector(options)
+//		})
+//	}
+//
+//	func main() {
+//		chatlog.Main("v1.0.0")
+//	}
+//
+// and in config.yaml:
+//
+//	connectors:
+//	  - name: acme
+//	    options:
+//	      channels: [lobby]
+//
+// Sinks are enabled under sinks.custom and processors under
+// enrich.processors in the same way. Registrations must happen before Main
+// is called, typically in init functions.
+package chatlog
+
+import (
+	"time"
+
+	"github.com/john/chatlog/internal/app"
+	"github.com/john/chatlog/internal/enrich"
+	"github.com/john/chatlog/internal/message"
+	"github.com/john/chatlog/internal/platform"
+	"github.com/john/chatlog/internal/sink"
+)
+
+// Message is one record: a chat message, or another record type named by
+// its Type. It is written to files as a JSON line.
+type Message = message.Message
+
+// Types of the optional parts of a Message
+type (
+	Reply      = message.Reply
+	EmoteRef   = message.EmoteRef
+	Paid       = message.Paid
+	Cheermote  = message.Cheermote
+	Aggregate  = message.Aggregate
+	Stream     = message.Stream
+	Summary    = message.Summary
+	EmoteCount = message.EmoteCount
+	RoomState  = message.RoomState
+)
+
+// TimestampFormat is the layout of Message timestamps
+const TimestampFormat = message.TimestampFormat
+
+// NewMessage creates a message stamped with its receive time and sequence
+// number, as the built-in connectors do. If the platform did not report a
+// send time, pass the zero time and the receive time is used.
+func NewMessage(platform string, platformTime time.Time) Message {
+	return message.New(platform, platformTime)
+}
+
+// Connector reads chat from a platform. Start sends messages until the
+// context is cancelled, reconnecting as needed, and then returns
+// ctx.Err(). Messages must set Platform and Channel, which name the file
+// they are recorded in.
+type Connector = platform.Connector
+
+// ConnectorFactory creates a connector from its config options
+type ConnectorFactory = platform.Factory
+
+// RegisterConnector makes a connector available to the connectors config
+// under name. Registering a name twice panics.
+func RegisterConnector(name string, factory ConnectorFactory) {
+	platform.Register(name, factory)
+}
+
+// Sink receives a copy of every recorded message. Start consumes messages
+// until the context is cancelled. Each sink has its own buffer
+// (sinks.buffer_size); a sink that falls behind has messages dropped
+// rather than slowing recording.
+type Sink = sink.Sink
+
+// SinkFactory creates a sink from its config options
+type SinkFactory = sink.Factory
+
+// RegisterSink makes a sink available to the sinks.custom config under
+// name. Registering a name twice panics.
+func RegisterSink(name string, factory SinkFactory) {
+	sink.Register(name, factory)
+}
+
+// Processor adds fields to chat messages, typically with msg.Enrich. It is
+// called for every chat message in order from a single goroutine.
+type Processor = enrich.Processor
+
+// ProcessorFactory creates a processor from its config options
+type ProcessorFactory = enrich.Factory
+
+// RegisterProcessor makes a processor available to the enrich.processors
+// config under name. Registering a name twice panics.
+func RegisterProcessor(name string, factory ProcessorFactory) {
+	enrich.Register(name, factory)
+}
+
+// Main runs chatlog as its own binary does: the recording pipeline
+// configured by CONFIG_PATH, or the subcommand named by os.Args[1]. version
+// is reported in logs and on /health. It returns once the pipeline has shut
+// down after SIGINT or SIGTERM.
+func Main(version string) {
+	app.Main(version)
+}
@@ -5,28 +5,60 @@ resource "aws_iam_policy" "s3_chatlog_access" {
 
   policy = jsonencode({
     Version = "2012-10-17"
-    Statement = [
-      {
-        Sid    = "ListBucket"
-        Effect = "Allow"
-        Action = [
-          "s3:ListBucket",
-          "s3:GetBucketLocation"
-        ]
-        Resource = aws_s3_bucket.chatlog_archive.arn
-      },
-      {
-        Sid    = "ReadWriteObjects"
-        Effect = "Allow"
-        Action = [
-          "s3:PutObject",
-          "s3:GetObject",
-          "s3:DeleteObject",
-          "s3:GetObjectVersion"
-        ]
-        Resource = "${aws_s3_bucket.chatlog_archive.arn}/*"
-      }
-    ]
+    Statement = concat(
+      [
+        {
+          Sid    = "ListBucket"
+          Effect = "Allow"
+          Action = [
+            "s3:ListBucket",
+            "s3:GetBucketLocation"
+          ]
+          Resource = aws_s3_bucket.chatlog_archive.arn
+        },
+        {
+          Sid    = "ReadWriteObjects"
+          Effect = "Allow"
+          Action = [
+            "s3:PutObject",
+            "s3:PutObjectTagging",
+            "s3:GetObject",
+            "s3:DeleteObject",
+            "s3:GetObjectVersion"
+          ]
+          Resource = "${aws_s3_bucket.chatlog_archive.arn}/*"
+        }
+      ],
+      # SSE-KMS uploads need a data key, and reading them back decrypts it
+      var.kms_key_arn == "" ? [] : [
+        {
+          Sid    = "UseKMSKey"
+          Effect = "Allow"
+          Action = [
+            "kms:GenerateDataKey",
+            "kms:Decrypt"
+          ]
+          Resource = var.kms_key_arn
+        }
+      ],
+      # Upload notifications (uploader.notify) use the same credentials
+      var.notify_sqs_queue_arn == "" ? [] : [
+        {
+          Sid      = "SendUploadNotifications"
+          Effect   = "Allow"
+          Action   = "sqs:SendMessage"
+          Resource = var.notify_sqs_queue_arn
+        }
+      ],
+      var.notify_sns_topic_arn == "" ? [] : [
+        {
+          Sid      = "PublishUploadNotifications"
+          Effect   = "Allow"
+          Action   = "sns:Publish"
+          Resource = var.notify_sns_topic_arn
+        }
+      ]
+    )
   })
 
   tags = {
@@ -45,3 +45,21 @@ variable "enable_s3_versioning" {
   type        = bool
   default     = true
 }
+
+variable "kms_key_arn" {
+  description = "KMS key ARN used for s3.kms_key_id with aws:kms encryption (empty = not used)"
+  type        = string
+  default     = ""
+}
+
+variable "notify_sqs_queue_arn" {
+  description = "ARN of the SQS queue set as uploader.notify.sqs_queue_url (empty = not used)"
+  type        = string
+  default     = ""
+}
+
+variable "notify_sns_topic_arn" {
+  description = "ARN of the SNS topic set as uploader.notify.sns_topic_arn (empty = not used)"
+  type        = string
+  default     = ""
+}